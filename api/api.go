@@ -0,0 +1,187 @@
+// Package api serves read-only queries on the store over HTTP, e.g.
+// for the sparklines of a UI.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Store is implemented by the stores queried by the API (e.g.
+// `*store.PGStore`).
+type Store interface {
+	GetDailyEventCounts(scope store.EventCountsScope, from, to time.Time) ([]store.DailyEventCount, error)
+	Search(query string, limit int) ([]store.SearchResult, error)
+}
+
+// dayFormat is the format of the days in the parameters and
+// responses.
+const dayFormat = "2006-01-02"
+
+// Limits of the `days` parameter of `/event-counts`.
+const (
+	defaultDays = 30
+	maxDays     = 366
+)
+
+// Limits of the `limit` parameter of `/search`.
+const (
+	DefaultSearchLimit = 20
+	maxSearchLimit     = 200
+)
+
+// SearchResult is an item of the response of `/search`.
+type SearchResult struct {
+	IssueKey string `json:"issue_key"`
+	Summary  string `json:"summary"`
+
+	// CommentID is the ID of the matching comment, omitted if the
+	// summary or description of the issue matches.
+	CommentID *string `json:"comment_id,omitempty"`
+
+	Rank     float64 `json:"rank"`
+	Headline string  `json:"headline"`
+}
+
+// EventCounts is the response of `/event-counts`.
+type EventCounts struct {
+	// From and To are the first and last days of the counts.
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Kinds are the number of events of each kind per day, from
+	// `From` to `To`, including the days without events. Kinds
+	// without events are omitted.
+	Kinds map[store.EventKind][]int `json:"kinds"`
+}
+
+// Handler returns an `http.Handler` exposing the API:
+//
+//	GET /event-counts?project=<name>|epic=<key>[&days=<n>][&to=<day>]
+//
+// returns the `EventCounts` of the issues of the project or epic for
+// the last `days` days (defaults to 30, 366 at most) until `to`
+// (e.g. `2020-03-31`, defaults to today).
+//
+//	GET /search?q=<query>[&limit=<n>]
+//
+// returns the `SearchResult`s of the issues and comments matching the
+// query (see `store.PGStore.Search`), by decreasing relevance,
+// `limit` at most (defaults to 20, 200 at most).
+//
+// If the store is a `GrafanaStore`, the time series of the metrics
+// are exposed to Grafana under `/grafana/` (see `GrafanaHandler`).
+func Handler(s Store) http.Handler {
+	mux := http.NewServeMux()
+	if gs, ok := s.(GrafanaStore); ok {
+		mux.Handle("/grafana/", http.StripPrefix("/grafana", GrafanaHandler(gs)))
+	}
+	mux.HandleFunc("/event-counts", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		scope := store.EventCountsScope{Project: q.Get("project"), Epic: q.Get("epic")}
+		if (scope.Project == "") == (scope.Epic == "") {
+			http.Error(w, "either `project` or `epic` is required", http.StatusBadRequest)
+			return
+		}
+		days := defaultDays
+		if v := q.Get("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxDays {
+				http.Error(w, "invalid `days`", http.StatusBadRequest)
+				return
+			}
+			days = n
+		}
+		to := time.Now()
+		if v := q.Get("to"); v != "" {
+			t, err := time.Parse(dayFormat, v)
+			if err != nil {
+				http.Error(w, "invalid `to`", http.StatusBadRequest)
+				return
+			}
+			to = t
+		}
+		to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+		from := to.AddDate(0, 0, 1-days)
+
+		counts, err := s.GetDailyEventCounts(scope, from, to.AddDate(0, 0, 1))
+		if err != nil {
+			logging.Errorf("Error counting events: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(eventCounts(counts, from, days)); err != nil {
+			logging.Errorf("Error writing response: %s", err)
+		}
+	})
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		query := q.Get("q")
+		if query == "" {
+			http.Error(w, "`q` is required", http.StatusBadRequest)
+			return
+		}
+		limit := DefaultSearchLimit
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxSearchLimit {
+				http.Error(w, "invalid `limit`", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		rs, err := s.Search(query, limit)
+		if err != nil {
+			logging.Errorf("Error searching: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		results := make([]SearchResult, len(rs))
+		for i, r := range rs {
+			results[i] = SearchResult{IssueKey: r.IssueKey, Summary: r.Summary, CommentID: r.CommentID, Rank: r.Rank, Headline: r.Headline}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(results); err != nil {
+			logging.Errorf("Error writing response: %s", err)
+		}
+	})
+	return mux
+}
+
+// eventCounts returns the `EventCounts` of the `days` days from
+// `from`.
+func eventCounts(counts []store.DailyEventCount, from time.Time, days int) EventCounts {
+	ec := EventCounts{
+		From:  from.Format(dayFormat),
+		To:    from.AddDate(0, 0, days-1).Format(dayFormat),
+		Kinds: make(map[store.EventKind][]int),
+	}
+	for _, c := range counts {
+		i := int(time.Date(c.Day.Year(), c.Day.Month(), c.Day.Day(), 0, 0, 0, 0, time.UTC).Sub(from).Hours() / 24)
+		if i < 0 || i >= days {
+			continue
+		}
+		if ec.Kinds[c.Kind] == nil {
+			ec.Kinds[c.Kind] = make([]int, days)
+		}
+		ec.Kinds[c.Kind][i] += c.Count
+	}
+	return ec
+}
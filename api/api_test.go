@@ -0,0 +1,211 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/api"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+type storeMock struct {
+	scope    store.EventCountsScope
+	from, to time.Time
+	counts   []store.DailyEventCount
+
+	query   string
+	limit   int
+	results []store.SearchResult
+}
+
+func (s *storeMock) GetDailyEventCounts(scope store.EventCountsScope, from, to time.Time) ([]store.DailyEventCount, error) {
+	s.scope, s.from, s.to = scope, from, to
+	return s.counts, nil
+}
+
+func (s *storeMock) Search(query string, limit int) ([]store.SearchResult, error) {
+	s.query, s.limit = query, limit
+	return s.results, nil
+}
+
+func TestHandler_EventCounts(t *testing.T) {
+	s := &storeMock{counts: []store.DailyEventCount{
+		{Day: time.Date(2020, 3, 29, 0, 0, 0, 0, time.UTC), Kind: store.EventCreated, Count: 2},
+		{Day: time.Date(2020, 3, 31, 0, 0, 0, 0, time.UTC), Kind: store.EventCreated, Count: 1},
+		{Day: time.Date(2020, 3, 31, 0, 0, 0, 0, time.UTC), Kind: store.EventStatusChanged, Count: 4},
+	}}
+	srv := httptest.NewServer(api.Handler(s))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/event-counts?epic=PJ-10&days=3&to=2020-03-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if s.scope.Epic != "PJ-10" || !s.from.Equal(time.Date(2020, 3, 29, 0, 0, 0, 0, time.UTC)) || !s.to.Equal(time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the events of epic PJ-10 from 2020-03-29 to 2020-04-01 to be counted, got %v from %s to %s", s.scope, s.from, s.to)
+	}
+
+	var ec api.EventCounts
+	if err = json.NewDecoder(res.Body).Decode(&ec); err != nil {
+		t.Fatal(err)
+	}
+	if ec.From != "2020-03-29" || ec.To != "2020-03-31" {
+		t.Errorf("expected the counts from 2020-03-29 to 2020-03-31, got %s to %s", ec.From, ec.To)
+	}
+	// Days without events are included
+	created := ec.Kinds[store.EventCreated]
+	if len(created) != 3 || created[0] != 2 || created[1] != 0 || created[2] != 1 {
+		t.Errorf("expected [2 0 1] created events, got %v", created)
+	}
+	if changed := ec.Kinds[store.EventStatusChanged]; len(changed) != 3 || changed[2] != 4 {
+		t.Errorf("expected [0 0 4] status changes, got %v", changed)
+	}
+
+	for _, q := range []string{"", "?project=PJ&epic=PJ-10", "?project=PJ&days=0", "?project=PJ&to=31/03/2020"} {
+		res, err := http.Get(srv.URL + "/event-counts" + q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status %d for `%s`, got %d", http.StatusBadRequest, q, res.StatusCode)
+		}
+	}
+}
+
+func TestHandler_Search(t *testing.T) {
+	commentID := "10100"
+	s := &storeMock{results: []store.SearchResult{
+		{IssueKey: "PJ-1", Summary: "Payment failed", Rank: 0.6, Headline: "**Payment** **failed**"},
+		{IssueKey: "PJ-2", Summary: "Checkout", CommentID: &commentID, Rank: 0.3, Headline: "the **payment** **failed** again"},
+	}}
+	srv := httptest.NewServer(api.Handler(s))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/search?q=payment+failed&limit=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if s.query != "payment failed" || s.limit != 5 {
+		t.Errorf("expected 5 results for `payment failed` to be searched, got %d for `%s`", s.limit, s.query)
+	}
+	var rs []api.SearchResult
+	if err = json.NewDecoder(res.Body).Decode(&rs); err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 || rs[0].IssueKey != "PJ-1" || rs[0].CommentID != nil || rs[1].CommentID == nil || *rs[1].CommentID != "10100" {
+		t.Errorf("expected the issue PJ-1 and the comment 10100 of PJ-2, got %v", rs)
+	}
+
+	for _, q := range []string{"", "?q=payment&limit=0", "?q=payment&limit=1000"} {
+		res, err := http.Get(srv.URL + "/search" + q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status %d for `%s`, got %d", http.StatusBadRequest, q, res.StatusCode)
+		}
+	}
+}
+
+// grafanaStoreMock is a `storeMock` returning time series.
+type grafanaStoreMock struct {
+	storeMock
+	seriesScope store.TimeSeriesScope
+	percentile  float64
+	points      []store.TimeSeriesPoint
+}
+
+func (s *grafanaStoreMock) GetWeeklyThroughput(scope store.TimeSeriesScope, from, to time.Time) ([]store.TimeSeriesPoint, error) {
+	s.seriesScope, s.from, s.to = scope, from, to
+	return s.points, nil
+}
+
+func (s *grafanaStoreMock) GetWeeklyCycleTime(scope store.TimeSeriesScope, percentile float64, from, to time.Time) ([]store.TimeSeriesPoint, error) {
+	s.seriesScope, s.percentile, s.from, s.to = scope, percentile, from, to
+	return s.points, nil
+}
+
+func (s *grafanaStoreMock) GetDailyWIP(scope store.TimeSeriesScope, from, to time.Time) ([]store.TimeSeriesPoint, error) {
+	s.seriesScope, s.from, s.to = scope, from, to
+	return s.points, nil
+}
+
+func TestHandler_Grafana(t *testing.T) {
+	week := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	s := &grafanaStoreMock{points: []store.TimeSeriesPoint{{Time: week, Value: 2.5}}}
+	srv := httptest.NewServer(api.Handler(s))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/grafana/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected the test of the datasource to succeed, got status %d", res.StatusCode)
+	}
+
+	body := `{
+		"range": {"from": "2020-03-01T00:00:00Z", "to": "2020-03-31T00:00:00Z"},
+		"targets": [{"target": "cycle_time_p85", "refId": "A", "payload": {"project": "Payments"}}]
+	}`
+	res, err = http.Post(srv.URL+"/grafana/query", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if s.seriesScope.Project != "Payments" || s.percentile != 0.85 || !s.from.Equal(time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the 85th percentile of Payments from 2020-03-01, got %v, %f from %s", s.seriesScope, s.percentile, s.from)
+	}
+	var series []api.GrafanaSeries
+	if err = json.NewDecoder(res.Body).Decode(&series); err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || series[0].Target != "cycle_time_p85" || len(series[0].Datapoints) != 1 || series[0].Datapoints[0] != [2]float64{2.5, 1583107200000} {
+		t.Errorf("expected a datapoint of 2.5 at 1583107200000, got %v", series)
+	}
+
+	res, err = http.Get(srv.URL + "/grafana/series?target=wip&type=Bug&from=2020-03-01&to=2020-03-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var points []api.GrafanaPoint
+	if err = json.NewDecoder(res.Body).Decode(&points); err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || !points[0].Time.Equal(week) || points[0].Value != 2.5 {
+		t.Errorf("expected a point of 2.5 on %s, got %v", week, points)
+	}
+	if s.seriesScope.Type != "Bug" || !s.to.Equal(time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the WIP of the bugs until 2020-03-31 included, got %v until %s", s.seriesScope, s.to)
+	}
+
+	for _, q := range []string{"?target=velocity", "?target=wip&from=01/03/2020"} {
+		res, err := http.Get(srv.URL + "/grafana/series" + q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status %d for `%s`, got %d", http.StatusBadRequest, q, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// GrafanaStore is the interface of the store used by the Grafana
+// endpoints (see `GrafanaHandler`). It's implemented by
+// `store.PGStore`.
+type GrafanaStore interface {
+	GetWeeklyThroughput(scope store.TimeSeriesScope, from, to time.Time) ([]store.TimeSeriesPoint, error)
+	GetWeeklyCycleTime(scope store.TimeSeriesScope, percentile float64, from, to time.Time) ([]store.TimeSeriesPoint, error)
+	GetDailyWIP(scope store.TimeSeriesScope, from, to time.Time) ([]store.TimeSeriesPoint, error)
+}
+
+// The time series exposed to Grafana, by target name. The cycle
+// times are in days.
+const (
+	TargetThroughput   = "throughput"
+	TargetCycleTimeP50 = "cycle_time_p50"
+	TargetCycleTimeP85 = "cycle_time_p85"
+	TargetCycleTimeP95 = "cycle_time_p95"
+	TargetWIP          = "wip"
+)
+
+// GrafanaTargets are the names of the time series exposed to
+// Grafana.
+var GrafanaTargets = []string{TargetThroughput, TargetCycleTimeP50, TargetCycleTimeP85, TargetCycleTimeP95, TargetWIP}
+
+// defaultGrafanaRange is the range of the time series of
+// `/grafana/series` if `from` is not set.
+const defaultGrafanaRange = 90 * 24 * time.Hour
+
+// GrafanaQuery is the body of `/grafana/query`, as sent by the JSON
+// datasource of Grafana.
+type GrafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []GrafanaTarget `json:"targets"`
+}
+
+// GrafanaTarget is a time series requested by a Grafana panel, with
+// the project and type of the issues in its payload.
+type GrafanaTarget struct {
+	Target  string                `json:"target"`
+	RefID   string                `json:"refId"`
+	Payload store.TimeSeriesScope `json:"payload"`
+}
+
+// GrafanaSeries is a time series of the response of
+// `/grafana/query`. The datapoints are values and times in
+// milliseconds since the epoch.
+type GrafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// GrafanaPoint is a point of the response of `/grafana/series`.
+type GrafanaPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// GrafanaHandler returns an `http.Handler` exposing the time series
+// of the store (see `GrafanaTargets`) to Grafana, without setting up
+// an SQL datasource, to be served under `/grafana`. For the JSON
+// datasource (with `/grafana` as URL):
+//
+//	GET /
+//
+// responds 200 to the test of the datasource,
+//
+//	POST /search, POST /metrics
+//
+// return the names of the time series, and
+//
+//	POST /query
+//
+// returns the requested time series (see `GrafanaQuery`) over the
+// range of the dashboard. The project and type of the issues can be
+// set in the payload of each target (e.g. `{"project": "Payments"}`).
+// For the Infinity datasource, or any client of JSON APIs:
+//
+//	GET /series?target=<name>[&project=<name>][&type=<name>][&from=<day>][&to=<day>]
+//
+// returns the points of the time series (see `GrafanaPoint`) from
+// `from` (defaults to 90 days ago) until `to` (e.g. `2020-03-31`,
+// included, defaults to today).
+func GrafanaHandler(s GrafanaStore) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, GrafanaTargets)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		metrics := make([]map[string]string, len(GrafanaTargets))
+		for i, t := range GrafanaTargets {
+			metrics[i] = map[string]string{"label": t, "value": t}
+		}
+		writeJSON(w, metrics)
+	})
+	mux.HandleFunc("/query", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var q GrafanaQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			http.Error(w, "invalid query", http.StatusBadRequest)
+			return
+		}
+		series := make([]GrafanaSeries, 0, len(q.Targets))
+		for _, t := range q.Targets {
+			points, err := timeSeries(s, t.Target, t.Payload, q.Range.From, q.Range.To)
+			if err == errUnknownTarget {
+				http.Error(w, "unknown target `"+t.Target+"`", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				logging.Errorf("Error querying the time series `%s`: %s", t.Target, err)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			gs := GrafanaSeries{Target: t.Target, Datapoints: make([][2]float64, len(points))}
+			for i, p := range points {
+				gs.Datapoints[i] = [2]float64{p.Value, float64(p.Time.UnixNano() / int64(time.Millisecond))}
+			}
+			series = append(series, gs)
+		}
+		writeJSON(w, series)
+	})
+	mux.HandleFunc("/series", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		to := time.Now()
+		if v := q.Get("to"); v != "" {
+			t, err := time.Parse(dayFormat, v)
+			if err != nil {
+				http.Error(w, "invalid `to`", http.StatusBadRequest)
+				return
+			}
+			to = t
+		}
+		to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+		from := to.Add(-defaultGrafanaRange)
+		if v := q.Get("from"); v != "" {
+			t, err := time.Parse(dayFormat, v)
+			if err != nil {
+				http.Error(w, "invalid `from`", http.StatusBadRequest)
+				return
+			}
+			from = t
+		}
+		scope := store.TimeSeriesScope{Project: q.Get("project"), Type: q.Get("type")}
+		points, err := timeSeries(s, q.Get("target"), scope, from, to)
+		if err == errUnknownTarget {
+			http.Error(w, "unknown `target`", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logging.Errorf("Error querying the time series `%s`: %s", q.Get("target"), err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		gps := make([]GrafanaPoint, len(points))
+		for i, p := range points {
+			gps[i] = GrafanaPoint{Time: p.Time, Value: p.Value}
+		}
+		writeJSON(w, gps)
+	})
+	return mux
+}
+
+// errUnknownTarget is returned by `timeSeries` for a target which
+// is not one of `GrafanaTargets`.
+var errUnknownTarget = errors.New("unknown target")
+
+// timeSeries returns the points of the time series of the target in
+// [from, to).
+func timeSeries(s GrafanaStore, target string, scope store.TimeSeriesScope, from, to time.Time) ([]store.TimeSeriesPoint, error) {
+	switch target {
+	case TargetThroughput:
+		return s.GetWeeklyThroughput(scope, from, to)
+	case TargetCycleTimeP50:
+		return s.GetWeeklyCycleTime(scope, 0.5, from, to)
+	case TargetCycleTimeP85:
+		return s.GetWeeklyCycleTime(scope, 0.85, from, to)
+	case TargetCycleTimeP95:
+		return s.GetWeeklyCycleTime(scope, 0.95, from, to)
+	case TargetWIP:
+		return s.GetDailyWIP(scope, from, to)
+	}
+	return nil, errUnknownTarget
+}
+
+// writeJSON writes the value as the JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Errorf("Error writing response: %s", err)
+	}
+}
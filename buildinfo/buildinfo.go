@@ -0,0 +1,58 @@
+// Package buildinfo reports the build of the application: its
+// version and commit, embedded at build time with `-ldflags` (see
+// the `Makefile`), e.g.:
+//
+//	go build -ldflags "-X github.com/rchampourlier/kaizenizer-source-jira/buildinfo.Version=v1.4.0 -X github.com/rchampourlier/kaizenizer-source-jira/buildinfo.Commit=$(git rev-parse --short HEAD)"
+//
+// along with the versions of the records it produces.
+package buildinfo
+
+import (
+	"runtime"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Version (a semantic version, e.g. "v1.4.0"), Commit and Date (of
+// the build, RFC 3339) are set at build time. Version is "dev" and
+// the others are empty for the builds which don't set them (e.g.
+// `go run`).
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info is the build of the application.
+type Info struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	Date    string `json:"date,omitempty"`
+
+	// MapperVersion and SchemaVersion are the versions of the
+	// mapping of the issues (see `mapping.Version`) and of the
+	// schema of the DB (see `store.SchemaVersion`) of the build.
+	MapperVersion string `json:"mapper_version"`
+	SchemaVersion int    `json:"schema_version"`
+
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build of the application.
+func Get() Info {
+	return Info{
+		Version:       Version,
+		Commit:        Commit,
+		Date:          Date,
+		MapperVersion: mapping.Version,
+		SchemaVersion: store.SchemaVersion,
+		GoVersion:     runtime.Version(),
+	}
+}
+
+// RunInfo returns the build as recorded with the sync runs (see
+// `store.PGStore.SetRunInfo`).
+func (i Info) RunInfo() store.RunInfo {
+	return store.RunInfo{AppVersion: i.Version, AppCommit: i.Commit, MapperVersion: i.MapperVersion}
+}
@@ -0,0 +1,23 @@
+package buildinfo_test
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/buildinfo"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+func TestGet(t *testing.T) {
+	buildinfo.Version, buildinfo.Commit = "v1.4.0", "3f2a9c1"
+	defer func() { buildinfo.Version, buildinfo.Commit = "dev", "" }()
+
+	ri := buildinfo.Get().RunInfo()
+	expected := store.RunInfo{AppVersion: "v1.4.0", AppCommit: "3f2a9c1", MapperVersion: mapping.Version}
+	if ri != expected {
+		t.Errorf("expected %+v, got %+v", expected, ri)
+	}
+	if v := buildinfo.Get().SchemaVersion; v != store.SchemaVersion {
+		t.Errorf("expected schema version %d, got %d", store.SchemaVersion, v)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/telemetry"
+)
+
+// command describes an action of the CLI: its documentation, for
+// `usage` and `--help`, its flags and the function performing it.
+// An action with several forms (e.g. `migrate up` and `migrate
+// status`) has a command for each of them.
+type command struct {
+	name string
+
+	// args are the arguments and flags of the action, e.g.
+	// `<issue-key>`. The first one is the sub-action of the form if
+	// it's a word (e.g. `up` for `migrate up`, see `sub`).
+	args string
+
+	// help is the description printed by `<action> --help`.
+	help string
+
+	// flags are the flags accepted by the form besides the global
+	// ones, without their dashes: the flags ending with `=` take a
+	// value (e.g. `max-duration=`), the others are booleans. The
+	// other flags are rejected (see `parseCommand`).
+	flags []string
+
+	// run performs the form with its positional arguments (starting
+	// with the sub-action, if any), without the DB.
+	run func(args []string)
+
+	// runStore performs the form with the store of the DB, unless
+	// `run` is set. A form with neither documents another mode of
+	// the action (e.g. `sync --output`), performed by the first form
+	// of the action.
+	runStore func(s *store.PGStore, args []string)
+}
+
+// commands are the actions of the CLI, documented in details at the
+// top of `main.go`. Set by `init`, their functions referring to the
+// usage listing them.
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "init", help: "Asks the URL and credentials of Jira, suggests the mapping of the custom fields from a sample of issues, writes the configuration file, then creates the schema and runs a trial sync.",
+			run: withoutArgs(runInitWizard)},
+		{name: "init-db", help: "Creates the schema if the DB has none, or applies the pending changes of the schema. Same as `migrate up`.",
+			runStore: withStore(migrateUp)},
+		{name: "drop-db", help: "Drops all the tables, indexes and views of the store. Same as `cleanup`.",
+			runStore: dropTables},
+		{name: "check", help: "Checks the configuration, and that Jira and the DB are reachable with the configured credentials. Exits with status 1 if a check fails.",
+			run: withoutArgs(check)},
+		{name: "support-bundle", args: "[<path>] [--logs <path1,path2>]", help: "Writes a tarball of the sanitized configuration, the logs, the sync runs, the schema version and the environment, to attach to a support request.",
+			flags: []string{"logs="},
+			run: func(args []string) {
+				path := ""
+				if len(args) > 0 {
+					path = args[0]
+				}
+				supportBundle(path, splitList(flagValue("logs")))
+			}},
+		{name: "explain-issue", args: "<issue-key>", help: "Fetches the issue from Jira like a sync does and prints its mapped state and events, without storing them.",
+			run: func(args []string) {
+				requireArgs(args, 1)
+				explainIssue(keyRenames().Normalize(args[0]))
+			}},
+		{name: "reset", args: "--force [--wait-lock]", help: "Drops the tables, creates them again and performs a full sync.",
+			flags:    []string{"force", "wait-lock"},
+			runStore: withStore(resetStore)},
+		{name: "sync", args: "[--incremental | --full [--resume]] [--max-duration <duration>] [--wait-lock]", help: "Performs an incremental sync (the default, or with `--incremental`) of the issues updated since the last successful sync, or a full sync with `--full`. With `--resume`, resumes the last full sync if it didn't finish. With `--max-duration`, stops once the duration is over.",
+			flags:    []string{"incremental", "full", "resume", "max-duration=", "wait-lock"},
+			runStore: withStore(syncStore)},
+		{name: "sync", args: "--output <dir> [--format jsonl|csv]", help: "Performs a full sync, writing the records to files in `dir` instead of the DB.",
+			flags: []string{"output=", "format="}},
+		{name: "assert", help: "Checks the assertions configured in `assertions`, like at the end of `reset` and `sync`. Exits with status 1 if an assertion of the `fail` level is violated.",
+			runStore: withStore(func(s *store.PGStore) {
+				as := assertions()
+				if len(as) == 0 {
+					logging.Warnf("No assertion configured (see `assertions`)")
+				}
+				runAssertions(s, as)
+			})},
+		{name: "sync-issue", args: "<issue-key> [--output <dir> [--format jsonl|csv]]", help: "Synchronizes the issue, or writes its records to files in `dir`.",
+			flags: []string{"output=", "format="},
+			runStore: func(s *store.PGStore, args []string) {
+				requireArgs(args, 1)
+				c, m := withSources(newAPIClient())
+				failOnSyncError("sync-issue", jira.PerformSyncForIssueKey(c, s, keyRenames().Normalize(args[0]), m))
+			}},
+		{name: "resync", args: "--where <predicate> [--wait-lock]", help: "Synchronizes again the issues whose state matches the SQL predicate, e.g. `--where \"issue_status IS NULL\"`.",
+			flags:    []string{"where=", "wait-lock"},
+			runStore: withStore(func(s *store.PGStore) { resync(s, flagValue("where")) })},
+		{name: "backfill", args: "changelogs", help: "Synchronizes again the issues whose changelog seems truncated, with their whole changelog.",
+			runStore: withStore(backfillChangelogs)},
+		{name: "backfill", args: "sprint-reports", help: "Fetches again the sprint reports of Jira for all the closed sprints.",
+			runStore: withStore(func(s *store.PGStore) {
+				if err := jira.PerformSprintReportsSync(newAPIClient(), s, true); err != nil {
+					telemetry.Fatalln(fmt.Errorf("error in `backfill sprint-reports`: %s", err))
+				}
+			})},
+		{name: "backfill", args: "sprint-goals", help: "Evaluates again the goals of all the closed sprints.",
+			runStore: withStore(func(s *store.PGStore) {
+				if err := jira.PerformSprintGoalsEvaluation(newAPIClient(), s, true); err != nil {
+					telemetry.Fatalln(fmt.Errorf("error in `backfill sprint-goals`: %s", err))
+				}
+			})},
+		{name: "verify", args: "[--sample <n> [--seed <n>] | --full] [--csv <file>]", help: "Compares the status, assignee and update time of a sample of the issues of Jira (or all of them with `--full`) with the store, and reports the drifts. Exits with status 1 if there is drift.",
+			flags:    []string{"sample=", "seed=", "full", "csv="},
+			runStore: withStore(verify)},
+		{name: "import", args: "<file> [--output <dir> [--format jsonl|csv]]", help: "Imports the raw issues of the JSON file (`-` for the standard input), or writes their records to files in `dir`.",
+			flags:    []string{"output=", "format="},
+			runStore: func(s *store.PGStore, args []string) { importIssues(s, args) }},
+		{name: "explore-raw-issue", args: "<issue-key>", help: "Displays the raw issue as fetched from Jira.",
+			run: func(args []string) {
+				requireArgs(args, 1)
+				if err := newAPIClient().ExploreRawIssue(args[0]); err != nil {
+					telemetry.Fatalln(fmt.Errorf("error in `explore-raw-issue`: %s", err))
+				}
+			}},
+		{name: "explore-custom-fields", args: "<issue-key>", help: "Displays the custom fields of the issue.",
+			run: func(args []string) {
+				requireArgs(args, 1)
+				if err := newAPIClient().ExploreCustomFields(args[0]); err != nil {
+					telemetry.Fatalln(fmt.Errorf("error in `explore-custom-fields`: %s", err))
+				}
+			}},
+		{name: "cleanup", help: "Drops all the tables, indexes and views of the store.",
+			runStore: dropTables},
+		{name: "gc", args: "events [--relink] [--dry-run]", help: "Deletes the events whose issue is not stored anymore, or moves them to the current key of the issue with `--relink`.",
+			flags:    []string{"relink", "dry-run"},
+			runStore: withStore(collectOrphanedEvents)},
+		{name: "erase", args: "person-data --account <id>", help: "Anonymizes the records referencing a user, for GDPR erasure requests.",
+			flags: []string{"account="},
+			runStore: withStore(func(s *store.PGStore) {
+				account := flagValue("account")
+				if account == "" {
+					usage()
+				}
+				erasePersonData(s, account)
+			})},
+		{name: "analyze", args: "[--full]", help: "Computes the metrics of the issues changed since the last computation into `jira_issue_metrics`, or of all the issues with `--full`.",
+			flags:    []string{"full"},
+			runStore: withStore(func(s *store.PGStore) { analyze(s, boolFlag("full")) })},
+		{name: "projections", args: "list", help: "Lists the projections and their tables.",
+			runStore: runProjectionsAction},
+		{name: "projections", args: "rebuild [<name>...]", help: "Rebuilds the projections with the names, or all of them.",
+			runStore: runProjectionsAction},
+		{name: "load-teams", help: "Loads the teams file (`TEAMS_PATH`) into `team_memberships`.",
+			runStore: withStore(loadTeams)},
+		{name: "daemon", help: "Performs an incremental sync every `SYNC_INTERVAL`.",
+			runStore: withStore(runDaemonSyncs)},
+		{name: "webhooks", help: "Receives the events of Jira webhooks and synchronizes the issues. Also named `serve`.",
+			runStore: withStore(receiveWebhooks)},
+		{name: "webhooks", args: "register --url <url> [--name <name>] [--jql <query>]", help: "Creates or updates the Jira webhook posting to the receiver.",
+			flags: []string{"url=", "name=", "jql="},
+			run:   withoutArgs(registerWebhook)},
+		{name: "realtime", help: "Combines `webhooks` and `daemon`, reconciling every `RECONCILE_INTERVAL`.",
+			runStore: withStore(runRealtime)},
+		{name: "api", help: "Serves read-only queries on `API_ADDR`.",
+			runStore: withStore(func(s *store.PGStore) { withReadStore(s, runAPI) })},
+		{name: "report", args: "cycles", help: "Lists the circular blocking dependencies between issues.",
+			runStore: runReportAction},
+		{name: "report", args: "cycle-time --explain <issue-key>", help: "Explains how the cycle time of the issue is computed.",
+			flags:    []string{"explain="},
+			runStore: runReportAction},
+		{name: "report", args: "capacity --team <team> [--weeks <n>]", help: "Prints the WIP, throughput and load of each member of the team per week as CSV.",
+			flags:    []string{"team=", "weeks="},
+			runStore: runReportAction},
+		{name: "report", args: "duplicates [--threshold <n>] [--cross-project]", help: "Prints the pairs of open issues with similar summaries or linked as duplicates as CSV.",
+			flags:    []string{"threshold=", "cross-project"},
+			runStore: runReportAction},
+		{name: "report", args: "sprints", help: "Prints the figures of the sprint reports of Jira next to those computed from the records as CSV.",
+			runStore: runReportAction},
+		{name: "report", args: "carryover --board <id> [--format csv|json]", help: "Prints the issues and points carried over from each closed sprint of the board to the next one.",
+			flags:    []string{"board=", "format="},
+			runStore: runReportAction},
+		{name: "comments", args: "reveal <issue-key>", help: "Prints the comments of the issue stored in the comment vault.",
+			runStore: func(s *store.PGStore, args []string) {
+				requireArgs(args, 2)
+				revealComments(s, keyRenames().Normalize(args[1]))
+			}},
+		{name: "search", args: "[--limit <n>] <query>", help: "Prints the issues and comments matching the query (requires `db.full_text_search`).",
+			runStore: func(s *store.PGStore, args []string) {
+				withReadStore(s, func(rs *store.PGStore) { search(rs, args) })
+			}},
+		{name: "export", args: "demo <dir>", help: "Exports an obfuscated copy of the records to CSV files in `dir`.",
+			runStore: func(s *store.PGStore, args []string) {
+				requireArgs(args, 2)
+				withReadStore(s, func(rs *store.PGStore) { exportDemo(rs, args[1]) })
+			}},
+		{name: "export", args: "snapshot <path> [--format sqlite] [--anonymize]", help: "Writes a snapshot of the issue states and metrics to a SQLite DB file.",
+			flags: []string{"format=", "anonymize"},
+			runStore: func(s *store.PGStore, args []string) {
+				requireArgs(args, 2)
+				withReadStore(s, func(rs *store.PGStore) { exportSnapshot(rs, args[1], flagValue("format"), boolFlag("anonymize")) })
+			}},
+		{name: "export", args: "ml <path> [--anonymize]", help: "Writes a feature vector per issue to a Parquet file for machine learning.",
+			flags: []string{"anonymize"},
+			runStore: func(s *store.PGStore, args []string) {
+				requireArgs(args, 2)
+				withReadStore(s, func(rs *store.PGStore) { exportML(rs, args[1], boolFlag("anonymize")) })
+			}},
+		{name: "export", args: "person-data --account <id> <path> [--since <date>] [--until <date>]", help: "Writes the records referencing a user to a JSON file, for GDPR access requests.",
+			flags: []string{"account=", "since=", "until="},
+			runStore: func(s *store.PGStore, args []string) {
+				requireArgs(args, 2)
+				account := flagValue("account")
+				if account == "" {
+					usage()
+				}
+				withReadStore(s, func(rs *store.PGStore) {
+					exportPersonData(rs, account, flagValue("since"), flagValue("until"), args[1])
+				})
+			}},
+		{name: "migrate", args: "up", help: "Creates the schema if the DB has none, or applies the pending changes of the schema.",
+			runStore: withStore(migrateUp)},
+		{name: "migrate", args: "status", help: "Lists the changes of the schema, applied or pending.",
+			runStore: withStore(migrateStatus)},
+		{name: "migrate", args: "plan", help: "Prints the statements migrating the schema, without running them.",
+			runStore: withStore(migratePlan)},
+		{name: "map-issue", args: "< issue.json", help: "Reads a raw Jira issue from the standard input and prints its mapped state and events.",
+			run: withoutArgs(mapIssue)},
+		{name: "version", args: "[--json]", help: "Prints the version and commit of the application, and the versions of the mapping and of the schema it produces.",
+			flags: []string{"json"},
+			run:   func(args []string) { printVersion(boolFlag("json")) }},
+		{name: "event-kinds", help: "Lists the kinds of events with their description.",
+			run: func(args []string) {
+				for _, info := range store.EventKinds() {
+					fmt.Printf("%-20s %s\n", info.Kind, info.Description)
+				}
+			}},
+		{name: "generate", args: "testdata [--issues <n>] [--seed <n>] [--end <date>] [--out <dir>] [--reset]", help: "Generates synthetic issues and stores their records, or writes them as fixtures to `dir`.",
+			flags: []string{"issues=", "seed=", "end=", "out=", "reset"},
+			run:   withoutArgs(generateTestdata)},
+		{name: "benchmark", args: "store [--strategy single|batch|copy] [--events <n>] [--batch-size <n>] [--rate <n>]", help: "Reports the throughput of the ingestion strategies.",
+			flags:    []string{"strategy=", "events=", "batch-size=", "rate="},
+			runStore: withStore(benchmarkStore)},
+	}
+}
+
+// withoutArgs returns the `command.run` of an action taking no
+// argument.
+func withoutArgs(f func()) func(args []string) {
+	return func(args []string) { f() }
+}
+
+// withStore returns the `command.runStore` of an action taking no
+// argument besides the store.
+func withStore(f func(s *store.PGStore)) func(s *store.PGStore, args []string) {
+	return func(s *store.PGStore, args []string) { f(s) }
+}
+
+// dropTables drops the tables of the store (`cleanup` and
+// `drop-db`).
+func dropTables(s *store.PGStore, args []string) {
+	if err := s.DropTables(); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `%s`: %s", os.Args[1], err))
+	}
+}
+
+// runProjectionsAction performs the `projections` sub-action.
+func runProjectionsAction(s *store.PGStore, args []string) {
+	runProjections(s, args[0], args[1:])
+}
+
+// runReportAction prints the report of the `report` sub-action with
+// the read-only DB.
+func runReportAction(s *store.PGStore, args []string) {
+	withReadStore(s, func(rs *store.PGStore) { runReport(rs, args[0]) })
+}
+
+// subAction matches the first argument of the forms with a
+// sub-action (see `command.sub`).
+var subAction = regexp.MustCompile(`^[a-z][a-z-]*$`)
+
+// sub returns the sub-action of the form, e.g. `up` for `migrate
+// up`, empty if it has none.
+func (c command) sub() string {
+	f := strings.Fields(c.args)
+	if len(f) == 0 || !subAction.MatchString(f[0]) {
+		return ""
+	}
+	return f[0]
+}
+
+// forms returns the forms of the action matching the arguments: the
+// form of the sub-action of the first argument, or the forms without
+// sub-action. Returns none if the action is unknown.
+func forms(name string, args []string) []command {
+	var matched, unnamed []command
+	for _, c := range commands {
+		switch {
+		case c.name != name:
+		case c.sub() == "":
+			unnamed = append(unnamed, c)
+		case len(args) > 0 && args[0] == c.sub():
+			matched = append(matched, c)
+		}
+	}
+	if len(matched) > 0 {
+		return matched
+	}
+	return unnamed
+}
+
+// commandFlags are the flags of the action, parsed by
+// `parseCommand`.
+var commandFlags = flag.NewFlagSet("", flag.ContinueOnError)
+
+// parseCommand returns the form of the action of the arguments (the
+// program name excepted) and its positional arguments, its flags
+// being parsed into `commandFlags`. The flags may be passed before,
+// between or after the positional arguments. Prints the usage and
+// exits if the action is unknown, or its help if it's passed a flag
+// it doesn't accept.
+func parseCommand(args []string) (command, []string) {
+	if len(args) == 0 {
+		usage()
+	}
+	name, rest := args[0], args[1:]
+	if name == "serve" {
+		name = "webhooks"
+	}
+	fs := forms(name, rest)
+	if len(fs) == 0 {
+		usage()
+	}
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	c := fs[0]
+	for _, f := range fs {
+		if c.run == nil && c.runStore == nil {
+			c = f
+		}
+		for _, fl := range f.flags {
+			if strings.HasSuffix(fl, "=") {
+				set.String(strings.TrimSuffix(fl, "="), "", "")
+			} else {
+				set.Bool(fl, false, "")
+			}
+		}
+	}
+	var positional []string
+	for {
+		if err := set.Parse(rest); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid arguments of `%s`: %s\n\n", name, err)
+			printHelp(os.Stderr, name)
+			os.Exit(2)
+		}
+		if set.NArg() == 0 {
+			break
+		}
+		if n := len(rest) - set.NArg(); n > 0 && rest[n-1] == "--" {
+			// The arguments after `--` are all positional
+			positional = append(positional, set.Args()...)
+			break
+		}
+		positional = append(positional, set.Arg(0))
+		rest = set.Args()[1:]
+	}
+	commandFlags = set
+	return c, positional
+}
+
+// boolFlag returns true if the boolean flag of the action is passed.
+func boolFlag(name string) bool {
+	f := commandFlags.Lookup(name)
+	return f != nil && f.Value.String() == "true"
+}
+
+// flagValue returns the value of the flag of the action, empty if
+// it's not passed.
+func flagValue(name string) string {
+	if f := commandFlags.Lookup(name); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
+
+// requireArgs prints the usage and exits if the action has less
+// than `n` positional arguments.
+func requireArgs(args []string, n int) {
+	if len(args) < n {
+		usage()
+	}
+}
+
+// globalFlags are the flags accepted by all the actions.
+const globalFlags = "[--debug-http] [--concurrency <n>] [--limit <n>] [--log-level <level>] [--log-format text|json] [--no-log-redaction] [--projects <p1,p2>] [--labels <l1,l2>] [--components <c1,c2>] [--issue-types <t1,t2>]"
+
+// printUsage writes the list of the actions.
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: go run *.go %s <action>\n\nAvailable actions:\n", globalFlags)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  - %s\n", strings.TrimSpace(c.name+" "+c.args))
+	}
+	fmt.Fprintf(w, "\nRun `go run *.go <action> --help` for the help of an action.\n")
+}
+
+func usage() {
+	printUsage(os.Stdout)
+	os.Exit(1)
+}
+
+// printHelp writes the help of the forms of the action, or the list
+// of the actions if it's unknown. Returns false if it's unknown.
+func printHelp(w io.Writer, name string) bool {
+	found := false
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if found {
+			fmt.Fprintln(w)
+		}
+		found = true
+		fmt.Fprintf(w, "Usage: go run *.go [global flags] %s\n\n%s\n", strings.TrimSpace(c.name+" "+c.args), c.help)
+	}
+	if !found {
+		printUsage(w)
+	}
+	return found
+}
+
+// handleHelp prints the help of the action and exits if `--help` (or
+// `-h`, or the `help <action>` action) is passed.
+func handleHelp() {
+	if len(os.Args) > 1 && os.Args[1] == "help" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	} else if !extractFlag("--help") && !extractFlag("-h") {
+		return
+	}
+	if len(os.Args) < 2 {
+		printUsage(os.Stdout)
+		os.Exit(0)
+	}
+	if !printHelp(os.Stdout, os.Args[1]) {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
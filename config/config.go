@@ -0,0 +1,805 @@
+// Package config loads the application's configuration file.
+//
+// The configuration is a JSON file whose path is read from the
+// `CONFIG_PATH` environment variable (`config.json` by default).
+// The file is optional: when it does not exist, the default
+// configuration is used.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// DefaultPath is the path of the configuration file used when
+// `CONFIG_PATH` is not set.
+const DefaultPath = "config.json"
+
+// Config represents the application's configuration.
+type Config struct {
+	Jira    Jira    `json:"jira"`
+	DB      DB      `json:"db"`
+	Mapping Mapping `json:"mapping"`
+	Metrics Metrics `json:"metrics"`
+	Export  Export  `json:"export"`
+
+	// Sources are the named sets of issues to sync, each with its
+	// own JQL query. All the issues are synced as a single source if
+	// none is set.
+	Sources []Source `json:"sources"`
+
+	// Assertions are the consistency checks of the records run at
+	// the end of the syncs.
+	Assertions []Assertion `json:"assertions"`
+
+	// SprintReconciliation sets the alarm thresholds of the
+	// differences between the sprint reports of Jira and the
+	// records, checked at the end of the syncs.
+	SprintReconciliation SprintReconciliation `json:"sprint_reconciliation"`
+
+	// OutboundWebhooks are the webhooks posted the events of the
+	// issues once ingested.
+	OutboundWebhooks []OutboundWebhook `json:"outbound_webhooks"`
+}
+
+// OutboundWebhook configures a webhook posted the events of some
+// kinds once they're ingested by the syncs or the webhooks receiver
+// (see package `outbound`), e.g. to notify a deploy tracker when
+// issues are released:
+//
+//	{
+//	  "outbound_webhooks": [
+//	    {
+//	      "name": "deploys",
+//	      "url": "https://deploys.example.com/hooks/jira",
+//	      "event_kinds": ["status_changed"],
+//	      "statuses": ["Released"],
+//	      "secret": "s3cr3t"
+//	    }
+//	  ]
+//	}
+type OutboundWebhook struct {
+	// Name identifies the webhook, e.g. in the logs and to record
+	// the events posted to it.
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// EventKinds are the kinds of the events posted (see
+	// `store.EventKinds`), all of them if empty.
+	EventKinds []string `json:"event_kinds"`
+
+	// Statuses restrict the `status_changed` events posted to those
+	// to one of the statuses, if set.
+	Statuses []string `json:"statuses"`
+
+	// Secret signs the payloads if set: their `X-Hub-Signature`
+	// header is the HMAC-SHA256 of the body with the secret, as
+	// `sha256=<hex>`.
+	Secret string `json:"secret"`
+
+	// MaxAttempts is the number of times an event is posted before
+	// giving up (5 by default).
+	MaxAttempts int `json:"max_attempts"`
+
+	// MaxAge is the age of the events beyond which they're not
+	// posted (24 hours by default), so the past events written
+	// again by a full sync are not posted.
+	MaxAge Duration `json:"max_age"`
+}
+
+// Assertion configures a consistency check of the records run at
+// the end of the syncs: a built-in one (see
+// `store.BuiltinAssertions`) referenced by its name, or a query
+// counting the records violating it.
+//
+// Example:
+//
+//	[
+//	  {"name": "resolved_issues_have_resolved_at", "level": "fail"},
+//	  {"name": "no_event_before_creation"},
+//	  {
+//	    "name": "bugs_have_priority",
+//	    "query": "SELECT COUNT(*) FROM jira_issues_states WHERE issue_type = 'Bug' AND issue_priority IS NULL"
+//	  }
+//	]
+type Assertion struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+
+	// Level is "warn" (the default) to log the number of records
+	// violating the assertion, or "fail" to also fail the run.
+	Level string `json:"level"`
+}
+
+// SprintReconciliation sets the thresholds of the differences
+// between the issue counts of the sprint reports of Jira and those
+// computed from the records (see `jira_sprint_reconciliation`),
+// relative to Jira's counts, e.g. to only warn about a small drift
+// but fail the run beyond 5%:
+//
+//	{"warn": 0.01, "error": 0.05}
+//
+// A threshold of 0 (the default) is disabled.
+type SprintReconciliation struct {
+	// Warn is the difference beyond which the sprint is logged as a
+	// warning.
+	Warn float64 `json:"warn"`
+
+	// Error is the difference beyond which the sprint is logged as
+	// an error and the run fails.
+	Error float64 `json:"error"`
+}
+
+// Jira configures the connection to the Jira instance, so the same
+// binary can target several instances with a configuration file for
+// each. The credentials not set are read from the environment (see
+// `client.Auth`), so they can be kept out of the file.
+//
+// Example, for a Jira Data Center served behind a reverse proxy with
+// a private CA:
+//
+//	{
+//	  "jira": {
+//	    "base_url": "https://tools.example.com/jira",
+//	    "auth": {"type": "pat"},
+//	    "tls": {"ca_path": "/etc/ssl/example-ca.pem"}
+//	  }
+//	}
+type Jira struct {
+	// BaseURL is the URL of the instance, including its path if
+	// it's served behind a reverse proxy. For an OAuth 2.0 app of
+	// Jira Cloud, `https://api.atlassian.com/ex/jira/<cloud ID>`.
+	BaseURL string `json:"base_url"`
+
+	Auth JiraAuth `json:"auth"`
+	TLS  JiraTLS  `json:"tls"`
+
+	// Proxy is the URL of the HTTP(S) proxy to reach the instance,
+	// read from `HTTPS_PROXY` (and `NO_PROXY`) if not set.
+	Proxy string `json:"proxy"`
+}
+
+// JiraAuth configures the authentication to Jira API.
+type JiraAuth struct {
+	// Type is "basic" (username and password or API token, the
+	// default), "pat" (personal access token of Jira Server or Data
+	// Center) or "oauth2" (OAuth 2.0 app of Jira Cloud).
+	Type string `json:"type"`
+
+	// Username and Password are the credentials of "basic",
+	// `JIRA_USERNAME` and `JIRA_PASSWORD` if not set.
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// Token is the personal access token of "pat", `JIRA_TOKEN` if
+	// not set.
+	Token string `json:"token"`
+
+	// ClientID, ClientSecret and RefreshToken are the credentials of
+	// "oauth2", `JIRA_OAUTH_CLIENT_ID`, `JIRA_OAUTH_CLIENT_SECRET` and
+	// `JIRA_OAUTH_REFRESH_TOKEN` if not set. TokenURL defaults to
+	// Atlassian's token endpoint.
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+	TokenURL     string `json:"token_url"`
+
+	// TokenFile is the file the refresh tokens rotated by Atlassian
+	// are saved to and read from at startup in preference to
+	// RefreshToken, `JIRA_OAUTH_TOKEN_FILE` if not set.
+	TokenFile string `json:"token_file"`
+}
+
+// JiraTLS configures the TLS connections to Jira.
+type JiraTLS struct {
+	// CAPath is the path of a PEM file of additional trusted CAs.
+	CAPath string `json:"ca_path"`
+
+	// CertPath and KeyPath are the paths of the PEM files of the
+	// client certificate and its key, for mutual TLS.
+	CertPath string `json:"cert_path"`
+	KeyPath  string `json:"key_path"`
+
+	// InsecureSkipVerify disables the verification of the server's
+	// certificate, for tests only.
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+}
+
+// Source is a named set of issues, synced with its own JQL query
+// (or saved filter) and optionally its own custom fields. The records of its issues
+// are tagged with its name (`issue_source`).
+//
+// Example:
+//
+//	{
+//	  "sources": [
+//	    {"name": "web", "jql": "project = WEB AND issuetype != Sub-task"},
+//	    {
+//	      "name": "mobile",
+//	      "jql": "project = MOB",
+//	      "custom_fields": [
+//	        {"id": "customfield_10800", "column": "issue_tribe", "type": "option"}
+//	      ]
+//	    },
+//	    {"name": "support", "filter_id": 10200}
+//	  ]
+//	}
+type Source struct {
+	Name string `json:"name"`
+
+	// JQL restricts the issues of the source.
+	JQL string `json:"jql"`
+
+	// FilterID is the ID of a Jira saved filter restricting the
+	// issues of the source instead of `JQL`, its query being
+	// fetched by each sync.
+	FilterID int `json:"filter_id"`
+
+	// CustomFields and TrackedFields override those of `mapping`
+	// for the issues of the source, if set. Custom fields of
+	// different sources may share a column if they have the same
+	// type.
+	CustomFields  []CustomField `json:"custom_fields"`
+	TrackedFields []string      `json:"tracked_fields"`
+}
+
+// Mapping configures the mapping of Jira issues to records.
+type Mapping struct {
+	// StatusChangeReasons lists the transitions whose screen
+	// collects a reason (e.g. a rejection reason) and the field
+	// holding it.
+	StatusChangeReasons []StatusChangeReason `json:"status_change_reasons"`
+
+	// CustomFields maps Jira custom fields to columns of the issue
+	// tables. The default mapping (see
+	// `mapping.DefaultCustomFields`) is used if not set.
+	CustomFields []CustomField `json:"custom_fields"`
+
+	// ExcludedAuthors are the names of the accounts (e.g. migration
+	// bots, integration users) whose events are flagged with
+	// `author_excluded` and ignored by the metrics.
+	ExcludedAuthors []string `json:"excluded_authors"`
+
+	// IssueProperties are the keys of the entity properties of the
+	// issues (e.g. the data stored by Marketplace apps) fetched with
+	// them and stored in `issue_properties`.
+	IssueProperties []string `json:"issue_properties"`
+
+	// RenderHTML fetches the descriptions and comments of the issues
+	// rendered in HTML by Jira, stored in `issue_description_html`
+	// and `body_html` alongside the raw text.
+	RenderHTML bool `json:"render_html"`
+
+	// TrackedFields are the fields (as named in Jira's changelogs,
+	// e.g. "priority", "Fix Version") whose changes generate
+	// `field_changed` events. The default fields (see
+	// `mapping.DefaultTrackedFields`) are tracked if not set.
+	TrackedFields []string `json:"tracked_fields"`
+
+	// SeverityBuckets group the priorities of the projects into
+	// uniform buckets, stored in `issue_severity_bucket`.
+	SeverityBuckets []SeverityBucket `json:"severity_buckets"`
+
+	// Estimates configures the normalization of the estimates of the
+	// projects into `issue_estimate_seconds` and
+	// `issue_estimate_points`.
+	Estimates Estimates `json:"estimates"`
+
+	// Redaction configures the columns redacted before the records
+	// are written, e.g. to share the DB with external analysts.
+	Redaction Redaction `json:"redaction"`
+
+	// GeneratedIssues identifies the issues created by automation
+	// rules or templates, flagged with `issue_is_generated`.
+	GeneratedIssues GeneratedIssues `json:"generated_issues"`
+
+	// ProjectKeyRenames maps the previous keys of the renamed
+	// projects to their current key (e.g. `{"OLD": "NEW"}`). The
+	// keys of the records are stored with the current key, so the
+	// history of the issues is unified across the rename.
+	ProjectKeyRenames map[string]string `json:"project_key_renames"`
+
+	// ChangelogTruncationThreshold is the delay after the creation
+	// of an issue beyond which its earliest changelog history is
+	// evidence of a truncated changelog, flagged with
+	// `issue_changelog_truncated` (e.g. "720h"). Disabled if not set.
+	ChangelogTruncationThreshold Duration `json:"changelog_truncation_threshold"`
+
+	// TeamInference infers the team of the issues whose team field
+	// is empty from their components and labels.
+	TeamInference TeamInference `json:"team_inference"`
+
+	// AgileFields are the IDs of the custom fields of Jira Software
+	// and Advanced Roadmaps read by the mapping (e.g. the sprints),
+	// which differ between instances. The default IDs (see
+	// `mapping.DefaultAgileFields`) are used for those not set.
+	AgileFields AgileFields `json:"agile_fields"`
+}
+
+// AgileFields are the IDs of the custom fields of Jira Software and
+// Advanced Roadmaps read by the mapping, as listed by the
+// `explore-custom-fields` action.
+//
+// Example:
+//
+//	{
+//	  "sprint": {"id": "customfield_10020"},
+//	  "parent_link": {"id": "customfield_10018", "project_ids": {"Mobile": "customfield_10300"}}
+//	}
+type AgileFields struct {
+	EpicLink  FieldID `json:"epic_link"`
+	Sprint    FieldID `json:"sprint"`
+	EpicName  FieldID `json:"epic_name"`
+	EpicColor FieldID `json:"epic_color"`
+
+	// ParentLink is the "Parent Link" of Advanced Roadmaps, linking
+	// an epic to its initiative (and an initiative to the level
+	// above).
+	ParentLink FieldID `json:"parent_link"`
+}
+
+// FieldID identifies the custom field a value is read from.
+type FieldID struct {
+	// ID is the ID of the custom field (e.g. "customfield_10005").
+	ID string `json:"id"`
+
+	// ProjectIDs are the IDs of the field for the projects using
+	// another custom field, by project name (see
+	// `CustomField.ProjectIDs`). The other projects use `ID`.
+	ProjectIDs map[string]string `json:"project_ids"`
+}
+
+// TeamInference infers the team of the issues whose team custom
+// field is empty (e.g. legacy issues created before the field was
+// used) from their components and labels, so the metrics per team
+// cover them.
+//
+// Example:
+//
+//	{
+//	  "column": "issue_team",
+//	  "rules": [
+//	    {"component": "Checkout", "team": "Payments"},
+//	    {"label": "search", "team": "Discovery"}
+//	  ]
+//	}
+type TeamInference struct {
+	// Column is the column of the custom field holding the team
+	// (`issue_team` if not set).
+	Column string `json:"column"`
+
+	// Rules are tried in order, the team of the first matching a
+	// component or a label of the issue being inferred.
+	Rules []TeamRule `json:"rules"`
+}
+
+// TeamRule infers a team from either a component or a label.
+type TeamRule struct {
+	Component string `json:"component"`
+	Label     string `json:"label"`
+	Team      string `json:"team"`
+}
+
+// GeneratedIssues identifies the issues created by automation rules
+// or templates (e.g. recurring chores, issues cloned by a
+// workflow), so the metrics can exclude them.
+//
+// Example:
+//
+//	{
+//	  "creators": ["automation", "557058:f58131cb-b67d-43c7-b30d-6b58d40bd077"],
+//	  "labels": ["recurring", "from-template"]
+//	}
+type GeneratedIssues struct {
+	// Creators are the names or account IDs of the accounts
+	// creating the issues (e.g. the "Automation for Jira" user).
+	Creators []string `json:"creators"`
+
+	// Labels are the labels set on the generated issues (e.g. by
+	// the template).
+	Labels []string `json:"labels"`
+}
+
+// Redaction configures the redaction of personal or confidential
+// data from the records, applied by the mapper so it's never
+// written to the DB.
+//
+// Example:
+//
+//	{
+//	  "salt": "a-long-random-string",
+//	  "columns": [
+//	    {"column": "issue_assignee", "action": "hash"},
+//	    {"column": "issue_description", "action": "drop"},
+//	    {"column": "comment_body", "action": "drop"},
+//	    {"column": "issue_summary", "action": "truncate", "length": 20}
+//	  ]
+//	}
+type Redaction struct {
+	// Salt is prepended to the values before they're hashed, so the
+	// hashes of known values (e.g. the names of the employees)
+	// can't be computed without it. Read from `REDACTION_SALT` if
+	// not set.
+	Salt string `json:"salt"`
+
+	Columns []ColumnRedaction `json:"columns"`
+}
+
+// ColumnRedaction redacts the values of a column.
+type ColumnRedaction struct {
+	// Column is the name of the column, as listed by
+	// `mapping.RedactableColumns`, or a custom column of type
+	// "text", "option" or "user".
+	Column string `json:"column"`
+
+	// Action is "drop" (the values are NULL, or empty if the
+	// column is required), "hash" (the values are replaced with
+	// their salted SHA-256 hash, so they can still be grouped and
+	// joined) or "truncate" (only their first `Length` characters
+	// are kept).
+	Action string `json:"action"`
+	Length int    `json:"length"`
+}
+
+// Estimates configures how the estimates of the issues, expressed
+// in different fields and units depending on the project, are
+// converted into seconds and story points, so they can be compared
+// across teams.
+//
+// Example:
+//
+//	{
+//	  "hours_per_day": 8,
+//	  "hours_per_point": 4,
+//	  "projects": [
+//	    {"project": "Backend", "field": "customfield_10016", "unit": "points", "hours_per_point": 6},
+//	    {"project": "Support", "field": "timeoriginalestimate", "unit": "seconds"}
+//	  ]
+//	}
+type Estimates struct {
+	// HoursPerDay is the length of a day of the "days" unit.
+	// Defaults to 8.
+	HoursPerDay float64 `json:"hours_per_day"`
+
+	// HoursPerPoint is the length of the canonical story point the
+	// estimates in seconds are converted to. The estimates are not
+	// converted to points if not set.
+	HoursPerPoint float64 `json:"hours_per_point"`
+
+	// Projects configure the estimate of each project. The projects
+	// not listed use the original estimate of time tracking.
+	Projects []ProjectEstimate `json:"projects"`
+}
+
+// ProjectEstimate configures the estimate of the issues of a
+// project.
+type ProjectEstimate struct {
+	// Project is the name of the project.
+	Project string `json:"project"`
+
+	// Field is the ID of the field holding the estimate: a number
+	// custom field (e.g. "customfield_10016"), or
+	// "timeoriginalestimate" (the default) for the original estimate
+	// of time tracking, in seconds.
+	Field string `json:"field"`
+
+	// Unit is the unit of the field's values: "seconds" (the
+	// default), "minutes", "hours", "days" or "points".
+	Unit string `json:"unit"`
+
+	// HoursPerDay overrides `Estimates.HoursPerDay` for the project.
+	HoursPerDay float64 `json:"hours_per_day"`
+
+	// HoursPerPoint is the length of a point of the project, to
+	// convert its estimates in points to seconds. Points are kept as
+	// they are, and not converted to seconds, if not set.
+	HoursPerPoint float64 `json:"hours_per_point"`
+}
+
+// SeverityBucket groups priorities, e.g. the priorities of several
+// projects using different priority schemes. The bucket of an issue
+// is the first one listing its priority, none (NULL) if no bucket
+// lists it.
+//
+// Example:
+//
+//	{"name": "P0", "priorities": ["Blocker", "Highest", "Critical"]}
+type SeverityBucket struct {
+	Name       string   `json:"name"`
+	Priorities []string `json:"priorities"`
+}
+
+// CustomField maps a Jira custom field to a column of
+// `jira_issues_states` and `jira_issues_events`.
+//
+// Example:
+//
+//	{
+//	  "id": "customfield_10600",
+//	  "column": "issue_developer_backend",
+//	  "type": "user",
+//	  "name": "Developer Backend",
+//	  "description": "Name of the backend developer of the issue.",
+//	  "project_ids": {"Mobile": "customfield_10555"}
+//	}
+type CustomField struct {
+	// ID is the ID of the custom field (e.g. "customfield_10600"),
+	// as listed by the `explore-custom-fields` action.
+	ID string `json:"id"`
+
+	// ProjectIDs are the IDs of the field for the projects using
+	// another custom field, by project name, e.g. in instances
+	// merged from several ones. The other projects use `ID`.
+	ProjectIDs map[string]string `json:"project_ids"`
+
+	// Column is the name of the column in the DB.
+	Column string `json:"column"`
+
+	// Type is the type of the field's values: "user" (the user's
+	// name is stored), "option" (the value of a select list),
+	// "text", "number", "date" or "datetime".
+	Type string `json:"type"`
+
+	// Name (the name of the field in Jira) and Description
+	// document the column in the DB.
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// StatusChangeReason maps a transition to the field holding the
+// reason of the status change, captured in `status_change_reason`.
+//
+// Example:
+//
+//	{"to": "Rejected", "field": "Rejection Reason"}
+type StatusChangeReason struct {
+	// From and To are the statuses of the transition. An empty
+	// value matches any status.
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Field is the name of the field as it appears in the
+	// changelog (e.g. "Rejection Reason").
+	Field string `json:"field"`
+}
+
+// Export configures how exports are written.
+type Export struct {
+	// Workers is the number of files written in parallel
+	// (defaults to the number of CPUs).
+	Workers int `json:"workers"`
+
+	// MaxFileSize is the approximate size in bytes above which
+	// a new file is started (defaults to 256 MB).
+	MaxFileSize int64 `json:"max_file_size"`
+
+	// MaxOpenFiles is the number of files each writer keeps open
+	// (defaults to 16).
+	MaxOpenFiles int `json:"max_open_files"`
+}
+
+// DB configures how the application uses the database.
+type DB struct {
+	// Backend is the kind of DB the records are stored in:
+	// "postgres" (the default) or "sqlite", which only supports the
+	// syncs (see `store.SQLiteStore`).
+	Backend string `json:"backend"`
+
+	// Path is the path of the SQLite DB file (`agilizer.db` by
+	// default), for the "sqlite" backend.
+	Path string `json:"path"`
+
+	// StatementTimeout cancels statements running for longer
+	// (e.g. `"30s"`). Disabled if not set.
+	StatementTimeout Duration `json:"statement_timeout"`
+
+	// LockTimeout cancels statements waiting for a lock for
+	// longer (e.g. `"10s"`). Disabled if not set.
+	LockTimeout Duration `json:"lock_timeout"`
+
+	// WaitTimeout is the period during which the connection to the
+	// DB is retried on startup (e.g. `"1m"` when the DB is started
+	// along with the application). The connection is tried once if
+	// not set.
+	WaitTimeout Duration `json:"wait_timeout"`
+
+	// BatchSize is the number of issues written at once by full
+	// syncs (defaults to `store.DefaultBatchSize`).
+	BatchSize int `json:"batch_size"`
+
+	// InsertStrategy is the way the batches of full syncs are
+	// inserted: "copy" (the default), "values" or "statements" (see
+	// `store.PGStore.SetInsertStrategy`).
+	InsertStrategy string `json:"insert_strategy"`
+
+	// PoolerCompatible makes the connections and the store work
+	// behind a pooler in transaction mode, e.g. pgbouncer with
+	// `pool_mode = transaction` (see
+	// `store.PGStore.SetPoolerCompatible`).
+	PoolerCompatible bool `json:"pooler_compatible"`
+
+	// StrictSchema creates foreign keys between the issue tables
+	// (see `store.PGStore.SetStrictSchema`), added to an existing
+	// schema by `migrate up`.
+	StrictSchema bool `json:"strict_schema"`
+
+	// FullTextSearch is the Postgres text search configuration
+	// (e.g. `"english"`) of the full-text search columns over the
+	// summaries, descriptions and comments (see
+	// `store.PGStore.SetFullTextSearch`), added to an existing
+	// schema by `migrate up`. Disabled if not set.
+	FullTextSearch string `json:"full_text_search"`
+
+	// NormalizedFields writes the labels, components and fix
+	// versions of the issues one per row to their own tables (see
+	// `store.PGStore.SetNormalizedFields`), along with the
+	// concatenated columns.
+	NormalizedFields bool `json:"normalized_fields"`
+
+	// StateHistory keeps the versions of the issue states in
+	// `jira_issues_states_history` (see
+	// `store.PGStore.SetStateHistory`).
+	StateHistory bool `json:"state_history"`
+
+	// Quarantine moves the rows violating the unique constraints of
+	// a batch to `jira_quarantine` instead of failing it (see
+	// `store.PGStore.SetQuarantine`).
+	Quarantine bool `json:"quarantine"`
+
+	Throttle Throttle `json:"throttle"`
+}
+
+// Throttle configures the throttling of writes to the DB, to
+// avoid degrading a DB shared with other applications. Zero values
+// disable the corresponding limit.
+//
+// Example:
+//
+//	{
+//	  "db": {
+//	    "throttle": {
+//	      "max_rows_per_second": 500,
+//	      "max_replication_lag": "30s",
+//	      "max_connections_usage": 0.8
+//	    }
+//	  }
+//	}
+type Throttle struct {
+	// MaxRowsPerSecond limits the rate of inserted rows.
+	MaxRowsPerSecond int `json:"max_rows_per_second"`
+
+	// MaxReplicationLag pauses writes while a replica lags more.
+	MaxReplicationLag Duration `json:"max_replication_lag"`
+
+	// MaxConnectionsUsage pauses writes while the ratio of used
+	// connections over `max_connections` is greater.
+	MaxConnectionsUsage float64 `json:"max_connections_usage"`
+
+	// CheckInterval is the interval between checks of the
+	// replication lag and connections (defaults to 10s).
+	CheckInterval Duration `json:"check_interval"`
+}
+
+// Metrics configures how metrics (e.g. cycle time, throughput)
+// are computed.
+//
+// By default, statuses are classified using the category Jira
+// assigns to them (To Do, In Progress, Done). A project with an
+// entry in `Projects` uses the listed statuses instead.
+//
+// Example:
+//
+//	{
+//	  "metrics": {
+//	    "projects": {
+//	      "Project": {
+//	        "started": ["In Dev", "In Review"],
+//	        "done": ["Released"]
+//	      }
+//	    }
+//	  }
+//	}
+type Metrics struct {
+	// Projects maps a project name to the statuses of the
+	// project's workflow counting as started or done.
+	Projects map[string]ProjectStatuses `json:"projects"`
+
+	// PercentileWindow is the trailing window over which the
+	// cycle time percentiles of the weekly stats are computed
+	// (e.g. `"672h"` for 4 weeks, the default).
+	PercentileWindow Duration `json:"percentile_window"`
+
+	// Calendar is the business calendar of the business-time
+	// durations, which are not computed if it's not set.
+	Calendar *Calendar `json:"calendar"`
+
+	// SprintGoalQuery is the JQL clause selecting the issues tagged
+	// to the goal of their sprint (e.g. `labels = sprint-goal`),
+	// whose completion is recorded in `sprint_goal_results` when the
+	// sprints are closed. The goals are not evaluated if not set.
+	SprintGoalQuery string `json:"sprint_goal_query"`
+}
+
+// Calendar configures the business calendar used to compute the
+// durations of the metrics in business time, counting only the work
+// hours of the work days which are not holidays.
+//
+// Example:
+//
+//	{
+//	  "metrics": {
+//	    "calendar": {
+//	      "timezone": "Europe/Paris",
+//	      "work_days": ["monday", "tuesday", "wednesday", "thursday", "friday"],
+//	      "work_start": "09:00",
+//	      "work_end": "18:00",
+//	      "holidays": ["2020-12-25", "2021-01-01"]
+//	    }
+//	  }
+//	}
+type Calendar struct {
+	// Timezone is the IANA name of the timezone of the work hours
+	// and holidays (defaults to UTC).
+	Timezone string `json:"timezone"`
+
+	// WorkDays are the names of the work days (defaults to monday
+	// to friday).
+	WorkDays []string `json:"work_days"`
+
+	// WorkStart and WorkEnd are the start and end of the work
+	// hours, as `HH:MM` (default to 09:00 and 17:00).
+	WorkStart string `json:"work_start"`
+	WorkEnd   string `json:"work_end"`
+
+	// Holidays are the dates (`YYYY-MM-DD`) which are not worked.
+	Holidays []string `json:"holidays"`
+}
+
+// ProjectStatuses lists the statuses of a project counting as
+// started or done. Statuses which are not listed are considered
+// as not started.
+//
+// Review lists the review and QA statuses (which should be listed
+// as started too): an issue done after entering one of them without
+// ever going back to a status before them is "first time right"
+// (see `store.IssueMetrics.FirstTimeRight`).
+type ProjectStatuses struct {
+	Started []string `json:"started"`
+	Done    []string `json:"done"`
+	Review  []string `json:"review"`
+}
+
+// Path returns the path of the configuration file: `CONFIG_PATH`,
+// or `DefaultPath` if not set.
+func Path() string {
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		return path
+	}
+	return DefaultPath
+}
+
+// Load reads the configuration file at `Path`. Returns the default
+// configuration if there is no file at this path.
+func Load() (*Config, error) {
+	return LoadFile(Path())
+}
+
+// LoadFile reads the configuration file at the specified path.
+// Returns the default configuration if there is no file at this
+// path.
+func LoadFile(path string) (*Config, error) {
+	c := Config{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening config file `%s`: %s", path, err)
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&c); err != nil {
+		return nil, fmt.Errorf("error parsing config file `%s`: %s", path, err)
+	}
+	return &c, nil
+}
@@ -0,0 +1,153 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+)
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("missing file", func(t *testing.T) {
+		c, err := config.LoadFile(filepath.Join(dir, "missing.json"))
+		if err != nil {
+			t.Fatalf("expected no error for a missing file, got %s", err)
+		}
+		if c.DB.Throttle.MaxRowsPerSecond != 0 {
+			t.Errorf("expected default config, got %v", c)
+		}
+	})
+
+	t.Run("valid file", func(t *testing.T) {
+		path := filepath.Join(dir, "config.json")
+		content := `{"db": {"throttle": {"max_rows_per_second": 500, "max_replication_lag": "30s"}}}`
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c, err := config.LoadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.DB.Throttle.MaxRowsPerSecond != 500 {
+			t.Errorf("expected max_rows_per_second to be 500, got %d", c.DB.Throttle.MaxRowsPerSecond)
+		}
+		if c.DB.Throttle.MaxReplicationLag.Duration != 30*time.Second {
+			t.Errorf("expected max_replication_lag to be 30s, got %s", c.DB.Throttle.MaxReplicationLag)
+		}
+	})
+
+	t.Run("invalid duration", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.json")
+		content := `{"db": {"throttle": {"max_replication_lag": 30}}}`
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := config.LoadFile(path); err == nil {
+			t.Errorf("expected an error for an invalid duration")
+		}
+	})
+}
+
+func TestLoadTeamsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "teams")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFile := func(path, content string) string {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write := func(content string) string {
+		return writeFile(filepath.Join(dir, "teams.json"), content)
+	}
+
+	t.Run("valid file", func(t *testing.T) {
+		teams, err := config.LoadTeamsFile(write(`{"memberships": [{"person": "alice", "team": "Payments", "from": "2019-01-01"}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(teams.Memberships) != 1 {
+			t.Fatalf("expected 1 membership, got %d", len(teams.Memberships))
+		}
+		m := teams.Memberships[0]
+		if m.From == nil || m.From.Format(config.DateFormat) != "2019-01-01" || m.To != nil {
+			t.Errorf("unexpected membership dates %v - %v", m.From, m.To)
+		}
+	})
+
+	t.Run("membership ending before it starts", func(t *testing.T) {
+		_, err := config.LoadTeamsFile(write(`{"memberships": [{"person": "alice", "team": "Payments", "from": "2019-06-01", "to": "2019-01-01"}]}`))
+		if err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+
+	t.Run("YAML file", func(t *testing.T) {
+		teams, err := config.LoadTeamsFile(writeFile(filepath.Join(dir, "teams.yml"), `
+# Comment
+memberships:
+  - person: alice
+    team: "Payments"
+    tribe: 'Commerce' # Comment
+    from: 2019-01-01
+    to:
+  -
+    person: bob
+    team: Search
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(teams.Memberships) != 2 {
+			t.Fatalf("expected 2 memberships, got %d", len(teams.Memberships))
+		}
+		m := teams.Memberships[0]
+		if m.Person != "alice" || m.Team != "Payments" || m.Tribe != "Commerce" || m.From == nil || m.From.Format(config.DateFormat) != "2019-01-01" || m.To != nil {
+			t.Errorf("unexpected membership %+v", m)
+		}
+		if m = teams.Memberships[1]; m.Person != "bob" || m.Team != "Search" || m.From != nil {
+			t.Errorf("unexpected membership %+v", m)
+		}
+	})
+
+	t.Run("unsupported YAML", func(t *testing.T) {
+		for _, content := range []string{
+			"teams:\n  - person: alice\n",
+			"memberships:\n  - {person: alice, team: Payments}\n",
+			"memberships:\n  person: alice\n",
+		} {
+			if _, err := config.LoadTeamsFile(writeFile(filepath.Join(dir, "teams.yml"), content)); err == nil {
+				t.Errorf("expected an error for %q", content)
+			}
+		}
+	})
+
+	t.Run("example file", func(t *testing.T) {
+		teams, err := config.LoadTeamsFile("../teams.example.yml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(teams.Memberships) != 3 {
+			t.Errorf("expected 3 memberships, got %d", len(teams.Memberships))
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := config.LoadTeamsFile(filepath.Join(dir, "missing.json")); err == nil {
+			t.Errorf("expected an error for a missing teams file")
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a `time.Duration` read from a string in the
+// configuration file (e.g. `"30s"`, `"1h30m"`).
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses the duration using `time.ParseDuration`.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string (e.g. \"30s\"), got %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalJSON formats the duration using `time.Duration.String`.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
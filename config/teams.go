@@ -0,0 +1,228 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTeamsPath is the path of the teams file used when
+// `TEAMS_PATH` is not set.
+const DefaultTeamsPath = "teams.yml"
+
+// legacyTeamsPath is the path of the teams file used when
+// `TEAMS_PATH` is not set and `DefaultTeamsPath` doesn't exist, the
+// teams file having been JSON only.
+const legacyTeamsPath = "teams.json"
+
+// Teams represents the teams file, mapping people to teams and
+// tribes over time, since Jira doesn't model teams. The file is in
+// YAML (see `decodeTeamsYAML`), or in JSON if its extension is not
+// `.yml` nor `.yaml`.
+//
+// Example:
+//
+//	memberships:
+//	  - person: alice
+//	    team: Payments
+//	    tribe: Commerce
+//	    from: 2019-01-01
+//	    to: 2019-06-30
+//	  - person: alice
+//	    team: Search
+//	    tribe: Discovery
+//	    from: 2019-07-01
+type Teams struct {
+	Memberships []Membership `json:"memberships"`
+}
+
+// Membership is the membership of a person (a Jira user name) to
+// a team, effective from `From` to `To` (both included). A
+// membership without `From` or `To` is not bounded on that side.
+type Membership struct {
+	Person string `json:"person"`
+	Team   string `json:"team"`
+	Tribe  string `json:"tribe"`
+	From   *Date  `json:"from"`
+	To     *Date  `json:"to"`
+}
+
+// Date is a date read from a string in the `YYYY-MM-DD` format.
+type Date struct {
+	time.Time
+}
+
+// DateFormat is the format of `Date` values.
+const DateFormat = "2006-01-02"
+
+// UnmarshalJSON parses the date.
+func (d *Date) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("date should be a string (e.g. \"2019-01-31\"), got %s", b)
+	}
+	t, err := time.Parse(DateFormat, s)
+	if err != nil {
+		return err
+	}
+	d.Time = t
+	return nil
+}
+
+// LoadTeams reads the teams file whose path is read from the
+// `TEAMS_PATH` environment variable (`teams.yml` by default, or
+// `teams.json` if it doesn't exist). Contrary to the configuration
+// file, the teams file is required.
+func LoadTeams() (*Teams, error) {
+	path := os.Getenv("TEAMS_PATH")
+	if path == "" {
+		path = DefaultTeamsPath
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if _, err = os.Stat(legacyTeamsPath); err == nil {
+				path = legacyTeamsPath
+			}
+		}
+	}
+	return LoadTeamsFile(path)
+}
+
+// LoadTeamsFile reads the teams file at the specified path and
+// validates it.
+func LoadTeamsFile(path string) (*Teams, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening teams file `%s`: %s", path, err)
+	}
+	defer f.Close()
+	t := Teams{}
+	switch filepath.Ext(path) {
+	case ".yml", ".yaml":
+		err = decodeTeamsYAML(f, &t)
+	default:
+		err = json.NewDecoder(f).Decode(&t)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing teams file `%s`: %s", path, err)
+	}
+	for i, m := range t.Memberships {
+		switch {
+		case m.Person == "" || m.Team == "":
+			return nil, fmt.Errorf("error in teams file `%s`: membership %d must have a person and a team", path, i)
+		case m.From != nil && m.To != nil && m.To.Before(m.From.Time):
+			return nil, fmt.Errorf("error in teams file `%s`: membership %d of `%s` ends before it starts", path, i, m.Person)
+		}
+	}
+	return &t, nil
+}
+
+// decodeTeamsYAML decodes the teams file in YAML into `t`. There is
+// no YAML library among the dependencies, so only the subset needed
+// by the file is supported: the `memberships` key with a list of
+// mappings of scalars (plain, or quoted with `"` or `'`), in block
+// style, and comments. Empty and `null` values are omitted.
+func decodeTeamsYAML(r io.Reader, t *Teams) error {
+	var memberships []map[string]string
+	root := false
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		text := strings.TrimSpace(line)
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			return fmt.Errorf("line %d: tabs are not allowed in the indentation", n)
+		}
+		if !strings.HasPrefix(line, " ") {
+			if k, v, err := yamlKeyValue(text); err != nil || k != "memberships" || v != "" {
+				return fmt.Errorf("line %d: expected `memberships:`", n)
+			}
+			root = true
+			continue
+		}
+		if !root {
+			return fmt.Errorf("line %d: expected `memberships:`", n)
+		}
+		if text == "-" || strings.HasPrefix(text, "- ") {
+			memberships = append(memberships, make(map[string]string))
+			if text = strings.TrimSpace(strings.TrimPrefix(text, "-")); text == "" {
+				continue
+			}
+		} else if len(memberships) == 0 {
+			return fmt.Errorf("line %d: expected a membership (`- person: ...`)", n)
+		}
+		k, v, err := yamlKeyValue(text)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", n, err)
+		}
+		if v != "" {
+			memberships[len(memberships)-1][k] = v
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	b, err := json.Marshal(map[string]interface{}{"memberships": memberships})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, t)
+}
+
+// yamlKeyValue parses a `key: value` line of YAML, returning the
+// key and the unquoted value, empty if it's null.
+func yamlKeyValue(text string) (string, string, error) {
+	i := strings.Index(text, ":")
+	if i <= 0 || (i+1 < len(text) && text[i+1] != ' ') {
+		return "", "", fmt.Errorf("expected `key: value`, got `%s`", text)
+	}
+	k, v := strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+	switch {
+	case strings.HasPrefix(v, `"`) || strings.HasPrefix(v, "'"):
+		u, ok := yamlQuoted(v)
+		if !ok {
+			return "", "", fmt.Errorf("invalid quoted value of `%s`: %s", k, v)
+		}
+		return k, u, nil
+	case strings.HasPrefix(v, "{") || strings.HasPrefix(v, "["):
+		return "", "", fmt.Errorf("flow style is not supported, got `%s`", v)
+	}
+	if j := strings.Index(v, " #"); j >= 0 {
+		v = strings.TrimSpace(v[:j])
+	}
+	if v == "~" || v == "null" || strings.HasPrefix(v, "#") {
+		v = ""
+	}
+	return k, v, nil
+}
+
+// yamlQuoted returns the unquoted value of a quoted scalar, followed
+// by a comment or nothing.
+func yamlQuoted(v string) (string, bool) {
+	q := v[0]
+	for i := 1; i < len(v); i++ {
+		switch {
+		case q == '"' && v[i] == '\\':
+			i++
+		case v[i] != q:
+		case q == '\'' && i+1 < len(v) && v[i+1] == '\'':
+			i++
+		default:
+			if rest := strings.TrimSpace(v[i+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return "", false
+			}
+			if q == '\'' {
+				return strings.Replace(v[1:i], "''", "'", -1), true
+			}
+			u, err := strconv.Unquote(v[:i+1])
+			return u, err == nil
+		}
+	}
+	return "", false
+}
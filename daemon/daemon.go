@@ -0,0 +1,235 @@
+// Package daemon runs the synchronization periodically and exposes
+// admin HTTP endpoints to control it.
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// The states the daemon may be in.
+const (
+	StateIdle    = "idle"
+	StateSyncing = "syncing"
+	StatePaused  = "paused"
+)
+
+// Daemon runs a sync function every `interval`. It can be paused,
+// resumed and triggered to sync immediately, either directly or
+// through the admin endpoints (see `Handler`). The errors of the
+// syncs are logged and reported by `Status`.
+//
+// Pausing the daemon doesn't interrupt a running sync, it prevents
+// the next ones from starting.
+type Daemon struct {
+	syncFn   func() error
+	interval time.Duration
+	trigger  chan struct{}
+
+	mutex          sync.Mutex
+	paused         bool
+	syncing        bool
+	syncsCount     int
+	failedSyncs    int
+	lastSyncStart  time.Time
+	lastSyncFinish time.Time
+	lastSyncError  error
+}
+
+// Status represents the current state of the daemon, as reported
+// by the `/status` endpoint.
+type Status struct {
+	State          string     `json:"state"`
+	Interval       string     `json:"interval"`
+	SyncsCount     int        `json:"syncs_count"`
+	LastSyncStart  *time.Time `json:"last_sync_start"`
+	LastSyncFinish *time.Time `json:"last_sync_finish"`
+	NextSync       *time.Time `json:"next_sync"`
+
+	// FailedSyncsCount is the number of syncs which returned an
+	// error, and LastSyncError the error of the last sync if it
+	// failed.
+	FailedSyncsCount int    `json:"failed_syncs_count"`
+	LastSyncError    string `json:"last_sync_error,omitempty"`
+}
+
+// New returns a `Daemon` running `syncFn` every `interval`.
+func New(interval time.Duration, syncFn func() error) *Daemon {
+	return &Daemon{
+		syncFn:   syncFn,
+		interval: interval,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// Run performs a first sync and then one every `interval`, or
+// when triggered, until `stop` is closed.
+func (d *Daemon) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	d.syncUnlessPaused()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			d.syncUnlessPaused()
+		case <-d.trigger:
+			d.syncUnlessPaused()
+		}
+	}
+}
+
+// Pause prevents new syncs from starting. A running sync is not
+// interrupted.
+func (d *Daemon) Pause() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.paused = true
+	logging.Infof("Daemon paused")
+}
+
+// Resume cancels `Pause`.
+func (d *Daemon) Resume() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.paused = false
+	logging.Infof("Daemon resumed")
+}
+
+// Trigger requests an immediate sync. Returns false if the daemon is
+// paused, in which case no sync is performed.
+//
+// If a sync is already running, the triggered sync is performed
+// once it's done. Several triggers while a sync is running result
+// in a single sync.
+func (d *Daemon) Trigger() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.paused {
+		return false
+	}
+	select {
+	case d.trigger <- struct{}{}:
+	default: // a sync is already triggered
+	}
+	return true
+}
+
+// Status returns the current status of the daemon.
+func (d *Daemon) Status() Status {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	s := Status{
+		State:      StateIdle,
+		Interval:   d.interval.String(),
+		SyncsCount: d.syncsCount,
+
+		FailedSyncsCount: d.failedSyncs,
+	}
+	if d.lastSyncError != nil {
+		s.LastSyncError = d.lastSyncError.Error()
+	}
+	switch {
+	case d.syncing:
+		s.State = StateSyncing
+	case d.paused:
+		s.State = StatePaused
+	}
+	if !d.lastSyncStart.IsZero() {
+		start := d.lastSyncStart
+		next := start.Add(d.interval)
+		s.LastSyncStart = &start
+		s.NextSync = &next
+	}
+	if !d.lastSyncFinish.IsZero() {
+		finish := d.lastSyncFinish
+		s.LastSyncFinish = &finish
+	}
+	if d.paused {
+		s.NextSync = nil
+	}
+	return s
+}
+
+// Handler returns an `http.Handler` exposing the admin endpoints:
+//
+//	GET  /status  reports the daemon's `Status` as JSON
+//	POST /pause   pauses the daemon
+//	POST /resume  resumes the daemon
+//	POST /sync    triggers an immediate sync (409 if paused)
+//
+// All endpoints respond with the daemon's `Status`.
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		d.respond(w, http.StatusOK)
+	})
+	mux.HandleFunc("/pause", d.postOnly(func(w http.ResponseWriter, r *http.Request) {
+		d.Pause()
+		d.respond(w, http.StatusOK)
+	}))
+	mux.HandleFunc("/resume", d.postOnly(func(w http.ResponseWriter, r *http.Request) {
+		d.Resume()
+		d.respond(w, http.StatusOK)
+	}))
+	mux.HandleFunc("/sync", d.postOnly(func(w http.ResponseWriter, r *http.Request) {
+		if !d.Trigger() {
+			d.respond(w, http.StatusConflict)
+			return
+		}
+		d.respond(w, http.StatusAccepted)
+	}))
+	return mux
+}
+
+func (d *Daemon) syncUnlessPaused() {
+	d.mutex.Lock()
+	if d.paused {
+		d.mutex.Unlock()
+		return
+	}
+	d.syncing = true
+	d.lastSyncStart = time.Now()
+	d.mutex.Unlock()
+
+	err := d.syncFn()
+	if err != nil {
+		logging.Errorf("Error in daemon sync: %s", err)
+	}
+
+	d.mutex.Lock()
+	d.syncing = false
+	d.syncsCount++
+	d.lastSyncError = err
+	if err != nil {
+		d.failedSyncs++
+	}
+	d.lastSyncFinish = time.Now()
+	d.mutex.Unlock()
+}
+
+func (d *Daemon) postOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (d *Daemon) respond(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(d.Status()); err != nil {
+		logging.Errorf("Error in daemon admin endpoint: %s", err)
+	}
+}
@@ -0,0 +1,120 @@
+package daemon_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/daemon"
+)
+
+func TestDaemon_Handler(t *testing.T) {
+	syncs := make(chan struct{}, 10)
+	d := daemon.New(time.Hour, func() error {
+		syncs <- struct{}{}
+		return nil
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.Run(stop)
+	waitForSync(t, syncs) // initial sync
+
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	// Trigger an immediate sync
+	s := post(t, srv.URL+"/sync", http.StatusAccepted)
+	waitForSync(t, syncs)
+
+	// Pause, then triggering is refused
+	s = post(t, srv.URL+"/pause", http.StatusOK)
+	if s.State != daemon.StatePaused {
+		t.Errorf("expected state `%s`, got `%s`", daemon.StatePaused, s.State)
+	}
+	post(t, srv.URL+"/sync", http.StatusConflict)
+
+	// Resume and trigger again
+	s = post(t, srv.URL+"/resume", http.StatusOK)
+	if s.State == daemon.StatePaused {
+		t.Errorf("expected daemon not to be paused after resume")
+	}
+	post(t, srv.URL+"/sync", http.StatusAccepted)
+	waitForSync(t, syncs)
+
+	// Only POST is allowed on actions
+	res, err := http.Get(srv.URL + "/pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET /pause to respond %d, got %d", http.StatusMethodNotAllowed, res.StatusCode)
+	}
+}
+
+func post(t *testing.T, url string, expectedCode int) daemon.Status {
+	res, err := http.Post(url, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != expectedCode {
+		t.Errorf("expected POST %s to respond %d, got %d", url, expectedCode, res.StatusCode)
+	}
+	var s daemon.Status
+	if err = json.NewDecoder(res.Body).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func waitForSync(t *testing.T, syncs chan struct{}) {
+	select {
+	case <-syncs:
+	case <-time.After(time.Second):
+		t.Fatalf("expected a sync to be performed")
+	}
+}
+
+func TestDaemon_Status_syncError(t *testing.T) {
+	syncs := make(chan struct{}, 10)
+	fail := true
+	d := daemon.New(time.Hour, func() error {
+		defer func() { syncs <- struct{}{} }()
+		if fail {
+			return errors.New("Jira is unreachable")
+		}
+		return nil
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	go d.Run(stop)
+	waitForSync(t, syncs)
+	waitForIdle(t, d)
+
+	if s := d.Status(); s.FailedSyncsCount != 1 || s.LastSyncError != "Jira is unreachable" {
+		t.Errorf("expected the failed sync to be reported, got %+v", s)
+	}
+
+	// The error is cleared by a successful sync
+	fail = false
+	d.Trigger()
+	waitForSync(t, syncs)
+	waitForIdle(t, d)
+	if s := d.Status(); s.FailedSyncsCount != 1 || s.LastSyncError != "" {
+		t.Errorf("expected the last sync to succeed, got %+v", s)
+	}
+}
+
+// waitForIdle waits for the sync in progress to be recorded.
+func waitForIdle(t *testing.T, d *daemon.Daemon) {
+	for i := 0; d.Status().State == daemon.StateSyncing; i++ {
+		if i == 100 {
+			t.Fatalf("expected the sync to complete")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
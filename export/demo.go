@@ -0,0 +1,277 @@
+// Package export implements the exports performed by the `export`
+// action on the data in the store.
+package export
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// DemoStore is the interface of the store used by `Demo`. It's
+// implemented by `store.PGStore`.
+type DemoStore interface {
+	EachIssueState(fn func(is store.IssueState) error) error
+	EachIssueEvent(fn func(ie store.IssueEvent) error) error
+	GetLinks(linkTypes []string) ([]store.IssueLink, error)
+}
+
+// Demo exports an obfuscated copy of the issue states, events and
+// links in the store to CSV files in `dir`, to be used for demos and
+// public benchmarks.
+//
+// Files are partitioned by project and month (links by project
+// only) and written in parallel, rolling to a new file when a file
+// exceeds the maximum size (see `PartitionOptions`), e.g.:
+//
+//	jira_issues_states/project=Project_1/month=2019-03/part-0001.csv
+//	jira_issue_links/project=Project_1/part-0001.csv
+//
+// The month is the one of the issue's creation for states and of the
+// event for events. The columns of the files are the ones of the
+// corresponding tables, so they can be loaded with `COPY <table>
+// (<columns>) FROM <file> WITH CSV HEADER`.
+//
+// The values are obfuscated with `o`, those of the custom fields
+// `cfs` according to their type (e.g. user names as users). A
+// `Manifest` describing the files is written to `manifest.json`.
+func Demo(s DemoStore, o Obfuscator, dir string, opts PartitionOptions, cfs []config.CustomField) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	m := newManifest("export demo", true)
+
+	// Projects of the issues (obfuscated) to partition events and
+	// links, and issue columns of their events
+	projects := make(map[string]string)
+	issues := make(map[string][]string)
+
+	columns := statesColumns
+	for _, c := range mapping.CustomColumns(cfs) {
+		columns = append(columns, Column{c.Name, c.Type, true})
+	}
+	err := writeTable(dir, "jira_issues_states", columns, opts, m, func(write writeFunc) error {
+		return s.EachIssueState(func(is store.IssueState) error {
+			r := stateRecord(is, o, cfs)
+			projects[is.Key] = r[3]
+			issues[is.Key] = eventIssueRecord(r)
+			return write(partition{project: r[3], month: month(r[0])}, r)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	err = writeTable(dir, "jira_issues_events", eventsColumns, opts, m, func(write writeFunc) error {
+		return s.EachIssueEvent(func(ie store.IssueEvent) error {
+			r := eventRecord(ie, o, issues[ie.IssueKey])
+			return write(partition{project: projects[ie.IssueKey], month: month(r[0])}, r)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	err = writeTable(dir, "jira_issue_links", linksColumns, opts, m, func(write writeFunc) error {
+		links, err := s.GetLinks(nil)
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			r := []string{o.IssueKey(l.SourceKey), o.IssueKey(l.TargetKey), l.LinkType, l.Direction}
+			if err = write(partition{project: projects[l.SourceKey]}, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return m.write(dir)
+}
+
+type writeFunc func(p partition, record []string) error
+
+// writeTable writes the records of the table, generated by calling
+// `fn`, with a `partitionedWriter`. The written files are added to
+// the manifest.
+func writeTable(dir, table string, columns []Column, opts PartitionOptions, m *Manifest, fn func(write writeFunc) error) error {
+	w := newPartitionedWriter(dir, table, columns, opts)
+	err := fn(w.Write)
+	files, cerr := w.Close()
+	if err != nil {
+		return err
+	}
+	if cerr != nil {
+		return cerr
+	}
+	m.Files = append(m.Files, files...)
+	return nil
+}
+
+// month returns the month (e.g. "2019-03") of a time formatted with
+// `formatTime`.
+func month(t string) string {
+	if len(t) < 7 {
+		return ""
+	}
+	return t[:7]
+}
+
+var statesColumns = []Column{
+	{"issue_created_at", "TIMESTAMP", false},
+	{"issue_updated_at", "TIMESTAMP", false},
+	{"issue_key", "TEXT", false},
+	{"issue_project", "TEXT", false},
+	{"issue_status", "TEXT", false},
+	{"issue_status_category", "TEXT", true},
+	{"issue_resolved_at", "TIMESTAMP", true},
+	{"issue_priority", "TEXT", false},
+	{"issue_summary", "TEXT", false},
+	{"issue_description", "TEXT", true},
+	{"issue_type", "TEXT", false},
+	{"issue_labels", "TEXT", true},
+	{"issue_assignee", "TEXT", true},
+	{"issue_epic", "TEXT", true},
+	{"issue_sprints", "TEXT", true},
+	{"issue_components", "TEXT", true},
+	{"issue_fix_versions", "TEXT", true},
+}
+
+func stateRecord(is store.IssueState, o Obfuscator, cfs []config.CustomField) []string {
+	r := []string{
+		formatTime(o.Time(is.CreatedAt)),
+		formatTime(o.Time(is.UpdatedAt)),
+		o.IssueKey(is.Key),
+		optional(is.Project, o.Project),
+		optional(is.Status, nil),
+		optional(is.StatusCategory, nil),
+		optionalTime(is.ResolvedAt, o),
+		optional(is.Priority, nil),
+		optional(is.Summary, o.Text),
+		optional(is.Description, o.Text),
+		optional(is.Type, nil),
+		optional(is.Labels, valueOf(o, "label")),
+		optional(is.Assignee, o.User),
+		optional(is.Epic, o.IssueKey),
+		optional(is.Sprints, valueOf(o, "sprint")),
+		optional(is.Components, valueOf(o, "component")),
+		optional(is.FixVersions, valueOf(o, "version")),
+	}
+	for _, cf := range cfs {
+		r = append(r, customValue(is.CustomFields[cf.Column], cf, o))
+	}
+	return r
+}
+
+// customValue returns the value of a custom field, obfuscated
+// according to the field's type, or an empty string if the value
+// is nil.
+func customValue(v interface{}, cf config.CustomField, o Obfuscator) string {
+	switch v := v.(type) {
+	case *string:
+		switch cf.Type {
+		case mapping.CustomFieldUser:
+			return optional(v, o.User)
+		case mapping.CustomFieldText:
+			return optional(v, o.Text)
+		default:
+			return optional(v, valueOf(o, cf.Column))
+		}
+	case *float64:
+		if v != nil {
+			return strconv.FormatFloat(*v, 'f', -1, 64)
+		}
+	case *time.Time:
+		return optionalTime(v, o)
+	}
+	return ""
+}
+
+var eventsColumns = []Column{
+	{"event_time", "TIMESTAMP", false},
+	{"event_kind", "TEXT", false},
+	{"event_author", "TEXT", false},
+	{"issue_key", "TEXT", false},
+	{"issue_created_at", "TIMESTAMP", false},
+	{"issue_updated_at", "TIMESTAMP", false},
+	{"issue_project", "TEXT", false},
+	{"issue_status", "TEXT", false},
+	{"issue_priority", "TEXT", false},
+	{"issue_summary", "TEXT", false},
+	{"issue_type", "TEXT", false},
+	{"comment_body", "TEXT", true},
+	{"status_change_from", "TEXT", true},
+	{"status_change_to", "TEXT", true},
+	{"status_change_reason", "TEXT", true},
+	{"assignee_change_from", "TEXT", true},
+	{"assignee_change_to", "TEXT", true},
+}
+
+// eventIssueRecord returns the issue columns of `eventsColumns`
+// (from `issue_created_at` to `issue_type`) of the issue, from its
+// record of `stateRecord`.
+func eventIssueRecord(state []string) []string {
+	return []string{state[0], state[1], state[3], state[4], state[7], state[8], state[10]}
+}
+
+// eventRecord returns the record of the event, with the issue
+// columns of its issue (see `eventIssueRecord`). If the issue is
+// not exported, the event time is used as its creation and update
+// times, and its other issue columns are empty.
+func eventRecord(ie store.IssueEvent, o Obfuscator, issue []string) []string {
+	t := formatTime(o.Time(ie.EventTime))
+	if issue == nil {
+		issue = []string{t, t, "", "", "", "", ""}
+	}
+	r := []string{t, string(ie.EventKind), o.User(ie.EventAuthor), o.IssueKey(ie.IssueKey)}
+	r = append(r, issue...)
+	return append(r,
+		optional(ie.CommentBody, o.Text),
+		optional(ie.StatusChangeFrom, nil),
+		optional(ie.StatusChangeTo, nil),
+		optional(ie.StatusChangeReason, o.Text),
+		optional(ie.AssigneeChangeFrom, o.User),
+		optional(ie.AssigneeChangeTo, o.User),
+	)
+}
+
+var linksColumns = []Column{
+	{"source_key", "TEXT", false},
+	{"target_key", "TEXT", false},
+	{"link_type", "TEXT", false},
+	{"direction", "TEXT", false},
+}
+
+// optional returns the value obfuscated with `fn` (if not nil), or
+// an empty string (NULL for `COPY`) if the value is nil.
+func optional(v *string, fn func(string) string) string {
+	switch {
+	case v == nil:
+		return ""
+	case fn == nil:
+		return *v
+	default:
+		return fn(*v)
+	}
+}
+
+func optionalTime(t *time.Time, o Obfuscator) string {
+	if t == nil {
+		return ""
+	}
+	return formatTime(o.Time(*t))
+}
+
+func valueOf(o Obfuscator, field string) func(string) string {
+	return func(v string) string {
+		return o.Value(field, v)
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
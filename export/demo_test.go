@@ -0,0 +1,320 @@
+package export_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/export"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+type demoStoreMock struct {
+	states []store.IssueState
+	events []store.IssueEvent
+	links  []store.IssueLink
+}
+
+func (s *demoStoreMock) EachIssueState(fn func(is store.IssueState) error) error {
+	for _, is := range s.states {
+		if err := fn(is); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *demoStoreMock) EachIssueEvent(fn func(ie store.IssueEvent) error) error {
+	for _, ie := range s.events {
+		if err := fn(ie); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *demoStoreMock) GetLinks(linkTypes []string) ([]store.IssueLink, error) {
+	return s.links, nil
+}
+
+func TestDemo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	created := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	project, summary, assignee := "Secret Project", "Fix the secret thing", "alice"
+	s := &demoStoreMock{
+		states: []store.IssueState{
+			{Key: "SEC-1", CreatedAt: created, UpdatedAt: created.Add(time.Hour), Project: &project, Summary: &summary, Assignee: &assignee,
+				CustomFields: map[string]interface{}{"issue_reviewer": &assignee}},
+			{Key: "SEC-2", CreatedAt: created, UpdatedAt: created, Project: &project},
+		},
+		events: []store.IssueEvent{
+			{IssueKey: "SEC-2", EventKind: "created", EventAuthor: "alice", EventTime: created},
+		},
+		links: []store.IssueLink{
+			{SourceKey: "SEC-2", TargetKey: "SEC-1", LinkType: "Blocks", Direction: store.LinkOutward},
+		},
+	}
+	if err = export.Demo(s, export.NewObfuscator(1), dir, export.PartitionOptions{Workers: 2}, mapping.DefaultCustomFields); err != nil {
+		t.Fatal(err)
+	}
+
+	// The manifest describes the files
+	m, err := export.ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.MapperVersion != mapping.Version || !m.Run.Obfuscated {
+		t.Errorf("unexpected manifest metadata %+v", m)
+	}
+	if len(m.Files) != 3 {
+		t.Fatalf("expected 3 files in the manifest, got %d", len(m.Files))
+	}
+	var states, events, links [][]string
+	for i, f := range m.Files {
+		records := readCSV(t, filepath.Join(dir, f.Path))
+		switch f.Table {
+		case "jira_issues_states":
+			states = records
+		case "jira_issues_events":
+			events = records
+		case "jira_issue_links":
+			links = records
+		}
+		if f.Rows != len(records)-1 {
+			t.Errorf("expected %d rows for `%s`, got %d", len(records)-1, f.Path, f.Rows)
+		}
+		if len(f.Columns) != len(records[0]) || f.Columns[0].Name != records[0][0] {
+			t.Errorf("expected the columns of `%s` to match its header %v, got %v", f.Path, records[0], f.Columns)
+		}
+		if f.Partition["project"] != "Project 1" {
+			t.Errorf("expected file %d to be partitioned by the obfuscated project, got %v", i, f.Partition)
+		}
+	}
+	if !strings.HasPrefix(m.Files[1].Path, "jira_issues_events/project=Project_1/month=") {
+		t.Errorf("expected events to be partitioned by project and month, got `%s`", m.Files[1].Path)
+	}
+
+	all := ""
+	for _, records := range [][][]string{states, events, links} {
+		for _, r := range records {
+			all += strings.Join(r, ",")
+		}
+	}
+	for _, secret := range []string{"SEC", "Secret", "alice", "2019-03-01"} {
+		if strings.Contains(all, secret) {
+			t.Errorf("expected `%s` to be obfuscated", secret)
+		}
+	}
+
+	// Relationships are preserved
+	if links[1][0] != states[2][2] || links[1][1] != states[1][2] {
+		t.Errorf("expected link keys %v to match issue keys %s and %s", links[1], states[2][2], states[1][2])
+	}
+	if events[1][2] != states[1][12] {
+		t.Errorf("expected event author `%s` to match assignee `%s`", events[1][2], states[1][12])
+	}
+	reviewer := -1
+	for i, c := range states[0] {
+		if c == "issue_reviewer" {
+			reviewer = i
+		}
+	}
+	if reviewer < 0 || states[1][reviewer] != states[1][12] {
+		t.Errorf("expected the `issue_reviewer` custom column to be obfuscated as a user like the assignee, got %v", states[1])
+	}
+
+	// Files are rolled when exceeding the maximum size
+	rolledDir := filepath.Join(dir, "rolled")
+	if err = export.Demo(s, export.NewObfuscator(1), rolledDir, export.PartitionOptions{MaxFileSize: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = export.ReadManifest(rolledDir); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 4 || !strings.HasSuffix(m.Files[1].Path, "part-0002.csv") {
+		t.Errorf("expected the 2 states to be written to 2 files, got %v", m.Files)
+	}
+
+	// Durations are preserved
+	c, _ := time.Parse(time.RFC3339, states[1][0])
+	u, _ := time.Parse(time.RFC3339, states[1][1])
+	if d := u.Sub(c); d != time.Hour {
+		t.Errorf("expected durations to be preserved, got %s", d)
+	}
+}
+
+// TestDemo_requiredColumns checks the files can be loaded with
+// `COPY`: their header has the required columns of the tables
+// created by `store.PGStore.CreateTables`, and the required text
+// columns are empty strings rather than NULL.
+func TestDemo_requiredColumns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	created := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := &demoStoreMock{
+		states: []store.IssueState{{Key: "SEC-1", CreatedAt: created, UpdatedAt: created}},
+		events: []store.IssueEvent{
+			{IssueKey: "SEC-1", EventKind: "created", EventAuthor: "alice", EventTime: created},
+			{IssueKey: "SEC-9", EventKind: "created", EventAuthor: "alice", EventTime: created},
+		},
+	}
+	if err = export.Demo(s, export.NewObfuscator(1), dir, export.PartitionOptions{Workers: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	m, err := export.ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	required := requiredColumns(t)
+	for _, f := range m.Files {
+		header := make(map[string]bool)
+		for _, c := range f.Columns {
+			header[c.Name] = true
+		}
+		for _, c := range required[f.Table] {
+			if !header[c] {
+				t.Errorf("expected the header of `%s` to have the required column `%s`", f.Path, c)
+			}
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, f.Path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Table == "jira_issues_events" && strings.Count(string(b), `,"",`) == 0 {
+			t.Errorf("expected the empty required columns of `%s` to be quoted, got %s", f.Path, b)
+		}
+	}
+}
+
+// requiredColumns returns the columns of the tables created by
+// `store.PGStore.CreateTables` which are `NOT NULL` without a
+// default value, by table.
+func requiredColumns(t *testing.T) map[string][]string {
+	sql.Register("demo-schema", &schemaDriver{})
+	db, err := sql.Open("demo-schema", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var queries []string
+	schemaQueries = &queries
+	if err = store.NewPGStore(db).CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+
+	table := regexp.MustCompile(`^CREATE TABLE (?:IF NOT EXISTS )?"([a-z_]+)"`)
+	column := regexp.MustCompile(`^\s*"([a-z_]+)" [A-Za-z0-9()]+ NOT NULL,?$`)
+	required := make(map[string][]string)
+	for _, q := range queries {
+		m := table.FindStringSubmatch(q)
+		if m == nil {
+			continue
+		}
+		for _, l := range strings.Split(q, "\n") {
+			if c := column.FindStringSubmatch(l); c != nil && !strings.Contains(l, "PRIMARY KEY") {
+				required[m[1]] = append(required[m[1]], c[1])
+			}
+		}
+	}
+	if len(required["jira_issues_events"]) == 0 {
+		t.Fatalf("expected required columns for `jira_issues_events`, got %v", required)
+	}
+	return required
+}
+
+// schemaQueries collects the statements executed through
+// `schemaDriver`.
+var schemaQueries *[]string
+
+// schemaDriver is a `database/sql` driver recording the statements
+// it executes in `schemaQueries`.
+type schemaDriver struct{}
+
+func (d *schemaDriver) Open(name string) (driver.Conn, error) { return d, nil }
+func (d *schemaDriver) Prepare(query string) (driver.Stmt, error) {
+	*schemaQueries = append(*schemaQueries, query)
+	return schemaStmt{}, nil
+}
+func (d *schemaDriver) Close() error              { return nil }
+func (d *schemaDriver) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type schemaStmt struct{}
+
+func (schemaStmt) Close() error  { return nil }
+func (schemaStmt) NumInput() int { return -1 }
+func (schemaStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+func (schemaStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func readCSV(t *testing.T, path string) [][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestDemo_maxOpenFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	created := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	projects := []string{"A", "B", "C"}
+	s := &demoStoreMock{}
+	// Interleaved, so the file of each project is suspended and
+	// resumed
+	for i := 0; i < 9; i++ {
+		s.states = append(s.states, store.IssueState{Key: fmt.Sprintf("SEC-%d", i), CreatedAt: created, UpdatedAt: created, Project: &projects[i%3]})
+	}
+	if err = export.Demo(s, export.NewObfuscator(1), dir, export.PartitionOptions{Workers: 1, MaxOpenFiles: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	m, err := export.ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files int
+	for _, f := range m.Files {
+		if f.Table != "jira_issues_states" {
+			continue
+		}
+		files++
+		if records := readCSV(t, filepath.Join(dir, f.Path)); f.Rows != 3 || len(records) != 4 || records[0][0] != "issue_created_at" {
+			t.Errorf("expected a header and 3 states in %s, got %d rows (%v)", f.Path, f.Rows, records)
+		}
+	}
+	if files != 3 {
+		t.Errorf("expected a file per project, got %v", m.Files)
+	}
+}
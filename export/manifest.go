@@ -0,0 +1,110 @@
+package export
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+)
+
+// ManifestFormatVersion is the version of the manifest format. It
+// must be incremented when the structure of `Manifest` changes.
+const ManifestFormatVersion = 1
+
+// ManifestFileName is the name of the manifest file written with
+// the exported files.
+const ManifestFileName = "manifest.json"
+
+// Manifest describes the files of an export, so downstream loaders
+// can validate their compatibility before loading them (e.g. check
+// the mapper version or the columns).
+type Manifest struct {
+	FormatVersion int            `json:"format_version"`
+	MapperVersion string         `json:"mapper_version"`
+	Run           RunMetadata    `json:"run"`
+	Files         []ManifestFile `json:"files"`
+}
+
+// RunMetadata describes the run which produced the export.
+type RunMetadata struct {
+	Command     string    `json:"command"`
+	Hostname    string    `json:"hostname"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	Obfuscated  bool      `json:"obfuscated"`
+}
+
+// ManifestFile describes an exported file.
+type ManifestFile struct {
+	// Path is relative to the manifest.
+	Path    string   `json:"path"`
+	Table   string   `json:"table"`
+	Format  string   `json:"format"`
+	Rows    int      `json:"rows"`
+	Columns []Column `json:"columns"`
+
+	// Partition contains the values of the partition keys of
+	// the records in the file (e.g. `project`, `month`).
+	Partition map[string]string `json:"partition,omitempty"`
+}
+
+// Column describes a column of an exported file. `Type` is the
+// Postgres type of the column in the source table.
+type Column struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+}
+
+// newManifest returns a manifest for an export started now by the
+// command.
+func newManifest(command string, obfuscated bool) *Manifest {
+	hostname, _ := os.Hostname()
+	return &Manifest{
+		FormatVersion: ManifestFormatVersion,
+		MapperVersion: mapping.Version,
+		Run: RunMetadata{
+			Command:    command,
+			Hostname:   hostname,
+			StartedAt:  time.Now(),
+			Obfuscated: obfuscated,
+		},
+		Files: make([]ManifestFile, 0),
+	}
+}
+
+// write completes the manifest and writes it to `dir`.
+func (m *Manifest) write(dir string) error {
+	m.Run.CompletedAt = time.Now()
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, ManifestFileName), append(b, '\n'), 0644)
+}
+
+// columnNames returns the names of the columns.
+func columnNames(cs []Column) []string {
+	names := make([]string, len(cs))
+	for i, c := range cs {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// ReadManifest reads the manifest in `dir`.
+func ReadManifest(dir string) (*Manifest, error) {
+	f, err := os.Open(filepath.Join(dir, ManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var m Manifest
+	if err = json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
@@ -0,0 +1,246 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// MLStore is the interface of the store used by `ML`. It's
+// implemented by `store.PGStore`.
+type MLStore interface {
+	EachIssueState(fn func(is store.IssueState) error) error
+	EachIssueEvent(fn func(ie store.IssueEvent) error) error
+	EachIssueMetrics(fn func(im store.IssueMetrics) error) error
+}
+
+// MLStatusCodesKey is the key of the metadata of the files written
+// by `ML` containing the codes of the statuses in the
+// `transition_sequence` column, as a JSON object.
+const MLStatusCodesKey = "status_codes"
+
+// mlColumns are the columns of the feature vectors written by `ML`.
+// Durations are in seconds.
+var mlColumns = []parquetColumn{
+	{name: "issue_key", typ: parquetByteArray},
+	{name: "issue_project", typ: parquetByteArray, nullable: true},
+	{name: "issue_type", typ: parquetByteArray, nullable: true},
+	{name: "issue_priority", typ: parquetByteArray, nullable: true},
+	{name: "issue_status", typ: parquetByteArray, nullable: true},
+	{name: "issue_created_at", typ: parquetInt64, timestamp: true},
+	{name: "issue_resolved_at", typ: parquetInt64, nullable: true, timestamp: true},
+	{name: "is_resolved", typ: parquetBoolean},
+	{name: "lead_time", typ: parquetInt64, nullable: true},
+	{name: "cycle_time", typ: parquetInt64, nullable: true},
+	{name: "time_to_first_assignment", typ: parquetInt64, nullable: true},
+	{name: "blocked_time", typ: parquetInt64},
+	{name: "reopenings", typ: parquetInt64},
+	{name: "events", typ: parquetInt64},
+	{name: "status_changes", typ: parquetInt64},
+	{name: "assignee_changes", typ: parquetInt64},
+	{name: "comments", typ: parquetInt64},
+	{name: "sprint_changes", typ: parquetInt64},
+	{name: "worklogs", typ: parquetInt64},
+	{name: "summary_length", typ: parquetInt64},
+	{name: "description_length", typ: parquetInt64},
+	{name: "comments_length", typ: parquetInt64},
+	{name: "transition_sequence", typ: parquetByteArray},
+}
+
+// mlFeatures are the features of an issue aggregated from its
+// state, events and metrics.
+type mlFeatures struct {
+	state   store.IssueState
+	metrics *store.IssueMetrics
+
+	events, statusChanges, assigneeChanges int64
+	comments, sprintChanges, worklogs      int64
+	commentsLength                         int64
+	transitions                            []int
+}
+
+// ML writes a feature vector per issue to `w` as a Parquet file, to
+// train machine learning models (e.g. to predict the lead time of
+// the issues) without querying the events table. The columns (see
+// `mlColumns`) are fixed, whatever the custom fields:
+//
+//   - the key, project, type, priority and status of the issue,
+//   - its creation and resolution times,
+//   - its metrics (see `store.IssueMetrics`), in seconds,
+//   - the number of events, and of events of each kind,
+//   - the lengths of the summary, description and comments,
+//   - `transition_sequence`, the statuses the issue went through,
+//     encoded as codes separated by "-" (e.g. "1-2-4"). The codes are
+//     stored as a JSON object in the file's metadata under
+//     `MLStatusCodesKey`.
+//
+// Events of issues not in the states (e.g. deleted ones) are
+// ignored. The issue keys and projects are obfuscated and the times
+// shifted with `o` if not nil, as by `Demo`.
+func ML(s MLStore, o Obfuscator, w io.Writer) error {
+	anonymized := o != nil
+	if !anonymized {
+		o = plainObfuscator{}
+	}
+
+	var keys []string
+	features := make(map[string]*mlFeatures)
+	err := s.EachIssueState(func(is store.IssueState) error {
+		keys = append(keys, is.Key)
+		features[is.Key] = &mlFeatures{state: is}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	codes := make(map[string]int)
+	code := func(status string) int {
+		c, ok := codes[status]
+		if !ok {
+			c = len(codes) + 1
+			codes[status] = c
+		}
+		return c
+	}
+	err = s.EachIssueEvent(func(ie store.IssueEvent) error {
+		f, ok := features[ie.IssueKey]
+		if !ok {
+			return nil
+		}
+		f.events++
+		switch ie.EventKind {
+		case store.EventStatusChanged:
+			f.statusChanges++
+			if len(f.transitions) == 0 && ie.StatusChangeFrom != nil {
+				f.transitions = append(f.transitions, code(*ie.StatusChangeFrom))
+			}
+			if ie.StatusChangeTo != nil {
+				f.transitions = append(f.transitions, code(*ie.StatusChangeTo))
+			}
+		case store.EventAssigneeChanged:
+			f.assigneeChanges++
+		case store.EventCommentAdded:
+			f.comments++
+			if ie.CommentLength != nil {
+				f.commentsLength += int64(*ie.CommentLength)
+			} else if ie.CommentBody != nil {
+				f.commentsLength += int64(len([]rune(*ie.CommentBody)))
+			}
+		case store.EventSprintAdded, store.EventSprintRemoved:
+			f.sprintChanges++
+		case store.EventWorklogAdded:
+			f.worklogs++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = s.EachIssueMetrics(func(im store.IssueMetrics) error {
+		if f, ok := features[im.IssueKey]; ok {
+			f.metrics = &im
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pw, err := newParquetWriter(w, mlColumns)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		f := features[k]
+		// Issues which never changed status went through their
+		// current status only
+		if len(f.transitions) == 0 && f.state.Status != nil {
+			f.transitions = append(f.transitions, code(*f.state.Status))
+		}
+		if err = pw.writeRow(mlRow(f, o)); err != nil {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(codes)
+	if err != nil {
+		return err
+	}
+	pw.metadata[MLStatusCodesKey] = string(b)
+	pw.metadata["mapper_version"] = mapping.Version
+	pw.metadata["obfuscated"] = strconv.FormatBool(anonymized)
+	return pw.close()
+}
+
+// mlRow returns the values of the `mlColumns` for the features.
+func mlRow(f *mlFeatures, o Obfuscator) []interface{} {
+	is := f.state
+	var resolvedAt interface{}
+	if is.ResolvedAt != nil {
+		resolvedAt = o.Time(*is.ResolvedAt)
+	}
+	var project interface{}
+	if is.Project != nil {
+		project = o.Project(*is.Project)
+	}
+	var leadTime, cycleTime, timeToFirstAssignment interface{}
+	var blockedTime, reopenings int64
+	if im := f.metrics; im != nil {
+		leadTime = snapshotSeconds(im.LeadTime)
+		cycleTime = snapshotSeconds(im.CycleTime)
+		timeToFirstAssignment = snapshotSeconds(im.TimeToFirstAssignment)
+		blockedTime = int64(im.BlockedTime.Seconds())
+		reopenings = int64(im.Reopenings)
+	}
+	transitions := make([]string, len(f.transitions))
+	for i, c := range f.transitions {
+		transitions[i] = strconv.Itoa(c)
+	}
+	return []interface{}{
+		o.IssueKey(is.Key),
+		project,
+		mlString(is.Type),
+		mlString(is.Priority),
+		mlString(is.Status),
+		o.Time(is.CreatedAt),
+		resolvedAt,
+		is.ResolvedAt != nil,
+		leadTime,
+		cycleTime,
+		timeToFirstAssignment,
+		blockedTime,
+		reopenings,
+		f.events,
+		f.statusChanges,
+		f.assigneeChanges,
+		f.comments,
+		f.sprintChanges,
+		f.worklogs,
+		mlLength(is.Summary),
+		mlLength(is.Description),
+		f.commentsLength,
+		strings.Join(transitions, "-"),
+	}
+}
+
+// mlString returns the value of the string, or nil if it's nil.
+func mlString(s *string) interface{} {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
+
+// mlLength returns the number of characters of the string, 0 if
+// it's nil.
+func mlLength(s *string) int64 {
+	if s == nil {
+		return 0
+	}
+	return int64(len([]rune(*s)))
+}
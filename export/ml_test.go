@@ -0,0 +1,234 @@
+package export_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/export"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+func TestML(t *testing.T) {
+	created := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	resolved := created.Add(48 * time.Hour)
+	project, status, summary, bug := "Secret Project", "Done", "Fix it", "Bug"
+	todo, doing, comment := "To Do", "In Progress", "Looks good"
+	leadTime := 48 * time.Hour
+	s := &snapshotStoreMock{
+		demoStoreMock: demoStoreMock{
+			states: []store.IssueState{
+				{Key: "SEC-1", CreatedAt: created, Project: &project, Status: &status, Summary: &summary, Type: &bug, ResolvedAt: &resolved},
+				{Key: "SEC-2", CreatedAt: created, Project: &project, Status: &todo},
+			},
+			events: []store.IssueEvent{
+				{IssueKey: "SEC-1", EventKind: store.EventCreated, EventTime: created},
+				{IssueKey: "SEC-1", EventKind: store.EventStatusChanged, StatusChangeFrom: &todo, StatusChangeTo: &doing},
+				{IssueKey: "SEC-1", EventKind: store.EventCommentAdded, CommentBody: &comment},
+				{IssueKey: "SEC-1", EventKind: store.EventStatusChanged, StatusChangeFrom: &doing, StatusChangeTo: &status},
+				{IssueKey: "SEC-3", EventKind: store.EventCreated},
+			},
+		},
+		metrics: []store.IssueMetrics{
+			{IssueKey: "SEC-1", Project: project, Type: bug, CreatedAt: created, LeadTime: &leadTime, Reopenings: 1},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := export.ML(s, nil, &buf); err != nil {
+		t.Fatal(err)
+	}
+	f := readParquet(t, buf.Bytes())
+
+	if rows := f.meta[3].(int64); rows != 2 {
+		t.Errorf("expected 2 rows, got %d", rows)
+	}
+	var codes map[string]int
+	if err := json.Unmarshal([]byte(f.metadata[export.MLStatusCodesKey]), &codes); err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 3 || codes["To Do"] != 1 || codes["In Progress"] != 2 || codes["Done"] != 3 {
+		t.Errorf("unexpected status codes %v", codes)
+	}
+
+	expected := map[string][]interface{}{
+		"issue_key":           {"SEC-1", "SEC-2"},
+		"issue_type":          {"Bug", nil},
+		"issue_created_at":    {created.Unix() * 1000, created.Unix() * 1000},
+		"issue_resolved_at":   {resolved.Unix() * 1000, nil},
+		"is_resolved":         {true, false},
+		"lead_time":           {int64(leadTime.Seconds()), nil},
+		"reopenings":          {int64(1), int64(0)},
+		"events":              {int64(4), int64(0)},
+		"status_changes":      {int64(2), int64(0)},
+		"comments":            {int64(1), int64(0)},
+		"comments_length":     {int64(10), int64(0)},
+		"summary_length":      {int64(6), int64(0)},
+		"transition_sequence": {"1-2-3", "1"},
+	}
+	for name, values := range expected {
+		got, ok := f.columns[name]
+		if !ok {
+			t.Errorf("missing column `%s`", name)
+			continue
+		}
+		for i, v := range values {
+			if got[i] != v {
+				t.Errorf("expected `%s` of row %d to be %v, got %v", name, i, v, got[i])
+			}
+		}
+	}
+}
+
+// parquetFile is a Parquet file decoded by `readParquet`: its file
+// metadata (`FileMetaData` as decoded by `thriftReader`), key/value
+// metadata and the values of its columns.
+type parquetFile struct {
+	meta     map[int16]interface{}
+	metadata map[string]string
+	columns  map[string][]interface{}
+}
+
+// readParquet decodes the files written by `export.ML`: flat
+// schemas, a single row group, one plain-encoded page per column
+// and RLE definition levels.
+func readParquet(t *testing.T, b []byte) parquetFile {
+	if len(b) < 12 || string(b[:4]) != "PAR1" || string(b[len(b)-4:]) != "PAR1" {
+		t.Fatal("missing Parquet magic")
+	}
+	n := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	r := &thriftReader{b: b[len(b)-8-n : len(b)-8]}
+	f := parquetFile{meta: r.readStruct(), metadata: map[string]string{}, columns: map[string][]interface{}{}}
+	for _, kv := range f.meta[5].([]interface{}) {
+		kv := kv.(map[int16]interface{})
+		f.metadata[string(kv[1].([]byte))] = string(kv[2].([]byte))
+	}
+
+	schema := f.meta[2].([]interface{})[1:]
+	rowGroups := f.meta[4].([]interface{})
+	if len(rowGroups) != 1 {
+		t.Fatalf("expected 1 row group, got %d", len(rowGroups))
+	}
+	chunks := rowGroups[0].(map[int16]interface{})[1].([]interface{})
+	for i, el := range schema {
+		el := el.(map[int16]interface{})
+		name := string(el[4].([]byte))
+		typ, optional := el[1].(int32), el[3].(int32) == 1
+		cm := chunks[i].(map[int16]interface{})[3].(map[int16]interface{})
+		offset := cm[9].(int64)
+
+		r := &thriftReader{b: b[offset:]}
+		header := r.readStruct()
+		numValues := int(header[5].(map[int16]interface{})[1].(int32))
+		data := r.b[r.pos : r.pos+int(header[2].(int32))]
+
+		defined := make([]bool, numValues)
+		for j := range defined {
+			defined[j] = true
+		}
+		if optional {
+			l := int(binary.LittleEndian.Uint32(data))
+			levels := &thriftReader{b: data[4 : 4+l]}
+			for j := 0; levels.pos < len(levels.b); {
+				run := int(levels.uvarint() >> 1)
+				v := levels.b[levels.pos]
+				levels.pos++
+				for k := 0; k < run; k, j = k+1, j+1 {
+					defined[j] = v == 1
+				}
+			}
+			data = data[4+l:]
+		}
+
+		var values []interface{}
+		var bit int
+		for _, d := range defined {
+			if !d {
+				values = append(values, nil)
+				continue
+			}
+			switch typ {
+			case 0:
+				values = append(values, data[bit/8]&(1<<uint(bit%8)) != 0)
+				bit++
+			case 2:
+				values = append(values, int64(binary.LittleEndian.Uint64(data)))
+				data = data[8:]
+			case 6:
+				l := int(binary.LittleEndian.Uint32(data))
+				values = append(values, string(data[4:4+l]))
+				data = data[4+l:]
+			}
+		}
+		f.columns[name] = values
+	}
+	return f
+}
+
+// thriftReader decodes structs encoded with the Thrift compact
+// protocol into maps of the values by field id.
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	s := make(map[int16]interface{})
+	var id int16
+	for {
+		h := r.b[r.pos]
+		r.pos++
+		if h == 0 {
+			return s
+		}
+		if delta := int16(h >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.varint())
+		}
+		s[id] = r.readValue(h & 0x0f)
+	}
+}
+
+func (r *thriftReader) readValue(typ byte) interface{} {
+	switch typ {
+	case 1, 2:
+		return typ == 1
+	case 5:
+		return int32(r.varint())
+	case 6:
+		return r.varint()
+	case 8:
+		n := int(r.uvarint())
+		r.pos += n
+		return r.b[r.pos-n : r.pos]
+	case 9:
+		h := r.b[r.pos]
+		r.pos++
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		l := make([]interface{}, n)
+		for i := range l {
+			l[i] = r.readValue(h & 0x0f)
+		}
+		return l
+	case 12:
+		return r.readStruct()
+	}
+	panic("unsupported Thrift type")
+}
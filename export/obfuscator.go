@@ -0,0 +1,144 @@
+package export
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Obfuscator obfuscates the values of the records exported by
+// `Demo` and `Snapshot`. Implementations must be consistent: the same input must
+// always give the same output, so the relationships between
+// records (e.g. links between issues, events of an issue, issues
+// of a user) are preserved.
+type Obfuscator interface {
+	// IssueKey obfuscates an issue key (e.g. "PJ-12").
+	IssueKey(k string) string
+
+	// Project obfuscates a project name.
+	Project(p string) string
+
+	// User obfuscates a user name.
+	User(name string) string
+
+	// Value obfuscates a categorical value of the specified
+	// field (e.g. a label, a component, a sprint).
+	Value(field, v string) string
+
+	// Text obfuscates a free text (e.g. summary, comment).
+	Text(s string) string
+
+	// Time obfuscates a time. Durations between times must be
+	// preserved for the metrics to be unchanged.
+	Time(t time.Time) time.Time
+}
+
+// DefaultObfuscator is the `Obfuscator` used by the `export demo`
+// and `export snapshot --anonymize` actions. It replaces identifiers
+// by sequential fake ones (e.g. "user-3", "DEMO1-12"), texts by
+// placeholder words and shifts all times back by the same random
+// duration.
+//
+// Statuses, issue types and priorities are not obfuscated since
+// they are generic and needed for the data to be meaningful.
+type DefaultObfuscator struct {
+	shift    time.Duration
+	prefixes map[string]string
+	numbers  map[string]int
+	values   map[string]map[string]string
+}
+
+// NewObfuscator returns a `DefaultObfuscator`. The times are shifted
+// back by a number of days between 30 and 394 chosen with the
+// specified seed.
+func NewObfuscator(seed int64) *DefaultObfuscator {
+	days := 30 + rand.New(rand.NewSource(seed)).Intn(365)
+	return &DefaultObfuscator{
+		shift:    -time.Duration(days) * 24 * time.Hour,
+		prefixes: make(map[string]string),
+		numbers:  make(map[string]int),
+		values:   make(map[string]map[string]string),
+	}
+}
+
+// IssueKey replaces the key's project prefix by a fake one (e.g.
+// "DEMO1") and its number by a sequential one within the project.
+func (o *DefaultObfuscator) IssueKey(k string) string {
+	return o.value("issue_key", k, func() string {
+		prefix := k
+		if i := strings.LastIndex(k, "-"); i >= 0 {
+			prefix = k[:i]
+		}
+		p, ok := o.prefixes[prefix]
+		if !ok {
+			p = fmt.Sprintf("DEMO%d", len(o.prefixes)+1)
+			o.prefixes[prefix] = p
+		}
+		o.numbers[p]++
+		return fmt.Sprintf("%s-%d", p, o.numbers[p])
+	})
+}
+
+// Project replaces the project name by "Project <n>".
+func (o *DefaultObfuscator) Project(p string) string {
+	return o.value("project", p, func() string {
+		return fmt.Sprintf("Project %d", len(o.values["project"])+1)
+	})
+}
+
+// User replaces the user name by "user-<n>". "N/A", used when there
+// is no user, is kept.
+func (o *DefaultObfuscator) User(name string) string {
+	if name == "N/A" {
+		return name
+	}
+	return o.value("user", name, func() string {
+		return fmt.Sprintf("user-%d", len(o.values["user"])+1)
+	})
+}
+
+// Value replaces the value by "<field>-<n>".
+func (o *DefaultObfuscator) Value(field, v string) string {
+	return o.value(field, v, func() string {
+		return fmt.Sprintf("%s-%d", field, len(o.values[field])+1)
+	})
+}
+
+// loremWords are the words used to replace texts.
+var loremWords = strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua")
+
+// Text replaces each word of the text by a placeholder word, so the
+// length of texts is roughly preserved.
+func (o *DefaultObfuscator) Text(s string) string {
+	n := len(strings.Fields(s))
+	words := make([]string, n)
+	for i := range words {
+		words[i] = loremWords[i%len(loremWords)]
+	}
+	return strings.Join(words, " ")
+}
+
+// Time shifts the time.
+func (o *DefaultObfuscator) Time(t time.Time) time.Time {
+	return t.Add(o.shift)
+}
+
+// value returns the obfuscated value of `v` for the field, calling
+// `fn` to generate it the first time.
+func (o *DefaultObfuscator) value(field, v string, fn func() string) string {
+	if v == "" {
+		return v
+	}
+	values, ok := o.values[field]
+	if !ok {
+		values = make(map[string]string)
+		o.values[field] = values
+	}
+	if r, ok := values[v]; ok {
+		return r
+	}
+	r := fn()
+	values[v] = r
+	return r
+}
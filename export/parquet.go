@@ -0,0 +1,409 @@
+package export
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// parquetMagic starts and ends Parquet files.
+const parquetMagic = "PAR1"
+
+// parquetRowGroupSize is the number of rows buffered in memory
+// before a row group is written.
+const parquetRowGroupSize = 10000
+
+// Physical types, converted types, repetition types, encodings and
+// page types of the Parquet format (see `parquet.thrift`).
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetDataPage = 0
+)
+
+// Types of the Thrift compact protocol used to encode the page
+// headers and the file metadata.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// parquetColumn describes a column of a Parquet file written by
+// `parquetWriter`. Values of `parquetInt64` columns are `int64` or
+// `time.Time` (if `timestamp`, stored in milliseconds), values of
+// `parquetByteArray` columns are `string` and values of
+// `parquetBoolean` columns `bool`. Nil values are only allowed in
+// nullable columns.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	nullable  bool
+	timestamp bool
+}
+
+// parquetWriter writes rows to a Parquet file with a flat schema,
+// with plain encoding and no compression, so it doesn't depend on a
+// Parquet library. Rows are buffered and written by row groups of
+// `parquetRowGroupSize` rows; `close` writes the last row group and
+// the footer.
+type parquetWriter struct {
+	w         *bufio.Writer
+	offset    int64
+	columns   []parquetColumn
+	metadata  map[string]string
+	rows      [][]interface{}
+	rowGroups [][]byte
+	numRows   int64
+}
+
+func newParquetWriter(w io.Writer, columns []parquetColumn) (*parquetWriter, error) {
+	pw := &parquetWriter{
+		w:        bufio.NewWriter(w),
+		columns:  columns,
+		metadata: make(map[string]string),
+	}
+	if err := pw.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *parquetWriter) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
+
+// writeRow buffers a row, with a value for each column in order.
+func (pw *parquetWriter) writeRow(row []interface{}) error {
+	if len(row) != len(pw.columns) {
+		return fmt.Errorf("parquet: %d values for %d columns", len(row), len(pw.columns))
+	}
+	pw.rows = append(pw.rows, row)
+	if len(pw.rows) >= parquetRowGroupSize {
+		return pw.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group, with a single data
+// page per column.
+func (pw *parquetWriter) flush() error {
+	if len(pw.rows) == 0 {
+		return nil
+	}
+	var chunks thriftWriter
+	chunks.listHeader(len(pw.columns), thriftStruct)
+	var total int64
+	for i, c := range pw.columns {
+		data, err := pw.pageData(i, c)
+		if err != nil {
+			return err
+		}
+		var header thriftWriter
+		header.i32Field(1, parquetDataPage)
+		header.i32Field(2, int32(len(data)))
+		header.i32Field(3, int32(len(data)))
+		header.fieldHeader(5, thriftStruct)
+		header.i32Field(1, int32(len(pw.rows)))
+		header.i32Field(2, parquetPlain)
+		header.i32Field(3, parquetRLE)
+		header.i32Field(4, parquetRLE)
+		header.stop()
+		header.stop()
+
+		pageOffset := pw.offset
+		if err = pw.write(header.bytes()); err != nil {
+			return err
+		}
+		if err = pw.write(data); err != nil {
+			return err
+		}
+		size := int64(len(header.bytes()) + len(data))
+		total += size
+
+		// ColumnChunk
+		chunks.begin()
+		chunks.i64Field(2, pageOffset)
+		chunks.fieldHeader(3, thriftStruct)
+		chunks.i32Field(1, c.typ)
+		chunks.fieldHeader(2, thriftList)
+		chunks.listHeader(2, thriftI32)
+		chunks.varint(zigzag(parquetPlain))
+		chunks.varint(zigzag(parquetRLE))
+		chunks.fieldHeader(3, thriftList)
+		chunks.listHeader(1, thriftBinary)
+		chunks.binary(c.name)
+		chunks.i32Field(4, 0) // UNCOMPRESSED
+		chunks.i64Field(5, int64(len(pw.rows)))
+		chunks.i64Field(6, size)
+		chunks.i64Field(7, size)
+		chunks.i64Field(9, pageOffset)
+		chunks.stop()
+		chunks.stop()
+	}
+
+	// RowGroup
+	var rg thriftWriter
+	rg.fieldHeader(1, thriftList)
+	rg.buf = append(rg.buf, chunks.bytes()...)
+	rg.i64Field(2, total)
+	rg.i64Field(3, int64(len(pw.rows)))
+	rg.stop()
+	pw.rowGroups = append(pw.rowGroups, rg.bytes())
+	pw.numRows += int64(len(pw.rows))
+	pw.rows = pw.rows[:0]
+	return nil
+}
+
+// pageData returns the data of the page of the buffered values of
+// the `i`th column: the definition levels if the column is nullable,
+// followed by the non-nil values.
+func (pw *parquetWriter) pageData(i int, c parquetColumn) ([]byte, error) {
+	var levels, values []byte
+	var bits []bool
+	for _, r := range pw.rows {
+		v := r[i]
+		if c.nullable {
+			levels = appendLevel(levels, v != nil)
+		}
+		if v == nil {
+			if !c.nullable {
+				return nil, fmt.Errorf("parquet: nil value in required column `%s`", c.name)
+			}
+			continue
+		}
+		switch v := v.(type) {
+		case bool:
+			bits = append(bits, v)
+		case int64:
+			values = appendInt64(values, v)
+		case time.Time:
+			values = appendInt64(values, v.UnixNano()/int64(time.Millisecond))
+		case string:
+			values = appendInt32(values, uint32(len(v)))
+			values = append(values, v...)
+		default:
+			return nil, fmt.Errorf("parquet: unsupported value %v (%T) in column `%s`", v, v, c.name)
+		}
+	}
+	if c.typ == parquetBoolean {
+		values = make([]byte, (len(bits)+7)/8)
+		for j, b := range bits {
+			if b {
+				values[j/8] |= 1 << uint(j%8)
+			}
+		}
+	}
+	if !c.nullable {
+		return values, nil
+	}
+	data := appendInt32(nil, uint32(len(levels)))
+	data = append(data, levels...)
+	return append(data, values...), nil
+}
+
+// appendLevel appends a definition level (1 if the value is defined,
+// 0 if it's nil) to levels encoded as RLE runs with a bit width of 1:
+// the varint of the run length shifted by one, followed by the value
+// on one byte. The last run is extended if it has the same value.
+func appendLevel(levels []byte, defined bool) []byte {
+	var v byte
+	if defined {
+		v = 1
+	}
+	if len(levels) > 0 && levels[len(levels)-1] == v {
+		// Decode the length of the last run to extend it
+		start := len(levels) - 2
+		for start > 0 && levels[start-1]&0x80 != 0 {
+			start--
+		}
+		n, _ := binary.Uvarint(levels[start:])
+		levels = levels[:start]
+		levels = appendUvarint(levels, ((n>>1)+1)<<1)
+		return append(levels, v)
+	}
+	levels = appendUvarint(levels, 1<<1)
+	return append(levels, v)
+}
+
+// close writes the buffered rows and the footer of the file: the
+// file metadata, its length and the magic.
+func (pw *parquetWriter) close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+
+	var fm thriftWriter
+	fm.i32Field(1, 1)
+
+	// Schema: the root element followed by the columns
+	fm.fieldHeader(2, thriftList)
+	fm.listHeader(len(pw.columns)+1, thriftStruct)
+	fm.begin()
+	fm.binaryField(4, "schema")
+	fm.i32Field(5, int32(len(pw.columns)))
+	fm.stop()
+	for _, c := range pw.columns {
+		fm.begin()
+		fm.i32Field(1, c.typ)
+		repetition := int32(parquetRequired)
+		if c.nullable {
+			repetition = parquetOptional
+		}
+		fm.i32Field(3, repetition)
+		fm.binaryField(4, c.name)
+		switch {
+		case c.typ == parquetByteArray:
+			fm.i32Field(6, parquetUTF8)
+		case c.timestamp:
+			fm.i32Field(6, parquetTimestampMillis)
+		}
+		fm.stop()
+	}
+
+	fm.i64Field(3, pw.numRows)
+	fm.fieldHeader(4, thriftList)
+	fm.listHeader(len(pw.rowGroups), thriftStruct)
+	for _, rg := range pw.rowGroups {
+		fm.buf = append(fm.buf, rg...)
+	}
+
+	if len(pw.metadata) > 0 {
+		keys := make([]string, 0, len(pw.metadata))
+		for k := range pw.metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fm.fieldHeader(5, thriftList)
+		fm.listHeader(len(keys), thriftStruct)
+		for _, k := range keys {
+			fm.begin()
+			fm.binaryField(1, k)
+			fm.binaryField(2, pw.metadata[k])
+			fm.stop()
+		}
+	}
+	fm.binaryField(6, "kaizenizer-source-jira")
+	fm.stop()
+
+	if err := pw.write(fm.bytes()); err != nil {
+		return err
+	}
+	if err := pw.write(appendInt32(nil, uint32(len(fm.bytes())))); err != nil {
+		return err
+	}
+	if err := pw.write([]byte(parquetMagic)); err != nil {
+		return err
+	}
+	return pw.w.Flush()
+}
+
+// thriftWriter encodes structs with the Thrift compact protocol.
+// Fields must be written in increasing order of their ids, and each
+// struct terminated with `stop`. The last field id is tracked per
+// nested struct, started by `fieldHeader` or `begin`.
+type thriftWriter struct {
+	buf    []byte
+	lastID []int
+}
+
+func (t *thriftWriter) bytes() []byte { return t.buf }
+
+func (t *thriftWriter) varint(v uint64) { t.buf = appendUvarint(t.buf, v) }
+
+func (t *thriftWriter) fieldHeader(id int, typ byte) {
+	if len(t.lastID) == 0 {
+		t.lastID = append(t.lastID, 0)
+	}
+	last := &t.lastID[len(t.lastID)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta<<4)|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.varint(zigzag(int64(id)))
+	}
+	*last = id
+	if typ == thriftStruct {
+		t.lastID = append(t.lastID, 0)
+	}
+}
+
+func (t *thriftWriter) i32Field(id int, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64Field(id int, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binaryField(id int, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.binary(s)
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.varint(uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// listHeader starts a list of `n` elements of type `typ`. Each
+// element of a list of structs is written between `begin` and
+// `stop`.
+func (t *thriftWriter) listHeader(n int, typ byte) {
+	if n < 15 {
+		t.buf = append(t.buf, byte(n<<4)|typ)
+	} else {
+		t.buf = append(t.buf, 0xf0|typ)
+		t.varint(uint64(n))
+	}
+}
+
+// begin starts a struct which is an element of a list.
+func (t *thriftWriter) begin() { t.lastID = append(t.lastID, 0) }
+
+// stop ends the current struct.
+func (t *thriftWriter) stop() {
+	t.buf = append(t.buf, 0)
+	if len(t.lastID) > 0 {
+		t.lastID = t.lastID[:len(t.lastID)-1]
+	}
+}
+
+func zigzag(v int64) uint64 { return uint64((v << 1) ^ (v >> 63)) }
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(b, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func appendInt32(b []byte, v uint32) []byte {
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+func appendInt64(b []byte, v int64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], uint64(v))
+	return append(b, tmp[:]...)
+}
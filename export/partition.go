@@ -0,0 +1,349 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PartitionOptions configures how the exported files are
+// partitioned and written.
+type PartitionOptions struct {
+	// Workers is the number of goroutines writing files in
+	// parallel. Defaults to the number of CPUs.
+	Workers int
+
+	// MaxFileSize is the approximate size (in bytes) above which
+	// a new file is started for a partition. Defaults to
+	// `DefaultMaxFileSize`.
+	MaxFileSize int64
+
+	// MaxOpenFiles is the number of files each worker keeps open.
+	// The file of the partition which received no record for the
+	// longest time is closed when a file must be opened over it,
+	// and reopened to append to it if the partition receives
+	// records again. Defaults to `DefaultMaxOpenFiles`.
+	MaxOpenFiles int
+}
+
+// DefaultMaxFileSize is the default `PartitionOptions.MaxFileSize`.
+const DefaultMaxFileSize = 256 * 1024 * 1024
+
+// DefaultMaxOpenFiles is the default `PartitionOptions.MaxOpenFiles`.
+const DefaultMaxOpenFiles = 16
+
+// partition identifies the files a record is written to. `month`
+// (e.g. "2019-03") may be empty for tables not partitioned by month.
+type partition struct {
+	project string
+	month   string
+}
+
+// path returns the directory of the partition, relative to the
+// table's directory (e.g. `project=Project_1/month=2019-03`).
+func (p partition) path() string {
+	path := "project=" + slugify(p.project)
+	if p.month != "" {
+		path = filepath.Join(path, "month="+p.month)
+	}
+	return path
+}
+
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// slugify returns the value with characters unsafe in paths
+// replaced by `_`.
+func slugify(v string) string {
+	if v == "" {
+		return "_"
+	}
+	return unsafeChars.ReplaceAllString(v, "_")
+}
+
+// partitionedWriter writes the records of a table to CSV files in
+// `<dir>/<table>/<partition>/part-<n>.csv`, rolling to a new file
+// when a file exceeds the maximum size. Each file has a header.
+//
+// Records are dispatched to the workers by partition, so the files
+// of a partition are written by a single worker and records keep
+// their order within a partition.
+type partitionedWriter struct {
+	dir     string
+	table   string
+	columns []Column
+	maxSize int64
+	maxOpen int
+	rows    []chan partitionedRecord
+	wg      sync.WaitGroup
+
+	mutex sync.Mutex
+	files []ManifestFile
+	err   error
+}
+
+type partitionedRecord struct {
+	partition partition
+	record    []string
+}
+
+func newPartitionedWriter(dir, table string, columns []Column, opts PartitionOptions) *partitionedWriter {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	maxSize := opts.MaxFileSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFileSize
+	}
+	maxOpen := opts.MaxOpenFiles
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpenFiles
+	}
+	w := &partitionedWriter{
+		dir:     dir,
+		table:   table,
+		columns: columns,
+		maxSize: maxSize,
+		maxOpen: maxOpen,
+		rows:    make([]chan partitionedRecord, workers),
+	}
+	for i := range w.rows {
+		w.rows[i] = make(chan partitionedRecord, 100)
+		w.wg.Add(1)
+		go w.work(w.rows[i])
+	}
+	return w
+}
+
+// Write sends the record to the worker in charge of the partition.
+// Returns the first error encountered by a worker, if any.
+func (w *partitionedWriter) Write(p partition, record []string) error {
+	if err := w.error(); err != nil {
+		return err
+	}
+	h := fnv.New32a()
+	h.Write([]byte(p.project + "/" + p.month))
+	w.rows[int(h.Sum32()%uint32(len(w.rows)))] <- partitionedRecord{p, record}
+	return nil
+}
+
+// Close waits for the workers to write all records and returns the
+// description of the written files, sorted by path.
+func (w *partitionedWriter) Close() ([]ManifestFile, error) {
+	for _, c := range w.rows {
+		close(c)
+	}
+	w.wg.Wait()
+	if err := w.error(); err != nil {
+		return nil, err
+	}
+	sort.Slice(w.files, func(i, j int) bool { return w.files[i].Path < w.files[j].Path })
+	return w.files, nil
+}
+
+// work writes the records of the partitions of the worker. The files
+// open are in `open`, least recently written first, so those of the
+// idle partitions are suspended once there are more than `maxOpen`.
+func (w *partitionedWriter) work(rows chan partitionedRecord) {
+	defer w.wg.Done()
+	files := make(map[partition]*partFile)
+	var open []*partFile
+	for r := range rows {
+		if w.error() != nil {
+			continue // drain
+		}
+		f, ok := files[r.partition]
+		if ok && f.bytes >= w.maxSize {
+			open = removePartFile(open, f)
+			w.fail(w.closeFile(f))
+			ok = false
+		}
+		if !ok {
+			var err error
+			index := 1
+			if f != nil {
+				index = f.index + 1
+			}
+			if f, err = w.openFile(r.partition, index); err != nil {
+				w.fail(err)
+				continue
+			}
+			files[r.partition] = f
+		} else if f.file == nil {
+			if err := f.resume(w.dir); err != nil {
+				w.fail(err)
+				continue
+			}
+		}
+		open = append(removePartFile(open, f), f)
+		if len(open) > w.maxOpen {
+			w.fail(open[0].suspend())
+			open = open[1:]
+		}
+		w.fail(f.write(r.record))
+	}
+	for _, f := range files {
+		w.fail(w.closeFile(f))
+	}
+}
+
+func (w *partitionedWriter) openFile(p partition, index int) (*partFile, error) {
+	rel := filepath.Join(w.table, p.path(), fmt.Sprintf("part-%04d.csv", index))
+	path := filepath.Join(w.dir, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &partFile{
+		partition: p,
+		index:     index,
+		path:      rel,
+		file:      file,
+		csv:       bufio.NewWriter(file),
+		required:  make([]bool, len(w.columns)),
+	}
+	for i, c := range w.columns {
+		f.required[i] = !c.Nullable && c.Type == "TEXT"
+	}
+	if err = writeCSVRecord(f.csv, columnNames(w.columns), nil); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// removePartFile returns the files without `f`.
+func removePartFile(files []*partFile, f *partFile) []*partFile {
+	for i, o := range files {
+		if o == f {
+			return append(files[:i], files[i+1:]...)
+		}
+	}
+	return files
+}
+
+func (w *partitionedWriter) closeFile(f *partFile) error {
+	if f.file != nil {
+		if err := f.suspend(); err != nil {
+			return err
+		}
+	}
+	mf := ManifestFile{
+		Path:    filepath.ToSlash(f.path),
+		Table:   w.table,
+		Format:  "csv",
+		Rows:    f.rows,
+		Columns: w.columns,
+		Partition: map[string]string{
+			"project": f.partition.project,
+		},
+	}
+	if f.partition.month != "" {
+		mf.Partition["month"] = f.partition.month
+	}
+	w.mutex.Lock()
+	w.files = append(w.files, mf)
+	w.mutex.Unlock()
+	return nil
+}
+
+func (w *partitionedWriter) fail(err error) {
+	if err == nil {
+		return
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *partitionedWriter) error() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.err
+}
+
+// partFile is a file being written for a partition.
+type partFile struct {
+	partition partition
+	index     int
+	path      string
+
+	// file and csv are nil while the file is suspended (see
+	// `suspend`).
+	file *os.File
+	csv  *bufio.Writer
+	rows int
+
+	// required are the columns whose empty values are written as
+	// empty strings rather than NULL (see `writeCSVRecord`).
+	required []bool
+
+	// bytes is the approximate size of the records written,
+	// ignoring CSV quoting.
+	bytes int64
+}
+
+// suspend flushes and closes the file of an idle partition, until
+// `resume`.
+func (f *partFile) suspend() error {
+	err := f.csv.Flush()
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	f.file, f.csv = nil, nil
+	return err
+}
+
+// resume reopens the file closed by `suspend`, to append to it.
+func (f *partFile) resume(dir string) error {
+	file, err := os.OpenFile(filepath.Join(dir, f.path), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	f.file, f.csv = file, bufio.NewWriter(file)
+	return nil
+}
+
+func (f *partFile) write(record []string) error {
+	f.rows++
+	for _, v := range record {
+		f.bytes += int64(len(v)) + 1 // value and separator
+	}
+	return writeCSVRecord(f.csv, record, f.required)
+}
+
+// writeCSVRecord writes the record as a CSV line like `csv.Writer`,
+// except that the empty values of the `required` columns are quoted,
+// so `COPY` loads them as empty strings rather than NULL.
+func writeCSVRecord(w *bufio.Writer, record []string, required []bool) error {
+	for i, v := range record {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		quoted := strings.ContainsAny(v, ",\"\r\n") ||
+			(v != "" && (v[0] == ' ' || v[0] == '\t')) ||
+			v == `\.` ||
+			(v == "" && i < len(required) && required[i])
+		if !quoted {
+			w.WriteString(v)
+			continue
+		}
+		w.WriteByte('"')
+		w.WriteString(strings.Replace(v, `"`, `""`, -1))
+		w.WriteByte('"')
+	}
+	_, err := w.WriteString("\n")
+	return err
+}
@@ -0,0 +1,259 @@
+package export
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// SnapshotFormatSQLite is the format of the snapshots written by
+// `Snapshot`: a single SQLite DB file.
+const SnapshotFormatSQLite = "sqlite"
+
+// SnapshotStore is the interface of the store used by `Snapshot`.
+// It's implemented by `store.PGStore`.
+type SnapshotStore interface {
+	EachIssueState(fn func(is store.IssueState) error) error
+	EachIssueMetrics(fn func(im store.IssueMetrics) error) error
+	EachWeeklyStats(fn func(ws store.WeeklyStats) error) error
+}
+
+// Snapshot writes a read-only snapshot of the current issue states
+// and the metrics (`jira_issue_metrics`, `jira_issue_status_times`
+// and `jira_weekly_stats`) to `db`, a new SQLite DB opened with
+// `store.SQLiteDriver`, so it can be shared as a single file (e.g.
+// opened with Datasette).
+//
+// The tables have the columns of the corresponding Postgres tables,
+// with those of the custom fields `cfs` for the states, and a
+// `snapshot_info` table records the mapper version, the time of the
+// snapshot and whether it's anonymized.
+//
+// If `o` is not nil, the values are anonymized with it the same way
+// as by `Demo`. The records are written in a single transaction.
+func Snapshot(s SnapshotStore, db *sql.DB, o Obfuscator, cfs []config.CustomField) (err error) {
+	anonymized := o != nil
+	if !anonymized {
+		o = plainObfuscator{}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	columns := statesColumns
+	for _, c := range mapping.CustomColumns(cfs) {
+		columns = append(columns, Column{c.Name, c.Type, true})
+	}
+	insert, err := createSnapshotTable(tx, "jira_issues_states", columns)
+	if err != nil {
+		return err
+	}
+	err = s.EachIssueState(func(is store.IssueState) error {
+		r := stateRecord(is, o, cfs)
+		values := make([]interface{}, len(r))
+		for i, v := range r {
+			values[i] = v
+			if v == "" && columns[i].Nullable {
+				values[i] = nil
+			}
+		}
+		return insert(values)
+	})
+	if err != nil {
+		return err
+	}
+
+	insertMetrics, err := createSnapshotTable(tx, "jira_issue_metrics", metricsColumns)
+	if err != nil {
+		return err
+	}
+	insertStatusTime, err := createSnapshotTable(tx, "jira_issue_status_times", statusTimesColumns)
+	if err != nil {
+		return err
+	}
+	err = s.EachIssueMetrics(func(im store.IssueMetrics) error {
+		key, project := o.IssueKey(im.IssueKey), o.Project(im.Project)
+		err := insertMetrics([]interface{}{
+			key,
+			project,
+			im.Type,
+			formatTime(o.Time(im.CreatedAt)),
+			snapshotTime(im.StartedAt, o),
+			snapshotTime(im.DoneAt, o),
+			snapshotSeconds(im.LeadTime),
+			snapshotSeconds(im.CycleTime),
+			snapshotTime(im.FirstResponseAt, o),
+			snapshotSeconds(im.FirstResponseTime),
+			im.Reopenings,
+			snapshotTime(im.FirstAssignedAt, o),
+			snapshotSeconds(im.TimeToFirstAssignment),
+			snapshotSeconds(im.LeadTimeBusiness),
+			snapshotSeconds(im.CycleTimeBusiness),
+			snapshotSeconds(im.FirstResponseTimeBusiness),
+			snapshotSeconds(im.TimeToFirstAssignmentBusiness),
+			snapshotSeconds(&im.BlockedTime),
+			snapshotSeconds(im.BlockedTimeBusiness),
+			snapshotBool(im.FirstTimeRight),
+		})
+		if err != nil {
+			return err
+		}
+		for _, st := range im.StatusTimes {
+			if err = insertStatusTime([]interface{}{key, project, st.Status, snapshotSeconds(&st.Duration), snapshotSeconds(st.BusinessDuration)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	insert, err = createSnapshotTable(tx, "jira_weekly_stats", weeklyStatsColumns)
+	if err != nil {
+		return err
+	}
+	err = s.EachWeeklyStats(func(ws store.WeeklyStats) error {
+		return insert([]interface{}{
+			o.Time(ws.Week).Format("2006-01-02"),
+			o.Project(ws.Project),
+			ws.Type,
+			ws.Throughput,
+			snapshotSeconds(ws.CycleTimeP85),
+			snapshotSeconds(ws.CycleTimeP95),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	insert, err = createSnapshotTable(tx, "snapshot_info", snapshotInfoColumns)
+	if err != nil {
+		return err
+	}
+	return insert([]interface{}{mapping.Version, formatTime(time.Now().UTC()), anonymized})
+}
+
+var metricsColumns = []Column{
+	{"issue_key", "TEXT", false},
+	{"issue_project", "TEXT", false},
+	{"issue_type", "TEXT", false},
+	{"issue_created_at", "TIMESTAMP", false},
+	{"started_at", "TIMESTAMP", true},
+	{"done_at", "TIMESTAMP", true},
+	{"lead_time_seconds", "BIGINT", true},
+	{"cycle_time_seconds", "BIGINT", true},
+	{"first_response_at", "TIMESTAMP", true},
+	{"first_response_time_seconds", "BIGINT", true},
+	{"reopenings_count", "INTEGER", false},
+	{"first_assigned_at", "TIMESTAMP", true},
+	{"time_to_first_assignment_seconds", "BIGINT", true},
+	{"lead_time_business_seconds", "BIGINT", true},
+	{"cycle_time_business_seconds", "BIGINT", true},
+	{"first_response_time_business_seconds", "BIGINT", true},
+	{"time_to_first_assignment_business_seconds", "BIGINT", true},
+	{"blocked_time_seconds", "BIGINT", false},
+	{"blocked_time_business_seconds", "BIGINT", true},
+	{"first_time_right", "BOOLEAN", true},
+}
+
+var statusTimesColumns = []Column{
+	{"issue_key", "TEXT", false},
+	{"issue_project", "TEXT", false},
+	{"status", "TEXT", false},
+	{"duration_seconds", "BIGINT", false},
+	{"business_duration_seconds", "BIGINT", true},
+}
+
+var weeklyStatsColumns = []Column{
+	{"week", "DATE", false},
+	{"issue_project", "TEXT", false},
+	{"issue_type", "TEXT", false},
+	{"throughput", "INTEGER", false},
+	{"cycle_time_p85_seconds", "BIGINT", true},
+	{"cycle_time_p95_seconds", "BIGINT", true},
+}
+
+var snapshotInfoColumns = []Column{
+	{"mapper_version", "TEXT", false},
+	{"exported_at", "TIMESTAMP", false},
+	{"anonymized", "BOOLEAN", false},
+}
+
+// createSnapshotTable creates the table with the columns in the
+// transaction, and returns a function inserting a record in it.
+func createSnapshotTable(tx *sql.Tx, table string, columns []Column) (func(values []interface{}) error, error) {
+	defs := make([]string, len(columns))
+	names := make([]string, len(columns))
+	params := make([]string, len(columns))
+	for i, c := range columns {
+		defs[i] = fmt.Sprintf(`"%s" %s`, c.Name, c.Type)
+		if !c.Nullable {
+			defs[i] += " NOT NULL"
+		}
+		names[i] = fmt.Sprintf(`"%s"`, c.Name)
+		params[i] = "?"
+	}
+	q := fmt.Sprintf(`CREATE TABLE "%s" (%s);`, table, strings.Join(defs, ", "))
+	if _, err := tx.Exec(q); err != nil {
+		return nil, err
+	}
+	insert := fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES (%s);`, table, strings.Join(names, ", "), strings.Join(params, ", "))
+	return func(values []interface{}) error {
+		_, err := tx.Exec(insert, values...)
+		return err
+	}, nil
+}
+
+// snapshotTime returns the time formatted with `formatTime`, or nil
+// (NULL) if the time is nil.
+func snapshotTime(t *time.Time, o Obfuscator) interface{} {
+	if t == nil {
+		return nil
+	}
+	return formatTime(o.Time(*t))
+}
+
+// snapshotSeconds returns the number of seconds of the duration, or
+// nil (NULL) if the duration is nil.
+func snapshotSeconds(d *time.Duration) interface{} {
+	if d == nil {
+		return nil
+	}
+	return int64(d.Seconds())
+}
+
+// snapshotBool returns the value of the boolean, or nil (NULL) if it
+// is nil.
+func snapshotBool(b *bool) interface{} {
+	if b == nil {
+		return nil
+	}
+	return *b
+}
+
+// plainObfuscator is the `Obfuscator` of the snapshots which are
+// not anonymized: it returns the values unchanged.
+type plainObfuscator struct{}
+
+func (plainObfuscator) IssueKey(k string) string     { return k }
+func (plainObfuscator) Project(p string) string      { return p }
+func (plainObfuscator) User(name string) string      { return name }
+func (plainObfuscator) Value(field, v string) string { return v }
+func (plainObfuscator) Text(s string) string         { return s }
+func (plainObfuscator) Time(t time.Time) time.Time   { return t }
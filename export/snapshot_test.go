@@ -0,0 +1,113 @@
+package export_test
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/export"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+type snapshotStoreMock struct {
+	demoStoreMock
+	metrics []store.IssueMetrics
+	stats   []store.WeeklyStats
+}
+
+func (s *snapshotStoreMock) EachIssueMetrics(fn func(im store.IssueMetrics) error) error {
+	for _, im := range s.metrics {
+		if err := fn(im); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *snapshotStoreMock) EachWeeklyStats(fn func(ws store.WeeklyStats) error) error {
+	for _, ws := range s.stats {
+		if err := fn(ws); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSnapshot(t *testing.T) {
+	created := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	project, status, summary := "Secret Project", "Done", "Fix the secret thing"
+	leadTime := 2 * time.Hour
+	s := &snapshotStoreMock{
+		demoStoreMock: demoStoreMock{
+			states: []store.IssueState{
+				{Key: "SEC-1", CreatedAt: created, UpdatedAt: created, Project: &project, Status: &status, Summary: &summary},
+			},
+		},
+		metrics: []store.IssueMetrics{
+			{IssueKey: "SEC-1", Project: project, Type: "Story", CreatedAt: created, LeadTime: &leadTime,
+				StatusTimes: []store.StatusTime{{Status: "In Progress", Duration: time.Hour}}},
+		},
+		stats: []store.WeeklyStats{
+			{Week: created, Project: project, Type: "Story", Throughput: 1},
+		},
+	}
+
+	for _, anonymized := range []bool{false, true} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		var o export.Obfuscator
+		key, projectValue, summaryValue := "SEC-1", project, summary
+		if anonymized {
+			o = export.NewObfuscator(1)
+			key, projectValue, summaryValue = "DEMO1-1", "Project 1", "lorem ipsum dolor sit"
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`CREATE TABLE "jira_issues_states" \("issue_created_at" TIMESTAMP NOT NULL, (.+), "issue_status_category" TEXT, (.+), "issue_tribe" (.+)\);`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		args := make([]driver.Value, 17+len(mapping.DefaultCustomFields))
+		for i := range args {
+			args[i] = sqlmock.AnyArg()
+		}
+		// Empty optional values are NULL
+		args[2], args[3], args[5], args[8] = key, projectValue, nil, summaryValue
+		mock.ExpectExec(`INSERT INTO "jira_issues_states"`).
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE TABLE "jira_issue_metrics"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`CREATE TABLE "jira_issue_status_times"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		args = make([]driver.Value, 20)
+		for i := range args {
+			args[i] = sqlmock.AnyArg()
+		}
+		args[0], args[1], args[6], args[7], args[19] = key, projectValue, int64(7200), nil, nil
+		mock.ExpectExec(`INSERT INTO "jira_issue_metrics"`).
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO "jira_issue_status_times"`).
+			WithArgs(key, projectValue, "In Progress", int64(3600), nil).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE TABLE "jira_weekly_stats"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO "jira_weekly_stats"`).
+			WithArgs(sqlmock.AnyArg(), projectValue, "Story", 1, nil, nil).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`CREATE TABLE "snapshot_info"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO "snapshot_info"`).
+			WithArgs(mapping.Version, sqlmock.AnyArg(), anonymized).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		if err = export.Snapshot(s, db, o, mapping.DefaultCustomFields); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations (anonymized: %t): %s", anonymized, err)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,329 @@
+// Package generate implements the generation of synthetic data
+// performed by the `generate` action, to load-test dashboards and
+// develop reports without production data.
+package generate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+)
+
+// Custom fields read by the mapping (see `mapping.Fields`)
+const (
+	epicLinkField = "customfield_10009"
+	sprintField   = "customfield_10005"
+)
+
+// sprintLength is the length of the generated sprints. The first
+// sprint starts on `sprintsStart`, so the sprint of a time doesn't
+// depend on the generation options.
+const sprintLength = 14 * 24 * time.Hour
+
+var sprintsStart = time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+
+// project is a project of the generated issues.
+type project struct {
+	key  string
+	name string
+}
+
+var (
+	projects   = []project{{"PAY", "Payments"}, {"SRC", "Search"}, {"MOB", "Mobile"}}
+	developers = []string{"alice", "bob", "carol", "dave", "erin", "frank"}
+	reporters  = []string{"grace", "heidi", "ivan", "judy"}
+	words      = []string{"checkout", "search", "login", "cart", "payment", "profile", "filter", "notification", "export", "dashboard"}
+	verbs      = []string{"Fix", "Add", "Improve", "Refactor", "Remove"}
+)
+
+// epicsPerProject is the number of epics generated in each project,
+// created before the other issues.
+const epicsPerProject = 3
+
+// Options are the options of the generation.
+type Options struct {
+	// Issues is the number of generated issues.
+	Issues int
+
+	// Seed initializes the random generator. The same seed (with the
+	// same `End`) always generates the same issues.
+	Seed int64
+
+	// End is the time after which no change is generated. Issues are
+	// created during the year before, and their workflow is stopped
+	// at `End` (e.g. an issue still in progress).
+	End time.Time
+}
+
+// Issues returns synthetic issues with realistic changelogs: epics,
+// stories, bugs and tasks of a few projects, assigned to developers,
+// planned in sprints, and going through a workflow from "Open" to
+// "Done", with reopened bugs and comments.
+//
+// The issues are the same for the same options, so the generated
+// data can be used as fixtures.
+func Issues(o Options) []*jira.Issue {
+	r := rand.New(rand.NewSource(o.Seed))
+
+	// Creation times are sorted so the keys of each project are in
+	// the order of creation
+	created := make([]time.Time, o.Issues)
+	for k := range created {
+		created[k] = o.End.Add(-time.Duration(r.Int63n(int64(365 * 24 * time.Hour))))
+	}
+	sort.Slice(created, func(a, b int) bool { return created[a].Before(created[b]) })
+
+	issues := make([]*jira.Issue, 0, o.Issues)
+	numbers := make(map[string]int)
+	epics := make(map[string][]string)
+	for k, t := range created {
+		p := projects[r.Intn(len(projects))]
+		if k < epicsPerProject*len(projects) {
+			p = projects[k%len(projects)]
+		}
+		numbers[p.key]++
+		key := fmt.Sprintf("%s-%d", p.key, numbers[p.key])
+
+		var i *jira.Issue
+		switch {
+		case numbers[p.key] <= epicsPerProject:
+			i = newEpic(r, key, p, t, o.End)
+			epics[p.key] = append(epics[p.key], key)
+		default:
+			i = newIssue(r, key, p, t, o.End, epics[p.key])
+		}
+		issues = append(issues, i)
+	}
+	return issues
+}
+
+// newEpic returns an epic, which stays in progress for months.
+func newEpic(r *rand.Rand, key string, p project, created, end time.Time) *jira.Issue {
+	f := newFixture(r, key, p, created).
+		WithType("Epic").
+		WithSummary(fmt.Sprintf("%s %s", p.name, words[r.Intn(len(words))]))
+	w := workflow{f: f, r: r, at: created, end: end, status: "Open"}
+	w.transition("In Progress", 24*time.Hour, 7*24*time.Hour)
+	w.transition("Done", 60*24*time.Hour, 120*24*time.Hour)
+	return w.issue()
+}
+
+// newIssue returns a story, bug or task, in an epic of `epics` for
+// stories. Stories and tasks are planned in the sprint following
+// their creation, and carried over to the next sprint while not
+// done.
+func newIssue(r *rand.Rand, key string, p project, created, end time.Time, epics []string) *jira.Issue {
+	typ := "Story"
+	switch n := r.Intn(100); {
+	case n < 30:
+		typ = "Bug"
+	case n < 45:
+		typ = "Task"
+	}
+	f := newFixture(r, key, p, created).
+		WithType(typ).
+		WithSummary(fmt.Sprintf("%s %s", verbs[r.Intn(len(verbs))], words[r.Intn(len(words))]))
+	if typ == "Story" && len(epics) > 0 {
+		f.WithCustomField(epicLinkField, epics[r.Intn(len(epics))])
+	}
+
+	w := workflow{f: f, r: r, at: created, end: end, status: "Open"}
+	if typ != "Bug" {
+		w.plan(sprintAfter(created))
+	}
+	if !w.assign(developers[r.Intn(len(developers))], time.Hour, 3*24*time.Hour) {
+		return w.issue()
+	}
+	w.transition("In Progress", time.Hour, 8*24*time.Hour)
+	w.transition("In Review", time.Hour, 6*24*time.Hour)
+	w.transition("Done", time.Hour, 2*24*time.Hour)
+	if typ == "Bug" && r.Intn(100) < 15 {
+		w.transition("Reopened", 24*time.Hour, 5*24*time.Hour)
+		w.transition("In Progress", time.Hour, 2*24*time.Hour)
+		w.transition("Done", time.Hour, 3*24*time.Hour)
+	}
+	for n := r.Intn(4); n > 0; n-- {
+		at := created.Add(time.Duration(r.Int63n(int64(w.at.Sub(created)) + 1)))
+		f.WithComment(developers[r.Intn(len(developers))], fmt.Sprintf("Comment about the %s", words[r.Intn(len(words))]), at)
+	}
+	return w.issue()
+}
+
+// newFixture returns the fixture of an issue of the project created
+// at `created` by a random reporter.
+func newFixture(r *rand.Rand, key string, p project, created time.Time) *client.IssueFixture {
+	return client.NewIssueFixture(key).
+		WithProject(p.key, p.name).
+		WithReporter(reporters[r.Intn(len(reporters))]).
+		WithCreated(created).
+		WithUpdated(created)
+}
+
+// workflow adds the changes of an issue to its fixture, in
+// chronological order, until `end`.
+type workflow struct {
+	f        *client.IssueFixture
+	r        *rand.Rand
+	at       time.Time
+	end      time.Time
+	status   string
+	resolved *time.Time
+
+	// sprints are the sprints the issue was planned in, the last one
+	// being the current one.
+	sprints []int
+}
+
+// next returns a random time between `min` and `max` after the last
+// change, and false if it's after `end`, in which case the workflow
+// is stopped (the issue remaining in the sprint in progress at
+// `end`).
+func (w *workflow) next(min, max time.Duration) (time.Time, bool) {
+	at := w.at.Add(min + time.Duration(w.r.Int63n(int64(max-min))))
+	if at.After(w.end) {
+		w.carryOver(w.end)
+		w.at = w.end
+		return at, false
+	}
+	return at, true
+}
+
+// assign assigns the issue to the developer. Returns false if the
+// workflow is stopped.
+func (w *workflow) assign(developer string, min, max time.Duration) bool {
+	at, ok := w.next(min, max)
+	if !ok {
+		return false
+	}
+	w.carryOver(at)
+	w.at = at
+	w.f.WithChangelog("assignee", "", developer, at).WithAssignee(developer)
+	return true
+}
+
+// transition moves the issue to the status. Does nothing if the
+// workflow is stopped.
+func (w *workflow) transition(status string, min, max time.Duration) {
+	if w.at.Equal(w.end) {
+		return
+	}
+	at, ok := w.next(min, max)
+	if !ok {
+		return
+	}
+	w.carryOver(at)
+	w.at = at
+	w.f.WithChangelog("status", w.status, status, at).WithStatus(status)
+	w.status = status
+	switch status {
+	case "Done":
+		w.resolved = &at
+	case "Reopened":
+		w.resolved = nil
+	}
+}
+
+// carryOver moves the issue to the next sprints while the current
+// one ends before `at`, at the start of each of them.
+func (w *workflow) carryOver(at time.Time) {
+	for len(w.sprints) > 0 && w.status != "Done" {
+		next := w.sprints[len(w.sprints)-1] + 1
+		if !sprintStart(next).Before(at) {
+			return
+		}
+		w.plan(next)
+	}
+}
+
+// plan moves the issue to the sprint, at the sprint's start or at
+// the last change if later. Does nothing if the sprint starts after
+// `end`.
+func (w *workflow) plan(sprint int) {
+	at := sprintStart(sprint)
+	if at.Before(w.at) {
+		at = w.at
+	}
+	if at.After(w.end) {
+		return
+	}
+	from := ""
+	if len(w.sprints) > 0 {
+		from = sprintName(w.sprints[len(w.sprints)-1])
+	}
+	w.sprints = append(w.sprints, sprint)
+	w.f.WithChangelog(client.SprintFieldName, from, sprintName(sprint), at)
+	w.at = at
+}
+
+// issue returns the issue built by the workflow, with its
+// resolution date and the sprints it was planned in.
+func (w *workflow) issue() *jira.Issue {
+	if w.resolved != nil {
+		w.f.WithResolved(*w.resolved)
+	}
+	if len(w.sprints) > 0 {
+		var sprints []interface{}
+		for _, s := range w.sprints {
+			state := "closed"
+			switch {
+			case sprintStart(s).After(w.end):
+				state = "future"
+			case sprintEnd(s).After(w.end):
+				state = "active"
+			}
+			sprints = append(sprints, map[string]interface{}{
+				"id":    float64(s),
+				"name":  sprintName(s),
+				"state": state,
+			})
+		}
+		w.f.WithCustomField(sprintField, sprints)
+	}
+	return w.f.Issue()
+}
+
+// sprintAfter returns the number of the sprint following the one in
+// progress at `t`.
+func sprintAfter(t time.Time) int {
+	return int(t.Sub(sprintsStart)/sprintLength) + 2
+}
+
+func sprintStart(sprint int) time.Time {
+	return sprintsStart.Add(time.Duration(sprint-1) * sprintLength)
+}
+
+func sprintEnd(sprint int) time.Time {
+	return sprintStart(sprint + 1)
+}
+
+func sprintName(sprint int) string {
+	return fmt.Sprintf("Sprint %d", sprint)
+}
+
+// WriteFixtures writes each issue as JSON to `<key>.json` in `dir`,
+// in the format of Jira API's issues (e.g. for `map-issue` or the
+// golden tests of the mapping).
+func WriteFixtures(dir string, issues []*jira.Issue) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, i := range issues {
+		b, err := json.MarshalIndent(i, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding issue `%s`: %s", i.Key, err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, i.Key+".json"), append(b, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package generate_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/generate"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+)
+
+func TestIssues(t *testing.T) {
+	end := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	o := generate.Options{Issues: 200, Seed: 42, End: end}
+	issues := generate.Issues(o)
+	if len(issues) != 200 {
+		t.Fatalf("expected 200 issues, got %d", len(issues))
+	}
+
+	// The same options generate the same issues
+	a, _ := json.Marshal(issues)
+	b, _ := json.Marshal(generate.Issues(o))
+	if string(a) != string(b) {
+		t.Errorf("expected the same issues to be generated with the same seed")
+	}
+
+	m := mapping.Mapper{}
+	kinds := make(map[string]int)
+	for _, i := range issues {
+		is := m.IssueStateFromIssue(i)
+		if is.UpdatedAt.After(end) || is.CreatedAt.After(is.UpdatedAt) {
+			t.Errorf("unexpected times for `%s`: created %s, updated %s", i.Key, is.CreatedAt, is.UpdatedAt)
+		}
+		for _, e := range m.IssueEventsFromIssue(i) {
+			if !e.EventKind.IsValid() {
+				t.Errorf("invalid event kind `%s` for `%s`", e.EventKind, i.Key)
+			}
+			if e.EventTime.After(end) {
+				t.Errorf("unexpected %s event after the end for `%s`: %s", e.EventKind, i.Key, e.EventTime)
+			}
+			kinds[string(e.EventKind)]++
+		}
+	}
+	for _, k := range []string{"created", "status_changed", "assignee_changed", "comment_added", "sprint_added", "sprint_removed"} {
+		if kinds[k] == 0 {
+			t.Errorf("expected `%s` events to be generated, got %v", k, kinds)
+		}
+	}
+}
+
+func TestWriteFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	issues := generate.Issues(generate.Options{Issues: 5, Seed: 1, End: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)})
+	if err = generate.WriteFixtures(dir, issues); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range issues {
+		f, err := os.Open(filepath.Join(dir, i.Key+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := mapping.DecodeIssue(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := mapping.Mapper{}
+		if len(m.IssueEventsFromIssue(decoded)) != len(m.IssueEventsFromIssue(i)) {
+			t.Errorf("expected the fixture of `%s` to be mapped like the generated issue", i.Key)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package jira
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// APIUsageReporter is implemented by clients measuring their
+// consumption of Jira API (e.g. `APIClient`).
+type APIUsageReporter interface {
+	APIUsage() client.Usage
+}
+
+// APIUsageStore is implemented by stores recording the consumption
+// of Jira API by the sync runs (e.g. `store.PGStore`).
+type APIUsageStore interface {
+	RecordSyncRunAPIUsage(id int64, u store.APIUsage) error
+}
+
+// trackAPIUsage measures the consumption of Jira API by the sync run
+// `runID` (not recorded if 0), if the client measures it. It returns
+// the function to call with the number of synced issues when the
+// sync is done, which logs the consumption and records it if the
+// store records it.
+func trackAPIUsage(c Client, s store.Store, runID int64) func(issuesCount int) {
+	r, ok := unfiltered(c).(APIUsageReporter)
+	if !ok {
+		return func(int) {}
+	}
+	before := r.APIUsage()
+	return func(issuesCount int) {
+		u := apiUsage(r.APIUsage().Sub(before), issuesCount)
+		logAPIUsage(u)
+		us, ok := s.(APIUsageStore)
+		if !ok || runID == 0 {
+			return
+		}
+		if err := us.RecordSyncRunAPIUsage(runID, u); err != nil {
+			logging.Errorf("Could not record the consumption of Jira API: %s", err)
+		}
+	}
+}
+
+// apiUsage returns the consumption of Jira API of a sync run which
+// synced `issuesCount` issues. The share of the quota is estimated
+// from the last quota announced by Jira.
+func apiUsage(cu client.Usage, issuesCount int) store.APIUsage {
+	u := store.APIUsage{Calls: cu.Total(), CallsByEndpoint: cu.Calls, Throttled: cu.Throttled}
+	if cu.Limit > 0 {
+		q := float64(u.Calls) / float64(cu.Limit)
+		u.QuotaUsed = &q
+	}
+	if issuesCount > 0 {
+		perIssue := float64(u.Calls) / float64(issuesCount)
+		u.CallsPerIssue = &perIssue
+	}
+	return u
+}
+
+// logAPIUsage logs the consumption of Jira API of a sync run, and
+// warns if requests were throttled.
+func logAPIUsage(u store.APIUsage) {
+	endpoints := make([]string, 0, len(u.CallsByEndpoint))
+	for e := range u.CallsByEndpoint {
+		endpoints = append(endpoints, e)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		ci, cj := u.CallsByEndpoint[endpoints[i]], u.CallsByEndpoint[endpoints[j]]
+		return ci > cj || ci == cj && endpoints[i] < endpoints[j]
+	})
+	details := make([]string, len(endpoints))
+	for i, e := range endpoints {
+		details[i] = fmt.Sprintf("%s: %d", e, u.CallsByEndpoint[e])
+	}
+	msg := fmt.Sprintf("Jira API: %d calls", u.Calls)
+	if u.CallsPerIssue != nil {
+		msg += fmt.Sprintf(", %.1f per issue", *u.CallsPerIssue)
+	}
+	if u.QuotaUsed != nil {
+		msg += fmt.Sprintf(", %.0f%% of the quota", *u.QuotaUsed*100)
+	}
+	if len(details) > 0 {
+		msg += " (" + strings.Join(details, ", ") + ")"
+	}
+	logging.Infof("%s", msg)
+	if u.Throttled > 0 {
+		logging.Warnf("%d requests to Jira API were throttled, consider lowering the concurrency or `JIRA_MAX_REQUESTS_PER_SECOND`", u.Throttled)
+	}
+}
@@ -0,0 +1,28 @@
+package jira
+
+import (
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// AssigneeIntervalsStore is implemented by stores deriving the
+// intervals during which the issues were assigned from their events
+// (e.g. `store.PGStore`).
+type AssigneeIntervalsStore interface {
+	RefreshAssigneeIntervals() (int64, error)
+}
+
+// refreshAssigneeIntervals refreshes the assignee intervals at the
+// end of a sync, if the store can (see `AssigneeIntervalsStore`).
+func refreshAssigneeIntervals(s store.Store) {
+	ais, ok := s.(AssigneeIntervalsStore)
+	if !ok {
+		return
+	}
+	n, err := ais.RefreshAssigneeIntervals()
+	if err != nil {
+		logging.Errorf("Could not refresh the assignee intervals: %s", err)
+		return
+	}
+	logging.Infof("%d assignee intervals refreshed", n)
+}
@@ -0,0 +1,51 @@
+package jira
+
+import (
+	"context"
+
+	"github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// PerformChangelogBackfill is the same as `PerformSyncForIssueKeys`,
+// but the whole changelog of each issue is fetched from the
+// changelog endpoint, whatever the number of histories returned with
+// the issue. It's meant to backfill the history of the issues whose
+// changelog seems truncated (see `store.IssueState.ChangelogTruncated`).
+// The client must be a `ChangelogFetcher`.
+func PerformChangelogBackfill(ctx context.Context, c Client, store store.Store, issueKeys []string, poolSize int, m Mapper) error {
+	return PerformSyncForIssueKeys(ctx, &deepHistoryClient{c}, store, issueKeys, poolSize, m)
+}
+
+// deepHistoryClient is a client replacing the changelog of the
+// issues with the one fetched from the changelog endpoint.
+type deepHistoryClient struct {
+	Client
+}
+
+// GetIssue fetches the issue with its whole changelog. The changelog
+// returned with the issue is kept if the other can't be fetched.
+func (c *deepHistoryClient) GetIssue(issueKey string) (*jira.Issue, error) {
+	i, err := c.Client.GetIssue(issueKey)
+	if err != nil {
+		return nil, err
+	}
+	cf, ok := unfiltered(c.Client).(ChangelogFetcher)
+	if !ok {
+		logging.Warnf("Could not fetch the whole changelog of issue %s: not supported by the client", issueKey)
+		return i, nil
+	}
+	histories, err := cf.GetChangelog(i.Key)
+	switch {
+	case err != nil:
+		logging.Warnf("Could not fetch the whole changelog of issue %s: %s", i.Key, err)
+	case len(histories) > 0:
+		if i.Changelog == nil {
+			i.Changelog = &jira.Changelog{}
+		}
+		i.Changelog.Histories = histories
+	}
+	return i, nil
+}
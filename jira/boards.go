@@ -0,0 +1,93 @@
+package jira
+
+import (
+	"github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// BoardsFetcher is implemented by clients able to fetch the boards
+// and sprints of Jira Agile (e.g. `APIClient`).
+type BoardsFetcher interface {
+	GetBoards() ([]jira.Board, error)
+	GetSprints(boardID int) ([]client.Sprint, error)
+}
+
+// BoardStore is implemented by stores recording the boards and
+// sprints (e.g. `store.PGStore`).
+type BoardStore interface {
+	ReplaceBoardsAndSprints(boards []store.Board, sprints []store.Sprint) error
+}
+
+// scrumBoardType is the type of the boards having sprints.
+const scrumBoardType = "scrum"
+
+// syncBoards fetches all the boards and their sprints and replaces
+// those in the store, if the client can fetch them and the store can
+// record them. The boards are not restricted by the client's
+// filter. The boards and sprints are left unchanged if fetching any
+// of them fails, so they are never partially replaced.
+func syncBoards(c Client, s store.Store) {
+	bf, ok := unfiltered(c).(BoardsFetcher)
+	if !ok {
+		return
+	}
+	bs, ok := s.(BoardStore)
+	if !ok {
+		return
+	}
+	jiraBoards, err := bf.GetBoards()
+	if err != nil {
+		logging.Errorf("Could not sync the boards: %s", err)
+		return
+	}
+
+	var boards []store.Board
+	var sprints []store.Sprint
+	seen := make(map[int]bool)
+	for _, b := range jiraBoards {
+		boards = append(boards, store.Board{ID: b.ID, Name: b.Name, Type: b.Type})
+		if b.Type != scrumBoardType {
+			continue
+		}
+		jiraSprints, err := bf.GetSprints(b.ID)
+		if err != nil {
+			logging.Errorf("Could not sync the boards: %s", err)
+			return
+		}
+		// A sprint is listed by every board displaying it
+		for _, sp := range jiraSprints {
+			if seen[sp.ID] {
+				continue
+			}
+			seen[sp.ID] = true
+			boardID := sp.OriginBoardID
+			if boardID == 0 {
+				boardID = b.ID
+			}
+			sprints = append(sprints, store.Sprint{
+				ID:           sp.ID,
+				BoardID:      boardID,
+				Name:         sp.Name,
+				State:        sp.State,
+				StartDate:    sp.StartDate,
+				EndDate:      sp.EndDate,
+				CompleteDate: sp.CompleteDate,
+				Goal:         sp.Goal,
+			})
+		}
+	}
+	if err := bs.ReplaceBoardsAndSprints(boards, sprints); err != nil {
+		logging.Errorf("Could not store the boards: %s", err)
+		return
+	}
+	logging.Infof("Synced %d boards and %d sprints", len(boards), len(sprints))
+	if err := PerformSprintReportsSync(c, s, false); err != nil {
+		logging.Errorf("Could not sync the sprint reports: %s", err)
+	}
+	if err := PerformSprintGoalsEvaluation(c, s, false); err != nil {
+		logging.Errorf("Could not evaluate the sprint goals: %s", err)
+	}
+}
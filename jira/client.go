@@ -1,7 +1,11 @@
 package jira
 
 import (
+	"time"
+
 	"github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
 )
 
 // Client is the interface for Jira clients used by the
@@ -11,7 +15,105 @@ import (
 //
 //   - `jira/client.APIClient`, which wraps `go-jira`'s client
 //   - `jira/client.MockClient`, a mock for tests
+//
+// Errors are returned once the client gave up retrying, e.g. the
+// syncs skip the issues which can't be fetched.
 type Client interface {
-	SearchIssues(query string, issueKeys chan string)
-	GetIssue(issueKey string) *jira.Issue
+	// SearchIssues sends the keys of the issues matching the JQL
+	// query through `issueKeys`, and closes it when done, even if
+	// the search fails.
+	SearchIssues(query string, issueKeys chan string) error
+
+	GetIssue(issueKey string) (*jira.Issue, error)
+}
+
+// ChangelogFetcher is implemented by clients able to fetch the
+// whole changelog of an issue (e.g. `APIClient`), the changelog
+// returned with the issue being truncated to its last histories.
+type ChangelogFetcher interface {
+	// GetChangelog returns the histories of the issue's changelog,
+	// sorted by time descending, or none if the Jira instance
+	// doesn't support it.
+	GetChangelog(issueKey string) ([]jira.ChangelogHistory, error)
+}
+
+// WorklogFetcher is implemented by clients able to fetch all the
+// worklogs of an issue (e.g. `APIClient`), the worklogs returned
+// with the issue being truncated to the first ones.
+type WorklogFetcher interface {
+	GetWorklogs(issueKey string) ([]jira.WorklogRecord, error)
+}
+
+// expandedChangelogLimit is the maximum number of histories in the
+// changelog returned with an issue. A changelog with as many
+// histories may be truncated.
+const expandedChangelogLimit = 100
+
+// getIssue fetches the issue with the client. If its changelog or
+// worklogs are truncated and the client is a `ChangelogFetcher` or
+// a `WorklogFetcher`, all of them are fetched, so that all the
+// changes and worklogs are mapped. The truncated ones are kept if
+// the others can't be fetched.
+func getIssue(c Client, issueKey string) (*jira.Issue, error) {
+	i, err := c.GetIssue(issueKey)
+	if err != nil {
+		return nil, err
+	}
+	completeChangelog(c, i)
+	completeWorklogs(c, i)
+	return i, nil
+}
+
+// completeChangelog replaces the issue's changelog with the whole
+// one if it may be truncated and the client is a
+// `ChangelogFetcher`.
+func completeChangelog(c Client, i *jira.Issue) {
+	cf, ok := unfiltered(c).(ChangelogFetcher)
+	if !ok || i.Changelog == nil || len(i.Changelog.Histories) < expandedChangelogLimit {
+		return
+	}
+	histories, err := cf.GetChangelog(i.Key)
+	switch {
+	case err != nil:
+		logging.Warnf("Could not fetch the whole changelog of issue %s, using the last %d histories: %s", i.Key, len(i.Changelog.Histories), err)
+	case len(histories) > 0:
+		i.Changelog.Histories = histories
+	}
+}
+
+// completeWorklogs replaces the issue's worklogs with all of them if
+// they are truncated and the client is a `WorklogFetcher`.
+func completeWorklogs(c Client, i *jira.Issue) {
+	wf, ok := unfiltered(c).(WorklogFetcher)
+	if !ok || i.Fields == nil || i.Fields.Worklog == nil || i.Fields.Worklog.Total <= len(i.Fields.Worklog.Worklogs) {
+		return
+	}
+	worklogs, err := wf.GetWorklogs(i.Key)
+	if err != nil {
+		logging.Warnf("Could not fetch all the worklogs of issue %s, using the first %d: %s", i.Key, len(i.Fields.Worklog.Worklogs), err)
+		return
+	}
+	i.Fields.Worklog.Worklogs = worklogs
+}
+
+// ClockSkewer is implemented by clients able to measure the clock
+// skew between Jira and the local clock (e.g. `APIClient`).
+type ClockSkewer interface {
+	// ClockSkew returns the skew (Jira time minus local time) if
+	// it's significant, 0 otherwise.
+	ClockSkew() time.Duration
+}
+
+// clockSkew returns the clock skew measured by the client, in
+// absolute value, or 0 if the client can't measure it.
+func clockSkew(c Client) time.Duration {
+	cs, ok := c.(ClockSkewer)
+	if !ok {
+		return 0
+	}
+	skew := cs.ClockSkew()
+	if skew < 0 {
+		return -skew
+	}
+	return skew
 }
@@ -0,0 +1,62 @@
+package client_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+)
+
+func TestAPIClient_GetIssue_Properties(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("properties")
+		w.Write([]byte(`{"key": "PJ-1", "fields": {"summary": "s"}, "properties": {"com.app.risk": {"score": 3}}}`))
+	}))
+	defer srv.Close()
+
+	c, err := client.NewAPIClientWithOptions(client.Options{
+		BaseURL:         srv.URL,
+		IssueProperties: []string{"com.app.risk", "com.app.owner"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := c.GetIssue("PJ-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "com.app.risk,com.app.owner" {
+		t.Errorf("expected the properties to be requested, got `%s`", query)
+	}
+	expected := map[string]interface{}{"com.app.risk": map[string]interface{}{"score": float64(3)}}
+	if p := i.Fields.Unknowns[client.PropertiesField]; !reflect.DeepEqual(p, expected) {
+		t.Errorf("expected the properties in the unknown fields, got %v", p)
+	}
+}
+
+func TestAPIClient_GetIssue_RenderedFields(t *testing.T) {
+	var expand string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expand = r.URL.Query().Get("expand")
+		w.Write([]byte(`{"key": "PJ-1", "fields": {"description": "*Bold*"}, "renderedFields": {"description": "<p><b>Bold</b></p>"}}`))
+	}))
+	defer srv.Close()
+
+	c, err := client.NewAPIClientWithOptions(client.Options{BaseURL: srv.URL, RenderedFields: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := c.GetIssue("PJ-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expand != "names,schema,changelog,renderedFields" {
+		t.Errorf("expected the rendered fields to be expanded, got `%s`", expand)
+	}
+	if i.RenderedFields == nil || i.RenderedFields.Description != "<p><b>Bold</b></p>" {
+		t.Errorf("expected the rendered description, got %v", i.RenderedFields)
+	}
+}
@@ -0,0 +1,353 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// The types of authentication of the requests to Jira API (see
+// `Auth`).
+const (
+	// AuthBasic authenticates with a username and a password (or an
+	// API token on Jira Cloud).
+	AuthBasic = "basic"
+
+	// AuthPAT authenticates with a personal access token of Jira
+	// Server or Data Center, sent as a bearer token.
+	AuthPAT = "pat"
+
+	// AuthOAuth2 authenticates with the access tokens of an OAuth 2.0
+	// (3LO) app of Jira Cloud, obtained with a refresh token.
+	AuthOAuth2 = "oauth2"
+)
+
+// DefaultOAuth2TokenURL is the URL of the token endpoint used when
+// `Auth.TokenURL` is not set.
+const DefaultOAuth2TokenURL = "https://auth.atlassian.com/oauth/token"
+
+// Auth configures the authentication of the requests to Jira API.
+// The credentials not set are read from the environment variables
+// specified for each field, so secrets can be kept out of the
+// configuration file.
+type Auth struct {
+	// Type is `AuthBasic` (the default), `AuthPAT` or `AuthOAuth2`.
+	Type string
+
+	// Username and Password are the credentials of `AuthBasic`
+	// (`JIRA_USERNAME` and `JIRA_PASSWORD`).
+	Username string
+	Password string
+
+	// Token is the personal access token of `AuthPAT`
+	// (`JIRA_TOKEN`).
+	Token string
+
+	// ClientID, ClientSecret and RefreshToken are the credentials of
+	// the OAuth 2.0 app of `AuthOAuth2` (`JIRA_OAUTH_CLIENT_ID`,
+	// `JIRA_OAUTH_CLIENT_SECRET` and `JIRA_OAUTH_REFRESH_TOKEN`),
+	// and TokenURL the URL its access tokens are obtained from
+	// (defaults to `DefaultOAuth2TokenURL`).
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+	TokenURL     string
+
+	// TokenFile is the file the refresh tokens rotated by the token
+	// endpoint are saved to (`JIRA_OAUTH_TOKEN_FILE`). If it exists,
+	// its refresh token is used instead of RefreshToken, so the next
+	// runs use the last one issued.
+	TokenFile string
+}
+
+// withEnv returns the auth with the credentials not set read from
+// the environment.
+func (a Auth) withEnv() Auth {
+	for _, f := range []struct {
+		value *string
+		env   string
+	}{
+		{&a.Username, "JIRA_USERNAME"},
+		{&a.Password, "JIRA_PASSWORD"},
+		{&a.Token, "JIRA_TOKEN"},
+		{&a.ClientID, "JIRA_OAUTH_CLIENT_ID"},
+		{&a.ClientSecret, "JIRA_OAUTH_CLIENT_SECRET"},
+		{&a.RefreshToken, "JIRA_OAUTH_REFRESH_TOKEN"},
+		{&a.TokenFile, "JIRA_OAUTH_TOKEN_FILE"},
+	} {
+		if *f.value == "" {
+			*f.value = os.Getenv(f.env)
+		}
+	}
+	return a
+}
+
+// transport returns the `http.RoundTripper` authenticating the
+// requests sent to `tr`. The OAuth 2.0 tokens are requested with
+// `base`, so they're not recorded by a `DebugTransport`.
+func (a Auth) transport(tr, base http.RoundTripper) (http.RoundTripper, error) {
+	a = a.withEnv()
+	switch a.Type {
+	case "", AuthBasic:
+		return &basicAuthTransport{Username: a.Username, Password: a.Password, Transport: tr}, nil
+	case AuthPAT:
+		if a.Token == "" {
+			return nil, errors.New("missing personal access token (JIRA_TOKEN)")
+		}
+		return &BearerTransport{Token: func() (string, error) { return a.Token, nil }, Transport: tr}, nil
+	case AuthOAuth2:
+		if a.TokenFile != "" {
+			token, err := readRefreshToken(a.TokenFile)
+			if err != nil {
+				return nil, err
+			}
+			if token != "" {
+				a.RefreshToken = token
+			}
+		}
+		if a.ClientID == "" || a.ClientSecret == "" || a.RefreshToken == "" {
+			return nil, errors.New("missing OAuth 2.0 client ID, client secret or refresh token (JIRA_OAUTH_*)")
+		}
+		ts := &OAuth2TokenSource{
+			ClientID:     a.ClientID,
+			ClientSecret: a.ClientSecret,
+			RefreshToken: a.RefreshToken,
+			TokenURL:     a.TokenURL,
+			TokenFile:    a.TokenFile,
+			Client:       &http.Client{Transport: base},
+		}
+		return &BearerTransport{Token: ts.Token, Transport: tr}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth type `%s`", a.Type)
+	}
+}
+
+// basicAuthTransport is an `http.RoundTripper` authenticating the
+// requests with basic auth.
+type basicAuthTransport struct {
+	Username  string
+	Password  string
+	Transport http.RoundTripper
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.SetBasicAuth(t.Username, t.Password)
+	return t.Transport.RoundTrip(r)
+}
+
+// BearerTransport is an `http.RoundTripper` authenticating the
+// requests with a bearer token, e.g. a personal access token of Jira
+// Server or an OAuth 2.0 access token.
+type BearerTransport struct {
+	// Token returns the token of the request.
+	Token func() (string, error)
+
+	Transport http.RoundTripper
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (t *BearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.Token()
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return t.Transport.RoundTrip(r)
+}
+
+// oauth2ExpiryMargin is the time before the expiry of an access token
+// from which it's renewed, so it doesn't expire while a request is in
+// flight.
+const oauth2ExpiryMargin = time.Minute
+
+// OAuth2TokenSource returns the access tokens of an OAuth 2.0 app,
+// obtained with its refresh token and renewed when they expire.
+//
+// The refresh token is replaced if the token endpoint returns a new
+// one (Atlassian rotates them, the previous one then expiring), and
+// saved to TokenFile if set. Without a token file, the rotated tokens
+// are kept in memory only, so the refresh token of the configuration
+// can't be used again once rotated.
+type OAuth2TokenSource struct {
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+
+	// TokenURL defaults to `DefaultOAuth2TokenURL`.
+	TokenURL string
+
+	// TokenFile is the file the rotated refresh tokens are saved to
+	// (see `Auth.TokenFile`), none if empty.
+	TokenFile string
+
+	// Client performs the token requests. Defaults to
+	// `http.DefaultClient` if nil.
+	Client *http.Client
+
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// Token returns a valid access token, requested from the token
+// endpoint if the previous one is about to expire.
+func (s *OAuth2TokenSource) Token() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.token != "" && time.Now().Add(oauth2ExpiryMargin).Before(s.expiresAt) {
+		return s.token, nil
+	}
+
+	u := s.TokenURL
+	if u == "" {
+		u = DefaultOAuth2TokenURL
+	}
+	c := s.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {s.ClientID},
+		"client_secret": {s.ClientSecret},
+		"refresh_token": {s.RefreshToken},
+	}
+	res, err := c.Post(u, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("error requesting OAuth 2.0 access token: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return "", fmt.Errorf("error requesting OAuth 2.0 access token: %s: %s", res.Status, body)
+	}
+	var t struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&t); err != nil {
+		return "", fmt.Errorf("error decoding OAuth 2.0 access token: %s", err)
+	}
+	if t.AccessToken == "" {
+		return "", errors.New("error requesting OAuth 2.0 access token: no token returned")
+	}
+	s.token = t.AccessToken
+	s.expiresAt = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+	if t.RefreshToken != "" && t.RefreshToken != s.RefreshToken {
+		s.RefreshToken = t.RefreshToken
+		if s.TokenFile != "" {
+			if err = writeRefreshToken(s.TokenFile, t.RefreshToken); err != nil {
+				// The access token is valid, the run can go on with the
+				// refresh token in memory
+				logging.Errorf("Failed to save the rotated OAuth 2.0 refresh token, the next runs won't be able to authenticate: %s", err)
+			}
+		}
+	}
+	return s.token, nil
+}
+
+// readRefreshToken returns the refresh token saved to the file by
+// `writeRefreshToken`, empty if the file doesn't exist yet.
+func readRefreshToken(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading OAuth 2.0 token file: %s", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// writeRefreshToken saves the refresh token to the file, readable by
+// its owner only. The token is written to a temporary file of the
+// same directory renamed once complete, so the file is never left
+// truncated.
+func writeRefreshToken(path, token string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.WriteString(token + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// TLS configures the TLS connections to Jira, e.g. for a Jira
+// Server behind a reverse proxy with a certificate signed by a
+// private CA.
+type TLS struct {
+	// CAPath is the path of a PEM file of certificates of the CAs
+	// trusted in addition to the system's.
+	CAPath string
+
+	// CertPath and KeyPath are the paths of the PEM files of the
+	// certificate and private key presented to Jira (or to the
+	// reverse proxy in front of it) by the client, if it requires
+	// mutual TLS. Both must be set.
+	CertPath string
+	KeyPath  string
+
+	// InsecureSkipVerify disables the verification of the
+	// certificate of the server. For tests only: the connections are
+	// then open to man-in-the-middle attacks.
+	InsecureSkipVerify bool
+}
+
+// transport returns the `http.Transport` of the TLS options, nil if
+// none is set (`http.DefaultTransport` is then used).
+func (o TLS) transport() (*http.Transport, error) {
+	if o.CAPath == "" && !o.InsecureSkipVerify && o.CertPath == "" && o.KeyPath == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+	if o.CAPath != "" {
+		pem, err := ioutil.ReadFile(o.CAPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %s", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA file `%s`", o.CAPath)
+		}
+		cfg.RootCAs = pool
+	}
+	if o.CertPath != "" || o.KeyPath != "" {
+		if o.CertPath == "" || o.KeyPath == "" {
+			return nil, errors.New("the client certificate requires both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertPath, o.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client certificate: %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = cfg
+	return tr, nil
+}
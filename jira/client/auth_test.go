@@ -0,0 +1,306 @@
+package client_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+)
+
+// permissionsHandler serves `mypermissions` under the path, recording
+// the authorization header of the requests.
+func permissionsHandler(path string, auth *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path+"/rest/api/2/mypermissions" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		*auth = append(*auth, r.Header.Get("Authorization"))
+		w.Write([]byte(`{"permissions": {"BROWSE_PROJECTS": {"havePermission": true}}}`))
+	})
+}
+
+func TestAPIClient_PersonalAccessToken(t *testing.T) {
+	var auth []string
+	srv := httptest.NewServer(permissionsHandler("/jira", &auth))
+	defer srv.Close()
+
+	// The base path of the reverse proxy is kept
+	c, err := client.NewAPIClientWithOptions(client.Options{
+		BaseURL: srv.URL + "/jira",
+		Auth:    client.Auth{Type: client.AuthPAT, Token: "pat-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.CanBrowseProject("PJ"); err != nil || !ok {
+		t.Fatalf("expected the project to be browsable, got %v (%v)", ok, err)
+	}
+	if len(auth) != 1 || auth[0] != "Bearer pat-1" {
+		t.Errorf("expected the token as bearer, got %v", auth)
+	}
+}
+
+func TestAPIClient_InvalidAuth(t *testing.T) {
+	os.Unsetenv("JIRA_TOKEN")
+	for _, a := range []client.Auth{{Type: "kerberos"}, {Type: client.AuthPAT}, {Type: client.AuthOAuth2, ClientID: "id"}} {
+		if _, err := client.NewAPIClientWithOptions(client.Options{Auth: a}); err == nil {
+			t.Errorf("expected an error for %v", a)
+		}
+	}
+}
+
+func TestOAuth2TokenSource(t *testing.T) {
+	var refreshTokens []string
+	// Expires within the margin, so it's renewed for each request
+	expiresIn := 30
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		refreshTokens = append(refreshTokens, r.Form.Get("refresh_token"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access-" + r.Form.Get("refresh_token"),
+			"refresh_token": "rotated",
+			"expires_in":    expiresIn,
+		})
+	}))
+	defer tokens.Close()
+
+	var auth []string
+	srv := httptest.NewServer(permissionsHandler("", &auth))
+	defer srv.Close()
+	c, err := client.NewAPIClientWithOptions(client.Options{
+		BaseURL: srv.URL,
+		Auth: client.Auth{
+			Type:         client.AuthOAuth2,
+			ClientID:     "id",
+			ClientSecret: "secret",
+			RefreshToken: "initial",
+			TokenURL:     tokens.URL,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = c.CanBrowseProject("PJ"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The rotated refresh token is used for the next access token
+	if len(refreshTokens) != 2 || refreshTokens[0] != "initial" || refreshTokens[1] != "rotated" {
+		t.Errorf("expected the tokens to be refreshed with [initial rotated], got %v", refreshTokens)
+	}
+	if len(auth) != 2 || auth[0] != "Bearer access-initial" || auth[1] != "Bearer access-rotated" {
+		t.Errorf("expected the access tokens as bearer, got %v", auth)
+	}
+
+	// A valid token is reused
+	expiresIn = 3600
+	ts := &client.OAuth2TokenSource{ClientID: "id", ClientSecret: "secret", RefreshToken: "r", TokenURL: tokens.URL}
+	refreshTokens = nil
+	for i := 0; i < 2; i++ {
+		if token, err := ts.Token(); err != nil || token != "access-r" {
+			t.Fatalf("expected token `access-r`, got `%s` (%v)", token, err)
+		}
+	}
+	if len(refreshTokens) != 1 {
+		t.Errorf("expected the token to be requested once, got %d requests", len(refreshTokens))
+	}
+}
+
+func TestOAuth2TokenSource_TokenFile(t *testing.T) {
+	var refreshTokens []string
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		refreshTokens = append(refreshTokens, r.Form.Get("refresh_token"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access",
+			"refresh_token": fmt.Sprintf("rotated-%d", len(refreshTokens)),
+			"expires_in":    3600,
+		})
+	}))
+	defer tokens.Close()
+	dir, err := ioutil.TempDir("", "oauth2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+
+	var auth []string
+	srv := httptest.NewServer(permissionsHandler("", &auth))
+	defer srv.Close()
+	for i := 0; i < 2; i++ {
+		// A new client for each run, configured with the same token
+		c, err := client.NewAPIClientWithOptions(client.Options{
+			BaseURL: srv.URL,
+			Auth: client.Auth{
+				Type:         client.AuthOAuth2,
+				ClientID:     "id",
+				ClientSecret: "secret",
+				RefreshToken: "initial",
+				TokenURL:     tokens.URL,
+				TokenFile:    path,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.CanBrowseProject("PJ"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The second run uses the token rotated by the first one
+	if len(refreshTokens) != 2 || refreshTokens[0] != "initial" || refreshTokens[1] != "rotated-1" {
+		t.Errorf("expected the tokens to be refreshed with [initial rotated-1], got %v", refreshTokens)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil || string(b) != "rotated-2\n" {
+		t.Errorf("expected the last rotated token to be saved, got %q (%v)", b, err)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Error(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("expected the token file to be readable by its owner only, got %v", fi.Mode())
+	}
+}
+
+func TestAPIClient_TLS(t *testing.T) {
+	var auth []string
+	srv := httptest.NewTLSServer(permissionsHandler("", &auth))
+	defer srv.Close()
+
+	// The certificate of the test server isn't trusted by default
+	c, err := client.NewAPIClientWithOptions(client.Options{BaseURL: srv.URL, RetryAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.CanBrowseProject("PJ"); err == nil {
+		t.Fatalf("expected an error with an untrusted certificate")
+	}
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caPath := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err = ioutil.WriteFile(caPath, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []client.TLS{{CAPath: caPath}, {InsecureSkipVerify: true}} {
+		c, err := client.NewAPIClientWithOptions(client.Options{BaseURL: srv.URL, TLS: o})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.CanBrowseProject("PJ"); err != nil {
+			t.Errorf("unexpected error with %v: %s", o, err)
+		}
+	}
+}
+
+func TestAPIClient_ClientCertificate(t *testing.T) {
+	var auth []string
+	srv := httptest.NewUnstartedServer(permissionsHandler("", &auth))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	// The server requires a client certificate
+	c, err := client.NewAPIClientWithOptions(client.Options{BaseURL: srv.URL, RetryAttempts: 1, TLS: client.TLS{InsecureSkipVerify: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.CanBrowseProject("PJ"); err == nil {
+		t.Fatalf("expected an error without client certificate")
+	}
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "agilizer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	if err = ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = client.NewAPIClientWithOptions(client.Options{BaseURL: srv.URL, TLS: client.TLS{CertPath: certPath}}); err == nil {
+		t.Errorf("expected an error with a certificate without key")
+	}
+	o := client.TLS{CertPath: certPath, KeyPath: keyPath, InsecureSkipVerify: true}
+	if c, err = client.NewAPIClientWithOptions(client.Options{BaseURL: srv.URL, TLS: o}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.CanBrowseProject("PJ"); err != nil {
+		t.Errorf("unexpected error with a client certificate: %s", err)
+	}
+}
+
+func TestAPIClient_Proxy(t *testing.T) {
+	// The proxy receives the requests with the absolute URL of the
+	// instance, which doesn't exist
+	var hosts, auth []string
+	handler := permissionsHandler("", &auth)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.URL.Host)
+		handler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	if _, err := client.NewAPIClientWithOptions(client.Options{BaseURL: "http://jira.invalid", Proxy: "://proxy"}); err == nil {
+		t.Errorf("expected an error with an invalid proxy URL")
+	}
+	c, err := client.NewAPIClientWithOptions(client.Options{BaseURL: "http://jira.invalid", Proxy: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.CanBrowseProject("PJ"); err != nil || !ok {
+		t.Fatalf("expected the project to be browsable through the proxy, got %v (%v)", ok, err)
+	}
+	if len(hosts) != 1 || hosts[0] != "jira.invalid" {
+		t.Errorf("expected the request to be sent through the proxy, got %v", hosts)
+	}
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// DefaultClockSkewThreshold is the clock skew between Jira and the
+// local clock above which a warning is logged. It can be changed
+// with the `JIRA_CLOCK_SKEW_THRESHOLD` environment variable (e.g.
+// `30s`).
+const DefaultClockSkewThreshold = time.Minute
+
+// ClockSkewTransport is an `http.RoundTripper` measuring the clock
+// skew between the Jira server and the local clock using the `Date`
+// header of the responses.
+//
+// A warning is logged the first time the skew exceeds `Threshold`,
+// since incremental syncs may miss issues when clocks disagree.
+type ClockSkewTransport struct {
+	// Transport is the underlying HTTP transport. Defaults to
+	// `http.DefaultTransport` if nil.
+	Transport http.RoundTripper
+
+	// Threshold is the skew above which a warning is logged.
+	Threshold time.Duration
+
+	mutex    sync.Mutex
+	skew     time.Duration
+	measured bool
+	warned   bool
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (t *ClockSkewTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr := t.Transport
+	if tr == nil {
+		tr = http.DefaultTransport
+	}
+	sent := time.Now()
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+	if serverTime, err := http.ParseTime(res.Header.Get("Date")); err == nil {
+		// The `Date` header has a precision of one second and is
+		// generated while processing the request, so it's compared
+		// to the middle of the round-trip.
+		local := sent.Add(time.Since(sent) / 2)
+		t.record(serverTime.Sub(local))
+	}
+	return res, nil
+}
+
+// Skew returns the last measured skew (Jira time minus local time)
+// and whether a skew has been measured yet.
+func (t *ClockSkewTransport) Skew() (time.Duration, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.skew, t.measured
+}
+
+// Exceeded returns true if the last measured skew, in absolute
+// value, exceeds `Threshold`.
+func (t *ClockSkewTransport) Exceeded() bool {
+	skew, _ := t.Skew()
+	return abs(skew) > t.Threshold
+}
+
+func (t *ClockSkewTransport) record(skew time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.skew = skew
+	t.measured = true
+	if abs(skew) > t.Threshold && !t.warned {
+		logging.Warnf("Jira clock is skewed by %s from the local clock (threshold: %s), incremental syncs will widen their window accordingly", skew, t.Threshold)
+		t.warned = true
+	}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,40 @@
+package client_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+)
+
+func TestClockSkewTransport(t *testing.T) {
+	offset := 10 * time.Minute
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+	}))
+	defer srv.Close()
+
+	tr := &client.ClockSkewTransport{Threshold: time.Minute}
+	if _, measured := tr.Skew(); measured {
+		t.Errorf("expected no skew to be measured before a request")
+	}
+
+	res, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	skew, measured := tr.Skew()
+	if !measured {
+		t.Fatalf("expected the skew to be measured")
+	}
+	if skew < offset-2*time.Second || skew > offset+2*time.Second {
+		t.Errorf("expected skew to be about %s, got %s", offset, skew)
+	}
+	if !tr.Exceeded() {
+		t.Errorf("expected skew to exceed the threshold")
+	}
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// ContextTransport is an `http.RoundTripper` performing the requests
+// with `Context`, go-jira performing them without one, so they are
+// cancelled when it's done (e.g. when the process is asked to shut
+// down) instead of running to completion.
+type ContextTransport struct {
+	// Transport is the underlying HTTP transport. Defaults to
+	// `http.DefaultTransport` if nil.
+	Transport http.RoundTripper
+
+	Context context.Context
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (t *ContextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr := t.Transport
+	if tr == nil {
+		tr = http.DefaultTransport
+	}
+	if t.Context == nil {
+		return tr.RoundTrip(req)
+	}
+	return tr.RoundTrip(req.WithContext(t.Context))
+}
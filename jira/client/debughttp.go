@@ -0,0 +1,202 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// DebugHTTPMaxSize is the size above which the file written by
+// `DebugTransport` is rotated.
+const DebugHTTPMaxSize = 10 * 1024 * 1024
+
+// DebugHTTPBackups is the number of rotated files kept by
+// `DebugTransport` (e.g. `http.log.1`, `http.log.2`).
+const DebugHTTPBackups = 3
+
+// sensitiveParams are the query parameters whose values are
+// redacted in the logged URLs.
+var sensitiveParams = []string{"password", "token", "secret", "key"}
+
+// HTTPExchange is the record written by `DebugTransport` for each
+// request to Jira API.
+type HTTPExchange struct {
+	Time         time.Time `json:"time"`
+	Method       string    `json:"method"`
+	URL          string    `json:"url"`
+	Status       int       `json:"status,omitempty"`
+	DurationMS   int64     `json:"duration_ms"`
+	Error        string    `json:"error,omitempty"`
+	RequestBody  string    `json:"request_body,omitempty"`
+	ResponseBody string    `json:"response_body,omitempty"`
+}
+
+// DebugTransport is an `http.RoundTripper` writing each request and
+// its response to a rolling file, one JSON `HTTPExchange` per line,
+// so mapping bugs can be traced back to the exact payloads received.
+//
+// Credentials are never written: headers are not recorded and
+// sensitive values in URLs are redacted. The secrets and email
+// addresses of the bodies are redacted too (see `logging.Redact`).
+type DebugTransport struct {
+	// Transport is the underlying HTTP transport. Defaults to
+	// `http.DefaultTransport` if nil.
+	Transport http.RoundTripper
+
+	mutex sync.Mutex
+	file  *rollingFile
+}
+
+// NewDebugTransport returns a `DebugTransport` writing to the file
+// at `path`, rotated when it exceeds `DebugHTTPMaxSize`.
+func NewDebugTransport(tr http.RoundTripper, path string) (*DebugTransport, error) {
+	f, err := openRollingFile(path, DebugHTTPMaxSize, DebugHTTPBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &DebugTransport{Transport: tr, file: f}, nil
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (t *DebugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr := t.Transport
+	if tr == nil {
+		tr = http.DefaultTransport
+	}
+	ex := HTTPExchange{
+		Time:   time.Now(),
+		Method: req.Method,
+		URL:    sanitizeURL(req.URL),
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		ex.RequestBody = string(body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	res, err := tr.RoundTrip(req)
+	ex.DurationMS = int64(time.Since(ex.Time) / time.Millisecond)
+	if err != nil {
+		ex.Error = err.Error()
+		t.write(ex)
+		return res, err
+	}
+	ex.Status = res.StatusCode
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		ex.Error = err.Error()
+	}
+	ex.ResponseBody = string(body)
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	t.write(ex)
+	return res, nil
+}
+
+// Close closes the file.
+func (t *DebugTransport) Close() error {
+	return t.file.Close()
+}
+
+func (t *DebugTransport) write(ex HTTPExchange) {
+	b, err := json.Marshal(ex)
+	if err != nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, err = t.file.Write(append([]byte(logging.Redact(string(b))), '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing HTTP debug log: %s\n", err)
+	}
+}
+
+// sanitizeURL returns the URL without user info and with the values
+// of sensitive query parameters redacted.
+func sanitizeURL(u *url.URL) string {
+	c := *u
+	c.User = nil
+	q := c.Query()
+	for k := range q {
+		for _, s := range sensitiveParams {
+			if strings.Contains(strings.ToLower(k), s) {
+				q.Set(k, "REDACTED")
+			}
+		}
+	}
+	c.RawQuery = q.Encode()
+	return c.String()
+}
+
+// rollingFile is an `io.WriteCloser` appending to a file which is
+// rotated when its size exceeds `maxSize`.
+type rollingFile struct {
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+func openRollingFile(path string, maxSize int64, backups int) (*rollingFile, error) {
+	f := &rollingFile{path: path, maxSize: maxSize, backups: backups}
+	return f, f.open()
+}
+
+func (f *rollingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rollingFile) Write(p []byte) (int, error) {
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file to `<path>.1` (shifting the existing
+// backups, dropping the oldest) and opens a new file.
+func (f *rollingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.backups))
+	for i := f.backups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *rollingFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,69 @@
+package client_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+)
+
+func TestDebugTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debughttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"key":"PJ-1"}`))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(dir, "http.log")
+	tr, err := client.NewDebugTransport(nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", srv.URL+"/rest/api/2/issue/PJ-1?token=secret", nil)
+	req.SetBasicAuth("user", "password")
+	res, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	tr.Close()
+
+	if string(body) != `{"key":"PJ-1"}` {
+		t.Errorf("expected the response body to be readable, got `%s`", body)
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret", "password", "dXNlcjpwYXNzd29yZA"} {
+		if strings.Contains(string(content), secret) {
+			t.Errorf("expected `%s` not to be recorded, got %s", secret, content)
+		}
+	}
+
+	var ex client.HTTPExchange
+	s := bufio.NewScanner(strings.NewReader(string(content)))
+	if !s.Scan() {
+		t.Fatalf("expected a recorded exchange")
+	}
+	if err = json.Unmarshal(s.Bytes(), &ex); err != nil {
+		t.Fatal(err)
+	}
+	if ex.Method != "GET" || ex.Status != 200 || ex.ResponseBody != `{"key":"PJ-1"}` {
+		t.Errorf("unexpected recorded exchange %+v", ex)
+	}
+}
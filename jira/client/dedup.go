@@ -0,0 +1,115 @@
+package client
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// DedupTransport is an `http.RoundTripper` sharing a single request
+// between the concurrent identical `GET` requests (same URL and
+// credentials), e.g. when several workers fetch the epic of their
+// stories at the same time. The callers waiting for the request in
+// flight get a copy of its response, with their own body. Responses
+// are not cached: a request sent once the shared one is done is
+// performed again. A caller stops waiting once the context of its
+// request is done, and performs the request again if the shared one
+// failed because its own context was done. It's safe for concurrent
+// use.
+type DedupTransport struct {
+	// Transport is the underlying HTTP transport. Defaults to
+	// `http.DefaultTransport` if nil.
+	Transport http.RoundTripper
+
+	mutex   sync.Mutex
+	flights map[string]*flight
+	shared  int
+}
+
+// flight is a request in flight, whose response (with its body read
+// in `body`) or error is shared by the identical requests. `canceled`
+// is set if it failed because the context of its request was done,
+// an error not shared.
+type flight struct {
+	done     chan struct{}
+	res      *http.Response
+	body     []byte
+	err      error
+	canceled bool
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (t *DedupTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr := t.Transport
+	if tr == nil {
+		tr = http.DefaultTransport
+	}
+	if req.Method != http.MethodGet || (req.Body != nil && req.Body != http.NoBody) {
+		return tr.RoundTrip(req)
+	}
+	key := req.URL.String() + " " + req.Header.Get("Authorization")
+
+	t.mutex.Lock()
+	if f, ok := t.flights[key]; ok {
+		t.shared++
+		t.mutex.Unlock()
+		select {
+		case <-f.done:
+		case <-req.Context().Done():
+			t.unshare()
+			return nil, req.Context().Err()
+		}
+		if f.canceled {
+			t.unshare()
+			return t.RoundTrip(req)
+		}
+		return f.response(req)
+	}
+	f := &flight{done: make(chan struct{})}
+	if t.flights == nil {
+		t.flights = make(map[string]*flight)
+	}
+	t.flights[key] = f
+	t.mutex.Unlock()
+
+	f.res, f.err = tr.RoundTrip(req)
+	if f.err == nil {
+		f.body, f.err = ioutil.ReadAll(f.res.Body)
+		f.res.Body.Close()
+	}
+	f.canceled = f.err != nil && req.Context().Err() != nil
+	t.mutex.Lock()
+	delete(t.flights, key)
+	t.mutex.Unlock()
+	close(f.done)
+	return f.response(req)
+}
+
+// Shared returns the number of requests which were not sent, their
+// response being shared with an identical request in flight.
+func (t *DedupTransport) Shared() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.shared
+}
+
+// unshare uncounts a request counted by `Shared` which was not
+// given the shared response.
+func (t *DedupTransport) unshare() {
+	t.mutex.Lock()
+	t.shared--
+	t.mutex.Unlock()
+}
+
+// response returns a copy of the response of the flight for `req`.
+func (f *flight) response(req *http.Request) (*http.Response, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	res := *f.res
+	res.Header = f.res.Header.Clone()
+	res.Body = ioutil.NopCloser(bytes.NewReader(f.body))
+	res.Request = req
+	return &res, nil
+}
@@ -0,0 +1,150 @@
+package client_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+)
+
+func TestDedupTransport(t *testing.T) {
+	var hits int32
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			close(started)
+			<-release
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	tr := &client.DedupTransport{}
+	get := func(path string) string {
+		res, err := (&http.Client{Transport: tr}).Get(srv.URL + path)
+		if err != nil {
+			t.Error(err)
+			return ""
+		}
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		return string(b)
+	}
+
+	// The requests sent while the first one is in flight share its
+	// response
+	bodies := make([]string, 5)
+	var wg sync.WaitGroup
+	wg.Add(len(bodies))
+	for i := range bodies {
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = get("/rest/api/2/issue/PJ-1")
+		}(i)
+		if i == 0 {
+			<-started
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); tr.Shared() < len(bodies)-1; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d requests to wait for the first one, got %d", len(bodies)-1, tr.Shared())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("expected 1 request to be sent, got %d", n)
+	}
+	for i, b := range bodies {
+		if b != "/rest/api/2/issue/PJ-1" {
+			t.Errorf("unexpected body of response %d: %q", i, b)
+		}
+	}
+
+	// Responses are not cached, and other requests are not shared
+	get("/rest/api/2/issue/PJ-1")
+	get("/rest/api/2/issue/PJ-2")
+	if n := atomic.LoadInt32(&hits); n != 3 || tr.Shared() != len(bodies)-1 {
+		t.Errorf("expected 3 requests sent and %d shared, got %d and %d", len(bodies)-1, n, tr.Shared())
+	}
+}
+
+func TestDedupTransport_canceled(t *testing.T) {
+	var hits int32
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			close(started)
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	tr := &client.DedupTransport{}
+	get := func(ctx context.Context) (string, error) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/issue", nil)
+		res, err := (&http.Client{Transport: tr}).Do(req.WithContext(ctx))
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		b, err := ioutil.ReadAll(res.Body)
+		return string(b), err
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := get(leaderCtx)
+		leaderErr <- err
+	}()
+	<-started
+
+	// A waiter whose context is done stops waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := get(ctx); err == nil {
+		t.Errorf("expected the waiter to fail once its context is done")
+	}
+
+	// A waiter retries if the leader is canceled
+	body := make(chan string, 1)
+	go func() {
+		b, err := get(context.Background())
+		if err != nil {
+			t.Errorf("expected the waiter to retry, got %s", err)
+		}
+		body <- b
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancelLeader()
+	if err := <-leaderErr; err == nil {
+		t.Errorf("expected the canceled request to fail")
+	}
+	select {
+	case b := <-body:
+		if b != "/issue" {
+			t.Errorf("expected `/issue`, got `%s`", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the waiter to get a response")
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Errorf("expected 2 requests sent, got %d", n)
+	}
+}
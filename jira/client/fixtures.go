@@ -0,0 +1,270 @@
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// IssueFixture is a builder for `jira.Issue` values to be used
+// in tests (e.g. as a response for `WillRespondWithIssue(..)`).
+// It avoids hand-constructing the nested `go-jira` structs.
+//
+// Example:
+//
+//	i := NewIssueFixture("PJ-1").
+//		WithStatus("Done").
+//		WithChangelog("status", "Open", "Done", refTime).
+//		Issue()
+type IssueFixture struct {
+	issue      *jira.Issue
+	histories  []jira.ChangelogHistory
+	historyAt  []time.Time
+	changeUser string
+}
+
+// FixtureTimeFormat is the format used by Jira API for times
+// in changelogs and comments.
+const FixtureTimeFormat = "2006-01-02T15:04:05.000-0700"
+
+// SprintFieldName is the name of the changelog field used by
+// Jira for sprint changes.
+const SprintFieldName = "Sprint"
+
+// NewIssueFixture returns an `IssueFixture` for an issue with
+// the specified key. The issue is a "Story", with an "Open"
+// status, a "Major" priority and a reporter, created one hour
+// ago.
+func NewIssueFixture(key string) *IssueFixture {
+	created := time.Now().Add(-time.Hour)
+	return &IssueFixture{
+		issue: &jira.Issue{
+			Key: key,
+			Fields: &jira.IssueFields{
+				Type:        jira.IssueType{Name: "Story"},
+				Project:     jira.Project{Key: "PJ", Name: "Project"},
+				Status:      &jira.Status{Name: "Open"},
+				Priority:    &jira.Priority{Name: "Major"},
+				Reporter:    &jira.User{Name: "reporter"},
+				Created:     jira.Time(created),
+				Updated:     jira.Time(created),
+				Summary:     "summary",
+				Description: "description",
+				Unknowns:    map[string]interface{}{},
+			},
+		},
+		changeUser: "change_author",
+	}
+}
+
+// WithType sets the issue's type (e.g. "Bug").
+func (f *IssueFixture) WithType(t string) *IssueFixture {
+	f.issue.Fields.Type = jira.IssueType{Name: t}
+	return f
+}
+
+// WithProject sets the issue's project key and name.
+func (f *IssueFixture) WithProject(key, name string) *IssueFixture {
+	f.issue.Fields.Project = jira.Project{Key: key, Name: name}
+	return f
+}
+
+// WithStatus sets the issue's current status.
+func (f *IssueFixture) WithStatus(s string) *IssueFixture {
+	f.issue.Fields.Status = &jira.Status{Name: s}
+	return f
+}
+
+// WithPriority sets the issue's priority.
+func (f *IssueFixture) WithPriority(p string) *IssueFixture {
+	f.issue.Fields.Priority = &jira.Priority{Name: p}
+	return f
+}
+
+// WithAssignee sets the issue's current assignee.
+func (f *IssueFixture) WithAssignee(name string) *IssueFixture {
+	f.issue.Fields.Assignee = &jira.User{Name: name}
+	return f
+}
+
+// WithCreated sets the issue's creation time. The update time is
+// set to the same value if it was before.
+func (f *IssueFixture) WithCreated(t time.Time) *IssueFixture {
+	f.issue.Fields.Created = jira.Time(t)
+	if time.Time(f.issue.Fields.Updated).Before(t) {
+		f.issue.Fields.Updated = jira.Time(t)
+	}
+	return f
+}
+
+// WithUpdated sets the issue's update time.
+func (f *IssueFixture) WithUpdated(t time.Time) *IssueFixture {
+	f.issue.Fields.Updated = jira.Time(t)
+	return f
+}
+
+// WithReporter sets the issue's reporter.
+func (f *IssueFixture) WithReporter(name string) *IssueFixture {
+	f.issue.Fields.Reporter = &jira.User{Name: name}
+	return f
+}
+
+// WithSummary sets the issue's summary.
+func (f *IssueFixture) WithSummary(s string) *IssueFixture {
+	f.issue.Fields.Summary = s
+	return f
+}
+
+// WithResolved sets the issue's resolution date.
+func (f *IssueFixture) WithResolved(t time.Time) *IssueFixture {
+	f.issue.Fields.Resolutiondate = jira.Time(t)
+	return f
+}
+
+// WithCustomField sets the raw value of a custom field (e.g.
+// `customfield_10600`), as `go-jira` would unmarshal it.
+func (f *IssueFixture) WithCustomField(field string, value interface{}) *IssueFixture {
+	f.issue.Fields.Unknowns[field] = value
+	return f
+}
+
+// WithChangeAuthor sets the author of the changelogs added after
+// this call.
+func (f *IssueFixture) WithChangeAuthor(name string) *IssueFixture {
+	f.changeUser = name
+	return f
+}
+
+// WithChangelog adds a changelog history with a single item for
+// the specified field. The issue's update time is moved to `t`
+// if it's later.
+func (f *IssueFixture) WithChangelog(field, from, to string, t time.Time) *IssueFixture {
+	f.histories = append(f.histories, jira.ChangelogHistory{
+		Author:  jira.User{Name: f.changeUser},
+		Created: t.Format(FixtureTimeFormat),
+		Items: []jira.ChangelogItems{
+			{
+				Field:      field,
+				FieldType:  "jira",
+				From:       from,
+				FromString: from,
+				To:         to,
+				ToString:   to,
+			},
+		},
+	})
+	f.historyAt = append(f.historyAt, t)
+	if time.Time(f.issue.Fields.Updated).Before(t) {
+		f.issue.Fields.Updated = jira.Time(t)
+	}
+	return f
+}
+
+// WithStatusChangeReason adds a changelog history changing the
+// status from `from` to `to` and setting the reason field along
+// with it, as a transition screen does.
+func (f *IssueFixture) WithStatusChangeReason(from, to string, t time.Time, field, reason string) *IssueFixture {
+	f.WithChangelog("status", from, to, t)
+	h := &f.histories[len(f.histories)-1]
+	h.Items = append(h.Items, jira.ChangelogItems{
+		Field:     field,
+		FieldType: "custom",
+		To:        reason,
+		ToString:  reason,
+	})
+	return f
+}
+
+// WithComment adds a comment on the issue.
+func (f *IssueFixture) WithComment(author, body string, t time.Time) *IssueFixture {
+	if f.issue.Fields.Comments == nil {
+		f.issue.Fields.Comments = &jira.Comments{}
+	}
+	f.issue.Fields.Comments.Comments = append(f.issue.Fields.Comments.Comments, &jira.Comment{
+		Author:  jira.User{Name: author},
+		Body:    body,
+		Created: t.Format(FixtureTimeFormat),
+	})
+	return f
+}
+
+// Issue returns the built `jira.Issue`.
+//
+// Changelog histories are sorted by time *descending*, as they are
+// returned by Jira API.
+func (f *IssueFixture) Issue() *jira.Issue {
+	idx := make([]int, len(f.histories))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		return f.historyAt[idx[a]].After(f.historyAt[idx[b]])
+	})
+	histories := make([]jira.ChangelogHistory, len(f.histories))
+	for i, j := range idx {
+		histories[i] = f.histories[j]
+	}
+	f.issue.Changelog = &jira.Changelog{Histories: histories}
+	return f.issue
+}
+
+// LoadIssueFixture returns the issue of the JSON file at `path`, as
+// returned by Jira API (e.g. recorded with `--debug-http`, or with
+// `explore-raw-issue`), so tests can use real payloads instead of
+// building them. The test fails if the file can't be read or
+// decoded.
+func LoadIssueFixture(t *testing.T, path string) *jira.Issue {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read the issue fixture: %s", err)
+	}
+	var i jira.Issue
+	if err = json.Unmarshal(b, &i); err != nil {
+		t.Fatalf("could not decode the issue fixture `%s`: %s", path, err)
+	}
+	return &i
+}
+
+// Scenarios
+// ---------
+
+// NewReopenedBugFixture returns a fixture for a bug created at
+// `created`, which went through "In Progress" and "Done" and was
+// then reopened and fixed again. Each transition happens one hour
+// after the previous one.
+func NewReopenedBugFixture(key string, created time.Time) *IssueFixture {
+	return NewIssueFixture(key).
+		WithType("Bug").
+		WithCreated(created).
+		WithChangelog("status", "Open", "In Progress", created.Add(1*time.Hour)).
+		WithChangelog("status", "In Progress", "Done", created.Add(2*time.Hour)).
+		WithChangelog("status", "Done", "Reopened", created.Add(3*time.Hour)).
+		WithChangelog("status", "Reopened", "In Progress", created.Add(4*time.Hour)).
+		WithChangelog("status", "In Progress", "Done", created.Add(5*time.Hour)).
+		WithStatus("Done").
+		WithResolved(created.Add(5 * time.Hour))
+}
+
+// NewMultiSprintStoryFixture returns a fixture for a story created
+// at `created` which was carried over the specified sprints before
+// being done. The story is added to the first sprint one hour after
+// its creation and moved to the next sprint every day.
+func NewMultiSprintStoryFixture(key string, created time.Time, sprints ...string) *IssueFixture {
+	f := NewIssueFixture(key).WithCreated(created)
+	t := created.Add(time.Hour)
+	previous := ""
+	for _, s := range sprints {
+		f.WithChangelog(SprintFieldName, previous, s, t)
+		previous = s
+		t = t.Add(24 * time.Hour)
+	}
+	return f.
+		WithChangelog("status", "Open", "Done", t).
+		WithStatus("Done").
+		WithResolved(t)
+}
@@ -2,9 +2,11 @@ package client
 
 import (
 	"fmt"
-	"log"
+	"regexp"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/andygrunwald/go-jira"
 	"github.com/rchampourlier/golib/matchers"
@@ -12,9 +14,15 @@ import (
 
 // MockClient is a mock to fake a client to Jira API. It
 // implements the `jira.Client` interface.
+//
+// The `SearchIssues` expectations are matched in the order they were
+// set, unless `MatchExpectationsInOrder(false)` is called. The
+// `GetIssue` expectations are matched by issue key, whatever their
+// order, since issues are fetched concurrently.
 type MockClient struct {
 	*testing.T
 	expectations []Expectation
+	unordered    bool
 	mutex        sync.Mutex
 }
 
@@ -35,38 +43,99 @@ func NewMockClient(t *testing.T) *MockClient {
 	}
 }
 
+// MatchExpectationsInOrder sets whether the `SearchIssues`
+// expectations must be matched in the order they were set (the
+// default). If not, a call matches the first `SearchIssues`
+// expectation whose query matches, e.g. for the concurrent searches
+// of several sources.
+func (c *MockClient) MatchExpectationsInOrder(inOrder bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.unordered = !inOrder
+}
+
+// ExpectationsWereMet returns an error listing the expectations
+// which were not matched by a call, if any.
+func (c *MockClient) ExpectationsWereMet() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.expectations) == 0 {
+		return nil
+	}
+	var descriptions []string
+	for _, e := range c.expectations {
+		descriptions = append(descriptions, e.Describe())
+	}
+	return fmt.Errorf("%d expectations were not met: %s", len(descriptions), strings.Join(descriptions, ", "))
+}
+
+// AssertExpectationsMet fails the test if some expectations were not
+// matched by a call (see `ExpectationsWereMet`).
+func (c *MockClient) AssertExpectationsMet(t *testing.T) {
+	t.Helper()
+	if err := c.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
 // SearchIssues fakes a search issues query to the Jira API.
-// The `query` parameter is ignored. The list of issue keys
-// passed when initializing the mock is sent through the
-// `issueKeys` channel. When all keys have been sent, the
+// The list of issue keys of the matching expectation is sent
+// through the `issueKeys` channel. When all keys have been sent, the
 // channel is closed.
-func (c *MockClient) SearchIssues(query string, issueKeys chan string) {
-	e := c.popExpectation()
-	if e == nil {
-		c.Errorf("mock received `SearchIssues` but no expectation was set")
+func (c *MockClient) SearchIssues(query string, issueKeys chan string) error {
+	esi, err := c.popExpectedSearchIssues(query)
+	if err != nil {
+		c.Error(err)
+		close(issueKeys)
+		return err
 	}
-	esi, ok := e.(*ExpectedSearchIssues)
-	if !ok {
-		c.Errorf("mock received `SearchIssues` but was expecting %s\n", e.Describe())
+	matchers.MatchStringWithRegex(c.T, "query", esi.query, query, esi.Describe())
+	if esi.timeout > 0 {
+		time.Sleep(esi.timeout)
+		close(issueKeys)
+		return &TimeoutError{After: esi.timeout}
 	}
-	matchers.MatchStringWithRegex(c.T, "query", esi.query, query, e.Describe())
 	for _, ik := range esi.issueKeys {
 		issueKeys <- ik
 	}
 	close(issueKeys)
+	return esi.err
 }
 
 // GetIssue fakes fetching the issue specified by its key.
-// To have it return a `jira.Issue`, use `WillRespondWithIssue(..)`.
-func (c *MockClient) GetIssue(issueKey string) *jira.Issue {
+// To have it return a `jira.Issue`, use `WillRespondWithIssue(..)`,
+// or `WillFailWith(..)` or `WillTimeoutAfter(..)` for an error.
+func (c *MockClient) GetIssue(issueKey string) (*jira.Issue, error) {
 	ee := c.popExpectedGetIssue(issueKey)
 	if ee == nil {
-		msg := fmt.Sprintf("mock received `GetIssue` with issue key `%s` but no matching expectation could be found", issueKey)
-		log.Fatalln(msg)
+		err := fmt.Errorf("mock received `GetIssue` with issue key `%s` but no matching expectation could be found", issueKey)
+		c.Error(err)
+		return nil, err
+	}
+	if ee.timeout > 0 {
+		time.Sleep(ee.timeout)
+		return nil, &TimeoutError{After: ee.timeout}
 	}
-	return ee.issue
+	return ee.issue, ee.err
 }
 
+// TimeoutError is the error returned by the expectations set with
+// `WillTimeoutAfter`. Like the timeouts of `net/http`, it's a
+// `net.Error` whose `Timeout` method returns true.
+type TimeoutError struct {
+	After time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("request timed out after %s", e.After)
+}
+
+// Timeout returns true.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// Temporary returns true.
+func (e *TimeoutError) Temporary() bool { return true }
+
 // ============
 // Expectations
 // ============
@@ -78,6 +147,8 @@ func (c *MockClient) GetIssue(issueKey string) *jira.Issue {
 type ExpectedSearchIssues struct {
 	query     string
 	issueKeys []string
+	err       error
+	timeout   time.Duration
 }
 
 // ExpectSearchIssues indicates the mock should expect a call to
@@ -98,8 +169,26 @@ func (e *ExpectedSearchIssues) Describe() string {
 // WillRespondWithIssueKeys indicates `ExpectedSearchIssues`
 // expectation should send the specified issue keys when
 // called.
-func (e *ExpectedSearchIssues) WillRespondWithIssueKeys(issueKeys []string) {
+func (e *ExpectedSearchIssues) WillRespondWithIssueKeys(issueKeys []string) *ExpectedSearchIssues {
 	e.issueKeys = issueKeys
+	return e
+}
+
+// WillFailWith indicates `ExpectedSearchIssues` expectation should
+// return the error after sending its issue keys, as if the search
+// failed (e.g. with a 429 or 500 response, once the client gave up
+// retrying).
+func (e *ExpectedSearchIssues) WillFailWith(err error) *ExpectedSearchIssues {
+	e.err = err
+	return e
+}
+
+// WillTimeoutAfter indicates `ExpectedSearchIssues` expectation
+// should wait for `d`, then return a `TimeoutError` without sending
+// any issue key.
+func (e *ExpectedSearchIssues) WillTimeoutAfter(d time.Duration) *ExpectedSearchIssues {
+	e.timeout = d
+	return e
 }
 
 // GetIssue
@@ -110,6 +199,8 @@ func (e *ExpectedSearchIssues) WillRespondWithIssueKeys(issueKeys []string) {
 type ExpectedGetIssue struct {
 	issueKey string
 	issue    *jira.Issue
+	err      error
+	timeout  time.Duration
 }
 
 // ExpectGetIssue indicates the mock is expected to receive a
@@ -121,9 +212,33 @@ func (c *MockClient) ExpectGetIssue(issueKey string) *ExpectedGetIssue {
 }
 
 // WillRespondWithIssue specified that the `ExpectedGetIssue`
-// expectation should respond with the passed issue.
-func (e *ExpectedGetIssue) WillRespondWithIssue(issue *jira.Issue) {
+// expectation should respond with the passed issue (e.g. loaded with
+// `LoadIssueFixture`).
+func (e *ExpectedGetIssue) WillRespondWithIssue(issue *jira.Issue) *ExpectedGetIssue {
 	e.issue = issue
+	return e
+}
+
+// WillRespondWithError specifies that the `ExpectedGetIssue`
+// expectation should fail with the passed error.
+func (e *ExpectedGetIssue) WillRespondWithError(err error) *ExpectedGetIssue {
+	e.err = err
+	return e
+}
+
+// WillFailWith specifies that the `ExpectedGetIssue` expectation
+// should fail with the passed error (e.g. the error of a 429 or 500
+// response, once the client gave up retrying).
+func (e *ExpectedGetIssue) WillFailWith(err error) *ExpectedGetIssue {
+	e.err = err
+	return e
+}
+
+// WillTimeoutAfter specifies that the `ExpectedGetIssue`
+// expectation should wait for `d`, then fail with a `TimeoutError`.
+func (e *ExpectedGetIssue) WillTimeoutAfter(d time.Duration) *ExpectedGetIssue {
+	e.timeout = d
+	return e
 }
 
 // Describe describes the `GetIssue` expectation
@@ -134,15 +249,25 @@ func (e *ExpectedGetIssue) Describe() string {
 // Other
 // -----
 
-func (c *MockClient) popExpectation() Expectation {
+// popExpectedSearchIssues pops the expectation matched by a
+// `SearchIssues` call with the query: the next expectation, which
+// must be a `SearchIssues` one, or the first `SearchIssues`
+// expectation whose query matches if unordered.
+func (c *MockClient) popExpectedSearchIssues(query string) (*ExpectedSearchIssues, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	if len(c.expectations) == 0 {
-		return nil
+	for i, e := range c.expectations {
+		esi, ok := e.(*ExpectedSearchIssues)
+		if !c.unordered && !ok {
+			return nil, fmt.Errorf("mock received `SearchIssues` but was expecting %s", e.Describe())
+		}
+		if !ok || (c.unordered && !regexp.MustCompile(esi.query).MatchString(query)) {
+			continue
+		}
+		c.expectations = append(c.expectations[:i:i], c.expectations[i+1:]...)
+		return esi, nil
 	}
-	e := c.expectations[0]
-	c.expectations = c.expectations[1:]
-	return e
+	return nil, fmt.Errorf("mock received `SearchIssues` with query `%s` but no matching expectation could be found", query)
 }
 
 func (c *MockClient) popExpectedGetIssue(issueKey string) *ExpectedGetIssue {
@@ -165,6 +290,5 @@ func (c *MockClient) popExpectedGetIssue(issueKey string) *ExpectedGetIssue {
 			}
 		}
 	}
-	fmt.Printf("popExpIssue %s -- DONE\n", issueKey)
 	return nil
 }
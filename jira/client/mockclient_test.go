@@ -0,0 +1,40 @@
+package client_test
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+)
+
+func TestMockClient_MatchExpectationsInOrder(t *testing.T) {
+	c := client.NewMockClient(t)
+	c.MatchExpectationsInOrder(false)
+	c.ExpectSearchIssues(`project = WEB`).WillRespondWithIssueKeys([]string{"WEB-1"})
+	c.ExpectSearchIssues(`project = MOB`).WillRespondWithIssueKeys([]string{"MOB-1"})
+
+	// The second expectation is matched first
+	for query, expected := range map[string]string{"project = MOB": "MOB-1", "project = WEB": "WEB-1"} {
+		keys := make(chan string, 1)
+		if err := c.SearchIssues(query, keys); err != nil {
+			t.Fatal(err)
+		}
+		if k := <-keys; k != expected {
+			t.Errorf("expected `%s` to be found by `%s`, got `%s`", expected, query, k)
+		}
+	}
+	c.AssertExpectationsMet(t)
+}
+
+func TestMockClient_ExpectationsWereMet(t *testing.T) {
+	c := client.NewMockClient(t)
+	c.ExpectGetIssue("PJ-1").WillRespondWithIssue(client.LoadIssueFixture(t, "../mapping/testdata/issues/epic.json"))
+	c.ExpectGetIssue("PJ-2")
+
+	i, err := c.GetIssue("PJ-1")
+	if err != nil || i.Fields == nil || i.Fields.Type.Name != "Epic" {
+		t.Errorf("expected the epic of the fixture, got %v (error: %v)", i, err)
+	}
+	if err := c.ExpectationsWereMet(); err == nil {
+		t.Errorf("expected the expectation of `PJ-2` to be reported")
+	}
+}
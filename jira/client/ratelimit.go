@@ -0,0 +1,135 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting the rate of requests to a
+// maximum number of requests per second, as Jira Cloud does. Up to
+// `burst` requests may be performed at once, the bucket being then
+// refilled at the maximum rate. With a burst of 1, requests are
+// evenly spaced out. It's safe for concurrent use.
+type RateLimiter struct {
+	mutex        sync.Mutex
+	maxPerSecond float64
+	burst        int
+
+	// next is the time at which the bucket is full again.
+	next time.Time
+}
+
+// NewRateLimiter returns a `RateLimiter` allowing `maxPerSecond`
+// requests per second, with bursts of `burst` requests (1 if less).
+func NewRateLimiter(maxPerSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{maxPerSecond: maxPerSecond, burst: burst}
+}
+
+// Wait blocks until a request may be performed, i.e. until a token
+// is available in the bucket.
+func (l *RateLimiter) Wait() {
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	interval := time.Duration(float64(time.Second) / l.maxPerSecond)
+	wait := l.next.Add(-time.Duration(l.burst-1) * interval).Sub(now)
+	l.next = l.next.Add(interval)
+	l.mutex.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// MaxPerSecond returns the maximum number of requests per second
+// allowed by the limiter.
+func (l *RateLimiter) MaxPerSecond() float64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.maxPerSecond
+}
+
+// Burst returns the number of requests the limiter allows at once.
+func (l *RateLimiter) Burst() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.burst
+}
+
+// limit lowers the limiter's rate to `maxPerSecond` and its burst to
+// `burst` if they are more restrictive.
+func (l *RateLimiter) limit(maxPerSecond float64, burst int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if maxPerSecond < l.maxPerSecond {
+		l.maxPerSecond = maxPerSecond
+	}
+	if burst >= 1 && burst < l.burst {
+		l.burst = burst
+	}
+}
+
+var sharedRateLimiters = struct {
+	sync.Mutex
+	byBaseURL map[string]*RateLimiter
+}{byBaseURL: map[string]*RateLimiter{}}
+
+// SharedRateLimiter returns the `RateLimiter` shared by all the
+// clients of the process targeting the Jira instance at `baseURL`,
+// so that their aggregate rate of requests respects the instance's
+// limits.
+//
+// The limiter is created on the first call for an instance. If
+// clients request different rates or bursts for the same instance,
+// the most restrictive ones apply.
+func SharedRateLimiter(baseURL string, maxPerSecond float64, burst int) *RateLimiter {
+	key := rateLimiterKey(baseURL)
+	sharedRateLimiters.Lock()
+	defer sharedRateLimiters.Unlock()
+	l, ok := sharedRateLimiters.byBaseURL[key]
+	if !ok {
+		l = NewRateLimiter(maxPerSecond, burst)
+		sharedRateLimiters.byBaseURL[key] = l
+		return l
+	}
+	l.limit(maxPerSecond, burst)
+	return l
+}
+
+// rateLimiterKey identifies the Jira instance at `baseURL` by its
+// scheme and host, so that URLs differing by their case or path
+// (e.g. a trailing slash) share the same limiter.
+func rateLimiterKey(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return strings.ToLower(strings.TrimRight(baseURL, "/"))
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// RateLimitTransport is an `http.RoundTripper` waiting for its
+// `Limiter` before performing each request.
+type RateLimitTransport struct {
+	// Transport is the underlying HTTP transport. Defaults to
+	// `http.DefaultTransport` if nil.
+	Transport http.RoundTripper
+
+	Limiter *RateLimiter
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr := t.Transport
+	if tr == nil {
+		tr = http.DefaultTransport
+	}
+	t.Limiter.Wait()
+	return tr.RoundTrip(req)
+}
@@ -0,0 +1,78 @@
+package client_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+)
+
+func TestSharedRateLimiter(t *testing.T) {
+	a := client.SharedRateLimiter("https://shared.example.com", 10, 1)
+	b := client.SharedRateLimiter("https://SHARED.example.com/", 20, 1)
+	if a != b {
+		t.Fatalf("expected clients of the same instance to share the limiter")
+	}
+	if r := a.MaxPerSecond(); r != 10 {
+		t.Errorf("expected the most restrictive rate (10) to apply, got %v", r)
+	}
+	client.SharedRateLimiter("https://shared.example.com", 5, 1)
+	if r := a.MaxPerSecond(); r != 5 {
+		t.Errorf("expected the rate to be lowered to 5, got %v", r)
+	}
+	if c := client.SharedRateLimiter("https://other.example.com", 10, 1); c == a {
+		t.Errorf("expected another instance to have its own limiter")
+	}
+}
+
+func TestRateLimitTransport_SharedAcrossClients(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// Two clients of the same instance at 20 requests per second
+	// each, sharing the limiter: 6 requests take at least 250ms.
+	var clients []*http.Client
+	for i := 0; i < 2; i++ {
+		clients = append(clients, &http.Client{Transport: &client.RateLimitTransport{
+			Limiter: client.SharedRateLimiter(srv.URL, 20, 1),
+		}})
+	}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(c *http.Client) {
+			defer wg.Done()
+			res, err := c.Get(srv.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res.Body.Close()
+		}(clients[i%2])
+	}
+	wg.Wait()
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("expected the requests to take at least 250ms, took %s", d)
+	}
+}
+
+func TestRateLimiter_Burst(t *testing.T) {
+	// 3 requests at once, then 10 requests per second
+	l := client.NewRateLimiter(10, 3)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		l.Wait()
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("expected the burst not to be limited, took %s", d)
+	}
+	l.Wait()
+	l.Wait()
+	if d := time.Since(start); d < 190*time.Millisecond {
+		t.Errorf("expected the requests after the burst to be limited, took %s", d)
+	}
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// Defaults of `RetryTransport`
+const (
+	DefaultRetryAttempts = 5
+	DefaultRetryDelay    = time.Second
+	maxRetryDelay        = time.Minute
+)
+
+// RetryTransport is an `http.RoundTripper` retrying the requests
+// failing transiently: responses with a `429 Too Many Requests` or
+// `5xx` status, and network errors (e.g. connection resets). The
+// delay before each retry doubles, starting at `Delay`, unless a
+// `429` response specifies it with `Retry-After`. The request is not
+// retried anymore once its context is done.
+type RetryTransport struct {
+	// Transport is the underlying HTTP transport. Defaults to
+	// `http.DefaultTransport` if nil.
+	Transport http.RoundTripper
+
+	// Attempts is the maximum number of attempts of a request.
+	// Defaults to `DefaultRetryAttempts` if zero.
+	Attempts int
+
+	// Delay is the delay before the first retry. Defaults to
+	// `DefaultRetryDelay` if zero.
+	Delay time.Duration
+}
+
+// RoundTrip implements `http.RoundTripper`. The response or error
+// of the last attempt is returned if all of them fail.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr := t.Transport
+	if tr == nil {
+		tr = http.DefaultTransport
+	}
+	attempts := t.Attempts
+	if attempts <= 0 {
+		attempts = DefaultRetryAttempts
+	}
+	delay := t.Delay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 && req.Body != nil {
+			// The body was consumed by the previous attempt
+			if req.GetBody == nil {
+				return nil, errors.New("cannot retry request: body can't be rewound")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+		res, err := tr.RoundTrip(r)
+		if attempt == attempts || !isTransient(res, err) {
+			return res, err
+		}
+
+		wait := delay
+		if res != nil {
+			if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && res.StatusCode == http.StatusTooManyRequests {
+				wait = time.Duration(s) * time.Second
+			}
+			logging.Warnf("Request to %s failed with status %d, retrying in %s (attempt %d/%d)", sanitizeURL(req.URL), res.StatusCode, wait, attempt, attempts)
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		} else {
+			logging.Warnf("Request to %s failed (%s), retrying in %s (attempt %d/%d)", sanitizeURL(req.URL), err, wait, attempt, attempts)
+		}
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// isTransient returns true if the request failed with a response or
+// an error which may not happen again.
+func isTransient(res *http.Response, err error) bool {
+	if err != nil {
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+}
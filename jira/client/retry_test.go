@@ -0,0 +1,86 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+)
+
+func TestRetryTransport(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		statuses         []int
+		expectedStatus   int
+		expectedAttempts int
+	}{
+		{"succeeds after transient errors", []int{503, 429, 200}, 200, 3},
+		{"gives up after the maximum attempts", []int{502, 502, 502, 502}, 502, 3},
+		{"doesn't retry client errors", []int{404, 200}, 404, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := make([]byte, 4)
+				n, _ := r.Body.Read(body)
+				if string(body[:n]) != "body" {
+					t.Errorf("expected the body to be sent with each attempt, got `%s`", body[:n])
+				}
+				w.WriteHeader(tc.statuses[attempts])
+				attempts++
+			}))
+			defer srv.Close()
+
+			c := &http.Client{Transport: &client.RetryTransport{Attempts: 3, Delay: time.Millisecond}}
+			res, err := c.Post(srv.URL, "text/plain", strings.NewReader("body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tc.expectedStatus || attempts != tc.expectedAttempts {
+				t.Errorf("expected status %d after %d attempts, got %d after %d", tc.expectedStatus, tc.expectedAttempts, res.StatusCode, attempts)
+			}
+		})
+	}
+}
+
+func TestRetryTransport_ConnectionErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+
+	start := time.Now()
+	c := &http.Client{Transport: &client.RetryTransport{Attempts: 3, Delay: 10 * time.Millisecond}}
+	if _, err := c.Get(url); err == nil {
+		t.Fatalf("expected an error requesting a closed server")
+	}
+	// Retried twice, after 10ms and 20ms
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("expected the request to be retried with backoff, took %s", d)
+	}
+}
+
+func TestRetryTransport_ContextDone(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	c := &http.Client{Transport: &client.ContextTransport{
+		Transport: &client.RetryTransport{Attempts: 3, Delay: time.Hour},
+		Context:   ctx,
+	}}
+	if _, err := c.Get(srv.URL); err == nil {
+		t.Fatalf("expected an error once the context is done")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the retries to stop once the context is done, took %s", d)
+	}
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Usage is the consumption of Jira API, as measured by
+// `UsageTransport`.
+type Usage struct {
+	// Calls is the number of requests sent to each endpoint (see
+	// `Endpoint`), including the retries.
+	Calls map[string]int
+
+	// Throttled is the number of requests rejected by Jira because
+	// of its rate limit (`429 Too Many Requests`).
+	Throttled int
+
+	// Limit is the last quota announced by Jira in the
+	// `X-RateLimit-Limit` header, 0 if none was.
+	Limit int
+
+	// Shared is the number of requests which were not sent, sharing
+	// the response of an identical request in flight (see
+	// `DedupTransport`). They are not counted in `Calls`.
+	Shared int
+}
+
+// Total returns the number of requests sent to all the endpoints.
+func (u Usage) Total() int {
+	n := 0
+	for _, c := range u.Calls {
+		n += c
+	}
+	return n
+}
+
+// Sub returns the usage since `before`, a previous measure of the
+// same transport, e.g. to get the usage of a sync.
+func (u Usage) Sub(before Usage) Usage {
+	d := Usage{Calls: make(map[string]int), Throttled: u.Throttled - before.Throttled, Limit: u.Limit, Shared: u.Shared - before.Shared}
+	for e, c := range u.Calls {
+		if c -= before.Calls[e]; c > 0 {
+			d.Calls[e] = c
+		}
+	}
+	return d
+}
+
+// UsageTransport is an `http.RoundTripper` counting the requests sent
+// to Jira API by endpoint, and those rejected because of its rate
+// limit. It's safe for concurrent use.
+type UsageTransport struct {
+	// Transport is the underlying HTTP transport. Defaults to
+	// `http.DefaultTransport` if nil.
+	Transport http.RoundTripper
+
+	mutex sync.Mutex
+	usage Usage
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (t *UsageTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr := t.Transport
+	if tr == nil {
+		tr = http.DefaultTransport
+	}
+	res, err := tr.RoundTrip(req)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.usage.Calls == nil {
+		t.usage.Calls = make(map[string]int)
+	}
+	t.usage.Calls[Endpoint(req)]++
+	if res != nil {
+		if res.StatusCode == http.StatusTooManyRequests {
+			t.usage.Throttled++
+		}
+		if limit, err := strconv.Atoi(res.Header.Get("X-RateLimit-Limit")); err == nil && limit > 0 {
+			t.usage.Limit = limit
+		}
+	}
+	return res, err
+}
+
+// Usage returns the usage since the transport was created.
+func (t *UsageTransport) Usage() Usage {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	u := t.usage
+	u.Calls = make(map[string]int, len(t.usage.Calls))
+	for e, c := range t.usage.Calls {
+		u.Calls[e] = c
+	}
+	return u
+}
+
+var (
+	issueKeySegment = regexp.MustCompile(`^[A-Z][A-Z0-9_]*-[0-9]+$`)
+	idSegment       = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// Endpoint returns the endpoint of the request: its method and path,
+// the issue keys and IDs of the path being replaced with `{key}` and
+// `{id}` (e.g. `GET /rest/api/2/issue/{key}/changelog`). The version
+// of the API (e.g. `/rest/agile/1.0`) is kept.
+func Endpoint(req *http.Request) string {
+	segments := strings.Split(req.URL.Path, "/")
+	for i, s := range segments {
+		switch {
+		case i >= 2 && segments[i-2] == "rest":
+			continue
+		case issueKeySegment.MatchString(s):
+			segments[i] = "{key}"
+		case idSegment.MatchString(s):
+			segments[i] = "{id}"
+		}
+	}
+	return req.Method + " " + strings.Join(segments, "/")
+}
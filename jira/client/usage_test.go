@@ -0,0 +1,53 @@
+package client_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+)
+
+func TestUsageTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "1000")
+		if r.URL.Path == "/rest/api/2/search" {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	tr := &client.UsageTransport{}
+	get := func(path string) {
+		res, err := (&http.Client{Transport: tr}).Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	get("/rest/api/2/search")
+	before := tr.Usage()
+	get("/rest/api/2/issue/PJ-1")
+	get("/rest/api/2/issue/PJ-2")
+	get("/rest/api/2/issue/10001/worklog")
+
+	u := tr.Usage()
+	expected := map[string]int{
+		"GET /rest/api/2/search":             1,
+		"GET /rest/api/2/issue/{key}":        2,
+		"GET /rest/api/2/issue/{id}/worklog": 1,
+	}
+	if !reflect.DeepEqual(u.Calls, expected) {
+		t.Errorf("expected calls %v, got %v", expected, u.Calls)
+	}
+	if u.Total() != 4 || u.Throttled != 1 || u.Limit != 1000 {
+		t.Errorf("expected 4 calls, 1 throttled and a limit of 1000, got %d, %d and %d", u.Total(), u.Throttled, u.Limit)
+	}
+
+	d := u.Sub(before)
+	delete(expected, "GET /rest/api/2/search")
+	if !reflect.DeepEqual(d.Calls, expected) || d.Throttled != 0 {
+		t.Errorf("expected calls %v and none throttled since the first request, got %v and %d", expected, d.Calls, d.Throttled)
+	}
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// webhooksPath is the path of the webhooks endpoint of Jira API.
+const webhooksPath = "rest/webhooks/1.0/webhook"
+
+// Webhook is the configuration of a Jira webhook.
+type Webhook struct {
+	// Self is the URL of the webhook in Jira API, set once it's
+	// registered.
+	Self string `json:"self,omitempty"`
+
+	Name string `json:"name"`
+
+	// URL is the URL the events are posted to.
+	URL string `json:"url"`
+
+	// Events are the events posted (e.g. `jira:issue_updated`).
+	Events []string `json:"events"`
+
+	// Filters restrict the events posted, e.g. the JQL query of
+	// the issues with the `issue-related-events-section` key.
+	Filters map[string]string `json:"filters,omitempty"`
+
+	// Secret is used by Jira to sign the events it posts (see
+	// `webhook.Receiver.SetSecret`). Not signed if empty.
+	Secret string `json:"secret,omitempty"`
+
+	Enabled     bool `json:"enabled"`
+	ExcludeBody bool `json:"excludeBody"`
+}
+
+// JQLFilter is the key of `Webhook.Filters` restricting the events
+// of issues, comments and worklogs to the issues matching a JQL
+// query.
+const JQLFilter = "issue-related-events-section"
+
+// GetWebhooks fetches the webhooks registered in Jira. It requires
+// the credentials of an administrator.
+func (c *APIClient) GetWebhooks() ([]Webhook, error) {
+	req, err := c.NewRequest("GET", webhooksPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	var webhooks []Webhook
+	if _, err = c.Do(req, &webhooks); err != nil {
+		return nil, fmt.Errorf("error fetching webhooks: %s", err)
+	}
+	return webhooks, nil
+}
+
+// RegisterWebhook creates the webhook in Jira, or updates the
+// webhook having the same name if there's one (e.g. to change its
+// URL after a new deployment of the receiver), so it can be run
+// on each deployment. Returns the registered webhook. It requires
+// the credentials of an administrator.
+func (c *APIClient) RegisterWebhook(w Webhook) (Webhook, error) {
+	existing, err := c.GetWebhooks()
+	if err != nil {
+		return w, err
+	}
+	method, u := "POST", webhooksPath
+	for _, e := range existing {
+		if e.Name == w.Name && e.Self != "" {
+			method, u = "PUT", e.Self
+			break
+		}
+	}
+	req, err := c.NewRequest(method, u, w)
+	if err != nil {
+		return w, err
+	}
+	var registered Webhook
+	if _, err = c.Do(req, &registered); err != nil {
+		return w, fmt.Errorf("error registering webhook `%s`: %s", w.Name, err)
+	}
+	if method == "PUT" {
+		logging.Infof("Updated webhook `%s` (%s)", w.Name, registered.Self)
+	} else {
+		logging.Infof("Created webhook `%s` (%s)", w.Name, registered.Self)
+	}
+	return registered, nil
+}
@@ -0,0 +1,64 @@
+package client_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+)
+
+func TestAPIClient_RegisterWebhook(t *testing.T) {
+	var registered []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/rest/webhooks/1.0/webhook":
+			json.NewEncoder(w).Encode([]client.Webhook{
+				{Self: srv.URL + "/rest/webhooks/1.0/webhook/1", Name: "other"},
+				{Self: srv.URL + "/rest/webhooks/1.0/webhook/2", Name: "agilizer"},
+			})
+		case r.Method == "PUT" || r.Method == "POST":
+			var wh client.Webhook
+			if err := json.NewDecoder(r.Body).Decode(&wh); err != nil {
+				t.Fatal(err)
+			}
+			registered = append(registered, r.Method+" "+r.URL.Path+" "+wh.URL+" "+wh.Filters[client.JQLFilter])
+			wh.Self = srv.URL + r.URL.Path
+			json.NewEncoder(w).Encode(wh)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := client.NewAPIClientWithOptions(client.Options{BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wh := client.Webhook{
+		Name:    "agilizer",
+		URL:     "https://agilizer.example.com/webhook",
+		Events:  []string{"jira:issue_updated"},
+		Filters: map[string]string{client.JQLFilter: "project = PJ"},
+		Enabled: true,
+	}
+
+	// The webhook with the same name is updated
+	if _, err = c.RegisterWebhook(wh); err != nil {
+		t.Fatal(err)
+	}
+	// A new one is created
+	wh.Name = "new"
+	if _, err = c.RegisterWebhook(wh); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"PUT /rest/webhooks/1.0/webhook/2 https://agilizer.example.com/webhook project = PJ",
+		"POST /rest/webhooks/1.0/webhook https://agilizer.example.com/webhook project = PJ",
+	}
+	if len(registered) != 2 || registered[0] != expected[0] || registered[1] != expected[1] {
+		t.Errorf("expected %v, got %v", expected, registered)
+	}
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// IssueTypeStatuses are the statuses valid for an issue type of a
+// project.
+type IssueTypeStatuses struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name"`
+	Statuses []jira.Status `json:"statuses"`
+}
+
+// WorkflowScheme maps the issue types of a project to their
+// workflow.
+type WorkflowScheme struct {
+	Name string `json:"name"`
+
+	// DefaultWorkflow is the workflow of the issue types missing
+	// from `IssueTypeMappings`.
+	DefaultWorkflow string `json:"defaultWorkflow"`
+
+	// IssueTypeMappings maps the IDs of the issue types to the
+	// names of their workflows.
+	IssueTypeMappings map[string]string `json:"issueTypeMappings"`
+}
+
+// WorkflowTransition is a transition of a workflow.
+type WorkflowTransition struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// From are the IDs of the statuses the transition is available
+	// from, none for global and initial transitions.
+	From []string `json:"from"`
+
+	// To is the ID of the status the transition leads to.
+	To string `json:"to"`
+}
+
+// GetProjects fetches all the projects the user can browse.
+func (c *APIClient) GetProjects() (jira.ProjectList, error) {
+	projects, _, err := c.Project.GetList()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching projects: %s", err)
+	}
+	logging.Infof("Fetched %d projects", len(*projects))
+	return *projects, nil
+}
+
+// GetProjectStatuses fetches the issue types of the project and
+// their valid statuses.
+func (c *APIClient) GetProjectStatuses(projectKey string) ([]IssueTypeStatuses, error) {
+	req, err := c.NewRequest("GET", fmt.Sprintf("rest/api/2/project/%s/statuses", url.PathEscape(projectKey)), nil)
+	if err != nil {
+		return nil, err
+	}
+	var its []IssueTypeStatuses
+	if _, err = c.Do(req, &its); err != nil {
+		return nil, fmt.Errorf("error fetching statuses of project `%s`: %s", projectKey, err)
+	}
+	return its, nil
+}
+
+// GetWorkflowScheme fetches the workflow scheme of the project
+// specified by its ID.
+//
+// Returns nil and no error if the credentials can't read it (it
+// requires the _Administer Jira_ permission) or the Jira instance
+// doesn't support it (e.g. Jira Server).
+func (c *APIClient) GetWorkflowScheme(projectID string) (*WorkflowScheme, error) {
+	req, err := c.NewRequest("GET", "rest/api/2/workflowscheme/project?projectId="+url.QueryEscape(projectID), nil)
+	if err != nil {
+		return nil, err
+	}
+	var page struct {
+		Values []struct {
+			WorkflowScheme WorkflowScheme `json:"workflowScheme"`
+		} `json:"values"`
+	}
+	res, err := c.Do(req, &page)
+	if res != nil && (res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching workflow scheme of project %s: %s", projectID, err)
+	}
+	if len(page.Values) == 0 {
+		return nil, nil
+	}
+	return &page.Values[0].WorkflowScheme, nil
+}
+
+// GetWorkflowTransitions fetches the transitions of the workflow
+// specified by its name.
+func (c *APIClient) GetWorkflowTransitions(workflow string) ([]WorkflowTransition, error) {
+	req, err := c.NewRequest("GET", "rest/api/2/workflow/search?expand=transitions&workflowName="+url.QueryEscape(workflow), nil)
+	if err != nil {
+		return nil, err
+	}
+	var page struct {
+		Values []struct {
+			Transitions []WorkflowTransition `json:"transitions"`
+		} `json:"values"`
+	}
+	if _, err = c.Do(req, &page); err != nil {
+		return nil, fmt.Errorf("error fetching transitions of workflow `%s`: %s", workflow, err)
+	}
+	if len(page.Values) == 0 {
+		return nil, nil
+	}
+	return page.Values[0].Transitions, nil
+}
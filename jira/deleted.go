@@ -0,0 +1,93 @@
+package jira
+
+import (
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// DeletionStore is implemented by stores able to mark the issues no
+// longer found in Jira (deleted, or moved to a project the
+// credentials can't browse) as deleted (e.g. `store.PGStore`).
+type DeletionStore interface {
+	MarkDeletedIssues(foundKeys []string, at time.Time) (int, error)
+}
+
+// searchedKeys records the keys of the issues found by a search, to
+// detect the issues of the store which were not found.
+type searchedKeys struct {
+	keys []string
+}
+
+// tee returns a `send` function for `syncIssues` sending the keys of
+// the issues matching the JQL query, and recording them.
+func (sk *searchedKeys) tee(c Client, query string) func(issueKeys chan string) error {
+	return func(issueKeys chan string) error {
+		found := make(chan string, 100)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer close(issueKeys)
+			for k := range found {
+				sk.keys = append(sk.keys, k)
+				issueKeys <- k
+			}
+		}()
+		err := c.SearchIssues(query, found)
+		<-done
+		return err
+	}
+}
+
+// markDeletedIssues marks the issues of the store which were not
+// found by the full sync (`foundKeys`) as deleted, if the store can
+// (see `DeletionStore`). Since a filtered sync doesn't find all the
+// issues, nothing is marked if the client is filtered (see
+// `NewFilteredClient`).
+func markDeletedIssues(c Client, s store.Store, foundKeys []string, at time.Time) {
+	ds, ok := s.(DeletionStore)
+	if !ok {
+		return
+	}
+	if isFiltered(c) {
+		logging.Infof("Deleted issues not detected since the sync is filtered")
+		return
+	}
+	count, err := ds.MarkDeletedIssues(foundKeys, at)
+	if err != nil {
+		logging.Errorf("Could not mark the deleted issues: %s", err)
+		return
+	}
+	if count > 0 {
+		logging.Infof("%d issues not found anymore marked as deleted", count)
+	}
+}
+
+// filterJQL returns the JQL of the filter restricting the client's
+// searches (see `NewFilteredClient`), empty if there is none.
+func filterJQL(c Client) string {
+	switch w := c.(type) {
+	case *filteredClient:
+		return w.filter.JQL()
+	case *Sources:
+		return filterJQL(w.Client)
+	case *limitedClient:
+		return filterJQL(w.Client)
+	}
+	return ""
+}
+
+// isFiltered returns true if the client's searches are restricted by
+// a filter (see `NewFilteredClient`).
+func isFiltered(c Client) bool {
+	switch w := c.(type) {
+	case *filteredClient:
+		return true
+	case *Sources:
+		return isFiltered(w.Client)
+	case *limitedClient:
+		return isFiltered(w.Client)
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package jira
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// Filter restricts the issues fetched by a sync to those matching
+// all of its non-empty criteria, e.g. only issues labeled "security".
+// Values within a criterion are alternatives.
+type Filter struct {
+	Projects   []string
+	Labels     []string
+	Components []string
+	IssueTypes []string
+
+	// Query is a raw JQL clause the issues must match too, e.g.
+	// the query of a source (see `Source`).
+	Query string
+}
+
+// IsEmpty returns true if the filter has no criterion.
+func (f Filter) IsEmpty() bool {
+	return len(f.Projects) == 0 && len(f.Labels) == 0 && len(f.Components) == 0 && len(f.IssueTypes) == 0 && f.Query == ""
+}
+
+// JQL returns the JQL clause matching the filter's criteria, or an
+// empty string if the filter is empty.
+//
+// Example: `labels IN ("security") AND component IN ("API", "Web")`
+func (f Filter) JQL() string {
+	var clauses []string
+	for _, c := range []struct {
+		field  string
+		values []string
+	}{
+		{"project", f.Projects},
+		{"labels", f.Labels},
+		{"component", f.Components},
+		{"issuetype", f.IssueTypes},
+	} {
+		if len(c.values) == 0 {
+			continue
+		}
+		quoted := make([]string, len(c.values))
+		for i, v := range c.values {
+			quoted[i] = quoteJQL(v)
+		}
+		clauses = append(clauses, fmt.Sprintf("%s IN (%s)", c.field, strings.Join(quoted, ", ")))
+	}
+	if f.Query != "" {
+		clauses = append(clauses, fmt.Sprintf("(%s)", f.Query))
+	}
+	return strings.Join(clauses, " AND ")
+}
+
+// Apply composes the filter's clause with the query, keeping the
+// query's `ORDER BY` clause (if any) at the end.
+//
+// Example: `updated > '2020/1/2 10:00' ORDER BY updated ASC` becomes
+// `labels IN ("security") AND (updated > '2020/1/2 10:00') ORDER BY
+// updated ASC`.
+func (f Filter) Apply(query string) string {
+	clause := f.JQL()
+	if clause == "" {
+		return query
+	}
+	condition, orderBy := query, ""
+	if i := strings.Index(strings.ToUpper(query), "ORDER BY"); i >= 0 {
+		condition, orderBy = strings.TrimSpace(query[:i]), query[i:]
+	}
+	q := clause
+	if condition != "" {
+		q = fmt.Sprintf("%s AND (%s)", clause, condition)
+	}
+	if orderBy != "" {
+		q = q + " " + orderBy
+	}
+	return q
+}
+
+// quoteJQL returns the value as a JQL string literal.
+func quoteJQL(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(v) + `"`
+}
+
+// ProjectPermissionChecker is implemented by clients able to check
+// the permissions of the credentials on a project (e.g.
+// `APIClient`).
+type ProjectPermissionChecker interface {
+	CanBrowseProject(projectKey string) (bool, error)
+}
+
+// CheckProjectPermissions checks the credentials of the client can
+// browse the projects of the filter. Jira returns no issue from the
+// projects the credentials can't browse, so a warning is logged for
+// each of them and they are removed from the returned filter.
+//
+// Returns an error if none of the filter's projects can be browsed,
+// since removing them all would sync all projects instead. The
+// filter is returned unchanged if the client can't check
+// permissions.
+func CheckProjectPermissions(c Client, f Filter) (Filter, error) {
+	pc, ok := c.(ProjectPermissionChecker)
+	if !ok || len(f.Projects) == 0 {
+		return f, nil
+	}
+	var browsable []string
+	for _, p := range f.Projects {
+		ok, err := pc.CanBrowseProject(p)
+		if err != nil {
+			return f, fmt.Errorf("could not check permissions on project `%s`: %s", p, err)
+		}
+		if !ok {
+			logging.Warnf("Project `%s` does not exist or the credentials lack the permission to browse it, its issues are skipped", p)
+			continue
+		}
+		browsable = append(browsable, p)
+	}
+	if len(browsable) == 0 {
+		return f, fmt.Errorf("none of the projects %v can be browsed with the credentials", f.Projects)
+	}
+	f.Projects = browsable
+	return f, nil
+}
+
+// NewFilteredClient returns a client restricting the searches of `c`
+// to the issues matching the filter. Returns `c` if the filter is
+// empty.
+func NewFilteredClient(c Client, f Filter) Client {
+	if f.IsEmpty() {
+		return c
+	}
+	return &filteredClient{Client: c, filter: f}
+}
+
+type filteredClient struct {
+	Client
+	filter Filter
+}
+
+// SearchIssues searches the issues matching both the query and
+// the filter.
+func (c *filteredClient) SearchIssues(query string, issueKeys chan string) error {
+	return c.Client.SearchIssues(c.filter.Apply(query), issueKeys)
+}
+
+// unfiltered returns the client wrapped by a filtered client (or by
+// `Sources`, or a limited client), or the client itself, to check
+// its optional capabilities (e.g. `ChangelogFetcher`), which don't
+// depend on the filter.
+func unfiltered(c Client) Client {
+	switch w := c.(type) {
+	case *filteredClient:
+		return w.Client
+	case *Sources:
+		return unfiltered(w.Client)
+	case *limitedClient:
+		return unfiltered(w.Client)
+	}
+	return c
+}
+
+// ClockSkew returns the clock skew measured by the wrapped client,
+// if it's a `ClockSkewer`.
+func (c *filteredClient) ClockSkew() time.Duration {
+	if cs, ok := c.Client.(ClockSkewer); ok {
+		return cs.ClockSkew()
+	}
+	return 0
+}
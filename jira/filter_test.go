@@ -0,0 +1,75 @@
+package jira_test
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+)
+
+func TestFilter_Apply(t *testing.T) {
+	f := jira.Filter{
+		Labels:     []string{"security"},
+		Components: []string{"API", `Web "front"`},
+	}
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		{
+			"ORDER BY updated ASC",
+			`labels IN ("security") AND component IN ("API", "Web \"front\"") ORDER BY updated ASC`,
+		},
+		{
+			"updated > '2020/1/2 10:0' ORDER BY updated ASC",
+			`labels IN ("security") AND component IN ("API", "Web \"front\"") AND (updated > '2020/1/2 10:0') ORDER BY updated ASC`,
+		},
+		{
+			"project = PJ",
+			`labels IN ("security") AND component IN ("API", "Web \"front\"") AND (project = PJ)`,
+		},
+	}
+	for _, c := range cases {
+		if q := f.Apply(c.query); q != c.expected {
+			t.Errorf("expected `%s`, got `%s`", c.expected, q)
+		}
+	}
+
+	if q := (jira.Filter{}).Apply("ORDER BY updated ASC"); q != "ORDER BY updated ASC" {
+		t.Errorf("expected the query to be unchanged with an empty filter, got `%s`", q)
+	}
+}
+
+func TestNewFilteredClient(t *testing.T) {
+	m := client.NewMockClient(t)
+	c := jira.NewFilteredClient(m, jira.Filter{IssueTypes: []string{"Bug"}})
+	m.ExpectSearchIssues(`issuetype IN \("Bug"\) ORDER BY updated ASC`).WillRespondWithIssueKeys([]string{})
+
+	issueKeys := make(chan string, 1)
+	c.SearchIssues("ORDER BY updated ASC", issueKeys)
+}
+
+type permissionsClientMock struct {
+	*client.MockClient
+	browsable map[string]bool
+}
+
+func (c *permissionsClientMock) CanBrowseProject(projectKey string) (bool, error) {
+	return c.browsable[projectKey], nil
+}
+
+func TestCheckProjectPermissions(t *testing.T) {
+	c := &permissionsClientMock{client.NewMockClient(t), map[string]bool{"PJ": true}}
+
+	f, err := jira.CheckProjectPermissions(c, jira.Filter{Projects: []string{"PJ", "SECRET"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(f.Projects) != 1 || f.Projects[0] != "PJ" {
+		t.Errorf("expected only `PJ` to be kept, got %v", f.Projects)
+	}
+
+	if _, err = jira.CheckProjectPermissions(c, jira.Filter{Projects: []string{"SECRET"}}); err == nil {
+		t.Errorf("expected an error when no project can be browsed")
+	}
+}
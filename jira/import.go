@@ -0,0 +1,48 @@
+package jira
+
+import (
+	"io"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// ImportIssues maps the raw issues read from `r` (see
+// `mapping.DecodeIssues` for the supported layouts, e.g. the JSON
+// files of Jira Cloud's "export all data") and writes their records
+// to the store, like a full sync does with the issues fetched from
+// Jira. It's meant to merge historical data which can't be fetched
+// anymore (e.g. predating the API retention, or from a decommissioned
+// instance) into the store.
+//
+// The records of an issue already in the store are replaced. Returns
+// the number of imported issues.
+func ImportIssues(r io.Reader, s store.Store, m Mapper) (int, error) {
+	beforeImport := time.Now()
+	logging.Infof("Import starting")
+	write, flush := batchWriter(s, 0)
+	prog := startProgress("Import")
+	count := 0
+	err := mapping.DecodeIssues(r, func(i *jira.Issue) error {
+		count++
+		prog.discover()
+		prog.fetch()
+		err := write(i.Key, m.IssueStateFromIssue(i), m.IssueEventsFromIssue(i))
+		prog.stored(err)
+		logStoreError(i.Key, err)
+		return nil
+	})
+	prog.searchDone()
+	flush()
+	prog.stop()
+	if err != nil {
+		logging.Errorf("Import failed after %d issues: %s", count, err)
+		return count, err
+	}
+	logging.Infof("Imported %d issues in %f minutes", count, time.Since(beforeImport).Minutes())
+	return count, nil
+}
@@ -0,0 +1,55 @@
+package jira
+
+import (
+	"errors"
+)
+
+// errLimitReached is returned by the searches of a limited client
+// when they are stopped at the limit (see `NewLimitedClient`).
+var errLimitReached = errors.New("limit of issues reached")
+
+// NewLimitedClient returns a client whose searches only send the
+// keys of the first `n` issues found by `c`, to sync a few issues
+// end-to-end (e.g. to smoke-test a configuration change). Returns
+// `c` if `n` is 0.
+//
+// A search stopped at the limit fails with `errLimitReached`, so the
+// sync is not recorded as successful: the next incremental sync
+// doesn't skip the issues left aside, and a full sync doesn't mark
+// them as deleted.
+func NewLimitedClient(c Client, n int) Client {
+	if n <= 0 {
+		return c
+	}
+	return &limitedClient{Client: c, limit: n}
+}
+
+type limitedClient struct {
+	Client
+	limit int
+}
+
+// SearchIssues sends the keys of the first issues matching the
+// query, up to the limit. The search of the wrapped client is then
+// left blocked on its next key rather than fetching the remaining
+// pages of results, so the client is meant for one-off syncs.
+func (c *limitedClient) SearchIssues(query string, issueKeys chan string) error {
+	defer close(issueKeys)
+	found := make(chan string)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.Client.SearchIssues(query, found)
+	}()
+	for n := 0; n < c.limit; n++ {
+		k, ok := <-found
+		if !ok {
+			return <-errs
+		}
+		issueKeys <- k
+	}
+	// The search is not stopped if there are no more keys
+	if _, ok := <-found; !ok {
+		return <-errs
+	}
+	return errLimitReached
+}
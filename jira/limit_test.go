@@ -0,0 +1,48 @@
+package jira_test
+
+import (
+	"context"
+	"testing"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+func TestNewLimitedClient(t *testing.T) {
+	m := client.NewMockClient(t)
+	s := &syncRunMockStore{MockStore: NewMockStore(t)}
+	c := jira.NewLimitedClient(m, 2)
+
+	// Only the first 2 issues are fetched and stored
+	m.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2", "PJ-3"})
+	for _, k := range []string{"PJ-1", "PJ-2"} {
+		m.ExpectGetIssue(k).WillRespondWithIssue(&extJira.Issue{})
+		s.ExpectReplaceIssueStateAndEvents().WithIssueKey(k).WithIssueState(&store.IssueState{}).WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}})
+	}
+
+	jira.PerformSync(context.Background(), c, s, 10, &mapperMock{})
+
+	// The sync stopped at the limit is not successful
+	if len(s.started) != 1 || len(s.finished) != 0 {
+		t.Errorf("expected the limited sync run to be left unfinished, got started %v, finished %v", s.started, s.finished)
+	}
+}
+
+func TestNewLimitedClient_NotReached(t *testing.T) {
+	m := client.NewMockClient(t)
+	s := &syncRunMockStore{MockStore: NewMockStore(t)}
+	c := jira.NewLimitedClient(m, 5)
+
+	m.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1"})
+	m.ExpectGetIssue("PJ-1").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().WithIssueKey("PJ-1").WithIssueState(&store.IssueState{}).WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}})
+
+	jira.PerformSync(context.Background(), c, s, 10, &mapperMock{})
+
+	if len(s.finished) != 1 || s.finished[0] != 1 {
+		t.Errorf("expected the sync of all the issues to be finished, got %v", s.finished)
+	}
+}
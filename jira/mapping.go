@@ -11,3 +11,14 @@ type Mapper interface {
 	IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent
 	IssueStateFromIssue(i *extJira.Issue) store.IssueState
 }
+
+// MapIssueForKey fetches the issue like the syncs do (its whole
+// changelog and worklogs included) and returns its records mapped
+// with the mapper, without storing them.
+func MapIssueForKey(c Client, issueKey string, m Mapper) (store.IssueState, []store.IssueEvent, error) {
+	i, err := getIssue(c, issueKey)
+	if err != nil {
+		return store.IssueState{}, nil, err
+	}
+	return m.IssueStateFromIssue(i), m.IssueEventsFromIssue(i), nil
+}
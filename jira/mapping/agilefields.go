@@ -0,0 +1,86 @@
+package mapping
+
+import (
+	"fmt"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+)
+
+// DefaultAgileFields are the IDs of the custom fields of Jira
+// Software and Advanced Roadmaps read when none are configured (see
+// `Mapper.AgileFields`). They are the fields of the Jira instance
+// this application was first written for.
+var DefaultAgileFields = config.AgileFields{
+	EpicLink:   config.FieldID{ID: "customfield_10009"},
+	Sprint:     config.FieldID{ID: "customfield_10005"},
+	EpicName:   config.FieldID{ID: "customfield_10011"},
+	EpicColor:  config.FieldID{ID: "customfield_10013"},
+	ParentLink: config.FieldID{ID: "customfield_10018"},
+}
+
+// agileFieldNames are the names of the fields of
+// `config.AgileFields` in the configuration, in the order of
+// `agileFieldRefs`.
+var agileFieldNames = []string{"epic_link", "sprint", "epic_name", "epic_color", "parent_link"}
+
+// agileFieldRefs returns the fields of `af`.
+func agileFieldRefs(af *config.AgileFields) []*config.FieldID {
+	return []*config.FieldID{&af.EpicLink, &af.Sprint, &af.EpicName, &af.EpicColor, &af.ParentLink}
+}
+
+// agileColumns are the columns of `Fields` and `eventFields` mapped
+// from the agile fields, with the field of each.
+var agileColumns = map[string]func(config.AgileFields) config.FieldID{
+	"issue_epic":       func(af config.AgileFields) config.FieldID { return af.EpicLink },
+	"issue_sprints":    func(af config.AgileFields) config.FieldID { return af.Sprint },
+	"issue_sprint_ids": func(af config.AgileFields) config.FieldID { return af.Sprint },
+	"issue_epic_name":  func(af config.AgileFields) config.FieldID { return af.EpicName },
+	"issue_epic_color": func(af config.AgileFields) config.FieldID { return af.EpicColor },
+	"sprint_id":        func(af config.AgileFields) config.FieldID { return af.Sprint },
+	"sprint_name":      func(af config.AgileFields) config.FieldID { return af.Sprint },
+}
+
+// ValidateAgileFields returns an error if an agile field has an
+// invalid ID (including those of `ProjectIDs`).
+func ValidateAgileFields(af config.AgileFields) error {
+	for i, f := range agileFieldRefs(&af) {
+		if f.ID != "" && !customFieldID.MatchString(f.ID) {
+			return fmt.Errorf("invalid ID `%s` of `%s` (expected e.g. `customfield_10005`)", f.ID, agileFieldNames[i])
+		}
+		for project, id := range f.ProjectIDs {
+			if !customFieldID.MatchString(id) {
+				return fmt.Errorf("invalid ID `%s` of `%s` for project `%s` (expected e.g. `customfield_10005`)", id, agileFieldNames[i], project)
+			}
+		}
+	}
+	return nil
+}
+
+// withDefaultAgileFields returns the agile fields, those without an
+// ID set to the ones of `DefaultAgileFields`.
+func withDefaultAgileFields(af config.AgileFields) config.AgileFields {
+	defaults := agileFieldRefs(&DefaultAgileFields)
+	for i, f := range agileFieldRefs(&af) {
+		if f.ID == "" {
+			f.ID = defaults[i].ID
+		}
+	}
+	return af
+}
+
+// agileFields returns the agile fields of the mapper, with the
+// defaults of the fields not set.
+func (m *Mapper) agileFields() config.AgileFields {
+	return withDefaultAgileFields(m.AgileFields)
+}
+
+// fieldID returns the ID of the field for the issue's project (see
+// `config.FieldID.ProjectIDs`).
+func fieldID(i *extJira.Issue, f config.FieldID) string {
+	if id, ok := f.ProjectIDs[i.Fields.Project.Name]; ok {
+		return id
+	}
+	return f.ID
+}
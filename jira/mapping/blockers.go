@@ -0,0 +1,43 @@
+package mapping
+
+import (
+	"strings"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// linkChangelogField is the name of the changelog field of the
+// links added and removed.
+const linkChangelogField = "Link"
+
+// blockedByDescription is the inward description of the "Blocks"
+// link type, found in the changelog items of the links (e.g. "This
+// issue is blocked by PJ-2").
+const blockedByDescription = "is blocked by"
+
+// blockerEvent returns the `field_changed` event of the addition or
+// removal of an "is blocked by" link by the changelog item, with the
+// key of the blocker as value (see `store.FieldBlockedBy`). Returns
+// false if the item is not about such a link.
+func blockerEvent(i *extJira.Issue, h extJira.ChangelogHistory, item extJira.ChangelogItems) (store.IssueEvent, bool) {
+	field := store.FieldBlockedBy
+	ie := store.IssueEvent{
+		EventTime:            parseTime(h.Created),
+		EventKind:            store.EventFieldChanged,
+		EventAuthor:          h.Author.Name,
+		EventAuthorAccountID: accountID(&h.Author),
+		IssueKey:             i.Key,
+		FieldName:            &field,
+	}
+	switch {
+	case strings.Contains(item.ToString, blockedByDescription):
+		ie.FieldChangeTo = changelogID(item.To)
+		return ie, ie.FieldChangeTo != nil
+	case strings.Contains(item.FromString, blockedByDescription):
+		ie.FieldChangeFrom = changelogID(item.From)
+		return ie, ie.FieldChangeFrom != nil
+	}
+	return ie, false
+}
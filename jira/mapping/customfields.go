@@ -0,0 +1,189 @@
+package mapping
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Types of the custom fields (see `config.CustomField`)
+const (
+	CustomFieldUser     = "user"
+	CustomFieldOption   = "option"
+	CustomFieldText     = "text"
+	CustomFieldNumber   = "number"
+	CustomFieldDate     = "date"
+	CustomFieldDateTime = "datetime"
+)
+
+// customFieldColumnTypes are the SQL types of the columns of each
+// type of custom field.
+var customFieldColumnTypes = map[string]string{
+	CustomFieldUser:     store.CustomColumnText,
+	CustomFieldOption:   store.CustomColumnText,
+	CustomFieldText:     store.CustomColumnText,
+	CustomFieldNumber:   store.CustomColumnNumeric,
+	CustomFieldDate:     store.CustomColumnDate,
+	CustomFieldDateTime: store.CustomColumnTimestamp,
+}
+
+// DefaultCustomFields are the custom fields mapped when none are
+// configured. They are the fields of the Jira instance this
+// application was first written for.
+var DefaultCustomFields = []config.CustomField{
+	{ID: "customfield_10600", Column: "issue_developer_backend", Type: CustomFieldUser, Name: "Developer Backend", Description: "Name of the backend developer of the issue."},
+	{ID: "customfield_12403", Column: "issue_developer_frontend", Type: CustomFieldUser, Name: "Developer Frontend", Description: "Name of the frontend developer of the issue."},
+	{ID: "customfield_10601", Column: "issue_reviewer", Type: CustomFieldUser, Name: "Reviewer", Description: "Name of the reviewer of the issue."},
+	{ID: "customfield_11200", Column: "issue_product_owner", Type: CustomFieldUser, Name: "Product Owner", Description: "Name of the product owner of the issue."},
+	{ID: "customfield_11101", Column: "issue_bug_cause", Type: CustomFieldOption, Name: "Bug Cause", Description: "Cause of the bug, for bugs."},
+	{ID: "customfield_12100", Column: "issue_tribe", Type: CustomFieldOption, Name: "Tribe", Description: "Tribe in charge of the issue."},
+}
+
+var (
+	customFieldID     = regexp.MustCompile(`^customfield_[0-9]+$`)
+	customFieldColumn = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+)
+
+// ValidateCustomFields returns an error if a custom field has an
+// invalid ID (including those of `ProjectIDs`), column or type, or
+// if its column is already used by another field.
+func ValidateCustomFields(cfs []config.CustomField) error {
+	columns := make(map[string]bool)
+	for _, f := range Fields {
+		columns[f.Column] = true
+	}
+	for _, cf := range cfs {
+		switch {
+		case !customFieldID.MatchString(cf.ID):
+			return fmt.Errorf("invalid custom field ID `%s` (expected e.g. `customfield_10600`)", cf.ID)
+		case !customFieldColumn.MatchString(cf.Column):
+			return fmt.Errorf("invalid column `%s` for custom field `%s` (expected lowercase letters, digits and underscores)", cf.Column, cf.ID)
+		case columns[cf.Column]:
+			return fmt.Errorf("column `%s` of custom field `%s` is already used", cf.Column, cf.ID)
+		case customFieldColumnTypes[cf.Type] == "":
+			return fmt.Errorf("invalid type `%s` for custom field `%s`", cf.Type, cf.ID)
+		}
+		for project, id := range cf.ProjectIDs {
+			if !customFieldID.MatchString(id) {
+				return fmt.Errorf("invalid ID `%s` of custom field `%s` for project `%s` (expected e.g. `customfield_10600`)", id, cf.ID, project)
+			}
+		}
+		columns[cf.Column] = true
+	}
+	return nil
+}
+
+// MergeCustomFields returns the custom fields of all the sets (e.g.
+// of several sources), a column used by several sets being
+// returned once. Returns an error if the sets use a column with
+// different types.
+func MergeCustomFields(sets ...[]config.CustomField) ([]config.CustomField, error) {
+	var merged []config.CustomField
+	types := make(map[string]string)
+	for _, cfs := range sets {
+		for _, cf := range cfs {
+			t, ok := types[cf.Column]
+			switch {
+			case !ok:
+				types[cf.Column] = cf.Type
+				merged = append(merged, cf)
+			case t != cf.Type:
+				return nil, fmt.Errorf("column `%s` is used by custom fields of types `%s` and `%s`", cf.Column, t, cf.Type)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// CustomColumns returns the columns of the custom fields, to be set
+// with `store.PGStore.SetCustomColumns`.
+func CustomColumns(cfs []config.CustomField) []store.CustomColumn {
+	cs := make([]store.CustomColumn, len(cfs))
+	for i, cf := range cfs {
+		cs[i] = store.CustomColumn{Name: cf.Column, Type: customFieldColumnTypes[cf.Type], User: cf.Type == CustomFieldUser}
+	}
+	return cs
+}
+
+// customFields returns the values of the mapper's custom fields for
+// the issue, by column.
+func (m *Mapper) customFields(i *extJira.Issue) map[string]interface{} {
+	if len(m.CustomFields) == 0 {
+		return nil
+	}
+	values := make(map[string]interface{}, len(m.CustomFields))
+	for _, cf := range m.CustomFields {
+		values[cf.Column] = customFieldValue(i, cf)
+	}
+	return values
+}
+
+// customFieldValue returns the value of the custom field for the
+// issue, read from the ID of the field for the issue's project (see
+// `config.CustomField.ProjectIDs`), typed as expected by the store
+// for the field's column (e.g. `*string` for a "user" field), or a
+// nil value of this type if the field is not set. Unexpected values
+// are logged and ignored.
+func customFieldValue(i *extJira.Issue, cf config.CustomField) interface{} {
+	if id, ok := cf.ProjectIDs[i.Fields.Project.Name]; ok {
+		cf.ID = id
+	}
+	raw := i.Fields.Unknowns[cf.ID]
+	switch cf.Type {
+	case CustomFieldNumber:
+		v, ok := raw.(float64)
+		if !ok {
+			logUnexpectedValue(i, cf, raw)
+			return (*float64)(nil)
+		}
+		return &v
+	case CustomFieldDate, CustomFieldDateTime:
+		s, ok := raw.(string)
+		if !ok {
+			logUnexpectedValue(i, cf, raw)
+			return (*time.Time)(nil)
+		}
+		layout := "2006-01-02T15:04:05.000-0700"
+		if cf.Type == CustomFieldDate {
+			layout = "2006-01-02"
+		}
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			logUnexpectedValue(i, cf, raw)
+			return (*time.Time)(nil)
+		}
+		return &t
+	}
+	var key string
+	switch cf.Type {
+	case CustomFieldUser:
+		key = "name"
+	case CustomFieldOption:
+		key = "value"
+	}
+	v, ok := raw.(string)
+	if key != "" {
+		obj, _ := raw.(map[string]interface{})
+		v, ok = obj[key].(string)
+	}
+	if !ok {
+		logUnexpectedValue(i, cf, raw)
+		return (*string)(nil)
+	}
+	return &v
+}
+
+// logUnexpectedValue logs the value of the custom field if it's set
+// but doesn't match the field's type.
+func logUnexpectedValue(i *extJira.Issue, cf config.CustomField, raw interface{}) {
+	if raw == nil {
+		return
+	}
+	logging.Warnf("Unexpected value `%v` for %s field `%s` (%s) of issue %s, ignored", raw, cf.Type, cf.Name, cf.ID, i.Key)
+}
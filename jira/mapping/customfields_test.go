@@ -0,0 +1,149 @@
+package mapping_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+)
+
+func TestIssueStateFromIssue_CustomFields(t *testing.T) {
+	m := mapping.Mapper{CustomFields: []config.CustomField{
+		{ID: "customfield_1", Column: "issue_developer", Type: mapping.CustomFieldUser},
+		{ID: "customfield_2", Column: "issue_team", Type: mapping.CustomFieldOption},
+		{ID: "customfield_3", Column: "issue_story_points", Type: mapping.CustomFieldNumber},
+		{ID: "customfield_4", Column: "issue_target_date", Type: mapping.CustomFieldDate},
+		{ID: "customfield_5", Column: "issue_notes", Type: mapping.CustomFieldText},
+	}}
+	i := client.NewIssueFixture("PJ-1").
+		WithCustomField("customfield_1", map[string]interface{}{"name": "alice"}).
+		WithCustomField("customfield_2", map[string]interface{}{"value": "Payments"}).
+		WithCustomField("customfield_3", 5.0).
+		WithCustomField("customfield_4", "2020-03-02").
+		WithCustomField("customfield_5", 42.0). // unexpected type
+		Issue()
+
+	cf := m.IssueStateFromIssue(i).CustomFields
+	if v, ok := cf["issue_developer"].(*string); !ok || v == nil || *v != "alice" {
+		t.Errorf("expected `issue_developer` to be `alice`, got %v", cf["issue_developer"])
+	}
+	if v, ok := cf["issue_team"].(*string); !ok || v == nil || *v != "Payments" {
+		t.Errorf("expected `issue_team` to be `Payments`, got %v", cf["issue_team"])
+	}
+	if v, ok := cf["issue_story_points"].(*float64); !ok || v == nil || *v != 5 {
+		t.Errorf("expected `issue_story_points` to be 5, got %v", cf["issue_story_points"])
+	}
+	if v, ok := cf["issue_target_date"].(*time.Time); !ok || v == nil || !v.Equal(time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected `issue_target_date` to be 2020-03-02, got %v", cf["issue_target_date"])
+	}
+	if v, ok := cf["issue_notes"].(*string); !ok || v != nil {
+		t.Errorf("expected `issue_notes` with an unexpected value to be a nil `*string`, got %v", cf["issue_notes"])
+	}
+}
+
+func TestIssueStateFromIssue_CustomFieldsProjectIDs(t *testing.T) {
+	m := mapping.Mapper{CustomFields: []config.CustomField{
+		{ID: "customfield_10010", Column: "issue_story_points", Type: mapping.CustomFieldNumber, ProjectIDs: map[string]string{"Mobile": "customfield_10555"}},
+	}}
+	for project, expected := range map[string]float64{"Web": 3, "Mobile": 8} {
+		i := client.NewIssueFixture("PJ-1").
+			WithProject("PJ", project).
+			WithCustomField("customfield_10010", 3.0).
+			WithCustomField("customfield_10555", 8.0).
+			Issue()
+		v, ok := m.IssueStateFromIssue(i).CustomFields["issue_story_points"].(*float64)
+		if !ok || v == nil || *v != expected {
+			t.Errorf("expected `issue_story_points` of a %s issue to be %v, got %v", project, expected, v)
+		}
+	}
+
+	fls := mapping.Lineage(m.CustomFields, m.AgileFields)
+	for _, fl := range fls {
+		if fl.Column == "issue_story_points" && fl.Transformation != "Value of the field. Read from customfield_10555 for Mobile." {
+			t.Errorf("unexpected transformation %q", fl.Transformation)
+		}
+	}
+}
+
+func TestIssueStateFromIssue_AgileFields(t *testing.T) {
+	m := mapping.Mapper{AgileFields: config.AgileFields{
+		Sprint:   config.FieldID{ID: "customfield_10020"},
+		EpicName: config.FieldID{ProjectIDs: map[string]string{"Mobile": "customfield_10555"}},
+	}}
+	for project, expected := range map[string]string{"Web": "Default", "Mobile": "Mobile"} {
+		i := client.NewIssueFixture("PJ-1").
+			WithProject("PJ", project).
+			WithType("Epic").
+			WithCustomField("customfield_10020", []interface{}{map[string]interface{}{"id": 1.0, "name": "Sprint 1"}}).
+			WithCustomField("customfield_10011", "Default").
+			WithCustomField("customfield_10555", "Mobile").
+			Issue()
+		s := m.IssueStateFromIssue(i)
+		if s.Sprints == nil || *s.Sprints != "Sprint 1" {
+			t.Errorf("expected the sprints of the configured field, got %v", s.Sprints)
+		}
+		if s.EpicName == nil || *s.EpicName != expected {
+			t.Errorf("expected the epic name of a %s issue to be %s, got %v", project, expected, s.EpicName)
+		}
+	}
+
+	for _, fl := range mapping.Lineage(nil, m.AgileFields) {
+		switch fl.Column {
+		case "issue_sprints", "sprint_id":
+			if fl.JiraFieldID == nil || *fl.JiraFieldID != "customfield_10020" {
+				t.Errorf("expected %s to be read from the configured field, got %v", fl.Column, fl.JiraFieldID)
+			}
+		case "issue_epic_name":
+			if fl.JiraFieldID == nil || *fl.JiraFieldID != "customfield_10011" || !strings.HasSuffix(fl.Transformation, "Read from customfield_10555 for Mobile.") {
+				t.Errorf("expected the default ID and the project IDs of issue_epic_name, got %v (%q)", fl.JiraFieldID, fl.Transformation)
+			}
+		}
+	}
+
+	if err := mapping.ValidateAgileFields(config.AgileFields{ParentLink: config.FieldID{ID: "10018"}}); err == nil {
+		t.Error("expected an error for an invalid ID")
+	}
+}
+
+func TestValidateCustomFields(t *testing.T) {
+	if err := mapping.ValidateCustomFields(mapping.DefaultCustomFields); err != nil {
+		t.Errorf("expected the default custom fields to be valid, got %s", err)
+	}
+	for _, cf := range []config.CustomField{
+		{ID: "10600", Column: "issue_developer", Type: mapping.CustomFieldUser},
+		{ID: "customfield_10600", Column: "issue developer; DROP", Type: mapping.CustomFieldUser},
+		{ID: "customfield_10600", Column: "issue_epic", Type: mapping.CustomFieldUser},
+		{ID: "customfield_10600", Column: "issue_developer", Type: "person"},
+		{ID: "customfield_10600", Column: "issue_developer", Type: mapping.CustomFieldUser, ProjectIDs: map[string]string{"Mobile": "10555"}},
+	} {
+		if err := mapping.ValidateCustomFields([]config.CustomField{cf}); err == nil {
+			t.Errorf("expected %+v to be invalid", cf)
+		}
+	}
+}
+
+func TestMergeCustomFields(t *testing.T) {
+	web := []config.CustomField{
+		{ID: "customfield_10600", Column: "issue_tribe", Type: mapping.CustomFieldOption},
+		{ID: "customfield_10601", Column: "issue_reviewer", Type: mapping.CustomFieldUser},
+	}
+	mobile := []config.CustomField{
+		{ID: "customfield_10800", Column: "issue_tribe", Type: mapping.CustomFieldOption},
+		{ID: "customfield_10801", Column: "issue_platform", Type: mapping.CustomFieldText},
+	}
+	cfs, err := mapping.MergeCustomFields(web, mobile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfs) != 3 || cfs[2].Column != "issue_platform" {
+		t.Errorf("expected the columns of both sources once, got %v", cfs)
+	}
+
+	mobile[0].Type = mapping.CustomFieldText
+	if _, err = mapping.MergeCustomFields(web, mobile); err == nil {
+		t.Errorf("expected an error for a column used with different types")
+	}
+}
@@ -0,0 +1,123 @@
+package mapping
+
+import (
+	"fmt"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+)
+
+// Units of the estimates of the projects (see
+// `config.ProjectEstimate`)
+const (
+	EstimateSeconds = "seconds"
+	EstimateMinutes = "minutes"
+	EstimateHours   = "hours"
+	EstimateDays    = "days"
+	EstimatePoints  = "points"
+)
+
+// originalEstimateField is the ID of the original estimate of time
+// tracking, the default field of the estimates.
+const originalEstimateField = "timeoriginalestimate"
+
+// defaultHoursPerDay is the length of a day of the "days" unit when
+// not configured.
+const defaultHoursPerDay = 8
+
+// estimateUnitHours are the lengths of the units of time, in hours.
+var estimateUnitHours = map[string]float64{
+	EstimateSeconds: 1.0 / 3600,
+	EstimateMinutes: 1.0 / 60,
+	EstimateHours:   1,
+}
+
+// ValidateEstimates returns an error if the estimate of a project
+// has an invalid field or unit, or if a project is configured
+// twice.
+func ValidateEstimates(e config.Estimates) error {
+	projects := make(map[string]bool)
+	for _, pe := range e.Projects {
+		switch {
+		case projects[pe.Project]:
+			return fmt.Errorf("estimate of project `%s` configured twice", pe.Project)
+		case pe.Field != "" && pe.Field != originalEstimateField && !customFieldID.MatchString(pe.Field):
+			return fmt.Errorf("invalid field `%s` for the estimate of project `%s` (expected `%s` or e.g. `customfield_10016`)", pe.Field, pe.Project, originalEstimateField)
+		case pe.Unit != "" && pe.Unit != EstimateDays && pe.Unit != EstimatePoints && estimateUnitHours[pe.Unit] == 0:
+			return fmt.Errorf("invalid unit `%s` for the estimate of project `%s`", pe.Unit, pe.Project)
+		}
+		projects[pe.Project] = true
+	}
+	return nil
+}
+
+// estimate returns the estimate of the issue in seconds and in
+// points, converted from the field and unit configured for its
+// project (see `Mapper.Estimates`). Each is nil if the issue has no
+// estimate or the conversion is not configured.
+func (m *Mapper) estimate(i *extJira.Issue) (*int, *float64) {
+	pe := config.ProjectEstimate{Field: originalEstimateField, Unit: EstimateSeconds}
+	for _, p := range m.Estimates.Projects {
+		if p.Project == i.Fields.Project.Name {
+			pe = p
+			break
+		}
+	}
+	value, ok := estimateValue(i, pe.Field)
+	if !ok {
+		return nil, nil
+	}
+
+	unit := pe.Unit
+	if unit == "" {
+		unit = EstimateSeconds
+	}
+	hours, converted := 0.0, true
+	switch unit {
+	case EstimateDays:
+		hours = value * firstPositive(pe.HoursPerDay, m.Estimates.HoursPerDay, defaultHoursPerDay)
+	case EstimatePoints:
+		hours, converted = value*pe.HoursPerPoint, pe.HoursPerPoint > 0
+	default:
+		hours = value * estimateUnitHours[unit]
+	}
+
+	var seconds *int
+	var points *float64
+	if converted {
+		s := int(hours*3600 + 0.5)
+		seconds = &s
+		if m.Estimates.HoursPerPoint > 0 {
+			p := hours / m.Estimates.HoursPerPoint
+			points = &p
+		}
+	} else {
+		points = &value
+	}
+	return seconds, points
+}
+
+// estimateValue returns the value of the estimate field of the
+// issue, false if the field is not set.
+func estimateValue(i *extJira.Issue, field string) (float64, bool) {
+	if field == "" || field == originalEstimateField {
+		tt := i.Fields.TimeTracking
+		if tt == nil || tt.OriginalEstimate == "" {
+			return 0, false
+		}
+		return float64(tt.OriginalEstimateSeconds), true
+	}
+	v, ok := i.Fields.Unknowns[field].(float64)
+	return v, ok
+}
+
+// firstPositive returns the first positive value.
+func firstPositive(values ...float64) float64 {
+	for _, v := range values {
+		if v > 0 {
+			return v
+		}
+	}
+	return 0
+}
@@ -0,0 +1,128 @@
+package mapping
+
+import (
+	"fmt"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Field describes how a column of `jira_issues_states` (and of the
+// issue columns of `jira_issues_events`) is mapped from Jira.
+type Field struct {
+	// Column is the name of the column in the DB.
+	Column string
+
+	// JiraField is the name of the Jira field, as displayed in Jira.
+	JiraField string
+
+	// CustomFieldID is the ID of the custom field (e.g.
+	// "customfield_10600") or empty for a system field.
+	CustomFieldID string
+
+	// Description explains the content of the column.
+	Description string
+}
+
+// Fields describes the issue columns filled by `IssueStateFromIssue`,
+// except the custom columns configured in `Mapper.CustomFields`. It
+// must be kept in sync with the mapping. The columns mapped from the
+// agile fields have their default IDs (see `Mapper.AgileFields`).
+var Fields = []Field{
+	{"issue_created_at", "Created", "", "Time of the creation of the issue."},
+	{"issue_updated_at", "Updated", "", "Time of the last update of the issue."},
+	{"issue_key", "Key", "", "Key of the issue (e.g. PJ-12)."},
+	{"issue_project", "Project", "", "Name of the issue's project."},
+	{"issue_status", "Status", "", "Current status of the issue, \"N/A\" if it has none."},
+	{"issue_status_category", "Status category", "", "Key of the Jira category of the current status (new, indeterminate or done)."},
+	{"issue_resolved_at", "Resolved", "", "Time of the resolution of the issue, if resolved."},
+	{"issue_priority", "Priority", "", "Priority of the issue, \"N/A\" if it has none."},
+	{"issue_summary", "Summary", "", "Summary (title) of the issue."},
+	{"issue_description", "Description", "", "Description of the issue."},
+	{"issue_type", "Issue Type", "", "Type of the issue (e.g. Bug, Story)."},
+	{"issue_labels", "Labels", "", "Labels of the issue, concatenated."},
+	{"issue_assignee", "Assignee", "", "Name of the current assignee."},
+	{"issue_epic", "Epic Link", DefaultAgileFields.EpicLink.ID, "Key of the issue's epic. For next-gen projects, key of the parent issue (except for epics, whose parent is their initiative)."},
+	{"issue_sprints", "Sprint", DefaultAgileFields.Sprint.ID, "Names of the sprints of the issue, comma-separated."},
+	{"issue_sprint_ids", "Sprint", DefaultAgileFields.Sprint.ID, "IDs of the sprints of the issue (see jira_sprints), comma-separated."},
+	{"issue_epic_name", "Epic Name", DefaultAgileFields.EpicName.ID, "For epics, short name of the epic (distinct from the summary)."},
+	{"issue_epic_color", "Epic Color", DefaultAgileFields.EpicColor.ID, "For epics, color of the epic on boards (e.g. ghx-label-4)."},
+	{"cloned_from_key", "Cloners", "", "Key of the issue this issue was cloned from, if it's a clone."},
+	{"moved_from_project", "Project", "", "Name of the project the issue was created in, if it was moved to another project."},
+	{"issue_components", "Components", "", "Components of the issue, concatenated."},
+	{"issue_fix_versions", "Fix Version/s", "", "Fix versions of the issue, concatenated."},
+	{"issue_original_estimate_seconds", "Original Estimate", "", "Original estimate of the issue, in seconds, if time tracking is enabled."},
+	{"issue_remaining_estimate_seconds", "Remaining Estimate", "", "Remaining estimate of the issue, in seconds, if time tracking is enabled."},
+	{"issue_time_spent_seconds", "Time Spent", "", "Total time logged on the issue, in seconds, if time tracking is enabled."},
+	{"issue_source", "", "", "Name of the source the issue was synced from, if sources are configured."},
+	{"issue_severity_bucket", "Priority", "", "Severity bucket of the issue's priority, if severity buckets are configured."},
+	{"issue_assignee_account_id", "Assignee", "", "Account ID of the current assignee (see jira_users)."},
+	{"issue_estimate_seconds", "", "", "Estimate of the issue in seconds, normalized across projects, if estimates are configured or time tracking is enabled."},
+	{"issue_estimate_points", "", "", "Estimate of the issue in story points, normalized across projects, if estimates are configured."},
+	{"issue_is_generated", "Creator", "", "True if the issue was created by automation or from a template, per the configured creators and labels."},
+	{"issue_properties", "", "", "Configured entity properties of the issue (e.g. data of Marketplace apps), as a JSON object keyed by property."},
+	{"issue_description_html", "Description", "", "Description of the issue rendered in HTML by Jira, if configured."},
+	{"issue_changelog_truncated", "", "", "True if the earliest change of the issue's changelog is later than its creation plus the configured threshold (truncated history)."},
+}
+
+// statesOnlyColumns are the columns of `Fields` which are not
+// copied to `jira_issues_events`.
+var statesOnlyColumns = map[string]bool{
+	"issue_status_category": true,
+	"issue_sprints":         true,
+	"issue_sprint_ids":      true,
+	"issue_epic_name":       true,
+	"issue_epic_color":      true,
+	"cloned_from_key":       true,
+	"moved_from_project":    true,
+
+	"issue_original_estimate_seconds":  true,
+	"issue_remaining_estimate_seconds": true,
+	"issue_time_spent_seconds":         true,
+	"issue_estimate_seconds":           true,
+	"issue_estimate_points":            true,
+	"issue_is_generated":               true,
+	"issue_properties":                 true,
+	"issue_description_html":           true,
+	"issue_changelog_truncated":        true,
+}
+
+// ColumnComments returns the comments of the issue columns of
+// `jira_issues_states` and `jira_issues_events`, built from `Fields`
+// and the custom fields, to be set with
+// `store.PGStore.SetColumnComments`.
+func ColumnComments(cfs []config.CustomField) []store.ColumnComment {
+	fields := Fields
+	for _, cf := range cfs {
+		fields = append(fields, Field{cf.Column, cf.Name, cf.ID, cf.Description})
+	}
+	var comments []store.ColumnComment
+	for _, table := range []string{"jira_issues_states", "jira_issues_events"} {
+		for _, f := range fields {
+			if table == "jira_issues_events" && statesOnlyColumns[f.Column] {
+				continue
+			}
+			comments = append(comments, store.ColumnComment{
+				Table:   table,
+				Column:  f.Column,
+				Comment: f.comment(),
+			})
+		}
+	}
+	return comments
+}
+
+// comment returns the column comment, e.g. "Jira field: Developer
+// Backend (customfield_10600). Name of the backend developer of the
+// issue.", or only the description for the columns not mapped from
+// a Jira field.
+func (f Field) comment() string {
+	if f.JiraField == "" {
+		return f.Description
+	}
+	source := f.JiraField
+	if f.CustomFieldID != "" {
+		source = fmt.Sprintf("%s (%s)", f.JiraField, f.CustomFieldID)
+	}
+	return fmt.Sprintf("Jira field: %s. %s", source, f.Description)
+}
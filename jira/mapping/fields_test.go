@@ -0,0 +1,52 @@
+package mapping_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+func TestColumnComments(t *testing.T) {
+	comments := make(map[string]string)
+	for _, c := range mapping.ColumnComments(mapping.DefaultCustomFields) {
+		comments[c.Table+"."+c.Column] = c.Comment
+	}
+
+	c := comments["jira_issues_states.issue_developer_backend"]
+	if !strings.Contains(c, "Developer Backend (customfield_10600)") {
+		t.Errorf("expected comment to include the Jira field and custom field ID, got `%s`", c)
+	}
+	if _, ok := comments["jira_issues_events.issue_developer_backend"]; !ok {
+		t.Errorf("expected the issue columns of `jira_issues_events` to be commented")
+	}
+	if _, ok := comments["jira_issues_events.issue_sprints"]; ok {
+		t.Errorf("expected no comment for `issue_sprints` which is not in `jira_issues_events`")
+	}
+}
+
+func TestLineage(t *testing.T) {
+	lineage := make(map[string]store.FieldLineage)
+	for _, fl := range mapping.Lineage(mapping.DefaultCustomFields, config.AgileFields{}) {
+		lineage[fl.Table+"."+fl.Column] = fl
+	}
+
+	fl, ok := lineage["jira_issues_events.issue_developer_backend"]
+	if !ok || fl.JiraFieldID == nil || *fl.JiraFieldID != "customfield_10600" || fl.Transformation != "Name of the user." {
+		t.Errorf("expected the lineage of the custom field's column, got %+v", fl)
+	}
+	if fl = lineage["jira_issues_states.issue_fix_versions"]; fl.JiraFieldID == nil || *fl.JiraFieldID != "fixVersions" || fl.MapperVersion != mapping.Version {
+		t.Errorf("expected the lineage of the system field's column, got %+v", fl)
+	}
+	if fl = lineage["jira_issues_states.issue_severity_bucket"]; fl.JiraFieldID != nil || fl.Transformation == "" {
+		t.Errorf("expected a computed column to have a transformation but no field ID, got %+v", fl)
+	}
+	if _, ok = lineage["jira_issues_events.event_kind"]; !ok {
+		t.Errorf("expected the lineage of the columns of the events")
+	}
+	if _, ok = lineage["jira_issues_events.issue_sprints"]; ok {
+		t.Errorf("expected no lineage for `issue_sprints` which is not in `jira_issues_events`")
+	}
+}
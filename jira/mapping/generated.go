@@ -0,0 +1,33 @@
+package mapping
+
+import (
+	extJira "github.com/andygrunwald/go-jira"
+)
+
+// generated returns true if the issue was created by automation or
+// from a template (see `Mapper.GeneratedIssues`): its creator (or
+// its reporter if the creator is not returned) is one of the
+// configured accounts, matched by name or account ID, or it has one
+// of the configured labels.
+func (m *Mapper) generated(i *extJira.Issue) bool {
+	creator := i.Fields.Creator
+	if creator == nil {
+		creator = i.Fields.Reporter
+	}
+	if creator != nil {
+		id := accountID(creator)
+		for _, c := range m.GeneratedIssues.Creators {
+			if c == creator.Name || (id != nil && c == *id) {
+				return true
+			}
+		}
+	}
+	for _, l := range i.Fields.Labels {
+		for _, gl := range m.GeneratedIssues.Labels {
+			if l == gl {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package mapping_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+)
+
+// Golden-file tests: each raw issue in `testdata/issues` is mapped
+// and the result is compared to the corresponding file in
+// `testdata/golden`.
+//
+// When the mapping is changed on purpose, regenerate the golden
+// files and review the diff:
+//
+//	go test ./jira/mapping -run TestGolden -update
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGolden(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "issues", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixture found in testdata/issues")
+	}
+
+	m := mapping.Mapper{CustomFields: mapping.DefaultCustomFields}
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			i, err := mapping.DecodeIssue(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var result bytes.Buffer
+			if err = mapping.WriteMappedIssue(&result, m.MapIssue(i)); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "golden", name+".golden.json")
+			if *update {
+				if err = ioutil.WriteFile(golden, result.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("missing golden file (run with `-update` to create it): %s", err)
+			}
+			if !bytes.Equal(expected, result.Bytes()) {
+				t.Errorf("mapping of `%s` doesn't match `%s` (run with `-update` if the change is expected)\n--- expected\n%s\n--- got\n%s", fixture, golden, expected, result.Bytes())
+			}
+		})
+	}
+}
+
+func TestDecodeIssues(t *testing.T) {
+	var raws []string
+	for _, name := range []string{"bug-with-comments", "epic"} {
+		raw, err := ioutil.ReadFile(filepath.Join("testdata", "issues", name+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Compacting the fixtures to get one issue per line
+		var b bytes.Buffer
+		if err = json.Compact(&b, raw); err != nil {
+			t.Fatal(err)
+		}
+		raws = append(raws, b.String())
+	}
+	layouts := map[string]string{
+		"JSON lines":    strings.Join(raws, "\n") + "\n",
+		"array":         "[" + strings.Join(raws, ",") + "]",
+		"search result": `{"startAt": 0, "total": 2, "issues": [` + strings.Join(raws, ",") + `]}`,
+	}
+	for name, input := range layouts {
+		t.Run(name, func(t *testing.T) {
+			var keys []string
+			err := mapping.DecodeIssues(strings.NewReader(input), func(i *extJira.Issue) error {
+				keys = append(keys, i.Key)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 2 || keys[0] != "PJ-1" || keys[1] == "" {
+				t.Errorf("expected the 2 issues to be decoded in order, got %v", keys)
+			}
+		})
+	}
+
+	t.Run("invalid issue", func(t *testing.T) {
+		err := mapping.DecodeIssues(strings.NewReader(raws[0]+"\n{\"key\": \"PJ-2\"}\n"), func(i *extJira.Issue) error { return nil })
+		if err == nil || !strings.Contains(err.Error(), "issue #2") {
+			t.Errorf("expected an error for the issue without fields, got %v", err)
+		}
+	})
+}
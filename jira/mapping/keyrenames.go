@@ -0,0 +1,66 @@
+package mapping
+
+import "github.com/rchampourlier/kaizenizer-source-jira/store"
+
+// renameState replaces the previous keys of the renamed projects
+// (see `Mapper.KeyRenames`) with their current keys in the state: its
+// key, the keys of its epic, of the issue it was cloned from and of
+// its links, comments and description revisions. If the issue's own
+// key is replaced, a "Previous key" link to it is added, so the
+// records stored with it can be found with `jira_issue_key_aliases`.
+// The targets of the "Previous key" links are kept.
+func (m *Mapper) renameState(is *store.IssueState) {
+	if len(m.KeyRenames) == 0 {
+		return
+	}
+	key := is.Key
+	is.Key = m.KeyRenames.Normalize(key)
+	is.Epic = m.renameKey(is.Epic)
+	is.ClonedFromKey = m.renameKey(is.ClonedFromKey)
+	aliased := false
+	for k := range is.Links {
+		l := &is.Links[k]
+		l.SourceKey = m.KeyRenames.Normalize(l.SourceKey)
+		if l.LinkType == store.LinkTypePreviousKey {
+			aliased = aliased || l.TargetKey == key
+			continue
+		}
+		l.TargetKey = m.KeyRenames.Normalize(l.TargetKey)
+	}
+	if key != is.Key && !aliased {
+		is.Links = append(is.Links, store.IssueLink{
+			SourceKey: is.Key,
+			TargetKey: key,
+			LinkType:  store.LinkTypePreviousKey,
+			Direction: store.LinkOutward,
+		})
+	}
+	for k := range is.Comments {
+		is.Comments[k].IssueKey = is.Key
+	}
+	for k := range is.DescriptionRevisions {
+		is.DescriptionRevisions[k].IssueKey = is.Key
+	}
+}
+
+// renameEvents replaces the previous keys of the renamed projects
+// with their current keys in the events, including the keys of the
+// blockers (see `store.FieldBlockedBy`).
+func (m *Mapper) renameEvents(ies []store.IssueEvent) {
+	for k := range ies {
+		ies[k].IssueKey = m.KeyRenames.Normalize(ies[k].IssueKey)
+		if fn := ies[k].FieldName; fn != nil && *fn == store.FieldBlockedBy {
+			ies[k].FieldChangeFrom = m.renameKey(ies[k].FieldChangeFrom)
+			ies[k].FieldChangeTo = m.renameKey(ies[k].FieldChangeTo)
+		}
+	}
+}
+
+// renameKey returns the current key of the key, or nil.
+func (m *Mapper) renameKey(key *string) *string {
+	if key == nil {
+		return nil
+	}
+	k := m.KeyRenames.Normalize(*key)
+	return &k
+}
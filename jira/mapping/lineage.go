@@ -0,0 +1,215 @@
+package mapping
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// systemFieldIDs are the IDs (as returned by Jira API) of the system
+// fields the columns of `Fields` are mapped from. The columns not
+// listed are not mapped from a single field.
+var systemFieldIDs = map[string]string{
+	"issue_created_at":                 "created",
+	"issue_updated_at":                 "updated",
+	"issue_key":                        "issuekey",
+	"issue_project":                    "project",
+	"issue_status":                     "status",
+	"issue_status_category":            "status",
+	"issue_resolved_at":                "resolutiondate",
+	"issue_priority":                   "priority",
+	"issue_summary":                    "summary",
+	"issue_description":                "description",
+	"issue_description_html":           "description",
+	"issue_type":                       "issuetype",
+	"issue_labels":                     "labels",
+	"issue_assignee":                   "assignee",
+	"issue_assignee_account_id":        "assignee",
+	"cloned_from_key":                  "issuelinks",
+	"moved_from_project":               "project",
+	"issue_components":                 "components",
+	"issue_fix_versions":               "fixVersions",
+	"issue_original_estimate_seconds":  "timeoriginalestimate",
+	"issue_remaining_estimate_seconds": "timeestimate",
+	"issue_time_spent_seconds":         "timespent",
+}
+
+// transformations describe how the values of the columns of `Fields`
+// are obtained from the Jira data, for the columns which are not a
+// copy of the field's value (or name, for objects like the status).
+var transformations = map[string]string{
+	"issue_status_category":            "Key of the category of the status.",
+	"issue_labels":                     "Labels concatenated without separator.",
+	"issue_assignee":                   "Name of the user.",
+	"issue_assignee_account_id":        "Account ID of the user, or its key on Jira Server.",
+	"issue_epic":                       "Key of the linked epic, or of the parent issue in next-gen projects (sub-tasks excepted).",
+	"issue_sprints":                    "Names of the sprints, comma-separated.",
+	"issue_sprint_ids":                 "IDs of the sprints, comma-separated.",
+	"cloned_from_key":                  "Key of the issue of the first outward \"Cloners\" link.",
+	"moved_from_project":               "Previous value of the first change of the project in the changelog.",
+	"issue_components":                 "Names of the components concatenated without separator.",
+	"issue_fix_versions":               "Names of the versions concatenated without separator.",
+	"issue_original_estimate_seconds":  "Time tracking estimate in seconds.",
+	"issue_remaining_estimate_seconds": "Time tracking estimate in seconds.",
+	"issue_time_spent_seconds":         "Time tracking total in seconds.",
+	"issue_source":                     "Name of the configured source whose JQL matched the issue first.",
+	"issue_severity_bucket":            "Name of the first configured severity bucket listing the priority.",
+	"issue_estimate_seconds":           "Estimate field of the project converted from its unit to seconds.",
+	"issue_estimate_points":            "Estimate in seconds divided by the length of a point, or points of the project's estimate field.",
+	"issue_is_generated":               "True if the name or account ID of the creator (or reporter) is a configured creator, or a label is a configured label.",
+	"issue_properties":                 "Values of the configured keys of the issue's entity properties, fetched with the issue.",
+	"issue_description_html":           "Rendered description of the issue (`renderedFields`), fetched with the issue if `mapping.render_html` is set.",
+	"issue_changelog_truncated":        "True if the earliest history of the changelog is later than the creation of the issue plus `mapping.changelog_truncation_threshold`.",
+}
+
+// eventFields describes the columns of `jira_issues_events` which
+// are specific to the events (the other ones being the issue columns
+// of `Fields`).
+var eventFields = []Field{
+	{"event_time", "", "", "Time of the change, comment or worklog, or creation time of the issue."},
+	{"event_kind", "", "", "Kind of the event, derived from its source (see the `event-kinds` action)."},
+	{"event_author", "", "", "Name of the author of the change, comment or worklog."},
+	{"event_author_account_id", "", "", "Account ID of the author (see jira_users), or its key on Jira Server."},
+	{"comment_body", "Comment", "", "Body of the comment, NULL if stored in the comment vault."},
+	{"comment_length", "Comment", "", "Number of characters of the body of the comment."},
+	{"comment_id", "Comment", "", "ID of the comment (see jira_issue_comments)."},
+	{"status_change_from", "Status", "", "Previous status, from the changelog."},
+	{"status_change_to", "Status", "", "New status, from the changelog."},
+	{"status_change_reason", "", "", "Value of the configured reason field, changed along with the status."},
+	{"assignee_change_from", "Assignee", "", "Previous assignee, from the changelog."},
+	{"assignee_change_to", "Assignee", "", "New assignee, from the changelog."},
+	{"assignee_change_from_account_id", "Assignee", "", "Account ID of the previous assignee, from the changelog."},
+	{"assignee_change_to_account_id", "Assignee", "", "Account ID of the new assignee, from the changelog."},
+	{"worklog_started_at", "Log Work", "", "Start time of the worklog."},
+	{"worklog_time_spent_seconds", "Log Work", "", "Time spent of the worklog, in seconds."},
+	{"author_excluded", "", "", "Whether the author is one of the configured excluded authors."},
+	{"sprint_id", "Sprint", DefaultAgileFields.Sprint.ID, "ID of the sprint the issue was added to or removed from, from the changelog."},
+	{"sprint_name", "Sprint", DefaultAgileFields.Sprint.ID, "Name of the sprint the issue was added to or removed from."},
+	{"field_name", "", "", "Name of the tracked field which changed, from the changelog."},
+	{"field_change_from", "", "", "Previous value of the tracked field."},
+	{"field_change_to", "", "", "New value of the tracked field."},
+	{"dedup_key", "", "", "SHA-256 of the event's values, to store each event once."},
+}
+
+// eventFieldIDs are the IDs of the system fields of `eventFields`.
+var eventFieldIDs = map[string]string{
+	"comment_body":                    "comment",
+	"comment_length":                  "comment",
+	"comment_id":                      "comment",
+	"status_change_from":              "status",
+	"status_change_to":                "status",
+	"assignee_change_from":            "assignee",
+	"assignee_change_to":              "assignee",
+	"assignee_change_from_account_id": "assignee",
+	"assignee_change_to_account_id":   "assignee",
+	"worklog_started_at":              "worklog",
+	"worklog_time_spent_seconds":      "worklog",
+}
+
+// customFieldTransformations describe how the values of the custom
+// fields are mapped, by type (see `config.CustomField`).
+var customFieldTransformations = map[string]string{
+	CustomFieldUser:     "Name of the user.",
+	CustomFieldOption:   "Value of the selected option.",
+	CustomFieldDate:     "Value of the field, parsed as a date.",
+	CustomFieldDateTime: "Value of the field, parsed as a time.",
+}
+
+// Lineage returns the lineage of the columns of `jira_issues_states`
+// and `jira_issues_events` filled by the mapping, including the
+// custom fields, with the IDs of the agile fields (see
+// `Mapper.AgileFields`), to be stored with
+// `store.PGStore.ReplaceFieldLineage`.
+func Lineage(cfs []config.CustomField, af config.AgileFields) []store.FieldLineage {
+	af = withDefaultAgileFields(af)
+	var fls []store.FieldLineage
+	for _, table := range []string{"jira_issues_states", "jira_issues_events"} {
+		for _, f := range Fields {
+			if table == "jira_issues_events" && statesOnlyColumns[f.Column] {
+				continue
+			}
+			f, t := f.withAgileField(af, transformations[f.Column])
+			fls = append(fls, f.lineage(table, systemFieldIDs[f.Column], t))
+		}
+		for _, cf := range cfs {
+			f := Field{cf.Column, cf.Name, cf.ID, cf.Description}
+			fls = append(fls, f.lineage(table, "", customFieldTransformation(cf)))
+		}
+	}
+	for _, f := range eventFields {
+		f, t := f.withAgileField(af, f.Description)
+		fls = append(fls, f.lineage("jira_issues_events", eventFieldIDs[f.Column], t))
+	}
+	return fls
+}
+
+// withAgileField returns the field with the ID of the agile field
+// its column is mapped from, if any, and the transformation listing
+// the fields read instead for some projects.
+func (f Field) withAgileField(af config.AgileFields, transformation string) (Field, string) {
+	get, ok := agileColumns[f.Column]
+	if !ok {
+		return f, transformation
+	}
+	id := get(af)
+	f.CustomFieldID = id.ID
+	return f, projectIDsTransformation(transformation, id.ProjectIDs)
+}
+
+// customFieldTransformation returns the transformation of the
+// custom field's value, listing the fields read instead for some
+// projects (see `config.CustomField.ProjectIDs`).
+func customFieldTransformation(cf config.CustomField) string {
+	return projectIDsTransformation(customFieldTransformations[cf.Type], cf.ProjectIDs)
+}
+
+// projectIDsTransformation returns the transformation followed by
+// the IDs of the fields read instead for some projects, by project
+// name.
+func projectIDsTransformation(t string, projectIDs map[string]string) string {
+	if len(projectIDs) == 0 {
+		return t
+	}
+	if t == "" {
+		t = "Value of the field."
+	}
+	projects := make([]string, 0, len(projectIDs))
+	for p := range projectIDs {
+		projects = append(projects, p)
+	}
+	sort.Strings(projects)
+	overrides := make([]string, len(projects))
+	for i, p := range projects {
+		overrides[i] = fmt.Sprintf("%s for %s", projectIDs[p], p)
+	}
+	return fmt.Sprintf("%s Read from %s.", t, strings.Join(overrides, ", "))
+}
+
+// lineage returns the lineage of the field's column in the table.
+// The ID of a custom field takes precedence over `fieldID`, and the
+// transformation defaults to a copy of the value.
+func (f Field) lineage(table, fieldID, transformation string) store.FieldLineage {
+	fl := store.FieldLineage{
+		Table:          table,
+		Column:         f.Column,
+		MapperVersion:  Version,
+		Transformation: transformation,
+	}
+	if f.JiraField != "" {
+		name := f.JiraField
+		fl.JiraField = &name
+	}
+	if f.CustomFieldID != "" {
+		fieldID = f.CustomFieldID
+	}
+	if fieldID != "" {
+		fl.JiraFieldID = &fieldID
+	}
+	if fl.Transformation == "" {
+		fl.Transformation = "Value of the field."
+	}
+	return fl
+}
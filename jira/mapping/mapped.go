@@ -0,0 +1,152 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// MappedIssue groups the state and events records generated
+// for a single issue. It is used to display the result of the
+// mapping (e.g. `map-issue` action).
+type MappedIssue struct {
+	State  store.IssueState   `json:"state"`
+	Events []store.IssueEvent `json:"events"`
+}
+
+// MapIssue returns the `MappedIssue` for the passed issue.
+func (m *Mapper) MapIssue(i *extJira.Issue) MappedIssue {
+	return MappedIssue{
+		State:  m.IssueStateFromIssue(i),
+		Events: m.IssueEventsFromIssue(i),
+	}
+}
+
+// DecodeIssue reads a raw Jira issue, as returned by Jira API
+// (e.g. `GET /rest/api/2/issue/<key>?expand=changelog`), from
+// the passed reader.
+func DecodeIssue(r io.Reader) (*extJira.Issue, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("error decoding issue: %s", err)
+	}
+	i, err := unmarshalIssue(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding issue: %s", err)
+	}
+	if i.Fields == nil {
+		return nil, fmt.Errorf("error decoding issue: no `fields` in payload")
+	}
+	return i, nil
+}
+
+// DecodeIssues reads raw Jira issues from the passed reader and
+// calls `fn` with each of them, stopping at the first error. The
+// issues may be:
+//
+//   - a JSON array of issues,
+//   - an object with an `issues` array, like the results of Jira's
+//     search API,
+//   - newline-delimited JSON (or any sequence of JSON objects), one
+//     issue per line, like the "export all data" JSON files.
+//
+// The issues are decoded one by one, so large exports can be read
+// without loading them in memory (except for the `issues` object).
+func DecodeIssues(r io.Reader, fn func(i *extJira.Issue) error) error {
+	dec := json.NewDecoder(r)
+	handle := func(raw json.RawMessage, n int) error {
+		i, err := unmarshalIssue(raw)
+		if err != nil {
+			return fmt.Errorf("error decoding issue #%d: %s", n, err)
+		}
+		if i.Fields == nil {
+			return fmt.Errorf("error decoding issue #%d: no `fields` in payload", n)
+		}
+		return fn(i)
+	}
+
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error decoding issues: %s", err)
+	}
+	if tok == json.Delim('[') {
+		for n := 1; dec.More(); n++ {
+			var raw json.RawMessage
+			if err = dec.Decode(&raw); err != nil {
+				return fmt.Errorf("error decoding issue #%d: %s", n, err)
+			}
+			if err = handle(raw, n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("error decoding issues: unexpected `%v`", tok)
+	}
+
+	// The first object was opened: decoding it field by field to
+	// find out whether it's an issue or a search result
+	first := make(map[string]json.RawMessage)
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("error decoding issues: %s", err)
+		}
+		var value json.RawMessage
+		if err = dec.Decode(&value); err != nil {
+			return fmt.Errorf("error decoding issues: %s", err)
+		}
+		first[key.(string)] = value
+	}
+	if _, err = dec.Token(); err != nil {
+		return fmt.Errorf("error decoding issues: %s", err)
+	}
+	if issues, ok := first["issues"]; ok && first["fields"] == nil {
+		var raws []json.RawMessage
+		if err = json.Unmarshal(issues, &raws); err != nil {
+			return fmt.Errorf("error decoding issues: %s", err)
+		}
+		for n, raw := range raws {
+			if err = handle(raw, n+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	raw, err := json.Marshal(first)
+	if err != nil {
+		return err
+	}
+	if err = handle(raw, 1); err != nil {
+		return err
+	}
+	for n := 2; ; n++ {
+		var raw json.RawMessage
+		err = dec.Decode(&raw)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error decoding issue #%d: %s", n, err)
+		}
+		if err = handle(raw, n); err != nil {
+			return err
+		}
+	}
+}
+
+// WriteMappedIssue writes the `MappedIssue` to the passed writer as
+// indented JSON.
+func WriteMappedIssue(w io.Writer, mi MappedIssue) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(mi)
+}
@@ -1,20 +1,97 @@
 package mapping
 
 import (
-	"log"
+	"regexp"
 	"sort"
+	"strings"
 	"time"
 
 	extJira "github.com/andygrunwald/go-jira"
 
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
 	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/telemetry"
 )
 
+// Version is the version of the mapping. It must be incremented
+// when the records generated from issues change (e.g. a new column,
+// a different value for a field), so consumers of the records (e.g.
+// exports) can detect incompatible changes.
+const Version = "22"
+
+// clonersLinkType is the name of the link type Jira uses for clones.
+const clonersLinkType = "Cloners"
+
 // Mapper is the implementation of the `jira.Mapper` interface.
 // Using an interface and a type with methods is only used to
 // enable dependency-injection for the synchronization functions
 // so they can be tested in isolation from the mapping.
-type Mapper struct{}
+//
+// The zero value is usable. Fields enable optional mappings.
+type Mapper struct {
+	// StatusChangeReasons configures the transitions whose reason
+	// is captured on the `status_changed` events.
+	StatusChangeReasons []config.StatusChangeReason
+
+	// CustomFields are the custom fields mapped to the custom
+	// columns of the issue tables (e.g. `DefaultCustomFields`).
+	CustomFields []config.CustomField
+
+	// ExcludedAuthors are the names of the accounts whose events
+	// are flagged as `AuthorExcluded`.
+	ExcludedAuthors []string
+
+	// TrackedFields are the changelog fields whose changes generate
+	// `field_changed` events (e.g. "priority"). Defaults to
+	// `DefaultTrackedFields` if nil.
+	TrackedFields []string
+
+	// SeverityBuckets group the priorities into the buckets mapped
+	// to `IssueState.SeverityBucket`.
+	SeverityBuckets []config.SeverityBucket
+
+	// Estimates configures the normalization of the estimates
+	// mapped to `IssueState.EstimateSeconds` and
+	// `IssueState.EstimatePoints`.
+	Estimates config.Estimates
+
+	// Redaction configures the columns redacted from the issue
+	// states and events.
+	Redaction config.Redaction
+
+	// GeneratedIssues identifies the issues flagged as
+	// `IssueState.Generated`.
+	GeneratedIssues config.GeneratedIssues
+
+	// IssueProperties are the keys of the entity properties of the
+	// issues stored in `IssueState.Properties`.
+	IssueProperties []string
+
+	// KeyRenames are the renames of the project keys, applied to the
+	// keys of the records so the history of an issue is unified
+	// across a rename.
+	KeyRenames store.KeyRenames
+
+	// ChangelogTruncationThreshold is the delay after the creation
+	// of an issue beyond which its earliest changelog history is
+	// evidence of a truncated changelog, flagged with
+	// `IssueState.ChangelogTruncated`. Disabled if 0.
+	ChangelogTruncationThreshold time.Duration
+
+	// TeamInference configures the inference of the team column of
+	// the issues whose team field is empty.
+	TeamInference config.TeamInference
+
+	// AgileFields are the IDs of the custom fields of Jira Software
+	// and Advanced Roadmaps (e.g. the sprints), those not set
+	// defaulting to `DefaultAgileFields`.
+	AgileFields config.AgileFields
+}
+
+// DefaultTrackedFields are the changelog fields tracked when none
+// are configured (see `Mapper.TrackedFields`): the changes of
+// priority and scope (labels and fix versions).
+var DefaultTrackedFields = []string{"priority", "labels", "Fix Version"}
 
 // IssueEventsFromIssue generates and returns the `IssueEvent`
 // records corresponding to the passed issue.
@@ -22,37 +99,44 @@ type Mapper struct{}
 // The following events are generated:
 //
 // - `created`: represents the issue creation
-// - `status_changed`: for each status change in the issue's changelogs
+// - `status_changed`: for each status change in the issue's changelogs,
+//   with the reason of the change if configured (see
+//   `StatusChangeReasons`)
 // - `assignee_changed`: idem, for assignee changes
 // - `comment_added`: for each comment in the issue
+// - `worklog_added`: for each worklog of the issue
+// - `sprint_added` and `sprint_removed`: for each change of the
+//   issue's sprints in the changelogs
+// - `field_changed`: for each change of one of `TrackedFields` in
+//   the changelogs
+//
+// The events generated from a changelog item have the changed field
+// and its values before and after the change in `FieldName`,
+// `FieldChangeFrom` and `FieldChangeTo`. The events generated by the
+// changelog plugins (see `RegisterChangelogPlugin`) from each item
+// are added.
+//
+// Events authored by one of `ExcludedAuthors` are flagged as
+// `AuthorExcluded`, before the columns of `Redaction` are redacted.
+// The keys of the renamed projects are replaced (see `KeyRenames`).
 func (m *Mapper) IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent {
 	issueEvents := make([]store.IssueEvent, 0)
 
 	issueEvents = append(issueEvents, store.IssueEvent{
-		EventTime:          time.Time(i.Fields.Created),
-		EventKind:          "created",
-		EventAuthor:        requiredString(reporterName(i)),
-		IssueKey:           i.Key,
-		CommentBody:        nil,
-		StatusChangeFrom:   nil,
-		StatusChangeTo:     nil,
-		AssigneeChangeFrom: nil,
-		AssigneeChangeTo:   nil,
+		EventTime:            time.Time(i.Fields.Created),
+		EventKind:            store.EventCreated,
+		EventAuthor:          requiredString(reporterName(i)),
+		EventAuthorAccountID: reporterAccountID(i),
+		IssueKey:             i.Key,
+		CommentBody:          nil,
+		StatusChangeFrom:     nil,
+		StatusChangeTo:       nil,
+		AssigneeChangeFrom:   nil,
+		AssigneeChangeTo:     nil,
 	})
 
-	if i.Fields.Comments != nil {
-		for _, c := range i.Fields.Comments.Comments {
-			issueEvents = append(issueEvents, store.IssueEvent{
-				EventTime:        parseTime(c.Created),
-				EventKind:        "comment_added",
-				EventAuthor:      c.Author.Name,
-				IssueKey:         i.Key,
-				CommentBody:      &c.Body,
-				StatusChangeFrom: nil,
-				StatusChangeTo:   nil,
-			})
-		}
-	}
+	issueEvents = append(issueEvents, commentEvents(i)...)
+	issueEvents = append(issueEvents, worklogEvents(i)...)
 
 	// If no assignee changelog, create a assignee_changed event with the current
 	// assignee.
@@ -60,6 +144,8 @@ func (m *Mapper) IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent {
 
 	hasChangelogOnStatus := false
 	hasChangelogOnAssignee := false
+	tracked := m.trackedFields()
+	plugins := ChangelogPlugins()
 	if i.Changelog != nil {
 		for k := range i.Changelog.Histories {
 			// We implement the loop using the index (k) to loop in reverse
@@ -69,6 +155,7 @@ func (m *Mapper) IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent {
 			h := i.Changelog.Histories[len(i.Changelog.Histories)-k-1]
 
 			for _, cli := range h.Items {
+				issueEvents = append(issueEvents, pluginEvents(plugins, i, h, cli)...)
 				switch cli.Field {
 				case "status":
 					from := cli.FromString
@@ -77,23 +164,27 @@ func (m *Mapper) IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent {
 						// first changelog on status
 						// => generate additional event with initial status
 						issueEvents = append(issueEvents, store.IssueEvent{
-							EventTime:        time.Time(i.Fields.Created),
-							EventKind:        "status_changed",
-							EventAuthor:      h.Author.Name,
-							IssueKey:         i.Key,
-							StatusChangeFrom: nil,
-							StatusChangeTo:   &from,
+							EventTime:            time.Time(i.Fields.Created),
+							EventKind:            store.EventStatusChanged,
+							EventAuthor:          h.Author.Name,
+							EventAuthorAccountID: accountID(&h.Author),
+							IssueKey:             i.Key,
+							StatusChangeFrom:     nil,
+							StatusChangeTo:       &from,
 						})
 					}
 					hasChangelogOnStatus = true
-					issueEvents = append(issueEvents, store.IssueEvent{
-						EventTime:        parseTime(h.Created),
-						EventKind:        "status_changed",
-						EventAuthor:      h.Author.Name,
-						IssueKey:         i.Key,
-						StatusChangeFrom: &from,
-						StatusChangeTo:   &to,
-					})
+					ie := store.IssueEvent{
+						EventTime:            parseTime(h.Created),
+						EventKind:            store.EventStatusChanged,
+						EventAuthor:          h.Author.Name,
+						EventAuthorAccountID: accountID(&h.Author),
+						IssueKey:             i.Key,
+						StatusChangeFrom:     &from,
+						StatusChangeTo:       &to,
+						StatusChangeReason:   m.statusChangeReason(h, from, to),
+					}
+					issueEvents = append(issueEvents, withFieldChange(ie, cli))
 
 				case "assignee":
 					from := cli.FromString
@@ -102,25 +193,65 @@ func (m *Mapper) IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent {
 						// first changelog on assignee
 						// => generate additional event with initial assignee
 						issueEvents = append(issueEvents, store.IssueEvent{
-							EventTime:          time.Time(i.Fields.Created),
-							EventKind:          "assignee_changed",
-							EventAuthor:        h.Author.Name,
-							IssueKey:           i.Key,
-							AssigneeChangeFrom: nil,
-							AssigneeChangeTo:   &from,
+							EventTime:                 time.Time(i.Fields.Created),
+							EventKind:                 store.EventAssigneeChanged,
+							EventAuthor:               h.Author.Name,
+							EventAuthorAccountID:      accountID(&h.Author),
+							IssueKey:                  i.Key,
+							AssigneeChangeFrom:        nil,
+							AssigneeChangeTo:          &from,
+							AssigneeChangeToAccountID: changelogID(cli.From),
 						})
 					}
 					hasChangelogOnAssignee = true
-					issueEvents = append(issueEvents, store.IssueEvent{
-						EventTime:          parseTime(h.Created),
-						EventKind:          "assignee_changed",
-						EventAuthor:        h.Author.Name,
-						IssueKey:           i.Key,
-						AssigneeChangeFrom: &from,
-						AssigneeChangeTo:   &to,
-					})
+					ie := store.IssueEvent{
+						EventTime:                   parseTime(h.Created),
+						EventKind:                   store.EventAssigneeChanged,
+						EventAuthor:                 h.Author.Name,
+						EventAuthorAccountID:        accountID(&h.Author),
+						IssueKey:                    i.Key,
+						AssigneeChangeFrom:          &from,
+						AssigneeChangeTo:            &to,
+						AssigneeChangeFromAccountID: changelogID(cli.From),
+						AssigneeChangeToAccountID:   changelogID(cli.To),
+					}
+					issueEvents = append(issueEvents, withFieldChange(ie, cli))
+				case sprintChangelogField:
+					for _, ie := range sprintEvents(i, h, cli) {
+						issueEvents = append(issueEvents, withFieldChange(ie, cli))
+					}
+				case store.FieldFlagged:
+					issueEvents = append(issueEvents, withFieldChange(store.IssueEvent{
+						EventTime:            parseTime(h.Created),
+						EventKind:            store.EventFieldChanged,
+						EventAuthor:          h.Author.Name,
+						EventAuthorAccountID: accountID(&h.Author),
+						IssueKey:             i.Key,
+					}, cli))
+				case linkChangelogField:
+					if ie, ok := blockerEvent(i, h, cli); ok {
+						issueEvents = append(issueEvents, ie)
+					}
+					if tracked[cli.Field] {
+						issueEvents = append(issueEvents, withFieldChange(store.IssueEvent{
+							EventTime:            parseTime(h.Created),
+							EventKind:            store.EventFieldChanged,
+							EventAuthor:          h.Author.Name,
+							EventAuthorAccountID: accountID(&h.Author),
+							IssueKey:             i.Key,
+						}, cli))
+					}
 				default:
-					continue
+					if !tracked[cli.Field] {
+						continue
+					}
+					issueEvents = append(issueEvents, withFieldChange(store.IssueEvent{
+						EventTime:            parseTime(h.Created),
+						EventKind:            store.EventFieldChanged,
+						EventAuthor:          h.Author.Name,
+						EventAuthorAccountID: accountID(&h.Author),
+						IssueKey:             i.Key,
+					}, cli))
 				}
 			}
 		}
@@ -134,12 +265,13 @@ func (m *Mapper) IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent {
 			author = *rn
 		}
 		issueEvents = append(issueEvents, store.IssueEvent{
-			EventTime:        time.Time(i.Fields.Created),
-			EventKind:        "status_changed",
-			EventAuthor:      author,
-			IssueKey:         i.Key,
-			StatusChangeFrom: nil,
-			StatusChangeTo:   &(i.Fields.Status.Name),
+			EventTime:            time.Time(i.Fields.Created),
+			EventKind:            store.EventStatusChanged,
+			EventAuthor:          author,
+			EventAuthorAccountID: reporterAccountID(i),
+			IssueKey:             i.Key,
+			StatusChangeFrom:     nil,
+			StatusChangeTo:       statusName(i),
 		})
 	}
 
@@ -151,45 +283,165 @@ func (m *Mapper) IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent {
 			author = *rn
 		}
 		issueEvents = append(issueEvents, store.IssueEvent{
-			EventTime:          time.Time(i.Fields.Created),
-			EventKind:          "assignee_changed",
-			EventAuthor:        author,
-			IssueKey:           i.Key,
-			AssigneeChangeFrom: nil,
-			AssigneeChangeTo:   &(i.Fields.Assignee.Name),
+			EventTime:                 time.Time(i.Fields.Created),
+			EventKind:                 store.EventAssigneeChanged,
+			EventAuthor:               author,
+			EventAuthorAccountID:      reporterAccountID(i),
+			IssueKey:                  i.Key,
+			AssigneeChangeFrom:        nil,
+			AssigneeChangeTo:          &(i.Fields.Assignee.Name),
+			AssigneeChangeToAccountID: accountID(i.Fields.Assignee),
 		})
 	}
 
+	m.flagExcludedAuthors(issueEvents)
+	m.renameEvents(issueEvents)
+	m.redactEvents(issueEvents)
 	sort.Sort(store.IssueEventsByTime(issueEvents))
 	return issueEvents
 }
 
-// IssueStateFromIssue creates a `store.IssueState` from a Jira issue
+// trackedFields returns the set of `TrackedFields`.
+func (m *Mapper) trackedFields() map[string]bool {
+	fields := m.TrackedFields
+	if fields == nil {
+		fields = DefaultTrackedFields
+	}
+	tracked := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		tracked[f] = true
+	}
+	return tracked
+}
+
+// withFieldChange returns the event with the field and values of
+// the changelog item. Empty values (e.g. no fix version before the
+// change) are nil.
+func withFieldChange(ie store.IssueEvent, item extJira.ChangelogItems) store.IssueEvent {
+	field := item.Field
+	ie.FieldName = &field
+	if item.FromString != "" {
+		from := item.FromString
+		ie.FieldChangeFrom = &from
+	}
+	if item.ToString != "" {
+		to := item.ToString
+		ie.FieldChangeTo = &to
+	}
+	return ie
+}
+
+// flagExcludedAuthors flags the events authored by one of
+// `ExcludedAuthors`.
+func (m *Mapper) flagExcludedAuthors(ies []store.IssueEvent) {
+	for k := range ies {
+		for _, a := range m.ExcludedAuthors {
+			if ies[k].EventAuthor == a {
+				ies[k].AuthorExcluded = true
+				break
+			}
+		}
+	}
+}
+
+// statusChangeReason returns the reason of the status change from
+// `from` to `to` in the history, if the transition is configured in
+// `StatusChangeReasons` and the reason field was set along with the
+// status.
+func (m *Mapper) statusChangeReason(h extJira.ChangelogHistory, from, to string) *string {
+	for _, r := range m.StatusChangeReasons {
+		if (r.From != "" && r.From != from) || (r.To != "" && r.To != to) {
+			continue
+		}
+		for _, item := range h.Items {
+			if item.Field != r.Field {
+				continue
+			}
+			reason := item.ToString
+			if v, ok := item.To.(string); ok && reason == "" {
+				reason = v
+			}
+			if reason != "" {
+				return &reason
+			}
+		}
+	}
+	return nil
+}
+
+// IssueStateFromIssue creates a `store.IssueState` from a Jira issue,
+// with the columns of `Redaction` redacted.
 func (m *Mapper) IssueStateFromIssue(i *extJira.Issue) store.IssueState {
-	return store.IssueState{
+	tt := i.Fields.TimeTracking
+	if tt == nil {
+		tt = &extJira.TimeTracking{}
+	}
+	estimateSeconds, estimatePoints := m.estimate(i)
+	status, priority := requiredString(statusName(i)), requiredString(priorityName(i))
+	is := store.IssueState{
 		CreatedAt:         time.Time(i.Fields.Created),
 		UpdatedAt:         time.Time(i.Fields.Updated),
 		Key:               i.Key,
 		Project:           &i.Fields.Project.Name,
-		Status:            &i.Fields.Status.Name,
+		Status:            &status,
+		StatusCategory:    statusCategory(i),
 		ResolvedAt:        resolvedAt(i),
-		Priority:          &i.Fields.Priority.Name,
+		Priority:          &priority,
+		SeverityBucket:    m.severityBucket(priority),
 		Summary:           &i.Fields.Summary,
 		Description:       &i.Fields.Description,
 		Type:              &i.Fields.Type.Name,
 		Labels:            labels(i),
 		Reporter:          reporterName(i),
 		Assignee:          assigneeName(i),
-		DeveloperBackend:  userNameFromCustomField(i, "customfield_10600"),
-		DeveloperFrontend: userNameFromCustomField(i, "customfield_12403"),
-		Reviewer:          userNameFromCustomField(i, "customfield_10601"),
-		ProductOwner:      userNameFromCustomField(i, "customfield_11200"),
-		BugCause:          valueFromCustomField(i, "customfield_11101"),
-		Epic:              epicFromCustomField(i),
-		Tribe:             valueFromCustomField(i, "customfield_12100"),
+		AssigneeAccountID: accountID(i.Fields.Assignee),
+		Epic:              m.epic(i),
+		Sprints:           m.sprints(i),
+		SprintIDs:         m.sprintIDs(i),
+		EpicName:          epicField(i, m.agileFields().EpicName),
+		EpicColor:         epicField(i, m.agileFields().EpicColor),
+		ClonedFromKey:     clonedFromKey(i),
+		MovedFromProject:  movedFromProject(i),
 		Components:        components(i),
 		FixVersions:       fixVersions(i),
+		LabelList:         i.Fields.Labels,
+		ComponentList:     componentList(i),
+		FixVersionList:    fixVersionList(i),
+		CustomFields:      m.customFields(i),
+		Links:             m.links(i),
+
+		OriginalEstimate:  trackedSeconds(tt.OriginalEstimate, tt.OriginalEstimateSeconds),
+		RemainingEstimate: trackedSeconds(tt.RemainingEstimate, tt.RemainingEstimateSeconds),
+		TimeSpent:         trackedSeconds(tt.TimeSpent, tt.TimeSpentSeconds),
+		EstimateSeconds:   estimateSeconds,
+		EstimatePoints:    estimatePoints,
+		Generated:         m.generated(i),
+		Properties:        m.properties(i),
+		DescriptionHTML:   descriptionHTML(i),
+
+		ChangelogTruncated: m.changelogTruncated(i),
+
+		DescriptionRevisions: descriptionRevisions(i),
+		Comments:             comments(i),
+	}
+	m.inferTeam(i, &is)
+	m.renameState(&is)
+	m.redactState(&is)
+	return is
+}
+
+// severityBucket returns the name of the first of `SeverityBuckets`
+// listing the priority, or nil if none does.
+func (m *Mapper) severityBucket(priority string) *string {
+	for _, b := range m.SeverityBuckets {
+		for _, p := range b.Priorities {
+			if p == priority {
+				name := b.Name
+				return &name
+			}
+		}
 	}
+	return nil
 }
 
 // requiredString returns a string for the specified string pointer,
@@ -217,6 +469,40 @@ func assigneeName(i *extJira.Issue) *string {
 	return &i.Fields.Assignee.Name
 }
 
+// reporterAccountID returns the account ID of the issue's reporter
+// (see `accountID`), or nil if there is no reporter.
+func reporterAccountID(i *extJira.Issue) *string {
+	return accountID(i.Fields.Reporter)
+}
+
+// accountID returns the account ID of the user, or its key on Jira
+// Server which has no account IDs. Both identify the user even if
+// they are renamed, unlike their name. Returns nil if there is no
+// user or it has neither.
+func accountID(u *extJira.User) *string {
+	if u == nil {
+		return nil
+	}
+	switch {
+	case u.AccountID != "":
+		return &u.AccountID
+	case u.Key != "":
+		return &u.Key
+	}
+	return nil
+}
+
+// changelogID returns the ID of the value before or after the
+// change of a changelog item (e.g. the account ID of an assignee),
+// or nil if there is none.
+func changelogID(v interface{}) *string {
+	id, ok := v.(string)
+	if !ok || id == "" {
+		return nil
+	}
+	return &id
+}
+
 func components(i *extJira.Issue) *string {
 	var components string
 	for _, c := range i.Fields.Components {
@@ -225,6 +511,16 @@ func components(i *extJira.Issue) *string {
 	return &components
 }
 
+// componentList returns the names of the issue's components, nil if
+// it has none.
+func componentList(i *extJira.Issue) []string {
+	var names []string
+	for _, c := range i.Fields.Components {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
 func labels(i *extJira.Issue) *string {
 	var labels string
 	for _, l := range i.Fields.Labels {
@@ -233,6 +529,33 @@ func labels(i *extJira.Issue) *string {
 	return &labels
 }
 
+// Returns the name of the issue's status, or nil if it has none
+// (e.g. the field is hidden from the user syncing the issues).
+func statusName(i *extJira.Issue) *string {
+	if i.Fields.Status == nil {
+		return nil
+	}
+	return &i.Fields.Status.Name
+}
+
+// Returns the name of the issue's priority, or nil if it has none
+// (e.g. the project's field configuration hides it).
+func priorityName(i *extJira.Issue) *string {
+	if i.Fields.Priority == nil {
+		return nil
+	}
+	return &i.Fields.Priority.Name
+}
+
+// Returns the key of the Jira category of the issue's status
+// (e.g. "indeterminate"), or nil if unknown.
+func statusCategory(i *extJira.Issue) *string {
+	if i.Fields.Status == nil || i.Fields.Status.StatusCategory.Key == "" {
+		return nil
+	}
+	return &i.Fields.Status.StatusCategory.Key
+}
+
 func resolvedAt(i *extJira.Issue) *time.Time {
 	t := time.Time(i.Fields.Resolutiondate)
 	if t.IsZero() {
@@ -249,49 +572,377 @@ func fixVersions(i *extJira.Issue) *string {
 	return &fixVersions
 }
 
-func userNameFromCustomField(i *extJira.Issue, field string) *string {
-	cf := i.Fields.Unknowns[field]
-	if cf == nil {
+// fixVersionList returns the names of the issue's fix versions,
+// nil if it has none.
+func fixVersionList(i *extJira.Issue) []string {
+	var names []string
+	for _, fv := range i.Fields.FixVersions {
+		names = append(names, fv.Name)
+	}
+	return names
+}
+
+// links returns the issue's links to other issues, as seen from
+// this issue, including the links to its parent (for a sub-task),
+// to its epic (see `store.LinkTypeParent`) and to the keys it had
+// before being moved (see `store.LinkTypePreviousKey`).
+func (m *Mapper) links(i *extJira.Issue) []store.IssueLink {
+	var links []store.IssueLink
+	if i.Fields.Parent != nil && i.Fields.Parent.Key != "" && i.Fields.Type.Subtask {
+		links = append(links, store.IssueLink{
+			SourceKey: i.Key,
+			TargetKey: i.Fields.Parent.Key,
+			LinkType:  store.LinkTypeParent,
+			Direction: store.LinkOutward,
+		})
+	}
+	if e := m.epic(i); e != nil {
+		links = append(links, store.IssueLink{
+			SourceKey: i.Key,
+			TargetKey: *e,
+			LinkType:  store.LinkTypeEpic,
+			Direction: store.LinkOutward,
+		})
+	}
+	for _, l := range i.Fields.IssueLinks {
+		switch {
+		case l.OutwardIssue != nil:
+			links = append(links, store.IssueLink{
+				SourceKey: i.Key,
+				TargetKey: l.OutwardIssue.Key,
+				LinkType:  l.Type.Name,
+				Direction: store.LinkOutward,
+			})
+		case l.InwardIssue != nil:
+			links = append(links, store.IssueLink{
+				SourceKey: i.Key,
+				TargetKey: l.InwardIssue.Key,
+				LinkType:  l.Type.Name,
+				Direction: store.LinkInward,
+			})
+		}
+	}
+	if p := m.parentLink(i); p != nil {
+		links = append(links, store.IssueLink{
+			SourceKey: i.Key,
+			TargetKey: *p,
+			LinkType:  store.LinkTypeParentLink,
+			Direction: store.LinkOutward,
+		})
+	}
+	for _, k := range previousKeys(i) {
+		links = append(links, store.IssueLink{
+			SourceKey: i.Key,
+			TargetKey: k,
+			LinkType:  store.LinkTypePreviousKey,
+			Direction: store.LinkOutward,
+		})
+	}
+	return links
+}
+
+// previousKeys returns the keys the issue had before being moved to
+// other projects, oldest first. Key changes are recorded in the
+// changelog as changes of the "Key" field.
+func previousKeys(i *extJira.Issue) []string {
+	if i.Changelog == nil {
 		return nil
 	}
-	name := cf.(map[string]interface{})["name"].(string)
-	return &name
+	var keys []string
+	for k := len(i.Changelog.Histories) - 1; k >= 0; k-- {
+		for _, item := range i.Changelog.Histories[k].Items {
+			if item.Field == "Key" && item.FromString != "" && item.FromString != i.Key {
+				keys = append(keys, item.FromString)
+			}
+		}
+	}
+	return keys
 }
 
-func epicFromCustomField(i *extJira.Issue) *string {
-	cf := i.Fields.Unknowns["customfield_10009"]
-	if cf == nil {
+// trackedSeconds returns the seconds of a time-tracking field, or
+// nil if the field is not set. The field is set if its displayed
+// value (e.g. "1d 2h", "0m") is, since the seconds are omitted by
+// Jira when 0.
+func trackedSeconds(displayed string, seconds int) *int {
+	if displayed == "" {
 		return nil
 	}
-	e, ok := cf.(*string)
-	if !ok {
+	return &seconds
+}
+
+// worklogEvents returns a `worklog_added` event for each worklog of
+// the issue, at the time the work was logged.
+func worklogEvents(i *extJira.Issue) []store.IssueEvent {
+	if i.Fields.Worklog == nil {
+		return nil
+	}
+	var events []store.IssueEvent
+	for _, w := range i.Fields.Worklog.Worklogs {
+		author := "N/A"
+		if w.Author != nil {
+			author = w.Author.Name
+		}
+		spent := w.TimeSpentSeconds
+		e := store.IssueEvent{
+			EventKind:            store.EventWorklogAdded,
+			EventAuthor:          author,
+			EventAuthorAccountID: accountID(w.Author),
+			IssueKey:             i.Key,
+			WorklogTimeSpent:     &spent,
+		}
+		if w.Created != nil {
+			e.EventTime = time.Time(*w.Created)
+		}
+		if w.Started != nil {
+			started := time.Time(*w.Started)
+			e.WorklogStartedAt = &started
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// commentEvents returns a `comment_added` event for each comment of
+// the issue, and a `comment_updated` event at the time of the last
+// edit of the edited ones.
+func commentEvents(i *extJira.Issue) []store.IssueEvent {
+	if i.Fields.Comments == nil {
 		return nil
 	}
-	return e
+	var events []store.IssueEvent
+	for _, c := range i.Fields.Comments.Comments {
+		var id *string
+		if c.ID != "" {
+			id = &c.ID
+		}
+		events = append(events, store.IssueEvent{
+			EventTime:            parseTime(c.Created),
+			EventKind:            store.EventCommentAdded,
+			EventAuthor:          c.Author.Name,
+			EventAuthorAccountID: accountID(&c.Author),
+			IssueKey:             i.Key,
+			CommentBody:          &c.Body,
+			CommentID:            id,
+		})
+		if commentUpdated(c) {
+			events = append(events, store.IssueEvent{
+				EventTime:            parseTime(c.Updated),
+				EventKind:            store.EventCommentUpdated,
+				EventAuthor:          c.UpdateAuthor.Name,
+				EventAuthorAccountID: accountID(&c.UpdateAuthor),
+				IssueKey:             i.Key,
+				CommentID:            id,
+			})
+		}
+	}
+	return events
+}
+
+// commentUpdated returns true if the comment was edited after its
+// creation.
+func commentUpdated(c *extJira.Comment) bool {
+	return c.Updated != "" && parseTime(c.Updated).After(parseTime(c.Created))
 }
 
-func valueFromCustomField(i *extJira.Issue, field string) *string {
-	cf := i.Fields.Unknowns[field]
-	if cf == nil {
+// comments returns the comments of the issue, or nil if they were
+// not fetched, with their bodies rendered in HTML if the rendered
+// fields were fetched.
+func comments(i *extJira.Issue) []store.Comment {
+	if i.Fields.Comments == nil {
 		return nil
 	}
-	cfMap, ok := cf.(map[string]interface{})
-	if !ok {
-		log.Printf("customfield `%s` / issue %s\n", field, i.Key) // to give some context
-		_ = cf.(map[string]interface{})                           // to make it fail with explicit message about type issue
+	comments := make([]store.Comment, 0, len(i.Fields.Comments.Comments))
+	bodiesHTML := commentsHTML(i)
+	for _, c := range i.Fields.Comments.Comments {
+		sc := store.Comment{
+			ID:              c.ID,
+			IssueKey:        i.Key,
+			Author:          c.Author.Name,
+			AuthorAccountID: accountID(&c.Author),
+			CreatedAt:       parseTime(c.Created),
+			UpdatedAt:       parseTime(c.Created),
+			Body:            c.Body,
+		}
+		if commentUpdated(c) {
+			sc.UpdatedAt = parseTime(c.Updated)
+		}
+		if body, ok := bodiesHTML[c.ID]; ok {
+			sc.BodyHTML = &body
+		}
+		if v := c.Visibility; v.Type != "" {
+			sc.VisibilityType, sc.VisibilityValue = &v.Type, &v.Value
+		}
+		comments = append(comments, sc)
 	}
-	cfValue, ok := cfMap["value"].(string)
+	return comments
+}
+
+// descriptionRevisions returns the edits of the issue's
+// description, recorded in the changelog as changes of the
+// "description" field, from the oldest to the newest.
+func descriptionRevisions(i *extJira.Issue) []store.DescriptionRevision {
+	if i.Changelog == nil {
+		return nil
+	}
+	var revisions []store.DescriptionRevision
+	// Histories are sorted by time descending.
+	for k := len(i.Changelog.Histories) - 1; k >= 0; k-- {
+		h := i.Changelog.Histories[k]
+		for _, item := range h.Items {
+			if item.Field != "description" {
+				continue
+			}
+			r := store.DescriptionRevision{
+				IssueKey:  i.Key,
+				RevisedAt: parseTime(h.Created),
+				Author:    h.Author.Name,
+			}
+			if from := item.FromString; from != "" {
+				r.From = &from
+			}
+			if to := item.ToString; to != "" {
+				r.To = &to
+			}
+			revisions = append(revisions, r)
+		}
+	}
+	return revisions
+}
+
+// clonedFromKey returns the key of the issue this issue was cloned
+// from, using its "clones" link, or nil if it's not a clone.
+func clonedFromKey(i *extJira.Issue) *string {
+	for _, l := range i.Fields.IssueLinks {
+		if l.OutwardIssue != nil && (l.Type.Name == clonersLinkType || l.Type.Outward == "clones") {
+			return &l.OutwardIssue.Key
+		}
+	}
+	return nil
+}
+
+// movedFromProject returns the name of the project the issue was
+// created in if it was moved to another project, or nil if it was
+// not moved. Moves are recorded in the changelog as changes of the
+// "project" field.
+func movedFromProject(i *extJira.Issue) *string {
+	if i.Changelog == nil {
+		return nil
+	}
+	// Histories are sorted by time descending, the first move is
+	// the last one found.
+	for k := len(i.Changelog.Histories) - 1; k >= 0; k-- {
+		for _, item := range i.Changelog.Histories[k].Items {
+			if item.Field == "project" && item.FromString != "" {
+				return &item.FromString
+			}
+		}
+	}
+	return nil
+}
+
+// epic returns the key of the issue's epic, or nil if the issue
+// has no epic.
+//
+// In company-managed (classic) projects, the epic is set in the
+// "Epic Link" custom field. Team-managed (next-gen) projects don't
+// use this field: the epic is the issue's parent. Sub-tasks have a
+// parent too, which is not an epic, so they are ignored.
+func (m *Mapper) epic(i *extJira.Issue) *string {
+	switch e := i.Fields.Unknowns[fieldID(i, m.agileFields().EpicLink)].(type) {
+	case string:
+		if e != "" {
+			return &e
+		}
+	case *string:
+		if e != nil {
+			return e
+		}
+	}
+	if i.Fields.Parent != nil && i.Fields.Parent.Key != "" && !i.Fields.Type.Subtask && i.Fields.Type.Name != "Epic" {
+		return &i.Fields.Parent.Key
+	}
+	return nil
+}
+
+// parentLink returns the key of the parent of the issue in the
+// hierarchy of Advanced Roadmaps (e.g. the initiative of an epic),
+// or nil if it has none. The parent is read from the "Parent Link"
+// field, set as an object (`{"data": {"key": "PJ-1", ...}}`) or a
+// key depending on the Jira version, or from the parent of an epic
+// where the hierarchy is exposed as the parent of the issues.
+func (m *Mapper) parentLink(i *extJira.Issue) *string {
+	switch p := i.Fields.Unknowns[fieldID(i, m.agileFields().ParentLink)].(type) {
+	case string:
+		if p != "" {
+			return &p
+		}
+	case map[string]interface{}:
+		data, _ := p["data"].(map[string]interface{})
+		if k, ok := data["key"].(string); ok && k != "" {
+			return &k
+		}
+	}
+	if i.Fields.Parent != nil && i.Fields.Parent.Key != "" && i.Fields.Type.Name == "Epic" {
+		return &i.Fields.Parent.Key
+	}
+	return nil
+}
+
+// epicField returns the value of the custom field if the issue is an
+// epic and the value is set, nil otherwise. Used for the fields
+// only set on epics, e.g. "Epic Name" (distinct from the summary)
+// and "Epic Color" (e.g. "ghx-label-4").
+func epicField(i *extJira.Issue, field config.FieldID) *string {
+	if i.Fields.Type.Name != "Epic" {
+		return nil
+	}
+	v, ok := i.Fields.Unknowns[fieldID(i, field)].(string)
+	if !ok || v == "" {
+		return nil
+	}
+	return &v
+}
+
+// greenhopperSprintName matches the name in sprints serialized by
+// Jira Agile, e.g.:
+// `com.atlassian.greenhopper.service.sprint.Sprint@1a2b[id=1,rapidViewId=2,state=CLOSED,name=Sprint 1,startDate=...]`
+var greenhopperSprintName = regexp.MustCompile(`[\[,]name=([^,\]]*)`)
+
+// sprints returns the names of the issue's sprints, comma-separated,
+// or nil if the issue has never been in a sprint.
+//
+// The items of the sprint field are objects with a `name` in
+// team-managed (next-gen) projects and recent Jira versions, and
+// strings serialized by Jira Agile in older company-managed ones.
+func (m *Mapper) sprints(i *extJira.Issue) *string {
+	values, ok := i.Fields.Unknowns[fieldID(i, m.agileFields().Sprint)].([]interface{})
 	if !ok {
-		log.Printf("customfield `%s` / issue %s\n", field, i.Key)
-		_ = cfMap["value"].(string)
+		return nil
+	}
+	names := make([]string, 0, len(values))
+	for _, v := range values {
+		switch sprint := v.(type) {
+		case map[string]interface{}:
+			if name, ok := sprint["name"].(string); ok {
+				names = append(names, name)
+			}
+		case string:
+			if m := greenhopperSprintName.FindStringSubmatch(sprint); m != nil {
+				names = append(names, m[1])
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil
 	}
-	return &cfValue
+	s := strings.Join(names, ",")
+	return &s
 }
 
 func parseTime(s string) time.Time {
 	t, err := time.Parse("2006-01-02T15:04:05.000-0700", s)
 	if err != nil {
-		log.Fatalf("failed to parse time `%s`", s)
+		telemetry.Fatalf("failed to parse time `%s`", s)
 	}
 	return t
 }
@@ -2,12 +2,16 @@ package mapping_test
 
 import (
 	"fmt"
+	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
 	extJira "github.com/andygrunwald/go-jira"
 	"github.com/rchampourlier/golib/matchers"
 
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
 	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
 	"github.com/rchampourlier/kaizenizer-source-jira/store"
 )
@@ -58,7 +62,7 @@ func TestIssueEventsFromIssue(t *testing.T) {
 
 		resultKinds := make([]string, 3)
 		for j, e := range resultEvents {
-			resultKinds[j] = e.EventKind
+			resultKinds[j] = string(e.EventKind)
 			matchers.MatchString(t, "event.IssueKey", key, e.IssueKey, e)
 
 			switch e.EventKind {
@@ -210,6 +214,198 @@ func TestIssueEventsFromIssue(t *testing.T) {
 	})
 }
 
+func TestIssueEventsFromIssue_ReopenedBug(t *testing.T) {
+	refTime := time.Now().Add(-24 * time.Hour)
+	m := mapping.Mapper{}
+	i := client.NewReopenedBugFixture("PJ-4", refTime).Issue()
+
+	resultEventsMap := groupAndSortEvents(m.IssueEventsFromIssue(i))
+
+	// Expects a `status_changed` event for the initial status and
+	// one for each of the 5 transitions.
+	matchers.MatchInt(t, "count of `status_changed` events", 6, len(resultEventsMap["status_changed"]), i.Key)
+	re := resultEventsMap["status_changed"][3]
+	matchers.MatchStringPtr(t, "event.StatusChangeFrom", strAddr("Done"), re.StatusChangeFrom, i.Key)
+	matchers.MatchStringPtr(t, "event.StatusChangeTo", strAddr("Reopened"), re.StatusChangeTo, i.Key)
+	matchers.MatchTimeApprox(t, "event.EventTime", refTime.Add(3*time.Hour), re.EventTime, 1, i.Key)
+}
+
+func TestIssueEventsFromIssue_StatusChangeReason(t *testing.T) {
+	created := time.Date(2018, 7, 1, 9, 0, 0, 0, time.UTC)
+	i := client.NewIssueFixture("PJ-1").
+		WithCreated(created).
+		WithStatusChangeReason("Open", "Rejected", created.Add(time.Hour), "Rejection Reason", "Duplicate").
+		WithChangelog("status", "Rejected", "Open", created.Add(2*time.Hour)).
+		WithStatusChangeReason("Open", "Done", created.Add(3*time.Hour), "Rejection Reason", "Not a rejection").
+		Issue()
+	m := mapping.Mapper{
+		StatusChangeReasons: []config.StatusChangeReason{{To: "Rejected", Field: "Rejection Reason"}},
+	}
+
+	reasons := make(map[string]*string)
+	for _, e := range m.IssueEventsFromIssue(i) {
+		if e.EventKind == "status_changed" && e.StatusChangeTo != nil {
+			reasons[*e.StatusChangeTo] = e.StatusChangeReason
+		}
+	}
+	if r := reasons["Rejected"]; r == nil || *r != "Duplicate" {
+		t.Errorf("expected the rejection reason to be captured, got %v", r)
+	}
+	for _, s := range []string{"Open", "Done"} {
+		if r := reasons[s]; r != nil {
+			t.Errorf("expected no reason for the transition to `%s`, got `%s`", s, *r)
+		}
+	}
+}
+
+func TestIssueEventsFromIssue_ExcludedAuthors(t *testing.T) {
+	created := time.Date(2018, 7, 1, 9, 0, 0, 0, time.UTC)
+	i := client.NewIssueFixture("PJ-1").
+		WithCreated(created).
+		WithChangeAuthor("migration-bot").
+		WithChangelog("status", "Open", "Done", created.Add(time.Hour)).
+		WithComment("dev", "Fixed", created.Add(2*time.Hour)).
+		Issue()
+	m := mapping.Mapper{ExcludedAuthors: []string{"migration-bot"}}
+
+	for _, e := range m.IssueEventsFromIssue(i) {
+		if expected := e.EventAuthor == "migration-bot"; e.AuthorExcluded != expected {
+			t.Errorf("expected %s event by `%s` to have AuthorExcluded=%t", e.EventKind, e.EventAuthor, expected)
+		}
+	}
+}
+
+// teamPlugin interprets the changes of the team field of Advanced
+// Roadmaps into `team_changed` events.
+type teamPlugin struct{}
+
+func (teamPlugin) Name() string { return "team" }
+
+func (teamPlugin) Events(i *extJira.Issue, h extJira.ChangelogHistory, item extJira.ChangelogItems) []store.IssueEvent {
+	if item.Field != "Team (test plugin)" {
+		return nil
+	}
+	field, to := "team", item.ToString
+	return []store.IssueEvent{{EventKind: "team_changed", FieldName: &field, FieldChangeTo: &to}}
+}
+
+func TestIssueEventsFromIssue_ChangelogPlugins(t *testing.T) {
+	if err := store.RegisterEventKind("team_changed", "The team of the issue changed."); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	mapping.RegisterChangelogPlugin(teamPlugin{})
+
+	created := time.Date(2018, 7, 1, 9, 0, 0, 0, time.UTC)
+	i := client.NewIssueFixture("PJ-1").
+		WithCreated(created).
+		WithChangeAuthor("alice").
+		WithChangelog("Team (test plugin)", "", "Payments", created.Add(time.Hour)).
+		WithChangelog("status", "Open", "Done", created.Add(2*time.Hour)).
+		Issue()
+	var found []store.IssueEvent
+	for _, e := range (&mapping.Mapper{}).IssueEventsFromIssue(i) {
+		if e.EventKind == "team_changed" {
+			found = append(found, e)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1 event generated by the plugin, got %d", len(found))
+	}
+	e := found[0]
+	if e.IssueKey != "PJ-1" || e.EventAuthor != "alice" || !e.EventTime.Equal(created.Add(time.Hour)) || *e.FieldChangeTo != "Payments" {
+		t.Errorf("unexpected event %v", e)
+	}
+	if !e.EventKind.IsValid() {
+		t.Errorf("expected the registered kind to be valid")
+	}
+	if err := store.RegisterEventKind("team_changed", ""); err == nil {
+		t.Errorf("expected an error registering a kind twice")
+	}
+}
+
+func TestIssueEventsFromIssue_Sprints(t *testing.T) {
+	created := time.Date(2018, 7, 1, 9, 0, 0, 0, time.UTC)
+	m := mapping.Mapper{}
+	i := client.NewMultiSprintStoryFixture("PJ-1", created, "Sprint 1", "Sprint 2").Issue()
+
+	resultEventsMap := groupAndSortEvents(m.IssueEventsFromIssue(i))
+
+	// Carried over from "Sprint 1" to "Sprint 2"
+	matchers.MatchInt(t, "count of `sprint_added` events", 2, len(resultEventsMap["sprint_added"]), i.Key)
+	matchers.MatchInt(t, "count of `sprint_removed` events", 1, len(resultEventsMap["sprint_removed"]), i.Key)
+	re := resultEventsMap["sprint_removed"][0]
+	matchers.MatchStringPtr(t, "event.SprintName", strAddr("Sprint 1"), re.SprintName, i.Key)
+	matchers.MatchTimeApprox(t, "event.EventTime", created.Add(25*time.Hour), re.EventTime, 1, i.Key)
+	matchers.MatchStringPtr(t, "event.SprintName", strAddr("Sprint 2"), resultEventsMap["sprint_added"][1].SprintName, i.Key)
+}
+
+func TestIssueEventsFromIssue_TrackedFields(t *testing.T) {
+	created := time.Date(2018, 7, 1, 9, 0, 0, 0, time.UTC)
+	i := client.NewIssueFixture("PJ-1").
+		WithCreated(created).
+		WithChangelog("priority", "Minor", "Major", created.Add(time.Hour)).
+		WithChangelog("duedate", "", "2018-07-10", created.Add(2*time.Hour)).
+		Issue()
+
+	// The priority is tracked by default
+	m := mapping.Mapper{}
+	resultEventsMap := groupAndSortEvents(m.IssueEventsFromIssue(i))
+	matchers.MatchInt(t, "count of `field_changed` events", 1, len(resultEventsMap["field_changed"]), i.Key)
+	fe := resultEventsMap["field_changed"][0]
+	matchers.MatchStringPtr(t, "event.FieldName", strAddr("priority"), fe.FieldName, i.Key)
+	matchers.MatchStringPtr(t, "event.FieldChangeFrom", strAddr("Minor"), fe.FieldChangeFrom, i.Key)
+	matchers.MatchStringPtr(t, "event.FieldChangeTo", strAddr("Major"), fe.FieldChangeTo, i.Key)
+
+	// Configured fields replace the default ones
+	m = mapping.Mapper{TrackedFields: []string{"duedate"}}
+	resultEventsMap = groupAndSortEvents(m.IssueEventsFromIssue(i))
+	matchers.MatchInt(t, "count of `field_changed` events", 1, len(resultEventsMap["field_changed"]), i.Key)
+	fe = resultEventsMap["field_changed"][0]
+	matchers.MatchStringPtr(t, "event.FieldName", strAddr("duedate"), fe.FieldName, i.Key)
+	if fe.FieldChangeFrom != nil {
+		t.Errorf("expected no value before the change, got `%s`", *fe.FieldChangeFrom)
+	}
+}
+
+func TestIssueEventsFromIssue_Blockers(t *testing.T) {
+	created := time.Date(2018, 7, 1, 9, 0, 0, 0, time.UTC)
+	i := client.NewIssueFixture("PJ-1").
+		WithCreated(created).
+		WithChangelog("Flagged", "", "Impediment", created.Add(time.Hour)).
+		WithChangelog("Link", "", "This issue is blocked by PJ-2", created.Add(2*time.Hour)).
+		WithChangelog("Link", "", "This issue relates to PJ-3", created.Add(3*time.Hour)).
+		WithChangelog("Link", "This issue is blocked by PJ-2", "", created.Add(4*time.Hour)).
+		Issue()
+	// The values of the links' items are the keys of the linked issues
+	for _, h := range i.Changelog.Histories {
+		for k, item := range h.Items {
+			if item.Field != "Link" {
+				continue
+			}
+			for _, v := range []*interface{}{&h.Items[k].From, &h.Items[k].To} {
+				if s := (*v).(string); s != "" {
+					*v = s[strings.LastIndex(s, " ")+1:]
+				}
+			}
+		}
+	}
+
+	// Generated although the fields are not tracked
+	m := mapping.Mapper{}
+	resultEventsMap := groupAndSortEvents(m.IssueEventsFromIssue(i))
+	events := resultEventsMap["field_changed"]
+	matchers.MatchInt(t, "count of `field_changed` events", 3, len(events), i.Key)
+	matchers.MatchStringPtr(t, "event.FieldName", strAddr(store.FieldFlagged), events[0].FieldName, i.Key)
+	matchers.MatchStringPtr(t, "event.FieldChangeTo", strAddr("Impediment"), events[0].FieldChangeTo, i.Key)
+	matchers.MatchStringPtr(t, "event.FieldName", strAddr(store.FieldBlockedBy), events[1].FieldName, i.Key)
+	matchers.MatchStringPtr(t, "event.FieldChangeTo", strAddr("PJ-2"), events[1].FieldChangeTo, i.Key)
+	matchers.MatchStringPtr(t, "event.FieldName", strAddr(store.FieldBlockedBy), events[2].FieldName, i.Key)
+	matchers.MatchStringPtr(t, "event.FieldChangeFrom", strAddr("PJ-2"), events[2].FieldChangeFrom, i.Key)
+	if events[2].FieldChangeTo != nil {
+		t.Errorf("expected no blocker after the removal, got `%s`", *events[2].FieldChangeTo)
+	}
+}
+
 func TestIssueStateFromIssue(t *testing.T) {
 	key := "PJ-1"
 	assigneeName := "assignee"
@@ -232,6 +428,260 @@ func TestIssueStateFromIssue(t *testing.T) {
 	// TODO: implement other expectations
 }
 
+func TestIssueStateFromIssue_SeverityBucket(t *testing.T) {
+	m := mapping.Mapper{SeverityBuckets: []config.SeverityBucket{
+		{Name: "P0", Priorities: []string{"Blocker", "Highest"}},
+		{Name: "P1", Priorities: []string{"Critical", "High"}},
+	}}
+	cases := map[string]*string{
+		"Highest": strAddr("P0"),
+		"High":    strAddr("P1"),
+		"Minor":   nil,
+	}
+	for priority, expected := range cases {
+		i := client.NewIssueFixture("PJ-1").WithPriority(priority).Issue()
+		matchers.MatchStringPtr(t, "state.SeverityBucket", expected, m.IssueStateFromIssue(i).SeverityBucket, priority)
+	}
+}
+
+func TestIssueStateFromIssue_RequiredColumns(t *testing.T) {
+	m := mapping.Mapper{Redaction: config.Redaction{Columns: []config.ColumnRedaction{
+		{Column: "issue_summary", Action: mapping.RedactDrop},
+	}}}
+	i := client.NewIssueFixture("PJ-1").Issue()
+	i.Fields.Status, i.Fields.Priority = nil, nil
+
+	is := m.IssueStateFromIssue(i)
+	matchers.MatchStringPtr(t, "state.Status", strAddr("N/A"), is.Status, "")
+	matchers.MatchStringPtr(t, "state.Priority", strAddr("N/A"), is.Priority, "")
+	matchers.MatchStringPtr(t, "state.Summary", strAddr(""), is.Summary, "")
+	if err := is.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	for _, ie := range m.IssueEventsFromIssue(i) {
+		if ie.EventKind == store.EventStatusChanged && ie.StatusChangeTo != nil {
+			t.Errorf("expected no status for the issue's creation, got `%s`", *ie.StatusChangeTo)
+		}
+	}
+}
+
+func TestIssueStateFromIssue_Generated(t *testing.T) {
+	m := mapping.Mapper{GeneratedIssues: config.GeneratedIssues{
+		Creators: []string{"automation"},
+		Labels:   []string{"recurring"},
+	}}
+
+	byReporter := client.NewIssueFixture("PJ-1").WithReporter("automation").Issue()
+	byCreator := client.NewIssueFixture("PJ-2").Issue()
+	byCreator.Fields.Creator = &extJira.User{Name: "automation"}
+	byLabel := client.NewIssueFixture("PJ-3").Issue()
+	byLabel.Fields.Labels = []string{"ops", "recurring"}
+	manual := client.NewIssueFixture("PJ-4").Issue()
+	manual.Fields.Creator = &extJira.User{Name: "dev"}
+	manual.Fields.Reporter = &extJira.User{Name: "automation"}
+
+	cases := map[*extJira.Issue]bool{byReporter: true, byCreator: true, byLabel: true, manual: false}
+	for i, expected := range cases {
+		if g := m.IssueStateFromIssue(i).Generated; g != expected {
+			t.Errorf("expected %s to have Generated=%t, got %t", i.Key, expected, g)
+		}
+	}
+}
+
+func TestIssueStateFromIssue_Properties(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/issues/epic.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := strings.Replace(string(b), "{", `{"properties": {"com.app.risk": {"score": 3}, "com.app.other": "ignored"},`, 1)
+	i, err := mapping.DecodeIssue(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := mapping.Mapper{IssueProperties: []string{"com.app.risk", "com.app.missing"}}
+	matchers.MatchStringPtr(t, "state.Properties", strAddr(`{"com.app.risk":{"score":3}}`), m.IssueStateFromIssue(i).Properties, i.Key)
+
+	m = mapping.Mapper{}
+	matchers.MatchStringPtr(t, "state.Properties", nil, m.IssueStateFromIssue(i).Properties, i.Key)
+}
+
+func TestIssueStateFromIssue_ParentLink(t *testing.T) {
+	m := mapping.Mapper{}
+	serverEpic := client.NewIssueFixture("PJ-10").WithType("Epic").WithCustomField("customfield_10018", "PJ-100").Issue()
+	cloudEpic := client.NewIssueFixture("PJ-11").WithType("Epic").Issue()
+	cloudEpic.Fields.Parent = &extJira.Parent{Key: "PJ-100"}
+	story := client.NewIssueFixture("PJ-12").WithType("Story").Issue()
+	story.Fields.Parent = &extJira.Parent{Key: "PJ-11"}
+
+	cases := []struct {
+		issue        *extJira.Issue
+		epic, parent *string
+	}{
+		{serverEpic, nil, strAddr("PJ-100")},
+		// The parent of an epic is its initiative, not its epic
+		{cloudEpic, nil, strAddr("PJ-100")},
+		{story, strAddr("PJ-11"), nil},
+	}
+	for _, tc := range cases {
+		is := m.IssueStateFromIssue(tc.issue)
+		matchers.MatchStringPtr(t, "state.Epic", tc.epic, is.Epic, tc.issue.Key)
+		var parent *string
+		for _, l := range is.Links {
+			if l.LinkType == store.LinkTypeParentLink {
+				k := l.TargetKey
+				parent = &k
+			}
+		}
+		matchers.MatchStringPtr(t, "parent link", tc.parent, parent, tc.issue.Key)
+	}
+}
+
+func TestIssueStateFromIssue_Estimates(t *testing.T) {
+	m := mapping.Mapper{Estimates: config.Estimates{
+		HoursPerPoint: 4,
+		Projects: []config.ProjectEstimate{
+			{Project: "Backend", Field: "customfield_10016", Unit: mapping.EstimatePoints, HoursPerPoint: 6},
+			{Project: "Mobile", Field: "customfield_10016", Unit: mapping.EstimatePoints},
+			{Project: "Ops", Field: "customfield_10700", Unit: mapping.EstimateDays},
+		},
+	}}
+	tracked := client.NewIssueFixture("PJ-4").WithProject("PJ", "Project").Issue()
+	tracked.Fields.TimeTracking = &extJira.TimeTracking{OriginalEstimate: "2h", OriginalEstimateSeconds: 7200}
+
+	cases := []struct {
+		issue   *extJira.Issue
+		seconds *int
+		points  *float64
+	}{
+		// 2 points of 6 hours
+		{client.NewIssueFixture("PJ-1").WithProject("BE", "Backend").WithCustomField("customfield_10016", 2.0).Issue(), intAddr(43200), floatAddr(3)},
+		// The points of a project without length are kept as they are
+		{client.NewIssueFixture("PJ-2").WithProject("MO", "Mobile").WithCustomField("customfield_10016", 5.0).Issue(), nil, floatAddr(5)},
+		// Half a day of 8 hours
+		{client.NewIssueFixture("PJ-3").WithProject("OP", "Ops").WithCustomField("customfield_10700", 0.5).Issue(), intAddr(14400), floatAddr(1)},
+		// The projects not configured use the original estimate
+		{tracked, intAddr(7200), floatAddr(0.5)},
+		{client.NewIssueFixture("PJ-5").WithProject("BE", "Backend").Issue(), nil, nil},
+	}
+	for _, tc := range cases {
+		is := m.IssueStateFromIssue(tc.issue)
+		if fmt.Sprint(deref(is.EstimateSeconds)) != fmt.Sprint(deref(tc.seconds)) {
+			t.Errorf("expected `state.EstimateSeconds` to be %v for %s, got %v", deref(tc.seconds), tc.issue.Key, deref(is.EstimateSeconds))
+		}
+		if fmt.Sprint(deref(is.EstimatePoints)) != fmt.Sprint(deref(tc.points)) {
+			t.Errorf("expected `state.EstimatePoints` to be %v for %s, got %v", deref(tc.points), tc.issue.Key, deref(is.EstimatePoints))
+		}
+	}
+}
+
+func TestValidateEstimates(t *testing.T) {
+	invalid := [][]config.ProjectEstimate{
+		{{Project: "Backend", Unit: "weeks"}},
+		{{Project: "Backend", Field: "storypoints"}},
+		{{Project: "Backend"}, {Project: "Backend", Unit: mapping.EstimateHours}},
+	}
+	for _, projects := range invalid {
+		if err := mapping.ValidateEstimates(config.Estimates{Projects: projects}); err == nil {
+			t.Errorf("expected an error for %v", projects)
+		}
+	}
+}
+
+func TestMapper_Redaction(t *testing.T) {
+	m := mapping.Mapper{Redaction: config.Redaction{
+		Salt: "salt",
+		Columns: []config.ColumnRedaction{
+			{Column: "issue_assignee", Action: mapping.RedactHash},
+			{Column: "issue_description", Action: mapping.RedactDrop},
+			{Column: "issue_summary", Action: mapping.RedactTruncate, Length: 5},
+			{Column: "comment_body", Action: mapping.RedactDrop},
+			{Column: "event_author", Action: mapping.RedactHash},
+		},
+	}}
+	now := time.Now()
+	i := client.NewIssueFixture("PJ-1").
+		WithSummary("Login fails with SSO").
+		WithAssignee("bob").
+		WithReporter("alice").
+		WithComment("alice", "Call me at 555-0100", now).
+		Issue()
+	i.Fields.Description = "Customer ACME reported..."
+	i.RenderedFields = &extJira.IssueRenderedFields{Description: "<p>Customer ACME reported...</p>"}
+
+	is := m.IssueStateFromIssue(i)
+	matchers.MatchStringPtr(t, "state.Summary", strAddr("Login"), is.Summary, "")
+	matchers.MatchStringPtr(t, "state.Description", nil, is.Description, "")
+	matchers.MatchStringPtr(t, "state.DescriptionHTML", nil, is.DescriptionHTML, "")
+	if is.Assignee == nil || len(*is.Assignee) != 64 || *is.Assignee == "bob" {
+		t.Errorf("expected the assignee to be hashed, got %v", is.Assignee)
+	}
+	if len(is.Comments) != 1 || is.Comments[0].Body != "" || is.Comments[0].Author == "alice" {
+		t.Errorf("expected the comment's body to be dropped and its author hashed, got %v", is.Comments)
+	}
+
+	for _, ie := range m.IssueEventsFromIssue(i) {
+		if ie.EventAuthor == "alice" {
+			t.Errorf("expected the author of `%s` to be hashed", ie.EventKind)
+		}
+		if ie.EventKind != store.EventCommentAdded {
+			continue
+		}
+		if ie.CommentBody != nil || ie.CommentLength == nil || *ie.CommentLength != 19 {
+			t.Errorf("expected the comment's body to be dropped and its length kept, got %v (%v)", ie.CommentBody, ie.CommentLength)
+		}
+		// The same values have the same hashes in all the records
+		if ie.EventAuthor != is.Comments[0].Author {
+			t.Errorf("expected the hashes of the comment's author to match, got `%s` and `%s`", ie.EventAuthor, is.Comments[0].Author)
+		}
+	}
+}
+
+func TestValidateRedaction(t *testing.T) {
+	cfs := []config.CustomField{{ID: "customfield_10600", Column: "issue_developer_backend", Type: mapping.CustomFieldUser}}
+	valid := []config.ColumnRedaction{
+		{Column: "issue_developer_backend", Action: mapping.RedactHash},
+		{Column: "comment_body", Action: mapping.RedactTruncate, Length: 10},
+	}
+	if err := mapping.ValidateRedaction(config.Redaction{Columns: valid}, cfs); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	invalid := [][]config.ColumnRedaction{
+		{{Column: "issue_created_at", Action: mapping.RedactDrop}},
+		{{Column: "comment_body", Action: "encrypt"}},
+		{{Column: "comment_body", Action: mapping.RedactTruncate}},
+		{{Column: "comment_body", Action: mapping.RedactDrop}, {Column: "comment_body", Action: mapping.RedactHash}},
+	}
+	for _, columns := range invalid {
+		if err := mapping.ValidateRedaction(config.Redaction{Columns: columns}, cfs); err == nil {
+			t.Errorf("expected an error for %v", columns)
+		}
+	}
+}
+
+func intAddr(i int) *int {
+	return &i
+}
+
+func floatAddr(f float64) *float64 {
+	return &f
+}
+
+// deref returns the value pointed by `p`, or nil if `p` is a nil
+// `*int` or `*float64`.
+func deref(p interface{}) interface{} {
+	switch v := p.(type) {
+	case *int:
+		if v != nil {
+			return *v
+		}
+	case *float64:
+		if v != nil {
+			return *v
+		}
+	}
+	return nil
+}
+
 // mockIssue mocks a Jira issue. It returns the mocked `extJira.Issue` as well
 // as the corresponding `store.IssueState` and `store.IssueEvent`s that are to
 // be expected for this issue.
@@ -294,8 +744,8 @@ func timeAsStr(t time.Time) string {
 	return t.Format("2006-01-02T15:04:05.000-0700")
 }
 
-func groupAndSortEvents(events []store.IssueEvent) map[string][]store.IssueEvent {
-	resultMap := make(map[string][]store.IssueEvent)
+func groupAndSortEvents(events []store.IssueEvent) map[store.EventKind][]store.IssueEvent {
+	resultMap := make(map[store.EventKind][]store.IssueEvent)
 	for _, e := range events {
 		if len(resultMap[e.EventKind]) == 0 {
 			resultMap[e.EventKind] = []store.IssueEvent{e}
@@ -312,3 +762,121 @@ func groupAndSortEvents(events []store.IssueEvent) map[string][]store.IssueEvent
 	}
 	return resultMap
 }
+
+func TestMapper_KeyRenames(t *testing.T) {
+	created := time.Date(2018, 7, 1, 9, 0, 0, 0, time.UTC)
+	i := client.NewIssueFixture("OLD-12").
+		WithCreated(created).
+		WithCustomField("customfield_10009", "OLD-1").
+		WithComment("dev", "Fixed", created.Add(time.Hour)).
+		Issue()
+	i.Fields.IssueLinks = []*extJira.IssueLink{
+		{Type: extJira.IssueLinkType{Name: "Blocks"}, OutwardIssue: &extJira.Issue{Key: "OLD-13"}},
+		{Type: extJira.IssueLinkType{Name: "Relates"}, InwardIssue: &extJira.Issue{Key: "PJ-2"}},
+	}
+	m := mapping.Mapper{KeyRenames: store.KeyRenames{"OLD": "NEW"}}
+
+	is := m.IssueStateFromIssue(i)
+	matchers.MatchString(t, "state.Key", "NEW-12", is.Key, i.Key)
+	matchers.MatchStringPtr(t, "state.Epic", strAddr("NEW-1"), is.Epic, i.Key)
+	targets := make(map[string]string)
+	for _, l := range is.Links {
+		matchers.MatchString(t, "link.SourceKey", "NEW-12", l.SourceKey, i.Key)
+		targets[l.LinkType] = l.TargetKey
+	}
+	expected := map[string]string{
+		store.LinkTypeEpic:        "NEW-1",
+		"Blocks":                  "NEW-13",
+		"Relates":                 "PJ-2",
+		store.LinkTypePreviousKey: "OLD-12",
+	}
+	for linkType, target := range expected {
+		matchers.MatchString(t, "link.TargetKey of "+linkType, target, targets[linkType], i.Key)
+	}
+	for _, c := range is.Comments {
+		matchers.MatchString(t, "comment.IssueKey", "NEW-12", c.IssueKey, i.Key)
+	}
+	for _, e := range m.IssueEventsFromIssue(i) {
+		matchers.MatchString(t, "event.IssueKey", "NEW-12", e.IssueKey, i.Key)
+	}
+}
+
+func TestMapper_ChangelogTruncated(t *testing.T) {
+	created := time.Date(2018, 7, 1, 9, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name      string
+		firstDays int
+		threshold time.Duration
+		expected  bool
+	}{
+		{"early history", 2, 7 * 24 * time.Hour, false},
+		{"late history", 30, 7 * 24 * time.Hour, true},
+		{"detection disabled", 30, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			i := client.NewIssueFixture("PJ-1").
+				WithCreated(created).
+				WithChangelog("status", "Open", "In Progress", created.AddDate(0, 0, tc.firstDays+1)).
+				WithChangelog("status", "In Progress", "Done", created.AddDate(0, 0, tc.firstDays)).
+				Issue()
+			m := mapping.Mapper{ChangelogTruncationThreshold: tc.threshold}
+			if is := m.IssueStateFromIssue(i); is.ChangelogTruncated != tc.expected {
+				t.Errorf("expected ChangelogTruncated to be %t, got %t", tc.expected, is.ChangelogTruncated)
+			}
+		})
+	}
+
+	m := mapping.Mapper{ChangelogTruncationThreshold: time.Hour}
+	i := client.NewIssueFixture("PJ-1").WithCreated(created).Issue()
+	if m.IssueStateFromIssue(i).ChangelogTruncated {
+		t.Errorf("expected an issue without changelog not to be flagged")
+	}
+}
+
+func TestIssueStateFromIssue_TeamInference(t *testing.T) {
+	m := mapping.Mapper{
+		CustomFields: []config.CustomField{{ID: "customfield_10700", Column: "issue_team", Type: mapping.CustomFieldOption}},
+		TeamInference: config.TeamInference{Rules: []config.TeamRule{
+			{Component: "Checkout", Team: "Payments"},
+			{Label: "search", Team: "Discovery"},
+		}},
+	}
+
+	explicit := client.NewIssueFixture("PJ-1").WithCustomField("customfield_10700", map[string]interface{}{"value": "Core"}).Issue()
+	explicit.Fields.Labels = []string{"search"}
+	byComponent := client.NewIssueFixture("PJ-2").Issue()
+	byComponent.Fields.Components = []*extJira.Component{{Name: "Checkout"}}
+	byComponent.Fields.Labels = []string{"search"}
+	byLabel := client.NewIssueFixture("PJ-3").Issue()
+	byLabel.Fields.Labels = []string{"ops", "search"}
+	unmatched := client.NewIssueFixture("PJ-4").Issue()
+
+	cases := map[*extJira.Issue]*string{explicit: strAddr("Core"), byComponent: strAddr("Payments"), byLabel: strAddr("Discovery"), unmatched: nil}
+	for i, expected := range cases {
+		team, _ := m.IssueStateFromIssue(i).CustomFields["issue_team"].(*string)
+		matchers.MatchStringPtr(t, "state.CustomFields[issue_team]", expected, team, i.Key)
+	}
+}
+
+func TestValidateTeamInference(t *testing.T) {
+	cfs := []config.CustomField{
+		{ID: "customfield_10700", Column: "issue_team", Type: mapping.CustomFieldOption},
+		{ID: "customfield_10701", Column: "issue_squad_size", Type: mapping.CustomFieldNumber},
+	}
+	valid := config.TeamInference{Rules: []config.TeamRule{{Component: "Checkout", Team: "Payments"}}}
+	if err := mapping.ValidateTeamInference(valid, cfs); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	invalid := []config.TeamInference{
+		{Column: "issue_squad", Rules: valid.Rules},
+		{Column: "issue_squad_size", Rules: valid.Rules},
+		{Rules: []config.TeamRule{{Component: "Checkout"}}},
+		{Rules: []config.TeamRule{{Team: "Payments"}}},
+		{Rules: []config.TeamRule{{Component: "Checkout", Label: "checkout", Team: "Payments"}}},
+	}
+	for _, ti := range invalid {
+		if err := mapping.ValidateTeamInference(ti, cfs); err == nil {
+			t.Errorf("expected an error for %v", ti)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package mapping
+
+import (
+	"sync"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// ChangelogPlugin interprets the changelog items of fields the
+// mapping doesn't know, e.g. those of Marketplace apps (Zephyr test
+// executions, the team of Advanced Roadmaps...), into events.
+//
+// Plugins are registered with `RegisterChangelogPlugin` and called
+// by `Mapper.IssueEventsFromIssue` with each changelog item of the
+// issues, in addition to the events generated by the mapping.
+type ChangelogPlugin interface {
+	// Name identifies the plugin.
+	Name() string
+
+	// Events returns the events of the changelog item of the
+	// history, or nil if the plugin doesn't handle the item. The
+	// time, author and issue key of the events default to those of
+	// the history (see `pluginEvents`).
+	//
+	// The kinds of the events must be valid: kinds of their own are
+	// registered with `store.RegisterEventKind`, otherwise the
+	// records of the issue are not written.
+	Events(i *extJira.Issue, h extJira.ChangelogHistory, item extJira.ChangelogItems) []store.IssueEvent
+}
+
+var (
+	pluginsMutex sync.Mutex
+	plugins      []ChangelogPlugin
+)
+
+// RegisterChangelogPlugin registers a changelog plugin, e.g. from
+// the `init` function of its package. Plugins are called in their
+// registration order.
+func RegisterChangelogPlugin(p ChangelogPlugin) {
+	pluginsMutex.Lock()
+	defer pluginsMutex.Unlock()
+	plugins = append(plugins, p)
+}
+
+// ChangelogPlugins returns the plugins registered with
+// `RegisterChangelogPlugin`, in their registration order.
+func ChangelogPlugins() []ChangelogPlugin {
+	pluginsMutex.Lock()
+	defer pluginsMutex.Unlock()
+	ps := make([]ChangelogPlugin, len(plugins))
+	copy(ps, plugins)
+	return ps
+}
+
+// pluginEvents returns the events of the changelog item generated
+// by the plugins. The events with no time, author or issue key get
+// those of the history and issue.
+func pluginEvents(ps []ChangelogPlugin, i *extJira.Issue, h extJira.ChangelogHistory, item extJira.ChangelogItems) []store.IssueEvent {
+	var ies []store.IssueEvent
+	for _, p := range ps {
+		for _, ie := range p.Events(i, h, item) {
+			if ie.EventTime.IsZero() {
+				ie.EventTime = parseTime(h.Created)
+			}
+			if ie.EventAuthor == "" {
+				ie.EventAuthor = h.Author.Name
+				ie.EventAuthorAccountID = accountID(&h.Author)
+			}
+			if ie.IssueKey == "" {
+				ie.IssueKey = i.Key
+			}
+			ies = append(ies, ie)
+		}
+	}
+	return ies
+}
@@ -0,0 +1,59 @@
+package mapping
+
+import (
+	"encoding/json"
+
+	extJira "github.com/andygrunwald/go-jira"
+)
+
+// propertiesField is the key of `Fields.Unknowns` holding the entity
+// properties of the issue, by key (see `client.PropertiesField`).
+const propertiesField = "properties"
+
+// properties returns the entity properties of the issue listed in
+// `Mapper.IssueProperties`, as a JSON object keyed by property, or
+// nil if the issue has none of them.
+func (m *Mapper) properties(i *extJira.Issue) *string {
+	if len(m.IssueProperties) == 0 || i.Fields == nil {
+		return nil
+	}
+	all, ok := i.Fields.Unknowns[propertiesField].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	props := make(map[string]interface{})
+	for _, k := range m.IssueProperties {
+		if v, ok := all[k]; ok {
+			props[k] = v
+		}
+	}
+	if len(props) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(props)
+	if err != nil {
+		return nil
+	}
+	s := string(b)
+	return &s
+}
+
+// unmarshalIssue decodes a raw Jira issue, moving its entity
+// properties, if it was fetched with some, to
+// `Fields.Unknowns[propertiesField]` like the API client does.
+func unmarshalIssue(raw []byte) (*extJira.Issue, error) {
+	var i struct {
+		extJira.Issue
+		Properties map[string]interface{} `json:"properties"`
+	}
+	if err := json.Unmarshal(raw, &i); err != nil {
+		return nil, err
+	}
+	if i.Fields != nil && len(i.Properties) > 0 {
+		if i.Fields.Unknowns == nil {
+			i.Fields.Unknowns = make(map[string]interface{})
+		}
+		i.Fields.Unknowns[propertiesField] = i.Properties
+	}
+	return &i.Issue, nil
+}
@@ -0,0 +1,205 @@
+package mapping
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Actions of the redactions of the columns (see
+// `config.ColumnRedaction`)
+const (
+	RedactDrop     = "drop"
+	RedactHash     = "hash"
+	RedactTruncate = "truncate"
+)
+
+// redactFunc returns the redacted value, nil if the value is
+// dropped.
+type redactFunc func(v *string) *string
+
+// redactableColumn redacts the values of a column in the records
+// they're copied to.
+type redactableColumn struct {
+	state func(r redactFunc, is *store.IssueState)
+	event func(r redactFunc, ie *store.IssueEvent)
+}
+
+// redactableColumns are the columns which can be redacted, besides
+// the text custom columns. The authors of the events also cover
+// those of the comments and description revisions, the description
+// its rendering in HTML, and the comment bodies those of
+// `jira_issue_comments`, rendered or not.
+var redactableColumns = map[string]redactableColumn{
+	"issue_summary": {state: func(r redactFunc, is *store.IssueState) {
+		summary := requiredString(is.Summary)
+		redactRequired(r, &summary)
+		is.Summary = &summary
+	}},
+	"issue_description": {state: func(r redactFunc, is *store.IssueState) {
+		is.Description = r(is.Description)
+		is.DescriptionHTML = r(is.DescriptionHTML)
+		for k := range is.DescriptionRevisions {
+			rev := &is.DescriptionRevisions[k]
+			rev.From, rev.To = r(rev.From), r(rev.To)
+		}
+	}},
+	"issue_assignee":            {state: func(r redactFunc, is *store.IssueState) { is.Assignee = r(is.Assignee) }},
+	"issue_assignee_account_id": {state: func(r redactFunc, is *store.IssueState) { is.AssigneeAccountID = r(is.AssigneeAccountID) }},
+	"issue_epic_name":           {state: func(r redactFunc, is *store.IssueState) { is.EpicName = r(is.EpicName) }},
+	"event_author": {
+		state: func(r redactFunc, is *store.IssueState) {
+			for k := range is.Comments {
+				redactRequired(r, &is.Comments[k].Author)
+			}
+			for k := range is.DescriptionRevisions {
+				redactRequired(r, &is.DescriptionRevisions[k].Author)
+			}
+		},
+		event: func(r redactFunc, ie *store.IssueEvent) { redactRequired(r, &ie.EventAuthor) },
+	},
+	"event_author_account_id": {
+		state: func(r redactFunc, is *store.IssueState) {
+			for k := range is.Comments {
+				is.Comments[k].AuthorAccountID = r(is.Comments[k].AuthorAccountID)
+			}
+		},
+		event: func(r redactFunc, ie *store.IssueEvent) { ie.EventAuthorAccountID = r(ie.EventAuthorAccountID) },
+	},
+	"comment_body": {
+		state: func(r redactFunc, is *store.IssueState) {
+			for k := range is.Comments {
+				redactRequired(r, &is.Comments[k].Body)
+				is.Comments[k].BodyHTML = r(is.Comments[k].BodyHTML)
+			}
+		},
+		event: func(r redactFunc, ie *store.IssueEvent) {
+			if ie.CommentBody != nil && ie.CommentLength == nil {
+				n := utf8.RuneCountInString(*ie.CommentBody)
+				ie.CommentLength = &n
+			}
+			ie.CommentBody = r(ie.CommentBody)
+		},
+	},
+	"assignee_change_from": {event: func(r redactFunc, ie *store.IssueEvent) { ie.AssigneeChangeFrom = r(ie.AssigneeChangeFrom) }},
+	"assignee_change_to":   {event: func(r redactFunc, ie *store.IssueEvent) { ie.AssigneeChangeTo = r(ie.AssigneeChangeTo) }},
+	"assignee_change_from_account_id": {event: func(r redactFunc, ie *store.IssueEvent) {
+		ie.AssigneeChangeFromAccountID = r(ie.AssigneeChangeFromAccountID)
+	}},
+	"assignee_change_to_account_id": {event: func(r redactFunc, ie *store.IssueEvent) {
+		ie.AssigneeChangeToAccountID = r(ie.AssigneeChangeToAccountID)
+	}},
+	"status_change_reason": {event: func(r redactFunc, ie *store.IssueEvent) { ie.StatusChangeReason = r(ie.StatusChangeReason) }},
+	"field_change_from":    {event: func(r redactFunc, ie *store.IssueEvent) { ie.FieldChangeFrom = r(ie.FieldChangeFrom) }},
+	"field_change_to":      {event: func(r redactFunc, ie *store.IssueEvent) { ie.FieldChangeTo = r(ie.FieldChangeTo) }},
+}
+
+// RedactableColumns returns the names of the columns which can be
+// redacted, besides the text custom columns, sorted.
+func RedactableColumns() []string {
+	var columns []string
+	for c := range redactableColumns {
+		columns = append(columns, c)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// redactRequired redacts a required value, which is emptied if
+// dropped.
+func redactRequired(r redactFunc, v *string) {
+	if rv := r(v); rv != nil {
+		*v = *rv
+	} else {
+		*v = ""
+	}
+}
+
+// ValidateRedaction returns an error if a redaction has an unknown
+// column or an invalid action, or if a column is redacted twice.
+// The text custom columns of `cfs` can be redacted.
+func ValidateRedaction(rd config.Redaction, cfs []config.CustomField) error {
+	columns := make(map[string]bool)
+	for c := range redactableColumns {
+		columns[c] = true
+	}
+	for _, cf := range cfs {
+		if customFieldColumnTypes[cf.Type] == store.CustomColumnText {
+			columns[cf.Column] = true
+		}
+	}
+	redacted := make(map[string]bool)
+	for _, cr := range rd.Columns {
+		switch {
+		case !columns[cr.Column]:
+			return fmt.Errorf("column `%s` can't be redacted", cr.Column)
+		case redacted[cr.Column]:
+			return fmt.Errorf("column `%s` redacted twice", cr.Column)
+		case cr.Action != RedactDrop && cr.Action != RedactHash && cr.Action != RedactTruncate:
+			return fmt.Errorf("invalid action `%s` for column `%s` (expected `drop`, `hash` or `truncate`)", cr.Action, cr.Column)
+		case cr.Action == RedactTruncate && cr.Length <= 0:
+			return fmt.Errorf("invalid length %d for column `%s` (expected a positive length)", cr.Length, cr.Column)
+		}
+		redacted[cr.Column] = true
+	}
+	return nil
+}
+
+// redactor returns the function applying the redaction's action.
+func (m *Mapper) redactor(cr config.ColumnRedaction) redactFunc {
+	return func(v *string) *string {
+		if v == nil {
+			return nil
+		}
+		switch cr.Action {
+		case RedactHash:
+			sum := sha256.Sum256([]byte(m.Redaction.Salt + *v))
+			h := hex.EncodeToString(sum[:])
+			return &h
+		case RedactTruncate:
+			if utf8.RuneCountInString(*v) <= cr.Length {
+				return v
+			}
+			t := string([]rune(*v)[:cr.Length])
+			return &t
+		}
+		return nil
+	}
+}
+
+// redactState applies the redactions of the columns (see
+// `Mapper.Redaction`) to the issue state.
+func (m *Mapper) redactState(is *store.IssueState) {
+	for _, cr := range m.Redaction.Columns {
+		r := m.redactor(cr)
+		if rc, ok := redactableColumns[cr.Column]; ok {
+			if rc.state != nil {
+				rc.state(r, is)
+			}
+			continue
+		}
+		if v, ok := is.CustomFields[cr.Column].(*string); ok {
+			is.CustomFields[cr.Column] = r(v)
+		}
+	}
+}
+
+// redactEvents applies the redactions of the columns (see
+// `Mapper.Redaction`) to the issue events.
+func (m *Mapper) redactEvents(ies []store.IssueEvent) {
+	for _, cr := range m.Redaction.Columns {
+		rc := redactableColumns[cr.Column]
+		if rc.event == nil {
+			continue
+		}
+		r := m.redactor(cr)
+		for k := range ies {
+			rc.event(r, &ies[k])
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package mapping
+
+import (
+	extJira "github.com/andygrunwald/go-jira"
+)
+
+// descriptionHTML returns the description of the issue rendered in
+// HTML by Jira, nil if the rendered fields were not fetched (see
+// `client.Options.RenderedFields`).
+func descriptionHTML(i *extJira.Issue) *string {
+	if i.RenderedFields == nil {
+		return nil
+	}
+	return &i.RenderedFields.Description
+}
+
+// commentsHTML returns the bodies of the comments of the issue
+// rendered in HTML by Jira, by comment ID, nil if the rendered fields
+// were not fetched.
+func commentsHTML(i *extJira.Issue) map[string]string {
+	if i.RenderedFields == nil || i.RenderedFields.Comments == nil {
+		return nil
+	}
+	bodies := make(map[string]string, len(i.RenderedFields.Comments.Comments))
+	for _, c := range i.RenderedFields.Comments.Comments {
+		bodies[c.ID] = c.Body
+	}
+	return bodies
+}
@@ -0,0 +1,138 @@
+package mapping
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// sprintChangelogField is the name of the changelog field of sprint
+// changes.
+const sprintChangelogField = "Sprint"
+
+// greenhopperSprintID matches the ID in sprints serialized by Jira
+// Agile (see `greenhopperSprintName`).
+var greenhopperSprintID = regexp.MustCompile(`[\[,]id=([0-9]+)`)
+
+// sprintIDs returns the IDs of the issue's sprints, comma-separated,
+// or nil if the issue has never been in a sprint. The IDs are those
+// of `jira_sprints`.
+func (m *Mapper) sprintIDs(i *extJira.Issue) *string {
+	values, ok := i.Fields.Unknowns[fieldID(i, m.agileFields().Sprint)].([]interface{})
+	if !ok {
+		return nil
+	}
+	ids := make([]string, 0, len(values))
+	for _, v := range values {
+		switch sprint := v.(type) {
+		case map[string]interface{}:
+			if id, ok := sprint["id"].(float64); ok {
+				ids = append(ids, strconv.Itoa(int(id)))
+			}
+		case string:
+			if m := greenhopperSprintID.FindStringSubmatch(sprint); m != nil {
+				ids = append(ids, m[1])
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	s := strings.Join(ids, ",")
+	return &s
+}
+
+// sprintRef identifies a sprint in a changelog item, by its ID and
+// name if known.
+type sprintRef struct {
+	id   *int
+	name *string
+}
+
+// key returns the ID of the sprint, or its name if the ID is not
+// known.
+func (r sprintRef) key() string {
+	if r.id != nil {
+		return strconv.Itoa(*r.id)
+	}
+	if r.name != nil {
+		return *r.name
+	}
+	return ""
+}
+
+// sprintRefs returns the sprints listed in a side of a changelog
+// item of the sprint field: the IDs (e.g. "12, 13") in `ids` and the
+// names (e.g. "Sprint 1, Sprint 2") in `names`. Names are only
+// attached to IDs if they have the same count, since names may
+// contain commas.
+func sprintRefs(ids interface{}, names string) []sprintRef {
+	idList := splitSprints(ids)
+	nameList := splitSprints(names)
+	n := len(idList)
+	if len(nameList) > n {
+		n = len(nameList)
+	}
+	refs := make([]sprintRef, n)
+	for k := range refs {
+		if k < len(idList) {
+			if id, err := strconv.Atoi(idList[k]); err == nil {
+				refs[k].id = &id
+			}
+		}
+		if len(nameList) == len(idList) || refs[k].id == nil && k < len(nameList) {
+			name := nameList[k]
+			refs[k].name = &name
+		}
+	}
+	return refs
+}
+
+// splitSprints splits a comma-separated list of sprints, ignoring
+// empty items.
+func splitSprints(v interface{}) []string {
+	s, _ := v.(string)
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// sprintEvents returns the `sprint_added` and `sprint_removed`
+// events of a changelog item of the sprint field, which lists all
+// the sprints of the issue before and after the change.
+func sprintEvents(i *extJira.Issue, h extJira.ChangelogHistory, item extJira.ChangelogItems) []store.IssueEvent {
+	from := sprintRefs(item.From, item.FromString)
+	to := sprintRefs(item.To, item.ToString)
+	var events []store.IssueEvent
+	diff := func(refs, others []sprintRef, kind store.EventKind) {
+		keys := make(map[string]bool, len(others))
+		for _, r := range others {
+			keys[r.key()] = true
+		}
+		for _, r := range refs {
+			if keys[r.key()] {
+				continue
+			}
+			events = append(events, store.IssueEvent{
+				EventTime:            parseTime(h.Created),
+				EventKind:            kind,
+				EventAuthor:          h.Author.Name,
+				EventAuthorAccountID: accountID(&h.Author),
+				IssueKey:             i.Key,
+				SprintID:             r.id,
+				SprintName:           r.name,
+			})
+		}
+	}
+	diff(from, to, store.EventSprintRemoved)
+	diff(to, from, store.EventSprintAdded)
+	return events
+}
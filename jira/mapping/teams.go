@@ -0,0 +1,98 @@
+package mapping
+
+import (
+	"fmt"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// DefaultTeamColumn is the custom column holding the team of the
+// issues when `config.TeamInference.Column` is not set.
+const DefaultTeamColumn = "issue_team"
+
+// ValidateTeamInference returns an error if the column of the team
+// inference is not a text custom column of `cfs`, or if a rule
+// doesn't have a team and exactly one of a component and a label.
+func ValidateTeamInference(ti config.TeamInference, cfs []config.CustomField) error {
+	if len(ti.Rules) == 0 {
+		return nil
+	}
+	column := teamColumn(ti)
+	found := false
+	for _, cf := range cfs {
+		if cf.Column == column {
+			if customFieldColumnTypes[cf.Type] != store.CustomColumnText {
+				return fmt.Errorf("column `%s` of custom field `%s` is not a text column", column, cf.ID)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("column `%s` is not the column of a custom field", column)
+	}
+	for i, r := range ti.Rules {
+		switch {
+		case r.Team == "":
+			return fmt.Errorf("rule %d has no team", i)
+		case (r.Component == "") == (r.Label == ""):
+			return fmt.Errorf("rule %d must have either a component or a label", i)
+		}
+	}
+	return nil
+}
+
+// teamColumn returns the column of the team inference, or
+// `DefaultTeamColumn` if not set.
+func teamColumn(ti config.TeamInference) string {
+	if ti.Column == "" {
+		return DefaultTeamColumn
+	}
+	return ti.Column
+}
+
+// inferTeam sets the team column of the issue state (see
+// `Mapper.TeamInference`) to the team of the first rule matching a
+// component or a label of the issue, if the column is empty.
+func (m *Mapper) inferTeam(i *extJira.Issue, is *store.IssueState) {
+	if len(m.TeamInference.Rules) == 0 {
+		return
+	}
+	column := teamColumn(m.TeamInference)
+	v, ok := is.CustomFields[column]
+	if !ok {
+		return
+	}
+	if s, _ := v.(*string); s != nil && *s != "" {
+		return
+	}
+	for _, r := range m.TeamInference.Rules {
+		if (r.Component != "" && hasComponent(i, r.Component)) || (r.Label != "" && hasLabel(i, r.Label)) {
+			team := r.Team
+			is.CustomFields[column] = &team
+			return
+		}
+	}
+}
+
+// hasComponent returns true if the issue has the component.
+func hasComponent(i *extJira.Issue, name string) bool {
+	for _, c := range i.Fields.Components {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLabel returns true if the issue has the label.
+func hasLabel(i *extJira.Issue, label string) bool {
+	for _, l := range i.Fields.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package mapping
+
+import (
+	"time"
+
+	extJira "github.com/andygrunwald/go-jira"
+)
+
+// changelogTruncated returns true if the earliest history of the
+// issue's changelog is later than its creation plus
+// `ChangelogTruncationThreshold`, e.g. if Jira returned only the
+// last histories and the whole changelog couldn't be fetched. An
+// issue without changelog is not flagged, since it may have never
+// changed.
+func (m *Mapper) changelogTruncated(i *extJira.Issue) bool {
+	if m.ChangelogTruncationThreshold <= 0 || i.Changelog == nil || len(i.Changelog.Histories) == 0 {
+		return false
+	}
+	earliest := parseTime(i.Changelog.Histories[0].Created)
+	for _, h := range i.Changelog.Histories[1:] {
+		if t := parseTime(h.Created); t.Before(earliest) {
+			earliest = t
+		}
+	}
+	return earliest.After(time.Time(i.Fields.Created).Add(m.ChangelogTruncationThreshold))
+}
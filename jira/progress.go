@@ -0,0 +1,142 @@
+package jira
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// ProgressInterval is the interval at which the progress of the
+// syncs and imports is logged.
+var ProgressInterval = 10 * time.Second
+
+// progress counts the issues processed by a sync or an import and
+// logs the counts every `ProgressInterval`, so long runs can be
+// told apart from stuck ones:
+//
+//   - discovered: the issues found by the search (or read from the
+//     imported file);
+//   - fetched: the issues fetched from Jira;
+//   - inserted: the issues written to the store;
+//   - errors: the issues which could not be fetched or written.
+//
+// The ETA is estimated from the rate of the issues processed so far
+// and the issues discovered but not processed yet. While the search
+// is still running, more issues may be discovered, so it's a lower
+// bound (`searching=true`).
+type progress struct {
+	name    string
+	started time.Time
+
+	mutex      sync.Mutex
+	discovered int
+	fetched    int
+	inserted   int
+	errors     int
+	searching  bool
+
+	// inFlight are the issues being processed by the workers, and
+	// lastProcessed and lastStall the times the last one was
+	// processed and the last stall was logged (see `checkStall`).
+	inFlight      map[string]*inFlightIssue
+	lastProcessed time.Time
+	lastStall     time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startProgress starts logging the progress of the run (e.g. "Sync")
+// until `stop` is called, and checking whether it stalls if
+// `StallTimeout` is set.
+func startProgress(name string) *progress {
+	now := time.Now()
+	p := &progress{name: name, started: now, lastProcessed: now, searching: true, done: make(chan struct{})}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		t := time.NewTicker(ProgressInterval)
+		defer t.Stop()
+		var stall <-chan time.Time
+		if StallTimeout > 0 {
+			st := time.NewTicker(StallTimeout / 4)
+			defer st.Stop()
+			stall = st.C
+		}
+		for {
+			select {
+			case <-t.C:
+				p.log()
+			case <-stall:
+				p.checkStall()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *progress) discover() { p.add(&p.discovered) }
+func (p *progress) fetch()    { p.add(&p.fetched) }
+func (p *progress) fail()     { p.add(&p.errors) }
+
+// stored records the result of writing an issue to the store.
+func (p *progress) stored(err error) {
+	if err != nil {
+		p.fail()
+		return
+	}
+	p.add(&p.inserted)
+}
+
+// searchDone records that all the issues have been discovered.
+func (p *progress) searchDone() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.searching = false
+}
+
+func (p *progress) add(counter *int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	*counter++
+}
+
+// stop stops logging the progress, and logs the final counts.
+func (p *progress) stop() {
+	close(p.done)
+	p.wg.Wait()
+	p.log()
+}
+
+// fields returns the counts, the rate and the ETA as log fields.
+func (p *progress) fields() logging.Fields {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	elapsed := time.Since(p.started)
+	f := logging.Fields{
+		"discovered": p.discovered,
+		"fetched":    p.fetched,
+		"inserted":   p.inserted,
+		"errors":     p.errors,
+		"searching":  p.searching,
+		"elapsed":    elapsed.Round(time.Second).String(),
+	}
+	processed := p.inserted + p.errors
+	if processed > 0 && elapsed > 0 {
+		rate := float64(processed) / elapsed.Minutes()
+		f["issues_per_minute"] = int(rate)
+		remaining := p.discovered - processed
+		if remaining < 0 {
+			remaining = 0
+		}
+		f["eta"] = time.Duration(float64(remaining) / rate * float64(time.Minute)).Round(time.Second).String()
+	}
+	return f
+}
+
+func (p *progress) log() {
+	logging.WithFields(p.fields()).Infof("%s progress", p.name)
+}
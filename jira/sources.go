@@ -0,0 +1,215 @@
+package jira
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Source is a named set of issues, restricted by a JQL query and
+// mapped with its own mapper (e.g. with the custom fields of its
+// project).
+type Source struct {
+	Name string
+
+	// JQL restricts the issues of the source, e.g. `project = PJ
+	// AND issuetype != Sub-task`.
+	JQL string
+
+	// FilterID is the ID of a Jira saved filter restricting the
+	// issues of the source instead of `JQL`. The query of the
+	// filter is fetched by each search (see `FilterResolver`), so
+	// the source follows the changes made to the filter in Jira.
+	FilterID int
+
+	Mapper Mapper
+}
+
+// FilterResolver is implemented by clients able to fetch the JQL
+// query of a saved filter (e.g. `APIClient`), required by the
+// sources defined by a filter.
+type FilterResolver interface {
+	GetFilterJQL(filterID int) (string, error)
+}
+
+// Sources syncs the issues of several sources. It's both the client
+// and the mapper to pass to the syncs:
+//
+//   - `SearchIssues` searches the issues of each source in turn, an
+//     issue matching several sources belonging to the first one;
+//   - `GetIssue` fetches the issue if it belongs to a source, looking
+//     it up when it was not searched (e.g. for `sync-issue` or
+//     webhooks);
+//   - the mapping is performed with the mapper of the issue's source,
+//     and the issue's state is tagged with the source's name
+//     (`store.IssueState.Source`).
+type Sources struct {
+	Client
+	sources []Source
+
+	mutex    sync.Mutex
+	sourceOf map[string]int
+	jqls     map[int]string
+}
+
+// NewSources returns the `Sources` fetching the issues of the
+// sources with `c`.
+func NewSources(c Client, sources []Source) *Sources {
+	return &Sources{Client: c, sources: sources, sourceOf: make(map[string]int), jqls: make(map[int]string)}
+}
+
+// SearchIssues searches the issues of each source matching the
+// query, the queries of the saved filters being fetched again.
+// Stops at the first search failing.
+func (s *Sources) SearchIssues(query string, issueKeys chan string) error {
+	defer close(issueKeys)
+	seen := make(map[string]bool)
+	for i, src := range s.sources {
+		jql, err := s.jql(i, true)
+		if err != nil {
+			return fmt.Errorf("error searching issues of source `%s`: %s", src.Name, err)
+		}
+		keys := make(chan string, cap(issueKeys))
+		done := make(chan struct{})
+		go func() {
+			for k := range keys {
+				if seen[k] {
+					continue
+				}
+				seen[k] = true
+				s.setSource(k, i)
+				issueKeys <- k
+			}
+			close(done)
+		}()
+		err = s.Client.SearchIssues(Filter{Query: jql}.Apply(query), keys)
+		<-done
+		if err != nil {
+			return fmt.Errorf("error searching issues of source `%s`: %s", src.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetIssue fetches the issue, if it belongs to one of the sources.
+func (s *Sources) GetIssue(issueKey string) (*jira.Issue, error) {
+	if _, ok := s.source(issueKey); !ok {
+		found, err := s.lookupSource(issueKey)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("issue `%s` does not belong to any source", issueKey)
+		}
+	}
+	return s.Client.GetIssue(issueKey)
+}
+
+// IssueStateFromIssue maps the issue with the mapper of its source
+// and tags it with the source's name.
+func (s *Sources) IssueStateFromIssue(i *jira.Issue) store.IssueState {
+	src := s.mapperSource(i.Key)
+	is := src.Mapper.IssueStateFromIssue(i)
+	name := src.Name
+	is.Source = &name
+	return is
+}
+
+// IssueEventsFromIssue maps the issue with the mapper of its source.
+func (s *Sources) IssueEventsFromIssue(i *jira.Issue) []store.IssueEvent {
+	return s.mapperSource(i.Key).Mapper.IssueEventsFromIssue(i)
+}
+
+// ClockSkew returns the clock skew measured by the wrapped client,
+// if it's a `ClockSkewer`.
+func (s *Sources) ClockSkew() time.Duration {
+	return clockSkew(s.Client)
+}
+
+// mapperSource returns the source of the issue, or the first source
+// if the issue was not fetched by `s`.
+func (s *Sources) mapperSource(issueKey string) Source {
+	i, _ := s.source(issueKey)
+	return s.sources[i]
+}
+
+// lookupSource searches the issue in each source, recording the
+// first one it belongs to. Returns false if it belongs to none.
+func (s *Sources) lookupSource(issueKey string) (bool, error) {
+	for i := range s.sources {
+		jql, err := s.jql(i, false)
+		if err != nil {
+			return false, fmt.Errorf("error looking up the source of issue `%s`: %s", issueKey, err)
+		}
+		keys := make(chan string, 1)
+		var found bool
+		done := make(chan struct{})
+		go func() {
+			for range keys {
+				found = true
+			}
+			close(done)
+		}()
+		err = s.Client.SearchIssues(Filter{Query: jql}.Apply(fmt.Sprintf("issuekey = %s", quoteJQL(issueKey))), keys)
+		<-done
+		if err != nil {
+			return false, fmt.Errorf("error looking up the source of issue `%s`: %s", issueKey, err)
+		}
+		if found {
+			s.setSource(issueKey, i)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// jql returns the JQL query of the i-th source. The query of a
+// source defined by a saved filter is fetched if `refresh` is set or
+// it was not fetched yet, without its `ORDER BY` clause.
+func (s *Sources) jql(i int, refresh bool) (string, error) {
+	src := s.sources[i]
+	if src.FilterID == 0 {
+		return src.JQL, nil
+	}
+	s.mutex.Lock()
+	jql, ok := s.jqls[i]
+	s.mutex.Unlock()
+	if ok && !refresh {
+		return jql, nil
+	}
+	fr, ok := unfiltered(s.Client).(FilterResolver)
+	if !ok {
+		return "", fmt.Errorf("the client can't fetch saved filter %d", src.FilterID)
+	}
+	jql, err := fr.GetFilterJQL(src.FilterID)
+	if err != nil {
+		return "", err
+	}
+	if j := strings.Index(strings.ToUpper(jql), "ORDER BY"); j >= 0 {
+		jql = strings.TrimSpace(jql[:j])
+	}
+	logging.Debugf("Resolved saved filter %d of source `%s`: %s", src.FilterID, src.Name, jql)
+	s.mutex.Lock()
+	s.jqls[i] = jql
+	s.mutex.Unlock()
+	return jql, nil
+}
+
+func (s *Sources) source(issueKey string) (int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	i, ok := s.sourceOf[issueKey]
+	return i, ok
+}
+
+func (s *Sources) setSource(issueKey string, i int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sourceOf[issueKey] = i
+}
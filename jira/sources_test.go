@@ -0,0 +1,116 @@
+package jira_test
+
+import (
+	"fmt"
+	"testing"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// sourceMapper maps the issues to states of the type `issueType`.
+type sourceMapper struct {
+	mapperMock
+	issueType string
+}
+
+func (m *sourceMapper) IssueStateFromIssue(i *extJira.Issue) store.IssueState {
+	return store.IssueState{Key: i.Key, Type: &m.issueType}
+}
+
+func TestSources(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := jira.NewSources(c, []jira.Source{
+		{Name: "web", JQL: "project = WEB", Mapper: &sourceMapper{issueType: "Story"}},
+		{Name: "mobile", JQL: "project = MOB", Mapper: &sourceMapper{issueType: "Bug"}},
+	})
+
+	// An issue matching several sources belongs to the first one
+	c.ExpectSearchIssues(`\(project = WEB\) ORDER BY updated ASC`).WillRespondWithIssueKeys([]string{"WEB-1", "MOB-2"})
+	c.ExpectSearchIssues(`\(project = MOB\) ORDER BY updated ASC`).WillRespondWithIssueKeys([]string{"MOB-1", "MOB-2"})
+	issueKeys := make(chan string, 10)
+	if err := s.SearchIssues("ORDER BY updated ASC", issueKeys); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k := range issueKeys {
+		keys = append(keys, k)
+	}
+	if len(keys) != 3 {
+		t.Errorf("expected 3 issues, got %v", keys)
+	}
+
+	for key, expected := range map[string][2]string{
+		"WEB-1": {"web", "Story"},
+		"MOB-2": {"web", "Story"},
+		"MOB-1": {"mobile", "Bug"},
+	} {
+		is := s.IssueStateFromIssue(&extJira.Issue{Key: key})
+		if *is.Source != expected[0] || *is.Type != expected[1] {
+			t.Errorf("expected `%s` to be mapped by source `%s`, got `%s` (%s)", key, expected[0], *is.Source, *is.Type)
+		}
+	}
+
+	// The source of an issue which was not searched is looked up
+	c.ExpectSearchIssues(`\(project = WEB\) AND \(issuekey = "MOB-3"\)`).WillRespondWithIssueKeys(nil)
+	c.ExpectSearchIssues(`\(project = MOB\) AND \(issuekey = "MOB-3"\)`).WillRespondWithIssueKeys([]string{"MOB-3"})
+	c.ExpectGetIssue("MOB-3").WillRespondWithIssue(&extJira.Issue{Key: "MOB-3"})
+	i, err := s.GetIssue("MOB-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if is := s.IssueStateFromIssue(i); *is.Source != "mobile" {
+		t.Errorf("expected `MOB-3` to be mapped by source `mobile`, got `%s`", *is.Source)
+	}
+
+	// Issues belonging to no source are not fetched
+	c.ExpectSearchIssues(`\(project = WEB\) AND \(issuekey = "OPS-1"\)`).WillRespondWithIssueKeys(nil)
+	c.ExpectSearchIssues(`\(project = MOB\) AND \(issuekey = "OPS-1"\)`).WillRespondWithIssueKeys(nil)
+	if _, err = s.GetIssue("OPS-1"); err == nil {
+		t.Errorf("expected an error for an issue belonging to no source")
+	}
+}
+
+// filterMockClient is a `MockClient` able to fetch the queries of
+// saved filters (see `jira.FilterResolver`).
+type filterMockClient struct {
+	*client.MockClient
+	jqls map[int]string
+}
+
+func (c *filterMockClient) GetFilterJQL(filterID int) (string, error) {
+	jql, ok := c.jqls[filterID]
+	if !ok {
+		return "", fmt.Errorf("filter %d not found", filterID)
+	}
+	return jql, nil
+}
+
+func TestSources_FilterID(t *testing.T) {
+	c := &filterMockClient{MockClient: client.NewMockClient(t), jqls: map[int]string{10200: "project = SUP ORDER BY rank"}}
+	s := jira.NewSources(c, []jira.Source{
+		{Name: "support", FilterID: 10200, Mapper: &sourceMapper{issueType: "Bug"}},
+	})
+
+	// The query of the filter is used without its `ORDER BY`
+	c.ExpectSearchIssues(`^\(project = SUP\) ORDER BY updated ASC$`).WillRespondWithIssueKeys([]string{"SUP-1"})
+	if err := s.SearchIssues("ORDER BY updated ASC", make(chan string, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	// It's fetched again by each search, following the changes of
+	// the filter
+	c.jqls[10200] = "project IN (SUP, HELP)"
+	c.ExpectSearchIssues(`^\(project IN \(SUP, HELP\)\) ORDER BY updated ASC$`).WillRespondWithIssueKeys(nil)
+	if err := s.SearchIssues("ORDER BY updated ASC", make(chan string, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	delete(c.jqls, 10200)
+	if err := s.SearchIssues("ORDER BY updated ASC", make(chan string, 10)); err == nil {
+		t.Errorf("expected an error for a filter which can't be fetched")
+	}
+}
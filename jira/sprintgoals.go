@@ -0,0 +1,60 @@
+package jira
+
+import (
+	"fmt"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// SprintGoalQuery is the JQL clause selecting the issues tagged to
+// the goal of their sprint, e.g. `labels = sprint-goal`. The sprint
+// goals are not evaluated if it's empty.
+var SprintGoalQuery string
+
+// SprintGoalStore is implemented by stores evaluating the goals of
+// the sprints (e.g. `store.PGStore`).
+type SprintGoalStore interface {
+	GetSprintsToEvaluate(all bool) ([]store.Sprint, error)
+	ReplaceSprintGoalResult(sp store.Sprint, goalIssueKeys []string) error
+}
+
+// PerformSprintGoalsEvaluation evaluates the goals of the closed
+// sprints which were not evaluated yet, or of all the closed sprints
+// if `all` (e.g. after changing `SprintGoalQuery`): the issues of
+// each sprint matching `SprintGoalQuery` are searched, and the store
+// records whether they were all completed with the sprint. Nothing
+// is done if `SprintGoalQuery` is empty or the store can't evaluate
+// the goals.
+//
+// A sprint whose issues can't be searched is skipped, to be
+// evaluated again by the next sync.
+func PerformSprintGoalsEvaluation(c Client, s store.Store, all bool) error {
+	if SprintGoalQuery == "" {
+		return nil
+	}
+	gs, ok := s.(SprintGoalStore)
+	if !ok {
+		return nil
+	}
+	sprints, err := gs.GetSprintsToEvaluate(all)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, sp := range sprints {
+		keys, err := searchAllKeys(unfiltered(c), fmt.Sprintf("sprint = %d AND (%s)", sp.ID, SprintGoalQuery))
+		if err != nil {
+			logging.Warnf("Could not search the goal issues of sprint `%s`: %s", sp.Name, err)
+			continue
+		}
+		if err = gs.ReplaceSprintGoalResult(sp, keys); err != nil {
+			return err
+		}
+		count++
+	}
+	if count > 0 {
+		logging.Infof("Evaluated the goals of %d sprints", count)
+	}
+	return nil
+}
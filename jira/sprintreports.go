@@ -0,0 +1,70 @@
+package jira
+
+import (
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// SprintReportsFetcher is implemented by clients able to fetch the
+// sprint reports of Jira Agile (e.g. `APIClient`).
+type SprintReportsFetcher interface {
+	GetSprintReport(boardID, sprintID int) (*client.SprintReport, error)
+}
+
+// SprintReportStore is implemented by stores recording the sprint
+// reports (e.g. `store.PGStore`).
+type SprintReportStore interface {
+	GetClosedSprints(unreportedOnly bool) ([]store.Sprint, error)
+	ReplaceSprintReport(r store.SprintReport) error
+}
+
+// PerformSprintReportsSync fetches the sprint reports of the closed
+// sprints which have none stored, or of all the closed sprints if
+// `all` (e.g. after fixing the records of their issues), and stores
+// them to be compared with the records (see
+// `store.SprintReconciliation`). Nothing is done if the client can't
+// fetch them or the store can't record them.
+//
+// A report which can't be fetched (e.g. the board of the sprint was
+// deleted) is skipped, to be fetched again by the next sync.
+func PerformSprintReportsSync(c Client, s store.Store, all bool) error {
+	rf, ok := unfiltered(c).(SprintReportsFetcher)
+	if !ok {
+		return nil
+	}
+	rs, ok := s.(SprintReportStore)
+	if !ok {
+		return nil
+	}
+	sprints, err := rs.GetClosedSprints(!all)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, sp := range sprints {
+		r, err := rf.GetSprintReport(sp.BoardID, sp.ID)
+		if err != nil {
+			logging.Warnf("Could not fetch the report of sprint `%s`: %s", sp.Name, err)
+			continue
+		}
+		err = rs.ReplaceSprintReport(store.SprintReport{
+			SprintID:           sp.ID,
+			BoardID:            sp.BoardID,
+			CompletedIssues:    r.CompletedIssues,
+			NotCompletedIssues: r.NotCompletedIssues,
+			PuntedIssues:       r.PuntedIssues,
+			AddedIssues:        r.AddedIssues,
+			CompletedPoints:    r.CompletedPoints,
+			NotCompletedPoints: r.NotCompletedPoints,
+		})
+		if err != nil {
+			return err
+		}
+		count++
+	}
+	if count > 0 {
+		logging.Infof("Synced the reports of %d sprints", count)
+	}
+	return nil
+}
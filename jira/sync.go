@@ -1,14 +1,18 @@
 package jira
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Jeffail/tunny"
 
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
 	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/telemetry"
 )
 
 // PerformIncrementalSync fetches only newly updated issues and performs
@@ -22,54 +26,64 @@ import (
 // - For each updated issue, the records already in the store are
 //   dropped (e.g. the issue's state and events) so they can be
 //   recreated.
-func PerformIncrementalSync(c Client, store store.Store, poolSize int, m Mapper) {
+// - If the store records the syncs (see `SyncRunStore`), the lower
+//   bound is the start of the last successful sync instead.
+// - If the client detects a clock skew between Jira and the local
+//   clock (see `ClockSkewer`), the query's lower bound is moved back
+//   by the skew.
+// - The boards and sprints are then replaced (see `BoardsFetcher`),
+//   sprints changing independently from the issues, and the assignee
+//   intervals refreshed (see `AssigneeIntervalsStore`).
+// - The consumption of Jira API is logged and recorded with the sync
+//   run if the client measures it (see `APIUsageReporter`).
+// - If the search fails, some issues can't be fetched or stored (see
+//   `FailedIssuesError`), or the sync is interrupted by `ctx` (see
+//   `syncIssues`), the sync is not recorded as successful, so the
+//   next one restarts from the same point.
+//
+// Returns the error which failed the sync, nil if it succeeded or
+// was only interrupted (see `logSyncError`).
+func PerformIncrementalSync(ctx context.Context, c Client, store store.Store, poolSize int, m Mapper) error {
 	beforeSync := time.Now()
-	log.Printf("Incremental sync starting\n")
-
-	// Using a chan of issue keys and a wait group for synchronization
-	issueKeys := make(chan string, 100)
-
-	// Using a WaitGroup to synchronize issue fetches and wait
-	// until all are done (even if all searches have been done)
-	var wg sync.WaitGroup
+	logging.Infof("Incremental sync starting")
+	id, finish := startSyncRun(c, store, SyncKindIncremental, beforeSync)
+	usage := trackAPIUsage(c, store, id)
 
-	// Initialize a pool of workers to fetch and process issues.
-	// The pool's function fetch the issue specified by `key` and processes
-	// it.
-	p := tunny.NewFunc(poolSize, func(key interface{}) interface{} {
-		defer wg.Done()
-
-		i := c.GetIssue(key.(string))
-		store.ReplaceIssueStateAndEvents(key.(string), m.IssueStateFromIssue(i), m.IssueEventsFromIssue(i))
-		return nil
-	})
-	defer p.Close()
-
-	// Start a routine to retrieve fetched issue keys from the `issueKeys`
-	// chan and run a pool job for each of them.
-	go func() {
-		for issueKey := range issueKeys {
-			wg.Add(1)
-			go p.Process(issueKey)
+	restartFromUpdatedAt := lastSyncStart(store)
+	if restartFromUpdatedAt == nil {
+		var err error
+		if restartFromUpdatedAt, err = store.GetRestartFromUpdatedAt(poolSize * 3); err != nil {
+			logging.Errorf("Sync failed, could not get the last update: %s", err)
+			return err
 		}
-		wg.Done() // Done when all `issueKeys` have been sent for processing
-	}()
-
-	// Search issues (fetch issue keys)
-	restartFromUpdatedAt := store.GetRestartFromUpdatedAt(poolSize * 3)
-	q := fmt.Sprintf("updated > '%d/%d/%d %d:%d' ORDER BY updated ASC",
+	}
+	if skew := clockSkew(c); skew > 0 {
+		// Widen the window so issues are not missed because of the
+		// skew
+		widened := restartFromUpdatedAt.Add(-skew)
+		restartFromUpdatedAt = &widened
+	}
+	q := fmt.Sprintf("updated >= '%d/%d/%d %d:%d' ORDER BY updated ASC",
 		restartFromUpdatedAt.Year(),
 		restartFromUpdatedAt.Month(),
 		restartFromUpdatedAt.Day(),
 		restartFromUpdatedAt.Hour(),
 		restartFromUpdatedAt.Minute())
-	c.SearchIssues(q, issueKeys)
-	wg.Add(1) // Adding a job to wait for the processing of `issueKeys`
+	count, err := syncSearchedIssues(ctx, c, poolSize, m, q, store.ReplaceIssueStateAndEvents, nil)
+	if err != nil {
+		usage(count)
+		return logSyncError(ctx, count, err, "")
+	}
+	finish(count)
+	syncBoards(c, store)
+	syncWorkflows(c, store)
+	syncUsers(c, store)
+	refreshAssigneeIntervals(store)
+	extendWIPAging(store)
+	usage(count)
 
-	// Wait until all fetches are done
-	wg.Wait()
-
-	log.Printf("Sync done in %f minutes\n", time.Since(beforeSync).Minutes())
+	logging.Infof("Sync done in %f minutes", time.Since(beforeSync).Minutes())
+	return nil
 }
 
 // PerformSync fetches issue identifiers from the attached Jira instance
@@ -78,10 +92,137 @@ func PerformIncrementalSync(c Client, store store.Store, poolSize int, m Mapper)
 //
 // Each fetched issue is then processed to generate `IssueState` and
 // `IssueEvent` records that are stored in the application's store.
-func PerformSync(c Client, store store.Store, poolSize int, m Mapper) {
+// The boards and sprints are then replaced (see `BoardsFetcher`), as
+// well as the workflows of the projects (see `WorkflowsFetcher`), the
+// issues of the store which were not found are marked as deleted
+// (see `DeletionStore`) and the assignee intervals are refreshed (see
+// `AssigneeIntervalsStore`).
+//
+// If the store can resume syncs (see `ResumableStore`), the issues
+// stored are recorded along the way, so the sync can be resumed with
+// `ResumeSync` if it doesn't finish, e.g. if it's interrupted by
+// `ctx` (see `syncIssues`) or some issues can't be fetched or stored
+// (see `FailedIssuesError`), which are retried by the resumed sync.
+//
+// Returns the error which failed the sync, as
+// `PerformIncrementalSync`.
+func PerformSync(ctx context.Context, c Client, store store.Store, poolSize int, m Mapper) error {
+	beforeSync := time.Now()
+	logging.Infof("Sync starting")
+	id, finish := startSyncRun(c, store, SyncKindFull, beforeSync)
+	err := fullSync(ctx, c, store, poolSize, m, id, nil, finish)
+	logging.Infof("Sync done in %f minutes", time.Since(beforeSync).Minutes())
+	return err
+}
+
+// ResumeSync resumes the last full sync which didn't finish (e.g.
+// the process crashed, or Jira was unreachable for longer than the
+// client retries), skipping the issues it already stored. Performs a
+// new full sync if there is none, or if the store can't resume syncs
+// (see `ResumableStore`).
+func ResumeSync(ctx context.Context, c Client, store store.Store, poolSize int, m Mapper) error {
+	id, synced := resumableSyncRun(c, store, SyncKindFull)
+	if id == 0 {
+		return PerformSync(ctx, c, store, poolSize, m)
+	}
 	beforeSync := time.Now()
-	log.Printf("Sync starting\n")
+	logging.Infof("Resuming sync %d (%d issues already synced)", id, len(synced))
+	err := fullSync(ctx, c, store, poolSize, m, id, synced, func(issuesCount int) {
+		finishSyncRun(store, id, issuesCount+len(synced))
+	})
+	logging.Infof("Sync done in %f minutes", time.Since(beforeSync).Minutes())
+	return err
+}
+
+// fullSync syncs all the issues but the `synced` ones, in batches,
+// recording the progress of the sync run `runID` if not 0. `finish`
+// is called with the number of synced issues if the sync succeeds,
+// i.e. if all the issues were fetched and stored, including the
+// last batch.
+func fullSync(ctx context.Context, c Client, s store.Store, poolSize int, m Mapper, runID int64, synced map[string]bool, finish func(issuesCount int)) error {
+	started := time.Now()
+	usage := trackAPIUsage(c, s, runID)
+	write, flush := batchWriter(s, runID)
+	var found searchedKeys
+	count, err := syncIssues(ctx, c, poolSize, m, found.tee(c, "ORDER BY updated ASC"), write, synced)
+	if flushErr := flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		usage(count)
+		return logSyncError(ctx, count, err, ", resume it with `sync --full --resume`")
+	}
+	finish(count)
+	markDeletedIssues(c, s, found.keys, started)
+	syncBoards(c, s)
+	syncWorkflows(c, s)
+	syncUsers(c, s)
+	refreshAssigneeIntervals(s)
+	extendWIPAging(s)
+	usage(count)
+	return nil
+}
+
+// PerformReconciliationSync synchronizes the issues updated during
+// the last `window`, whatever the issues already in the store.
+//
+// It's meant to be run periodically when issues are synchronized by
+// webhooks, to fix updates missed because a webhook delivery failed.
+// Unlike `PerformIncrementalSync`, it doesn't rely on the last
+// update in the store, which the webhooks keep up to date even if
+// some deliveries are missed.
+//
+// Returns the error which failed the sync, as
+// `PerformIncrementalSync`.
+func PerformReconciliationSync(ctx context.Context, c Client, store store.Store, poolSize int, m Mapper, window time.Duration) error {
+	beforeSync := time.Now()
+	logging.Infof("Reconciliation sync starting (issues updated in the last %s)", window)
+	id, finish := startSyncRun(c, store, SyncKindReconciliation, beforeSync)
+	usage := trackAPIUsage(c, store, id)
+
+	window += clockSkew(c)
+	minutes := int(window.Minutes())
+	if minutes < 1 {
+		minutes = 1
+	}
+	q := fmt.Sprintf("updated >= '-%dm' ORDER BY updated ASC", minutes)
+	count, err := syncSearchedIssues(ctx, c, poolSize, m, q, store.ReplaceIssueStateAndEvents, nil)
+	if err != nil {
+		usage(count)
+		return logSyncError(ctx, count, err, "")
+	}
+	finish(count)
+	refreshAssigneeIntervals(store)
+	extendWIPAging(store)
+	usage(count)
 
+	logging.Infof("Sync done in %f minutes", time.Since(beforeSync).Minutes())
+	return nil
+}
+
+// syncSearchedIssues searches the issues matching the JQL query and
+// fetches, maps and stores each of them with `write`, using a pool
+// of `poolSize` workers. The `skipped` issues are not fetched, and
+// the issues which can't be fetched or stored are skipped with an
+// error logged. Returns the number of issues found (but the skipped
+// ones), and the error of the search if it failed, or a
+// `FailedIssuesError` if some issues were skipped.
+func syncSearchedIssues(ctx context.Context, c Client, poolSize int, m Mapper, query string, write writeFunc, skipped map[string]bool) (int, error) {
+	return syncIssues(ctx, c, poolSize, m, func(issueKeys chan string) error {
+		return c.SearchIssues(query, issueKeys)
+	}, write, skipped)
+}
+
+// syncIssues is `syncSearchedIssues` for the issues whose keys are
+// sent by `send`, which must close `issueKeys` when done.
+//
+// Once `ctx` is done (e.g. the process is asked to shut down), the
+// issues sent are not fetched anymore and those being fetched are
+// skipped, but the issues already fetched are still written, so the
+// batches can be flushed and the progress of the sync recorded. The
+// context's error is returned then. The issues abandoned by the
+// watchdog are skipped like those which can't be fetched.
+func syncIssues(ctx context.Context, c Client, poolSize int, m Mapper, send func(issueKeys chan string) error, write writeFunc, skipped map[string]bool) (int, error) {
 	// Using a chan of issue keys and a wait group for synchronization
 	issueKeys := make(chan string, 100)
 
@@ -89,41 +230,273 @@ func PerformSync(c Client, store store.Store, poolSize int, m Mapper) {
 	// until all are done (even if all searches have been done)
 	var wg sync.WaitGroup
 
-	// Initialize a pool of workers to fetch issues
+	// Initialize a pool of workers to fetch and process issues.
+	// The pool's function fetch the issue specified by `key` and processes
+	// it.
+	prog := startProgress("Sync")
+	defer prog.stop()
+	var failed failedKeys
+	//
+	// The issue is processed in its own goroutine, so the worker can
+	// move on if the watchdog abandons it (see
+	// `RestartStalledWorkers`).
 	p := tunny.NewFunc(poolSize, func(key interface{}) interface{} {
 		defer wg.Done()
+		defer telemetry.Recover()
 
-		i := c.GetIssue(key.(string))
-		store.ReplaceIssueStateAndEvents(key.(string), m.IssueStateFromIssue(i), m.IssueEventsFromIssue(i))
+		if ctx.Err() != nil {
+			return nil
+		}
+		k := key.(string)
+		abandoned := prog.begin(k)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer telemetry.Recover()
+			if !processIssue(ctx, c, m, k, write, prog, abandoned) {
+				failed.add(k)
+			}
+		}()
+		select {
+		case <-done:
+			prog.end(k)
+		case <-abandoned:
+			failed.add(k)
+		}
 		return nil
 	})
 	defer p.Close()
 
+	// Start a routine to retrieve fetched issue keys from the `issueKeys`
+	// chan and run a pool job for each of them.
+	count := 0
 	go func() {
 		for issueKey := range issueKeys {
+			if skipped[issueKey] || ctx.Err() != nil {
+				continue
+			}
 			wg.Add(1)
+			count++
+			prog.discover()
 			go p.Process(issueKey)
 		}
 		wg.Done() // Done when all `issueKeys` have been sent for processing
 	}()
 
-	c.SearchIssues("ORDER BY updated ASC", issueKeys)
 	wg.Add(1) // Adding a job to wait for the processing of `issueKeys`
+	err := send(issueKeys)
+	prog.searchDone()
 
 	// Wait until all fetches are done
 	wg.Wait()
+	if ctx.Err() != nil {
+		return count, ctx.Err()
+	}
+	if err != nil {
+		return count, err
+	}
+	return count, failed.err()
+}
+
+// processIssue fetches, maps and writes the issue for `syncIssues`,
+// unless it's `abandoned` by the watchdog once fetched. Returns false
+// if the issue could not be fetched or stored, true otherwise, e.g.
+// if the fetch was interrupted by `ctx`.
+func processIssue(ctx context.Context, c Client, m Mapper, key string, write writeFunc, prog *progress, abandoned <-chan struct{}) bool {
+	i, err := getIssue(c, key)
+	if (err != nil && ctx.Err() != nil) || isAbandoned(abandoned) {
+		return true
+	}
+	if err != nil {
+		prog.fail()
+		logging.WithFields(logging.Fields{"issue_key": key}).Errorf("Error fetching issue `%s`, skipped: %s", key, err)
+		return false
+	}
+	prog.fetch()
+	is := m.IssueStateFromIssue(i)
+	if is.ChangelogTruncated {
+		logging.WithFields(logging.Fields{"issue_key": key}).Warnf("The changelog of issue `%s` seems truncated, run `backfill changelogs` to fetch it again", key)
+	}
+	err = write(key, is, m.IssueEventsFromIssue(i))
+	prog.stored(err)
+	logStoreError(key, err)
+	return err == nil
+}
+
+// FailedIssuesError is the error of a sync which skipped some issues
+// because they could not be fetched or stored. The sync is not
+// recorded as successful, so the issues are synced again by the next
+// incremental sync, or by the resumed full sync.
+type FailedIssuesError struct {
+	Keys []string
+}
+
+// maxFailedKeys is the max number of keys listed by the message of a
+// `FailedIssuesError`.
+const maxFailedKeys = 10
+
+func (e *FailedIssuesError) Error() string {
+	keys := e.Keys
+	if len(keys) > maxFailedKeys {
+		keys = append(keys[:maxFailedKeys:maxFailedKeys], "...")
+	}
+	return fmt.Sprintf("%d issues could not be synced (%s)", len(e.Keys), strings.Join(keys, ", "))
+}
+
+// failedKeys collects the keys of the issues skipped by `syncIssues`.
+type failedKeys struct {
+	mutex sync.Mutex
+	keys  []string
+}
 
-	log.Printf("Sync done in %f minutes\n", time.Since(beforeSync).Minutes())
+func (f *failedKeys) add(k string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.keys = append(f.keys, k)
+}
+
+// err returns a `FailedIssuesError` listing the keys, sorted, nil if
+// there is none.
+func (f *failedKeys) err() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.keys) == 0 {
+		return nil
+	}
+	keys := append([]string(nil), f.keys...)
+	sort.Strings(keys)
+	return &FailedIssuesError{Keys: keys}
+}
+
+// logSyncError logs the error which stopped the sync after `count`
+// issues, `hint` telling how to continue it, and returns it. An
+// interruption by `ctx`, its deadline (a time-boxed sync) or the
+// limit of a limited client (see `NewLimitedClient`) is not an
+// error: nil is returned then.
+func logSyncError(ctx context.Context, count int, err error, hint string) error {
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		logging.Infof("Sync stopped after %d issues at the end of its time budget%s", count, hint)
+	case ctx.Err() != nil:
+		logging.Warnf("Sync interrupted after %d issues%s", count, hint)
+	case err == errLimitReached:
+		logging.Infof("Sync stopped after %d issues, the limit being reached", count)
+	default:
+		logging.Errorf("Sync failed after %d issues%s: %s", count, hint, err)
+		return err
+	}
+	return nil
+}
+
+// writeFunc writes the records of an issue, e.g.
+// `store.Store.ReplaceIssueStateAndEvents`.
+type writeFunc func(k string, is store.IssueState, ies []store.IssueEvent) error
+
+// BatchStore is implemented by stores able to write issues in
+// batches (e.g. `store.PGStore`, see `store.Writer`), which is much
+// faster for full syncs.
+type BatchStore interface {
+	NewWriter() *store.Writer
+}
+
+// flushAttempts is the number of attempts to write the last batch
+// of a full sync.
+const flushAttempts = 3
+
+// batchWriter returns the function writing the issues of a full
+// sync, in batches if the store is a `BatchStore`, and the function
+// writing the last batch. A batch which fails is kept and written
+// again with the next one (see `store.BatchError`), so its issues
+// are not failed, and the last batch is retried if it fails, its
+// error being returned by `flush` after the last attempt. The
+// written issues are recorded as synced by the run `runID` if not 0.
+func batchWriter(s store.Store, runID int64) (write writeFunc, flush func() error) {
+	bs, ok := s.(BatchStore)
+	if !ok {
+		return s.ReplaceIssueStateAndEvents, func() error { return nil }
+	}
+	w := bs.NewWriter()
+	if runID != 0 {
+		w.SetSyncRun(runID)
+	}
+	write = func(k string, is store.IssueState, ies []store.IssueEvent) error {
+		err := w.Add(k, is, ies)
+		if store.IsBatchError(err) {
+			logging.Warnf("%s, kept for the next batch", err)
+			return nil
+		}
+		return err
+	}
+	return write, func() error {
+		for attempt := 1; ; attempt++ {
+			err := w.Flush()
+			if err == nil {
+				return nil
+			}
+			if attempt == flushAttempts {
+				return fmt.Errorf("error storing the last %d issues: %s", w.Pending(), err)
+			}
+			logging.Warnf("Error storing the last %d issues, retrying: %s", w.Pending(), err)
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+	}
 }
 
 // PerformSyncForIssueKey is the same as `PerformSync` but for a single
-// issue specified by its key.
-func PerformSyncForIssueKey(c Client, store store.Store, issueKey string, m Mapper) {
+// issue specified by its key. Returns the error if the issue could
+// not be fetched or stored.
+func PerformSyncForIssueKey(c Client, store store.Store, issueKey string, m Mapper) error {
 	beforeSync := time.Now()
-	log.Printf("Sync for issue `%s` starting\n", issueKey)
+	logging.Infof("Sync for issue `%s` starting", issueKey)
+
+	i, err := getIssue(c, issueKey)
+	if err != nil {
+		logging.Errorf("Error fetching issue `%s`: %s", issueKey, err)
+		return err
+	}
+	err = store.ReplaceIssueStateAndEvents(issueKey, m.IssueStateFromIssue(i), m.IssueEventsFromIssue(i))
+	logStoreError(issueKey, err)
+	if err != nil {
+		return err
+	}
 
-	i := c.GetIssue(issueKey)
-	store.ReplaceIssueStateAndEvents(issueKey, m.IssueStateFromIssue(i), m.IssueEventsFromIssue(i))
+	logging.Infof("Sync done in %f minutes", time.Since(beforeSync).Minutes())
+	return nil
+}
+
+// PerformSyncForIssueKeys is the same as `PerformSyncForIssueKey`
+// for several issues, fetched using a pool of `poolSize` workers.
+// It's meant to re-import issues selected from the store, e.g. after
+// fixing the mapping of a field. Returns a `FailedIssuesError` if
+// some issues could not be fetched or stored.
+func PerformSyncForIssueKeys(ctx context.Context, c Client, store store.Store, issueKeys []string, poolSize int, m Mapper) error {
+	beforeSync := time.Now()
+	logging.Infof("Sync for %d issues starting", len(issueKeys))
+
+	count, err := syncIssues(ctx, c, poolSize, m, func(ch chan string) error {
+		for _, k := range issueKeys {
+			ch <- k
+		}
+		close(ch)
+		return nil
+	}, store.ReplaceIssueStateAndEvents, nil)
+	if err != nil {
+		return logSyncError(ctx, count, err, "")
+	}
+
+	logging.Infof("Sync of %d issues done in %f minutes", count, time.Since(beforeSync).Minutes())
+	return nil
+}
 
-	log.Printf("Sync done in %f minutes\n", time.Since(beforeSync).Minutes())
+// logStoreError logs the error returned when storing the issue, if
+// any. Timeouts are reported distinctly since they are usually caused
+// by a lock held on the tables, not by the issue itself.
+func logStoreError(issueKey string, err error) {
+	switch {
+	case err == nil:
+	case store.IsTimeout(err):
+		logging.WithFields(logging.Fields{"issue_key": issueKey}).Errorf("Timeout storing issue `%s`, skipped: %s", issueKey, err)
+	default:
+		logging.WithFields(logging.Fields{"issue_key": issueKey}).Errorf("Error storing issue `%s`: %s", issueKey, err)
+	}
 }
@@ -138,7 +138,7 @@ func (m *MockStore) ReplaceIssueStateAndEvents(ik string, is store.IssueState, i
 // expectation.
 //
 // To position an expectation, use `ExpectGetRestartFromUpdatedAt(..)`
-func (m *MockStore) GetRestartFromUpdatedAt(n int) *time.Time {
+func (m *MockStore) GetRestartFromUpdatedAt(n int) (*time.Time, error) {
 	e := m.popExpectation()
 	if e == nil {
 		m.Errorf("mock received `GetRestartFromUpdatedAt` but no expectation was set")
@@ -148,7 +148,7 @@ func (m *MockStore) GetRestartFromUpdatedAt(n int) *time.Time {
 		m.Errorf("mock received `GetRestartFromUpdatedAt` but was expecting `%s`\n", e.Describe())
 	}
 	// Implement the necessary mocking
-	return &ee.t
+	return &ee.t, nil
 }
 
 // ExpectGetRestartFromUpdatedAt sets an expectation on the
@@ -160,11 +160,13 @@ func (m *MockStore) ExpectGetRestartFromUpdatedAt() *ExpectedGetRestartFromUpdat
 }
 
 // CreateTables does nothing
-func (m *MockStore) CreateTables() {
+func (m *MockStore) CreateTables() error {
+	return nil
 }
 
 // DropTables does nothing
-func (m *MockStore) DropTables() {
+func (m *MockStore) DropTables() error {
+	return nil
 }
 
 // ============
@@ -1,7 +1,16 @@
 package jira_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -9,6 +18,7 @@ import (
 
 	"github.com/rchampourlier/kaizenizer-source-jira/jira"
 	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
 	"github.com/rchampourlier/kaizenizer-source-jira/store"
 )
 
@@ -36,7 +46,7 @@ func TestPerformIncrementalSync(t *testing.T) {
 
 	// Perform a search with `updated > 'max issue_updated_at'`
 	expectedJiraQuery := fmt.Sprintf(
-		"updated > '%d.*%d.*%d.*%d.*%d.*' ORDER BY updated ASC",
+		"updated >= '%d.*%d.*%d.*%d.*%d.*' ORDER BY updated ASC",
 		refTime.Year(),
 		refTime.Month(),
 		refTime.Day(),
@@ -59,7 +69,123 @@ func TestPerformIncrementalSync(t *testing.T) {
 			WillReturnError(nil)
 	}
 
-	jira.PerformIncrementalSync(c, s, 10, &mapperMock{})
+	jira.PerformIncrementalSync(context.Background(), c, s, 10, &mapperMock{})
+}
+
+// syncRunMockStore is a `MockStore` recording the syncs.
+type syncRunMockStore struct {
+	*MockStore
+	lastSyncStart *time.Time
+	started       []string
+	filters       []string
+	finished      []int
+}
+
+func (s *syncRunMockStore) StartSyncRun(kind string, startedAt time.Time, filter string) (int64, error) {
+	s.started = append(s.started, kind)
+	s.filters = append(s.filters, filter)
+	return int64(len(s.started)), nil
+}
+
+func (s *syncRunMockStore) FinishSyncRun(id int64, finishedAt time.Time, issuesCount int) error {
+	s.finished = append(s.finished, issuesCount)
+	return nil
+}
+
+func (s *syncRunMockStore) GetLastSyncStart(kinds []string) (*time.Time, error) {
+	return s.lastSyncStart, nil
+}
+
+func TestPerformIncrementalSync_FromLastSyncRun(t *testing.T) {
+	lastSync := time.Date(2020, 3, 2, 10, 5, 0, 0, time.Local)
+	c := client.NewMockClient(t)
+	s := &syncRunMockStore{MockStore: NewMockStore(t), lastSyncStart: &lastSync}
+
+	// No `GetRestartFromUpdatedAt` expected, the search starts from
+	// the last successful sync
+	c.ExpectSearchIssues("updated >= '2020/3/2 10:5' ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1"})
+	c.ExpectGetIssue("PJ-1").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-1").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WillReturnError(nil)
+
+	jira.PerformIncrementalSync(context.Background(), c, s, 10, &mapperMock{})
+
+	if len(s.started) != 1 || s.started[0] != jira.SyncKindIncremental {
+		t.Errorf("expected an incremental sync run to be started, got %v", s.started)
+	}
+	if len(s.finished) != 1 || s.finished[0] != 1 {
+		t.Errorf("expected the sync run to be finished with 1 issue, got %v", s.finished)
+	}
+}
+
+// usageMockClient is a `MockClient` reporting its consumption of
+// Jira API (see `jira.APIUsageReporter`), one more call to each
+// endpoint being reported each time.
+type usageMockClient struct {
+	*client.MockClient
+	reported int
+}
+
+func (c *usageMockClient) APIUsage() client.Usage {
+	c.reported++
+	return client.Usage{
+		Calls: map[string]int{
+			"GET /rest/api/2/search":      c.reported,
+			"GET /rest/api/2/issue/{key}": c.reported * 3,
+		},
+		Limit: 100,
+	}
+}
+
+// usageMockStore is a `syncRunMockStore` recording the consumption
+// of Jira API (see `jira.APIUsageStore`).
+type usageMockStore struct {
+	*syncRunMockStore
+	usages map[int64]store.APIUsage
+}
+
+func (s *usageMockStore) RecordSyncRunAPIUsage(id int64, u store.APIUsage) error {
+	s.usages[id] = u
+	return nil
+}
+
+func TestPerformIncrementalSync_APIUsage(t *testing.T) {
+	lastSync := time.Date(2020, 3, 2, 10, 5, 0, 0, time.Local)
+	c := &usageMockClient{MockClient: client.NewMockClient(t)}
+	s := &usageMockStore{
+		syncRunMockStore: &syncRunMockStore{MockStore: NewMockStore(t), lastSyncStart: &lastSync},
+		usages:           make(map[int64]store.APIUsage),
+	}
+	c.ExpectSearchIssues("updated >= '2020/3/2 10:5' ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2"})
+	for _, k := range []string{"PJ-1", "PJ-2"} {
+		c.ExpectGetIssue(k).WillRespondWithIssue(&extJira.Issue{})
+		s.ExpectReplaceIssueStateAndEvents().
+			WithIssueKey(k).
+			WithIssueState(&store.IssueState{}).
+			WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+			WillReturnError(nil)
+	}
+
+	jira.PerformIncrementalSync(context.Background(), c, s, 10, &mapperMock{})
+
+	// The usage since the start of the sync is recorded
+	u, ok := s.usages[1]
+	if !ok {
+		t.Fatalf("expected the consumption of Jira API to be recorded for run 1, got %v", s.usages)
+	}
+	expected := map[string]int{"GET /rest/api/2/search": 1, "GET /rest/api/2/issue/{key}": 3}
+	if u.Calls != 4 || !reflect.DeepEqual(u.CallsByEndpoint, expected) {
+		t.Errorf("expected 4 calls (%v), got %d (%v)", expected, u.Calls, u.CallsByEndpoint)
+	}
+	if u.CallsPerIssue == nil || *u.CallsPerIssue != 2 {
+		t.Errorf("expected 2 calls per issue, got %v", u.CallsPerIssue)
+	}
+	if u.QuotaUsed == nil || *u.QuotaUsed != 0.04 {
+		t.Errorf("expected 4%% of the quota to be used, got %v", u.QuotaUsed)
+	}
 }
 
 func TestPerformSync(t *testing.T) {
@@ -85,7 +211,295 @@ func TestPerformSync(t *testing.T) {
 			WillReturnError(nil)
 	}
 
-	jira.PerformSync(c, s, 10, &mapperMock{})
+	jira.PerformSync(context.Background(), c, s, 10, &mapperMock{})
+	c.AssertExpectationsMet(t)
+}
+
+// concurrentMockClient is a `MockClient` recording the maximum
+// number of issues fetched at the same time.
+type concurrentMockClient struct {
+	*client.MockClient
+	mutex    sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (c *concurrentMockClient) GetIssue(issueKey string) (*extJira.Issue, error) {
+	c.mutex.Lock()
+	c.inFlight++
+	if c.inFlight > c.max {
+		c.max = c.inFlight
+	}
+	c.mutex.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	c.mutex.Lock()
+	c.inFlight--
+	c.mutex.Unlock()
+	return c.MockClient.GetIssue(issueKey)
+}
+
+func TestPerformSync_Concurrency(t *testing.T) {
+	var issueKeys []string
+	for i := 1; i <= 12; i++ {
+		issueKeys = append(issueKeys, fmt.Sprintf("PJ-%d", i))
+	}
+
+	c := &concurrentMockClient{MockClient: client.NewMockClient(t)}
+	s := NewMockStore(t)
+
+	// The issues are fetched and stored in any order
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys(issueKeys)
+	for i := len(issueKeys) - 1; i >= 0; i-- {
+		c.ExpectGetIssue(issueKeys[i]).WillRespondWithIssue(&extJira.Issue{})
+		s.ExpectReplaceIssueStateAndEvents().
+			WithIssueKey(issueKeys[i]).
+			WithIssueState(&store.IssueState{}).
+			WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+			WillReturnError(nil)
+	}
+
+	jira.PerformSync(context.Background(), c, s, 4, &mapperMock{})
+
+	if c.max != 4 {
+		t.Errorf("expected 4 issues to be fetched concurrently, got %d", c.max)
+	}
+}
+
+func TestPerformSync_GetIssueError(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := &syncRunMockStore{MockStore: NewMockStore(t)}
+
+	// The issue which can't be fetched is skipped, the others are
+	// stored, and the sync fails so the issue is synced again by the
+	// next one
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2"})
+	c.ExpectGetIssue("PJ-1").WillRespondWithError(errors.New("unreachable"))
+	c.ExpectGetIssue("PJ-2").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-2").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WillReturnError(nil)
+
+	err := jira.PerformSync(context.Background(), c, s, 10, &mapperMock{})
+
+	if failed, ok := err.(*jira.FailedIssuesError); !ok || !reflect.DeepEqual(failed.Keys, []string{"PJ-1"}) {
+		t.Errorf("expected the sync to fail for PJ-1, got %v", err)
+	}
+	if len(s.started) != 1 || len(s.finished) != 0 {
+		t.Errorf("expected the failed sync run to be left unfinished, got started %v, finished %v", s.started, s.finished)
+	}
+}
+
+func TestPerformIncrementalSync_StoreError(t *testing.T) {
+	lastSync := time.Date(2020, 3, 2, 10, 5, 0, 0, time.Local)
+	c := client.NewMockClient(t)
+	s := &syncRunMockStore{MockStore: NewMockStore(t), lastSyncStart: &lastSync}
+
+	c.ExpectSearchIssues("updated >= '2020/3/2 10:5' ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1"})
+	c.ExpectGetIssue("PJ-1").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-1").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WillReturnError(errors.New("connection refused"))
+
+	err := jira.PerformIncrementalSync(context.Background(), c, s, 10, &mapperMock{})
+
+	if _, ok := err.(*jira.FailedIssuesError); !ok {
+		t.Errorf("expected the sync to fail, got %v", err)
+	}
+	if len(s.finished) != 0 {
+		t.Errorf("expected the failed sync run to be left unfinished, got finished %v", s.finished)
+	}
+}
+
+func TestPerformSync_GetIssueFailures(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := NewMockStore(t)
+
+	// Issues failing after the client gave up retrying or timing out
+	// are skipped, the others are stored
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2", "PJ-3"})
+	c.ExpectGetIssue("PJ-1").WillFailWith(errors.New("429 Too Many Requests"))
+	c.ExpectGetIssue("PJ-2").WillTimeoutAfter(10 * time.Millisecond)
+	c.ExpectGetIssue("PJ-3").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-3").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WillReturnError(nil)
+
+	jira.PerformSync(context.Background(), c, s, 10, &mapperMock{})
+}
+
+func TestPerformSync_Progress(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := NewMockStore(t)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		logging.Configure(logging.LevelInfo, logging.FormatText)
+	}()
+	logging.Configure(logging.LevelInfo, logging.FormatJSON)
+
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2"})
+	c.ExpectGetIssue("PJ-1").WillRespondWithError(errors.New("unreachable"))
+	c.ExpectGetIssue("PJ-2").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-2").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WillReturnError(nil)
+
+	jira.PerformSync(context.Background(), c, s, 10, &mapperMock{})
+
+	// The final counts are logged when the sync is done
+	var last map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var l map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &l); err != nil {
+			t.Fatalf("expected JSON log lines, got %q", line)
+		}
+		if l["msg"] == "Sync progress" {
+			last = l
+		}
+	}
+	if last == nil {
+		t.Fatalf("expected the progress to be logged, got:\n%s", logs.String())
+	}
+	for k, expected := range map[string]interface{}{"discovered": 2.0, "fetched": 1.0, "inserted": 1.0, "errors": 1.0, "searching": false, "eta": "0s"} {
+		if last[k] != expected {
+			t.Errorf("expected `%s` to be %v, got %v", k, expected, last[k])
+		}
+	}
+}
+
+func TestPerformSync_SearchTimeout(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := &syncRunMockStore{MockStore: NewMockStore(t)}
+
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillTimeoutAfter(10 * time.Millisecond)
+
+	jira.PerformSync(context.Background(), c, s, 10, &mapperMock{})
+
+	if len(s.started) != 1 || len(s.finished) != 0 {
+		t.Errorf("expected the timed out sync run to be left unfinished, got started %v, finished %v", s.started, s.finished)
+	}
+}
+
+func TestPerformSync_Cancelled(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := &syncRunMockStore{MockStore: NewMockStore(t)}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The issues found are not fetched once the context is done
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2"})
+
+	jira.PerformSync(ctx, c, s, 10, &mapperMock{})
+
+	if len(s.started) != 1 || len(s.finished) != 0 {
+		t.Errorf("expected the interrupted sync run to be left unfinished, got started %v, finished %v", s.started, s.finished)
+	}
+}
+
+func TestPerformSync_MaxDuration(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := &syncRunMockStore{MockStore: NewMockStore(t)}
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1"})
+
+	jira.PerformSync(ctx, c, s, 10, &mapperMock{})
+
+	// The end of the budget is not an error, the sync is resumed
+	if !strings.Contains(logs.String(), "at the end of its time budget") || strings.Contains(logs.String(), "ERROR") {
+		t.Errorf("expected the sync to stop at the end of its budget without error, got:\n%s", logs.String())
+	}
+	if len(s.started) != 1 || len(s.finished) != 0 {
+		t.Errorf("expected the time-boxed sync run to be left unfinished, got started %v, finished %v", s.started, s.finished)
+	}
+}
+
+func TestPerformSync_SearchError(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := &syncRunMockStore{MockStore: NewMockStore(t)}
+
+	c.ExpectSearchIssues("ORDER BY updated ASC").
+		WillRespondWithIssueKeys([]string{"PJ-1"}).
+		WillFailWith(errors.New("unreachable"))
+	c.ExpectGetIssue("PJ-1").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-1").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WillReturnError(nil)
+
+	if err := jira.PerformSync(context.Background(), c, s, 10, &mapperMock{}); err == nil {
+		t.Errorf("expected the error of the search")
+	}
+	if len(s.started) != 1 || len(s.finished) != 0 {
+		t.Errorf("expected the failed sync run to be left unfinished, got started %v, finished %v", s.started, s.finished)
+	}
+}
+
+// resumableMockStore is a `syncRunMockStore` with an unfinished
+// sync run.
+type resumableMockStore struct {
+	syncRunMockStore
+	runID  int64
+	synced []string
+}
+
+func (s *resumableMockStore) GetResumableSyncRun(kind string) (int64, error) {
+	return s.runID, nil
+}
+
+func (s *resumableMockStore) GetSyncedIssueKeys(id int64) ([]string, error) {
+	return s.synced, nil
+}
+
+func (s *resumableMockStore) SetSyncRunFilter(id int64, filter string) error {
+	s.filters = append(s.filters, filter)
+	return nil
+}
+
+func TestResumeSync(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := &resumableMockStore{
+		syncRunMockStore: syncRunMockStore{MockStore: NewMockStore(t)},
+		runID:            3,
+		synced:           []string{"PJ-1"},
+	}
+
+	// `PJ-1` was synced before the interruption and is not fetched
+	// again
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2"})
+	c.ExpectGetIssue("PJ-2").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-2").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WillReturnError(nil)
+
+	jira.ResumeSync(context.Background(), c, s, 10, &mapperMock{})
+
+	if len(s.started) != 0 {
+		t.Errorf("expected no new sync run to be started, got %v", s.started)
+	}
+	if len(s.finished) != 1 || s.finished[0] != 2 {
+		t.Errorf("expected the resumed sync run to be finished with 2 issues, got %v", s.finished)
+	}
 }
 
 func TestPerformSyncForIssueKey(t *testing.T) {
@@ -107,6 +521,630 @@ func TestPerformSyncForIssueKey(t *testing.T) {
 	jira.PerformSyncForIssueKey(c, s, k, &mapperMock{})
 }
 
+func TestPerformSyncForIssueKeys(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := NewMockStore(t)
+
+	// The issues are fetched without searching
+	for _, k := range []string{"PJ-1", "PJ-2"} {
+		c.ExpectGetIssue(k).WillRespondWithIssue(&extJira.Issue{})
+		s.ExpectReplaceIssueStateAndEvents().
+			WithIssueKey(k).
+			WithIssueState(&store.IssueState{}).
+			WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+			WillReturnError(nil)
+	}
+
+	jira.PerformSyncForIssueKeys(context.Background(), c, s, []string{"PJ-1", "PJ-2"}, 1, &mapperMock{})
+}
+
+func TestPerformSyncForIssueKeys_Stalled(t *testing.T) {
+	jira.StallTimeout, jira.RestartStalledWorkers = 40*time.Millisecond, true
+	defer func() { jira.StallTimeout, jira.RestartStalledWorkers = 0, false }()
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	c := client.NewMockClient(t)
+	s := NewMockStore(t)
+	c.ExpectGetIssue("PJ-1").WillTimeoutAfter(time.Second)
+	c.ExpectGetIssue("PJ-2").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-2").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WillReturnError(nil)
+
+	// The single worker gives up PJ-1 and moves on to PJ-2
+	start := time.Now()
+	jira.PerformSyncForIssueKeys(context.Background(), c, s, []string{"PJ-1", "PJ-2"}, 1, &mapperMock{})
+	if d := time.Since(start); d >= time.Second {
+		t.Errorf("expected the stalled issue to be abandoned, the sync took %s", d)
+	}
+	for _, expected := range []string{"Sync stalled", "PJ-1 (", "goroutine ", "Issue `PJ-1` abandoned"} {
+		if !strings.Contains(logs.String(), expected) {
+			t.Errorf("expected the logs to contain %q, got:\n%s", expected, logs.String())
+		}
+	}
+}
+
+func TestImportIssues(t *testing.T) {
+	s := NewMockStore(t)
+	for _, k := range []string{"PJ-1", "PJ-2"} {
+		s.ExpectReplaceIssueStateAndEvents().
+			WithIssueKey(k).
+			WithIssueState(&store.IssueState{}).
+			WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+			WillReturnError(nil)
+	}
+
+	r := strings.NewReader(`{"key": "PJ-1", "fields": {}}
+{"key": "PJ-2", "fields": {}}
+`)
+	n, err := jira.ImportIssues(r, s, &mapperMock{})
+	if err != nil || n != 2 {
+		t.Errorf("expected 2 issues to be imported, got %d (%v)", n, err)
+	}
+}
+
+// changelogMockClient is a `MockClient` able to fetch whole
+// changelogs (see `jira.ChangelogFetcher`).
+type changelogMockClient struct {
+	*client.MockClient
+	histories []extJira.ChangelogHistory
+}
+
+func (c *changelogMockClient) GetChangelog(issueKey string) ([]extJira.ChangelogHistory, error) {
+	return c.histories, nil
+}
+
+// changelogMapper records the number of changelog histories of the
+// mapped issue.
+type changelogMapper struct {
+	mapperMock
+	histories int
+}
+
+func (m *changelogMapper) IssueStateFromIssue(i *extJira.Issue) store.IssueState {
+	m.histories = len(i.Changelog.Histories)
+	return store.IssueState{}
+}
+
+func TestPerformSyncForIssueKey_WholeChangelog(t *testing.T) {
+	k := "PJ-1"
+	for _, tc := range []struct {
+		name     string
+		embedded int
+		expected int
+	}{
+		{"changelog not truncated", 3, 3},
+		{"changelog truncated", 100, 150},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &changelogMockClient{
+				MockClient: client.NewMockClient(t),
+				histories:  make([]extJira.ChangelogHistory, 150),
+			}
+			s := NewMockStore(t)
+			m := &changelogMapper{}
+
+			c.ExpectGetIssue(k).WillRespondWithIssue(&extJira.Issue{
+				Changelog: &extJira.Changelog{Histories: make([]extJira.ChangelogHistory, tc.embedded)},
+			})
+			s.ExpectReplaceIssueStateAndEvents().
+				WithIssueKey(k).
+				WithIssueState(&store.IssueState{}).
+				WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+				WillReturnError(nil)
+
+			jira.PerformSyncForIssueKey(c, s, k, m)
+			if m.histories != tc.expected {
+				t.Errorf("expected %d histories to be mapped, got %d", tc.expected, m.histories)
+			}
+		})
+	}
+}
+
+func TestPerformChangelogBackfill(t *testing.T) {
+	c := &changelogMockClient{
+		MockClient: client.NewMockClient(t),
+		histories:  make([]extJira.ChangelogHistory, 150),
+	}
+	s := NewMockStore(t)
+	m := &changelogMapper{}
+
+	// The changelog is fetched again even if not truncated by Jira
+	c.ExpectGetIssue("PJ-1").WillRespondWithIssue(&extJira.Issue{
+		Changelog: &extJira.Changelog{Histories: make([]extJira.ChangelogHistory, 3)},
+	})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-1").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WillReturnError(nil)
+
+	jira.PerformChangelogBackfill(context.Background(), c, s, []string{"PJ-1"}, 1, m)
+	if m.histories != 150 {
+		t.Errorf("expected 150 histories to be mapped, got %d", m.histories)
+	}
+}
+
+// worklogMockClient is a `MockClient` able to fetch all the
+// worklogs of an issue (see `jira.WorklogFetcher`).
+type worklogMockClient struct {
+	*client.MockClient
+	worklogs []extJira.WorklogRecord
+}
+
+func (c *worklogMockClient) GetWorklogs(issueKey string) ([]extJira.WorklogRecord, error) {
+	return c.worklogs, nil
+}
+
+// worklogMapper records the number of worklogs of the mapped issue.
+type worklogMapper struct {
+	mapperMock
+	worklogs int
+}
+
+func (m *worklogMapper) IssueStateFromIssue(i *extJira.Issue) store.IssueState {
+	m.worklogs = len(i.Fields.Worklog.Worklogs)
+	return store.IssueState{}
+}
+
+func TestPerformSyncForIssueKey_AllWorklogs(t *testing.T) {
+	k := "PJ-1"
+	wc := &worklogMockClient{
+		MockClient: client.NewMockClient(t),
+		worklogs:   make([]extJira.WorklogRecord, 25),
+	}
+	// The capabilities of a filtered client are those of the
+	// wrapped one
+	c := jira.NewFilteredClient(wc, jira.Filter{Projects: []string{"PJ"}})
+	s := NewMockStore(t)
+	m := &worklogMapper{}
+
+	wc.ExpectGetIssue(k).WillRespondWithIssue(&extJira.Issue{
+		Key: k,
+		Fields: &extJira.IssueFields{
+			Worklog: &extJira.Worklog{Total: 25, Worklogs: make([]extJira.WorklogRecord, 20)},
+		},
+	})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey(k).
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WillReturnError(nil)
+
+	jira.PerformSyncForIssueKey(c, s, k, m)
+	if m.worklogs != 25 {
+		t.Errorf("expected 25 worklogs to be mapped, got %d", m.worklogs)
+	}
+}
+
+// boardsMockClient is a `MockClient` able to fetch boards and
+// sprints (see `jira.BoardsFetcher`).
+type boardsMockClient struct {
+	*client.MockClient
+	boards  []extJira.Board
+	sprints map[int][]client.Sprint
+}
+
+func (c *boardsMockClient) GetBoards() ([]extJira.Board, error) {
+	return c.boards, nil
+}
+
+func (c *boardsMockClient) GetSprints(boardID int) ([]client.Sprint, error) {
+	return c.sprints[boardID], nil
+}
+
+// boardsMockStore is a `MockStore` recording the boards and sprints
+// (see `jira.BoardStore`).
+type boardsMockStore struct {
+	*MockStore
+	boards  []store.Board
+	sprints []store.Sprint
+}
+
+func (s *boardsMockStore) ReplaceBoardsAndSprints(boards []store.Board, sprints []store.Sprint) error {
+	s.boards, s.sprints = boards, sprints
+	return nil
+}
+
+func TestPerformSync_BoardsAndSprints(t *testing.T) {
+	c := &boardsMockClient{
+		MockClient: client.NewMockClient(t),
+		boards: []extJira.Board{
+			{ID: 1, Name: "Team A", Type: "scrum"},
+			{ID: 2, Name: "Team B", Type: "scrum"},
+			{ID: 3, Name: "Support", Type: "kanban"},
+		},
+		sprints: map[int][]client.Sprint{
+			1: {{Sprint: extJira.Sprint{ID: 10, Name: "A 1", State: "closed", OriginBoardID: 1}, Goal: "Ship the checkout"}},
+			// Sprint 10 is displayed on board 2 too
+			2: {
+				{Sprint: extJira.Sprint{ID: 10, Name: "A 1", State: "closed", OriginBoardID: 1}, Goal: "Ship the checkout"},
+				{Sprint: extJira.Sprint{ID: 20, Name: "B 1", State: "active"}},
+			},
+		},
+	}
+	s := &boardsMockStore{MockStore: NewMockStore(t)}
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{})
+
+	jira.PerformSync(context.Background(), c, s, 10, &mapperMock{})
+
+	if len(s.boards) != 3 {
+		t.Errorf("expected 3 boards to be stored, got %v", s.boards)
+	}
+	expected := []store.Sprint{
+		{ID: 10, BoardID: 1, Name: "A 1", State: "closed", Goal: "Ship the checkout"},
+		{ID: 20, BoardID: 2, Name: "B 1", State: "active"},
+	}
+	if fmt.Sprint(s.sprints) != fmt.Sprint(expected) {
+		t.Errorf("expected sprints %v, got %v", expected, s.sprints)
+	}
+}
+
+// sprintReportsMockClient is a `MockClient` able to fetch the
+// sprint reports (see `jira.SprintReportsFetcher`).
+type sprintReportsMockClient struct {
+	*client.MockClient
+	reports map[int]*client.SprintReport
+}
+
+func (c *sprintReportsMockClient) GetSprintReport(boardID, sprintID int) (*client.SprintReport, error) {
+	r, ok := c.reports[sprintID]
+	if !ok {
+		return nil, fmt.Errorf("no report of sprint %d", sprintID)
+	}
+	return r, nil
+}
+
+// sprintReportsMockStore is a `MockStore` recording the sprint
+// reports (see `jira.SprintReportStore`).
+type sprintReportsMockStore struct {
+	*MockStore
+	sprints        []store.Sprint
+	unreportedOnly bool
+	reports        []store.SprintReport
+}
+
+func (s *sprintReportsMockStore) GetClosedSprints(unreportedOnly bool) ([]store.Sprint, error) {
+	s.unreportedOnly = unreportedOnly
+	return s.sprints, nil
+}
+
+func (s *sprintReportsMockStore) ReplaceSprintReport(r store.SprintReport) error {
+	s.reports = append(s.reports, r)
+	return nil
+}
+
+func TestPerformSprintReportsSync(t *testing.T) {
+	c := &sprintReportsMockClient{
+		MockClient: client.NewMockClient(t),
+		reports: map[int]*client.SprintReport{
+			10: {CompletedIssues: 8, NotCompletedIssues: 2, AddedIssues: 1, CompletedPoints: 21},
+		},
+	}
+	s := &sprintReportsMockStore{
+		MockStore: NewMockStore(t),
+		// The board of sprint 20 was deleted
+		sprints: []store.Sprint{{ID: 10, BoardID: 1, Name: "A 1"}, {ID: 20, BoardID: 2, Name: "B 1"}},
+	}
+
+	if err := jira.PerformSprintReportsSync(c, s, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !s.unreportedOnly {
+		t.Errorf("expected only the sprints without report to be fetched")
+	}
+	expected := []store.SprintReport{
+		{SprintID: 10, BoardID: 1, CompletedIssues: 8, NotCompletedIssues: 2, AddedIssues: 1, CompletedPoints: 21},
+	}
+	if fmt.Sprint(s.reports) != fmt.Sprint(expected) {
+		t.Errorf("expected reports %v, got %v", expected, s.reports)
+	}
+}
+
+// sprintGoalsMockStore is a `MockStore` evaluating the sprint goals
+// (see `jira.SprintGoalStore`).
+type sprintGoalsMockStore struct {
+	*MockStore
+	sprints []store.Sprint
+	all     bool
+	goals   map[int][]string
+}
+
+func (s *sprintGoalsMockStore) GetSprintsToEvaluate(all bool) ([]store.Sprint, error) {
+	s.all = all
+	return s.sprints, nil
+}
+
+func (s *sprintGoalsMockStore) ReplaceSprintGoalResult(sp store.Sprint, goalIssueKeys []string) error {
+	s.goals[sp.ID] = goalIssueKeys
+	return nil
+}
+
+func TestPerformSprintGoalsEvaluation(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := &sprintGoalsMockStore{
+		MockStore: NewMockStore(t),
+		sprints:   []store.Sprint{{ID: 10, BoardID: 1, Name: "A 1", Goal: "Ship the checkout"}, {ID: 20, BoardID: 2, Name: "B 1"}},
+		goals:     make(map[int][]string),
+	}
+
+	// Nothing is evaluated without a query
+	if err := jira.PerformSprintGoalsEvaluation(c, s, false); err != nil || len(s.goals) > 0 {
+		t.Fatalf("expected no evaluation, got %v (%v)", s.goals, err)
+	}
+
+	jira.SprintGoalQuery = "labels = sprint-goal"
+	defer func() { jira.SprintGoalQuery = "" }()
+	c.ExpectSearchIssues(`^sprint = 10 AND \(labels = sprint-goal\)$`).WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2"})
+	c.ExpectSearchIssues(`^sprint = 20 AND \(labels = sprint-goal\)$`).WillRespondWithIssueKeys(nil)
+	if err := jira.PerformSprintGoalsEvaluation(c, s, true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !s.all {
+		t.Errorf("expected all the closed sprints to be evaluated")
+	}
+	if fmt.Sprint(s.goals) != "map[10:[PJ-1 PJ-2] 20:[]]" {
+		t.Errorf("expected the goal issues of both sprints, got %v", s.goals)
+	}
+}
+
+// usersMockClient is a `MockClient` able to fetch the users (see
+// `jira.UsersFetcher`).
+type usersMockClient struct {
+	*client.MockClient
+	users []extJira.User
+}
+
+func (c *usersMockClient) GetUsers() ([]extJira.User, error) {
+	return c.users, nil
+}
+
+// usersMockStore is a `MockStore` recording the users (see
+// `jira.UserStore`).
+type usersMockStore struct {
+	*MockStore
+	users []store.User
+}
+
+func (s *usersMockStore) ReplaceUsers(users []store.User) error {
+	s.users = users
+	return nil
+}
+
+func TestPerformSync_Users(t *testing.T) {
+	c := &usersMockClient{
+		MockClient: client.NewMockClient(t),
+		users: []extJira.User{
+			{AccountID: "557058:alice", DisplayName: "Alice Liddell", EmailAddress: "alice@example.com", Active: true},
+			{AccountID: "557058:bob", DisplayName: "Bob"},
+			// Users without account ID can't be referenced by the events
+			{DisplayName: "Anonymous"},
+		},
+	}
+	s := &usersMockStore{MockStore: NewMockStore(t)}
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{})
+
+	jira.PerformSync(context.Background(), c, s, 10, &mapperMock{})
+
+	email := "alice@example.com"
+	expected := []store.User{
+		{AccountID: "557058:alice", DisplayName: "Alice Liddell", Email: &email, Active: true},
+		{AccountID: "557058:bob", DisplayName: "Bob"},
+	}
+	if !reflect.DeepEqual(s.users, expected) {
+		t.Errorf("expected users %v, got %v", expected, s.users)
+	}
+}
+
+// assigneeIntervalsMockStore is a `syncRunMockStore` counting the
+// refreshes of the assignee intervals (see
+// `jira.AssigneeIntervalsStore`).
+type assigneeIntervalsMockStore struct {
+	*syncRunMockStore
+	refreshes int
+}
+
+func (s *assigneeIntervalsMockStore) RefreshAssigneeIntervals() (int64, error) {
+	s.refreshes++
+	return 3, nil
+}
+
+func TestPerformSync_AssigneeIntervals(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := &assigneeIntervalsMockStore{syncRunMockStore: &syncRunMockStore{MockStore: NewMockStore(t)}}
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{})
+	jira.PerformSync(context.Background(), c, s, 10, &mapperMock{})
+	if s.refreshes != 1 {
+		t.Errorf("expected the intervals to be refreshed at the end of the sync, got %d refreshes", s.refreshes)
+	}
+
+	// A failed sync doesn't refresh them
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillFailWith(errors.New("search failed"))
+	jira.PerformSync(context.Background(), c, s, 10, &mapperMock{})
+	if s.refreshes != 1 {
+		t.Errorf("expected the intervals not to be refreshed after a failed sync, got %d refreshes", s.refreshes)
+	}
+}
+
+// workflowsMockClient is a `MockClient` able to fetch the statuses
+// and workflows of the projects (see `jira.WorkflowsFetcher`).
+type workflowsMockClient struct {
+	*client.MockClient
+	projects    extJira.ProjectList
+	statuses    map[string][]client.IssueTypeStatuses
+	schemes     map[string]*client.WorkflowScheme
+	transitions map[string][]client.WorkflowTransition
+}
+
+func (c *workflowsMockClient) GetProjects() (extJira.ProjectList, error) {
+	return c.projects, nil
+}
+
+func (c *workflowsMockClient) GetProjectStatuses(projectKey string) ([]client.IssueTypeStatuses, error) {
+	return c.statuses[projectKey], nil
+}
+
+func (c *workflowsMockClient) GetWorkflowScheme(projectID string) (*client.WorkflowScheme, error) {
+	return c.schemes[projectID], nil
+}
+
+func (c *workflowsMockClient) GetWorkflowTransitions(workflow string) ([]client.WorkflowTransition, error) {
+	return c.transitions[workflow], nil
+}
+
+// workflowsMockStore is a `MockStore` recording the workflows (see
+// `jira.WorkflowStore`).
+type workflowsMockStore struct {
+	*MockStore
+	workflows store.Workflows
+}
+
+func (s *workflowsMockStore) ReplaceWorkflows(w store.Workflows, at time.Time) error {
+	s.workflows = w
+	return nil
+}
+
+func TestPerformSync_Workflows(t *testing.T) {
+	c := &workflowsMockClient{
+		MockClient: client.NewMockClient(t),
+		statuses: map[string][]client.IssueTypeStatuses{
+			"PJ": {
+				{ID: "1", Name: "Bug", Statuses: []extJira.Status{
+					{ID: "10", Name: "Open", StatusCategory: extJira.StatusCategory{Key: "new"}},
+					{ID: "11", Name: "Done", StatusCategory: extJira.StatusCategory{Key: "done"}},
+				}},
+				{ID: "2", Name: "Task", Statuses: []extJira.Status{
+					{ID: "10", Name: "Open", StatusCategory: extJira.StatusCategory{Key: "new"}},
+				}},
+			},
+		},
+		schemes: map[string]*client.WorkflowScheme{
+			"100": {Name: "PJ Scheme", DefaultWorkflow: "Default", IssueTypeMappings: map[string]string{"1": "Bug Workflow"}},
+		},
+		transitions: map[string][]client.WorkflowTransition{
+			"Bug Workflow": {
+				{ID: "1", Name: "Create", To: "10"},
+				{ID: "2", Name: "Close", From: []string{"10"}, To: "11"},
+			},
+		},
+	}
+	c.projects = extJira.ProjectList{{ID: "100", Key: "PJ"}, {ID: "200", Key: "OTHER"}}
+	s := &workflowsMockStore{MockStore: NewMockStore(t)}
+	c.ExpectSearchIssues(`project IN \("PJ"\) ORDER BY updated ASC`).WillRespondWithIssueKeys([]string{})
+
+	// Only the projects of the filter are synced
+	jira.PerformSync(context.Background(), jira.NewFilteredClient(c, jira.Filter{Projects: []string{"PJ"}}), s, 10, &mapperMock{})
+
+	if len(s.workflows.Statuses) != 3 {
+		t.Errorf("expected 3 project statuses to be stored, got %v", s.workflows.Statuses)
+	}
+	expectedWorkflows := []store.ProjectWorkflow{
+		{ProjectKey: "PJ", IssueType: "Bug", WorkflowScheme: "PJ Scheme", Workflow: "Bug Workflow"},
+		{ProjectKey: "PJ", IssueType: "Task", WorkflowScheme: "PJ Scheme", Workflow: "Default"},
+	}
+	if fmt.Sprint(s.workflows.Workflows) != fmt.Sprint(expectedWorkflows) {
+		t.Errorf("expected workflows %v, got %v", expectedWorkflows, s.workflows.Workflows)
+	}
+	ts := s.workflows.Transitions
+	if len(ts) != 2 || ts[0].FromStatus != nil || ts[0].ToStatus != "Open" || *ts[1].FromStatus != "Open" || ts[1].ToStatus != "Done" {
+		t.Errorf("expected the transitions of `Bug Workflow` with status names, got %v", ts)
+	}
+}
+
+// deletionMockStore is a `MockStore` recording the keys of the
+// issues found by the full syncs (see `jira.DeletionStore`).
+type deletionMockStore struct {
+	*MockStore
+	foundKeys []string
+	called    bool
+}
+
+func (s *deletionMockStore) MarkDeletedIssues(foundKeys []string, at time.Time) (int, error) {
+	s.called = true
+	s.foundKeys = foundKeys
+	return 1, nil
+}
+
+func TestPerformSync_DeletedIssues(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := &deletionMockStore{MockStore: NewMockStore(t)}
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2"})
+	for _, k := range []string{"PJ-1", "PJ-2"} {
+		c.ExpectGetIssue(k).WillRespondWithIssue(&extJira.Issue{})
+		s.ExpectReplaceIssueStateAndEvents().WithIssueKey(k).WithIssueState(&store.IssueState{}).WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}})
+	}
+
+	jira.PerformSync(context.Background(), c, s, 10, &mapperMock{})
+
+	if fmt.Sprint(s.foundKeys) != "[PJ-1 PJ-2]" {
+		t.Errorf("expected the found keys [PJ-1 PJ-2] to be passed, got %v", s.foundKeys)
+	}
+}
+
+func TestPerformSync_DeletedIssuesFiltered(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := &deletionMockStore{MockStore: NewMockStore(t)}
+	c.ExpectSearchIssues(`project IN \("PJ"\) ORDER BY updated ASC`).WillRespondWithIssueKeys([]string{})
+
+	// The issues of the other projects are not found, but not deleted
+	jira.PerformSync(context.Background(), jira.NewFilteredClient(c, jira.Filter{Projects: []string{"PJ"}}), s, 10, &mapperMock{})
+
+	if s.called {
+		t.Errorf("expected no issues to be marked as deleted by a filtered sync")
+	}
+}
+
+func TestPerformIncrementalSync_Filtered(t *testing.T) {
+	lastSync := time.Date(2020, 3, 2, 10, 5, 0, 0, time.Local)
+	c := client.NewMockClient(t)
+	s := &syncRunMockStore{MockStore: NewMockStore(t), lastSyncStart: &lastSync}
+	c.ExpectSearchIssues(`project IN \("PJ"\) AND \(updated >= '2020/3/2 10:5'\) ORDER BY updated ASC`).WillRespondWithIssueKeys([]string{})
+
+	// The run is recorded with its filter, so the next incremental
+	// syncs don't restart from it
+	jira.PerformIncrementalSync(context.Background(), jira.NewFilteredClient(c, jira.Filter{Projects: []string{"PJ"}}), s, 10, &mapperMock{})
+
+	if len(s.filters) != 1 || s.filters[0] != `project IN ("PJ")` {
+		t.Errorf("expected the sync run to be recorded with its filter, got %q", s.filters)
+	}
+}
+
 func timeAsStr(t time.Time) string {
 	return t.Format("2006-01-02T15:04:05.000-0700")
 }
+
+func TestPerformReconciliationSync(t *testing.T) {
+	c := client.NewMockClient(t)
+	s := NewMockStore(t)
+
+	// Search the issues updated during the window, whatever the
+	// last update in the store
+	c.ExpectSearchIssues("updated >= '-120m' ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1"})
+	c.ExpectGetIssue("PJ-1").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-1").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WillReturnError(nil)
+
+	jira.PerformReconciliationSync(context.Background(), c, s, 10, &mapperMock{}, 2*time.Hour)
+}
+
+func TestMapIssueForKey(t *testing.T) {
+	c := client.NewMockClient(t)
+	c.ExpectGetIssue("PJ-1").WillRespondWithIssue(client.NewIssueFixture("PJ-1").Issue())
+	c.ExpectGetIssue("PJ-2").WillRespondWithError(errors.New("not found"))
+
+	is, ies, err := jira.MapIssueForKey(c, "PJ-1", &mapperMock{})
+	if err != nil || len(ies) != 1 || is.Key != "" {
+		t.Errorf("expected the records of the mapper, got %v, %v (%v)", is, ies, err)
+	}
+	if _, _, err = jira.MapIssueForKey(c, "PJ-2", &mapperMock{}); err == nil {
+		t.Errorf("expected an error when the issue can't be fetched")
+	}
+	c.AssertExpectationsMet(t)
+}
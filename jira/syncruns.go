@@ -0,0 +1,124 @@
+package jira
+
+import (
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// The kinds of syncs recorded in the `SyncRunStore`
+const (
+	SyncKindFull           = "full"
+	SyncKindIncremental    = "incremental"
+	SyncKindReconciliation = "reconciliation"
+)
+
+// SyncRunStore is implemented by stores recording the syncs (e.g.
+// `store.PGStore`), so an incremental sync can restart from the
+// last successful one. The runs are recorded with the JQL of the
+// filter of the client (see `NewFilteredClient`), and
+// `GetLastSyncStart` must ignore the filtered ones.
+type SyncRunStore interface {
+	StartSyncRun(kind string, startedAt time.Time, filter string) (int64, error)
+	FinishSyncRun(id int64, finishedAt time.Time, issuesCount int) error
+	GetLastSyncStart(kinds []string) (*time.Time, error)
+}
+
+// ResumableStore is implemented by stores able to resume the full
+// syncs which didn't finish (e.g. `store.PGStore`), by recording
+// the issues stored by each sync run (see `store.Writer.SetSyncRun`).
+type ResumableStore interface {
+	SyncRunStore
+
+	// GetResumableSyncRun returns the ID of the last sync run of
+	// the kind if it didn't finish, 0 otherwise.
+	GetResumableSyncRun(kind string) (int64, error)
+
+	GetSyncedIssueKeys(id int64) ([]string, error)
+
+	// SetSyncRunFilter replaces the filter of the resumed run.
+	SetSyncRunFilter(id int64, filter string) error
+}
+
+// startSyncRun records the start of a sync, with the filter of the
+// client (see `filterJQL`), if the store records them, and returns the ID of the run (0 if not
+// recorded) and the function to call with the number of synced
+// issues when the sync is done. Failing to record the sync is
+// logged but doesn't prevent it.
+func startSyncRun(c Client, s store.Store, kind string, startedAt time.Time) (int64, func(issuesCount int)) {
+	srs, ok := s.(SyncRunStore)
+	if !ok {
+		return 0, func(int) {}
+	}
+	id, err := srs.StartSyncRun(kind, startedAt, filterJQL(c))
+	if err != nil {
+		logging.Errorf("Could not record the start of the sync: %s", err)
+		return 0, func(int) {}
+	}
+	return id, func(issuesCount int) {
+		finishSyncRun(s, id, issuesCount)
+	}
+}
+
+// finishSyncRun records the end of the sync run, which must have
+// been recorded by `startSyncRun`.
+func finishSyncRun(s store.Store, id int64, issuesCount int) {
+	if err := s.(SyncRunStore).FinishSyncRun(id, time.Now(), issuesCount); err != nil {
+		logging.Errorf("Could not record the end of the sync: %s", err)
+	}
+}
+
+// resumableSyncRun returns the ID of the last sync run of the kind
+// if it didn't finish, and the keys of the issues it stored. Returns
+// 0 if there is none or the store is not a `ResumableStore`. The
+// filter of the run is replaced with the client's, which syncs the
+// remaining issues.
+func resumableSyncRun(c Client, s store.Store, kind string) (int64, map[string]bool) {
+	rs, ok := s.(ResumableStore)
+	if !ok {
+		logging.Errorf("The store can't resume syncs")
+		return 0, nil
+	}
+	id, err := rs.GetResumableSyncRun(kind)
+	if err != nil || id == 0 {
+		if err != nil {
+			logging.Errorf("Could not get the sync to resume: %s", err)
+		}
+		return 0, nil
+	}
+	keys, err := rs.GetSyncedIssueKeys(id)
+	if err != nil {
+		logging.Errorf("Could not get the issues synced by sync %d: %s", id, err)
+		return 0, nil
+	}
+	if err = rs.SetSyncRunFilter(id, filterJQL(c)); err != nil {
+		logging.Errorf("Could not record the filter of sync %d: %s", id, err)
+		return 0, nil
+	}
+	synced := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		synced[k] = true
+	}
+	return id, synced
+}
+
+// lastSyncStart returns the start of the last successful full or
+// incremental sync (reconciliation syncs only cover recent updates,
+// and filtered syncs some issues), or nil if the store doesn't
+// record syncs or none was successful.
+func lastSyncStart(s store.Store) *time.Time {
+	srs, ok := s.(SyncRunStore)
+	if !ok {
+		return nil
+	}
+	t, err := srs.GetLastSyncStart([]string{SyncKindFull, SyncKindIncremental})
+	if err != nil {
+		logging.Errorf("Could not get the last sync: %s", err)
+		return nil
+	}
+	if t != nil {
+		logging.Infof("Restarting from the last successful sync, started at %s", t)
+	}
+	return t
+}
@@ -0,0 +1,66 @@
+package jira
+
+import (
+	"github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// UsersFetcher is implemented by clients able to fetch the users of
+// the Jira instance (e.g. `APIClient`).
+type UsersFetcher interface {
+	GetUsers() ([]jira.User, error)
+}
+
+// UserStore is implemented by stores recording the users (e.g.
+// `store.PGStore`).
+type UserStore interface {
+	ReplaceUsers(users []store.User) error
+}
+
+// syncUsers fetches all the users and replaces those in the store,
+// if the client can fetch them and the store can record them. The
+// users are left unchanged if fetching them fails or returns none
+// (e.g. on Jira Server).
+func syncUsers(c Client, s store.Store) {
+	uf, ok := unfiltered(c).(UsersFetcher)
+	if !ok {
+		return
+	}
+	us, ok := s.(UserStore)
+	if !ok {
+		return
+	}
+	jiraUsers, err := uf.GetUsers()
+	if err != nil {
+		logging.Errorf("Could not sync the users: %s", err)
+		return
+	}
+	if len(jiraUsers) == 0 {
+		return
+	}
+
+	users := make([]store.User, 0, len(jiraUsers))
+	for _, u := range jiraUsers {
+		if u.AccountID == "" {
+			continue
+		}
+		var email *string
+		if e := u.EmailAddress; e != "" {
+			email = &e
+		}
+		users = append(users, store.User{
+			AccountID:   u.AccountID,
+			Name:        u.Name,
+			DisplayName: u.DisplayName,
+			Email:       email,
+			Active:      u.Active,
+		})
+	}
+	if err := us.ReplaceUsers(users); err != nil {
+		logging.Errorf("Could not store the users: %s", err)
+		return
+	}
+	logging.Infof("Synced %d users", len(users))
+}
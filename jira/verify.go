@@ -0,0 +1,239 @@
+package jira
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// VerificationStore is implemented by stores whose states can be
+// compared with Jira by `Verify` (e.g. `store.PGStore`).
+type VerificationStore interface {
+	GetVerifiedStates(keys []string) (map[string]store.VerifiedState, error)
+}
+
+// The kinds of drift between the store and Jira reported by
+// `Verify`.
+const (
+	// DriftMissing is an issue of Jira which is not in the store.
+	DriftMissing = "missing"
+
+	// DriftStale is an issue updated in Jira after its state in the
+	// store.
+	DriftStale = "stale"
+
+	// DriftStatus and DriftAssignee are issues whose status or
+	// assignee in the store is not the one of Jira.
+	DriftStatus   = "status"
+	DriftAssignee = "assignee"
+
+	// DriftNotInJira is an issue of the store not found in Jira,
+	// and not marked as deleted. Only detected by a full scan.
+	DriftNotInJira = "not_in_jira"
+)
+
+// Drift is a difference between an issue of the store and Jira.
+type Drift struct {
+	IssueKey string
+	Kind     string
+
+	// Stored and Jira are the values of the store and of Jira, empty
+	// if there is none.
+	Stored string
+	Jira   string
+}
+
+// VerifyOptions configures `Verify`.
+type VerifyOptions struct {
+	// Sample is the number of issues picked at random among the
+	// issues of Jira, or 0 to scan all of them.
+	Sample int
+	Seed   int64
+
+	// SyncedAt is the start of the last sync. The issues updated in
+	// Jira since are not synced yet, so they are counted as pending
+	// rather than reported as drift. Ignored if zero.
+	SyncedAt time.Time
+}
+
+// VerifyReport is the result of `Verify`.
+type VerifyReport struct {
+	// Found is the number of issues found in Jira, and Checked the
+	// number of them which were fetched and compared.
+	Found   int
+	Checked int
+
+	// Pending is the number of issues checked which were updated
+	// since the last sync (see `VerifyOptions.SyncedAt`).
+	Pending int
+
+	// Failed is the number of issues which couldn't be fetched.
+	Failed int
+
+	Drifts []Drift
+}
+
+// Count returns the number of drifts of the kind.
+func (r VerifyReport) Count(kind string) int {
+	n := 0
+	for _, d := range r.Drifts {
+		if d.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// DriftedIssues returns the number of issues with at least a drift.
+func (r VerifyReport) DriftedIssues() int {
+	keys := make(map[string]bool)
+	for _, d := range r.Drifts {
+		keys[d.IssueKey] = true
+	}
+	return len(keys)
+}
+
+// WriteCSV writes the drifts as CSV, with a header row.
+func (r VerifyReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"issue_key", "drift", "stored", "jira"})
+	for _, d := range r.Drifts {
+		cw.Write([]string{d.IssueKey, d.Kind, d.Stored, d.Jira})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Verify compares the issues of Jira with their state in the store,
+// fetching and mapping them like the syncs do, with a pool of
+// `poolSize` workers. The status, assignee and update time of the
+// states are compared, so the drifts of the store (see `Drift`) are
+// found without modifying it.
+//
+// A full scan (`o.Sample` is 0) also reports the issues of the store
+// not found in Jira, unless the client is filtered (see
+// `NewFilteredClient`).
+func Verify(ctx context.Context, c Client, s VerificationStore, poolSize int, m Mapper, o VerifyOptions) (VerifyReport, error) {
+	var r VerifyReport
+	keys, err := searchAllKeys(c, "ORDER BY key ASC")
+	if err != nil {
+		return r, err
+	}
+	r.Found = len(keys)
+	if o.Sample > 0 && o.Sample < len(keys) {
+		rnd := rand.New(rand.NewSource(o.Seed))
+		picked := make([]string, o.Sample)
+		for i, j := range rnd.Perm(len(keys))[:o.Sample] {
+			picked[i] = keys[j]
+		}
+		keys = picked
+	}
+
+	var mutex sync.Mutex
+	states := make(map[string]store.IssueState, len(keys))
+	_, err = syncIssues(ctx, c, poolSize, m, func(issueKeys chan string) error {
+		defer close(issueKeys)
+		for _, k := range keys {
+			issueKeys <- k
+		}
+		return nil
+	}, func(k string, is store.IssueState, _ []store.IssueEvent) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		states[k] = is
+		return nil
+	}, nil)
+	if _, failed := err.(*FailedIssuesError); err != nil && !failed {
+		return r, err
+	}
+	r.Checked = len(states)
+	r.Failed = len(keys) - len(states)
+
+	full := o.Sample <= 0 || o.Sample >= r.Found
+	var storedKeys []string
+	if !full {
+		storedKeys = keys
+	}
+	stored, err := s.GetVerifiedStates(storedKeys)
+	if err != nil {
+		return r, err
+	}
+	for k, is := range states {
+		if !o.SyncedAt.IsZero() && is.UpdatedAt.After(o.SyncedAt) {
+			r.Pending++
+			continue
+		}
+		r.Drifts = append(r.Drifts, drifts(k, is, stored)...)
+	}
+	if full && !isFiltered(c) {
+		found := make(map[string]bool, len(keys))
+		for _, k := range keys {
+			found[k] = true
+		}
+		for k := range stored {
+			if !found[k] {
+				r.Drifts = append(r.Drifts, Drift{IssueKey: k, Kind: DriftNotInJira})
+			}
+		}
+	}
+	sort.Slice(r.Drifts, func(i, j int) bool {
+		if r.Drifts[i].IssueKey != r.Drifts[j].IssueKey {
+			return r.Drifts[i].IssueKey < r.Drifts[j].IssueKey
+		}
+		return r.Drifts[i].Kind < r.Drifts[j].Kind
+	})
+	logging.Infof("Verified %d issues of %d found in Jira, %d with drift", r.Checked, r.Found, r.DriftedIssues())
+	return r, nil
+}
+
+// drifts returns the drifts between the state of the issue mapped
+// from Jira and its state in the store.
+func drifts(k string, is store.IssueState, stored map[string]store.VerifiedState) []Drift {
+	vs, ok := stored[k]
+	if !ok {
+		return []Drift{{IssueKey: k, Kind: DriftMissing}}
+	}
+	var ds []Drift
+	// The store may truncate the time to the microsecond or second
+	if is.UpdatedAt.Sub(vs.UpdatedAt) >= time.Second {
+		ds = append(ds, Drift{k, DriftStale, vs.UpdatedAt.Format(time.RFC3339), is.UpdatedAt.Format(time.RFC3339)})
+	}
+	if deref(vs.Status) != deref(is.Status) {
+		ds = append(ds, Drift{k, DriftStatus, deref(vs.Status), deref(is.Status)})
+	}
+	if deref(vs.Assignee) != deref(is.Assignee) {
+		ds = append(ds, Drift{k, DriftAssignee, deref(vs.Assignee), deref(is.Assignee)})
+	}
+	return ds
+}
+
+// searchAllKeys returns the keys of the issues matching the JQL
+// query.
+func searchAllKeys(c Client, query string) ([]string, error) {
+	issueKeys := make(chan string, 100)
+	done := make(chan struct{})
+	var keys []string
+	go func() {
+		defer close(done)
+		for k := range issueKeys {
+			keys = append(keys, k)
+		}
+	}()
+	err := c.SearchIssues(query, issueKeys)
+	<-done
+	return keys, err
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
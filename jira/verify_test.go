@@ -0,0 +1,111 @@
+package jira_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// fieldsMapper maps the fields of the issues compared by `Verify`.
+type fieldsMapper struct{ mapperMock }
+
+func (m *fieldsMapper) IssueStateFromIssue(i *extJira.Issue) store.IssueState {
+	is := store.IssueState{Key: i.Key, UpdatedAt: time.Time(i.Fields.Updated), Status: &i.Fields.Status.Name}
+	if i.Fields.Assignee != nil {
+		is.Assignee = &i.Fields.Assignee.Name
+	}
+	return is
+}
+
+// verificationStore is a `jira.VerificationStore` of the states,
+// recording the keys it's asked for.
+type verificationStore struct {
+	states    map[string]store.VerifiedState
+	requested [][]string
+}
+
+func (s *verificationStore) GetVerifiedStates(keys []string) (map[string]store.VerifiedState, error) {
+	s.requested = append(s.requested, keys)
+	return s.states, nil
+}
+
+func TestVerify(t *testing.T) {
+	updated := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	open, done, alice := "Open", "Done", "alice"
+	s := &verificationStore{states: map[string]store.VerifiedState{
+		"PJ-1": {Key: "PJ-1", UpdatedAt: updated, Status: &open, Assignee: &alice},
+		"PJ-2": {Key: "PJ-2", UpdatedAt: updated.Add(-time.Hour), Status: &open},
+		"PJ-4": {Key: "PJ-4", UpdatedAt: updated, Status: &open},
+		"PJ-9": {Key: "PJ-9", UpdatedAt: updated, Status: &done},
+	}}
+	c := client.NewMockClient(t)
+	c.ExpectSearchIssues("ORDER BY key ASC").WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2", "PJ-3", "PJ-4", "PJ-5", "PJ-6"})
+	// Up to date, but for the truncation of the time by the store
+	c.ExpectGetIssue("PJ-1").WillRespondWithIssue(client.NewIssueFixture("PJ-1").WithUpdated(updated.Add(time.Millisecond)).WithAssignee("alice").Issue())
+	c.ExpectGetIssue("PJ-2").WillRespondWithIssue(client.NewIssueFixture("PJ-2").WithUpdated(updated).WithStatus("Done").Issue())
+	c.ExpectGetIssue("PJ-3").WillRespondWithIssue(client.NewIssueFixture("PJ-3").WithUpdated(updated).Issue())
+	c.ExpectGetIssue("PJ-4").WillRespondWithIssue(client.NewIssueFixture("PJ-4").WithUpdated(updated).WithAssignee("bob").Issue())
+	c.ExpectGetIssue("PJ-5").WillRespondWithError(errors.New("not found"))
+	// Updated since the last sync
+	c.ExpectGetIssue("PJ-6").WillRespondWithIssue(client.NewIssueFixture("PJ-6").WithUpdated(updated.Add(2 * time.Hour)).Issue())
+
+	r, err := jira.Verify(context.Background(), c, s, 2, &fieldsMapper{}, jira.VerifyOptions{SyncedAt: updated.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AssertExpectationsMet(t)
+	expected := []jira.Drift{
+		{IssueKey: "PJ-2", Kind: jira.DriftStale, Stored: "2020-03-02T09:00:00Z", Jira: "2020-03-02T10:00:00Z"},
+		{IssueKey: "PJ-2", Kind: jira.DriftStatus, Stored: "Open", Jira: "Done"},
+		{IssueKey: "PJ-3", Kind: jira.DriftMissing},
+		{IssueKey: "PJ-4", Kind: jira.DriftAssignee, Stored: "", Jira: "bob"},
+		{IssueKey: "PJ-9", Kind: jira.DriftNotInJira},
+	}
+	if !reflect.DeepEqual(r.Drifts, expected) {
+		t.Errorf("expected drifts %v, got %v", expected, r.Drifts)
+	}
+	if r.Found != 6 || r.Checked != 5 || r.Failed != 1 || r.Pending != 1 || r.DriftedIssues() != 4 || r.Count(jira.DriftStale) != 1 {
+		t.Errorf("unexpected report %+v", r)
+	}
+	// A full scan compares all the states of the store
+	if len(s.requested) != 1 || s.requested[0] != nil {
+		t.Errorf("expected all the states to be requested, got %v", s.requested)
+	}
+
+	var b bytes.Buffer
+	if err = r.WriteCSV(&b); err != nil {
+		t.Fatal(err)
+	}
+	if csv := b.String(); !strings.HasPrefix(csv, "issue_key,drift,stored,jira\nPJ-2,stale,2020-03-02T09:00:00Z,2020-03-02T10:00:00Z\n") {
+		t.Errorf("unexpected CSV %q", csv)
+	}
+}
+
+func TestVerify_Sample(t *testing.T) {
+	c := client.NewMockClient(t)
+	c.ExpectSearchIssues("ORDER BY key ASC").WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2", "PJ-3"})
+	s := &verificationStore{}
+	for _, k := range []string{"PJ-1", "PJ-2", "PJ-3"} {
+		c.ExpectGetIssue(k).WillRespondWithIssue(client.NewIssueFixture(k).Issue())
+	}
+
+	r, err := jira.Verify(context.Background(), c, s, 1, &fieldsMapper{}, jira.VerifyOptions{Sample: 2, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the sampled issues are compared, and the issues of the
+	// store not found are not reported
+	if r.Checked != 2 || len(s.requested) != 1 || len(s.requested[0]) != 2 || r.Count(jira.DriftMissing) != 2 || r.Count(jira.DriftNotInJira) != 0 {
+		t.Errorf("expected the 2 sampled issues to be missing, got %+v (requested %v)", r, s.requested)
+	}
+}
@@ -0,0 +1,121 @@
+package jira
+
+import (
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// StallTimeout is the time without any issue processed after which
+// a sync is considered stalled (e.g. by a request to Jira which
+// never returns, or a lock of the DB), and the diagnostics are
+// logged: the issues being processed and a dump of the goroutines.
+// They are logged again for each `StallTimeout` the sync remains
+// stalled. 0 disables the detection.
+var StallTimeout time.Duration
+
+// RestartStalledWorkers makes the workers processing an issue for
+// longer than `StallTimeout` give up the issue, counted as an error,
+// and move on to the next ones, when a stall is detected. The stuck
+// request is left running in the background: Go can't interrupt it.
+// The issue is synced again by the next sync.
+var RestartStalledWorkers bool
+
+// stallDumpSize is the maximum size of the goroutine dump logged
+// when a sync stalls.
+const stallDumpSize = 1 << 20
+
+// inFlightIssue is an issue being processed by a worker.
+type inFlightIssue struct {
+	started time.Time
+
+	// abandoned is closed if the worker gives up the issue (see
+	// `RestartStalledWorkers`).
+	abandoned chan struct{}
+}
+
+// begin records that a worker started processing the issue. The
+// returned channel is closed if the issue is abandoned by the
+// watchdog.
+func (p *progress) begin(key string) <-chan struct{} {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.inFlight == nil {
+		p.inFlight = make(map[string]*inFlightIssue)
+	}
+	ifi := &inFlightIssue{started: time.Now(), abandoned: make(chan struct{})}
+	p.inFlight[key] = ifi
+	return ifi.abandoned
+}
+
+// end records that the worker is done with the issue.
+func (p *progress) end(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.inFlight, key)
+	p.lastProcessed = time.Now()
+}
+
+// checkStall logs the diagnostics of the sync if no issue was
+// processed for `StallTimeout` while issues are being processed, and
+// abandons the issues processed for longer than it if
+// `RestartStalledWorkers` is set.
+func (p *progress) checkStall() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	if StallTimeout <= 0 || len(p.inFlight) == 0 {
+		return
+	}
+	since := p.lastProcessed
+	if p.lastStall.After(since) {
+		since = p.lastStall
+	}
+	if now.Sub(since) < StallTimeout {
+		return
+	}
+	p.lastStall = now
+
+	keys := make([]string, 0, len(p.inFlight))
+	for k := range p.inFlight {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return p.inFlight[keys[i]].started.Before(p.inFlight[keys[j]].started) })
+	inFlight := make([]string, len(keys))
+	for i, k := range keys {
+		inFlight[i] = k + " (" + now.Sub(p.inFlight[k].started).Round(time.Second).String() + ")"
+	}
+	logging.WithFields(logging.Fields{
+		"in_flight": inFlight,
+		"stalled":   now.Sub(p.lastProcessed).Round(time.Second).String(),
+	}).Errorf("%s stalled: no issue processed for %s, %d being processed", p.name, now.Sub(p.lastProcessed).Round(time.Second), len(keys))
+	buf := make([]byte, stallDumpSize)
+	logging.Errorf("%s goroutines:\n%s", p.name, buf[:runtime.Stack(buf, true)])
+
+	if !RestartStalledWorkers {
+		return
+	}
+	for _, k := range keys {
+		ifi := p.inFlight[k]
+		if now.Sub(ifi.started) < StallTimeout {
+			continue
+		}
+		close(ifi.abandoned)
+		delete(p.inFlight, k)
+		p.errors++
+		logging.WithFields(logging.Fields{"issue_key": k}).Warnf("Issue `%s` abandoned after %s, its worker moves on to the next issues", k, now.Sub(ifi.started).Round(time.Second))
+	}
+}
+
+// isAbandoned returns true if the issue was abandoned by the
+// watchdog (see `begin`).
+func isAbandoned(abandoned <-chan struct{}) bool {
+	select {
+	case <-abandoned:
+		return true
+	default:
+		return false
+	}
+}
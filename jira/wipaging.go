@@ -0,0 +1,28 @@
+package jira
+
+import (
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// WIPAgingStore is implemented by stores keeping daily snapshots of
+// the issues in progress (e.g. `store.PGStore`).
+type WIPAgingStore interface {
+	ExtendWIPAging() (int64, error)
+}
+
+// extendWIPAging extends the snapshots of the issues in progress to
+// the current day at the end of a sync, if the store can (see
+// `WIPAgingStore`).
+func extendWIPAging(s store.Store) {
+	was, ok := s.(WIPAgingStore)
+	if !ok {
+		return
+	}
+	n, err := was.ExtendWIPAging()
+	if err != nil {
+		logging.Errorf("Could not extend the WIP aging snapshots: %s", err)
+		return
+	}
+	logging.Infof("%d WIP aging snapshots added", n)
+}
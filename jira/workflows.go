@@ -0,0 +1,164 @@
+package jira
+
+import (
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// WorkflowsFetcher is implemented by clients able to fetch the
+// issue types, statuses and workflows of the projects (e.g.
+// `APIClient`).
+type WorkflowsFetcher interface {
+	GetProjects() (jira.ProjectList, error)
+	GetProjectStatuses(projectKey string) ([]client.IssueTypeStatuses, error)
+
+	// GetWorkflowScheme returns nil if the workflow scheme can't be
+	// read with the credentials.
+	GetWorkflowScheme(projectID string) (*client.WorkflowScheme, error)
+	GetWorkflowTransitions(workflow string) ([]client.WorkflowTransition, error)
+}
+
+// WorkflowStore is implemented by stores recording the workflows
+// (e.g. `store.PGStore`).
+type WorkflowStore interface {
+	ReplaceWorkflows(w store.Workflows, at time.Time) error
+}
+
+// syncWorkflows fetches the issue types, statuses and workflows of
+// the projects and replaces those in the store, if the client can
+// fetch them and the store can record them. The projects are
+// restricted to those of the client's filter, if any. The records
+// are left unchanged if fetching any of them fails, so they are
+// never partially replaced, which would be recorded as workflow
+// changes.
+//
+// Workflow schemes can only be read by administrators: if they
+// can't be read, only the statuses are synced.
+func syncWorkflows(c Client, s store.Store) {
+	wf, ok := unfiltered(c).(WorkflowsFetcher)
+	if !ok {
+		return
+	}
+	ws, ok := s.(WorkflowStore)
+	if !ok {
+		return
+	}
+	at := time.Now()
+	projects, err := wf.GetProjects()
+	if err != nil {
+		logging.Errorf("Could not sync the workflows: %s", err)
+		return
+	}
+	var wanted map[string]bool
+	if fc, ok := c.(*filteredClient); ok && len(fc.filter.Projects) > 0 {
+		wanted = toSet(fc.filter.Projects)
+	}
+
+	var w store.Workflows
+	statusNames := make(map[string]string)
+	var workflowNames []string
+	seen := make(map[string]bool)
+	for _, p := range projects {
+		if wanted != nil && !wanted[p.Key] {
+			continue
+		}
+		its, err := wf.GetProjectStatuses(p.Key)
+		if err != nil {
+			logging.Errorf("Could not sync the workflows: %s", err)
+			return
+		}
+		for _, it := range its {
+			for _, st := range it.Statuses {
+				statusNames[st.ID] = st.Name
+				w.Statuses = append(w.Statuses, store.ProjectStatus{
+					ProjectKey:     p.Key,
+					IssueType:      it.Name,
+					StatusID:       st.ID,
+					StatusName:     st.Name,
+					StatusCategory: st.StatusCategory.Key,
+				})
+			}
+		}
+
+		scheme, err := wf.GetWorkflowScheme(p.ID)
+		if err != nil {
+			logging.Errorf("Could not sync the workflows: %s", err)
+			return
+		}
+		if scheme == nil {
+			continue
+		}
+		for _, it := range its {
+			workflow, ok := scheme.IssueTypeMappings[it.ID]
+			if !ok {
+				workflow = scheme.DefaultWorkflow
+			}
+			w.Workflows = append(w.Workflows, store.ProjectWorkflow{
+				ProjectKey:     p.Key,
+				IssueType:      it.Name,
+				WorkflowScheme: scheme.Name,
+				Workflow:       workflow,
+			})
+			// A workflow is usually shared by several issue types
+			// and projects
+			if !seen[workflow] {
+				seen[workflow] = true
+				workflowNames = append(workflowNames, workflow)
+			}
+		}
+	}
+
+	for _, workflow := range workflowNames {
+		transitions, err := wf.GetWorkflowTransitions(workflow)
+		if err != nil {
+			logging.Errorf("Could not sync the workflows: %s", err)
+			return
+		}
+		for _, t := range transitions {
+			wt := store.WorkflowTransition{
+				Workflow:     workflow,
+				TransitionID: t.ID,
+				Name:         t.Name,
+				ToStatus:     statusName(statusNames, t.To),
+			}
+			if len(t.From) == 0 {
+				w.Transitions = append(w.Transitions, wt)
+				continue
+			}
+			for _, from := range t.From {
+				name := statusName(statusNames, from)
+				wt.FromStatus = &name
+				w.Transitions = append(w.Transitions, wt)
+			}
+		}
+	}
+
+	if err := ws.ReplaceWorkflows(w, at); err != nil {
+		logging.Errorf("Could not store the workflows: %s", err)
+		return
+	}
+	logging.Infof("Synced %d project statuses, %d workflows and %d transitions", len(w.Statuses), len(workflowNames), len(w.Transitions))
+}
+
+// statusName returns the name of the status specified by its ID, or
+// the ID if the status is not used by any synced project.
+func statusName(names map[string]string, id string) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return id
+}
+
+// toSet returns the values as a set.
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
@@ -0,0 +1,199 @@
+// Package logging writes the logs of the application with a level
+// and structured fields, as text or JSON lines (see `Configure`). The
+// secrets and email addresses are redacted from the lines (see
+// `Redact`).
+//
+// The lines are written with the standard logger, so its output can
+// still be redirected (e.g. tee'd by `telemetry.Init` to attach the
+// last lines to the reported errors).
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the level of a log line. Lines below the level set with
+// `Configure` are discarded.
+type Level int
+
+// The levels of the log lines, by increasing severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the name (e.g. "debug"), "warning"
+// being accepted for `LevelWarn`.
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(name)
+	if name == "warning" {
+		return LevelWarn, nil
+	}
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level `%s` (expected one of %s)", name, strings.Join(levelNames, ", "))
+}
+
+// The formats of the log lines.
+//
+// Text lines are prefixed by the standard logger (date and time),
+// followed by the level, the message and the fields as `key=value`:
+//
+//	2019/03/04 10:00:00 INFO Sync progress discovered=120 inserted=80
+//
+// JSON lines are objects with the `time`, `level` and `msg` keys and
+// the fields:
+//
+//	{"discovered":120,"inserted":80,"level":"info","msg":"Sync progress","time":"2019-03-04T10:00:00Z"}
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Fields are the structured data attached to a log line, e.g. the
+// key of the issue it's about.
+type Fields map[string]interface{}
+
+var (
+	mutex    sync.RWMutex
+	minLevel = LevelInfo
+	format   = FormatText
+)
+
+// Configure sets the minimum level of the lines written and their
+// format (`FormatText` or `FormatJSON`). The flags of the standard
+// logger are reset to `log.LstdFlags` for text lines, and cleared for
+// JSON lines, which hold their time.
+func Configure(level Level, f string) error {
+	switch f {
+	case FormatText:
+		log.SetFlags(log.LstdFlags)
+	case FormatJSON:
+		log.SetFlags(0)
+	default:
+		return fmt.Errorf("unknown log format `%s` (expected `%s` or `%s`)", f, FormatText, FormatJSON)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	minLevel, format = level, f
+	return nil
+}
+
+// Enabled returns true if the lines of the level are written, e.g.
+// to skip computing the fields of debug lines.
+func Enabled(level Level) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return level >= minLevel
+}
+
+// Entry is a log line being built, with its fields.
+type Entry struct {
+	fields Fields
+}
+
+// WithFields returns an entry writing lines with the fields.
+func WithFields(f Fields) *Entry {
+	return &Entry{fields: f}
+}
+
+// Debugf writes a debug line, formatted like `fmt.Printf`.
+func Debugf(format string, v ...interface{}) { write(LevelDebug, nil, format, v) }
+
+// Infof writes an info line, formatted like `fmt.Printf`.
+func Infof(format string, v ...interface{}) { write(LevelInfo, nil, format, v) }
+
+// Warnf writes a warning line, formatted like `fmt.Printf`.
+func Warnf(format string, v ...interface{}) { write(LevelWarn, nil, format, v) }
+
+// Errorf writes an error line, formatted like `fmt.Printf`.
+func Errorf(format string, v ...interface{}) { write(LevelError, nil, format, v) }
+
+// Debugf writes a debug line with the entry's fields.
+func (e *Entry) Debugf(format string, v ...interface{}) { write(LevelDebug, e.fields, format, v) }
+
+// Infof writes an info line with the entry's fields.
+func (e *Entry) Infof(format string, v ...interface{}) { write(LevelInfo, e.fields, format, v) }
+
+// Warnf writes a warning line with the entry's fields.
+func (e *Entry) Warnf(format string, v ...interface{}) { write(LevelWarn, e.fields, format, v) }
+
+// Errorf writes an error line with the entry's fields.
+func (e *Entry) Errorf(format string, v ...interface{}) { write(LevelError, e.fields, format, v) }
+
+// write writes the line with the standard logger, if the level is
+// enabled, redacted (see `Redact`).
+func write(level Level, fields Fields, f string, v []interface{}) {
+	mutex.RLock()
+	enabled, jsonFormat := level >= minLevel, format == FormatJSON
+	mutex.RUnlock()
+	if !enabled {
+		return
+	}
+	msg := strings.TrimSuffix(fmt.Sprintf(f, v...), "\n")
+	if jsonFormat {
+		log.Print(Redact(jsonLine(level, fields, msg)))
+		return
+	}
+	log.Print(Redact(textLine(level, fields, msg)))
+}
+
+func jsonLine(level Level, fields Fields, msg string) string {
+	obj := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		obj[k] = v
+	}
+	obj["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	obj["level"] = level.String()
+	obj["msg"] = msg
+	b, err := json.Marshal(obj)
+	if err != nil {
+		// A field can't be marshalled, the line is written without
+		// the fields
+		b, _ = json.Marshal(map[string]interface{}{"time": obj["time"], "level": obj["level"], "msg": msg})
+	}
+	return string(b)
+}
+
+func textLine(level Level, fields Fields, msg string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := fmt.Sprint(fields[k])
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	return b.String()
+}
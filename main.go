@@ -1,18 +1,46 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/rchampourlier/kaizenizer-source-jira/api"
+	"github.com/rchampourlier/kaizenizer-source-jira/buildinfo"
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/daemon"
+	"github.com/rchampourlier/kaizenizer-source-jira/export"
+	"github.com/rchampourlier/kaizenizer-source-jira/generate"
 	"github.com/rchampourlier/kaizenizer-source-jira/jira"
 	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
 	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/metrics"
+	"github.com/rchampourlier/kaizenizer-source-jira/outbound"
+	"github.com/rchampourlier/kaizenizer-source-jira/projection"
+	"github.com/rchampourlier/kaizenizer-source-jira/report"
 	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/support"
+	"github.com/rchampourlier/kaizenizer-source-jira/telemetry"
+	"github.com/rchampourlier/kaizenizer-source-jira/webhook"
 )
 
-const poolSize = 10
+// defaultConcurrency is the number of issues fetched concurrently
+// by syncs, unless set with `--concurrency`.
+const defaultConcurrency = 10
 
 // MaxOpenConns defines the maximum number of open connections
 // to the DB.
@@ -20,23 +48,151 @@ const MaxOpenConns = 5 // for Heroku Postgres
 
 // Main program
 //
-// ### reset
+// ### reset --force
 //
-// Initializes the connected database. Drops the existing tables if
-// exist and create new ones according to the necessary schema. It
-// then performs a full sync.
+// Drops the existing tables, including the indexes, views and
+// grants added on top of them, creates new ones according to the
+// necessary schema, then performs a full sync. Destructive, so
+// `--force` is required. To initialize the DB or upgrade its schema,
+// use `migrate up` instead.
 //
-// ### sync
+// ### init
 //
-// Performs an incremental sync, only fetching issues updated after
-// the maximum `updated_at` of issues already stored in the application.
+// Onboards a Jira instance interactively: asks its URL and the
+// credentials (unless set in the environment) and checks them, lets
+// the user choose the projects to sync, and scans a sample of their
+// issues to suggest the custom fields to map and the story points
+// to use as estimates. Then writes the configuration file
+// (`CONFIG_PATH`), and creates the schema and runs a trial sync of
+// 100 issues (or `--limit`) if the user agrees.
+//
+// ### init-db, drop-db
+//
+// Same as `migrate up` and `cleanup`: create or upgrade the schema,
+// and drop the tables of the store.
+//
+// ### check
+//
+// Checks that the configuration loads, that the credentials of Jira
+// are accepted, and that the DB is reachable (reporting whether its
+// schema is up to date), without waiting for it. Exits with status 1
+// if a check fails, e.g. to validate a deployment.
+//
+// ### support-bundle [<path>] [--logs <path1,path2>]
+//
+// Writes a support bundle to attach to a support request: a tarball
+// (`support-bundle-<time>.tar.gz` by default) of the build, the
+// environment, the configuration without its credentials, the schema
+// version and the last sync runs, and the end of the log files and of
+// the `--debug-http` log, if any. The secrets and email addresses are
+// redacted. It's written even if the DB is not reachable, the
+// information which couldn't be gathered being listed in
+// `errors.txt`.
+//
+// ### sync [--incremental | --full [--resume]] [--max-duration <duration>]
+//
+// Performs an incremental sync (the default, or with `--incremental`), only fetching issues updated since
+// the start of the last successful sync (recorded in `sync_runs`),
+// or after the maximum `updated_at` of issues already stored in the
+// application if no sync was recorded.
+//
+// With `--full`, fetches all issues again without dropping the
+// tables. With `--full --resume`, resumes the last full sync if it
+// didn't finish, skipping the issues it already stored. The issues
+// of the store not found by an unfiltered full sync are marked as
+// deleted (`issue_deleted_at`).
 //
 // NB: the incremental sync will fail if started from an empty database.
 //
+// On SIGINT or SIGTERM, the syncs stop fetching issues, store the
+// ones already fetched and exit (see `handleShutdown`).
+//
+// With `--max-duration` (e.g. `50m`), the sync stops the same way
+// once the duration is over, and exits successfully, so it fits a
+// scheduler's window. A full sync then resumes the last one if it
+// didn't finish, as with `--resume`, so each run continues the
+// previous one.
+//
+// `reset`, `sync`, `daemon` and `realtime` record the lineage of the
+// columns filled by the mapping in `field_lineage` when starting.
+//
+// `reset` and `sync` check the assertions configured in `assertions`
+// (see `config.Assertion`) when they complete, logging the number of
+// records violating each one, and exit with status 1 if an assertion
+// of the `fail` level is violated.
+//
+// ### assert
+//
+// Checks the assertions configured in `assertions`, like at the end
+// of the syncs.
+//
 // ### sync-issue <issue key>
 //
 // Synchronizes only the issue specified by the passed key.
 //
+// Like the other commands taking an issue key, it accepts the
+// previous keys of the renamed projects (see
+// `mapping.project_key_renames`).
+//
+// ### explain-issue <issue key>
+//
+// Fetches the issue from Jira like a sync does (with the mapper of
+// its source) and prints its mapped state and events, like
+// `map-issue`, without storing them. Does not need the DB.
+//
+// ### sync --output <dir> [--format jsonl|csv], sync-issue <issue key> --output <dir>
+//
+// Fetches and maps the issues like a full sync (or `sync-issue`),
+// but writes the states, events and links to files in `dir` instead
+// of the DB (see `store.FileStore`), as newline-delimited JSON (the
+// default) or CSV. No DB is needed.
+//
+// ### resync --where <predicate>
+//
+// Synchronizes again the issues whose state matches the SQL
+// predicate on the columns of `jira_issues_states`, e.g.
+// `--where "issue_status IS NULL"`, to repair the issues affected by
+// a mapping gap once fixed.
+//
+// ### backfill changelogs
+//
+// Synchronizes again the issues whose changelog seems truncated
+// (`issue_changelog_truncated`, see
+// `mapping.changelog_truncation_threshold`), fetching their whole
+// changelog from the changelog endpoint of each issue.
+//
+// ### backfill sprint-reports
+//
+// Fetches again the sprint reports of Jira Agile for all the closed
+// sprints, e.g. after fixing the records of their issues. The reports
+// of the sprints closed since the last sync are fetched at the end of
+// each sync.
+//
+// ### backfill sprint-goals
+//
+// Evaluates again the goals of all the closed sprints (see
+// `metrics.sprint_goal_query`), e.g. after changing the query. The
+// goals of the sprints closed since the last sync are evaluated at
+// the end of each sync.
+//
+// ### verify [--sample <n> [--seed <n>] | --full] [--csv <file>]
+//
+// Compares the status, assignee and update time of a sample of the
+// issues of Jira (100 by default, or all of them with `--full`) with
+// their state in `jira_issues_states`, without modifying the store.
+// Prints the number of issues missing from the store, stale (updated
+// in Jira since), or whose status or assignee differ, and with
+// `--full` the issues of the store not found in Jira. The drifts are
+// written to the CSV `file` if set. The issues updated since the
+// last sync are not compared. Exits with status 1 if there is drift.
+//
+// ### import <file>
+//
+// Imports the raw issues of the JSON file (`-` for the standard
+// input), e.g. from Jira Cloud's "export all data", mapping them
+// like the synced issues (see `jira.ImportIssues`). Also supported
+// with `--output` and the SQLite backend.
+//
 // ### explore-raw-issue
 //
 // Displays the raw issue as fetched from Jira.
@@ -50,77 +206,2322 @@ const MaxOpenConns = 5 // for Heroku Postgres
 //
 // Drops all store tables and indexes used by this source.
 //
+// ### gc events [--relink] [--dry-run]
+//
+// Deletes the events whose issue is not in `jira_issues_states`
+// anymore (e.g. after purges or key changes). With `--relink`, the
+// events of a previous key of an issue in the store are moved to its
+// current key instead. With `--dry-run`, only prints the number of
+// events which would be processed.
+//
+// If project keys were renamed (`mapping.project_key_renames`), the
+// records of the issues stored with a previous key while also stored
+// with the current one are deleted first.
+//
+// ### erase person-data --account <id>
+//
+// Anonymizes the records referencing the user with the account ID
+// (see `export person-data`), to answer a GDPR erasure request: the
+// account ID and names are replaced with a pseudonym, printed, and
+// the user is deleted from `jira_users`. The next sync of the issues
+// rewrites their records, so the user must be erased from Jira too.
+//
+// ### analyze [--full]
+//
+// Computes metrics (e.g. lead time, cycle time) from the events in
+// the store and writes them to the `jira_issue_metrics` table. See
+// `config.Metrics` to configure how statuses are classified. Only
+// the metrics of the issues written since the last computation are
+// computed again, unless the settings changed or with `--full`
+// (same as `projections rebuild metrics`).
+//
+// ### projections list
+//
+// Lists the projections (see package `projection`) and their tables,
+// including the custom ones registered with `projection.Register`.
+//
+// ### projections rebuild [<name>...]
+//
+// Rebuilds the projections with the names, or all of them, from the
+// ingested events and states. The built-in projections are
+// `metrics` and `wip-aging` (the daily snapshots of the issues in
+// progress, in `jira_wip_aging`).
+//
+// ### load-teams
+//
+// Loads the teams file (`TEAMS_PATH`, defaults to `teams.yml`)
+// mapping people to teams and tribes over time into the
+// `team_memberships` table, replacing the existing records.
+//
+// ### report cycles
+//
+// Lists the circular blocking dependencies between issues (A blocks
+// B which blocks A), using the links in `jira_issue_links`.
+//
+// ### report cycle-time --explain <issue key>
+//
+// Prints the status changes of the issue used to compute its cycle
+// time, how each one is classified, and the resulting interval, to
+// understand a surprising value.
+//
+// ### report capacity --team <team> [--weeks <n>]
+//
+// Prints the load of each member of the team per week as CSV, over
+// the last 12 weeks by default: their average WIP (from the
+// assignee intervals), the issues assigned and resolved, the
+// throughput over 4 weeks, and the weeks of work it represents.
+//
+// ### report duplicates [--threshold <n>] [--cross-project]
+//
+// Prints the pairs of open issues probably duplicating each other
+// as CSV: those whose summaries are similar (trigram similarity of
+// at least 0.5 by default) and those linked as duplicates. With
+// `--cross-project`, only the pairs of issues of different projects.
+//
+// ### report sprints
+//
+// Prints the figures of the sprint reports of Jira (completed, not
+// completed and added issues, completed points) next to those
+// computed from the records, and their differences, as CSV (see
+// `jira_sprint_reconciliation`). Exits with an error if the issue
+// counts of a sprint differ beyond the `error` threshold of
+// `sprint_reconciliation`, like the syncs.
+//
+// ### report carryover --board <id> [--format csv|json]
+//
+// Prints, for each closed sprint of the board, the issues and points
+// which were in it, those unfinished when it was completed, and those
+// carried over to the next sprint of the board, from the sprint
+// membership history, as CSV (the default) or JSON.
+//
+// Reports are read from the DB specified by `READ_DB_URL` (e.g. a
+// read replica) if set.
+//
+// ### comments reveal <issue key>
+//
+// Prints the comments of the issue stored in the comment vault,
+// decrypted with `COMMENT_VAULT_KEY`, to audit a thread (see below).
+//
+// ### search [--limit <n>] <query>
+//
+// Prints the issues whose summary or description match the query,
+// and the comments matching it, by decreasing relevance (20 at most
+// by default), e.g. `search '"payment failed" -refund'`. Requires
+// `db.full_text_search` (see `store.PGStore.SetFullTextSearch`).
+//
+// ### export demo <dir>
+//
+// Exports an obfuscated copy of the issue states, events and links
+// to CSV files in `dir`, partitioned by project and month, for demos
+// and public benchmarks. Keys, names and texts are replaced and times
+// shifted, preserving relationships between records and durations.
+//
+// ### export snapshot <path> [--format sqlite] [--anonymize]
+//
+// Writes a read-only snapshot of the current issue states and metrics
+// tables to a single SQLite DB file at `path`, to be shared with
+// stakeholders or opened with Datasette. With `--anonymize`, the
+// values are obfuscated as by `export demo`. Requires the application
+// to be built with `-tags sqlite`.
+//
+// ### export ml <path> [--anonymize]
+//
+// Writes a fixed-schema feature vector per issue (counts of events,
+// durations, text lengths and the encoded sequence of statuses) to a
+// Parquet file at `path`, to train machine learning models. With
+// `--anonymize`, the keys and projects are obfuscated and the times
+// shifted as by `export demo`.
+//
+// ### export person-data --account <id> <path> [--since <date>] [--until <date>]
+//
+// Writes the records referencing the user with the account ID
+// (authored events and comments, assignments, mentions, team
+// memberships) to a JSON file at `path`, by table, to answer a GDPR
+// access request. With `--since` and `--until` (YYYY-MM-DD, `until`
+// excluded), only the records of this period are written.
+//
+// ### migrate up
+//
+// Creates the schema if the DB has none, or applies the changes of
+// the schema which are pending (see `store.SchemaChanges`), each one
+// in a transaction recorded in `schema_migrations`, and adds the
+// columns of the custom fields added to `mapping.custom_fields`,
+// the foreign keys of the strict schema if `db.strict_schema` is
+// set, and the columns of the full-text search if
+// `db.full_text_search` is set. Existing tables and records are
+// kept.
+//
+// ### migrate status
+//
+// Lists the changes of the schema, applied (with their date) or
+// pending.
+//
+// ### migrate plan
+//
+// Prints the statements migrating the DB's schema, from the version
+// recorded in `schema_migrations`, to the schema of this version
+// of the application, so they can be reviewed before being applied.
+// The columns of the custom fields added to `mapping.custom_fields`
+// the missing foreign keys of the strict schema and columns of the
+// full-text search are added too.
+// The statements are not run.
+//
+// ### daemon
+//
+// Performs an incremental sync every `SYNC_INTERVAL` (e.g. `10m`,
+// defaults to 10 minutes), skipped if another run holds the sync
+// lock (see `--wait-lock`). Admin endpoints are served on
+// `ADMIN_ADDR` (defaults to `localhost:8081`) to control the daemon:
+//
+//   - `GET /status`: reports the current state
+//   - `POST /pause`, `POST /resume`: pause and resume the syncs
+//   - `POST /sync`: triggers an immediate sync
+//
+// ### webhooks (or serve)
+//
+// Receives the events of Jira webhooks on `POST /webhook`, served
+// on `WEBHOOK_ADDR` (defaults to `localhost:8082`). Each created or
+// updated issue is synchronized, and its number of watchers is
+// recorded in `jira_issue_watchers_daily`. The records of deleted
+// issues are deleted once Jira confirms the issues don't exist
+// anymore, and the issues whose comments are created, updated or
+// deleted are synchronized.
+//
+// ### webhooks register --url <url> [--name <name>] [--jql <query>]
+//
+// Creates the Jira webhook posting the events handled by `webhooks`
+// to the URL (e.g. `https://agilizer.example.com/webhook`), or
+// updates the webhook of the same name (`kaizenizer-source-jira` by
+// default), so deploying the receiver needs no manual configuration
+// in Jira's administration. The events are restricted to the issues
+// matching the JQL query, or the filter flags, and signed with
+// `WEBHOOK_SECRET` if set, the receiver then rejecting the events
+// not signed with it. Requires administrator credentials.
+//
+// ### realtime
+//
+// Combines `webhooks` and `daemon`: issues are synchronized when
+// webhook events are received, and a reconciliation sync of the
+// issues updated during the last two intervals runs every
+// `RECONCILE_INTERVAL` (defaults to 1 hour) to catch up with missed
+// webhook deliveries. The admin endpoints of the daemon control the
+// reconciliation syncs.
+//
+// ### api
+//
+// Serves read-only queries on `API_ADDR` (defaults to
+// `localhost:8083`), using the read-only DB if `READ_DB_URL` is set:
+//
+//   - `GET /event-counts?project=<name>|epic=<key>[&days=<n>][&to=<day>]`:
+//     number of events of each kind per day, e.g. for sparklines
+//   - `GET /search?q=<query>[&limit=<n>]`: issues and comments
+//     matching the query, if `db.full_text_search` is set
+//
+// ### Spooling writes while the DB is unreachable
+//
+// In the `daemon`, `webhooks` and `realtime` actions, the writes of
+// issues and watch counts are spooled to the file at `SPOOL_PATH`
+// (defaults to `spool.jsonl`) while the DB is unreachable, and
+// replayed once it's reachable again, on the next write or every
+// `SPOOL_FLUSH_INTERVAL` (defaults to 30 seconds).
+//
+// ### Stalled syncs
+//
+// A sync processing no issue for `STALL_TIMEOUT` (defaults to 15
+// minutes, `0` to disable) logs the issues being processed and a
+// dump of the goroutines. With `STALL_RESTART=true`, the workers also
+// give up the issues they are stuck on and move on to the next ones.
+//
+// ### Comment vault
+//
+// If `COMMENT_VAULT_KEY` is set (32 bytes, base64-encoded, e.g.
+// generated with `openssl rand -base64 32`), the bodies of the
+// comments are encrypted with it and stored in `jira_comment_vault`
+// instead of `jira_issues_events`, which only keeps their length,
+// author and time.
+//
+// ### map-issue
+//
+// Reads a raw Jira issue JSON from stdin and prints the mapped
+// state and events. Does not need Jira nor the DB.
+//
+//     go run main.go map-issue < issue.json
+//
+// ### version [--json]
+//
+// Prints the version and commit of the application (embedded at
+// build time, see package `buildinfo`), and the versions of the
+// mapping and of the schema it produces. The same build is recorded
+// with each sync run in `sync_runs`.
+//
+// ### event-kinds
+//
+// Lists the kinds of events stored in `jira_issues_events` with
+// their description.
+//
+// ### generate testdata [--issues <n>] [--seed <n>] [--end <date>] [--out <dir>] [--reset]
+//
+// Generates synthetic issues with realistic changelogs (500 by
+// default) and stores their records in the DB, replacing those of
+// the same keys, or writes them as Jira API JSON fixtures to `dir`
+// with `--out` (the DB is not needed then). The tables are dropped
+// and created first with `--reset`. The same seed (defaults to 1)
+// and end date (`YYYY-MM-DD`, defaults to today) always generate the
+// same issues.
+//
+// ### benchmark store [--strategy <s>] [--events <n>] [--batch-size <n>] [--rate <n>]
+//
+// Writes synthetic events to the DB with each strategy (`single`,
+// `batch` or `copy`, or only the one passed with `--strategy`) and
+// reports their throughput and the latency of the writes, to choose
+// an ingestion strategy fitting the DB. Writes 10000 events as fast
+// as possible by default, `--rate` setting a target number of events
+// per second. The events are deleted afterwards.
+//
+// ## Error reporting
+//
+// Panics (in the main goroutine) and fatal errors are reported to
+// Sentry if `SENTRY_DSN` is set, or posted as JSON to
+// `ERROR_WEBHOOK_URL` if set, with the context of the run (action,
+// arguments, host, last log lines).
+//
+// ## Flags
+//
+// The flags below are accepted by all the actions, anywhere in the
+// arguments. The other flags are those of the action (see `--help`),
+// passed before or after its arguments: an action passed a flag it
+// doesn't accept prints its help and exits with status 2.
+//
+// ### --help, -h
+//
+// Prints the usage of the action, e.g. `sync --help`, or the list of
+// the actions. `help <action>` is the same as `<action> --help`.
+//
+// ### --debug-http
+//
+// Records each request to Jira API and its response (without
+// credentials) in `jira-http.log`, rotated every 10 MB.
+//
+// ### --concurrency <n>
+//
+// Sets the number of issues fetched concurrently by syncs (10 by
+// default). The rate of requests is still limited by
+// `JIRA_MAX_REQUESTS_PER_SECOND`.
+//
+// ### --log-level <level>, --log-format text|json
+//
+// Sets the minimum level of the logged lines (`debug`, `info`,
+// `warn` or `error`, `info` by default) and their format (`text` by
+// default, or `json` for one JSON object per line). The requests to
+// Jira API are logged at the `debug` level. Syncs and imports log
+// their progress every 10 seconds.
+//
+// ### --no-log-redaction
+//
+// Disables the redaction of the logs, the error reports and the
+// payloads recorded by `--debug-http`: by default, the passwords of
+// the DSNs, the tokens and other secrets are replaced with
+// `[REDACTED]` and the email addresses with `[EMAIL]`, so they can be
+// shared safely (see `logging.Redact`).
+//
+// ### --wait-lock
+//
+// Makes `reset`, `sync` and `resync` wait for the sync lock held by
+// another run instead of exiting. Runs writing to the same schema
+// hold the lock named `SYNC_LOCK_NAME` (defaults to `sync`) while
+// they run, so overlapping runs (e.g. a cron sync starting while a
+// slow one is still running) don't interleave their writes.
+//
+// ### --projects, --labels, --components, --issue-types
+//
+// Restrict `reset`, `sync` and `daemon` to the issues having one of
+// the comma-separated values, e.g. `--labels security` or
+// `--issue-types=Bug,Incident`. The filters are combined with `AND`.
+// A warning is logged for each project the credentials can't browse.
+//
+// ### --limit <n>
+//
+// Restricts `reset`, `sync` and `resync` to the first `n` issues
+// found, fetched, mapped and stored like the others, to smoke-test a
+// configuration change in minutes. A sync stopped at the limit is
+// not recorded as successful (see `jira.NewLimitedClient`).
+//
 func main() {
-	if len(os.Args) < 2 {
-		usage()
+	defer telemetry.Recover()
+
+	// The global flags are removed from the arguments before the
+	// context of the run is captured, so its action is the command
+	logLevel, logFormat := extractFlagValue("--log-level"), extractFlagValue("--log-format")
+	logRedaction := !extractFlag("--no-log-redaction")
+	debugHTTP = extractFlag("--debug-http")
+	concurrency, limitValue := extractFlagValue("--concurrency"), extractFlagValue("--limit")
+	filter = jira.Filter{
+		Projects:   splitList(extractFlagValue("--projects")),
+		Labels:     splitList(extractFlagValue("--labels")),
+		Components: splitList(extractFlagValue("--components")),
+		IssueTypes: splitList(extractFlagValue("--issue-types")),
+	}
+	telemetry.Init(errorReporter(), os.Args)
+
+	configureLogging(logLevel, logFormat)
+	logging.SetRedaction(logRedaction)
+	poolSize = defaultConcurrency
+	if v := concurrency; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			telemetry.Fatalln(fmt.Errorf("invalid `--concurrency`: %s", v))
+		}
+		poolSize = n
+	}
+	if v := limitValue; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			telemetry.Fatalln(fmt.Errorf("invalid `--limit`: %s", v))
+		}
+		limit = n
+	}
+	jira.StallTimeout = envDuration("STALL_TIMEOUT", 15*time.Minute)
+	jira.RestartStalledWorkers = os.Getenv("STALL_RESTART") == "true"
+	conf = loadConfig()
+	jira.SprintGoalQuery = conf.Metrics.SprintGoalQuery
+	handleHelp()
+	c, args := parseCommand(os.Args[1:])
+
+	if dir := flagValue("output"); dir != "" {
+		syncToFiles(c.name, args, dir, flagValue("format"))
+		return
+	}
+
+	// Actions that don't need the DB
+	if c.run != nil {
+		c.run(args)
+		return
+	}
+
+	switch backend := conf.DB.Backend; backend {
+	case "", "postgres":
+	case "sqlite":
+		runSQLite(c.name, args)
+		return
+	default:
+		telemetry.Fatalln(fmt.Errorf("unknown `db.backend`: %s", backend))
 	}
 
 	db := openDB()
 	defer db.Close()
-	store := store.NewPGStore(db)
-	m := mapping.Mapper{}
+	store := newStore(db)
+	webhooks := outboundWebhooks(store)
+	defer webhooks.Close()
+	maintainProjections(store, webhooks)
+	c.runStore(store, args)
+}
 
-	switch os.Args[1] {
+// resetStore drops the tables, creates them again and performs a
+// full sync (`reset`).
+func resetStore(s *store.PGStore) {
+	if !boolFlag("force") {
+		telemetry.Fatalln(fmt.Errorf("`reset` drops all the tables, including the indexes, views and grants added on top of them: run it with `--force`, or use `migrate up` to upgrade the schema"))
+	}
+	as := assertions()
+	shutdown = handleShutdown()
+	defer lockSync(s)()
+	resetTables(s)
+	recordFieldLineage(s)
+	c, m := limitedSyncClient()
+	err := jira.PerformSync(shutdown, c, s, poolSize, m)
+	runAssertions(s, as)
+	checkSprints(s)
+	failOnSyncError("reset", err)
+}
 
-	case "reset":
-		store.DropTables()
-		store.CreateTables()
-		c := client.NewAPIClient()
-		jira.PerformSync(c, store, poolSize, &m)
+// syncStore performs the incremental or full sync of `sync`.
+func syncStore(s *store.PGStore) {
+	full := syncFull()
+	as := assertions()
+	shutdown = handleShutdown()
+	defer lockSync(s)()
+	recordFieldLineage(s)
+	maxDuration := flagValue("max-duration")
+	var cancel context.CancelFunc
+	shutdown, cancel = withMaxDuration(shutdown, maxDuration)
+	defer cancel()
+	c, m := limitedSyncClient()
+	var err error
+	switch {
+	case full && (boolFlag("resume") || maxDuration != ""):
+		err = jira.ResumeSync(shutdown, c, s, poolSize, m)
+	case full:
+		err = jira.PerformSync(shutdown, c, s, poolSize, m)
+	default:
+		err = jira.PerformIncrementalSync(shutdown, c, s, poolSize, m)
+	}
+	runAssertions(s, as)
+	checkSprints(s)
+	failOnSyncError("sync", err)
+}
 
-	case "sync":
-		c := client.NewAPIClient()
-		jira.PerformIncrementalSync(c, store, poolSize, &m)
+// runDaemonSyncs performs an incremental sync every
+// `SYNC_INTERVAL` (`daemon`).
+func runDaemonSyncs(s *store.PGStore) {
+	recordFieldLineage(s)
+	shutdown = handleShutdown()
+	c, m := withSources(newSyncClient())
+	ss := spoolingStore(s)
+	runDaemon(envDuration("SYNC_INTERVAL", 10*time.Minute), func() error {
+		return withSyncLock(s, func(ctx context.Context) error {
+			return jira.PerformIncrementalSync(ctx, c, ss, poolSize, m)
+		})
+	})
+}
 
-	case "sync-issue":
-		if len(os.Args) < 3 {
-			usage()
+// runRealtime receives the webhooks and reconciles the store every
+// `RECONCILE_INTERVAL` (`realtime`).
+func runRealtime(s *store.PGStore) {
+	recordFieldLineage(s)
+	shutdown = handleShutdown()
+	c, m := withSources(newSyncClient())
+	ss := spoolingStore(s)
+	go runWebhooks(webhook.NewReceiver(ss, func(issueKey string) {
+		if err := jira.PerformSyncForIssueKey(c, ss, issueKey, m); err != nil {
+			logging.Errorf("Error in webhook sync of `%s`: %s", issueKey, err)
 		}
-		c := client.NewAPIClient()
-		jira.PerformSyncForIssueKey(c, store, os.Args[2], &m)
+	}))
+	interval := envDuration("RECONCILE_INTERVAL", time.Hour)
+	runDaemon(interval, func() error {
+		return withSyncLock(s, func(ctx context.Context) error {
+			return jira.PerformReconciliationSync(ctx, c, ss, poolSize, m, 2*interval)
+		})
+	})
+}
 
-	case "explore-raw-issue":
-		if len(os.Args) < 3 {
-			usage()
+// receiveWebhooks synchronizes the issues of the events received
+// from Jira webhooks (`webhooks`).
+func receiveWebhooks(s *store.PGStore) {
+	c, m := withSources(newAPIClient())
+	ss := spoolingStore(s)
+	runWebhooks(webhook.NewReceiver(ss, func(issueKey string) {
+		if err := jira.PerformSyncForIssueKey(c, ss, issueKey, m); err != nil {
+			logging.Errorf("Error in webhook sync of `%s`: %s", issueKey, err)
 		}
-		client.NewAPIClient().ExploreRawIssue(os.Args[2])
+	}))
+}
 
-	case "explore-custom-fields":
-		if len(os.Args) < 3 {
-			usage()
+// withReadStore calls `f` with the store of the read-only DB (see
+// `openReadDB`), the DB of `s` if none is configured.
+func withReadStore(s *store.PGStore, f func(rs *store.PGStore)) {
+	readDB := openReadDB(s.DB)
+	if readDB != s.DB {
+		defer readDB.Close()
+	}
+	f(newStore(readDB))
+}
+
+// errorReporter returns the `telemetry.Reporter` used to report
+// panics and fatal errors: Sentry if `SENTRY_DSN` is set, a webhook
+// if `ERROR_WEBHOOK_URL` is set, none otherwise.
+func errorReporter() telemetry.Reporter {
+	if dsn := os.Getenv("SENTRY_DSN"); dsn != "" {
+		r, err := telemetry.NewSentryReporter(dsn)
+		if err != nil {
+			log.Fatalln(fmt.Errorf("error in `errorReporter`: %s", err))
 		}
-		client.NewAPIClient().ExploreCustomFields(os.Args[2])
+		return r
+	}
+	if url := os.Getenv("ERROR_WEBHOOK_URL"); url != "" {
+		return telemetry.NewWebhookReporter(url)
+	}
+	return nil
+}
 
-	case "cleanup":
-		store.DropTables()
+// debugHTTP is set with the `--debug-http` flag.
+var debugHTTP bool
 
-	default:
-		usage()
+// poolSize is the number of issues fetched concurrently by syncs,
+// set with the `--concurrency` flag.
+var poolSize int
+
+// limit is the maximum number of issues synced by the one-off
+// syncs, set with the `--limit` flag. Not limited if 0.
+var limit int
+
+// filter restricts the synced issues, set with the `--projects`,
+// `--labels`, `--components` and `--issue-types` flags.
+var filter jira.Filter
+
+// conf is the configuration, loaded once by `main`.
+var conf *config.Config
+
+// shutdown is done when the process is asked to shut down, for the
+// actions handling it (see `handleShutdown`).
+var shutdown = context.Background()
+
+// debugHTTPPath is the file where requests to Jira API are
+// recorded with `--debug-http`.
+const debugHTTPPath = "jira-http.log"
+
+// handleShutdown returns a context done when the process receives
+// SIGINT or SIGTERM (e.g. when a Kubernetes CronJob reaches its
+// deadline): the syncs then stop fetching issues, and flush their
+// batches and record their progress before exiting, instead of being
+// killed mid-insert. A second signal exits immediately.
+func handleShutdown() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logging.Warnf("Received %s, shutting down (send it again to exit immediately)", sig)
+		cancel()
+		<-signals
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// withMaxDuration returns a context done after the duration `v` of
+// the `--max-duration` flag (e.g. `50m`) to time-box the sync, or
+// `ctx` if the flag is not set.
+func withMaxDuration(ctx context.Context, v string) (context.Context, context.CancelFunc) {
+	if v == "" {
+		return ctx, func() {}
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		telemetry.Fatalln(fmt.Errorf("invalid `--max-duration`: %s", v))
+	}
+	logging.Infof("Sync time-boxed to %s", d)
+	return context.WithTimeout(ctx, d)
+}
+
+// configureLogging sets the level and format of the logs (see
+// `logging.Configure`), `info` and `text` if empty.
+func configureLogging(level, format string) {
+	l := logging.LevelInfo
+	if level != "" {
+		var err error
+		if l, err = logging.ParseLevel(level); err != nil {
+			telemetry.Fatalln(fmt.Errorf("invalid `--log-level`: %s", err))
+		}
+	}
+	if format == "" {
+		format = logging.FormatText
+	}
+	if err := logging.Configure(l, format); err != nil {
+		telemetry.Fatalln(fmt.Errorf("invalid `--log-format`: %s", err))
 	}
 }
 
-func usage() {
-	fmt.Printf(`Usage: go run main.go <action>
+// extractFlag returns true if the flag is present in the arguments
+// and removes it. Used for the global flags, accepted anywhere in
+// the arguments and removed before the flags of the action are
+// parsed (see `parseCommand`).
+func extractFlag(name string) bool {
+	for i, a := range os.Args {
+		if a == name {
+			os.Args = append(os.Args[:i], os.Args[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
 
-Available actions:
-  - reset
-  - sync
-  - sync-issue <issue-key>
-  - issue-to-xml <issue-key>
-  - explore-raw-issue <issue_key>
-  - explore-custom-fields <issue-key>
-  - cleanup
-`)
-	os.Exit(1)
+// extractFlagValue returns the value of the flag, passed either as
+// `--flag value` or `--flag=value`, and removes it from the
+// arguments. Returns an empty string if the flag is absent.
+func extractFlagValue(name string) string {
+	for i, a := range os.Args {
+		if strings.HasPrefix(a, name+"=") {
+			os.Args = append(os.Args[:i], os.Args[i+1:]...)
+			return strings.TrimPrefix(a, name+"=")
+		}
+		if a == name && i+1 < len(os.Args) {
+			v := os.Args[i+1]
+			os.Args = append(os.Args[:i], os.Args[i+2:]...)
+			return v
+		}
+	}
+	return ""
 }
 
-func openDB() *sql.DB {
-	//connStr := os.Getenv("DB_URL")
-	connStr := "user=agilizer password=password dbname=agilizer sslmode=disable"
-	db, err := sql.Open("postgres", connStr)
-	db.SetMaxOpenConns(MaxOpenConns)
+// splitList splits a comma-separated list, ignoring empty values.
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// newSyncClient returns the client used by syncs, restricted to the
+// issues matching `filter`. The projects of the filter the
+// credentials can't browse are skipped with a warning.
+func newSyncClient() jira.Client {
+	c := newAPIClient()
+	f, err := jira.CheckProjectPermissions(c, filter)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `newSyncClient`: %s", err))
+	}
+	return jira.NewFilteredClient(c, f)
+}
+
+// limitedSyncClient returns the client and mapper of the one-off
+// syncs (see `withSources`), whose searches are limited to the first
+// `limit` issues if set.
+func limitedSyncClient() (jira.Client, jira.Mapper) {
+	c, m := withSources(newSyncClient())
+	return jira.NewLimitedClient(c, limit), m
+}
+
+// newAPIClient returns a Jira API client for the instance of the
+// `jira` section of the config, recording the requests in
+// `debugHTTPPath` if `--debug-http` is set.
+func newAPIClient() *client.APIClient {
+	return newAPIClientFor(conf)
+}
+
+// newAPIClientFor is the same as `newAPIClient` for the passed
+// config, e.g. before it's written by `init`.
+func newAPIClientFor(cfg *config.Config) *client.APIClient {
+	j := cfg.Jira
+	o := client.Options{
+		Context: shutdown,
+		BaseURL: j.BaseURL,
+		Auth: client.Auth{
+			Type:         j.Auth.Type,
+			Username:     j.Auth.Username,
+			Password:     j.Auth.Password,
+			Token:        j.Auth.Token,
+			ClientID:     j.Auth.ClientID,
+			ClientSecret: j.Auth.ClientSecret,
+			RefreshToken: j.Auth.RefreshToken,
+			TokenURL:     j.Auth.TokenURL,
+			TokenFile:    j.Auth.TokenFile,
+		},
+		TLS: client.TLS{
+			CAPath:             j.TLS.CAPath,
+			CertPath:           j.TLS.CertPath,
+			KeyPath:            j.TLS.KeyPath,
+			InsecureSkipVerify: j.TLS.InsecureSkipVerify,
+		},
+		Proxy:           j.Proxy,
+		IssueProperties: cfg.Mapping.IssueProperties,
+		RenderedFields:  cfg.Mapping.RenderHTML,
+	}
+	if o.TLS.InsecureSkipVerify {
+		logging.Warnf("The certificate of Jira is not verified (`jira.tls.insecure_skip_verify`)")
+	}
+	if debugHTTP {
+		o.DebugHTTPPath = debugHTTPPath
+		logging.Infof("Recording requests to Jira API in %s", debugHTTPPath)
+	}
+	c, err := client.NewAPIClientWithOptions(o)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `newAPIClient`: %s", err))
+	}
+	return c
+}
+
+// resync synchronizes again the issues whose state matches the SQL
+// predicate, holding the sync lock.
+func resync(s *store.PGStore, predicate string) {
+	if predicate == "" {
+		usage()
+	}
+	keys, err := s.GetIssueKeysWhere(predicate)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `resync`: %s", err))
+	}
+	if len(keys) == 0 {
+		logging.Warnf("No issue matching `%s`", predicate)
+		return
+	}
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	shutdown = handleShutdown()
+	defer lockSync(s)()
+	c, m := withSources(newAPIClient())
+	failOnSyncError("resync", jira.PerformSyncForIssueKeys(shutdown, c, s, keys, poolSize, m))
+}
+
+// backfillChangelogs synchronizes again the issues whose changelog
+// seems truncated, with their whole changelog.
+func backfillChangelogs(s *store.PGStore) {
+	keys, err := s.GetIssueKeysWhere("issue_changelog_truncated")
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `backfill changelogs`: %s", err))
+	}
+	if len(keys) == 0 {
+		logging.Infof("No issue with a truncated changelog")
+		return
+	}
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	shutdown = handleShutdown()
+	c, m := withSources(newAPIClient())
+	failOnSyncError("backfill changelogs", jira.PerformChangelogBackfill(shutdown, c, s, keys, poolSize, m))
+}
+
+// defaultVerifySample is the number of issues compared by `verify`
+// without `--sample` nor `--full`.
+const defaultVerifySample = 100
+
+// verify compares a sample of the issues of Jira (or all of them
+// with `--full`) with their state in the store, printing a summary
+// of the drifts and writing them to the `--csv` file if set. Exits
+// with status 1 if there is drift.
+func verify(s *store.PGStore) {
+	o := jira.VerifyOptions{Sample: defaultVerifySample, Seed: time.Now().UnixNano()}
+	if v := flagValue("sample"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			telemetry.Fatalln(fmt.Errorf("invalid `--sample`: %s", v))
+		}
+		o.Sample = n
+	}
+	if v := flagValue("seed"); v != "" {
+		seed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			telemetry.Fatalln(fmt.Errorf("invalid `--seed`: %s", v))
+		}
+		o.Seed = seed
+	}
+	if boolFlag("full") {
+		o.Sample = 0
+	}
+	csvPath := flagValue("csv")
+	last, err := s.GetLastSyncStart([]string{jira.SyncKindFull, jira.SyncKindIncremental, jira.SyncKindReconciliation})
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `verify`: %s", err))
+	}
+	if last != nil {
+		o.SyncedAt = *last
+	}
+
+	shutdown = handleShutdown()
+	c, m := withSources(newSyncClient())
+	r, err := jira.Verify(shutdown, c, s, poolSize, m, o)
 	if err != nil {
-		log.Fatalln(fmt.Errorf("error in `openDB`: %s", err))
+		telemetry.Fatalln(fmt.Errorf("error in `verify`: %s", err))
+	}
+	fmt.Printf("Issues found in Jira:   %d\n", r.Found)
+	fmt.Printf("Issues checked:         %d (%d could not be fetched, %d updated since the last sync)\n", r.Checked, r.Failed, r.Pending)
+	fmt.Printf("Issues with drift:      %d\n", r.DriftedIssues())
+	for _, k := range []string{jira.DriftMissing, jira.DriftStale, jira.DriftStatus, jira.DriftAssignee, jira.DriftNotInJira} {
+		fmt.Printf("  %-21s %d\n", k+":", r.Count(k))
+	}
+	if csvPath != "" {
+		f, err := os.Create(csvPath)
+		if err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `verify`: %s", err))
+		}
+		err = r.WriteCSV(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `verify`: %s", err))
+		}
+	}
+	if len(r.Drifts) > 0 {
+		os.Exit(1)
+	}
+}
+
+// importIssues imports the issues of the file passed as argument, or
+// of the standard input if it's `-`.
+func importIssues(s store.Store, args []string) {
+	requireArgs(args, 1)
+	r := os.Stdin
+	if path := args[0]; path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `import`: %s", err))
+		}
+		defer f.Close()
+		r = f
+	}
+	m := newMapper()
+	if _, err := jira.ImportIssues(r, s, &m); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `import`: %s", err))
+	}
+}
+
+// resetTables drops the tables of the store and creates them again.
+func resetTables(s store.Store) {
+	if err := s.DropTables(); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `resetTables`: %s", err))
+	}
+	if err := s.CreateTables(); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `resetTables`: %s", err))
+	}
+}
+
+// assertions returns the assertions configured in `assertions`.
+func assertions() []store.Assertion {
+	var as []store.Assertion
+	for _, ca := range conf.Assertions {
+		a, ok := store.BuiltinAssertion(ca.Name)
+		switch {
+		case ca.Query != "":
+			a = store.Assertion{Name: ca.Name, Query: ca.Query}
+		case !ok:
+			telemetry.Fatalln(fmt.Errorf("error in `assertions`: unknown assertion `%s` (set its `query`)", ca.Name))
+		}
+		switch ca.Level {
+		case "", "warn":
+		case "fail":
+			a.Fail = true
+		default:
+			telemetry.Fatalln(fmt.Errorf("error in `assertions`: invalid level `%s` of `%s` (expected `warn` or `fail`)", ca.Level, ca.Name))
+		}
+		as = append(as, a)
+	}
+	return as
+}
+
+// runAssertions checks the assertions at the end of a sync, logging
+// the number of records violating each one, and exits with an error
+// if an assertion of the `fail` level is violated. Skipped if the
+// sync was interrupted.
+func runAssertions(s *store.PGStore, as []store.Assertion) {
+	if shutdown.Err() != nil {
+		return
+	}
+	var failed []string
+	for _, a := range as {
+		n, err := s.Violations(a)
+		switch {
+		case err != nil:
+			telemetry.Fatalln(fmt.Errorf("error checking assertion `%s`: %s", a.Name, err))
+		case n == 0:
+			logging.Infof("Assertion `%s` passed", a.Name)
+		case a.Fail:
+			logging.Errorf("Assertion `%s` failed: %d records violate it", a.Name, n)
+			failed = append(failed, a.Name)
+		default:
+			logging.Warnf("Assertion `%s` failed: %d records violate it", a.Name, n)
+		}
+	}
+	if len(failed) > 0 {
+		telemetry.Fatalln(fmt.Errorf("assertions failed: %s", strings.Join(failed, ", ")))
+	}
+}
+
+// checkSprints logs the sprints whose issue counts differ from those
+// of their sprint report of Jira beyond the thresholds of
+// `sprint_reconciliation`, and exits with an error if the `error`
+// threshold is exceeded. Skipped if no threshold is set or the sync
+// was interrupted.
+func checkSprints(s report.SprintsStore) {
+	cfg := conf.SprintReconciliation
+	switch {
+	case cfg.Warn < 0 || cfg.Error < 0:
+		telemetry.Fatalln(fmt.Errorf("error in `sprint_reconciliation`: thresholds can't be negative"))
+	case cfg.Warn == 0 && cfg.Error == 0:
+		return
+	case shutdown.Err() != nil:
+		return
+	}
+	rs, err := s.GetSprintReconciliations()
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error checking the sprint reconciliation: %s", err))
+	}
+	var failed []string
+	for _, d := range report.SprintDiscrepancies(rs, report.SprintThresholds{Warn: cfg.Warn, Error: cfg.Error}) {
+		msg := fmt.Sprintf("Sprint `%s` (%d) differs from its Jira sprint report: %s issues off by %.1f%%", d.SprintName, d.SprintID, d.Figure, d.Gap*100)
+		if d.Level == report.DiscrepancyError {
+			logging.Errorf("%s", msg)
+			failed = append(failed, d.SprintName)
+			continue
+		}
+		logging.Warnf("%s", msg)
+	}
+	if len(failed) > 0 {
+		telemetry.Fatalln(fmt.Errorf("sprint reconciliation failed: %s", strings.Join(failed, ", ")))
+	}
+}
+
+// printVersion prints the build of the application, as JSON if
+// `asJSON` is true.
+func printVersion(asJSON bool) {
+	info := buildinfo.Get()
+	if asJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(info); err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `version`: %s", err))
+		}
+		return
+	}
+	fmt.Printf("kaizenizer-source-jira %s\n", info.Version)
+	if info.Commit != "" {
+		fmt.Printf("commit:         %s\n", info.Commit)
+	}
+	if info.Date != "" {
+		fmt.Printf("built at:       %s\n", info.Date)
+	}
+	fmt.Printf("mapper version: %s\n", info.MapperVersion)
+	fmt.Printf("schema version: %d\n", info.SchemaVersion)
+	fmt.Printf("go version:     %s\n", info.GoVersion)
+}
+
+// syncFull returns true if the `sync` action performs a full sync
+// (`--full`), false for an incremental one (the default, or
+// `--incremental`).
+func syncFull() bool {
+	full, incremental := boolFlag("full"), boolFlag("incremental")
+	if full && incremental {
+		telemetry.Fatalln(fmt.Errorf("`sync` can't be both `--full` and `--incremental`"))
+	}
+	return full
+}
+
+// explainIssue fetches the issue from Jira like a sync does and
+// prints its mapped state and events, without storing them.
+func explainIssue(issueKey string) {
+	c, m := withSources(newAPIClient())
+	is, ies, err := jira.MapIssueForKey(c, issueKey, m)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `explain-issue`: %s", err))
+	}
+	if err = mapping.WriteMappedIssue(os.Stdout, mapping.MappedIssue{State: is, Events: ies}); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `explain-issue`: %s", err))
+	}
+}
+
+// check checks the configuration, and that Jira and the DB are
+// reachable with the configured credentials, printing the result of
+// each check. Exits with status 1 if a check fails.
+func check() {
+	failed := false
+	report := func(name string, err error, result string) {
+		if err != nil {
+			failed = true
+			fmt.Printf("FAIL  %-8s %s\n", name, err)
+			return
+		}
+		fmt.Printf("OK    %-8s %s\n", name, result)
+	}
+
+	// The configuration is loaded by `main`, which exits if it fails
+	report("config", nil, "loaded")
+
+	c := newAPIClient()
+	user, err := c.CurrentUser()
+	report("jira", err, fmt.Sprintf("authenticated as %s", user))
+
+	if conf.DB.Backend == "sqlite" {
+		report("db", checkSQLite(conf.DB.Path), "reachable")
+	} else {
+		version, err := checkPostgres()
+		switch {
+		case err != nil:
+			report("db", err, "")
+		case version < store.SchemaVersion:
+			report("db", nil, fmt.Sprintf("reachable, schema at version %d of %d (run `migrate up`)", version, store.SchemaVersion))
+		default:
+			report("db", nil, fmt.Sprintf("reachable, schema at version %d", version))
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// checkPostgres pings the Postgres DB, without waiting for it, and
+// returns the version of its schema.
+func checkPostgres() (int, error) {
+	cfg := conf.DB
+	db, err := sql.Open("postgres", postgresConnStr(connStr, cfg))
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err = db.PingContext(ctx); err != nil {
+		return 0, err
+	}
+	return store.NewPGStore(db).RecordedSchemaVersion()
+}
+
+// supportBundle writes the support bundle (see `support.WriteBundle`)
+// to the path, `support-bundle-<time>.tar.gz` if empty, with the
+// end of the log files and of the `--debug-http` log, if any. The
+// bundle is written even if the DB is not reachable.
+func supportBundle(path string, logPaths []string) {
+	now := time.Now()
+	if path == "" {
+		path = fmt.Sprintf("support-bundle-%s.tar.gz", now.UTC().Format("20060102T150405Z"))
+	}
+	o := support.Options{
+		Config:   conf,
+		LogPaths: append(logPaths, debugHTTPPath),
+		Now:      now,
+	}
+	if backend := o.Config.DB.Backend; backend == "" || backend == "postgres" {
+		db, err := sql.Open("postgres", postgresConnStr(connStr, o.Config.DB))
+		if err == nil {
+			defer db.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = db.PingContext(ctx)
+			cancel()
+		}
+		if err != nil {
+			o.Errors = append(o.Errors, fmt.Sprintf("db: %s", err))
+		} else {
+			o.Store = store.NewPGStore(db)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `support-bundle`: %s", err))
+	}
+	if err = support.WriteBundle(f, o); err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `support-bundle`: %s", err))
+	}
+	fmt.Printf("Support bundle written to %s\n", path)
+}
+
+// checkSQLite opens the SQLite DB at the path and pings it.
+func checkSQLite(path string) error {
+	if path == "" {
+		path = defaultSQLitePath
+	}
+	db, err := sql.Open(store.SQLiteDriver, path)
+	if err != nil {
+		return fmt.Errorf("the application must be built with `-tags sqlite`: %s", err)
+	}
+	defer db.Close()
+	return db.Ping()
+}
+
+func mapIssue() {
+	m := newMapper()
+	i, err := mapping.DecodeIssue(os.Stdin)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `map-issue`: %s", err))
+	}
+	if err = mapping.WriteMappedIssue(os.Stdout, m.MapIssue(i)); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `map-issue`: %s", err))
+	}
+}
+
+// analyze computes the metrics of the issues changed since they were
+// last computed, or of all of them if `full`.
+func analyze(s *store.PGStore, full bool) {
+	p := metricsProjection(s)
+	var err error
+	if full {
+		err = projection.Rebuild(context.Background(), []projection.Projection{p})
+	} else {
+		err = p.(projection.Refresher).Refresh(context.Background())
+	}
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `analyze`: %s", err))
+	}
+}
+
+// metricsProjection returns the projection of the metrics, with the
+// statuses classified as configured in `metrics`.
+func metricsProjection(s *store.PGStore) projection.Projection {
+	categories, err := s.GetStatusCategories()
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `metricsProjection`: %s", err))
+	}
+	return projection.NewMetrics(s, metrics.NewClassifier(conf.Metrics, categories), calendar(), conf.Metrics.PercentileWindow.Duration)
+}
+
+// calendar returns the business calendar of `metrics.calendar`, nil
+// if it's not set.
+func calendar() *metrics.Calendar {
+	cfg := conf.Metrics.Calendar
+	if cfg == nil {
+		return nil
+	}
+	c, err := metrics.NewCalendar(*cfg)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `metrics.calendar`: %s", err))
+	}
+	return c
+}
+
+// registeredProjections returns the custom projections registered
+// with `projection.Register`.
+func registeredProjections(s *store.PGStore) []projection.Projection {
+	ps, err := projection.Registered(s)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `registeredProjections`: %s", err))
+	}
+	return ps
+}
+
+// wipAgingProjection returns the projection of the daily snapshots
+// of the issues in progress.
+func wipAgingProjection(s *store.PGStore) projection.Projection {
+	return projection.NewWIPAging(s, conf.Metrics)
+}
+
+// maintainProjections passes the events written to the store to the
+// projections maintained during the syncs: the WIP aging and the
+// custom projections, if any. They're also passed to the outbound
+// webhooks, if any are configured (`d` not nil).
+func maintainProjections(s *store.PGStore, d *outbound.Dispatcher) {
+	ps := append([]projection.Projection{wipAgingProjection(s)}, registeredProjections(s)...)
+	h := projection.Handler(ps)
+	if d == nil {
+		s.SetEventHandler(h)
+		return
+	}
+	s.SetEventHandler(func(ie store.IssueEvent) error {
+		err := h(ie)
+		if derr := d.Handle(ie); err == nil {
+			err = derr
+		}
+		return err
+	})
+}
+
+// outboundWebhooks returns the dispatcher posting the events to the
+// webhooks of `outbound_webhooks`, nil if none is configured.
+func outboundWebhooks(s *store.PGStore) *outbound.Dispatcher {
+	webhooks := conf.OutboundWebhooks
+	if len(webhooks) == 0 {
+		return nil
+	}
+	d, err := outbound.NewDispatcher(webhooks, s)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `outbound_webhooks`: %s", err))
+	}
+	return d
+}
+
+// runProjections lists or rebuilds the projections.
+func runProjections(s *store.PGStore, action string, names []string) {
+	ps := append([]projection.Projection{metricsProjection(s), wipAgingProjection(s)}, registeredProjections(s)...)
+	switch action {
+	case "list":
+		for _, p := range ps {
+			fmt.Printf("%-20s %s\n", p.Name(), strings.Join(p.Tables(), ", "))
+		}
+	case "rebuild":
+		found, err := projection.Find(ps, names)
+		if err == nil {
+			err = projection.Rebuild(context.Background(), found)
+		}
+		if err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `projections rebuild`: %s", err))
+		}
+	default:
+		usage()
+	}
+}
+
+// commentVault returns the `CommentVault` using the base64-encoded
+// key.
+func commentVault(key string) *store.CommentVault {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("invalid `COMMENT_VAULT_KEY`: %s", err))
+	}
+	v, err := store.NewCommentVault(b)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("invalid `COMMENT_VAULT_KEY`: %s", err))
+	}
+	return v
+}
+
+// revealComments prints the comments of the issue stored in the
+// comment vault.
+func revealComments(s *store.PGStore, issueKey string) {
+	cs, err := s.GetVaultedComments(issueKey)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `comments reveal`: %s", err))
+	}
+	for _, c := range cs {
+		fmt.Printf("%s %s:\n%s\n\n", c.EventTime.Format(time.RFC3339), c.EventAuthor, c.Body)
+	}
+}
+
+// search prints the issues and comments matching the query of the
+// arguments, `--limit` at most (`api.DefaultSearchLimit` if not set).
+func search(s *store.PGStore, args []string) {
+	requireArgs(args, 1)
+	n := limit
+	if n == 0 {
+		n = api.DefaultSearchLimit
+	}
+	rs, err := s.Search(strings.Join(args, " "), n)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `search`: %s", err))
+	}
+	for _, r := range rs {
+		where := "summary/description"
+		if r.CommentID != nil {
+			where = "comment " + *r.CommentID
+		}
+		fmt.Printf("%s %s (%s, %.2f)\n  %s\n\n", r.IssueKey, r.Summary, where, r.Rank, r.Headline)
+	}
+}
+
+// recordFieldLineage replaces the lineage of the columns filled by
+// the mapping in `field_lineage`, so it matches the mapping of the
+// sync being run. An error is only logged, the lineage being
+// metadata.
+func recordFieldLineage(s *store.PGStore) {
+	if err := s.ReplaceFieldLineage(mapping.Lineage(allCustomFields(), agileFields())); err != nil {
+		logging.Errorf("Could not record the field lineage: %s", err)
+	}
+}
+
+// collectOrphanedEvents deletes or relinks the events whose issue
+// is not in the store anymore (see `store.CollectOrphanedEvents`).
+func collectOrphanedEvents(s *store.PGStore) {
+	relink, dryRun := boolFlag("relink"), boolFlag("dry-run")
+	prefix := ""
+	if dryRun {
+		prefix = "(dry run) "
+	}
+	if renames := keyRenames(); len(renames) > 0 {
+		keys, err := s.GetRenamedIssueKeys(renames)
+		if err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `gc events`: %s", err))
+		}
+		for _, k := range keys {
+			if dryRun {
+				continue
+			}
+			if err = s.DeleteIssue(k); err != nil {
+				telemetry.Fatalln(fmt.Errorf("error in `gc events`: %s", err))
+			}
+		}
+		fmt.Printf("%s%d issues stored with a renamed key deleted\n", prefix, len(keys))
+	}
+	r, err := s.CollectOrphanedEvents(relink, dryRun)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `gc events`: %s", err))
+	}
+	fmt.Printf("%s%d events relinked, %d duplicates deleted, %d orphaned events deleted\n", prefix, r.Relinked, r.Duplicates, r.Deleted)
+}
+
+func loadTeams(s *store.PGStore) {
+	teams, err := config.LoadTeams()
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `load-teams`: %s", err))
+	}
+	tms := make([]store.TeamMembership, len(teams.Memberships))
+	for i, m := range teams.Memberships {
+		tms[i] = store.TeamMembership{Person: m.Person, Team: m.Team}
+		if m.Tribe != "" {
+			tribe := m.Tribe
+			tms[i].Tribe = &tribe
+		}
+		if m.From != nil {
+			tms[i].From = &m.From.Time
+		}
+		if m.To != nil {
+			tms[i].To = &m.To.Time
+		}
+	}
+	if err = s.ReplaceTeamMemberships(tms); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `load-teams`: %s", err))
+	}
+	logging.Infof("Loaded %d team memberships", len(tms))
+}
+
+func runReport(s *store.PGStore, name string) {
+	var err error
+	switch name {
+	case "cycles":
+		err = report.Cycles(s, os.Stdout)
+	case "cycle-time":
+		key := flagValue("explain")
+		if key == "" {
+			usage()
+		}
+		err = explainCycleTime(s, keyRenames().Normalize(key))
+	case "capacity":
+		err = reportCapacity(s)
+	case "duplicates":
+		err = reportDuplicates(s)
+	case "sprints":
+		if err = report.Sprints(s, os.Stdout); err == nil {
+			checkSprints(s)
+		}
+	case "carryover":
+		err = reportCarryover(s)
+	default:
+		usage()
+	}
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `report %s`: %s", name, err))
+	}
+}
+
+// reportCapacity prints the capacity report of the team of
+// `--team` over the weeks of `--weeks` as CSV.
+func reportCapacity(s *store.PGStore) error {
+	o := report.CapacityOptions{Team: flagValue("team")}
+	if o.Team == "" {
+		usage()
+	}
+	if v := flagValue("weeks"); v != "" {
+		var err error
+		if o.Weeks, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid --weeks: %s", err)
+		}
+	}
+	rows, err := report.Capacity(s, o)
+	if err != nil {
+		return err
+	}
+	return report.WriteCapacityCSV(os.Stdout, rows)
+}
+
+// reportDuplicates prints the probable duplicate issues as CSV, with
+// the similarity threshold of `--threshold`.
+func reportDuplicates(s *store.PGStore) error {
+	o := report.DuplicatesOptions{CrossProject: boolFlag("cross-project")}
+	if v := flagValue("threshold"); v != "" {
+		var err error
+		if o.Threshold, err = strconv.ParseFloat(v, 64); err != nil || o.Threshold <= 0 || o.Threshold > 1 {
+			return fmt.Errorf("invalid --threshold: expected a number in (0, 1], got `%s`", v)
+		}
+	}
+	pairs, err := report.Duplicates(s, o)
+	if err != nil {
+		return err
+	}
+	return report.WriteDuplicatesCSV(os.Stdout, pairs)
+}
+
+// reportCarryover prints the carryover report of the board of
+// `--board` as CSV, or JSON with `--format json`.
+func reportCarryover(s *store.PGStore) error {
+	board, format := flagValue("board"), flagValue("format")
+	if board == "" {
+		usage()
+	}
+	boardID, err := strconv.Atoi(board)
+	if err != nil {
+		return fmt.Errorf("invalid --board: %s", err)
+	}
+	if format != "" && format != "csv" && format != "json" {
+		return fmt.Errorf("unsupported format `%s` (supported: csv, json)", format)
+	}
+	rows, err := report.Carryover(s, boardID)
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		return report.WriteCarryoverJSON(os.Stdout, rows)
+	}
+	return report.WriteCarryoverCSV(os.Stdout, rows)
+}
+
+// explainCycleTime prints how the cycle time of the issue is
+// computed from its events.
+func explainCycleTime(s *store.PGStore, issueKey string) error {
+	h, err := s.GetIssueHistory(issueKey)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return fmt.Errorf("no event found for issue `%s`", issueKey)
+	}
+	categories, err := s.GetStatusCategories()
+	if err != nil {
+		return err
+	}
+	aliases, err := s.GetStatusAliases()
+	if err != nil {
+		return err
+	}
+	c := metrics.NewClassifier(conf.Metrics, categories)
+	c.SetAliases(aliases)
+	return metrics.Explain(*h, c, os.Stdout)
+}
+
+func exportDemo(s *store.PGStore, dir string) {
+	cfg := conf.Export
+	opts := export.PartitionOptions{Workers: cfg.Workers, MaxFileSize: cfg.MaxFileSize, MaxOpenFiles: cfg.MaxOpenFiles}
+	if err := export.Demo(s, export.NewObfuscator(time.Now().UnixNano()), dir, opts, customFields()); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `export demo`: %s", err))
+	}
+}
+
+// exportSnapshot writes a snapshot of the issue states and metrics
+// to a new SQLite DB file at the path, replacing the file if it
+// exists (see `export.Snapshot`). The values are anonymized with an
+// `export.DefaultObfuscator` if `anonymize` is set.
+//
+// The snapshot is written to a temporary file of the same directory,
+// renamed once complete, so a failed export leaves the previous
+// snapshot in place.
+//
+// The SQLite driver is only compiled in with the `sqlite` build tag.
+func exportSnapshot(s *store.PGStore, path, format string, anonymize bool) {
+	if format != "" && format != export.SnapshotFormatSQLite {
+		telemetry.Fatalln(fmt.Errorf("error in `export snapshot`: unsupported format `%s` (supported: %s)", format, export.SnapshotFormatSQLite))
+	}
+	if !sqliteDriverRegistered() {
+		telemetry.Fatalln(fmt.Errorf("error in `export snapshot`: the SQLite driver is missing, the application must be built with `-tags sqlite`"))
+	}
+	cfs := customFields()
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `export snapshot`: %s", err))
+	}
+	tmp := f.Name()
+	if err = f.Close(); err == nil {
+		err = writeSnapshot(s, tmp, cfs, anonymize)
+	}
+	if err == nil {
+		// Temporary files are only readable by their owner
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		telemetry.Fatalln(fmt.Errorf("error in `export snapshot`: %s", err))
+	}
+}
+
+// writeSnapshot writes the snapshot to the SQLite DB file at the
+// path (see `exportSnapshot`).
+func writeSnapshot(s *store.PGStore, path string, cfs []config.CustomField, anonymize bool) error {
+	db, err := sql.Open(store.SQLiteDriver, path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var o export.Obfuscator
+	if anonymize {
+		o = export.NewObfuscator(time.Now().UnixNano())
+	}
+	return export.Snapshot(s, db, o, cfs)
+}
+
+// sqliteDriverRegistered returns true if the SQLite driver is
+// compiled in.
+func sqliteDriverRegistered() bool {
+	for _, d := range sql.Drivers() {
+		if d == store.SQLiteDriver {
+			return true
+		}
+	}
+	return false
+}
+
+// exportML writes the feature vectors of the issues to a Parquet
+// file at the path, replacing the file if it exists (see
+// `export.ML`). The values are anonymized with an
+// `export.DefaultObfuscator` if `anonymize` is set.
+func exportML(s *store.PGStore, path string, anonymize bool) {
+	f, err := os.Create(path)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `export ml`: %s", err))
+	}
+	defer f.Close()
+	var o export.Obfuscator
+	if anonymize {
+		o = export.NewObfuscator(time.Now().UnixNano())
+	}
+	if err = export.ML(s, o, f); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `export ml`: %s", err))
+	}
+}
+
+// exportPersonData writes the records referencing the account to a
+// JSON file at `path` (see `store.PGStore.GetPersonData`).
+func exportPersonData(s *store.PGStore, account, since, until, path string) {
+	var from, to *time.Time
+	for _, d := range []struct {
+		flag, value string
+		t           **time.Time
+	}{{"--since", since, &from}, {"--until", until, &to}} {
+		if d.value == "" {
+			continue
+		}
+		t, err := time.Parse("2006-01-02", d.value)
+		if err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `export person-data`: invalid %s: %s", d.flag, err))
+		}
+		*d.t = &t
+	}
+	pd, err := s.GetPersonData(account, from, to)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `export person-data`: %s", err))
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `export person-data`: %s", err))
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(pd); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `export person-data`: %s", err))
+	}
+	var n int
+	for _, records := range pd.Tables {
+		n += len(records)
+	}
+	fmt.Printf("%d records of %s written to %s\n", n, account, path)
+}
+
+// erasePersonData anonymizes the records referencing the account
+// (see `store.PGStore.ErasePersonData`).
+func erasePersonData(s *store.PGStore, account string) {
+	pseudonym, err := s.ErasePersonData(account)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `erase person-data`: %s", err))
+	}
+	fmt.Printf("Records of %s anonymized as %s\n", account, pseudonym)
+}
+
+// generateTestdata generates synthetic issues as configured by the
+// flags of `generate testdata`, and writes them to the fixtures
+// directory or the DB.
+func generateTestdata() {
+	o := generate.Options{Issues: 500, Seed: 1, End: time.Now().UTC().Truncate(24 * time.Hour)}
+	var err error
+	if v := flagValue("issues"); v != "" {
+		if o.Issues, err = strconv.Atoi(v); err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `generate testdata`: invalid --issues: %s", err))
+		}
+	}
+	if v := flagValue("seed"); v != "" {
+		if o.Seed, err = strconv.ParseInt(v, 10, 64); err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `generate testdata`: invalid --seed: %s", err))
+		}
+	}
+	if v := flagValue("end"); v != "" {
+		if o.End, err = time.Parse("2006-01-02", v); err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `generate testdata`: invalid --end: %s", err))
+		}
+	}
+	out := flagValue("out")
+	reset := boolFlag("reset")
+	issues := generate.Issues(o)
+
+	if out != "" {
+		if err = generate.WriteFixtures(out, issues); err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `generate testdata`: %s", err))
+		}
+		logging.Infof("Wrote %d issues to %s", len(issues), out)
+		return
+	}
+
+	db := openDB()
+	defer db.Close()
+	s := newStore(db)
+	if reset {
+		resetTables(s)
+	}
+	m := newMapper()
+	w := s.NewWriter()
+	for _, i := range issues {
+		if err = w.Add(i.Key, m.IssueStateFromIssue(i), m.IssueEventsFromIssue(i)); err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `generate testdata`: %s", err))
+		}
+	}
+	if err = w.Flush(); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `generate testdata`: %s", err))
+	}
+	logging.Infof("Stored %d issues", len(issues))
+}
+
+// benchmarkStore runs the load tests of the store configured by the
+// flags of `benchmark store` and prints their results.
+func benchmarkStore(s *store.PGStore) {
+	o := store.LoadTestOptions{}
+	var err error
+	if v := flagValue("events"); v != "" {
+		if o.Events, err = strconv.Atoi(v); err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `benchmark store`: invalid --events: %s", err))
+		}
+	}
+	if v := flagValue("batch-size"); v != "" {
+		if o.BatchSize, err = strconv.Atoi(v); err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `benchmark store`: invalid --batch-size: %s", err))
+		}
+	}
+	if v := flagValue("rate"); v != "" {
+		if o.EventsPerSecond, err = strconv.ParseFloat(v, 64); err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `benchmark store`: invalid --rate: %s", err))
+		}
+	}
+	strategies := store.LoadTestStrategies
+	if v := flagValue("strategy"); v != "" {
+		strategies = []string{v}
+	}
+
+	fmt.Printf("%-8s %8s %8s %10s %10s %10s %10s %10s %10s\n", "strategy", "events", "writes", "duration", "events/s", "p50", "p95", "p99", "max")
+	for _, st := range strategies {
+		o.Strategy = st
+		r, err := s.LoadTest(o)
+		if err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `benchmark store`: %s", err))
+		}
+		fmt.Printf("%-8s %8d %8d %10s %10.0f %10s %10s %10s %10s\n",
+			r.Strategy, r.Events, r.Writes,
+			r.Duration.Round(time.Millisecond), r.EventsPerSecond(),
+			r.P50.Round(time.Microsecond), r.P95.Round(time.Microsecond),
+			r.P99.Round(time.Microsecond), r.Max.Round(time.Microsecond))
+	}
+}
+
+// runDaemon runs `syncFn` every `interval`, serving the admin
+// endpoints of the daemon on `ADMIN_ADDR` (defaults to
+// `localhost:8081`).
+func runDaemon(interval time.Duration, syncFn func() error) {
+	addr := os.Getenv("ADMIN_ADDR")
+	if addr == "" {
+		addr = "localhost:8081"
+	}
+
+	d := daemon.New(interval, syncFn)
+	go func() {
+		logging.Infof("Admin endpoints listening on %s", addr)
+		telemetry.Fatalln(http.ListenAndServe(addr, d.Handler()))
+	}()
+	d.Run(shutdown.Done())
+}
+
+// defaultSyncLockName is the name of the sync lock if
+// `SYNC_LOCK_NAME` is not set.
+const defaultSyncLockName = "sync"
+
+// syncLockName returns the name of the sync lock of the runs (see
+// `store.PGStore.LockSync`), `SYNC_LOCK_NAME` or
+// `defaultSyncLockName`.
+func syncLockName() string {
+	if n := os.Getenv("SYNC_LOCK_NAME"); n != "" {
+		return n
+	}
+	return defaultSyncLockName
+}
+
+// syncLockLost is closed if the sync lock acquired by `lockSync` is
+// lost (see `store.SyncLock.Lost`).
+var syncLockLost <-chan struct{}
+
+// lockSync acquires the sync lock for the run and returns the
+// function releasing it, also called if the run exits with
+// `telemetry.Fatalln`. Exits if another run holds the lock, unless
+// `--wait-lock` is passed: the lock is then waited for.
+//
+// Must be called once `shutdown` is set: it's canceled if the lock
+// is lost, so the run stops writing, and fails (see
+// `failOnSyncError`).
+func lockSync(s *store.PGStore) (unlock func()) {
+	name := syncLockName()
+	l, err := s.LockSync(shutdown, name, boolFlag("wait-lock"))
+	if err == store.ErrSyncLocked {
+		telemetry.Fatalln(fmt.Errorf("another run holds the sync lock `%s`: wait for it to complete, or pass `--wait-lock`", name))
+	}
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error acquiring the sync lock `%s`: %s", name, err))
+	}
+	var cancel context.CancelFunc
+	shutdown, cancel = cancelOnLost(shutdown, l)
+	syncLockLost = l.Lost()
+	var once sync.Once
+	unlock = func() {
+		once.Do(func() {
+			cancel()
+			if err := l.Unlock(); err != nil {
+				logging.Warnf("Failed to release the sync lock `%s`: %s", name, err)
+			}
+		})
+	}
+	telemetry.AtExit(unlock)
+	return unlock
+}
+
+// cancelOnLost returns a context canceled once the sync lock is lost.
+func cancelOnLost(ctx context.Context, l *store.SyncLock) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-l.Lost():
+			logging.Errorf("Lost the sync lock, stopping the run")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// errSyncLockLost is the error of the runs which lost their sync
+// lock.
+var errSyncLockLost = fmt.Errorf("the sync lock was lost (its lease expired), another run may have written meanwhile")
+
+// failOnSyncError exits with an error if the sync of the command
+// failed, e.g. if some issues could not be synced (see
+// `jira.FailedIssuesError`) or the sync lock was lost, so the
+// schedulers running it notice. The sync already logged the error.
+func failOnSyncError(cmd string, err error) {
+	select {
+	case <-syncLockLost:
+		err = errSyncLockLost
+	default:
+	}
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `%s`: %s", cmd, err))
+	}
+}
+
+// withSyncLock calls `fn` holding the sync lock, for the runs of
+// `daemon` and `realtime`. The run is skipped if another run holds
+// the lock. If the lock can't be acquired otherwise (e.g. the DB is
+// unreachable, the writes being spooled), the run is performed
+// without it. Returns the error of `fn`.
+//
+// `fn` is passed the context of the run, canceled if the lock is
+// lost, the run then failing with `errSyncLockLost`.
+func withSyncLock(s *store.PGStore, fn func(ctx context.Context) error) error {
+	name := syncLockName()
+	l, err := s.LockSync(shutdown, name, false)
+	switch {
+	case err == store.ErrSyncLocked:
+		logging.Warnf("Skipping the run: another run holds the sync lock `%s`", name)
+		return nil
+	case err != nil:
+		logging.Warnf("Running without the sync lock `%s`: %s", name, err)
+		return fn(shutdown)
+	}
+	defer func() {
+		if err := l.Unlock(); err != nil {
+			logging.Warnf("Failed to release the sync lock `%s`: %s", name, err)
+		}
+	}()
+	ctx, cancel := cancelOnLost(shutdown, l)
+	defer cancel()
+	err = fn(ctx)
+	select {
+	case <-l.Lost():
+		return errSyncLockLost
+	default:
+		return err
+	}
+}
+
+// envDuration returns the duration set in the environment variable,
+// or `def` if it's not set.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `envDuration`: invalid %s: %s", name, err))
+	}
+	return d
+}
+
+// migrateUp creates or migrates the store's schema to
+// `store.SchemaVersion`.
+func migrateUp(s *store.PGStore) {
+	applied, err := s.MigrateUp()
+	for _, c := range applied {
+		logging.Infof("Applied version %d: %s", c.Version, c.Description)
+	}
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `migrate up`: %s", err))
+	}
+	if len(applied) == 0 {
+		logging.Infof("The schema is up to date (version %d)", store.SchemaVersion)
+	}
+}
+
+// migrateStatus lists the changes of the schema and whether they're
+// applied.
+func migrateStatus(s *store.PGStore) {
+	statuses, err := s.MigrationStatuses()
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `migrate status`: %s", err))
+	}
+	for _, st := range statuses {
+		status := "pending"
+		switch {
+		case st.AppliedAt != nil:
+			status = st.AppliedAt.Format("2006-01-02 15:04")
+		case st.Applied:
+			status = "applied"
+		}
+		fmt.Printf("%3d  %-16s %s\n", st.Version, status, st.Description)
+	}
+}
+
+// migratePlan prints the changes and statements migrating the
+// store's schema to `store.SchemaVersion`, as an SQL script.
+func migratePlan(s *store.PGStore) {
+	from, err := s.RecordedSchemaVersion()
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `migrate plan`: %s", err))
+	}
+	if from == 0 {
+		fmt.Println("-- No schema in the DB, create it with `migrate up`.")
+		return
+	}
+	fmt.Printf("-- DB schema version: %d\n", from)
+	fmt.Printf("-- Application schema version: %d\n", store.SchemaVersion)
+	custom, err := s.CustomColumnsPlan()
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `migrate plan`: %s", err))
+	}
+	strict, err := s.StrictSchemaPlan()
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `migrate plan`: %s", err))
+	}
+	search, err := s.FullTextSearchPlan()
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `migrate plan`: %s", err))
+	}
+	changes := store.SchemaChanges(from)
+	if len(changes) == 0 && len(custom) == 0 && len(strict) == 0 && len(search) == 0 {
+		fmt.Println("-- The schema is up to date.")
+		return
+	}
+	for _, c := range changes {
+		fmt.Printf("-- Version %d: %s\n", c.Version, c.Description)
+	}
+	if len(custom) > 0 {
+		fmt.Println("-- Add the columns of the custom fields added to the mapping")
+	}
+	if len(strict) > 0 {
+		fmt.Println("-- Add the foreign keys of the strict schema")
+	}
+	if len(search) > 0 {
+		fmt.Println("-- Add the columns of the full-text search")
+	}
+	fmt.Println()
+	for _, q := range append(append(append(store.MigrationPlan(from), custom...), strict...), search...) {
+		fmt.Println(q)
+	}
+}
+
+// spoolingStore returns a `SpoolingStore` for `s`, spooling to
+// `SPOOL_PATH` (defaults to `spool.jsonl`). Writes left in the spool
+// by a previous run are replayed, then the spool is flushed every
+// `SPOOL_FLUSH_INTERVAL` (defaults to 30 seconds).
+func spoolingStore(s *store.PGStore) *store.SpoolingStore {
+	path := os.Getenv("SPOOL_PATH")
+	if path == "" {
+		path = "spool.jsonl"
+	}
+	ss := store.NewSpoolingStore(s, path)
+	if err := ss.Flush(); err != nil {
+		logging.Errorf("Could not flush spool `%s`: %s", path, err)
+	}
+	go ss.FlushEvery(envDuration("SPOOL_FLUSH_INTERVAL", 30*time.Second), make(chan struct{}))
+	return ss
+}
+
+// runWebhooks serves the webhook receiver on `WEBHOOK_ADDR`
+// (defaults to `localhost:8082`).
+func runWebhooks(r *webhook.Receiver) {
+	addr := os.Getenv("WEBHOOK_ADDR")
+	if addr == "" {
+		addr = "localhost:8082"
+	}
+	r.SetSecret(os.Getenv("WEBHOOK_SECRET"))
+	r.SetIssueCheck(newAPIClient().IssueExists)
+	logging.Infof("Webhook receiver listening on %s", addr)
+	telemetry.Fatalln(http.ListenAndServe(addr, r.Handler()))
+}
+
+// runAPI serves the API on `API_ADDR` (defaults to
+// `localhost:8083`).
+func runAPI(s *store.PGStore) {
+	addr := os.Getenv("API_ADDR")
+	if addr == "" {
+		addr = "localhost:8083"
+	}
+	logging.Infof("API listening on %s", addr)
+	telemetry.Fatalln(http.ListenAndServe(addr, api.Handler(s)))
+}
+
+// defaultWebhookName is the name of the webhook registered by
+// `webhooks register`, unless set with `--name`.
+const defaultWebhookName = "kaizenizer-source-jira"
+
+// registerWebhook creates the Jira webhook posting the events handled
+// by the receiver to the URL of `--url`, or updates it if it exists.
+// The events are restricted to the issues matching `--jql`, or the
+// filter of the syncs, and signed with `WEBHOOK_SECRET` if set.
+func registerWebhook() {
+	u := flagValue("url")
+	if u == "" {
+		usage()
+	}
+	name := flagValue("name")
+	if name == "" {
+		name = defaultWebhookName
+	}
+	jql := flagValue("jql")
+	if jql == "" {
+		jql = filter.JQL()
+	}
+	wh := client.Webhook{
+		Name:    name,
+		URL:     u,
+		Events:  webhook.HandledEvents,
+		Secret:  os.Getenv("WEBHOOK_SECRET"),
+		Enabled: true,
+	}
+	if jql != "" {
+		wh.Filters = map[string]string{client.JQLFilter: jql}
+	}
+	if _, err := newAPIClient().RegisterWebhook(wh); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `webhooks register`: %s", err))
+	}
+}
+
+// newStore returns the `PGStore` for the DB, with writes throttled
+// as configured in `db.throttle`, the batch size of `db.batch_size`,
+// the insert strategy of `db.insert_strategy` (and
+// `db.pooler_compatible`), and the custom
+// columns and column comments of the mapping.
+func newStore(db *sql.DB) *store.PGStore {
+	s := store.NewPGStore(db)
+	cfs := allCustomFields()
+	s.SetColumnComments(mapping.ColumnComments(cfs))
+	s.SetCustomColumns(mapping.CustomColumns(cfs))
+	s.SetBatchSize(conf.DB.BatchSize)
+	s.SetPoolerCompatible(conf.DB.PoolerCompatible)
+	if st := conf.DB.InsertStrategy; st != "" {
+		if err := s.SetInsertStrategy(st); err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `db.insert_strategy`: %s", err))
+		}
+	}
+	s.SetStrictSchema(conf.DB.StrictSchema)
+	s.SetFullTextSearch(conf.DB.FullTextSearch)
+	s.SetNormalizedFields(conf.DB.NormalizedFields)
+	s.SetStateHistory(conf.DB.StateHistory)
+	s.SetQuarantine(conf.DB.Quarantine)
+	s.SetRunInfo(buildinfo.Get().RunInfo())
+	if key := os.Getenv("COMMENT_VAULT_KEY"); key != "" {
+		s.SetCommentVault(commentVault(key))
+	}
+	t := conf.DB.Throttle
+	if t.MaxRowsPerSecond > 0 || t.MaxReplicationLag.Duration > 0 || t.MaxConnectionsUsage > 0 {
+		s.SetThrottle(store.NewThrottle(db, store.ThrottleOptions{
+			MaxRowsPerSecond:    t.MaxRowsPerSecond,
+			MaxReplicationLag:   t.MaxReplicationLag.Duration,
+			MaxConnectionsUsage: t.MaxConnectionsUsage,
+			CheckInterval:       t.CheckInterval.Duration,
+		}))
+	}
+	return s
+}
+
+// newMapper returns the mapper configured with the `mapping`
+// section of the config.
+func newMapper() mapping.Mapper {
+	return mapping.Mapper{
+		StatusChangeReasons: conf.Mapping.StatusChangeReasons,
+		CustomFields:        customFields(),
+		ExcludedAuthors:     conf.Mapping.ExcludedAuthors,
+		TrackedFields:       conf.Mapping.TrackedFields,
+		SeverityBuckets:     conf.Mapping.SeverityBuckets,
+		Estimates:           estimates(),
+		Redaction:           redaction(allCustomFields()),
+		GeneratedIssues:     conf.Mapping.GeneratedIssues,
+		IssueProperties:     conf.Mapping.IssueProperties,
+		KeyRenames:          keyRenames(),
+
+		ChangelogTruncationThreshold: conf.Mapping.ChangelogTruncationThreshold.Duration,
+		TeamInference:                teamInference(allCustomFields()),
+		AgileFields:                  agileFields(),
+	}
+}
+
+// agileFields returns the IDs of the agile fields configured in
+// `mapping.agile_fields`.
+func agileFields() config.AgileFields {
+	af := conf.Mapping.AgileFields
+	if err := mapping.ValidateAgileFields(af); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `mapping.agile_fields`: %s", err))
+	}
+	return af
+}
+
+// teamInference returns the team inference configured in
+// `mapping.team_inference`.
+func teamInference(cfs []config.CustomField) config.TeamInference {
+	ti := conf.Mapping.TeamInference
+	if err := mapping.ValidateTeamInference(ti, cfs); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `mapping.team_inference`: %s", err))
+	}
+	return ti
+}
+
+// keyRenames returns the renames of the project keys configured in
+// `mapping.project_key_renames`.
+func keyRenames() store.KeyRenames {
+	return store.KeyRenames(conf.Mapping.ProjectKeyRenames)
+}
+
+// redaction returns the redaction configured in
+// `mapping.redaction`, with the salt read from `REDACTION_SALT` if
+// not set.
+func redaction(cfs []config.CustomField) config.Redaction {
+	rd := conf.Mapping.Redaction
+	if err := mapping.ValidateRedaction(rd, cfs); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `mapping.redaction`: %s", err))
+	}
+	if rd.Salt == "" {
+		rd.Salt = os.Getenv("REDACTION_SALT")
+	}
+	return rd
+}
+
+// estimates returns the estimates configured in
+// `mapping.estimates`.
+func estimates() config.Estimates {
+	e := conf.Mapping.Estimates
+	if err := mapping.ValidateEstimates(e); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `mapping.estimates`: %s", err))
+	}
+	return e
+}
+
+// customFields returns the custom fields configured in
+// `mapping.custom_fields`, or `mapping.DefaultCustomFields` if none
+// are configured.
+func customFields() []config.CustomField {
+	cfs := conf.Mapping.CustomFields
+	if cfs == nil {
+		cfs = mapping.DefaultCustomFields
+	}
+	if err := mapping.ValidateCustomFields(cfs); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `mapping.custom_fields`: %s", err))
+	}
+	return cfs
+}
+
+// allCustomFields returns the custom fields of the `mapping` section
+// and of the sources, merged by column.
+func allCustomFields() []config.CustomField {
+	sets := [][]config.CustomField{customFields()}
+	for _, src := range conf.Sources {
+		sets = append(sets, sourceCustomFields(src))
+	}
+	cfs, err := mapping.MergeCustomFields(sets...)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `sources`: %s", err))
+	}
+	return cfs
+}
+
+// sourceCustomFields returns the custom fields of the source, or
+// those of the `mapping` section if not set.
+func sourceCustomFields(src config.Source) []config.CustomField {
+	if src.CustomFields == nil {
+		return customFields()
+	}
+	if err := mapping.ValidateCustomFields(src.CustomFields); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `custom_fields` of source `%s`: %s", src.Name, err))
+	}
+	return src.CustomFields
+}
+
+// withSources returns the client and mapper of the syncs: if
+// `sources` are configured, a `jira.Sources` searching the issues
+// of each source with `c` and mapping them with the source's
+// mapper, otherwise `c` and the mapper of the `mapping` section.
+func withSources(c jira.Client) (jira.Client, jira.Mapper) {
+	srcs := conf.Sources
+	if len(srcs) == 0 {
+		m := newMapper()
+		return c, &m
+	}
+	var sources []jira.Source
+	names := make(map[string]bool)
+	for _, src := range srcs {
+		if src.Name == "" || names[src.Name] {
+			telemetry.Fatalln(fmt.Errorf("error in `sources`: missing or duplicate name `%s`", src.Name))
+		}
+		names[src.Name] = true
+		if src.JQL != "" && src.FilterID != 0 {
+			telemetry.Fatalln(fmt.Errorf("error in `sources`: source `%s` has both a `jql` and a `filter_id`", src.Name))
+		}
+		m := newMapper()
+		m.CustomFields = sourceCustomFields(src)
+		if src.TrackedFields != nil {
+			m.TrackedFields = src.TrackedFields
+		}
+		sources = append(sources, jira.Source{Name: src.Name, JQL: src.JQL, FilterID: src.FilterID, Mapper: &m})
+	}
+	ss := jira.NewSources(c, sources)
+	return ss, ss
+}
+
+func loadConfig() *config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		telemetry.Fatalln(err)
+	}
+	return cfg
+}
+
+// connStr is the connection string of the Postgres DB.
+const connStr = "user=agilizer password=password dbname=agilizer sslmode=disable"
+
+func openDB() *sql.DB {
+	//connStr := os.Getenv("DB_URL")
+	return openDBWithConnStr(connStr)
+}
+
+// openReadDB opens the read-only DB specified by `READ_DB_URL`
+// (e.g. a replica), used by commands which only read from the
+// store so heavy queries don't contend with the sync. Returns
+// the passed primary DB if `READ_DB_URL` is not set.
+func openReadDB(primary *sql.DB) *sql.DB {
+	connStr := os.Getenv("READ_DB_URL")
+	if connStr == "" {
+		return primary
+	}
+	return openDBWithConnStr(connStr)
+}
+
+// syncToFiles performs the sync action (`sync`, `sync-issue` or
+// `import`) with its arguments, writing the records to files of the
+// format in `dir` instead of the DB. `sync` performs a full sync.
+func syncToFiles(action string, args []string, dir, format string) {
+	if format == "" {
+		format = store.FileFormatJSONL
+	}
+	s, err := store.NewFileStore(dir, format, mapping.CustomColumns(allCustomFields()))
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `--output`: %s", err))
+	}
+	var syncErr error
+	switch action {
+	case "sync":
+		shutdown = handleShutdown()
+		c, m := limitedSyncClient()
+		syncErr = jira.PerformSync(shutdown, c, s, poolSize, m)
+	case "sync-issue":
+		requireArgs(args, 1)
+		c, m := withSources(newAPIClient())
+		syncErr = jira.PerformSyncForIssueKey(c, s, keyRenames().Normalize(args[0]), m)
+	case "import":
+		importIssues(s, args)
+	default:
+		telemetry.Fatalln(fmt.Errorf("`--output` is only supported by `sync`, `sync-issue` and `import`"))
+	}
+	if err = s.Close(); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error writing the files: %s", err))
+	}
+	logging.Infof("Records written to %s", dir)
+	failOnSyncError(action, syncErr)
+}
+
+// postgresConnStr returns the connection string with the timeouts
+// of `db.statement_timeout` and `db.lock_timeout`, and the options of
+// `db.pooler_compatible`.
+func postgresConnStr(connStr string, cfg config.DB) string {
+	connStr = store.WithTimeouts(connStr, cfg.StatementTimeout.Duration, cfg.LockTimeout.Duration)
+	if cfg.PoolerCompatible {
+		connStr = store.WithPoolerCompatibility(connStr)
+	}
+	return connStr
+}
+
+// defaultSQLitePath is the path of the SQLite DB if `db.path` is not
+// set.
+const defaultSQLitePath = "agilizer.db"
+
+// runSQLite performs the action with its arguments with the records
+// stored in the SQLite DB of `db.path` (see `store.SQLiteStore`).
+// Only the actions syncing the issues and managing the schema are
+// supported.
+//
+// The SQLite driver is only compiled in with the `sqlite` build tag.
+func runSQLite(action string, args []string) {
+	path := conf.DB.Path
+	if path == "" {
+		path = defaultSQLitePath
+	}
+	db, err := sql.Open(store.SQLiteDriver, path)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error opening the SQLite DB (the application must be built with `-tags sqlite`): %s", err))
+	}
+	defer db.Close()
+	// SQLite supports a single writer
+	db.SetMaxOpenConns(1)
+	s := store.NewSQLiteStore(db)
+	s.SetCustomColumns(mapping.CustomColumns(allCustomFields()))
+
+	switch action {
+	case "reset":
+		if !boolFlag("force") {
+			telemetry.Fatalln(fmt.Errorf("`reset` drops all the tables: run it with `--force`, or use `migrate up` to upgrade the schema"))
+		}
+		resetTables(s)
+		shutdown = handleShutdown()
+		c, m := limitedSyncClient()
+		failOnSyncError(action, jira.PerformSync(shutdown, c, s, poolSize, m))
+
+	case "sync":
+		full := syncFull()
+		shutdown = handleShutdown()
+		c, m := limitedSyncClient()
+		if full {
+			failOnSyncError(action, jira.PerformSync(shutdown, c, s, poolSize, m))
+			break
+		}
+		failOnSyncError(action, jira.PerformIncrementalSync(shutdown, c, s, poolSize, m))
+
+	case "sync-issue":
+		requireArgs(args, 1)
+		c, m := withSources(newAPIClient())
+		failOnSyncError(action, jira.PerformSyncForIssueKey(c, s, keyRenames().Normalize(args[0]), m))
+
+	case "import":
+		importIssues(s, args)
+
+	case "cleanup", "drop-db":
+		if err := s.DropTables(); err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `%s`: %s", action, err))
+		}
+
+	case "migrate", "init-db":
+		if action == "migrate" && args[0] != "up" {
+			telemetry.Fatalln(fmt.Errorf("`migrate %s` is not supported with the `sqlite` backend", args[0]))
+		}
+		if err := s.MigrateUp(); err != nil {
+			telemetry.Fatalln(fmt.Errorf("error in `%s`: %s", action, err))
+		}
+
+	default:
+		telemetry.Fatalln(fmt.Errorf("action `%s` is not supported with the `sqlite` backend", action))
+	}
+}
+
+func openDBWithConnStr(connStr string) *sql.DB {
+	cfg := conf.DB
+	db, err := sql.Open("postgres", postgresConnStr(connStr, cfg))
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `openDB`: %s", err))
+	}
+	db.SetMaxOpenConns(MaxOpenConns)
+	if err = store.WaitForDB(db, store.WaitOptions{Timeout: cfg.WaitTimeout.Duration}); err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `openDB`: %s", err))
 	}
 	return db
 }
@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// interval is a period of time, from `start` until `end`.
+type interval struct {
+	start time.Time
+	end   time.Time
+}
+
+// blockedIntervals returns the periods the issue was flagged (see
+// `store.FieldFlagged`) or blocked by an issue (see
+// `store.FieldBlockedBy`) while the blocker was unresolved (see
+// `Classifier.SetBlockerResolutions`), merged so the overlapping
+// periods are counted once, sorted.
+//
+// The periods are cut when the issue is done (`doneAt` not nil). The
+// ones not over yet are not counted.
+func blockedIntervals(h store.IssueHistory, c *Classifier, doneAt *time.Time) []interval {
+	var intervals []interval
+	add := func(start, end time.Time) {
+		if doneAt != nil && end.After(*doneAt) {
+			end = *doneAt
+		}
+		if end.After(start) {
+			intervals = append(intervals, interval{start, end})
+		}
+	}
+
+	var flaggedAt *time.Time
+	blockers := make(map[string]time.Time)
+	for _, e := range h.Events {
+		if e.EventKind != store.EventFieldChanged || e.FieldName == nil {
+			continue
+		}
+		t := e.EventTime
+		switch *e.FieldName {
+		case store.FieldFlagged:
+			switch {
+			case e.FieldChangeTo != nil && flaggedAt == nil:
+				flaggedAt = &t
+			case e.FieldChangeTo == nil && flaggedAt != nil:
+				add(*flaggedAt, t)
+				flaggedAt = nil
+			}
+		case store.FieldBlockedBy:
+			switch {
+			case e.FieldChangeTo != nil:
+				if _, ok := blockers[*e.FieldChangeTo]; !ok {
+					blockers[*e.FieldChangeTo] = t
+				}
+			case e.FieldChangeFrom != nil:
+				if start, ok := blockers[*e.FieldChangeFrom]; ok {
+					add(start, c.blockerEnd(*e.FieldChangeFrom, t))
+					delete(blockers, *e.FieldChangeFrom)
+				}
+			}
+		}
+	}
+
+	if flaggedAt != nil && doneAt != nil {
+		add(*flaggedAt, *doneAt)
+	}
+	for key, start := range blockers {
+		if resolvedAt, ok := c.resolutions[key]; ok {
+			add(start, resolvedAt)
+		} else if doneAt != nil {
+			add(start, *doneAt)
+		}
+	}
+	return merge(intervals)
+}
+
+// blockerEnd returns the end of the block by the issue of the key,
+// ending at `end` at the latest: the time the blocker was resolved
+// if earlier.
+func (c *Classifier) blockerEnd(key string, end time.Time) time.Time {
+	if resolvedAt, ok := c.resolutions[key]; ok && resolvedAt.Before(end) {
+		return resolvedAt
+	}
+	return end
+}
+
+// merge returns the intervals sorted, the overlapping ones being
+// merged.
+func merge(intervals []interval) []interval {
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].start.Before(intervals[j].start)
+	})
+	var merged []interval
+	for _, i := range intervals {
+		if n := len(merged); n > 0 && !i.start.After(merged[n-1].end) {
+			if i.end.After(merged[n-1].end) {
+				merged[n-1].end = i.end
+			}
+			continue
+		}
+		merged = append(merged, i)
+	}
+	return merged
+}
+
+// blockedTime returns the total duration of the blocked intervals
+// (see `blockedIntervals`), and the same in business time if `cal` is
+// not nil.
+func blockedTime(intervals []interval, cal *Calendar) (time.Duration, *time.Duration) {
+	var d time.Duration
+	for _, i := range intervals {
+		d += i.end.Sub(i.start)
+	}
+	if cal == nil {
+		return d, nil
+	}
+	var bd time.Duration
+	for _, i := range intervals {
+		bd += cal.Between(i.start, i.end)
+	}
+	return d, &bd
+}
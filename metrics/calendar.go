@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+)
+
+// Calendar is a business calendar, used to compute the durations of
+// the metrics in business time (see `Calendar.Between`).
+type Calendar struct {
+	location *time.Location
+	workDays map[time.Weekday]bool
+
+	// start and end are the work hours, as offsets from midnight.
+	start time.Duration
+	end   time.Duration
+
+	// holidays are the dates not worked, as `YYYY-MM-DD`.
+	holidays map[string]bool
+}
+
+// The defaults of `config.Calendar`.
+var (
+	defaultWorkDays  = []string{"monday", "tuesday", "wednesday", "thursday", "friday"}
+	defaultWorkStart = "09:00"
+	defaultWorkEnd   = "17:00"
+)
+
+// NewCalendar returns the calendar of the configuration, or an
+// error if it's invalid.
+func NewCalendar(cfg config.Calendar) (*Calendar, error) {
+	c := Calendar{
+		location: time.UTC,
+		workDays: make(map[time.Weekday]bool),
+		holidays: make(map[string]bool),
+	}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone `%s`: %s", cfg.Timezone, err)
+		}
+		c.location = loc
+	}
+
+	days := cfg.WorkDays
+	if len(days) == 0 {
+		days = defaultWorkDays
+	}
+	for _, d := range days {
+		wd, ok := weekday(d)
+		if !ok {
+			return nil, fmt.Errorf("invalid work day `%s`", d)
+		}
+		c.workDays[wd] = true
+	}
+
+	var err error
+	if c.start, err = timeOfDay(cfg.WorkStart, defaultWorkStart); err != nil {
+		return nil, err
+	}
+	if c.end, err = timeOfDay(cfg.WorkEnd, defaultWorkEnd); err != nil {
+		return nil, err
+	}
+	if c.start >= c.end {
+		return nil, fmt.Errorf("the work hours must end after they start, got %s-%s", cfg.WorkStart, cfg.WorkEnd)
+	}
+
+	for _, h := range cfg.Holidays {
+		if _, err := time.Parse("2006-01-02", h); err != nil {
+			return nil, fmt.Errorf("invalid holiday `%s` (expected YYYY-MM-DD)", h)
+		}
+		c.holidays[h] = true
+	}
+	return &c, nil
+}
+
+// Between returns the business time between `from` and `to`: the
+// time within the work hours of the work days which are not
+// holidays. Returns 0 if `to` is before `from`.
+func (c *Calendar) Between(from, to time.Time) time.Duration {
+	if !to.After(from) {
+		return 0
+	}
+	from, to = from.In(c.location), to.In(c.location)
+	var d time.Duration
+	for day := midnight(from); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !c.workDays[day.Weekday()] || c.holidays[day.Format("2006-01-02")] {
+			continue
+		}
+		start, end := at(day, c.start), at(day, c.end)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			d += end.Sub(start)
+		}
+	}
+	return d
+}
+
+// businessDuration returns the business time between `from` and
+// `to` with the calendar, or nil if there is none.
+func businessDuration(c *Calendar, from, to time.Time) *time.Duration {
+	if c == nil {
+		return nil
+	}
+	d := c.Between(from, to)
+	return &d
+}
+
+// midnight returns the start of the day of `t`, in its location.
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// at returns the time of the day at the offset from midnight, in
+// wall clock time (e.g. 09:00 even on the days of DST changes).
+func at(day time.Time, offset time.Duration) time.Time {
+	y, m, d := day.Date()
+	return time.Date(y, m, d, 0, int(offset/time.Minute), 0, 0, day.Location())
+}
+
+// weekday returns the day of the week with the name (e.g. "monday").
+func weekday(name string) (time.Weekday, bool) {
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		if strings.EqualFold(wd.String(), name) {
+			return wd, true
+		}
+	}
+	return 0, false
+}
+
+// timeOfDay parses the `HH:MM` time as an offset from midnight, or
+// `def` if it's empty.
+func timeOfDay(v, def string) (time.Duration, error) {
+	if v == "" {
+		v = def
+	}
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid work hour `%s` (expected HH:MM)", v)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -0,0 +1,151 @@
+package metrics
+
+import (
+	"time"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Category is the category of a status for metrics.
+type Category int
+
+// The categories a status may be classified in.
+const (
+	ToDo Category = iota
+	InProgress
+	Done
+)
+
+func (c Category) String() string {
+	switch c {
+	case InProgress:
+		return "in progress"
+	case Done:
+		return "done"
+	default:
+		return "to do"
+	}
+}
+
+// Classifier classifies statuses in categories to determine when
+// an issue was started or done.
+//
+// Statuses of projects configured in `config.Metrics.Projects` are
+// classified using the configuration. Other projects fall back on
+// the status categories defined in Jira.
+type Classifier struct {
+	projects   map[string]projectStatuses
+	categories map[string]string
+
+	// aliases maps the projects to the former names of their renamed
+	// statuses, mapped to the current ones (see `SetAliases`).
+	aliases map[string]map[string]string
+
+	// resolutions are the resolution times of the blockers (see
+	// `SetBlockerResolutions`).
+	resolutions map[string]time.Time
+}
+
+type projectStatuses struct {
+	started map[string]bool
+	done    map[string]bool
+	review  map[string]bool
+}
+
+// NewClassifier returns a `Classifier` using the passed
+// configuration. `categories` maps status names to the key of their
+// Jira status category (e.g. "indeterminate"), as returned by
+// `store.PGStore.GetStatusCategories()`.
+func NewClassifier(cfg config.Metrics, categories map[string]string) *Classifier {
+	c := Classifier{
+		projects:   make(map[string]projectStatuses),
+		categories: categories,
+	}
+	for p, ps := range cfg.Projects {
+		c.projects[p] = projectStatuses{
+			started: toSet(ps.Started),
+			done:    toSet(ps.Done),
+			review:  toSet(ps.Review),
+		}
+	}
+	return &c
+}
+
+// SetAliases sets the former names of the renamed statuses (see
+// `DetectStatusRenames`): they're classified like the statuses they
+// were renamed to, and `Status` returns the current name.
+func (c *Classifier) SetAliases(aliases []store.StatusAlias) {
+	c.aliases = make(map[string]map[string]string)
+	for _, a := range aliases {
+		if c.aliases[a.Project] == nil {
+			c.aliases[a.Project] = make(map[string]string)
+		}
+		c.aliases[a.Project][a.Alias] = a.Status
+	}
+}
+
+// SetBlockerResolutions sets the resolution times of the issues
+// which blocked other issues, by key (see
+// `store.PGStore.GetBlockerResolutions`): an issue isn't blocked
+// anymore once its blocker is resolved.
+func (c *Classifier) SetBlockerResolutions(resolutions map[string]time.Time) {
+	c.resolutions = resolutions
+}
+
+// Status returns the current name of the status of the project,
+// following the renames set with `SetAliases`.
+func (c *Classifier) Status(project string, status string) string {
+	aliases := c.aliases[project]
+	for i := 0; i < len(aliases); i++ {
+		renamed, ok := aliases[status]
+		if !ok {
+			break
+		}
+		status = renamed
+	}
+	return status
+}
+
+// Category returns the category of the status for the specified
+// project. The former names of the renamed statuses are classified
+// like the current ones (see `SetAliases`).
+func (c *Classifier) Category(project string, status string) Category {
+	status = c.Status(project, status)
+	if ps, ok := c.projects[project]; ok {
+		switch {
+		case ps.done[status]:
+			return Done
+		case ps.started[status]:
+			return InProgress
+		default:
+			return ToDo
+		}
+	}
+	switch c.categories[status] {
+	case extJira.StatusCategoryComplete:
+		return Done
+	case extJira.StatusCategoryInProgress:
+		return InProgress
+	default:
+		return ToDo
+	}
+}
+
+// IsReview returns true if the status is one of the review statuses
+// of the project (see `config.ProjectStatuses.Review`), following the
+// renames set with `SetAliases`. Projects which are not configured
+// have no review statuses.
+func (c *Classifier) IsReview(project string, status string) bool {
+	return c.projects[project].review[c.Status(project, status)]
+}
+
+func toSet(values []string) map[string]bool {
+	s := make(map[string]bool)
+	for _, v := range values {
+		s[v] = true
+	}
+	return s
+}
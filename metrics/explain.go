@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// explainTimeFormat is the format of the times printed by `Explain`.
+const explainTimeFormat = "2006-01-02 15:04:05 MST"
+
+// Explain writes to `w` how the cycle time of the issue is computed
+// from its history: each status change with the category of the new
+// status and its effect (started, done, reopened), followed by the
+// resulting interval. It uses the same implementation as `Compute`,
+// so analysts can understand a surprising value.
+func Explain(h store.IssueHistory, c *Classifier, w io.Writer) error {
+	var err error
+	printf := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+
+	printf("Cycle time of %s (project: %s, type: %s)\n\n", h.IssueKey, h.Project, h.Type)
+	printf("Status changes:\n")
+	im := compute(h, c, nil, func(s step) {
+		from := "(initial status)"
+		if s.event.StatusChangeFrom != nil {
+			from = *s.event.StatusChangeFrom
+		}
+		printf("  %s  %s -> %s [%s]", s.event.EventTime.Format(explainTimeFormat), from, *s.event.StatusChangeTo, s.category)
+		if s.effect != "" {
+			printf(" => %s", s.effect)
+		}
+		printf("\n")
+	})
+	printf("\n")
+
+	printf("Started at: %s\n", formatExplainTime(im.StartedAt, "never (no status classified as in progress)"))
+	printf("Done at:    %s\n", formatExplainTime(im.DoneAt, "not done (last status not classified as done)"))
+	switch {
+	case im.CycleTime != nil:
+		printf("Cycle time: %s (done at - started at)\n", *im.CycleTime)
+	default:
+		printf("Cycle time: none (the issue must be both started and done)\n")
+	}
+	if im.Reopenings > 0 {
+		printf("Reopenings: %d\n", im.Reopenings)
+	}
+	if im.FirstTimeRight != nil {
+		printf("First time right: %t\n", *im.FirstTimeRight)
+	}
+	if im.BlockedTime > 0 {
+		printf("Blocked time: %s (flagged or blocked by an unresolved issue)\n", im.BlockedTime)
+	}
+	return err
+}
+
+func formatExplainTime(t *time.Time, none string) string {
+	if t == nil {
+		return none
+	}
+	return t.Format(explainTimeFormat)
+}
@@ -0,0 +1,255 @@
+// Package metrics computes metrics (e.g. lead time, cycle time)
+// from the events of the issues and writes them to the
+// `jira_issue_metrics` table.
+package metrics
+
+import (
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Store is the interface of the store used to compute and store
+// the metrics. It's implemented by `store.PGStore`.
+type Store interface {
+	EachIssueHistory(fn func(h store.IssueHistory) error) error
+	ReplaceIssueMetrics(ims []store.IssueMetrics) error
+	ReplaceWeeklyStats(wss []store.WeeklyStats) error
+}
+
+// Analyze computes the metrics of all issues in the store and
+// the weekly stats, and replaces the existing `jira_issue_metrics`
+// and `jira_weekly_stats` records.
+//
+// The durations are also computed in business time if `cal` is not
+// nil. The cycle time percentiles of the weekly stats are computed
+// over the trailing `window` (`DefaultPercentileWindow` if 0).
+func Analyze(s Store, c *Classifier, cal *Calendar, window time.Duration) error {
+	ims := make([]store.IssueMetrics, 0)
+	err := s.EachIssueHistory(func(h store.IssueHistory) error {
+		ims = append(ims, Compute(h, c, cal))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = s.ReplaceIssueMetrics(ims); err != nil {
+		return err
+	}
+	if window == 0 {
+		window = DefaultPercentileWindow
+	}
+	return s.ReplaceWeeklyStats(ComputeWeeklyStats(ims, window))
+}
+
+// Compute computes the metrics of an issue from its history.
+//
+//   - The issue is started when it first enters a status classified as
+//     `InProgress`.
+//   - The issue is done when it enters a status classified as `Done`
+//     and doesn't leave it. An issue reopened after being done is not
+//     done anymore.
+//   - Lead time is the duration between the creation of the issue and
+//     the moment it's done.
+//   - Cycle time is the duration between the moment the issue was
+//     started and the moment it's done.
+//   - Reopenings is the number of times the issue left a `Done`
+//     status for another one.
+//   - The time spent in each status is summed over all the periods
+//     the issue was in it, until it left it: the time in the current
+//     status is not counted. The time spent in a former name of a
+//     renamed status is counted in the status (see
+//     `Classifier.SetAliases`).
+//   - For bugs, first response time is the duration between the
+//     creation of the issue and the first comment or status change
+//     by someone else than the reporter.
+//   - Time to first assignment is the duration between the creation
+//     of the issue and the first time it had an assignee (zero if it
+//     was created assigned).
+//   - The issue is first time right if it's done, it entered a review
+//     status (see `Classifier.IsReview`) and it never went back from
+//     a review or done status to a status which is neither, i.e.
+//     before the review. Undefined (nil) if the issue is not done or
+//     never entered a review status.
+//   - Blocked time is the time the issue was flagged or blocked by
+//     another issue ("is blocked by" link) while the blocker was
+//     unresolved, the overlapping periods being counted once, until
+//     the issue is done. The period the issue is still flagged or
+//     blocked in is only counted once it's over.
+//   - Events of excluded authors (see `IssueEvent.AuthorExcluded`)
+//     are ignored, except for the times in status and the first
+//     assignment since the status or assignee did change.
+//   - If `cal` is not nil, the durations are also computed in
+//     business time with the calendar (see `Calendar.Between`).
+//
+// The events of the history are expected to be sorted by time.
+func Compute(h store.IssueHistory, c *Classifier, cal *Calendar) store.IssueMetrics {
+	return compute(h, c, cal, nil)
+}
+
+// step describes how an event of the history was used to compute
+// the metrics, for `Explain`.
+type step struct {
+	event    store.IssueEvent
+	category Category
+	effect   string
+}
+
+// compute implements `Compute`, calling `trace` (if not nil) with
+// each status change considered.
+func compute(h store.IssueHistory, c *Classifier, cal *Calendar, trace func(s step)) store.IssueMetrics {
+	im := store.IssueMetrics{
+		IssueKey:  h.IssueKey,
+		Project:   h.Project,
+		Type:      h.Type,
+		CreatedAt: h.CreatedAt,
+	}
+	var status string
+	var enteredAt time.Time
+	var reviewed, bounced bool
+	statusTimes := make(map[string]int)
+	for _, e := range h.Events {
+		if e.EventKind != store.EventStatusChanged || e.StatusChangeTo == nil {
+			continue
+		}
+		t := e.EventTime
+		if status != "" {
+			im.StatusTimes = addStatusTime(im.StatusTimes, statusTimes, status, t.Sub(enteredAt), businessDuration(cal, enteredAt, t))
+		}
+		status, enteredAt = c.Status(h.Project, *e.StatusChangeTo), t
+		cat := c.Category(h.Project, *e.StatusChangeTo)
+		if e.AuthorExcluded {
+			if trace != nil {
+				trace(step{e, cat, "ignored, author excluded"})
+			}
+			continue
+		}
+		switch review := c.IsReview(h.Project, *e.StatusChangeTo); {
+		case review:
+			reviewed = true
+		case reviewed && cat != Done:
+			bounced = true
+		}
+		var effect string
+		switch cat {
+		case InProgress:
+			if im.StartedAt == nil {
+				im.StartedAt = &t
+				effect = "started"
+			}
+			if im.DoneAt != nil {
+				effect = "reopened, not done anymore"
+				im.Reopenings++
+			}
+			im.DoneAt = nil
+		case Done:
+			if im.DoneAt == nil {
+				im.DoneAt = &t
+				effect = "done"
+			}
+		default:
+			if im.DoneAt != nil {
+				effect = "reopened, not done anymore"
+				im.Reopenings++
+			}
+			im.DoneAt = nil
+		}
+		if trace != nil {
+			trace(step{e, cat, effect})
+		}
+	}
+	if im.DoneAt != nil {
+		lt := im.DoneAt.Sub(h.CreatedAt)
+		im.LeadTime = &lt
+		im.LeadTimeBusiness = businessDuration(cal, h.CreatedAt, *im.DoneAt)
+		if im.StartedAt != nil {
+			ct := im.DoneAt.Sub(*im.StartedAt)
+			im.CycleTime = &ct
+			im.CycleTimeBusiness = businessDuration(cal, *im.StartedAt, *im.DoneAt)
+		}
+		if reviewed {
+			ftr := !bounced
+			im.FirstTimeRight = &ftr
+		}
+	}
+	im.BlockedTime, im.BlockedTimeBusiness = blockedTime(blockedIntervals(h, c, im.DoneAt), cal)
+	im.FirstAssignedAt = firstAssignment(h)
+	if im.FirstAssignedAt != nil {
+		tfa := im.FirstAssignedAt.Sub(h.CreatedAt)
+		im.TimeToFirstAssignment = &tfa
+		im.TimeToFirstAssignmentBusiness = businessDuration(cal, h.CreatedAt, *im.FirstAssignedAt)
+	}
+	if h.Type == BugType {
+		im.FirstResponseAt = firstResponse(h)
+		if im.FirstResponseAt != nil {
+			frt := im.FirstResponseAt.Sub(h.CreatedAt)
+			im.FirstResponseTime = &frt
+			im.FirstResponseTimeBusiness = businessDuration(cal, h.CreatedAt, *im.FirstResponseAt)
+		}
+	}
+	return im
+}
+
+// addStatusTime adds the duration (and the business duration, if
+// not nil) to the time spent in the status, appending the status to
+// the times if it's the first time the issue leaves it. `index` maps
+// the statuses to their index in `times`.
+func addStatusTime(times []store.StatusTime, index map[string]int, status string, d time.Duration, bd *time.Duration) []store.StatusTime {
+	i, ok := index[status]
+	if !ok {
+		index[status] = len(times)
+		return append(times, store.StatusTime{Status: status, Duration: d, BusinessDuration: bd})
+	}
+	times[i].Duration += d
+	if bd != nil {
+		*times[i].BusinessDuration += *bd
+	}
+	return times
+}
+
+// firstAssignment returns the time of the first `assignee_changed`
+// event to an assignee, or nil if the issue was never assigned. The
+// event generated for the initial assignee of an issue created
+// unassigned has an empty assignee.
+func firstAssignment(h store.IssueHistory) *time.Time {
+	for _, e := range h.Events {
+		if e.EventKind == store.EventAssigneeChanged && e.AssigneeChangeTo != nil && *e.AssigneeChangeTo != "" {
+			t := e.EventTime
+			return &t
+		}
+	}
+	return nil
+}
+
+// BugType is the name of the issue type for which the first
+// response time is computed.
+const BugType = "Bug"
+
+// firstResponse returns the time of the first comment or status
+// change by someone else than the reporter (the author of the
+// `created` event), or nil if there is none. Events of excluded
+// authors are not responses.
+//
+// The `status_changed` events generated for the initial status
+// (without `StatusChangeFrom`) are not responses.
+func firstResponse(h store.IssueHistory) *time.Time {
+	var reporter string
+	for _, e := range h.Events {
+		if e.EventKind == store.EventCreated {
+			reporter = e.EventAuthor
+			break
+		}
+	}
+	for _, e := range h.Events {
+		if e.EventAuthor == reporter || e.AuthorExcluded {
+			continue
+		}
+		switch {
+		case e.EventKind == store.EventCommentAdded,
+			e.EventKind == store.EventStatusChanged && e.StatusChangeFrom != nil:
+			t := e.EventTime
+			return &t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,687 @@
+package metrics_test
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/metrics"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+func TestClassifier_Category(t *testing.T) {
+	cfg := config.Metrics{
+		Projects: map[string]config.ProjectStatuses{
+			"Custom": config.ProjectStatuses{
+				Started: []string{"In Dev"},
+				Done:    []string{"Released"},
+			},
+		},
+	}
+	categories := map[string]string{
+		"In Dev":   "indeterminate",
+		"Closed":   "done",
+		"Released": "done",
+	}
+	c := metrics.NewClassifier(cfg, categories)
+
+	cases := []struct {
+		project  string
+		status   string
+		expected metrics.Category
+	}{
+		{"Custom", "In Dev", metrics.InProgress},
+		{"Custom", "Released", metrics.Done},
+		{"Custom", "Closed", metrics.ToDo}, // not listed for the project
+		{"Other", "In Dev", metrics.InProgress},
+		{"Other", "Closed", metrics.Done},
+		{"Other", "Unknown", metrics.ToDo},
+	}
+	for _, tc := range cases {
+		if r := c.Category(tc.project, tc.status); r != tc.expected {
+			t.Errorf("expected `%s` in project `%s` to have category %d, got %d", tc.status, tc.project, tc.expected, r)
+		}
+	}
+
+	// Renamed statuses are classified like their current name
+	c.SetAliases([]store.StatusAlias{{Project: "Custom", Alias: "Dev", Status: "In Dev"}, {Project: "Custom", Alias: "Coding", Status: "Dev"}})
+	if s := c.Status("Custom", "Coding"); s != "In Dev" {
+		t.Errorf("expected `Coding` to be renamed to `In Dev`, got `%s`", s)
+	}
+	if r := c.Category("Custom", "Coding"); r != metrics.InProgress {
+		t.Errorf("expected `Coding` to be in progress, got %d", r)
+	}
+	if s := c.Status("Other", "Coding"); s != "Coding" {
+		t.Errorf("expected `Coding` not to be renamed in another project, got `%s`", s)
+	}
+}
+
+func TestDetectStatusRenames(t *testing.T) {
+	day := func(m time.Month, d int) time.Time { return time.Date(2020, m, d, 0, 0, 0, 0, time.UTC) }
+	ts := []store.StatusTransition{
+		{Project: "PJ", From: "", To: "Open", Count: 10, First: day(1, 1), Last: day(3, 1)},
+		{Project: "PJ", From: "Open", To: "In Dev", Count: 5, First: day(1, 1), Last: day(1, 31)},
+		{Project: "PJ", From: "In Dev", To: "Done", Count: 5, First: day(1, 2), Last: day(1, 31)},
+		{Project: "PJ", From: "Open", To: "Development", Count: 4, First: day(2, 1), Last: day(3, 1)},
+		{Project: "PJ", From: "Development", To: "Done", Count: 4, First: day(2, 2), Last: day(3, 1)},
+
+		// Only half similar to `In Dev`
+		{Project: "PJ", From: "Open", To: "Blocked", Count: 3, First: day(2, 1), Last: day(3, 1)},
+		{Project: "PJ", From: "Blocked", To: "Open", Count: 3, First: day(2, 1), Last: day(3, 1)},
+
+		// Same transitions in another project, but used at the same
+		// time
+		{Project: "Other", From: "Open", To: "In Dev", Count: 5, First: day(1, 1), Last: day(2, 15)},
+		{Project: "Other", From: "Open", To: "Development", Count: 5, First: day(2, 1), Last: day(3, 1)},
+	}
+	aliases := metrics.DetectStatusRenames(ts)
+	if len(aliases) != 1 {
+		t.Fatalf("expected 1 alias, got %+v", aliases)
+	}
+	if a := aliases[0]; a.Project != "PJ" || a.Alias != "In Dev" || a.Status != "Development" || a.Score != 1 {
+		t.Errorf("expected `In Dev` to be renamed to `Development` in PJ, got %+v", a)
+	}
+}
+
+func TestCompute(t *testing.T) {
+	refTime := time.Now()
+	c := metrics.NewClassifier(config.Metrics{}, map[string]string{
+		"Open":        "new",
+		"In Progress": "indeterminate",
+		"Done":        "done",
+	})
+
+	t.Run("done issue", func(t *testing.T) {
+		h := history(refTime, "Open", "In Progress", "Done")
+		im := metrics.Compute(h, c, nil)
+		expectDuration(t, "LeadTime", 3*time.Hour, im.LeadTime)
+		expectDuration(t, "CycleTime", 1*time.Hour, im.CycleTime)
+	})
+
+	t.Run("reopened issue", func(t *testing.T) {
+		h := history(refTime, "Open", "In Progress", "Done", "Open")
+		im := metrics.Compute(h, c, nil)
+		if im.DoneAt != nil || im.LeadTime != nil || im.CycleTime != nil {
+			t.Errorf("expected reopened issue not to be done, got %v", im)
+		}
+	})
+
+	t.Run("reopened and done again", func(t *testing.T) {
+		h := history(refTime, "Open", "In Progress", "Done", "In Progress", "Done")
+		im := metrics.Compute(h, c, nil)
+		expectDuration(t, "LeadTime", 5*time.Hour, im.LeadTime)
+		expectDuration(t, "CycleTime", 3*time.Hour, im.CycleTime)
+		if im.Reopenings != 1 {
+			t.Errorf("expected 1 reopening, got %d", im.Reopenings)
+		}
+	})
+
+	t.Run("times in status", func(t *testing.T) {
+		h := history(refTime, "Open", "In Progress", "Done", "In Progress", "Done")
+		h.Events[1].EventTime = h.Events[1].EventTime.Add(30 * time.Minute)
+		im := metrics.Compute(h, c, nil)
+		expected := []store.StatusTime{
+			{Status: "Open", Duration: 90 * time.Minute},
+			{Status: "In Progress", Duration: 90 * time.Minute},
+			{Status: "Done", Duration: 1 * time.Hour},
+		}
+		if !reflect.DeepEqual(im.StatusTimes, expected) {
+			t.Errorf("expected times in status %v, got %v", expected, im.StatusTimes)
+		}
+	})
+
+	t.Run("status change by an excluded author", func(t *testing.T) {
+		h := history(refTime, "Open", "In Progress", "Done", "Open")
+		h.Events[len(h.Events)-1].AuthorExcluded = true
+		im := metrics.Compute(h, c, nil)
+		expectDuration(t, "CycleTime", 1*time.Hour, im.CycleTime)
+	})
+}
+
+func TestCompute_FirstResponseTime(t *testing.T) {
+	refTime := time.Now()
+	c := metrics.NewClassifier(config.Metrics{}, map[string]string{})
+	open, inProgress := "Open", "In Progress"
+	event := func(d time.Duration, kind store.EventKind, author string) store.IssueEvent {
+		return store.IssueEvent{EventTime: refTime.Add(d), EventKind: kind, EventAuthor: author}
+	}
+	statusChange := func(d time.Duration, author string) store.IssueEvent {
+		e := event(d, "status_changed", author)
+		e.StatusChangeFrom = &open
+		e.StatusChangeTo = &inProgress
+		return e
+	}
+	initialStatus := event(0, "status_changed", "dev")
+	initialStatus.StatusChangeTo = &open
+
+	cases := []struct {
+		name     string
+		typ      string
+		events   []store.IssueEvent
+		expected *time.Duration
+	}{
+		{
+			name: "comment by a non-reporter",
+			typ:  "Bug",
+			events: []store.IssueEvent{
+				event(0, "created", "reporter"),
+				initialStatus,
+				event(1*time.Hour, "comment_added", "reporter"),
+				event(2*time.Hour, "comment_added", "dev"),
+				statusChange(3*time.Hour, "dev"),
+			},
+			expected: durationPtr(2 * time.Hour),
+		},
+		{
+			name: "status change by a non-reporter",
+			typ:  "Bug",
+			events: []store.IssueEvent{
+				event(0, "created", "reporter"),
+				statusChange(1*time.Hour, "reporter"),
+				statusChange(4*time.Hour, "dev"),
+			},
+			expected: durationPtr(4 * time.Hour),
+		},
+		{
+			name: "comment by an excluded author",
+			typ:  "Bug",
+			events: []store.IssueEvent{
+				event(0, "created", "reporter"),
+				func() store.IssueEvent {
+					e := event(1*time.Hour, "comment_added", "bot")
+					e.AuthorExcluded = true
+					return e
+				}(),
+				event(2*time.Hour, "comment_added", "dev"),
+			},
+			expected: durationPtr(2 * time.Hour),
+		},
+		{
+			name: "no response",
+			typ:  "Bug",
+			events: []store.IssueEvent{
+				event(0, "created", "reporter"),
+				initialStatus,
+				event(1*time.Hour, "comment_added", "reporter"),
+			},
+		},
+		{
+			name: "not a bug",
+			typ:  "Story",
+			events: []store.IssueEvent{
+				event(0, "created", "reporter"),
+				event(1*time.Hour, "comment_added", "dev"),
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := store.IssueHistory{IssueKey: "PJ-1", Project: "Project", Type: tc.typ, CreatedAt: refTime, Events: tc.events}
+			im := metrics.Compute(h, c, nil)
+			if tc.expected == nil {
+				if im.FirstResponseTime != nil {
+					t.Errorf("expected no FirstResponseTime, got %s", *im.FirstResponseTime)
+				}
+				return
+			}
+			expectDuration(t, "FirstResponseTime", *tc.expected, im.FirstResponseTime)
+		})
+	}
+}
+
+func TestCompute_FirstTimeRight(t *testing.T) {
+	refTime := time.Now()
+	c := metrics.NewClassifier(config.Metrics{
+		Projects: map[string]config.ProjectStatuses{
+			"Project": config.ProjectStatuses{
+				Started: []string{"In Progress", "Code Review", "QA"},
+				Done:    []string{"Done"},
+				Review:  []string{"Code Review", "QA"},
+			},
+		},
+	}, map[string]string{})
+
+	yes, no := true, false
+	cases := []struct {
+		name     string
+		statuses []string
+		expected *bool
+	}{
+		{"through review", []string{"Open", "In Progress", "Code Review", "QA", "Done"}, &yes},
+		{"back to development", []string{"Open", "In Progress", "Code Review", "In Progress", "Code Review", "Done"}, &no},
+		{"reopened after review", []string{"Open", "In Progress", "QA", "Done", "Open", "In Progress", "Done"}, &no},
+		{"back to review after done", []string{"Open", "In Progress", "QA", "Done", "QA", "Done"}, &yes},
+		{"not reviewed", []string{"Open", "In Progress", "Done"}, nil},
+		{"not done", []string{"Open", "In Progress", "Code Review"}, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			im := metrics.Compute(history(refTime, tc.statuses...), c, nil)
+			switch {
+			case tc.expected == nil && im.FirstTimeRight != nil:
+				t.Errorf("expected first time right to be nil, got %t", *im.FirstTimeRight)
+			case tc.expected != nil && (im.FirstTimeRight == nil || *im.FirstTimeRight != *tc.expected):
+				t.Errorf("expected first time right to be %t, got %v", *tc.expected, im.FirstTimeRight)
+			}
+		})
+	}
+}
+
+func TestCompute_TimeToFirstAssignment(t *testing.T) {
+	refTime := time.Now()
+	c := metrics.NewClassifier(config.Metrics{}, map[string]string{})
+	assignment := func(d time.Duration, to string) store.IssueEvent {
+		return store.IssueEvent{EventTime: refTime.Add(d), EventKind: "assignee_changed", EventAuthor: "lead", AssigneeChangeTo: &to}
+	}
+
+	cases := []struct {
+		name     string
+		events   []store.IssueEvent
+		expected *time.Duration
+	}{
+		{
+			name: "created unassigned",
+			events: []store.IssueEvent{
+				// Initial assignee, from the first changelog on the
+				// assignee
+				assignment(0, ""),
+				assignment(3*time.Hour, "dev"),
+				assignment(5*time.Hour, "other"),
+			},
+			expected: durationPtr(3 * time.Hour),
+		},
+		{
+			name:     "created assigned",
+			events:   []store.IssueEvent{assignment(0, "dev")},
+			expected: durationPtr(0),
+		},
+		{
+			name:   "never assigned",
+			events: []store.IssueEvent{{EventTime: refTime, EventKind: "created", EventAuthor: "reporter"}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := store.IssueHistory{IssueKey: "PJ-1", Project: "Project", Type: "Story", CreatedAt: refTime, Events: tc.events}
+			im := metrics.Compute(h, c, nil)
+			if tc.expected == nil {
+				if im.TimeToFirstAssignment != nil || im.FirstAssignedAt != nil {
+					t.Errorf("expected no first assignment, got %s", *im.TimeToFirstAssignment)
+				}
+				return
+			}
+			expectDuration(t, "TimeToFirstAssignment", *tc.expected, im.TimeToFirstAssignment)
+		})
+	}
+}
+
+func TestCompute_BlockedTime(t *testing.T) {
+	refTime := time.Date(2020, 3, 2, 9, 0, 0, 0, time.UTC)
+	c := metrics.NewClassifier(config.Metrics{}, map[string]string{
+		"Open":        "new",
+		"In Progress": "indeterminate",
+		"Done":        "done",
+	})
+	c.SetBlockerResolutions(map[string]time.Time{
+		"PJ-2": refTime.Add(150 * time.Minute),
+		"PJ-4": refTime,
+	})
+	change := func(minutes int, field string, from, to string) store.IssueEvent {
+		e := store.IssueEvent{EventTime: refTime.Add(time.Duration(minutes) * time.Minute), EventKind: "field_changed", FieldName: &field}
+		if from != "" {
+			e.FieldChangeFrom = &from
+		}
+		if to != "" {
+			e.FieldChangeTo = &to
+		}
+		return e
+	}
+
+	cases := []struct {
+		name     string
+		statuses []string
+		changes  []store.IssueEvent
+		expected time.Duration
+	}{
+		{
+			name:     "overlapping flag and blocker",
+			statuses: []string{"Open", "In Progress", "Done"},
+			changes: []store.IssueEvent{
+				change(60, store.FieldFlagged, "", "Impediment"),
+				change(90, store.FieldBlockedBy, "", "PJ-2"),
+				change(120, store.FieldFlagged, "Impediment", ""),
+				// PJ-2 resolved at 150m
+				change(170, store.FieldBlockedBy, "PJ-2", ""),
+			},
+			expected: 90 * time.Minute,
+		},
+		{
+			name:     "flagged until done",
+			statuses: []string{"Open", "In Progress", "Done"},
+			changes:  []store.IssueEvent{change(120, store.FieldFlagged, "", "Impediment")},
+			expected: 60 * time.Minute,
+		},
+		{
+			name:     "blocker already resolved",
+			statuses: []string{"Open", "In Progress", "Done"},
+			changes: []store.IssueEvent{
+				change(90, store.FieldBlockedBy, "", "PJ-4"),
+				change(120, store.FieldBlockedBy, "PJ-4", ""),
+			},
+		},
+		{
+			name:     "still blocked",
+			statuses: []string{"Open", "In Progress"},
+			changes: []store.IssueEvent{
+				change(90, store.FieldFlagged, "", "Impediment"),
+				change(90, store.FieldBlockedBy, "", "PJ-3"),
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := history(refTime, tc.statuses...)
+			h.Events = append(h.Events, tc.changes...)
+			sort.Sort(store.IssueEventsByTime(h.Events))
+			im := metrics.Compute(h, c, nil)
+			if im.BlockedTime != tc.expected {
+				t.Errorf("expected BlockedTime to be %s, got %s", tc.expected, im.BlockedTime)
+			}
+		})
+	}
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
+
+func TestComputeWeeklyStats(t *testing.T) {
+	monday := time.Date(2019, 3, 4, 0, 0, 0, 0, time.UTC)
+	done := func(key string, doneAt time.Time, cycleTime time.Duration) store.IssueMetrics {
+		return store.IssueMetrics{IssueKey: key, Project: "Project", Type: "Story", DoneAt: &doneAt, CycleTime: &cycleTime}
+	}
+	ims := []store.IssueMetrics{
+		done("PJ-1", monday.Add(24*time.Hour), 1*time.Hour),
+		done("PJ-2", monday.Add(48*time.Hour), 2*time.Hour),
+		// nothing done in the second week
+		done("PJ-3", monday.AddDate(0, 0, 14), 10*time.Hour),
+		{IssueKey: "PJ-4", Project: "Project", Type: "Story"}, // not done
+	}
+
+	stats := metrics.ComputeWeeklyStats(ims, 14*24*time.Hour)
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 weeks of stats, got %d", len(stats))
+	}
+	expected := []struct {
+		throughput int
+		p85, p95   time.Duration
+	}{
+		{2, 2 * time.Hour, 2 * time.Hour},
+		{0, 2 * time.Hour, 2 * time.Hour},   // window covers the first week
+		{1, 10 * time.Hour, 10 * time.Hour}, // window excludes the first week
+	}
+	for i, e := range expected {
+		ws := stats[i]
+		if !ws.Week.Equal(monday.AddDate(0, 0, 7*i)) {
+			t.Errorf("week %d: expected to start on %s, got %s", i, monday.AddDate(0, 0, 7*i), ws.Week)
+		}
+		if ws.Throughput != e.throughput {
+			t.Errorf("week %d: expected throughput %d, got %d", i, e.throughput, ws.Throughput)
+		}
+		expectDuration(t, "CycleTimeP85", e.p85, ws.CycleTimeP85)
+		expectDuration(t, "CycleTimeP95", e.p95, ws.CycleTimeP95)
+	}
+}
+
+// history returns an `IssueHistory` for an issue created at
+// `refTime` going through the specified statuses, one every hour,
+// starting with the issue's creation.
+func TestExplain(t *testing.T) {
+	refTime := time.Date(2020, 3, 2, 9, 0, 0, 0, time.UTC)
+	c := metrics.NewClassifier(config.Metrics{}, map[string]string{
+		"Open":        "new",
+		"In Progress": "indeterminate",
+		"Done":        "done",
+	})
+	h := history(refTime, "Open", "In Progress", "Done", "In Progress", "Done")
+
+	var b strings.Builder
+	if err := metrics.Explain(h, c, &b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, expected := range []string{
+		"2020-03-02 11:00:00 UTC  (initial status) -> In Progress [in progress] => started",
+		"2020-03-02 12:00:00 UTC  (initial status) -> Done [done] => done",
+		"2020-03-02 13:00:00 UTC  (initial status) -> In Progress [in progress] => reopened, not done anymore",
+		"Cycle time: 3h0m0s",
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("expected explanation to contain `%s`, got:\n%s", expected, b.String())
+		}
+	}
+}
+
+func TestCalendar_Between(t *testing.T) {
+	cal, err := metrics.NewCalendar(config.Calendar{
+		Timezone: "Europe/Paris",
+		Holidays: []string{"2020-04-13"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paris, _ := time.LoadLocation("Europe/Paris")
+	at := func(day, hour int) time.Time { return time.Date(2020, 4, day, hour, 0, 0, 0, paris) }
+
+	cases := []struct {
+		name     string
+		from, to time.Time
+		expected time.Duration
+	}{
+		{"within the work hours", at(6, 10), at(6, 12), 2 * time.Hour},
+		{"overnight", at(6, 16), at(7, 10), 2 * time.Hour},
+		{"outside the work hours", at(6, 18), at(7, 8), 0},
+		// Friday 16:00 to Tuesday 10:00, Monday being a holiday
+		{"weekend and holiday", at(10, 16), at(14, 10), 2 * time.Hour},
+		{"whole week", at(6, 0), at(13, 0), 5 * 8 * time.Hour},
+		{"in another timezone", at(6, 10).UTC(), at(6, 12).UTC(), 2 * time.Hour},
+		{"reversed", at(6, 12), at(6, 10), 0},
+	}
+	for _, tc := range cases {
+		if d := cal.Between(tc.from, tc.to); d != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.expected, d)
+		}
+	}
+
+	// The work hours are in wall clock time on the days of DST changes
+	if d := cal.Between(time.Date(2020, 3, 29, 0, 0, 0, 0, paris), time.Date(2020, 3, 31, 0, 0, 0, 0, paris)); d != 8*time.Hour {
+		t.Errorf("expected 8h over the DST change, got %s", d)
+	}
+}
+
+func TestNewCalendar_Invalid(t *testing.T) {
+	for _, cfg := range []config.Calendar{
+		{Timezone: "Mars/Olympus"},
+		{WorkDays: []string{"funday"}},
+		{WorkStart: "9h"},
+		{WorkStart: "18:00", WorkEnd: "09:00"},
+		{Holidays: []string{"25/12/2020"}},
+	} {
+		if _, err := metrics.NewCalendar(cfg); err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
+
+type incrementalStoreMock struct {
+	histories map[string]store.IssueHistory
+	groups    []store.MetricsGroup
+	done      []store.IssueMetrics
+
+	replacedKeys   []string
+	replaced       []store.IssueMetrics
+	replacedGroups []store.MetricsGroup
+	replacedStats  []store.WeeklyStats
+}
+
+func (s *incrementalStoreMock) EachIssueHistoryOf(issueKeys []string, fn func(h store.IssueHistory) error) error {
+	for _, k := range issueKeys {
+		if h, ok := s.histories[k]; ok {
+			if err := fn(h); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *incrementalStoreMock) GetIssueMetricsGroups(issueKeys []string) ([]store.MetricsGroup, error) {
+	return s.groups, nil
+}
+
+func (s *incrementalStoreMock) ReplaceIssuesMetrics(issueKeys []string, ims []store.IssueMetrics) error {
+	s.replacedKeys, s.replaced = issueKeys, ims
+	return nil
+}
+
+func (s *incrementalStoreMock) GetDoneIssueMetrics(groups []store.MetricsGroup) ([]store.IssueMetrics, error) {
+	return s.done, nil
+}
+
+func (s *incrementalStoreMock) ReplaceGroupsWeeklyStats(groups []store.MetricsGroup, wss []store.WeeklyStats) error {
+	s.replacedGroups, s.replacedStats = groups, wss
+	return nil
+}
+
+func TestRefresh(t *testing.T) {
+	refTime := time.Date(2020, 3, 2, 9, 0, 0, 0, time.UTC)
+	c := metrics.NewClassifier(config.Metrics{}, map[string]string{
+		"Open":        "new",
+		"In Progress": "indeterminate",
+		"Done":        "done",
+	})
+	h := history(refTime, "Open", "In Progress", "Done")
+	cycleTime := time.Hour
+	doneAt := refTime.Add(3 * time.Hour)
+	s := &incrementalStoreMock{
+		histories: map[string]store.IssueHistory{"PJ-1": h},
+		// PJ-2 was a bug before being deleted
+		groups: []store.MetricsGroup{{Project: "Project", Type: "Bug"}},
+		done:   []store.IssueMetrics{{IssueKey: "PJ-1", Project: "Project", Type: "Story", DoneAt: &doneAt, CycleTime: &cycleTime}},
+	}
+
+	if err := metrics.Refresh(s, c, nil, 0, []string{"PJ-1", "PJ-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.replacedKeys, []string{"PJ-1", "PJ-2"}) {
+		t.Errorf("expected the metrics of PJ-1 and PJ-2 to be replaced, got %v", s.replacedKeys)
+	}
+	if len(s.replaced) != 1 || s.replaced[0].IssueKey != "PJ-1" {
+		t.Fatalf("expected the metrics of PJ-1 only, got %v", s.replaced)
+	}
+	expectDuration(t, "CycleTime", time.Hour, s.replaced[0].CycleTime)
+	expectedGroups := []store.MetricsGroup{{Project: "Project", Type: "Bug"}, {Project: "Project", Type: "Story"}}
+	if !reflect.DeepEqual(s.replacedGroups, expectedGroups) {
+		t.Errorf("expected the weekly stats of %v to be replaced, got %v", expectedGroups, s.replacedGroups)
+	}
+	if len(s.replacedStats) != 1 || s.replacedStats[0].Throughput != 1 {
+		t.Errorf("expected 1 week of stats with a throughput of 1, got %v", s.replacedStats)
+	}
+
+	t.Run("no changed issues", func(t *testing.T) {
+		s := &incrementalStoreMock{}
+		if err := metrics.Refresh(s, c, nil, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+		if s.replacedKeys != nil || s.replacedGroups != nil {
+			t.Errorf("expected nothing to be replaced")
+		}
+	})
+}
+
+func TestFingerprint(t *testing.T) {
+	categories := map[string]string{"Open": "new", "Done": "done"}
+	c := metrics.NewClassifier(config.Metrics{}, categories)
+	f := metrics.Fingerprint(c, nil, 0)
+	if f != metrics.Fingerprint(metrics.NewClassifier(config.Metrics{}, categories), nil, 0) {
+		t.Errorf("expected the same settings to have the same fingerprint")
+	}
+	if f == metrics.Fingerprint(c, nil, time.Hour) {
+		t.Errorf("expected a different window to change the fingerprint")
+	}
+	cal, err := metrics.NewCalendar(config.Calendar{Timezone: "Europe/Paris"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f == metrics.Fingerprint(c, cal, 0) {
+		t.Errorf("expected a calendar to change the fingerprint")
+	}
+	other := metrics.NewClassifier(config.Metrics{}, map[string]string{"Open": "new", "Done": "indeterminate"})
+	if f == metrics.Fingerprint(other, nil, 0) {
+		t.Errorf("expected different categories to change the fingerprint")
+	}
+}
+
+func TestCompute_Business(t *testing.T) {
+	c := metrics.NewClassifier(config.Metrics{}, map[string]string{
+		"Open":        "new",
+		"In Progress": "indeterminate",
+		"Done":        "done",
+	})
+	cal, err := metrics.NewCalendar(config.Calendar{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Created on Friday at 15:00, in progress from 17:00 and done on
+	// Monday at 10:00
+	created := time.Date(2020, 4, 3, 15, 0, 0, 0, time.UTC)
+	h := history(created, "Open", "In Progress")
+	done := "Done"
+	h.Events = append(h.Events, store.IssueEvent{EventTime: time.Date(2020, 4, 6, 10, 0, 0, 0, time.UTC), EventKind: "status_changed", StatusChangeTo: &done})
+
+	im := metrics.Compute(h, c, cal)
+	expectDuration(t, "LeadTime", 67*time.Hour, im.LeadTime)
+	expectDuration(t, "LeadTimeBusiness", 3*time.Hour, im.LeadTimeBusiness)
+	expectDuration(t, "CycleTimeBusiness", time.Hour, im.CycleTimeBusiness)
+	if len(im.StatusTimes) != 2 {
+		t.Fatalf("expected 2 status times, got %v", im.StatusTimes)
+	}
+	expectDuration(t, "business time in progress", time.Hour, im.StatusTimes[1].BusinessDuration)
+
+	// Without calendar, the business durations are not computed
+	im = metrics.Compute(h, c, nil)
+	if im.LeadTimeBusiness != nil || im.StatusTimes[1].BusinessDuration != nil {
+		t.Errorf("expected no business durations without calendar, got %v", im)
+	}
+}
+
+func history(refTime time.Time, statuses ...string) store.IssueHistory {
+	h := store.IssueHistory{
+		IssueKey:  "PJ-1",
+		Project:   "Project",
+		Type:      "Story",
+		CreatedAt: refTime,
+	}
+	for i, s := range statuses {
+		to := s
+		h.Events = append(h.Events, store.IssueEvent{
+			EventTime:      refTime.Add(time.Duration(i+1) * time.Hour),
+			EventKind:      "status_changed",
+			IssueKey:       h.IssueKey,
+			StatusChangeTo: &to,
+		})
+	}
+	return h
+}
+
+func expectDuration(t *testing.T, name string, expected time.Duration, d *time.Duration) {
+	if d == nil {
+		t.Errorf("expected %s to be %s, got nil", name, expected)
+		return
+	}
+	if *d != expected {
+		t.Errorf("expected %s to be %s, got %s", name, expected, *d)
+	}
+}
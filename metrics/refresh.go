@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// IncrementalStore is the interface of the store used to compute the
+// metrics of some issues only (see `Refresh`). It's implemented by
+// `store.PGStore`.
+type IncrementalStore interface {
+	EachIssueHistoryOf(issueKeys []string, fn func(h store.IssueHistory) error) error
+	GetIssueMetricsGroups(issueKeys []string) ([]store.MetricsGroup, error)
+	ReplaceIssuesMetrics(issueKeys []string, ims []store.IssueMetrics) error
+	GetDoneIssueMetrics(groups []store.MetricsGroup) ([]store.IssueMetrics, error)
+	ReplaceGroupsWeeklyStats(groups []store.MetricsGroup, wss []store.WeeklyStats) error
+}
+
+// Refresh computes the metrics of the issues of the keys and replaces
+// their records, like `Analyze` does for all the issues, so the time
+// it takes depends on the number of issues which changed rather than
+// on the size of the store. The metrics of the issues without events
+// are deleted.
+//
+// The weekly stats of the projects and types of the issues, before
+// and after the refresh, are computed again from the stored metrics
+// of their issues, since their percentiles depend on the other
+// issues.
+func Refresh(s IncrementalStore, c *Classifier, cal *Calendar, window time.Duration, issueKeys []string) error {
+	if len(issueKeys) == 0 {
+		return nil
+	}
+	groups, err := s.GetIssueMetricsGroups(issueKeys)
+	if err != nil {
+		return err
+	}
+	seen := make(map[store.MetricsGroup]bool, len(groups))
+	for _, g := range groups {
+		seen[g] = true
+	}
+
+	ims := make([]store.IssueMetrics, 0, len(issueKeys))
+	err = s.EachIssueHistoryOf(issueKeys, func(h store.IssueHistory) error {
+		im := Compute(h, c, cal)
+		if g := (store.MetricsGroup{Project: im.Project, Type: im.Type}); !seen[g] {
+			seen[g] = true
+			groups = append(groups, g)
+		}
+		ims = append(ims, im)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = s.ReplaceIssuesMetrics(issueKeys, ims); err != nil {
+		return err
+	}
+
+	done, err := s.GetDoneIssueMetrics(groups)
+	if err != nil {
+		return err
+	}
+	if window == 0 {
+		window = DefaultPercentileWindow
+	}
+	return s.ReplaceGroupsWeeklyStats(groups, ComputeWeeklyStats(done, window))
+}
+
+// Fingerprint returns a hash of the settings of the metrics: the
+// classification of the statuses (including the Jira status
+// categories and the aliases), the business calendar and the window
+// of the percentiles. The metrics computed with different settings
+// can't be refreshed incrementally (see `Refresh`).
+func Fingerprint(c *Classifier, cal *Calendar, window time.Duration) string {
+	h := sha256.New()
+	// `fmt` prints the maps sorted by key
+	fmt.Fprintf(h, "%v\n%v\n%v\n%s\n", c.projects, c.categories, c.aliases, window)
+	if cal != nil {
+		days := make([]int, 0, len(cal.workDays))
+		for d := range cal.workDays {
+			days = append(days, int(d))
+		}
+		sort.Ints(days)
+		fmt.Fprintf(h, "%s\n%v\n%s-%s\n%v\n", cal.location, days, cal.start, cal.end, cal.holidays)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
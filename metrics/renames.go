@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// MinRenameScore is the minimum similarity of the transitions of two
+// statuses for the second one to be detected as a rename of the
+// first one (see `DetectStatusRenames`).
+const MinRenameScore = 0.5
+
+// minRenameTransitions is the minimum number of transitions from or
+// to each status of a rename, so statuses barely used are not
+// matched by chance.
+const minRenameTransitions = 3
+
+// statusUsage is how a status of a project is used by the
+// transitions.
+type statusUsage struct {
+	first, last time.Time
+	count       int
+
+	// from and to are the statuses the issues came from and went to.
+	// The initial status of the issues comes from "".
+	from, to map[string]bool
+}
+
+// DetectStatusRenames returns the statuses of each project which
+// were renamed, detected from the transitions between them: a status
+// is a rename of another one if it was first used after the other
+// one was last used, without any transition between them, and the
+// statuses the issues came from and went to are similar (their
+// Jaccard index, the renames of these statuses being taken into
+// account, is at least `MinRenameScore`). A status is renamed at most
+// once, to the most similar status, and the renames are sorted by
+// project and alias.
+func DetectStatusRenames(ts []store.StatusTransition) []store.StatusAlias {
+	byProject := make(map[string][]store.StatusTransition)
+	var projects []string
+	for _, t := range ts {
+		if _, ok := byProject[t.Project]; !ok {
+			projects = append(projects, t.Project)
+		}
+		byProject[t.Project] = append(byProject[t.Project], t)
+	}
+	sort.Strings(projects)
+
+	var aliases []store.StatusAlias
+	for _, p := range projects {
+		aliases = append(aliases, detectProjectRenames(p, byProject[p])...)
+	}
+	return aliases
+}
+
+// detectProjectRenames implements `DetectStatusRenames` for the
+// transitions of a project. The renames are detected in rounds, the
+// renames of a round making the transitions of the statuses renamed
+// at the same time comparable in the next one.
+func detectProjectRenames(project string, ts []store.StatusTransition) []store.StatusAlias {
+	linked := make(map[[2]string]bool)
+	for _, t := range ts {
+		linked[[2]string{t.From, t.To}] = true
+		linked[[2]string{t.To, t.From}] = true
+	}
+	renamed := make(map[string]store.StatusAlias)
+	renamedTo := make(map[string]bool)
+	for {
+		usages := statusUsages(ts, renamed)
+		var statuses []string
+		for s := range usages {
+			statuses = append(statuses, s)
+		}
+		sort.Strings(statuses)
+
+		var candidates []store.StatusAlias
+		for _, o := range statuses {
+			old := usages[o]
+			if _, ok := renamed[o]; ok || old.count < minRenameTransitions {
+				continue
+			}
+			for _, n := range statuses {
+				cur := usages[n]
+				if n == o || renamedTo[n] || cur.count < minRenameTransitions || cur.first.Before(old.last) || linked[[2]string{o, n}] {
+					continue
+				}
+				if score := (jaccard(old.from, cur.from) + jaccard(old.to, cur.to)) / 2; score >= MinRenameScore {
+					candidates = append(candidates, store.StatusAlias{Project: project, Alias: o, Status: n, Score: score})
+				}
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+
+		found := false
+		for _, c := range candidates {
+			if _, ok := renamed[c.Alias]; ok || renamedTo[c.Status] {
+				continue
+			}
+			renamed[c.Alias] = c
+			renamedTo[c.Status] = true
+			found = true
+		}
+		if !found {
+			break
+		}
+	}
+
+	aliases := make([]store.StatusAlias, 0, len(renamed))
+	for _, a := range renamed {
+		aliases = append(aliases, a)
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Alias < aliases[j].Alias })
+	return aliases
+}
+
+// statusUsages returns the usage of each status by the transitions,
+// the statuses they came from and went to being replaced by the
+// statuses they were renamed to.
+func statusUsages(ts []store.StatusTransition, renamed map[string]store.StatusAlias) map[string]*statusUsage {
+	current := func(s string) string {
+		for i := 0; i < len(renamed); i++ {
+			a, ok := renamed[s]
+			if !ok {
+				break
+			}
+			s = a.Status
+		}
+		return s
+	}
+	usages := make(map[string]*statusUsage)
+	use := func(s string, t store.StatusTransition) *statusUsage {
+		u, ok := usages[s]
+		if !ok {
+			u = &statusUsage{first: t.First, last: t.Last, from: make(map[string]bool), to: make(map[string]bool)}
+			usages[s] = u
+		}
+		if t.First.Before(u.first) {
+			u.first = t.First
+		}
+		if t.Last.After(u.last) {
+			u.last = t.Last
+		}
+		u.count += t.Count
+		return u
+	}
+	for _, t := range ts {
+		if t.From == t.To {
+			continue
+		}
+		use(t.To, t).from[current(t.From)] = true
+		if t.From != "" {
+			use(t.From, t).to[current(t.To)] = true
+		}
+	}
+	return usages
+}
+
+// jaccard returns the Jaccard index of the sets of statuses, 1 if
+// both are empty.
+func jaccard(a, b map[string]bool) float64 {
+	inter := 0
+	for s := range a {
+		if b[s] {
+			inter++
+		}
+	}
+	union := len(a) + len(b) - inter
+	if union == 0 {
+		return 1
+	}
+	return float64(inter) / float64(union)
+}
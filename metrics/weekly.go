@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// DefaultPercentileWindow is the trailing window over which the
+// cycle time percentiles are computed when not configured.
+const DefaultPercentileWindow = 4 * 7 * 24 * time.Hour
+
+const week = 7 * 24 * time.Hour
+
+// ComputeWeeklyStats computes the stats of each week, per project and
+// issue type, from the metrics of the issues.
+//
+//   - Weeks start on monday (UTC), like Postgres' `date_trunc('week')`.
+//     Stats are computed for every week between the first and the
+//     last week an issue of the project and type was done.
+//   - The throughput is the number of issues done during the week.
+//   - The 85th and 95th percentiles of the cycle time are computed
+//     over the issues done during the trailing `window` ending with
+//     the week (nearest-rank method). They are nil when no issue was
+//     done in the window.
+func ComputeWeeklyStats(ims []store.IssueMetrics, window time.Duration) []store.WeeklyStats {
+	type group struct{ project, issueType string }
+	done := make(map[group][]store.IssueMetrics)
+	var groups []group
+	for _, im := range ims {
+		if im.DoneAt == nil {
+			continue
+		}
+		g := group{im.Project, im.Type}
+		if _, ok := done[g]; !ok {
+			groups = append(groups, g)
+		}
+		done[g] = append(done[g], im)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].project != groups[j].project {
+			return groups[i].project < groups[j].project
+		}
+		return groups[i].issueType < groups[j].issueType
+	})
+
+	stats := make([]store.WeeklyStats, 0)
+	for _, g := range groups {
+		gims := done[g]
+		sort.Slice(gims, func(i, j int) bool { return gims[i].DoneAt.Before(*gims[j].DoneAt) })
+		first := weekStart(*gims[0].DoneAt)
+		last := weekStart(*gims[len(gims)-1].DoneAt)
+		for w := first; !w.After(last); w = w.Add(week) {
+			end := w.Add(week)
+			ws := store.WeeklyStats{Week: w, Project: g.project, Type: g.issueType}
+			var cycleTimes []time.Duration
+			for _, im := range gims {
+				if !im.DoneAt.Before(end) {
+					break
+				}
+				if !im.DoneAt.Before(w) {
+					ws.Throughput++
+				}
+				if !im.DoneAt.Before(end.Add(-window)) && im.CycleTime != nil {
+					cycleTimes = append(cycleTimes, *im.CycleTime)
+				}
+			}
+			ws.CycleTimeP85 = percentile(cycleTimes, 85)
+			ws.CycleTimeP95 = percentile(cycleTimes, 95)
+			stats = append(stats, ws)
+		}
+	}
+	return stats
+}
+
+// weekStart returns the first instant of the week (monday, UTC)
+// of the time.
+func weekStart(t time.Time) time.Time {
+	t = t.UTC()
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	offset := (int(d.Weekday()) + 6) % 7 // days since monday
+	return d.AddDate(0, 0, -offset)
+}
+
+// percentile returns the `p`th percentile of the durations using
+// the nearest-rank method, or nil if there is none.
+func percentile(ds []time.Duration, p float64) *time.Duration {
+	if len(ds) == 0 {
+		return nil
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	v := sorted[rank-1]
+	return &v
+}
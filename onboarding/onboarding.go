@@ -0,0 +1,302 @@
+// Package onboarding implements the steps of the `init` wizard which
+// don't need a terminal or a DB: sampling issues of the Jira
+// instance, suggesting the mapping of their custom fields and the
+// estimates of their projects, and generating the configuration
+// file from the answers.
+package onboarding
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+)
+
+// DefaultSampleSize is the number of issues scanned to suggest the
+// mapping.
+const DefaultSampleSize = 50
+
+// exampleLength is the maximum length of the example values of the
+// suggestions.
+const exampleLength = 40
+
+// customFieldTypes are the types of custom fields (see
+// `config.CustomField`) of the types of values of Jira fields.
+// Fields of other types (e.g. arrays) can't be mapped to a column.
+var customFieldTypes = map[string]string{
+	"user":     mapping.CustomFieldUser,
+	"option":   mapping.CustomFieldOption,
+	"string":   mapping.CustomFieldText,
+	"number":   mapping.CustomFieldNumber,
+	"date":     mapping.CustomFieldDate,
+	"datetime": mapping.CustomFieldDateTime,
+}
+
+// storyPointsName matches the names of the fields holding the
+// story points, e.g. "Story Points" or "Story point estimate".
+var storyPointsName = regexp.MustCompile(`(?i)^story ?points?\b`)
+
+// Suggestion is a custom field suggested to be mapped to a column.
+type Suggestion struct {
+	Field config.CustomField
+
+	// Coverage is the share of the sampled issues having a value
+	// for the field, between 0 and 1.
+	Coverage float64
+
+	// Example is one of the values of the field, shortened.
+	Example string
+}
+
+// SampleIssues fetches the first `n` issues matching the JQL query.
+// The search is left blocked on its next key once `n` issues are
+// found (see `jira.NewLimitedClient`), so it's meant for one-off
+// commands.
+func SampleIssues(c jira.Client, query string, n int) ([]*extJira.Issue, error) {
+	keys := make(chan string)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.SearchIssues(query, keys)
+	}()
+	var issues []*extJira.Issue
+	for len(issues) < n {
+		k, ok := <-keys
+		if !ok {
+			return issues, <-errs
+		}
+		i, err := c.GetIssue(k)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, i)
+	}
+	return issues, nil
+}
+
+// SuggestCustomFields returns the custom fields having a value in
+// at least one of the sampled issues, with their type and a column
+// named after them (e.g. `issue_team` for "Team"), sorted by
+// decreasing coverage then name. The fields already mapped to the
+// columns of `mapping.Fields` (e.g. the sprints) and those whose
+// values can't be mapped to a column are left aside.
+func SuggestCustomFields(fields []extJira.Field, issues []*extJira.Issue) []Suggestion {
+	if len(issues) == 0 {
+		return nil
+	}
+	columns := make(map[string]bool)
+	builtin := make(map[string]bool)
+	for _, f := range mapping.Fields {
+		columns[f.Column] = true
+		if f.CustomFieldID != "" {
+			builtin[f.CustomFieldID] = true
+		}
+	}
+
+	var suggestions []Suggestion
+	for _, f := range fields {
+		t, ok := customFieldTypes[f.Schema.Type]
+		if !f.Custom || !ok || builtin[f.ID] {
+			continue
+		}
+		count, example := 0, ""
+		for _, i := range issues {
+			if v := i.Fields.Unknowns[f.ID]; hasValue(v) {
+				if count == 0 {
+					example = exampleValue(v)
+				}
+				count++
+			}
+		}
+		if count == 0 {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{
+			Field:    config.CustomField{ID: f.ID, Type: t, Name: f.Name},
+			Coverage: float64(count) / float64(len(issues)),
+			Example:  example,
+		})
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].Coverage != suggestions[j].Coverage {
+			return suggestions[i].Coverage > suggestions[j].Coverage
+		}
+		return suggestions[i].Field.Name < suggestions[j].Field.Name
+	})
+	// The columns are named once sorted, so the most used field
+	// gets the plain name if two fields have the same.
+	for k := range suggestions {
+		f := &suggestions[k].Field
+		f.Column = columnName(f.Name, f.ID, columns)
+		columns[f.Column] = true
+	}
+	return suggestions
+}
+
+// SuggestEstimates returns the estimates of the projects of the
+// sampled issues having a value for a story points field among the
+// suggestions (e.g. "Story Points"), in points.
+func SuggestEstimates(suggestions []Suggestion, issues []*extJira.Issue) []config.ProjectEstimate {
+	var id string
+	for _, s := range suggestions {
+		if s.Field.Type == mapping.CustomFieldNumber && storyPointsName.MatchString(s.Field.Name) {
+			id = s.Field.ID
+			break
+		}
+	}
+	if id == "" {
+		return nil
+	}
+	var estimates []config.ProjectEstimate
+	seen := make(map[string]bool)
+	for _, i := range issues {
+		project := i.Fields.Project.Name
+		if seen[project] || !hasValue(i.Fields.Unknowns[id]) {
+			continue
+		}
+		seen[project] = true
+		estimates = append(estimates, config.ProjectEstimate{Project: project, Field: id, Unit: "points"})
+	}
+	sort.Slice(estimates, func(i, j int) bool { return estimates[i].Project < estimates[j].Project })
+	return estimates
+}
+
+// Settings are the answers to the wizard the configuration file is
+// generated from (see `Config`).
+type Settings struct {
+	BaseURL  string
+	AuthType string
+
+	// Projects restrict the synced issues to those of the projects
+	// (keys), with a source (see `config.Source`). All the issues
+	// are synced if empty.
+	Projects []string
+
+	CustomFields []config.CustomField
+	Estimates    []config.ProjectEstimate
+}
+
+// generatedConfig is the subset of `config.Config` written by
+// `Settings.Config`, without the settings left to their default
+// value. The credentials are not written: they're read from the
+// environment (see `config.JiraAuth`).
+type generatedConfig struct {
+	Jira struct {
+		BaseURL string `json:"base_url"`
+		Auth    struct {
+			Type string `json:"type,omitempty"`
+		} `json:"auth"`
+	} `json:"jira"`
+	Sources []generatedSource `json:"sources,omitempty"`
+	Mapping struct {
+		// CustomFields is not omitted when empty, since
+		// `mapping.DefaultCustomFields` are mapped if it's not set.
+		CustomFields []config.CustomField `json:"custom_fields"`
+		Estimates    *generatedEstimates  `json:"estimates,omitempty"`
+	} `json:"mapping"`
+}
+
+type generatedSource struct {
+	Name string `json:"name"`
+	JQL  string `json:"jql"`
+}
+
+type generatedEstimates struct {
+	Projects []generatedEstimate `json:"projects"`
+}
+
+type generatedEstimate struct {
+	Project string `json:"project"`
+	Field   string `json:"field"`
+	Unit    string `json:"unit"`
+}
+
+// Config returns the configuration file of the settings, as indented
+// JSON. Returns an error if a custom field is not valid (see
+// `mapping.ValidateCustomFields`).
+func (s Settings) Config() ([]byte, error) {
+	if err := mapping.ValidateCustomFields(s.CustomFields); err != nil {
+		return nil, err
+	}
+	var c generatedConfig
+	c.Jira.BaseURL = s.BaseURL
+	c.Jira.Auth.Type = s.AuthType
+	if len(s.Projects) > 0 {
+		c.Sources = []generatedSource{{Name: "jira", JQL: jira.Filter{Projects: s.Projects}.JQL()}}
+	}
+	c.Mapping.CustomFields = append([]config.CustomField{}, s.CustomFields...)
+	if len(s.Estimates) > 0 {
+		c.Mapping.Estimates = &generatedEstimates{}
+		for _, e := range s.Estimates {
+			c.Mapping.Estimates.Projects = append(c.Mapping.Estimates.Projects, generatedEstimate{Project: e.Project, Field: e.Field, Unit: e.Unit})
+		}
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// hasValue returns true if the value of a field is set: not null,
+// an empty string or an empty list.
+func hasValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// exampleValue returns the value of a field as displayed in Jira (the
+// name of a user, the value of an option), shortened to
+// `exampleLength` characters.
+func exampleValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, k := range []string{"displayName", "name", "value"} {
+			if n, ok := v[k].(string); ok {
+				s = n
+				break
+			}
+		}
+	case float64:
+		s = fmt.Sprintf("%g", v)
+	default:
+		s = fmt.Sprintf("%v", v)
+	}
+	if r := []rune(s); len(r) > exampleLength {
+		s = string(r[:exampleLength-1]) + "…"
+	}
+	return s
+}
+
+var nonColumnChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// columnName returns the name of the column of the custom field,
+// `issue_<name>` with the name in snake case, suffixed with the
+// number of the field if the column is already `used` (or
+// `issue_customfield_<number>` if the name has no letter or digit).
+func columnName(name, id string, used map[string]bool) string {
+	snake := strings.Trim(nonColumnChars.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	number := strings.TrimPrefix(id, "customfield_")
+	if snake == "" {
+		return "issue_customfield_" + number
+	}
+	c := "issue_" + snake
+	if used[c] {
+		c = c + "_" + number
+	}
+	return c
+}
@@ -0,0 +1,170 @@
+package onboarding_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/onboarding"
+)
+
+type clientMock struct {
+	issues map[string]*extJira.Issue
+	keys   []string
+}
+
+func (c *clientMock) SearchIssues(query string, issueKeys chan string) error {
+	defer close(issueKeys)
+	for _, k := range c.keys {
+		issueKeys <- k
+	}
+	return nil
+}
+
+func (c *clientMock) GetIssue(issueKey string) (*extJira.Issue, error) {
+	return c.issues[issueKey], nil
+}
+
+func TestSampleIssues(t *testing.T) {
+	c := &clientMock{issues: make(map[string]*extJira.Issue)}
+	for _, k := range []string{"PJ-3", "PJ-2", "PJ-1"} {
+		c.issues[k] = client.NewIssueFixture(k).Issue()
+		c.keys = append(c.keys, k)
+	}
+	for n, expected := range map[int]int{2: 2, 5: 3} {
+		issues, err := onboarding.SampleIssues(c, "ORDER BY updated DESC", n)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(issues) != expected || issues[0].Key != "PJ-3" {
+			t.Errorf("expected the first %d issues for a sample of %d, got %d", expected, n, len(issues))
+		}
+	}
+}
+
+func TestSuggestCustomFields(t *testing.T) {
+	fields := []extJira.Field{
+		{ID: "customfield_10100", Name: "Team", Custom: true, Schema: extJira.FieldSchema{Type: "option"}},
+		{ID: "customfield_10016", Name: "Story Points", Custom: true, Schema: extJira.FieldSchema{Type: "number"}},
+		{ID: "customfield_10200", Name: "Reviewer", Custom: true, Schema: extJira.FieldSchema{Type: "user"}},
+		// Never set
+		{ID: "customfield_10300", Name: "Legacy", Custom: true, Schema: extJira.FieldSchema{Type: "string"}},
+		// Not a custom field, already mapped, or not mappable
+		{ID: "priority", Name: "Priority", Schema: extJira.FieldSchema{Type: "priority"}},
+		{ID: "customfield_10005", Name: "Sprint", Custom: true, Schema: extJira.FieldSchema{Type: "array"}},
+		{ID: "customfield_10011", Name: "Epic Name", Custom: true, Schema: extJira.FieldSchema{Type: "string"}},
+		// Same column as a built-in one
+		{ID: "customfield_10400", Name: "Labels", Custom: true, Schema: extJira.FieldSchema{Type: "string"}},
+	}
+	issues := []*extJira.Issue{
+		client.NewIssueFixture("PJ-1").
+			WithCustomField("customfield_10100", map[string]interface{}{"value": "Payments"}).
+			WithCustomField("customfield_10016", 3.0).
+			WithCustomField("customfield_10011", "Checkout").
+			WithCustomField("customfield_10400", "a,b").
+			Issue(),
+		client.NewIssueFixture("PJ-2").
+			WithCustomField("customfield_10100", map[string]interface{}{"value": "Search"}).
+			WithCustomField("customfield_10200", map[string]interface{}{"name": "alice", "displayName": "Alice"}).
+			WithCustomField("customfield_10300", "").
+			Issue(),
+	}
+
+	expected := []onboarding.Suggestion{
+		{Field: config.CustomField{ID: "customfield_10100", Column: "issue_team", Type: "option", Name: "Team"}, Coverage: 1, Example: "Payments"},
+		{Field: config.CustomField{ID: "customfield_10400", Column: "issue_labels_10400", Type: "text", Name: "Labels"}, Coverage: 0.5, Example: "a,b"},
+		{Field: config.CustomField{ID: "customfield_10200", Column: "issue_reviewer", Type: "user", Name: "Reviewer"}, Coverage: 0.5, Example: "Alice"},
+		{Field: config.CustomField{ID: "customfield_10016", Column: "issue_story_points", Type: "number", Name: "Story Points"}, Coverage: 0.5, Example: "3"},
+	}
+	suggestions := onboarding.SuggestCustomFields(fields, issues)
+	if !reflect.DeepEqual(suggestions, expected) {
+		t.Errorf("expected %+v, got %+v", expected, suggestions)
+	}
+
+	estimates := onboarding.SuggestEstimates(suggestions, issues)
+	expectedEstimates := []config.ProjectEstimate{{Project: "Project", Field: "customfield_10016", Unit: "points"}}
+	if !reflect.DeepEqual(estimates, expectedEstimates) {
+		t.Errorf("expected %+v, got %+v", expectedEstimates, estimates)
+	}
+}
+
+func TestSettings_Config(t *testing.T) {
+	dir, err := ioutil.TempDir("", "onboarding")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := onboarding.Settings{
+		BaseURL:      "https://example.atlassian.net",
+		AuthType:     "basic",
+		Projects:     []string{"PJ", "OPS"},
+		CustomFields: []config.CustomField{{ID: "customfield_10100", Column: "issue_team", Type: "option", Name: "Team"}},
+		Estimates:    []config.ProjectEstimate{{Project: "Project", Field: "customfield_10016", Unit: "points"}},
+	}
+	for _, s := range []onboarding.Settings{s, {BaseURL: "https://jira.example.com"}} {
+		b, err := s.Config()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		path := filepath.Join(dir, "config.json")
+		if err = ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		cfg, err := config.LoadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error loading the generated config: %s", err)
+		}
+		if cfg.Jira.BaseURL != s.BaseURL || cfg.Jira.Auth.Type != s.AuthType {
+			t.Errorf("unexpected `jira` section %+v", cfg.Jira)
+		}
+		// The default custom fields are not mapped if none is chosen
+		if cfg.Mapping.CustomFields == nil || !reflect.DeepEqual(cfg.Mapping.CustomFields, append([]config.CustomField{}, s.CustomFields...)) {
+			t.Errorf("expected the custom fields %+v, got %+v", s.CustomFields, cfg.Mapping.CustomFields)
+		}
+		if len(cfg.Mapping.Estimates.Projects) != len(s.Estimates) {
+			t.Errorf("expected the estimates %+v, got %+v", s.Estimates, cfg.Mapping.Estimates)
+		}
+		if strings.Contains(string(b), "password") {
+			t.Errorf("expected no credentials in the generated config:\n%s", b)
+		}
+	}
+	b, _ := s.Config()
+	if !strings.Contains(string(b), `"jql": "project IN (\"PJ\", \"OPS\")"`) {
+		t.Errorf("expected a source restricted to the projects, got:\n%s", b)
+	}
+
+	s.CustomFields = []config.CustomField{{ID: "team", Column: "issue_team", Type: "option"}}
+	if _, err := s.Config(); err == nil {
+		t.Errorf("expected an error for an invalid custom field")
+	}
+}
+
+func TestPrompter(t *testing.T) {
+	var out bytes.Buffer
+	p := onboarding.NewPrompter(strings.NewReader("https://jira.example.com\n\nmaybe\nn\n"), &out)
+	if a := p.Ask("URL", ""); a != "https://jira.example.com" {
+		t.Errorf("unexpected answer `%s`", a)
+	}
+	if a := p.Ask("Authentication", "basic"); a != "basic" {
+		t.Errorf("expected the default answer, got `%s`", a)
+	}
+	if p.Confirm("Continue?", true) {
+		t.Errorf("expected the question to be asked again until answered `n`")
+	}
+	// The input is closed
+	if !p.Confirm("Continue?", true) {
+		t.Errorf("expected the default answer")
+	}
+	if !strings.Contains(out.String(), "Authentication [basic]: ") || strings.Count(out.String(), "Continue? [Y/n]: ") != 3 {
+		t.Errorf("unexpected questions:\n%s", out.String())
+	}
+}
@@ -0,0 +1,66 @@
+package onboarding
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Prompter asks the questions of the wizard, reading the answers one
+// per line.
+type Prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewPrompter returns a `Prompter` writing the questions to `out`
+// and reading the answers from `in`.
+func NewPrompter(in io.Reader, out io.Writer) *Prompter {
+	return &Prompter{in: bufio.NewReader(in), out: out}
+}
+
+// Ask asks the question and returns the answer, or `def` if the
+// answer is empty (or the input is closed).
+func (p *Prompter) Ask(question, def string) string {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, _ := p.in.ReadString('\n')
+	if line = strings.TrimSpace(line); line != "" {
+		return line
+	}
+	return def
+}
+
+// Confirm asks the yes/no question and returns the answer, `def` if
+// the answer is empty. The question is asked again until the answer
+// is understood.
+func (p *Prompter) Confirm(question string, def bool) bool {
+	choices := "y/N"
+	if def {
+		choices = "Y/n"
+	}
+	for {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, choices)
+		line, err := p.in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		case "":
+			return def
+		}
+		if err != nil {
+			return def
+		}
+	}
+}
+
+// Printf writes the message to the output of the prompter.
+func (p *Prompter) Printf(format string, args ...interface{}) {
+	fmt.Fprintf(p.out, format, args...)
+}
@@ -0,0 +1,303 @@
+// Package outbound posts the events of the issues to webhooks once
+// they're ingested (see `config.OutboundWebhook`), e.g. to notify a
+// deploy tracker when issues transition to "Released", making the
+// store a lightweight event bus for the activity of Jira.
+package outbound
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/telemetry"
+	"github.com/rchampourlier/kaizenizer-source-jira/webhook"
+)
+
+// Defaults of the webhooks' settings
+const (
+	DefaultMaxAttempts = 5
+	DefaultMaxAge      = 24 * time.Hour
+)
+
+// DefaultBackoff is the delay before posting an event again after a
+// failure, doubled after each attempt.
+const DefaultBackoff = time.Second
+
+// QueueSize is the number of events waiting to be posted beyond
+// which `Dispatcher.Handle` blocks.
+const QueueSize = 1000
+
+// requestTimeout is the timeout of the requests posting the events.
+const requestTimeout = 10 * time.Second
+
+// Store is the interface of the store recording the events posted,
+// so an event written again by the next sync of its issue is not
+// posted twice. It's implemented by `store.PGStore`.
+type Store interface {
+	IsWebhookDelivered(webhook string, dedupKey string) (bool, error)
+	RecordWebhookDelivery(webhook string, dedupKey string) error
+}
+
+// Payload is the JSON body posted to the webhooks.
+type Payload struct {
+	// ID identifies the event (see `store.IssueEvent.DedupKey`). An
+	// event is posted again if recording its delivery fails, so
+	// the receivers should ignore the IDs they already received.
+	ID      string `json:"id"`
+	Webhook string `json:"webhook"`
+	Event   Event  `json:"event"`
+}
+
+// Event is the event of an issue posted to the webhooks, with the
+// columns of `jira_issues_events` set for its kind.
+type Event struct {
+	Time               time.Time       `json:"event_time"`
+	Kind               store.EventKind `json:"event_kind"`
+	Author             string          `json:"event_author"`
+	IssueKey           string          `json:"issue_key"`
+	StatusChangeFrom   *string         `json:"status_change_from,omitempty"`
+	StatusChangeTo     *string         `json:"status_change_to,omitempty"`
+	AssigneeChangeFrom *string         `json:"assignee_change_from,omitempty"`
+	AssigneeChangeTo   *string         `json:"assignee_change_to,omitempty"`
+	FieldName          *string         `json:"field_name,omitempty"`
+	FieldChangeFrom    *string         `json:"field_change_from,omitempty"`
+	FieldChangeTo      *string         `json:"field_change_to,omitempty"`
+	SprintID           *int            `json:"sprint_id,omitempty"`
+	SprintName         *string         `json:"sprint_name,omitempty"`
+	CommentID          *string         `json:"comment_id,omitempty"`
+}
+
+// Dispatcher posts the events it handles to the webhooks whose
+// filters they match. The events are posted in the order they're
+// handled, one at a time, by a goroutine started with the first
+// event, so the syncs are not slowed down by the webhooks unless
+// `QueueSize` events are waiting.
+//
+// An event which can't be posted after the webhook's `MaxAttempts`
+// is logged and dropped: it's posted again if its issue is synced
+// again before it's older than the webhook's `MaxAge`.
+type Dispatcher struct {
+	// Client is the HTTP client posting the events.
+	Client *http.Client
+
+	// Backoff is the delay before the second attempt to post an
+	// event (`DefaultBackoff` if 0), doubled after each attempt.
+	Backoff time.Duration
+
+	// Now returns the current time, to compute the age of the
+	// events. Defaults to `time.Now`.
+	Now func() time.Time
+
+	hooks []hook
+	store Store
+	queue chan delivery
+	start sync.Once
+	done  chan struct{}
+}
+
+// hook is a webhook with its filters as sets.
+type hook struct {
+	config.OutboundWebhook
+	kinds    map[store.EventKind]bool
+	statuses map[string]bool
+}
+
+// delivery is an event to post to a webhook.
+type delivery struct {
+	hook  *hook
+	event store.IssueEvent
+}
+
+// NewDispatcher returns a `Dispatcher` posting the events to the
+// webhooks and recording them in `s`. Returns an error if a webhook
+// has no name or URL, two have the same name, or one has an unknown
+// event kind.
+func NewDispatcher(webhooks []config.OutboundWebhook, s Store) (*Dispatcher, error) {
+	d := &Dispatcher{
+		Client: &http.Client{Timeout: requestTimeout},
+		store:  s,
+		queue:  make(chan delivery, QueueSize),
+		done:   make(chan struct{}),
+	}
+	names := make(map[string]bool)
+	for _, w := range webhooks {
+		if w.Name == "" || w.URL == "" {
+			return nil, fmt.Errorf("outbound webhook `%s`: the name and URL are required", w.Name)
+		}
+		if names[w.Name] {
+			return nil, fmt.Errorf("outbound webhook `%s`: duplicate name", w.Name)
+		}
+		names[w.Name] = true
+		h := hook{OutboundWebhook: w, kinds: make(map[store.EventKind]bool), statuses: make(map[string]bool)}
+		for _, k := range w.EventKinds {
+			if !store.EventKind(k).IsValid() {
+				return nil, fmt.Errorf("outbound webhook `%s`: unknown event kind `%s`", w.Name, k)
+			}
+			h.kinds[store.EventKind(k)] = true
+		}
+		for _, s := range w.Statuses {
+			h.statuses[s] = true
+		}
+		if h.MaxAttempts <= 0 {
+			h.MaxAttempts = DefaultMaxAttempts
+		}
+		if h.MaxAge.Duration <= 0 {
+			h.MaxAge.Duration = DefaultMaxAge
+		}
+		d.hooks = append(d.hooks, h)
+	}
+	return d, nil
+}
+
+// Handle queues the event to be posted to the webhooks whose filters
+// it matches. It implements `store.EventHandler`.
+func (d *Dispatcher) Handle(ie store.IssueEvent) error {
+	now := time.Now
+	if d.Now != nil {
+		now = d.Now
+	}
+	for i := range d.hooks {
+		h := &d.hooks[i]
+		if !h.matches(ie) || now().Sub(ie.EventTime) > h.MaxAge.Duration {
+			continue
+		}
+		d.start.Do(func() { go d.run() })
+		d.queue <- delivery{hook: h, event: ie}
+	}
+	return nil
+}
+
+// Close waits for the queued events to be posted. The dispatcher
+// can't handle events anymore.
+func (d *Dispatcher) Close() {
+	if d == nil {
+		return
+	}
+	close(d.queue)
+	started := true
+	d.start.Do(func() { started = false })
+	if started {
+		<-d.done
+	}
+}
+
+// matches returns true if the event matches the filters of the
+// webhook.
+func (h *hook) matches(ie store.IssueEvent) bool {
+	if len(h.kinds) > 0 && !h.kinds[ie.EventKind] {
+		return false
+	}
+	if len(h.statuses) > 0 && ie.EventKind == store.EventStatusChanged {
+		return ie.StatusChangeTo != nil && h.statuses[*ie.StatusChangeTo]
+	}
+	return true
+}
+
+// run posts the queued events until the queue is closed.
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	defer telemetry.Recover()
+	for dl := range d.queue {
+		d.deliver(dl)
+	}
+}
+
+// deliver posts the event to the webhook unless it was already,
+// retrying on failures, and records its delivery.
+func (d *Dispatcher) deliver(dl delivery) {
+	name := dl.hook.Name
+	id := dl.event.DedupKey()
+	delivered, err := d.store.IsWebhookDelivered(name, id)
+	if err != nil {
+		logging.Errorf("Could not check the delivery of event %s to webhook `%s`: %s", dl.event, name, err)
+		return
+	}
+	if delivered {
+		return
+	}
+	body, err := json.Marshal(Payload{ID: id, Webhook: name, Event: event(dl.event)})
+	if err != nil {
+		logging.Errorf("Could not post event %s to webhook `%s`: %s", dl.event, name, err)
+		return
+	}
+	backoff := d.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		if err = d.post(dl.hook, body); err == nil {
+			break
+		}
+		if attempt >= dl.hook.MaxAttempts {
+			logging.Errorf("Could not post event %s to webhook `%s` after %d attempts: %s", dl.event, name, attempt, err)
+			return
+		}
+		logging.Warnf("Could not post event %s to webhook `%s` (attempt %d): %s", dl.event, name, attempt, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if err = d.store.RecordWebhookDelivery(name, id); err != nil {
+		logging.Errorf("Could not record the delivery of event %s to webhook `%s`: %s", dl.event, name, err)
+	}
+}
+
+// post posts the body to the webhook, signed with its secret if it
+// has one (see `Sign`). Returns an error if the response's status is
+// not a success.
+func (d *Dispatcher) post(h *hook, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != "" {
+		req.Header.Set(webhook.SignatureHeader, Sign(body, h.Secret))
+	}
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature of the body with the secret, as
+// verified by the receivers of Jira webhooks (see
+// `webhook.Receiver.SetSecret`): `sha256=<hex>` of its HMAC-SHA256.
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// event returns the posted event of the issue's event.
+func event(ie store.IssueEvent) Event {
+	return Event{
+		Time:               ie.EventTime.UTC(),
+		Kind:               ie.EventKind,
+		Author:             ie.EventAuthor,
+		IssueKey:           ie.IssueKey,
+		StatusChangeFrom:   ie.StatusChangeFrom,
+		StatusChangeTo:     ie.StatusChangeTo,
+		AssigneeChangeFrom: ie.AssigneeChangeFrom,
+		AssigneeChangeTo:   ie.AssigneeChangeTo,
+		FieldName:          ie.FieldName,
+		FieldChangeFrom:    ie.FieldChangeFrom,
+		FieldChangeTo:      ie.FieldChangeTo,
+		SprintID:           ie.SprintID,
+		SprintName:         ie.SprintName,
+		CommentID:          ie.CommentID,
+	}
+}
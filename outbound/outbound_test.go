@@ -0,0 +1,115 @@
+package outbound_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/outbound"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/webhook"
+)
+
+type storeMock struct {
+	mutex     sync.Mutex
+	delivered map[string]bool
+}
+
+func (s *storeMock) IsWebhookDelivered(name string, dedupKey string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.delivered[name+"/"+dedupKey], nil
+}
+
+func (s *storeMock) RecordWebhookDelivery(name string, dedupKey string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.delivered[name+"/"+dedupKey] = true
+	return nil
+}
+
+func TestDispatcher(t *testing.T) {
+	var payloads []outbound.Payload
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// The first attempt fails
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if sig := r.Header.Get(webhook.SignatureHeader); sig != outbound.Sign(body, "secret") {
+			t.Errorf("expected the payload to be signed, got `%s`", sig)
+		}
+		var p outbound.Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("invalid payload: %s", err)
+		}
+		payloads = append(payloads, p)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	now := time.Date(2020, 3, 2, 9, 0, 0, 0, time.UTC)
+	s := &storeMock{delivered: make(map[string]bool)}
+	d, err := outbound.NewDispatcher([]config.OutboundWebhook{{
+		Name:       "deploys",
+		URL:        server.URL,
+		EventKinds: []string{"status_changed"},
+		Statuses:   []string{"Released"},
+		Secret:     "secret",
+	}}, s)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	d.Backoff = time.Millisecond
+	d.Now = func() time.Time { return now }
+
+	status := func(to string, at time.Time) store.IssueEvent {
+		return store.IssueEvent{EventTime: at, EventKind: store.EventStatusChanged, EventAuthor: "dev", IssueKey: "PJ-1", StatusChangeTo: &to}
+	}
+	released := status("Released", now.Add(-time.Hour))
+	for _, ie := range []store.IssueEvent{
+		released,
+		// Written again by the next sync of the issue
+		released,
+		status("Done", now.Add(-time.Hour)),
+		// Too old
+		status("Released", now.Add(-48*time.Hour)),
+		{EventTime: now, EventKind: store.EventCommentAdded, IssueKey: "PJ-1"},
+	} {
+		if err := d.Handle(ie); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	d.Close()
+
+	if len(payloads) != 1 {
+		t.Fatalf("expected 1 event to be posted, got %v", payloads)
+	}
+	p := payloads[0]
+	if p.Webhook != "deploys" || p.ID != released.DedupKey() || p.Event.IssueKey != "PJ-1" || *p.Event.StatusChangeTo != "Released" {
+		t.Errorf("unexpected payload %+v", p)
+	}
+	if requests != 2 {
+		t.Errorf("expected the event to be posted again after the failure, got %d requests", requests)
+	}
+}
+
+func TestNewDispatcher_Invalid(t *testing.T) {
+	for _, ws := range [][]config.OutboundWebhook{
+		{{Name: "deploys"}},
+		{{Name: "deploys", URL: "http://a"}, {Name: "deploys", URL: "http://b"}},
+		{{Name: "deploys", URL: "http://a", EventKinds: []string{"released"}}},
+	} {
+		if _, err := outbound.NewDispatcher(ws, &storeMock{}); err == nil {
+			t.Errorf("expected an error for %+v", ws)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package projection
+
+import (
+	"context"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/metrics"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// metricsProjection is the projection of the metrics of the issues
+// and the weekly stats (see `metrics.Analyze`).
+type metricsProjection struct {
+	store      metrics.Store
+	classifier *metrics.Classifier
+	calendar   *metrics.Calendar
+	window     time.Duration
+}
+
+// NewMetrics returns the projection of the metrics computed from the
+// events of the issues, in `jira_issue_metrics`,
+// `jira_issue_status_times` and `jira_weekly_stats`. See `metrics.Analyze` for `c`, `cal` and `window`.
+//
+// The weekly stats depend on all the issues, so the projection is
+// not maintained during the syncs: it's only updated when rebuilt
+// (e.g. with `analyze --full`) or refreshed (see `Refresher`). If the
+// store implements `StatusAliasesStore`, the renamed statuses are
+// detected first when it's rebuilt (see
+// `metrics.DetectStatusRenames`), stored in `jira_status_aliases` and
+// set on the classifier. If it implements `BlockerResolutionsStore`,
+// the resolution times of the blockers are set on the classifier to
+// compute the blocked time of the issues.
+func NewMetrics(s metrics.Store, c *metrics.Classifier, cal *metrics.Calendar, window time.Duration) Projection {
+	return &metricsProjection{store: s, classifier: c, calendar: cal, window: window}
+}
+
+func (p *metricsProjection) Name() string {
+	return "metrics"
+}
+
+func (p *metricsProjection) Tables() []string {
+	return []string{"jira_issue_metrics", "jira_issue_status_times", "jira_weekly_stats"}
+}
+
+func (p *metricsProjection) Handle(ie store.IssueEvent) error {
+	return nil
+}
+
+func (p *metricsProjection) Rebuild(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if sas, ok := p.store.(StatusAliasesStore); ok {
+		if err := refreshStatusAliases(sas, p.classifier); err != nil {
+			return err
+		}
+	}
+	if err := loadBlockerResolutions(p.store, p.classifier); err != nil {
+		return err
+	}
+	rs, recorded := p.store.(MetricsRefreshStore)
+	var id int64
+	if recorded {
+		var err error
+		if id, err = rs.StartMetricsRefresh(store.MetricsRefreshFull, p.fingerprint()); err != nil {
+			return err
+		}
+	}
+	cs := &countingStore{Store: p.store}
+	if err := metrics.Analyze(cs, p.classifier, p.calendar, p.window); err != nil {
+		return err
+	}
+	if !recorded {
+		return nil
+	}
+	return rs.FinishMetricsRefresh(id, cs.count)
+}
+
+// countingStore is a `metrics.Store` counting the histories of the
+// issues read.
+type countingStore struct {
+	metrics.Store
+	count int
+}
+
+func (s *countingStore) EachIssueHistory(fn func(h store.IssueHistory) error) error {
+	return s.Store.EachIssueHistory(func(h store.IssueHistory) error {
+		s.count++
+		return fn(h)
+	})
+}
+
+// Refresher is implemented by the projections which can be updated
+// with the changes since they were last updated or rebuilt, rather
+// than rebuilt from all the records (e.g. the metrics).
+type Refresher interface {
+	// Refresh updates the projection, rebuilding it if it can't be
+	// updated (e.g. it was never built).
+	Refresh(ctx context.Context) error
+}
+
+// MetricsRefreshStore is implemented by stores able to refresh the
+// metrics incrementally (e.g. `store.PGStore`), by recording when
+// they're computed and finding the issues written since.
+type MetricsRefreshStore interface {
+	metrics.IncrementalStore
+	StartMetricsRefresh(kind string, fingerprint string) (int64, error)
+	FinishMetricsRefresh(id int64, issuesCount int) error
+	GetLastMetricsRefresh(fingerprint string) (*time.Time, error)
+	GetChangedIssueKeys(since time.Time) ([]string, error)
+}
+
+// MetricsRefreshMargin is how long before the start of the last
+// computation of the metrics the events are looked for by
+// `Refresh`, so the events written by transactions still running
+// then are not missed.
+const MetricsRefreshMargin = 5 * time.Minute
+
+// Refresh computes the metrics of the issues whose events were
+// written since the metrics were last computed (see
+// `metrics.Refresh`), the stored status aliases being set on the
+// classifier. The projection is rebuilt if the store can't refresh
+// it (see `MetricsRefreshStore`), or if it was last computed with
+// other settings (see `metrics.Fingerprint`), e.g. after changing
+// the classification of the statuses.
+func (p *metricsProjection) Refresh(ctx context.Context) error {
+	rs, ok := p.store.(MetricsRefreshStore)
+	if !ok {
+		return p.Rebuild(ctx)
+	}
+	if err := loadStatusAliases(p.store, p.classifier); err != nil {
+		return err
+	}
+	if err := loadBlockerResolutions(p.store, p.classifier); err != nil {
+		return err
+	}
+	fingerprint := p.fingerprint()
+	last, err := rs.GetLastMetricsRefresh(fingerprint)
+	if err != nil {
+		return err
+	}
+	if last == nil {
+		logging.Infof("The metrics were not computed with the current settings, computing all of them")
+		return p.Rebuild(ctx)
+	}
+	id, err := rs.StartMetricsRefresh(store.MetricsRefreshIncremental, fingerprint)
+	if err != nil {
+		return err
+	}
+	keys, err := rs.GetChangedIssueKeys(last.Add(-MetricsRefreshMargin))
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = metrics.Refresh(rs, p.classifier, p.calendar, p.window, keys); err != nil {
+		return err
+	}
+	logging.Infof("Refreshed the metrics of %d issues changed since %s", len(keys), last)
+	return rs.FinishMetricsRefresh(id, len(keys))
+}
+
+// fingerprint returns the fingerprint of the settings of the
+// metrics (see `metrics.Fingerprint`).
+func (p *metricsProjection) fingerprint() string {
+	return metrics.Fingerprint(p.classifier, p.calendar, p.window)
+}
+
+// StatusAliasesStore is implemented by stores keeping the former
+// names of the renamed statuses (e.g. `store.PGStore`).
+type StatusAliasesStore interface {
+	GetStatusTransitions() ([]store.StatusTransition, error)
+	ReplaceStatusAliases(aliases []store.StatusAlias) error
+	GetStatusAliases() ([]store.StatusAlias, error)
+}
+
+// refreshStatusAliases replaces the stored status aliases with the
+// renames detected from the transitions, and sets them on the
+// classifier.
+func refreshStatusAliases(s StatusAliasesStore, c *metrics.Classifier) error {
+	ts, err := s.GetStatusTransitions()
+	if err != nil {
+		return err
+	}
+	aliases := metrics.DetectStatusRenames(ts)
+	if err = s.ReplaceStatusAliases(aliases); err != nil {
+		return err
+	}
+	for _, a := range aliases {
+		logging.Infof("Status `%s` of project %s detected as renamed to `%s` (score: %.2f)", a.Alias, a.Project, a.Status, a.Score)
+	}
+	c.SetAliases(aliases)
+	return nil
+}
+
+// loadStatusAliases sets the stored status aliases on the
+// classifier, if the store keeps them (see `StatusAliasesStore`).
+func loadStatusAliases(s interface{}, c *metrics.Classifier) error {
+	sas, ok := s.(StatusAliasesStore)
+	if !ok {
+		return nil
+	}
+	aliases, err := sas.GetStatusAliases()
+	if err != nil {
+		return err
+	}
+	c.SetAliases(aliases)
+	return nil
+}
+
+// BlockerResolutionsStore is implemented by stores returning the
+// resolution times of the issues which blocked other issues (e.g.
+// `store.PGStore`).
+type BlockerResolutionsStore interface {
+	GetBlockerResolutions() (map[string]time.Time, error)
+}
+
+// loadBlockerResolutions sets the resolution times of the blockers
+// on the classifier, if the store returns them (see
+// `BlockerResolutionsStore`).
+func loadBlockerResolutions(s interface{}, c *metrics.Classifier) error {
+	brs, ok := s.(BlockerResolutionsStore)
+	if !ok {
+		return nil
+	}
+	resolutions, err := brs.GetBlockerResolutions()
+	if err != nil {
+		return err
+	}
+	c.SetBlockerResolutions(resolutions)
+	return nil
+}
@@ -0,0 +1,172 @@
+// Package projection builds the query-optimized tables of the
+// warehouse (e.g. the metrics of the issues) from the ingested
+// records.
+//
+// The warehouse has two sides:
+//
+//   - The ingest side is written by the syncs and the webhooks (see
+//     package `jira`): the events of the issues
+//     (`jira_issues_events`) and their current state as fetched from
+//     Jira (`jira_issues_states`, `jira_issue_links`...). These are
+//     the source of truth.
+//   - The projections are derived from the ingested records only, so
+//     they can be dropped and rebuilt at any time (e.g. after
+//     changing how the statuses are classified) with `projections
+//     rebuild`.
+//
+// Custom projections (e.g. team-specific KPIs) are added by
+// implementing `Projection` and registering it with `Register`. They
+// are maintained during the syncs, the events written to the store
+// being passed to their `Handle` method, and can be rebuilt from the
+// event log like the built-in ones.
+package projection
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Projection is a set of tables derived from the ingested records.
+type Projection interface {
+	// Name identifies the projection (e.g. in `projections rebuild
+	// <name>`).
+	Name() string
+
+	// Tables are the tables written by the projection.
+	Tables() []string
+
+	// Handle updates the projection with an event written to the
+	// store. All the events of an issue are written again each time
+	// it's synced, so an event may be handled several times: the
+	// records derived from an event should be replaced, not
+	// accumulated.
+	Handle(ie store.IssueEvent) error
+
+	// Rebuild replaces all the records of the projection with those
+	// derived from the ingested records. It should stop and return
+	// the context's error if the context is done.
+	Rebuild(ctx context.Context) error
+}
+
+// Factory returns a projection writing to the store.
+type Factory func(s *store.PGStore) (Projection, error)
+
+var (
+	registryMutex sync.Mutex
+	registry      []Factory
+)
+
+// Register registers a custom projection, e.g. from the `init`
+// function of its package. Registered projections are listed and
+// rebuilt with the built-in ones, and maintained during the syncs.
+func Register(f Factory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry = append(registry, f)
+}
+
+// Registered returns the projections registered with `Register`, in
+// their registration order.
+func Registered(s *store.PGStore) ([]Projection, error) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	ps := make([]Projection, 0, len(registry))
+	for _, f := range registry {
+		p, err := f(s)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// Find returns the projections with the names, in the order of
+// `ps`, or all of them if `names` is empty. Returns an error if a
+// name doesn't match any projection.
+func Find(ps []Projection, names []string) ([]Projection, error) {
+	if len(names) == 0 {
+		return ps, nil
+	}
+	byName := make(map[string]Projection)
+	for _, p := range ps {
+		byName[p.Name()] = p
+	}
+	wanted := make(map[string]bool)
+	for _, n := range names {
+		if _, ok := byName[n]; !ok {
+			return nil, fmt.Errorf("unknown projection `%s`", n)
+		}
+		wanted[n] = true
+	}
+	var found []Projection
+	for _, p := range ps {
+		if wanted[p.Name()] {
+			found = append(found, p)
+		}
+	}
+	return found, nil
+}
+
+// Rebuild rebuilds the projections in order, stopping at the first
+// failure.
+func Rebuild(ctx context.Context, ps []Projection) error {
+	for _, p := range ps {
+		start := time.Now()
+		if err := p.Rebuild(ctx); err != nil {
+			return fmt.Errorf("error rebuilding projection `%s`: %s", p.Name(), err)
+		}
+		logging.Infof("Rebuilt projection `%s` in %s", p.Name(), time.Since(start))
+	}
+	return nil
+}
+
+// Handler returns the `store.EventHandler` passing the events to the
+// projections (see `store.PGStore.SetEventHandler`). The events are
+// handled one at a time, so projections don't have to be safe for
+// concurrent use. All projections handle the event even if one
+// fails.
+func Handler(ps []Projection) store.EventHandler {
+	var mutex sync.Mutex
+	return func(ie store.IssueEvent) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		var failed error
+		for _, p := range ps {
+			if err := p.Handle(ie); err != nil && failed == nil {
+				failed = fmt.Errorf("error in projection `%s`: %s", p.Name(), err)
+			}
+		}
+		return failed
+	}
+}
+
+// HistoryStore is the interface of the store used by `Replay`. It's
+// implemented by `store.PGStore`.
+type HistoryStore interface {
+	EachIssueHistory(fn func(h store.IssueHistory) error) error
+}
+
+// Replay passes all the events in the store, issue by issue and
+// sorted by time, to `handle` (e.g. a projection's `Handle`), so
+// event-driven projections can be rebuilt from the event log. Stops
+// and returns the error if `handle` returns one or the context is
+// done.
+func Replay(ctx context.Context, s HistoryStore, handle store.EventHandler) error {
+	return s.EachIssueHistory(func(h store.IssueHistory) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, ie := range h.Events {
+			if err := handle(ie); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,117 @@
+package projection_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/projection"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+type projectionMock struct {
+	name    string
+	err     error
+	rebuilt *[]string
+	handled []string
+}
+
+func (p *projectionMock) Name() string     { return p.name }
+func (p *projectionMock) Tables() []string { return []string{p.name} }
+func (p *projectionMock) Handle(ie store.IssueEvent) error {
+	p.handled = append(p.handled, ie.IssueKey)
+	return p.err
+}
+func (p *projectionMock) Rebuild(ctx context.Context) error {
+	*p.rebuilt = append(*p.rebuilt, p.name)
+	return p.err
+}
+
+type historyStoreMock []store.IssueHistory
+
+func (s historyStoreMock) EachIssueHistory(fn func(h store.IssueHistory) error) error {
+	for _, h := range s {
+		if err := fn(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestFindAndRebuild(t *testing.T) {
+	var rebuilt []string
+	ps := []projection.Projection{
+		&projectionMock{name: "a", rebuilt: &rebuilt},
+		&projectionMock{name: "b", err: errors.New("failed"), rebuilt: &rebuilt},
+		&projectionMock{name: "c", rebuilt: &rebuilt},
+	}
+
+	// Projections are rebuilt in their order, whatever the order of
+	// the names
+	found, err := projection.Find(ps, []string{"c", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = projection.Rebuild(context.Background(), found); err != nil {
+		t.Fatal(err)
+	}
+	if len(rebuilt) != 2 || rebuilt[0] != "a" || rebuilt[1] != "c" {
+		t.Errorf("expected `a` and `c` to be rebuilt, got %v", rebuilt)
+	}
+
+	// All projections by default, stopping at the first failure
+	rebuilt = nil
+	found, _ = projection.Find(ps, nil)
+	if err = projection.Rebuild(context.Background(), found); err == nil {
+		t.Errorf("expected the failure of `b` to be returned")
+	}
+	if len(rebuilt) != 2 {
+		t.Errorf("expected the rebuild to stop after `b`, got %v", rebuilt)
+	}
+
+	if _, err = projection.Find(ps, []string{"d"}); err == nil {
+		t.Errorf("expected an error for an unknown projection")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	a := &projectionMock{name: "a"}
+	b := &projectionMock{name: "b", err: errors.New("failed")}
+	c := &projectionMock{name: "c"}
+	h := projection.Handler([]projection.Projection{a, b, c})
+
+	// All projections handle the event even if one fails
+	if err := h(store.IssueEvent{IssueKey: "PJ-1"}); err == nil {
+		t.Errorf("expected the failure of `b` to be returned")
+	}
+	for _, p := range []*projectionMock{a, b, c} {
+		if len(p.handled) != 1 || p.handled[0] != "PJ-1" {
+			t.Errorf("expected `%s` to handle the event, got %v", p.name, p.handled)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	s := historyStoreMock{
+		{IssueKey: "PJ-1", Events: []store.IssueEvent{{IssueKey: "PJ-1"}, {IssueKey: "PJ-1"}}},
+		{IssueKey: "PJ-2", Events: []store.IssueEvent{{IssueKey: "PJ-2"}}},
+	}
+	p := &projectionMock{name: "a"}
+	if err := projection.Replay(context.Background(), s, p.Handle); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.handled) != 3 || p.handled[2] != "PJ-2" {
+		t.Errorf("expected all the events to be handled in order, got %v", p.handled)
+	}
+
+	// Stops if the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.handled = nil
+	if err := projection.Replay(ctx, s, p.Handle); err != context.Canceled {
+		t.Errorf("expected `context.Canceled`, got %v", err)
+	}
+	if len(p.handled) != 0 {
+		t.Errorf("expected no handled event, got %v", p.handled)
+	}
+}
@@ -0,0 +1,119 @@
+package projection
+
+import (
+	"context"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/metrics"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// WIPAgingStore is the interface of the store used by the `wip-aging`
+// projection. It's implemented by `store.PGStore`.
+type WIPAgingStore interface {
+	HistoryStore
+	GetStatusCategories() (map[string]string, error)
+	GetIssueProject(issueKey string) (string, error)
+	UpdateWIPAging(issueKey string, t time.Time, status string, inProgress bool) error
+	ExtendWIPAging() (int64, error)
+	ClearWIPAging() error
+}
+
+// wipAgingProjection is the projection of the daily snapshots of the
+// issues in progress (`jira_wip_aging`).
+type wipAgingProjection struct {
+	store      WIPAgingStore
+	cfg        config.Metrics
+	classifier *metrics.Classifier
+
+	// issueKey and project are the last issue handled and its
+	// project, the events of an issue being handled together.
+	issueKey string
+	project  string
+}
+
+// NewWIPAging returns the projection of the daily snapshots of the
+// issues in progress, with their age, in `jira_wip_aging`. The
+// statuses are classified like for the metrics (see
+// `metrics.NewClassifier`), with the status categories and aliases
+// stored when the projection handles its first event, the former
+// names of the renamed statuses being replaced with the current
+// ones.
+//
+// The snapshots are updated with each `status_changed` event, and
+// extended to the current day at the end of the syncs (see
+// `store.PGStore.ExtendWIPAging`).
+func NewWIPAging(s WIPAgingStore, cfg config.Metrics) Projection {
+	return &wipAgingProjection{store: s, cfg: cfg}
+}
+
+func (p *wipAgingProjection) Name() string {
+	return "wip-aging"
+}
+
+func (p *wipAgingProjection) Tables() []string {
+	return []string{"jira_wip_aging"}
+}
+
+func (p *wipAgingProjection) Handle(ie store.IssueEvent) error {
+	if ie.EventKind != store.EventStatusChanged || ie.StatusChangeTo == nil {
+		return nil
+	}
+	if p.classifier == nil {
+		categories, err := p.store.GetStatusCategories()
+		if err != nil {
+			return err
+		}
+		p.classifier = metrics.NewClassifier(p.cfg, categories)
+		if err = loadStatusAliases(p.store, p.classifier); err != nil {
+			return err
+		}
+	}
+	if ie.IssueKey != p.issueKey {
+		project, err := p.store.GetIssueProject(ie.IssueKey)
+		if err != nil {
+			return err
+		}
+		p.issueKey, p.project = ie.IssueKey, project
+	}
+	status := p.classifier.Status(p.project, *ie.StatusChangeTo)
+	inProgress := p.classifier.Category(p.project, status) == metrics.InProgress
+	return p.store.UpdateWIPAging(ie.IssueKey, ie.EventTime, status, inProgress)
+}
+
+func (p *wipAgingProjection) Rebuild(ctx context.Context) error {
+	categories, err := p.store.GetStatusCategories()
+	if err != nil {
+		return err
+	}
+	p.classifier = metrics.NewClassifier(p.cfg, categories)
+	if err = loadStatusAliases(p.store, p.classifier); err != nil {
+		return err
+	}
+	if err = p.store.ClearWIPAging(); err != nil {
+		return err
+	}
+	err = p.store.EachIssueHistory(func(h store.IssueHistory) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p.issueKey, p.project = h.IssueKey, h.Project
+		for _, ie := range h.Events {
+			if err := p.Handle(ie); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	n, err := p.store.ExtendWIPAging()
+	if err != nil {
+		return err
+	}
+	logging.Debugf("%d WIP aging snapshots extended to the current day", n)
+	return nil
+}
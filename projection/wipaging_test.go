@@ -0,0 +1,79 @@
+package projection_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/projection"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+type wipAgingStoreMock struct {
+	historyStoreMock
+	updates  []string
+	cleared  bool
+	extended bool
+}
+
+func (s *wipAgingStoreMock) GetStatusCategories() (map[string]string, error) {
+	return map[string]string{"Open": "new", "In Progress": "indeterminate", "Closed": "done"}, nil
+}
+
+func (s *wipAgingStoreMock) GetIssueProject(issueKey string) (string, error) {
+	return "PJ", nil
+}
+
+func (s *wipAgingStoreMock) UpdateWIPAging(issueKey string, t time.Time, status string, inProgress bool) error {
+	u := issueKey + " " + status
+	if inProgress {
+		u += " (in progress)"
+	}
+	s.updates = append(s.updates, u)
+	return nil
+}
+
+func (s *wipAgingStoreMock) ExtendWIPAging() (int64, error) {
+	s.extended = true
+	return 0, nil
+}
+
+func (s *wipAgingStoreMock) ClearWIPAging() error {
+	s.cleared = true
+	return nil
+}
+
+func TestWIPAging(t *testing.T) {
+	status := func(s string) *string { return &s }
+	at := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &wipAgingStoreMock{historyStoreMock: historyStoreMock{{
+		IssueKey: "PJ-1",
+		Project:  "PJ",
+		Events: []store.IssueEvent{
+			{IssueKey: "PJ-1", EventKind: store.EventStatusChanged, EventTime: at, StatusChangeTo: status("Open")},
+			{IssueKey: "PJ-1", EventKind: store.EventCommentAdded, EventTime: at.Add(time.Hour)},
+			{IssueKey: "PJ-1", EventKind: store.EventStatusChanged, EventTime: at.Add(2 * time.Hour), StatusChangeTo: status("In Progress")},
+			{IssueKey: "PJ-1", EventKind: store.EventStatusChanged, EventTime: at.Add(3 * time.Hour), StatusChangeTo: status("Review")},
+		},
+	}}}
+
+	// "Review" is in progress for the project
+	cfg := config.Metrics{Projects: map[string]config.ProjectStatuses{"PJ": {Started: []string{"In Progress", "Review"}, Done: []string{"Closed"}}}}
+	p := projection.NewWIPAging(s, cfg)
+	if err := p.Rebuild(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"PJ-1 Open", "PJ-1 In Progress (in progress)", "PJ-1 Review (in progress)"}
+	if len(s.updates) != len(expected) {
+		t.Fatalf("expected updates %v, got %v", expected, s.updates)
+	}
+	for i, u := range expected {
+		if s.updates[i] != u {
+			t.Errorf("expected update %d to be `%s`, got `%s`", i, u, s.updates[i])
+		}
+	}
+	if !s.cleared || !s.extended {
+		t.Errorf("expected the snapshots to be cleared and extended")
+	}
+}
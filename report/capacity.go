@@ -0,0 +1,228 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// CapacityStore is the interface of the store used by the capacity
+// report. It's implemented by `store.PGStore`.
+type CapacityStore interface {
+	GetTeamMemberships(team string) ([]store.TeamMembership, error)
+	GetAssigneeIntervals(assignees []string, from, to time.Time) ([]store.AssigneeInterval, error)
+	GetResolvedIssues(from, to time.Time) ([]store.ResolvedIssue, error)
+}
+
+// DefaultCapacityWeeks is the number of weeks of the capacity report
+// if not set.
+const DefaultCapacityWeeks = 12
+
+// capacityTrendWeeks is the number of weeks the throughput of the
+// capacity report is averaged over.
+const capacityTrendWeeks = 4
+
+// CapacityOptions configures `Capacity`.
+type CapacityOptions struct {
+	Team string
+
+	// Weeks is the number of weeks reported, the last one being the
+	// current week. Defaults to `DefaultCapacityWeeks`.
+	Weeks int
+
+	// Now is the end of the report. Defaults to the current time.
+	Now time.Time
+}
+
+// CapacityRow is the load of a member of the team during a week.
+type CapacityRow struct {
+	// Week is the Monday starting the week (UTC).
+	Week   time.Time
+	Person string
+
+	// WIP is the average number of issues assigned to the person
+	// during the week (or the elapsed part of the current week),
+	// weighted by the time they were assigned.
+	WIP float64
+
+	// Assigned is the number of issues assigned to the person at
+	// some point of the week, and Resolved the number of them
+	// resolved during the week while assigned to the person.
+	Assigned int
+	Resolved int
+
+	// Throughput is the average number of issues resolved per week
+	// over the last `capacityTrendWeeks` weeks of the report.
+	Throughput float64
+
+	// WeeksOfWork estimates the number of weeks needed to resolve
+	// the WIP at the throughput (Little's law), or nil if nothing
+	// was resolved.
+	WeeksOfWork *float64
+}
+
+// Capacity returns the load of the members of the team for each
+// week of the report, from the assignee intervals
+// (`jira_assignee_intervals`) and the resolved issues, to spot the
+// people whose WIP grows faster than their throughput. A person is
+// reported for the weeks during which a membership of the team is
+// valid (see `store.TeamMembership`). Sorted by week and person.
+func Capacity(s CapacityStore, o CapacityOptions) ([]CapacityRow, error) {
+	if o.Weeks <= 0 {
+		o.Weeks = DefaultCapacityWeeks
+	}
+	if o.Now.IsZero() {
+		o.Now = time.Now()
+	}
+	o.Now = o.Now.UTC()
+	tms, err := s.GetTeamMemberships(o.Team)
+	if err != nil {
+		return nil, err
+	}
+	if len(tms) == 0 {
+		return nil, fmt.Errorf("no member found for team `%s`", o.Team)
+	}
+	var people []string
+	memberships := make(map[string][]store.TeamMembership)
+	for _, tm := range tms {
+		if _, ok := memberships[tm.Person]; !ok {
+			people = append(people, tm.Person)
+		}
+		memberships[tm.Person] = append(memberships[tm.Person], tm)
+	}
+	sort.Strings(people)
+
+	from := weekStart(o.Now).AddDate(0, 0, -7*(o.Weeks-1))
+	intervals, err := s.GetAssigneeIntervals(people, from, o.Now)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := s.GetResolvedIssues(from, o.Now)
+	if err != nil {
+		return nil, err
+	}
+	byPerson := make(map[string][]store.AssigneeInterval)
+	byIssue := make(map[string][]store.AssigneeInterval)
+	for _, ai := range intervals {
+		byPerson[ai.Assignee] = append(byPerson[ai.Assignee], ai)
+		byIssue[ai.IssueKey] = append(byIssue[ai.IssueKey], ai)
+	}
+
+	// Resolved issues by person and week, counted for the assignee
+	// at the time of the resolution
+	resolvedBy := make(map[string]map[int]int)
+	for _, ri := range resolved {
+		for _, ai := range byIssue[ri.Key] {
+			if ai.From.After(ri.ResolvedAt) || (ai.To != nil && !ai.To.After(ri.ResolvedAt)) {
+				continue
+			}
+			if resolvedBy[ai.Assignee] == nil {
+				resolvedBy[ai.Assignee] = make(map[int]int)
+			}
+			resolvedBy[ai.Assignee][int(ri.ResolvedAt.Sub(from)/(7*24*time.Hour))]++
+			break
+		}
+	}
+
+	var rows []CapacityRow
+	for w := 0; w < o.Weeks; w++ {
+		start := from.AddDate(0, 0, 7*w)
+		end := start.AddDate(0, 0, 7)
+		if end.After(o.Now) {
+			end = o.Now
+		}
+		for _, p := range people {
+			if !isMember(memberships[p], start, end) {
+				continue
+			}
+			r := CapacityRow{Week: start, Person: p, Resolved: resolvedBy[p][w]}
+			var assigned time.Duration
+			for _, ai := range byPerson[p] {
+				if d := overlap(ai, start, end, o.Now); d > 0 {
+					assigned += d
+					r.Assigned++
+				}
+			}
+			if end.After(start) {
+				r.WIP = float64(assigned) / float64(end.Sub(start))
+			}
+			n := 0
+			for tw := w - capacityTrendWeeks + 1; tw <= w; tw++ {
+				if tw >= 0 {
+					r.Throughput += float64(resolvedBy[p][tw])
+					n++
+				}
+			}
+			r.Throughput /= float64(n)
+			if r.Throughput > 0 {
+				weeks := r.WIP / r.Throughput
+				r.WeeksOfWork = &weeks
+			}
+			rows = append(rows, r)
+		}
+	}
+	return rows, nil
+}
+
+// WriteCapacityCSV writes the rows of the capacity report as CSV,
+// with a header row.
+func WriteCapacityCSV(w io.Writer, rows []CapacityRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"week", "person", "wip", "assigned", "resolved", "throughput", "weeks_of_work"})
+	for _, r := range rows {
+		weeksOfWork := ""
+		if r.WeeksOfWork != nil {
+			weeksOfWork = strconv.FormatFloat(*r.WeeksOfWork, 'f', 2, 64)
+		}
+		cw.Write([]string{
+			r.Week.Format("2006-01-02"),
+			r.Person,
+			strconv.FormatFloat(r.WIP, 'f', 2, 64),
+			strconv.Itoa(r.Assigned),
+			strconv.Itoa(r.Resolved),
+			strconv.FormatFloat(r.Throughput, 'f', 2, 64),
+			weeksOfWork,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// weekStart returns the Monday starting the week of the time, at
+// midnight UTC.
+func weekStart(t time.Time) time.Time {
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+}
+
+// isMember returns true if one of the memberships is valid during
+// [start, end). Their dates are included.
+func isMember(tms []store.TeamMembership, start, end time.Time) bool {
+	for _, tm := range tms {
+		if (tm.From == nil || tm.From.Before(end)) && (tm.To == nil || tm.To.AddDate(0, 0, 1).After(start)) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlap returns the time the interval overlaps [start, end), an
+// interval still running ending at `now`.
+func overlap(ai store.AssigneeInterval, start, end, now time.Time) time.Duration {
+	to := now
+	if ai.To != nil {
+		to = *ai.To
+	}
+	if ai.From.After(start) {
+		start = ai.From
+	}
+	if to.Before(end) {
+		end = to
+	}
+	return end.Sub(start)
+}
@@ -0,0 +1,78 @@
+package report_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/report"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+type capacityStoreMock struct {
+	memberships []store.TeamMembership
+	intervals   []store.AssigneeInterval
+	resolved    []store.ResolvedIssue
+}
+
+func (s *capacityStoreMock) GetTeamMemberships(team string) ([]store.TeamMembership, error) {
+	return s.memberships, nil
+}
+
+func (s *capacityStoreMock) GetAssigneeIntervals(assignees []string, from, to time.Time) ([]store.AssigneeInterval, error) {
+	return s.intervals, nil
+}
+
+func (s *capacityStoreMock) GetResolvedIssues(from, to time.Time) ([]store.ResolvedIssue, error) {
+	return s.resolved, nil
+}
+
+func TestCapacity(t *testing.T) {
+	// Monday, January 8th 2018
+	week := time.Date(2018, 1, 8, 0, 0, 0, 0, time.UTC)
+	at := func(days float64) time.Time { return week.Add(time.Duration(days * 24 * float64(time.Hour))) }
+	timeAddr := func(t time.Time) *time.Time { return &t }
+	left := week.AddDate(0, 0, -1)
+	s := &capacityStoreMock{
+		memberships: []store.TeamMembership{
+			{Person: "alice", Team: "backend"},
+			// Left the team before the current week
+			{Person: "bob", Team: "backend", To: &left},
+		},
+		intervals: []store.AssigneeInterval{
+			// Assigned during the whole previous week, resolved on
+			// Wednesday
+			{IssueKey: "PJ-1", Assignee: "alice", From: at(-10), To: timeAddr(at(2))},
+			// Assigned half of the previous week
+			{IssueKey: "PJ-2", Assignee: "alice", From: at(-3.5)},
+			{IssueKey: "PJ-3", Assignee: "bob", From: at(-7), To: timeAddr(at(-6))},
+		},
+		resolved: []store.ResolvedIssue{
+			{Key: "PJ-1", ResolvedAt: at(1)},
+			{Key: "PJ-3", ResolvedAt: at(-6.5)},
+		},
+	}
+	rows, err := report.Capacity(s, report.CapacityOptions{Team: "backend", Weeks: 2, Now: at(4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err = report.WriteCapacityCSV(&out, rows); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"week,person,wip,assigned,resolved,throughput,weeks_of_work",
+		"2018-01-01,alice,1.50,2,0,0.00,",
+		"2018-01-01,bob,0.14,1,1,1.00,0.14",
+		"2018-01-08,alice,1.50,2,1,0.50,3.00",
+		"",
+	}, "\n")
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	if _, err = report.Capacity(&capacityStoreMock{}, report.CapacityOptions{Team: "frontend"}); err == nil {
+		t.Errorf("expected an error for a team without members")
+	}
+}
@@ -0,0 +1,151 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// CarryoverStore is the interface of the store used by the carryover
+// report. It's implemented by `store.PGStore`.
+type CarryoverStore interface {
+	GetBoardSprints(boardID int) ([]store.Sprint, error)
+	GetSprintMembers(boardID int) ([]store.SprintMember, error)
+}
+
+// CarryoverRow is the carryover of a closed sprint to the next one.
+type CarryoverRow struct {
+	SprintID     int       `json:"sprint_id"`
+	Sprint       string    `json:"sprint"`
+	CompleteDate time.Time `json:"complete_date"`
+
+	// NextSprintID and NextSprint are the sprint started next on
+	// the board, nil and empty if none was.
+	NextSprintID *int   `json:"next_sprint_id"`
+	NextSprint   string `json:"next_sprint"`
+
+	// Issues and Points are those of the issues which were in the
+	// sprint, and Unfinished and UnfinishedPoints those of the
+	// issues not resolved when it was completed.
+	Issues           int     `json:"issues"`
+	Points           float64 `json:"points"`
+	Unfinished       int     `json:"unfinished"`
+	UnfinishedPoints float64 `json:"unfinished_points"`
+
+	// CarriedOver and CarriedOverPoints are those of the unfinished
+	// issues which were in the next sprint too, and Rate the share of
+	// the issues of the sprint carried over.
+	CarriedOver       int     `json:"carried_over"`
+	CarriedOverPoints float64 `json:"carried_over_points"`
+	Rate              float64 `json:"rate"`
+}
+
+// Carryover returns how many issues (and points) rolled from each
+// closed sprint of the board to the next one started on the board,
+// sorted by start date, for retrospectives. The members of the
+// sprints are taken from their history (see
+// `store.PGStore.GetSprintMembers`), so the issues removed from a
+// sprint are counted in it.
+func Carryover(s CarryoverStore, boardID int) ([]CarryoverRow, error) {
+	sprints, err := s.GetBoardSprints(boardID)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.GetSprintMembers(boardID)
+	if err != nil {
+		return nil, err
+	}
+	bySprint := make(map[int][]store.SprintMember)
+	for _, m := range members {
+		bySprint[m.SprintID] = append(bySprint[m.SprintID], m)
+	}
+
+	var rows []CarryoverRow
+	for i, sp := range sprints {
+		if sp.State != "closed" || sp.CompleteDate == nil {
+			continue
+		}
+		r := CarryoverRow{SprintID: sp.ID, Sprint: sp.Name, CompleteDate: *sp.CompleteDate}
+		next := make(map[string]bool)
+		if i+1 < len(sprints) {
+			n := sprints[i+1]
+			r.NextSprintID, r.NextSprint = &n.ID, n.Name
+			for _, m := range bySprint[n.ID] {
+				next[m.IssueKey] = true
+			}
+		}
+		for _, m := range bySprint[sp.ID] {
+			var points float64
+			if m.Points != nil {
+				points = *m.Points
+			}
+			r.Issues++
+			r.Points += points
+			if m.ResolvedAt != nil && !m.ResolvedAt.After(*sp.CompleteDate) {
+				continue
+			}
+			r.Unfinished++
+			r.UnfinishedPoints += points
+			if next[m.IssueKey] {
+				r.CarriedOver++
+				r.CarriedOverPoints += points
+			}
+		}
+		if r.Issues > 0 {
+			r.Rate = float64(r.CarriedOver) / float64(r.Issues)
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+// WriteCarryoverCSV writes the rows of the carryover report as CSV
+// to `w`.
+func WriteCarryoverCSV(w io.Writer, rows []CarryoverRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"sprint_id", "sprint", "completed_at", "next_sprint_id", "next_sprint",
+		"issues", "points", "unfinished", "unfinished_points",
+		"carried_over", "carried_over_points", "rate",
+	})
+	number := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	for _, r := range rows {
+		nextID := ""
+		if r.NextSprintID != nil {
+			nextID = strconv.Itoa(*r.NextSprintID)
+		}
+		cw.Write([]string{
+			strconv.Itoa(r.SprintID),
+			r.Sprint,
+			r.CompleteDate.Format("2006-01-02"),
+			nextID,
+			r.NextSprint,
+			strconv.Itoa(r.Issues),
+			number(r.Points),
+			strconv.Itoa(r.Unfinished),
+			number(r.UnfinishedPoints),
+			strconv.Itoa(r.CarriedOver),
+			number(r.CarriedOverPoints),
+			strconv.FormatFloat(r.Rate, 'f', 2, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteCarryoverJSON writes the rows of the carryover report as a
+// JSON array to `w`.
+func WriteCarryoverJSON(w io.Writer, rows []CarryoverRow) error {
+	if rows == nil {
+		rows = []CarryoverRow{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
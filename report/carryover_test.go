@@ -0,0 +1,71 @@
+package report_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/report"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+type carryoverStoreMock struct {
+	sprints []store.Sprint
+	members []store.SprintMember
+}
+
+func (s *carryoverStoreMock) GetBoardSprints(boardID int) ([]store.Sprint, error) {
+	return s.sprints, nil
+}
+
+func (s *carryoverStoreMock) GetSprintMembers(boardID int) ([]store.SprintMember, error) {
+	return s.members, nil
+}
+
+func TestCarryover(t *testing.T) {
+	day := func(d int) *time.Time {
+		t := time.Date(2020, 3, d, 17, 0, 0, 0, time.UTC)
+		return &t
+	}
+	points := func(p float64) *float64 { return &p }
+	s := &carryoverStoreMock{
+		sprints: []store.Sprint{
+			{ID: 1, Name: "A 1", State: "closed", StartDate: day(2), CompleteDate: day(13)},
+			{ID: 2, Name: "A 2", State: "active", StartDate: day(16)},
+		},
+		members: []store.SprintMember{
+			{SprintID: 1, IssueKey: "PJ-1", Points: points(3), ResolvedAt: day(10)},
+			{SprintID: 1, IssueKey: "PJ-2", Points: points(5), ResolvedAt: day(20)},
+			{SprintID: 1, IssueKey: "PJ-3", Points: points(2)},
+			{SprintID: 1, IssueKey: "PJ-4"},
+			{SprintID: 2, IssueKey: "PJ-2", Points: points(5), ResolvedAt: day(20)},
+			{SprintID: 2, IssueKey: "PJ-4"},
+			{SprintID: 2, IssueKey: "PJ-5", Points: points(1)},
+		},
+	}
+
+	rows, err := report.Carryover(s, 42)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 row (the closed sprint), got %d", len(rows))
+	}
+
+	// PJ-1 was resolved during the sprint, PJ-3 was not finished but
+	// not carried over
+	var buf bytes.Buffer
+	if err = report.WriteCarryoverCSV(&buf, rows); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "sprint_id,sprint,completed_at,next_sprint_id,next_sprint,issues,points,unfinished,unfinished_points,carried_over,carried_over_points,rate\n" +
+		"1,A 1,2020-03-13,2,A 2,4,10,3,7,2,5,0.50\n"
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err = report.WriteCarryoverJSON(&buf, nil); err != nil || buf.String() != "[]\n" {
+		t.Errorf("expected an empty JSON array without rows, got %q (%v)", buf.String(), err)
+	}
+}
@@ -0,0 +1,193 @@
+// Package report implements the reports performed by the `report`
+// action on the data in the store.
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// BlockingLinkTypes are the link types considered as blocking
+// dependencies by `Cycles`.
+var BlockingLinkTypes = []string{"Blocks"}
+
+// LinksStore is the interface of the store used by the links
+// reports. It's implemented by `store.PGStore`.
+type LinksStore interface {
+	GetLinks(linkTypes []string) ([]store.IssueLink, error)
+}
+
+// Cycles detects circular blocking dependencies (A blocks B which
+// blocks A) in the links stored in `jira_issue_links` and writes
+// them to `w`, one per line.
+//
+// Each cycle is reported once, starting with its smallest issue key.
+// Issues involved in several interleaved cycles are reported as part
+// of a single group of issues with one of the cycles as example.
+func Cycles(s LinksStore, w io.Writer) error {
+	links, err := s.GetLinks(BlockingLinkTypes)
+	if err != nil {
+		return err
+	}
+	cycles := FindCycles(BlockingGraph(links))
+	if len(cycles) == 0 {
+		_, err = fmt.Fprintln(w, "No blocking cycle found.")
+		return err
+	}
+	for _, c := range cycles {
+		if _, err = fmt.Fprintf(w, "%s -> %s\n", strings.Join(c, " -> "), c[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BlockingGraph returns the graph of blocking dependencies from the
+// passed links: the keys of the map are the blocking issues, the
+// values the issues they block.
+//
+// Links are stored for both issues, so the same dependency may be
+// found twice (outward from the blocker and inward from the blocked
+// issue). It's only present once in the returned graph.
+func BlockingGraph(links []store.IssueLink) map[string][]string {
+	seen := make(map[[2]string]bool)
+	graph := make(map[string][]string)
+	for _, l := range links {
+		edge := [2]string{l.SourceKey, l.TargetKey}
+		if l.Direction == store.LinkInward {
+			edge = [2]string{l.TargetKey, l.SourceKey}
+		}
+		if seen[edge] {
+			continue
+		}
+		seen[edge] = true
+		graph[edge[0]] = append(graph[edge[0]], edge[1])
+	}
+	for k := range graph {
+		sort.Strings(graph[k])
+	}
+	return graph
+}
+
+// FindCycles returns one cycle for each group of issues depending
+// circularly on each other (strongly connected components of the
+// graph). Cycles are sorted by their first key.
+func FindCycles(graph map[string][]string) [][]string {
+	cycles := make([][]string, 0)
+	for _, component := range stronglyConnectedComponents(graph) {
+		inComponent := make(map[string]bool)
+		for _, k := range component {
+			inComponent[k] = true
+		}
+		if len(component) == 1 && !contains(graph[component[0]], component[0]) {
+			continue // not a cycle, unless the issue blocks itself
+		}
+		sort.Strings(component)
+		cycles = append(cycles, cycleFrom(component[0], graph, inComponent))
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
+
+// stronglyConnectedComponents implements Tarjan's algorithm.
+func stronglyConnectedComponents(graph map[string][]string) [][]string {
+	keys := make([]string, 0, len(graph))
+	for k := range graph {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	index := 0
+	indexes := make(map[string]int)
+	lowLinks := make(map[string]int)
+	onStack := make(map[string]bool)
+	stack := make([]string, 0)
+	components := make([][]string, 0)
+
+	var visit func(k string)
+	visit = func(k string) {
+		indexes[k] = index
+		lowLinks[k] = index
+		index++
+		stack = append(stack, k)
+		onStack[k] = true
+
+		for _, next := range graph[k] {
+			if _, visited := indexes[next]; !visited {
+				visit(next)
+				lowLinks[k] = min(lowLinks[k], lowLinks[next])
+			} else if onStack[next] {
+				lowLinks[k] = min(lowLinks[k], indexes[next])
+			}
+		}
+
+		if lowLinks[k] == indexes[k] {
+			var component []string
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == k {
+					break
+				}
+			}
+			components = append(components, component)
+		}
+	}
+	for _, k := range keys {
+		if _, visited := indexes[k]; !visited {
+			visit(k)
+		}
+	}
+	return components
+}
+
+// cycleFrom returns a path from `start` back to itself, staying
+// within the component (breadth-first, so the path is one of the
+// shortest).
+func cycleFrom(start string, graph map[string][]string, inComponent map[string]bool) []string {
+	previous := map[string]string{}
+	queue := []string{start}
+	for len(queue) > 0 {
+		k := queue[0]
+		queue = queue[1:]
+		for _, next := range graph[k] {
+			if !inComponent[next] {
+				continue
+			}
+			if next == start {
+				path := []string{k}
+				for path[0] != start {
+					path = append([]string{previous[path[0]]}, path...)
+				}
+				return path
+			}
+			if _, seen := previous[next]; !seen {
+				previous[next] = k
+				queue = append(queue, next)
+			}
+		}
+	}
+	return []string{start}
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,68 @@
+package report_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/report"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+type linksStoreMock struct {
+	links []store.IssueLink
+}
+
+func (s *linksStoreMock) GetLinks(linkTypes []string) ([]store.IssueLink, error) {
+	return s.links, nil
+}
+
+func TestBlockingGraph(t *testing.T) {
+	links := []store.IssueLink{
+		{SourceKey: "PJ-1", TargetKey: "PJ-2", LinkType: "Blocks", Direction: store.LinkOutward},
+		{SourceKey: "PJ-2", TargetKey: "PJ-1", LinkType: "Blocks", Direction: store.LinkInward},
+		{SourceKey: "PJ-3", TargetKey: "PJ-2", LinkType: "Blocks", Direction: store.LinkInward},
+	}
+	expected := map[string][]string{
+		"PJ-1": {"PJ-2"},
+		"PJ-2": {"PJ-3"},
+	}
+	if g := report.BlockingGraph(links); !reflect.DeepEqual(g, expected) {
+		t.Errorf("expected graph %v, got %v", expected, g)
+	}
+}
+
+func TestFindCycles(t *testing.T) {
+	graph := map[string][]string{
+		"PJ-1": {"PJ-2"},
+		"PJ-2": {"PJ-3"},
+		"PJ-3": {"PJ-1", "PJ-4"},
+		"PJ-4": {"PJ-5"},
+		"PJ-6": {"PJ-6"},
+		"PJ-7": {"PJ-8"},
+		"PJ-8": {"PJ-7"},
+	}
+	expected := [][]string{
+		{"PJ-1", "PJ-2", "PJ-3"},
+		{"PJ-6"},
+		{"PJ-7", "PJ-8"},
+	}
+	if c := report.FindCycles(graph); !reflect.DeepEqual(c, expected) {
+		t.Errorf("expected cycles %v, got %v", expected, c)
+	}
+}
+
+func TestCycles(t *testing.T) {
+	s := &linksStoreMock{links: []store.IssueLink{
+		{SourceKey: "PJ-1", TargetKey: "PJ-2", LinkType: "Blocks", Direction: store.LinkOutward},
+		{SourceKey: "PJ-1", TargetKey: "PJ-2", LinkType: "Blocks", Direction: store.LinkInward},
+	}}
+	var out bytes.Buffer
+	if err := report.Cycles(s, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "PJ-1 -> PJ-2 -> PJ-1\n"
+	if out.String() != expected {
+		t.Errorf("expected output `%s`, got `%s`", expected, out.String())
+	}
+}
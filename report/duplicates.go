@@ -0,0 +1,275 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// DuplicateLinkTypes are the link types marking an issue as a
+// duplicate of another, reported by `Duplicates` whatever the
+// similarity of the summaries.
+var DuplicateLinkTypes = []string{"Duplicate"}
+
+// DefaultDuplicatesThreshold is the minimum similarity of the
+// summaries of two issues for `Duplicates` to report them, if not
+// set.
+const DefaultDuplicatesThreshold = 0.5
+
+// DuplicatesStore is the interface of the store used by the
+// duplicates report. It's implemented by `store.PGStore`.
+type DuplicatesStore interface {
+	LinksStore
+	GetOpenIssueSummaries() ([]store.IssueSummary, error)
+}
+
+// Embedder returns a vector for each of the texts, e.g. from an
+// embedding model, in the same order. The similarity of two texts is
+// the cosine of their vectors.
+type Embedder func(texts []string) ([][]float64, error)
+
+// DuplicatesOptions configures `Duplicates`.
+type DuplicatesOptions struct {
+	// Threshold is the minimum similarity (between 0 and 1) of the
+	// summaries. Defaults to `DefaultDuplicatesThreshold`.
+	Threshold float64
+
+	// CrossProject restricts the report to the issues of different
+	// projects.
+	CrossProject bool
+
+	// Embed compares the summaries with their vectors if set, rather
+	// than with their trigrams (see `TrigramSimilarity`).
+	Embed Embedder
+}
+
+// DuplicatePair is two issues probably duplicating each other. The
+// key of `A` is lower than the key of `B`.
+type DuplicatePair struct {
+	A, B       store.IssueSummary
+	Similarity float64
+
+	// Linked is true if the issues are linked as duplicates (see
+	// `DuplicateLinkTypes`).
+	Linked bool
+}
+
+// Duplicates returns the pairs of open issues (see
+// `store.GetOpenIssueSummaries`) whose summaries are similar, or
+// which are linked as duplicates, across all the projects, sorted by
+// decreasing similarity. The pairs already linked are reported too,
+// since the duplicate issue is still open.
+func Duplicates(s DuplicatesStore, o DuplicatesOptions) ([]DuplicatePair, error) {
+	if o.Threshold == 0 {
+		o.Threshold = DefaultDuplicatesThreshold
+	}
+	issues, err := s.GetOpenIssueSummaries()
+	if err != nil {
+		return nil, err
+	}
+	links, err := s.GetLinks(DuplicateLinkTypes)
+	if err != nil {
+		return nil, err
+	}
+	texts := make([]string, len(issues))
+	indexes := make(map[string]int, len(issues))
+	for i, is := range issues {
+		texts[i] = is.Summary
+		indexes[is.Key] = i
+	}
+	similarity, candidates, err := summariesSimilarity(texts, o.Embed)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[[2]int]*DuplicatePair)
+	add := func(i, j int) *DuplicatePair {
+		if i > j {
+			i, j = j, i
+		}
+		if p, ok := found[[2]int{i, j}]; ok {
+			return p
+		}
+		if o.CrossProject && issues[i].Project == issues[j].Project {
+			return nil
+		}
+		p := &DuplicatePair{A: issues[i], B: issues[j], Similarity: similarity(i, j)}
+		found[[2]int{i, j}] = p
+		return p
+	}
+	for i := range issues {
+		for _, j := range candidates(i) {
+			if o.CrossProject && issues[i].Project == issues[j].Project {
+				continue
+			}
+			if similarity(i, j) >= o.Threshold {
+				add(i, j)
+			}
+		}
+	}
+	for _, l := range links {
+		i, iOpen := indexes[l.SourceKey]
+		j, jOpen := indexes[l.TargetKey]
+		if !iOpen || !jOpen || i == j {
+			continue
+		}
+		if p := add(i, j); p != nil {
+			p.Linked = true
+		}
+	}
+
+	pairs := make([]DuplicatePair, 0, len(found))
+	for _, p := range found {
+		pairs = append(pairs, *p)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Similarity != pairs[j].Similarity {
+			return pairs[i].Similarity > pairs[j].Similarity
+		}
+		if pairs[i].A.Key != pairs[j].A.Key {
+			return pairs[i].A.Key < pairs[j].A.Key
+		}
+		return pairs[i].B.Key < pairs[j].B.Key
+	})
+	return pairs, nil
+}
+
+// WriteDuplicatesCSV writes the pairs of the duplicates report as
+// CSV, with a header row.
+func WriteDuplicatesCSV(w io.Writer, pairs []DuplicatePair) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"issue_key", "project", "duplicate_key", "duplicate_project", "similarity", "linked", "summary", "duplicate_summary"})
+	for _, p := range pairs {
+		cw.Write([]string{
+			p.A.Key,
+			p.A.Project,
+			p.B.Key,
+			p.B.Project,
+			strconv.FormatFloat(p.Similarity, 'f', 2, 64),
+			strconv.FormatBool(p.Linked),
+			p.A.Summary,
+			p.B.Summary,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// summariesSimilarity returns the similarity of the texts of the
+// indexes, and the indexes greater than an index whose text may be
+// similar to it: those sharing a trigram, or all of them when
+// comparing vectors.
+func summariesSimilarity(texts []string, embed Embedder) (func(i, j int) float64, func(i int) []int, error) {
+	if embed != nil {
+		vectors, err := embed(texts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(vectors) != len(texts) {
+			return nil, nil, fmt.Errorf("expected %d vectors, got %d", len(texts), len(vectors))
+		}
+		similarity := func(i, j int) float64 { return cosine(vectors[i], vectors[j]) }
+		candidates := func(i int) []int {
+			js := make([]int, 0, len(texts)-i-1)
+			for j := i + 1; j < len(texts); j++ {
+				js = append(js, j)
+			}
+			return js
+		}
+		return similarity, candidates, nil
+	}
+
+	sets := make([]map[string]bool, len(texts))
+	index := make(map[string][]int)
+	for i, t := range texts {
+		sets[i] = trigrams(t)
+		for tg := range sets[i] {
+			index[tg] = append(index[tg], i)
+		}
+	}
+	similarity := func(i, j int) float64 { return jaccard(sets[i], sets[j]) }
+	candidates := func(i int) []int {
+		seen := make(map[int]bool)
+		for tg := range sets[i] {
+			for _, j := range index[tg] {
+				if j > i {
+					seen[j] = true
+				}
+			}
+		}
+		js := make([]int, 0, len(seen))
+		for j := range seen {
+			js = append(js, j)
+		}
+		sort.Ints(js)
+		return js
+	}
+	return similarity, candidates, nil
+}
+
+// TrigramSimilarity returns the similarity of the texts, like
+// `similarity` of Postgres' `pg_trgm`: the number of trigrams they
+// share divided by the number of their distinct trigrams. The
+// trigrams are those of the lowercased words, padded with two spaces
+// before and one after.
+func TrigramSimilarity(a, b string) float64 {
+	return jaccard(trigrams(a), trigrams(b))
+}
+
+// trigrams returns the trigrams of the text (see
+// `TrigramSimilarity`).
+func trigrams(text string) map[string]bool {
+	set := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		runes := []rune("  " + w + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			set[string(runes[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// jaccard returns the size of the intersection of the sets divided by
+// the size of their union, 0 if both are empty.
+func jaccard(a, b map[string]bool) float64 {
+	shared := 0
+	for k := range a {
+		if b[k] {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
+
+// cosine returns the cosine of the vectors, 0 if one of them is
+// null.
+func cosine(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := 0; i < len(a) && i < len(b); i++ {
+		dot += a[i] * b[i]
+	}
+	for _, v := range a {
+		na += v * v
+	}
+	for _, v := range b {
+		nb += v * v
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
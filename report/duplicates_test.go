@@ -0,0 +1,128 @@
+package report_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/report"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+type duplicatesStoreMock struct {
+	linksStoreMock
+	summaries []store.IssueSummary
+}
+
+func (s *duplicatesStoreMock) GetOpenIssueSummaries() ([]store.IssueSummary, error) {
+	return s.summaries, nil
+}
+
+func TestTrigramSimilarity(t *testing.T) {
+	if s := report.TrigramSimilarity("Login fails", "login FAILS!"); s != 1 {
+		t.Errorf("expected the case and punctuation to be ignored, got %f", s)
+	}
+	if s := report.TrigramSimilarity("Login fails", "Export to CSV"); s != 0 {
+		t.Errorf("expected unrelated summaries not to be similar, got %f", s)
+	}
+	if s := report.TrigramSimilarity("", ""); s != 0 {
+		t.Errorf("expected empty summaries not to be similar, got %f", s)
+	}
+	s := report.TrigramSimilarity("Login fails with SSO", "Login fails with SAML SSO")
+	if s < 0.5 || s >= 1 {
+		t.Errorf("expected close summaries to be similar, got %f", s)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	s := &duplicatesStoreMock{
+		summaries: []store.IssueSummary{
+			{Key: "APP-1", Project: "APP", Summary: "Login fails with SSO"},
+			{Key: "APP-2", Project: "APP", Summary: "Login fails with SSO"},
+			{Key: "OPS-1", Project: "OPS", Summary: "Login fails with SAML SSO"},
+			{Key: "OPS-2", Project: "OPS", Summary: "Rotate the certificates"},
+			{Key: "OPS-3", Project: "OPS", Summary: "Renew TLS certs"},
+		},
+		linksStoreMock: linksStoreMock{links: []store.IssueLink{
+			{SourceKey: "OPS-2", TargetKey: "OPS-3", LinkType: "Duplicate", Direction: store.LinkOutward},
+			{SourceKey: "OPS-3", TargetKey: "OPS-2", LinkType: "Duplicate", Direction: store.LinkInward},
+			{SourceKey: "OPS-3", TargetKey: "OPS-9", LinkType: "Duplicate", Direction: store.LinkOutward}, // resolved
+		}},
+	}
+
+	pairs, err := report.Duplicates(s, report.DuplicatesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		a, b   string
+		linked bool
+	}{
+		{"APP-1", "APP-2", false},
+		{"APP-1", "OPS-1", false},
+		{"APP-2", "OPS-1", false},
+		{"OPS-2", "OPS-3", true},
+	}
+	if len(pairs) != len(expected) {
+		t.Fatalf("expected %d pairs, got %v", len(expected), pairs)
+	}
+	for i, e := range expected {
+		p := pairs[i]
+		if p.A.Key != e.a || p.B.Key != e.b || p.Linked != e.linked {
+			t.Errorf("pair %d: expected %s/%s (linked: %t), got %s/%s (linked: %t)", i, e.a, e.b, e.linked, p.A.Key, p.B.Key, p.Linked)
+		}
+	}
+
+	t.Run("cross-project", func(t *testing.T) {
+		pairs, err := report.Duplicates(s, report.DuplicatesOptions{CrossProject: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pairs) != 2 || pairs[0].B.Key != "OPS-1" || pairs[1].B.Key != "OPS-1" {
+			t.Errorf("expected the pairs with OPS-1 only, got %v", pairs)
+		}
+	})
+
+	t.Run("embeddings", func(t *testing.T) {
+		embed := func(texts []string) ([][]float64, error) {
+			vectors := make([][]float64, len(texts))
+			for i, t := range texts {
+				vectors[i] = []float64{1, 0}
+				if t == "Rotate the certificates" || t == "Renew TLS certs" {
+					vectors[i] = []float64{0, 1}
+				}
+			}
+			return vectors, nil
+		}
+		pairs, err := report.Duplicates(s, report.DuplicatesOptions{Threshold: 0.9, Embed: embed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pairs) != 4 || pairs[3].A.Key != "OPS-2" || pairs[3].Similarity != 1 {
+			t.Errorf("expected OPS-2 and OPS-3 to be similar, got %v", pairs)
+		}
+
+		failing := func(texts []string) ([][]float64, error) { return nil, errors.New("unavailable") }
+		if _, err := report.Duplicates(s, report.DuplicatesOptions{Embed: failing}); err == nil {
+			t.Errorf("expected the error of the embedder")
+		}
+	})
+}
+
+func TestWriteDuplicatesCSV(t *testing.T) {
+	pairs := []report.DuplicatePair{{
+		A:          store.IssueSummary{Key: "APP-1", Project: "APP", Summary: "Login fails, again"},
+		B:          store.IssueSummary{Key: "OPS-1", Project: "OPS", Summary: "Login fails"},
+		Similarity: 0.6666,
+		Linked:     true,
+	}}
+	var b bytes.Buffer
+	if err := report.WriteDuplicatesCSV(&b, pairs); err != nil {
+		t.Fatal(err)
+	}
+	expected := "issue_key,project,duplicate_key,duplicate_project,similarity,linked,summary,duplicate_summary\n" +
+		"APP-1,APP,OPS-1,OPS,0.67,true,\"Login fails, again\",Login fails\n"
+	if b.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
@@ -0,0 +1,145 @@
+package report
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// SprintsStore is the interface of the store used by `Sprints`.
+// It's implemented by `store.PGStore`.
+type SprintsStore interface {
+	GetSprintReconciliations() ([]store.SprintReconciliation, error)
+}
+
+// Sprints writes the figures of the sprint reports of Jira next to
+// those computed from the records, and their differences (the
+// records' figure minus Jira's one), as CSV to `w`, one row per
+// closed sprint whose report was synced.
+func Sprints(s SprintsStore, w io.Writer) error {
+	rs, err := s.GetSprintReconciliations()
+	if err != nil {
+		return err
+	}
+	return WriteSprintsCSV(w, rs)
+}
+
+// WriteSprintsCSV writes the comparisons of the sprint reports as
+// CSV to `w`.
+func WriteSprintsCSV(w io.Writer, rs []store.SprintReconciliation) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"sprint_id", "sprint", "board_id", "completed_at",
+		"jira_completed", "warehouse_completed", "completed_delta",
+		"jira_not_completed", "warehouse_not_completed", "not_completed_delta",
+		"jira_added", "warehouse_added", "added_delta",
+		"jira_completed_points", "warehouse_completed_points", "completed_points_delta",
+	})
+	points := func(p float64) string {
+		return strconv.FormatFloat(p, 'f', -1, 64)
+	}
+	for _, r := range rs {
+		completedAt := ""
+		if r.CompleteDate != nil {
+			completedAt = r.CompleteDate.Format("2006-01-02")
+		}
+		cw.Write([]string{
+			strconv.Itoa(r.SprintID),
+			r.SprintName,
+			strconv.Itoa(r.BoardID),
+			completedAt,
+			strconv.Itoa(r.JiraCompletedIssues),
+			strconv.Itoa(r.WarehouseCompletedIssues),
+			strconv.Itoa(r.WarehouseCompletedIssues - r.JiraCompletedIssues),
+			strconv.Itoa(r.JiraNotCompletedIssues),
+			strconv.Itoa(r.WarehouseNotCompletedIssues),
+			strconv.Itoa(r.WarehouseNotCompletedIssues - r.JiraNotCompletedIssues),
+			strconv.Itoa(r.JiraAddedIssues),
+			strconv.Itoa(r.WarehouseAddedIssues),
+			strconv.Itoa(r.WarehouseAddedIssues - r.JiraAddedIssues),
+			points(r.JiraCompletedPoints),
+			points(r.WarehouseCompletedPoints),
+			points(r.WarehouseCompletedPoints - r.JiraCompletedPoints),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Levels of the `SprintDiscrepancy`s.
+const (
+	DiscrepancyWarn  = "warn"
+	DiscrepancyError = "error"
+)
+
+// SprintThresholds are the thresholds of `SprintDiscrepancies`: the
+// differences between the issue counts of the records and of Jira,
+// relative to Jira's counts (e.g. 0.05 for 5%), beyond which a
+// sprint is reported. A threshold of 0 is disabled.
+type SprintThresholds struct {
+	Warn  float64
+	Error float64
+}
+
+// SprintDiscrepancy is a sprint whose issue counts differ from those
+// of its sprint report of Jira beyond a threshold.
+type SprintDiscrepancy struct {
+	store.SprintReconciliation
+
+	// Figure is the issue count with the largest difference:
+	// "completed", "not_completed" or "added".
+	Figure string
+
+	// Gap is the difference of the figure, relative to Jira's
+	// count (1 if Jira's count is 0).
+	Gap   float64
+	Level string
+}
+
+// SprintDiscrepancies returns the sprints whose completed, not
+// completed or added issue counts differ from Jira's by more than
+// the thresholds, at the level of the highest threshold exceeded, in
+// the order of `rs`.
+func SprintDiscrepancies(rs []store.SprintReconciliation, t SprintThresholds) []SprintDiscrepancy {
+	var ds []SprintDiscrepancy
+	for _, r := range rs {
+		d := SprintDiscrepancy{SprintReconciliation: r}
+		for _, f := range []struct {
+			name            string
+			jira, warehouse int
+		}{
+			{"completed", r.JiraCompletedIssues, r.WarehouseCompletedIssues},
+			{"not_completed", r.JiraNotCompletedIssues, r.WarehouseNotCompletedIssues},
+			{"added", r.JiraAddedIssues, r.WarehouseAddedIssues},
+		} {
+			if gap := countGap(f.jira, f.warehouse); gap > d.Gap {
+				d.Figure, d.Gap = f.name, gap
+			}
+		}
+		switch {
+		case t.Error > 0 && d.Gap > t.Error:
+			d.Level = DiscrepancyError
+		case t.Warn > 0 && d.Gap > t.Warn:
+			d.Level = DiscrepancyWarn
+		default:
+			continue
+		}
+		ds = append(ds, d)
+	}
+	return ds
+}
+
+// countGap returns the difference between the counts, relative to
+// Jira's count, or 1 if Jira's count is 0 but not the other one.
+func countGap(jira, warehouse int) float64 {
+	switch {
+	case jira == warehouse:
+		return 0
+	case jira == 0:
+		return 1
+	}
+	return math.Abs(float64(warehouse-jira)) / float64(jira)
+}
@@ -0,0 +1,89 @@
+package report_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/report"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+type sprintsStoreMock struct {
+	reconciliations []store.SprintReconciliation
+}
+
+func (s *sprintsStoreMock) GetSprintReconciliations() ([]store.SprintReconciliation, error) {
+	return s.reconciliations, nil
+}
+
+func TestSprints(t *testing.T) {
+	completed := time.Date(2020, 3, 13, 17, 0, 0, 0, time.UTC)
+	s := &sprintsStoreMock{reconciliations: []store.SprintReconciliation{
+		{
+			SprintID:                    10,
+			SprintName:                  "A 1",
+			BoardID:                     1,
+			CompleteDate:                &completed,
+			JiraCompletedIssues:         8,
+			WarehouseCompletedIssues:    7,
+			JiraNotCompletedIssues:      2,
+			WarehouseNotCompletedIssues: 3,
+			JiraAddedIssues:             1,
+			WarehouseAddedIssues:        1,
+			JiraCompletedPoints:         21,
+			WarehouseCompletedPoints:    18.5,
+		},
+	}}
+
+	var buf bytes.Buffer
+	if err := report.Sprints(s, &buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "sprint_id,sprint,board_id,completed_at," +
+		"jira_completed,warehouse_completed,completed_delta," +
+		"jira_not_completed,warehouse_not_completed,not_completed_delta," +
+		"jira_added,warehouse_added,added_delta," +
+		"jira_completed_points,warehouse_completed_points,completed_points_delta\n" +
+		"10,A 1,1,2020-03-13,8,7,-1,2,3,1,1,1,0,21,18.5,-2.5\n"
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestSprintDiscrepancies(t *testing.T) {
+	rs := []store.SprintReconciliation{
+		// Matches Jira
+		{SprintID: 10, JiraCompletedIssues: 40, WarehouseCompletedIssues: 40, JiraNotCompletedIssues: 5, WarehouseNotCompletedIssues: 5},
+		// 1 completed issue of 40 missing: 2.5%
+		{SprintID: 20, JiraCompletedIssues: 40, WarehouseCompletedIssues: 39, JiraNotCompletedIssues: 5, WarehouseNotCompletedIssues: 5},
+		// 1 added issue of 10 missing: 10%
+		{SprintID: 30, JiraCompletedIssues: 40, WarehouseCompletedIssues: 39, JiraAddedIssues: 10, WarehouseAddedIssues: 9},
+		// An added issue while Jira has none
+		{SprintID: 40, JiraCompletedIssues: 40, WarehouseCompletedIssues: 40, WarehouseAddedIssues: 1},
+	}
+	ds := report.SprintDiscrepancies(rs, report.SprintThresholds{Warn: 0.01, Error: 0.05})
+	expected := []struct {
+		sprintID int
+		figure   string
+		gap      float64
+		level    string
+	}{
+		{20, "completed", 0.025, report.DiscrepancyWarn},
+		{30, "added", 0.1, report.DiscrepancyError},
+		{40, "added", 1, report.DiscrepancyError},
+	}
+	if len(ds) != len(expected) {
+		t.Fatalf("expected %d discrepancies, got %v", len(expected), ds)
+	}
+	for i, e := range expected {
+		d := ds[i]
+		if d.SprintID != e.sprintID || d.Figure != e.figure || d.Gap != e.gap || d.Level != e.level {
+			t.Errorf("expected sprint %d to differ by %v on `%s` (%s), got sprint %d by %v on `%s` (%s)", e.sprintID, e.gap, e.figure, e.level, d.SprintID, d.Gap, d.Figure, d.Level)
+		}
+	}
+
+	if ds := report.SprintDiscrepancies(rs, report.SprintThresholds{}); len(ds) != 0 {
+		t.Errorf("expected no discrepancy without thresholds, got %v", ds)
+	}
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Assertion is a consistency check of the records, e.g. run at the
+// end of the syncs to catch the bugs of the data before the
+// dashboards do. Its query counts the records violating it.
+type Assertion struct {
+	Name        string
+	Description string
+
+	// Query returns the number of records violating the assertion,
+	// e.g. `SELECT COUNT(*) FROM jira_issues_states WHERE ...`.
+	Query string
+
+	// Fail is true if a violation must fail the run, false if it's
+	// only logged as a warning.
+	Fail bool
+}
+
+// BuiltinAssertions are the assertions which can be enabled by name
+// (see `config.Assertion`).
+var BuiltinAssertions = []Assertion{
+	{
+		Name:        "resolved_issues_have_resolved_at",
+		Description: "Every issue of the done status category has a resolution time.",
+		Query:       `SELECT COUNT(*) FROM jira_issues_states WHERE issue_status_category = 'done' AND issue_resolved_at IS NULL AND issue_deleted_at IS NULL;`,
+	},
+	{
+		Name:        "no_event_before_creation",
+		Description: "No event predates the creation of its issue.",
+		Query:       `SELECT COUNT(*) FROM jira_issues_events WHERE event_time < issue_created_at;`,
+	},
+	{
+		Name:        "events_have_issue",
+		Description: "Every event belongs to an issue of `jira_issues_states`.",
+		Query:       `SELECT COUNT(*) FROM jira_issues_events e WHERE NOT EXISTS (SELECT 1 FROM jira_issues_states s WHERE s.issue_key = e.issue_key);`,
+	},
+	{
+		Name:        "issues_have_created_event",
+		Description: "Every issue has a `created` event.",
+		Query:       `SELECT COUNT(*) FROM jira_issues_states s WHERE NOT EXISTS (SELECT 1 FROM jira_issues_events e WHERE e.issue_key = s.issue_key AND e.event_kind = 'created');`,
+	},
+}
+
+// BuiltinAssertion returns the built-in assertion with the name,
+// false if there is none.
+func BuiltinAssertion(name string) (Assertion, bool) {
+	for _, a := range BuiltinAssertions {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Assertion{}, false
+}
+
+// Violations returns the number of records violating the
+// assertion. The query is run in a read-only transaction.
+func (s *PGStore) Violations(a Assertion) (n int, err error) {
+	tx, err := s.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow(a.Query).Scan(&n)
+	return n, err
+}
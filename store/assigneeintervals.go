@@ -0,0 +1,113 @@
+package store
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AssigneeInterval is an interval during which an issue was
+// assigned, as stored in `jira_assignee_intervals`.
+type AssigneeInterval struct {
+	IssueKey string
+	Assignee string
+	From     time.Time
+
+	// To is nil if the issue is still assigned.
+	To *time.Time
+}
+
+// assigneeIntervalsTables are the tables created with `CreateTables`
+// to store the intervals during which the issues were assigned,
+// derived from the `assignee_changed` events at the end of each
+// sync (see `RefreshAssigneeIntervals`).
+//
+// An interval starts when the issue is assigned to `assignee` and
+// ends when it's assigned to someone else or unassigned (`to_time`),
+// or is still running if `to_time` is NULL. The unassigned periods
+// have no interval. E.g. to compute the time each user had the
+// issues of a sprint assigned:
+//
+//	SELECT a.assignee, SUM(LEAST(COALESCE(a.to_time, NOW()), sp.end_date) - GREATEST(a.from_time, sp.start_date))
+//	FROM jira_assignee_intervals a
+//	JOIN jira_sprints sp ON sp.name = 'Sprint 42'
+//	WHERE a.from_time < sp.end_date AND (a.to_time IS NULL OR a.to_time > sp.start_date)
+//	GROUP BY a.assignee;
+var assigneeIntervalsTables = []string{
+	`CREATE TABLE IF NOT EXISTS "jira_assignee_intervals" (
+		"issue_key" TEXT NOT NULL,
+		"assignee" TEXT NOT NULL,
+		"assignee_account_id" TEXT,
+		"from_time" TIMESTAMP NOT NULL,
+		"to_time" TIMESTAMP
+	);`,
+	`CREATE INDEX IF NOT EXISTS "jira_assignee_intervals_issue_key_idx" ON "jira_assignee_intervals" ("issue_key");`,
+	`CREATE INDEX IF NOT EXISTS "jira_assignee_intervals_assignee_idx" ON "jira_assignee_intervals" ("assignee", "from_time");`,
+}
+
+// refreshAssigneeIntervalsQuery inserts the intervals of all the
+// issues. The changes of the same time are ordered by their ID, the
+// order they were mapped in, and the empty intervals are skipped.
+const refreshAssigneeIntervalsQuery = `INSERT INTO jira_assignee_intervals (issue_key, assignee, assignee_account_id, from_time, to_time)
+	SELECT issue_key, assignee, assignee_account_id, from_time, to_time
+	FROM (
+		SELECT
+			issue_key,
+			assignee_change_to AS assignee,
+			assignee_change_to_account_id AS assignee_account_id,
+			event_time AS from_time,
+			LEAD(event_time) OVER (PARTITION BY issue_key ORDER BY event_time, id) AS to_time
+		FROM jira_issues_events
+		WHERE event_kind = 'assignee_changed'
+	) changes
+	WHERE assignee IS NOT NULL AND (to_time IS NULL OR to_time > from_time);`
+
+// RefreshAssigneeIntervals replaces the intervals of
+// `jira_assignee_intervals` with those derived from the events of
+// the issues, within a transaction, and returns their number.
+func (s *PGStore) RefreshAssigneeIntervals() (n int64, err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(`DELETE FROM jira_assignee_intervals;`); err != nil {
+		return 0, err
+	}
+	return execCount(tx, refreshAssigneeIntervalsQuery)
+}
+
+// GetAssigneeIntervals returns the intervals of the assignees
+// overlapping [from, to), sorted by assignee and start.
+func (s *PGStore) GetAssigneeIntervals(assignees []string, from, to time.Time) ([]AssigneeInterval, error) {
+	rows, err := s.Query(`
+	SELECT issue_key, assignee, from_time, to_time
+	FROM jira_assignee_intervals
+	WHERE assignee = ANY($1)
+	AND from_time < $3 AND (to_time IS NULL OR to_time > $2)
+	ORDER BY assignee, from_time, issue_key;
+	`, pq.Array(assignees), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var intervals []AssigneeInterval
+	for rows.Next() {
+		var ai AssigneeInterval
+		if err = rows.Scan(&ai.IssueKey, &ai.Assignee, &ai.From, &ai.To); err != nil {
+			return nil, err
+		}
+		intervals = append(intervals, ai)
+	}
+	return intervals, rows.Err()
+}
@@ -0,0 +1,78 @@
+package store
+
+import "time"
+
+// SprintMember is an issue which was in a sprint at some point (see
+// `GetSprintMembers`).
+type SprintMember struct {
+	SprintID   int
+	IssueKey   string
+	Points     *float64
+	ResolvedAt *time.Time
+}
+
+// GetBoardSprints returns the sprints created on the board which
+// were started, sorted by start date.
+func (s *PGStore) GetBoardSprints(boardID int) ([]Sprint, error) {
+	rows, err := s.Query(`
+	SELECT id, board_id, name, state, start_date, end_date, complete_date
+	FROM jira_sprints
+	WHERE board_id = $1 AND start_date IS NOT NULL
+	ORDER BY start_date, id;
+	`, boardID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sprints []Sprint
+	for rows.Next() {
+		var sp Sprint
+		if err = rows.Scan(&sp.ID, &sp.BoardID, &sp.Name, &sp.State, &sp.StartDate, &sp.EndDate, &sp.CompleteDate); err != nil {
+			return nil, err
+		}
+		sprints = append(sprints, sp)
+	}
+	return sprints, rows.Err()
+}
+
+// GetSprintMembers returns the issues which were in the sprints
+// created on the board, once per sprint, sorted by sprint and issue
+// key. An issue was in a sprint if the sprint is one of its current
+// sprints (`issue_sprint_ids`, which keeps the closed ones) or if it
+// was added to it (`sprint_added` events), even if it was removed
+// since. Deleted and generated issues are ignored.
+func (s *PGStore) GetSprintMembers(boardID int) ([]SprintMember, error) {
+	rows, err := s.Query(`
+	WITH members AS (
+		SELECT sp.id AS sprint_id, i.issue_key
+		FROM jira_sprints sp
+		JOIN jira_issues_states i ON sp.id::TEXT = ANY(string_to_array(i.issue_sprint_ids, ','))
+		WHERE sp.board_id = $1
+		UNION
+		SELECT e.sprint_id, e.issue_key
+		FROM jira_issues_events e
+		JOIN jira_sprints sp ON sp.id = e.sprint_id
+		WHERE sp.board_id = $1 AND e.event_kind = 'sprint_added'
+	)
+	SELECT m.sprint_id, m.issue_key, i.issue_estimate_points, i.issue_resolved_at
+	FROM members m
+	JOIN jira_issues_states i ON i.issue_key = m.issue_key
+	WHERE i.issue_deleted_at IS NULL AND NOT i.issue_is_generated
+	ORDER BY m.sprint_id, m.issue_key;
+	`, boardID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []SprintMember
+	for rows.Next() {
+		var m SprintMember
+		if err = rows.Scan(&m.SprintID, &m.IssueKey, &m.Points, &m.ResolvedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ColumnComment is a comment set on a column by `CreateTables`,
+// so analysts exploring the DB understand the columns.
+type ColumnComment struct {
+	Table   string
+	Column  string
+	Comment string
+}
+
+// SetColumnComments sets the comments added to the columns by
+// `CreateTables` (e.g. `mapping.ColumnComments()`).
+func (s *PGStore) SetColumnComments(cs []ColumnComment) {
+	s.columnComments = cs
+}
+
+// commentQueries returns the `COMMENT ON COLUMN` statements for the
+// comments.
+func commentQueries(cs []ColumnComment) []string {
+	queries := make([]string, len(cs))
+	for i, c := range cs {
+		queries[i] = fmt.Sprintf(`COMMENT ON COLUMN "%s"."%s" IS '%s';`, c.Table, c.Column, strings.Replace(c.Comment, "'", "''", -1))
+	}
+	return queries
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQL types of the custom columns
+const (
+	CustomColumnText      = "TEXT"
+	CustomColumnNumeric   = "NUMERIC"
+	CustomColumnDate      = "DATE"
+	CustomColumnTimestamp = "TIMESTAMP"
+)
+
+// CustomColumn is a column of `jira_issues_states` and
+// `jira_issues_events` filled with the value of a Jira custom field
+// (see `IssueState.CustomFields`), as configured in the mapping.
+type CustomColumn struct {
+	Name string
+
+	// Type is the SQL type of the column (e.g. `CustomColumnText`).
+	// The values of the column in `IssueState.CustomFields` are
+	// `*string` for `TEXT`, `*float64` for `NUMERIC` and
+	// `*time.Time` for `DATE` and `TIMESTAMP`.
+	Type string
+
+	// User is true if the column holds the names of users (a "user"
+	// field), exported and anonymized with the data of the person
+	// (see `GetPersonData`).
+	User bool
+}
+
+// customColumnsTables are the tables including the custom columns.
+var customColumnsTables = []string{"jira_issues_states", "jira_issues_events"}
+
+// SetCustomColumns sets the custom columns created by
+// `CreateTables` and filled by `ReplaceIssueStateAndEvents` (e.g.
+// `mapping.CustomColumns(...)`).
+func (s *PGStore) SetCustomColumns(cs []CustomColumn) {
+	s.customColumns = cs
+}
+
+// CustomColumnsPlan returns the statements adding the custom
+// columns missing from the existing tables, e.g. after a custom
+// field was added to the mapping. Returns no statement if all the
+// custom columns exist.
+func (s *PGStore) CustomColumnsPlan() ([]string, error) {
+	var queries []string
+	for _, table := range customColumnsTables {
+		existing := make(map[string]bool)
+		rows, err := s.Query(`SELECT column_name FROM information_schema.columns WHERE table_name = $1;`, table)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name string
+			if err = rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			existing[name] = true
+		}
+		if err = rows.Close(); err != nil {
+			return nil, err
+		}
+		for _, c := range s.customColumns {
+			if !existing[c.Name] {
+				queries = append(queries, fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN IF NOT EXISTS "%s" %s;`, table, c.Name, c.Type))
+			}
+		}
+	}
+	return queries, nil
+}
+
+// customColumnsDefinition returns the definitions of the custom
+// columns to be appended to a `CREATE TABLE` statement.
+func customColumnsDefinition(cs []CustomColumn) string {
+	var b strings.Builder
+	for _, c := range cs {
+		fmt.Fprintf(&b, ",\n\t\t\t\"%s\" %s", c.Name, c.Type)
+	}
+	return b.String()
+}
+
+// customColumnNames returns the names of the custom columns.
+func customColumnNames(cs []CustomColumn) []string {
+	names := make([]string, len(cs))
+	for i, c := range cs {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// customColumnValues returns the values of the custom columns for
+// the issue state.
+func customColumnValues(cs []CustomColumn, is IssueState) []interface{} {
+	values := make([]interface{}, len(cs))
+	for i, c := range cs {
+		values[i] = is.CustomFields[c.Name]
+	}
+	return values
+}
+
+// customColumnsScan returns the destinations to scan the custom
+// columns to, and a function setting the scanned values in the
+// issue state's `CustomFields`.
+func customColumnsScan(cs []CustomColumn) (dest []interface{}, set func(is *IssueState)) {
+	for _, c := range cs {
+		switch c.Type {
+		case CustomColumnNumeric:
+			dest = append(dest, new(*float64))
+		case CustomColumnDate, CustomColumnTimestamp:
+			dest = append(dest, new(*time.Time))
+		default:
+			dest = append(dest, new(*string))
+		}
+	}
+	set = func(is *IssueState) {
+		if len(cs) == 0 {
+			return
+		}
+		is.CustomFields = make(map[string]interface{}, len(cs))
+		for i, c := range cs {
+			switch d := dest[i].(type) {
+			case **float64:
+				is.CustomFields[c.Name] = *d
+			case **time.Time:
+				is.CustomFields[c.Name] = *d
+			case **string:
+				is.CustomFields[c.Name] = *d
+			}
+		}
+	}
+	return dest, set
+}
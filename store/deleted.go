@@ -0,0 +1,98 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MarkDeletedIssues marks the issues of `jira_issues_states` which
+// are not in `foundKeys` (the keys of all the issues found by a full
+// sync) as deleted: their `issue_deleted_at` is set to `at` and an
+// `EventIssueDeleted` event is added, with the issue columns of
+// their state. The issues already marked are ignored. Returns the
+// number of marked issues.
+//
+// The records of the marked issues are kept: the deleted issues can
+// be excluded with `issue_deleted_at IS NULL`. An issue found again
+// by a later sync has its records replaced, without the mark.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) MarkDeletedIssues(foundKeys []string, at time.Time) (count int, err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	found := make(map[string]bool, len(foundKeys))
+	for _, k := range foundKeys {
+		found[k] = true
+	}
+	rows, err := tx.Query(`SELECT issue_key FROM jira_issues_states WHERE issue_deleted_at IS NULL;`)
+	if err != nil {
+		return
+	}
+	var missing, dedupKeys []string
+	for rows.Next() {
+		var k string
+		if err = rows.Scan(&k); err != nil {
+			rows.Close()
+			return
+		}
+		if !found[k] {
+			missing = append(missing, k)
+			dedupKeys = append(dedupKeys, IssueEvent{EventTime: at, EventKind: EventIssueDeleted, IssueKey: k}.DedupKey())
+		}
+	}
+	if err = rows.Close(); err != nil {
+		return
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	if _, err = tx.Exec(`UPDATE jira_issues_states SET issue_deleted_at = $1 WHERE issue_key = ANY($2);`, at, pq.Array(missing)); err != nil {
+		return
+	}
+	columns := deletedEventIssueColumns(s.customColumns)
+	query := fmt.Sprintf(`
+	INSERT INTO jira_issues_events (event_time, event_kind, event_author, dedup_key, %s)
+	SELECT $1, $2, '', d.dedup_key, s.%s
+	FROM jira_issues_states s
+	JOIN unnest($3::TEXT[], $4::TEXT[]) AS d (issue_key, dedup_key) ON d.issue_key = s.issue_key
+	ON CONFLICT (dedup_key) DO NOTHING;
+	`, strings.Join(columns, ", "), strings.Join(columns, ", s."))
+	if _, err = tx.Exec(query, at, EventIssueDeleted, pq.Array(missing), pq.Array(dedupKeys)); err != nil {
+		return
+	}
+	return len(missing), nil
+}
+
+// deletedEventIssueColumns returns the issue columns copied from the
+// state of a deleted issue to its `EventIssueDeleted` event: the
+// columns of `issueEventColumns` which are in `issueStateColumns`
+// too, and the custom columns.
+func deletedEventIssueColumns(cs []CustomColumn) []string {
+	stateColumns := make(map[string]bool, len(issueStateColumns))
+	for _, c := range issueStateColumns {
+		stateColumns[c] = true
+	}
+	var columns []string
+	for _, c := range issueEventColumns {
+		if stateColumns[c] {
+			columns = append(columns, c)
+		}
+	}
+	return append(columns, customColumnNames(cs)...)
+}
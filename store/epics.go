@@ -0,0 +1,42 @@
+package store
+
+// epicViews are the views created along with the tables to query
+// epics.
+//
+// ### jira_epic_rollup
+//
+// Returns one row per epic with its name, color and status, and the
+// roll-up of its issues (number of issues, number of resolved
+// issues, first creation and last resolution).
+//
+// Example:
+//
+//	SELECT epic_name, epic_color, issues_resolved_count, issues_count
+//	FROM jira_epic_rollup
+//	WHERE epic_project = 'Project';
+var epicViews = []string{
+	`CREATE OR REPLACE VIEW jira_epic_rollup AS
+	SELECT
+		e.issue_key AS epic_key,
+		e.issue_project AS epic_project,
+		e.issue_summary AS epic_summary,
+		e.issue_epic_name AS epic_name,
+		e.issue_epic_color AS epic_color,
+		e.issue_status AS epic_status,
+		e.issue_resolved_at AS epic_resolved_at,
+		COUNT(c.issue_key) AS issues_count,
+		COUNT(c.issue_resolved_at) AS issues_resolved_count,
+		MIN(c.issue_created_at) AS first_issue_created_at,
+		MAX(c.issue_resolved_at) AS last_issue_resolved_at
+	FROM jira_issues_states e
+	LEFT JOIN jira_issues_states c ON c.issue_epic = e.issue_key
+	WHERE e.issue_type = 'Epic'
+	GROUP BY
+		e.issue_key,
+		e.issue_project,
+		e.issue_summary,
+		e.issue_epic_name,
+		e.issue_epic_color,
+		e.issue_status,
+		e.issue_resolved_at;`,
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// DailyEventCount is the number of events of a kind on a day, as
+// returned by `GetDailyEventCounts`.
+type DailyEventCount struct {
+	Day   time.Time
+	Kind  EventKind
+	Count int
+}
+
+// EventCountsScope restricts the events counted by
+// `GetDailyEventCounts` to those of the issues of a project or of
+// an epic. Exactly one of them must be set.
+type EventCountsScope struct {
+	Project string
+
+	// Epic is the key of the epic.
+	Epic string
+}
+
+// eventCountsIndexes are the indexes created with `CreateTables` so
+// the events of a project or epic are counted with index-only scans
+// (see `GetDailyEventCounts`).
+var eventCountsIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS "jira_issues_events_project_time_kind_idx" ON "jira_issues_events" ("issue_project", "event_time", "event_kind");`,
+	`CREATE INDEX IF NOT EXISTS "jira_issues_events_epic_time_kind_idx" ON "jira_issues_events" ("issue_epic", "event_time", "event_kind");`,
+}
+
+// GetDailyEventCounts returns the number of events of each kind per
+// day, for the days in [from, to), for the issues of the scope. Days
+// and kinds without events are omitted. Sorted by day and kind.
+func (s *PGStore) GetDailyEventCounts(scope EventCountsScope, from, to time.Time) ([]DailyEventCount, error) {
+	column, value := "issue_project", scope.Project
+	switch {
+	case scope.Project != "" && scope.Epic != "":
+		return nil, errors.New("event counts scope can't have both a project and an epic")
+	case scope.Epic != "":
+		column, value = "issue_epic", scope.Epic
+	case scope.Project == "":
+		return nil, errors.New("event counts scope must have a project or an epic")
+	}
+	rows, err := s.Query(`
+	SELECT event_time::DATE, event_kind, COUNT(*)
+	FROM jira_issues_events
+	WHERE `+column+` = $1
+	AND event_time >= $2 AND event_time < $3
+	GROUP BY 1, 2
+	ORDER BY 1, 2;
+	`, value, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []DailyEventCount
+	for rows.Next() {
+		var c DailyEventCount
+		if err = rows.Scan(&c.Day, &c.Kind, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+)
+
+// EventKind is the kind of an `IssueEvent`, stored in the
+// `event_kind` column of `jira_issues_events`.
+//
+// Only the kinds listed in `EventKinds()` are valid, so consumers
+// of the events can rely on a stable set of kinds. To add a new
+// kind, declare its constant and add it to `eventKinds` with its
+// description. Extensions generating their own events (see
+// `mapping.RegisterChangelogPlugin`) register their kinds with
+// `RegisterEventKind`.
+type EventKind string
+
+// Event kinds generated by the mapping
+const (
+	// EventCreated is the creation of the issue.
+	EventCreated EventKind = "created"
+
+	// EventStatusChanged is a change of the issue's status.
+	EventStatusChanged EventKind = "status_changed"
+
+	// EventAssigneeChanged is a change of the issue's assignee.
+	EventAssigneeChanged EventKind = "assignee_changed"
+
+	// EventCommentAdded is a comment added on the issue, and
+	// EventCommentUpdated the last edit of a comment.
+	EventCommentAdded   EventKind = "comment_added"
+	EventCommentUpdated EventKind = "comment_updated"
+
+	// EventWorklogAdded is work logged on the issue.
+	EventWorklogAdded EventKind = "worklog_added"
+
+	// EventSprintAdded and EventSprintRemoved are the addition of
+	// the issue to a sprint and its removal from a sprint.
+	EventSprintAdded   EventKind = "sprint_added"
+	EventSprintRemoved EventKind = "sprint_removed"
+
+	// EventFieldChanged is a change of another field of the issue
+	// (e.g. its priority, labels or fix versions).
+	EventFieldChanged EventKind = "field_changed"
+)
+
+// Field names of the `field_changed` events generated whether the
+// fields are tracked or not, since the blocked time of the issues is
+// computed from them (see `metrics.Compute`).
+const (
+	// FieldFlagged is the "Flagged" field of Jira: the issue was
+	// flagged (e.g. as an impediment) if `field_change_to` is set,
+	// and unflagged otherwise.
+	FieldFlagged = "Flagged"
+
+	// FieldBlockedBy is the addition (`field_change_to`) or removal
+	// (`field_change_from`) of an "is blocked by" link to the issue
+	// of the key.
+	FieldBlockedBy = "Blocked by"
+)
+
+// Event kinds generated by the syncs
+const (
+	// EventIssueDeleted is the detection by a full sync that the
+	// issue was deleted in Jira, or moved out of the synced issues
+	// (see `PGStore.MarkDeletedIssues`).
+	EventIssueDeleted EventKind = "issue_deleted"
+
+	// EventCommentDeleted is the detection by a sync of the issue
+	// that one of its comments was deleted (see `Comment`).
+	EventCommentDeleted EventKind = "comment_deleted"
+)
+
+// EventKindInfo documents an event kind.
+type EventKindInfo struct {
+	Kind        EventKind
+	Description string
+}
+
+// eventKindsMutex protects `eventKinds` from the registrations.
+var eventKindsMutex sync.RWMutex
+
+var eventKinds = []EventKindInfo{
+	{EventCreated, "The issue was created, by the event's author (the reporter)."},
+	{EventStatusChanged, "The issue's status changed from `status_change_from` to `status_change_to`, with the reason in `status_change_reason` if configured."},
+	{EventAssigneeChanged, "The issue's assignee changed from `assignee_change_from` to `assignee_change_to`."},
+	{EventCommentAdded, "A comment was added on the issue, its body is in `comment_body` and its ID in `comment_id` (see `jira_issue_comments`)."},
+	{EventCommentUpdated, "The comment `comment_id` was edited by the event's author. Only the last edit of a comment is known."},
+	{EventWorklogAdded, "Work was logged on the issue by the event's author: `worklog_time_spent_seconds` spent from `worklog_started_at`."},
+	{EventSprintAdded, "The issue was added to the sprint `sprint_id` (`sprint_name`)."},
+	{EventSprintRemoved, "The issue was removed from the sprint `sprint_id` (`sprint_name`), e.g. moved to the next sprint when the sprint was completed."},
+	{EventFieldChanged, "The field `field_name` (e.g. `priority`, `labels`, `Fix Version`) changed from `field_change_from` to `field_change_to`. The `Flagged` changes and the `Blocked by` links added and removed (with the keys of the blockers as values) are always generated."},
+	{EventIssueDeleted, "The issue was not found anymore by a full sync (deleted in Jira, or moved out of the synced issues), at the event's time. It has no author."},
+	{EventCommentDeleted, "The comment `comment_id` was not found anymore by a sync of the issue, at the event's time. The event's author is the comment's author."},
+}
+
+// RegisterEventKind adds a kind of events generated by an extension
+// (e.g. a changelog plugin) to the valid kinds, with its
+// description, e.g. from the `init` function of its package.
+// Returns an error if the kind is empty or already valid.
+func RegisterEventKind(k EventKind, description string) error {
+	eventKindsMutex.Lock()
+	defer eventKindsMutex.Unlock()
+	if k == "" {
+		return fmt.Errorf("invalid empty event kind")
+	}
+	for _, info := range eventKinds {
+		if info.Kind == k {
+			return fmt.Errorf("event kind `%s` is already registered", k)
+		}
+	}
+	eventKinds = append(eventKinds, EventKindInfo{k, description})
+	return nil
+}
+
+// EventKinds returns the valid event kinds and their descriptions.
+func EventKinds() []EventKindInfo {
+	eventKindsMutex.RLock()
+	defer eventKindsMutex.RUnlock()
+	kinds := make([]EventKindInfo, len(eventKinds))
+	copy(kinds, eventKinds)
+	return kinds
+}
+
+// IsValid returns true if the kind is one of `EventKinds()`.
+func (k EventKind) IsValid() bool {
+	eventKindsMutex.RLock()
+	defer eventKindsMutex.RUnlock()
+	for _, info := range eventKinds {
+		if info.Kind == k {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,293 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// EachIssueState calls `fn` with each issue state in the store,
+// sorted by issue key, including the custom columns (see
+// `SetCustomColumns`).
+//
+// Stops and returns the error if `fn` returns one.
+func (s *PGStore) EachIssueState(fn func(is IssueState) error) error {
+	q := `
+	SELECT
+		issue_created_at,
+		issue_updated_at,
+		issue_key,
+		issue_project,
+		issue_status,
+		issue_status_category,
+		issue_resolved_at,
+		issue_priority,
+		issue_summary,
+		issue_description,
+		issue_type,
+		issue_labels,
+		issue_assignee,
+		issue_epic,
+		issue_sprints,
+		issue_components,
+		issue_fix_versions%s
+	FROM jira_issues_states
+	ORDER BY issue_key
+	`
+	var columns string
+	for _, c := range s.customColumns {
+		columns += ",\n\t\t" + c.Name
+	}
+	rows, err := s.Query(fmt.Sprintf(q, columns))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var is IssueState
+		custom, setCustom := customColumnsScan(s.customColumns)
+		dest := []interface{}{
+			&is.CreatedAt,
+			&is.UpdatedAt,
+			&is.Key,
+			&is.Project,
+			&is.Status,
+			&is.StatusCategory,
+			&is.ResolvedAt,
+			&is.Priority,
+			&is.Summary,
+			&is.Description,
+			&is.Type,
+			&is.Labels,
+			&is.Assignee,
+			&is.Epic,
+			&is.Sprints,
+			&is.Components,
+			&is.FixVersions,
+		}
+		if err = rows.Scan(append(dest, custom...)...); err != nil {
+			return err
+		}
+		setCustom(&is)
+		if err = fn(is); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// EachIssueEvent calls `fn` with each issue event in the store,
+// sorted by issue key and time.
+//
+// Stops and returns the error if `fn` returns one.
+func (s *PGStore) EachIssueEvent(fn func(ie IssueEvent) error) error {
+	q := `
+	SELECT
+		event_time,
+		event_kind,
+		event_author,
+		issue_key,
+		comment_body,
+		status_change_from,
+		status_change_to,
+		status_change_reason,
+		assignee_change_from,
+		assignee_change_to,
+		comment_length
+	FROM jira_issues_events
+	ORDER BY issue_key, event_time, id
+	`
+	rows, err := s.Query(q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ie IssueEvent
+		err = rows.Scan(
+			&ie.EventTime,
+			&ie.EventKind,
+			&ie.EventAuthor,
+			&ie.IssueKey,
+			&ie.CommentBody,
+			&ie.StatusChangeFrom,
+			&ie.StatusChangeTo,
+			&ie.StatusChangeReason,
+			&ie.AssigneeChangeFrom,
+			&ie.AssigneeChangeTo,
+			&ie.CommentLength,
+		)
+		if err != nil {
+			return err
+		}
+		if err = fn(ie); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// EachIssueMetrics calls `fn` with the metrics of each issue in the
+// store (see `ReplaceIssueMetrics`), sorted by issue key, including
+// their status times.
+//
+// Stops and returns the error if `fn` returns one.
+func (s *PGStore) EachIssueMetrics(fn func(im IssueMetrics) error) error {
+	statusTimes, err := s.getStatusTimes()
+	if err != nil {
+		return err
+	}
+
+	q := `
+	SELECT
+		issue_key,
+		issue_project,
+		issue_type,
+		issue_created_at,
+		started_at,
+		done_at,
+		lead_time_seconds,
+		cycle_time_seconds,
+		first_response_at,
+		first_response_time_seconds,
+		reopenings_count,
+		first_assigned_at,
+		time_to_first_assignment_seconds,
+		lead_time_business_seconds,
+		cycle_time_business_seconds,
+		first_response_time_business_seconds,
+		time_to_first_assignment_business_seconds,
+		blocked_time_seconds,
+		blocked_time_business_seconds,
+		first_time_right
+	FROM jira_issue_metrics
+	ORDER BY issue_key, id
+	`
+	rows, err := s.Query(q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var im IssueMetrics
+		var leadTime, cycleTime, firstResponseTime, timeToFirstAssignment sql.NullInt64
+		var leadTimeBusiness, cycleTimeBusiness, firstResponseTimeBusiness, timeToFirstAssignmentBusiness sql.NullInt64
+		var blockedTime int64
+		var blockedTimeBusiness sql.NullInt64
+		err = rows.Scan(
+			&im.IssueKey,
+			&im.Project,
+			&im.Type,
+			&im.CreatedAt,
+			&im.StartedAt,
+			&im.DoneAt,
+			&leadTime,
+			&cycleTime,
+			&im.FirstResponseAt,
+			&firstResponseTime,
+			&im.Reopenings,
+			&im.FirstAssignedAt,
+			&timeToFirstAssignment,
+			&leadTimeBusiness,
+			&cycleTimeBusiness,
+			&firstResponseTimeBusiness,
+			&timeToFirstAssignmentBusiness,
+			&blockedTime,
+			&blockedTimeBusiness,
+			&im.FirstTimeRight,
+		)
+		if err != nil {
+			return err
+		}
+		im.LeadTime = duration(leadTime)
+		im.CycleTime = duration(cycleTime)
+		im.FirstResponseTime = duration(firstResponseTime)
+		im.TimeToFirstAssignment = duration(timeToFirstAssignment)
+		im.LeadTimeBusiness = duration(leadTimeBusiness)
+		im.CycleTimeBusiness = duration(cycleTimeBusiness)
+		im.FirstResponseTimeBusiness = duration(firstResponseTimeBusiness)
+		im.TimeToFirstAssignmentBusiness = duration(timeToFirstAssignmentBusiness)
+		im.BlockedTime = time.Duration(blockedTime) * time.Second
+		im.BlockedTimeBusiness = duration(blockedTimeBusiness)
+		im.StatusTimes = statusTimes[im.IssueKey]
+		if err = fn(im); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// getStatusTimes returns the status times of each issue, in the
+// order they were inserted.
+func (s *PGStore) getStatusTimes() (map[string][]StatusTime, error) {
+	rows, err := s.Query(`SELECT issue_key, status, duration_seconds, business_duration_seconds FROM jira_issue_status_times ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statusTimes := make(map[string][]StatusTime)
+	for rows.Next() {
+		var k string
+		var st StatusTime
+		var d int64
+		var business sql.NullInt64
+		if err = rows.Scan(&k, &st.Status, &d, &business); err != nil {
+			return nil, err
+		}
+		st.Duration = time.Duration(d) * time.Second
+		st.BusinessDuration = duration(business)
+		statusTimes[k] = append(statusTimes[k], st)
+	}
+	return statusTimes, rows.Err()
+}
+
+// EachWeeklyStats calls `fn` with each weekly stats in the store
+// (see `ReplaceWeeklyStats`), sorted by week, project and type.
+//
+// Stops and returns the error if `fn` returns one.
+func (s *PGStore) EachWeeklyStats(fn func(ws WeeklyStats) error) error {
+	q := `
+	SELECT
+		week,
+		issue_project,
+		issue_type,
+		throughput,
+		cycle_time_p85_seconds,
+		cycle_time_p95_seconds
+	FROM jira_weekly_stats
+	ORDER BY week, issue_project, issue_type
+	`
+	rows, err := s.Query(q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ws WeeklyStats
+		var p85, p95 sql.NullInt64
+		if err = rows.Scan(&ws.Week, &ws.Project, &ws.Type, &ws.Throughput, &p85, &p95); err != nil {
+			return err
+		}
+		ws.CycleTimeP85 = duration(p85)
+		ws.CycleTimeP95 = duration(p95)
+		if err = fn(ws); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// duration returns the duration of a number of seconds, or nil if
+// the number is NULL. It's the reverse of `seconds`.
+func duration(n sql.NullInt64) *time.Duration {
+	if !n.Valid {
+		return nil
+	}
+	d := time.Duration(n.Int64) * time.Second
+	return &d
+}
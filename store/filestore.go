@@ -0,0 +1,224 @@
+package store
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Formats of the files written by a `FileStore`
+const (
+	FileFormatJSONL = "jsonl"
+	FileFormatCSV   = "csv"
+)
+
+// FileStore implements the application's `Store` by writing the
+// issue states, events and links to files instead of a DB, e.g. to
+// debug the mapping, load the records into other tools, or run the
+// extractor without DB credentials.
+//
+// The records of each table are written to `<dir>/<table>.<format>`,
+// with the same columns as the table, as newline-delimited JSON
+// objects (`FileFormatJSONL`) or CSV with a header
+// (`FileFormatCSV`). Times are formatted as RFC 3339 and NULL values
+// are `null` in JSON and empty in CSV.
+//
+// Records are appended as the issues are written: an issue written
+// twice appears twice. The files must be closed with `Close`.
+type FileStore struct {
+	format        string
+	customColumns []CustomColumn
+
+	mutex  sync.Mutex
+	states *fileTable
+	events *fileTable
+	links  *fileTable
+}
+
+// fileTable is the file the records of a table are written to.
+type fileTable struct {
+	file    *os.File
+	buf     *bufio.Writer
+	csv     *csv.Writer
+	columns []string
+}
+
+// NewFileStore returns a `FileStore` writing files of the format
+// (`FileFormatJSONL` or `FileFormatCSV`) in `dir`, which is created
+// if it doesn't exist. Existing files are overwritten.
+//
+// The custom columns (e.g. `mapping.CustomColumns(...)`) are
+// appended to the columns of the issue tables.
+func NewFileStore(dir, format string, cs []CustomColumn) (*FileStore, error) {
+	if format != FileFormatJSONL && format != FileFormatCSV {
+		return nil, fmt.Errorf("unknown file format `%s` (expected `%s` or `%s`)", format, FileFormatJSONL, FileFormatCSV)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &FileStore{format: format, customColumns: cs}
+	custom := customColumnNames(cs)
+	var err error
+	open := func(table string, columns []string) *fileTable {
+		if err != nil {
+			return nil
+		}
+		var t *fileTable
+		t, err = s.openTable(filepath.Join(dir, table+"."+format), columns)
+		return t
+	}
+	s.states = open("jira_issues_states", append(issueStateColumns, custom...))
+	s.events = open("jira_issues_events", append(issueEventColumns, custom...))
+	s.links = open("jira_issue_links", []string{"source_key", "target_key", "link_type", "direction"})
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) openTable(path string, columns []string) (*fileTable, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &fileTable{file: f, buf: bufio.NewWriter(f), columns: columns}
+	if s.format == FileFormatCSV {
+		t.csv = csv.NewWriter(t.buf)
+		if err = t.csv.Write(columns); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// write writes a record of the table, `values` being the values of
+// its columns.
+func (t *fileTable) write(values []interface{}) error {
+	if t.csv != nil {
+		record := make([]string, len(values))
+		for i, v := range values {
+			switch v := fileValue(v).(type) {
+			case nil:
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		return t.csv.Write(record)
+	}
+	// The object is built by hand to keep the order of the columns
+	t.buf.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			t.buf.WriteByte(',')
+		}
+		value, err := json.Marshal(fileValue(v))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(t.buf, "%q:%s", t.columns[i], value)
+	}
+	t.buf.WriteString("}\n")
+	return nil
+}
+
+func (t *fileTable) close() error {
+	if t == nil {
+		return nil
+	}
+	if t.csv != nil {
+		t.csv.Flush()
+	}
+	err := t.buf.Flush()
+	if cerr := t.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// fileValue returns the value to write for the value of a column:
+// nil for nil pointers, the pointed value for other pointers, and
+// times formatted as RFC 3339.
+func fileValue(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil
+	}
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		v = rv.Elem().Interface()
+	}
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case EventKind:
+		return string(v)
+	}
+	return v
+}
+
+// ReplaceIssueStateAndEvents writes the state, links and events of
+// the issue. Duplicate events (see `IssueEvent.DedupKey`) are
+// written once.
+func (s *FileStore) ReplaceIssueStateAndEvents(k string, is IssueState, ies []IssueEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.states.write(append(issueStateValues(is), customColumnValues(s.customColumns, is)...)); err != nil {
+		return err
+	}
+	for _, l := range is.Links {
+		if err := s.links.write([]interface{}{l.SourceKey, l.TargetKey, l.LinkType, l.Direction}); err != nil {
+			return err
+		}
+	}
+	for _, ie := range uniqueEvents(ies) {
+		if err := s.events.write(append(issueEventValues(ie, is), customColumnValues(s.customColumns, is)...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRestartFromUpdatedAt returns an error: the files are written
+// from scratch, so only full syncs can be performed.
+func (s *FileStore) GetRestartFromUpdatedAt(n int) (*time.Time, error) {
+	return nil, errors.New("incremental syncs are not supported when writing to files")
+}
+
+// CreateTables does nothing: the files are created by
+// `NewFileStore`.
+func (s *FileStore) CreateTables() error {
+	return nil
+}
+
+// DropTables does nothing: the files are overwritten by
+// `NewFileStore`.
+func (s *FileStore) DropTables() error {
+	return nil
+}
+
+// Close flushes and closes the files.
+func (s *FileStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var err error
+	for _, t := range []*fileTable{s.states, s.events, s.links} {
+		if cerr := t.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
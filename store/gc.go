@@ -0,0 +1,118 @@
+package store
+
+import (
+	"database/sql"
+)
+
+// OrphanedEvents reports the events of `jira_issues_events` whose
+// issue key is not in `jira_issues_states` anymore (e.g. after an
+// issue was purged, or moved and synced again under its new key),
+// processed by `CollectOrphanedEvents`.
+type OrphanedEvents struct {
+	// Relinked is the number of events moved to the current key of
+	// their issue.
+	Relinked int64
+
+	// Duplicates is the number of events deleted because the same
+	// event (same kind, time and author) is already stored with the
+	// current key of their issue.
+	Duplicates int64
+
+	// Deleted is the number of events deleted because their issue
+	// is not in the store anymore.
+	Deleted int64
+}
+
+// orphanedEventCondition selects the orphaned events of
+// `jira_issues_events e`.
+const orphanedEventCondition = `NOT EXISTS (
+		SELECT 1 FROM jira_issues_states s WHERE s.issue_key = e.issue_key
+	)`
+
+// CollectOrphanedEvents deletes the orphaned events (see
+// `OrphanedEvents`), with their comments stored in the vault.
+//
+// If `relink` is true, the orphaned events whose key is a previous
+// key of an issue in the store (see `jira_issue_key_aliases`) are
+// moved to its current key instead, unless they are duplicates of
+// its events. The events moved are replaced by the next sync of the
+// issue.
+//
+// The changes are performed in a transaction, which is rolled back
+// if `dryRun` is true: the counts are then those of the events which
+// would be processed.
+func (s *PGStore) CollectOrphanedEvents(relink, dryRun bool) (r OrphanedEvents, err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch {
+		case err == nil && !dryRun:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if relink {
+		if r.Duplicates, err = execCount(tx, `
+		DELETE FROM jira_issues_events e
+		USING jira_issue_key_aliases a
+		WHERE e.issue_key = a.alias_key
+		AND `+orphanedEventCondition+`
+		AND EXISTS (
+			SELECT 1 FROM jira_issues_events x
+			WHERE x.issue_key = a.issue_key
+			AND x.event_kind = e.event_kind
+			AND x.event_time = e.event_time
+			AND x.event_author IS NOT DISTINCT FROM e.event_author
+		);`); err != nil {
+			return
+		}
+		if r.Relinked, err = execCount(tx, `
+		UPDATE jira_issues_events e
+		SET issue_key = a.issue_key
+		FROM jira_issue_key_aliases a
+		WHERE e.issue_key = a.alias_key
+		AND `+orphanedEventCondition+`
+		AND EXISTS (
+			SELECT 1 FROM jira_issues_states s WHERE s.issue_key = a.issue_key
+		);`); err != nil {
+			return
+		}
+		if s.commentVault != nil {
+			if _, err = tx.Exec(`
+			UPDATE jira_comment_vault v
+			SET issue_key = e.issue_key
+			FROM jira_issues_events e
+			WHERE e.dedup_key = v.dedup_key
+			AND e.issue_key <> v.issue_key;`); err != nil {
+				return
+			}
+		}
+	}
+
+	if r.Deleted, err = execCount(tx, `DELETE FROM jira_issues_events e WHERE `+orphanedEventCondition+`;`); err != nil {
+		return
+	}
+	if s.commentVault != nil {
+		_, err = tx.Exec(`
+		DELETE FROM jira_comment_vault v
+		WHERE NOT EXISTS (
+			SELECT 1 FROM jira_issues_events e WHERE e.dedup_key = v.dedup_key
+		);`)
+	}
+	return
+}
+
+// execCount executes the statement in the transaction and returns
+// the number of rows it affected.
+func execCount(tx *sql.Tx, query string) (int64, error) {
+	res, err := tx.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,30 @@
+package store
+
+import "github.com/rchampourlier/kaizenizer-source-jira/logging"
+
+// EventHandler is called with each event written to the store, once
+// committed (see `SetEventHandler`).
+type EventHandler func(ie IssueEvent) error
+
+// SetEventHandler sets the function called with the events written
+// by `ReplaceIssueStateAndEvents` and the writers returned by
+// `NewWriter`, once their transaction is committed, e.g. to maintain
+// the projections (see package `projection`).
+//
+// Errors returned by the handler are logged, the events being
+// written anyway.
+func (s *PGStore) SetEventHandler(h EventHandler) {
+	s.eventHandler = h
+}
+
+// handleEvents calls the event handler, if any, with the events.
+func (s *PGStore) handleEvents(ies []IssueEvent) {
+	if s.eventHandler == nil {
+		return
+	}
+	for _, ie := range ies {
+		if err := s.eventHandler(ie); err != nil {
+			logging.Errorf("Error handling event %s: %s", ie, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package store
+
+// hierarchyViews are the views created along with the tables to
+// query the levels of the hierarchy of the issues, on top of
+// `jira_issue_ancestors`.
+//
+// ### jira_issue_hierarchy
+//
+// Returns one row per issue with its initiative
+// (`issue_initiative_key`), the first ancestor reached through a
+// "Parent link" link of Advanced Roadmaps (see `LinkTypeParentLink`),
+// e.g. the initiative of an epic and of the issues of the epic, and
+// its depth in the hierarchy (`issue_hierarchy_depth`, 0 for the
+// issues without parent, 2 for a story of an epic of an initiative).
+// E.g. to roll the resolved issues up to their initiatives:
+//
+//	SELECT h.issue_initiative_key, COUNT(s.issue_resolved_at), COUNT(*)
+//	FROM jira_issue_hierarchy h
+//	JOIN jira_issues_states s ON s.issue_key = h.issue_key
+//	WHERE h.issue_initiative_key IS NOT NULL
+//	GROUP BY h.issue_initiative_key;
+var hierarchyViews = []string{
+	`CREATE OR REPLACE VIEW jira_issue_hierarchy AS
+	SELECT
+		s.issue_key,
+		(ARRAY_AGG(a.ancestor_key ORDER BY a.depth) FILTER (WHERE a.link_type = 'Parent link'))[1] AS issue_initiative_key,
+		COALESCE(MAX(a.depth), 0) AS issue_hierarchy_depth
+	FROM jira_issues_states s
+	LEFT JOIN jira_issue_ancestors a ON a.issue_key = s.issue_key
+	GROUP BY s.issue_key;`,
+}
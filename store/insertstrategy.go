@@ -0,0 +1,178 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// Strategies inserting the rows of the batches written by `Writer`
+// and of the normalized multi-valued fields (see
+// `SetInsertStrategy`).
+const (
+	// InsertStatements inserts each row with its own `INSERT`,
+	// prepared once per table and batch (unless the store is
+	// compatible with poolers, see `SetPoolerCompatible`).
+	InsertStatements = "statements"
+
+	// InsertValues inserts the rows with multi-row `INSERT ...
+	// VALUES` statements, of up to `maxQueryParams` parameters.
+	InsertValues = "values"
+
+	// InsertCopy inserts the rows with the `COPY` protocol, the
+	// fastest strategy, but not available with some managed
+	// Postgres services or with pgbouncer in transaction mode. The
+	// store falls back to `InsertValues` if the DB rejects it.
+	InsertCopy = "copy"
+)
+
+// InsertStrategies are the strategies of `SetInsertStrategy`, from
+// the slowest to the fastest one.
+var InsertStrategies = []string{InsertStatements, InsertValues, InsertCopy}
+
+// SetInsertStrategy sets the strategy inserting the rows
+// (`InsertCopy` by default, `InsertValues` if the store is
+// compatible with poolers, see `SetPoolerCompatible`). Returns an error if the strategy is not
+// one of `InsertStrategies`.
+func (s *PGStore) SetInsertStrategy(strategy string) error {
+	for _, st := range InsertStrategies {
+		if st == strategy {
+			s.insertMutex.Lock()
+			defer s.insertMutex.Unlock()
+			s.insertStrategy = strategy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown insert strategy `%s` (expected one of %s)", strategy, strings.Join(InsertStrategies, ", "))
+}
+
+// InsertStrategy returns the strategy inserting the rows, which may
+// have changed since `SetInsertStrategy` if the store fell back from
+// `InsertCopy`.
+func (s *PGStore) InsertStrategy() string {
+	s.insertMutex.Lock()
+	defer s.insertMutex.Unlock()
+	return s.currentInsertStrategy()
+}
+
+// currentInsertStrategy returns the strategy inserting the rows.
+// Must be called with `insertMutex` held.
+func (s *PGStore) currentInsertStrategy() string {
+	switch {
+	case s.insertStrategy != "":
+		return s.insertStrategy
+	case s.poolerCompatible:
+		return InsertValues
+	default:
+		return InsertCopy
+	}
+}
+
+// fallBackFromCopy switches the store to `InsertValues` if the error
+// was returned because the DB doesn't support `COPY` while it's the
+// strategy, and returns true if so: the failed write should be
+// performed again.
+func (s *PGStore) fallBackFromCopy(err error) bool {
+	if !isCopyUnsupported(err) {
+		return false
+	}
+	s.insertMutex.Lock()
+	defer s.insertMutex.Unlock()
+	if s.currentInsertStrategy() != InsertCopy {
+		return false
+	}
+	s.insertStrategy = InsertValues
+	logging.Warnf("COPY is not supported by the DB (%s), falling back to the `%s` insert strategy", err, InsertValues)
+	return true
+}
+
+// isCopyUnsupported returns true if the error was returned for a
+// `COPY` rejected by the DB or a proxy, e.g. for a lack of
+// privileges or pgbouncer in transaction mode.
+func isCopyUnsupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// 0A000 is "feature_not_supported", 42501
+		// "insufficient_privilege"
+		return pqErr.Code == "0A000" || pqErr.Code == "42501"
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "copy")
+}
+
+// insertRows inserts the rows in the table within the transaction,
+// with the insert strategy of the store.
+func (s *PGStore) insertRows(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	s.insertMutex.Lock()
+	strategy, prepare := s.currentInsertStrategy(), !s.poolerCompatible
+	s.insertMutex.Unlock()
+	switch {
+	case strategy == InsertStatements && prepare:
+		return prepareRows(tx, table, columns, rows)
+	case strategy == InsertStatements:
+		return execRows(tx, table, columns, rows)
+	case strategy == InsertValues:
+		return valuesRows(tx, table, columns, rows)
+	default:
+		return copyRows(tx, table, columns, rows)
+	}
+}
+
+// prepareRows inserts the rows in the table with an `INSERT` per
+// row, prepared once, within the transaction.
+func prepareRows(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	stmt, err := tx.Prepare(insertQuery(table, columns))
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if _, err = stmt.Exec(r...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	return stmt.Close()
+}
+
+// execRows inserts the rows in the table with an `INSERT` per row,
+// within the transaction, without preparing it (see
+// `SetPoolerCompatible`).
+func execRows(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	q := insertQuery(table, columns)
+	for _, r := range rows {
+		if _, err := tx.Exec(q, r...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// valuesRows inserts the rows in the table with multi-row `INSERT`
+// statements, within the transaction.
+func valuesRows(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	n := maxQueryParams / len(columns)
+	for len(rows) > 0 {
+		if n > len(rows) {
+			n = len(rows)
+		}
+		var values []interface{}
+		for _, r := range rows[:n] {
+			values = append(values, r...)
+		}
+		if _, err := tx.Exec(insertRowsQuery(table, columns, n), values...); err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Comment is a comment of an issue to be stored in the DB, in its
+// latest version.
+type Comment struct {
+	// ID is the ID of the comment in Jira.
+	ID string
+
+	IssueKey        string
+	Author          string
+	AuthorAccountID *string
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Body            string
+
+	// BodyHTML is the body rendered in HTML by Jira, nil if the
+	// rendered fields were not fetched (see
+	// `config.Mapping.RenderHTML`).
+	BodyHTML *string
+
+	// VisibilityType and VisibilityValue are the restriction of the
+	// comment's visibility (e.g. "role" and "Developers"), nil if
+	// the comment is visible by all users.
+	VisibilityType  *string
+	VisibilityValue *string
+}
+
+// issueCommentsTables are the tables created with `CreateTables` to
+// store the comments of the issues, keyed by their Jira ID.
+//
+// The comments are updated each time their issue is synced: unlike
+// the other records of the issue, the comments which are not found
+// anymore are kept, with the time of the sync which found them
+// deleted in `deleted_at`. E.g. to list the discussion of an issue,
+// deleted comments included:
+//
+//	SELECT created_at, author, body, deleted_at
+//	FROM jira_issue_comments
+//	WHERE issue_key = 'PJ-12'
+//	ORDER BY created_at;
+var issueCommentsTables = []string{
+	`CREATE TABLE IF NOT EXISTS "jira_issue_comments" (
+		"comment_id" TEXT PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"issue_key" TEXT NOT NULL,
+		"author" TEXT NOT NULL,
+		"author_account_id" TEXT,
+		"created_at" TIMESTAMP NOT NULL,
+		"updated_at" TIMESTAMP NOT NULL,
+		"body" TEXT,
+		"body_html" TEXT,
+		"visibility_type" TEXT,
+		"visibility_value" TEXT,
+		"deleted_at" TIMESTAMP
+	);`,
+	`CREATE INDEX IF NOT EXISTS "jira_issue_comments_issue_key_idx" ON "jira_issue_comments" ("issue_key");`,
+}
+
+// issueCommentColumns are the columns of `jira_issue_comments`
+// filled with `issueCommentValues`.
+var issueCommentColumns = []string{
+	"comment_id",
+	"issue_key",
+	"author",
+	"author_account_id",
+	"created_at",
+	"updated_at",
+	"body",
+	"body_html",
+	"visibility_type",
+	"visibility_value",
+	"deleted_at",
+}
+
+// issueCommentValues returns the values of `issueCommentColumns` for
+// the comment. The body, raw and rendered, is nil if the store has a
+// comment vault (see `SetCommentVault`), since it's only stored
+// encrypted.
+func (s *PGStore) issueCommentValues(c Comment) []interface{} {
+	var body, bodyHTML *string
+	if s.commentVault == nil {
+		body, bodyHTML = &c.Body, c.BodyHTML
+	}
+	return []interface{}{
+		c.ID,
+		c.IssueKey,
+		c.Author,
+		c.AuthorAccountID,
+		c.CreatedAt,
+		c.UpdatedAt,
+		body,
+		bodyHTML,
+		c.VisibilityType,
+		c.VisibilityValue,
+		nil,
+	}
+}
+
+// replaceIssueComments updates the comments of the issue in
+// `jira_issue_comments` within the specified transaction, and marks
+// the stored comments which are not in `is.Comments` anymore as
+// deleted at `at`. Nothing is done if the comments of the issue were
+// not fetched (`is.Comments` is nil).
+//
+// Returns a `comment_deleted` event for each deleted comment of the
+// issue, including those deleted before, since the events of the
+// issue are replaced.
+func (s *PGStore) replaceIssueComments(tx *sql.Tx, is IssueState, at time.Time) ([]IssueEvent, error) {
+	if is.Comments == nil {
+		return nil, nil
+	}
+	query := upsertQuery("jira_issue_comments", "comment_id", issueCommentColumns)
+	ids := make([]string, 0, len(is.Comments))
+	for _, c := range is.Comments {
+		if _, err := tx.Exec(query, s.issueCommentValues(c)...); err != nil {
+			return nil, err
+		}
+		ids = append(ids, c.ID)
+	}
+	_, err := tx.Exec(`
+	UPDATE jira_issue_comments
+	SET deleted_at = $2
+	WHERE issue_key = $1 AND deleted_at IS NULL AND NOT comment_id = ANY($3);
+	`, is.Key, at, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	return deletedCommentEvents(tx, is.Key)
+}
+
+// deletedCommentEvents returns a `comment_deleted` event for each
+// comment of the issue marked as deleted, authored by the author of
+// the comment.
+func deletedCommentEvents(tx *sql.Tx, issueKey string) ([]IssueEvent, error) {
+	rows, err := tx.Query(`
+	SELECT comment_id, author, author_account_id, deleted_at
+	FROM jira_issue_comments
+	WHERE issue_key = $1 AND deleted_at IS NOT NULL
+	ORDER BY deleted_at, comment_id;
+	`, issueKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []IssueEvent
+	for rows.Next() {
+		ie := IssueEvent{EventKind: EventCommentDeleted, IssueKey: issueKey}
+		var id string
+		if err = rows.Scan(&id, &ie.EventAuthor, &ie.EventAuthorAccountID, &ie.EventTime); err != nil {
+			return nil, err
+		}
+		ie.CommentID = &id
+		events = append(events, ie)
+	}
+	return events, rows.Err()
+}
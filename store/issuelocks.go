@@ -0,0 +1,52 @@
+package store
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// issueLockShards is the number of locks the writes of the issues
+// are sharded on by their keys.
+const issueLockShards = 256
+
+// issueLocks serializes the writes of the same issues by the
+// concurrent writers of a store (e.g. the workers of a sync and the
+// webhooks in real-time mode), so the events of an issue written
+// twice at the same time are not interleaved: each write of an issue
+// replaces all its events, inserted in event-time order (see
+// `sortedEvents`). The writes of issues on different shards run
+// concurrently.
+type issueLocks struct {
+	shards [issueLockShards]sync.Mutex
+}
+
+// lock locks the shards of the keys and returns the function
+// unlocking them. The shards are locked in order, so concurrent
+// calls with overlapping keys can't deadlock.
+func (l *issueLocks) lock(keys ...string) (unlock func()) {
+	seen := make(map[int]bool, len(keys))
+	var shards []int
+	for _, k := range keys {
+		if i := issueLockShard(k); !seen[i] {
+			seen[i] = true
+			shards = append(shards, i)
+		}
+	}
+	sort.Ints(shards)
+	for _, i := range shards {
+		l.shards[i].Lock()
+	}
+	return func() {
+		for j := len(shards) - 1; j >= 0; j-- {
+			l.shards[shards[j]].Unlock()
+		}
+	}
+}
+
+// issueLockShard returns the shard of the issue's key.
+func issueLockShard(k string) int {
+	h := fnv.New32a()
+	h.Write([]byte(k))
+	return int(h.Sum32() % issueLockShards)
+}
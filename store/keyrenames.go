@@ -0,0 +1,83 @@
+package store
+
+import (
+	"sort"
+	"strings"
+)
+
+// KeyRenames maps the previous keys of the renamed projects to their
+// current key (e.g. "OLD" to "NEW"), so the issues keep a single key
+// across the rename (e.g. "NEW-12" for "OLD-12").
+type KeyRenames map[string]string
+
+// Normalize returns the current key of the issue key, or the key
+// itself if its project was not renamed. Chained renames (e.g. "A"
+// to "B" then "B" to "C") are followed.
+func (r KeyRenames) Normalize(issueKey string) string {
+	for n := 0; n < len(r); n++ {
+		i := strings.LastIndex(issueKey, "-")
+		if i < 0 {
+			return issueKey
+		}
+		to, ok := r[issueKey[:i]]
+		if !ok {
+			return issueKey
+		}
+		issueKey = to + issueKey[i:]
+	}
+	return issueKey
+}
+
+// GetRenamedIssueKeys returns the previous keys of the renamed
+// projects with which issues are stored while they're also stored
+// with their current key (e.g. "OLD-12" if "NEW-12" is stored),
+// sorted. Their records can be deleted (see `DeleteIssue`), since
+// those of the current key are mapped from the whole history of the
+// issue.
+func (s *PGStore) GetRenamedIssueKeys(renames KeyRenames) ([]string, error) {
+	previous := make([]string, 0, len(renames))
+	for from := range renames {
+		previous = append(previous, from)
+	}
+	sort.Strings(previous)
+	var keys []string
+	for _, from := range previous {
+		rows, err := s.Query(`
+		SELECT s.issue_key
+		FROM jira_issues_states s
+		WHERE s.issue_key LIKE $1 || '-%'
+		ORDER BY s.issue_key;
+		`, from)
+		if err != nil {
+			return nil, err
+		}
+		var candidates []string
+		for rows.Next() {
+			var k string
+			if err = rows.Scan(&k); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			candidates = append(candidates, k)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		for _, k := range candidates {
+			current := renames.Normalize(k)
+			if current == k {
+				continue
+			}
+			var exists bool
+			if err = s.QueryRow(`SELECT EXISTS (SELECT 1 FROM jira_issues_states WHERE issue_key = $1);`, current).Scan(&exists); err != nil {
+				return nil, err
+			}
+			if exists {
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"database/sql"
+)
+
+// FieldLineage describes where a column of the warehouse comes from,
+// to be stored in `field_lineage` for data governance tools.
+type FieldLineage struct {
+	Table  string
+	Column string
+
+	// JiraField is the name of the Jira field the column is mapped
+	// from (e.g. "Fix Version/s"), nil if the column is computed by
+	// the mapping (e.g. `issue_severity_bucket`).
+	JiraField *string
+
+	// JiraFieldID is the ID of the Jira field (e.g. "fixVersions"
+	// or "customfield_10600"), nil if the column is not mapped from
+	// a single field.
+	JiraFieldID *string
+
+	// MapperVersion is the version of the mapping which filled the
+	// column (see `mapping.Version`).
+	MapperVersion string
+
+	// Transformation explains how the column's value is obtained
+	// from the Jira data.
+	Transformation string
+}
+
+// fieldLineageTables are the tables created with `CreateTables` to
+// store the lineage of the columns, replaced on each sync (see
+// `ReplaceFieldLineage`). E.g. to list the columns filled from
+// custom fields:
+//
+//	SELECT table_name, column_name, jira_field_id
+//	FROM field_lineage
+//	WHERE jira_field_id LIKE 'customfield_%';
+var fieldLineageTables = []string{
+	`CREATE TABLE IF NOT EXISTS "field_lineage" (
+		"table_name" TEXT NOT NULL,
+		"column_name" TEXT NOT NULL,
+		"jira_field" TEXT,
+		"jira_field_id" TEXT,
+		"mapper_version" TEXT NOT NULL,
+		"transformation" TEXT NOT NULL,
+		"recorded_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		PRIMARY KEY ("table_name", "column_name")
+	);`,
+}
+
+// ReplaceFieldLineage replaces all records in `field_lineage` by the
+// passed ones.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) ReplaceFieldLineage(fls []FieldLineage) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM field_lineage;"); err != nil {
+		return
+	}
+	for _, fl := range fls {
+		if err = insertFieldLineage(tx, fl); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func insertFieldLineage(tx *sql.Tx, fl FieldLineage) (err error) {
+	query := `
+	INSERT INTO field_lineage (
+		table_name,
+		column_name,
+		jira_field,
+		jira_field_id,
+		mapper_version,
+		transformation
+	)
+	VALUES ($1, $2, $3, $4, $5, $6);
+	`
+	_, err = tx.Exec(query, fl.Table, fl.Column, fl.JiraField, fl.JiraFieldID, fl.MapperVersion, fl.Transformation)
+	return
+}
@@ -0,0 +1,152 @@
+package store
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// The directions of an `IssueLink`, from the point of view of the
+// issue it was read from (`SourceKey`).
+//
+// For a "Blocks" link between A and B (A blocks B), A has an
+// outward link to B and B has an inward link to A.
+const (
+	LinkOutward = "outward"
+	LinkInward  = "inward"
+)
+
+// The types of the links of the hierarchy of the issues, stored
+// along with the links between issues: a sub-task has a "Parent"
+// link to its parent, an issue of an epic an "Epic" link to the
+// epic, and an epic (or an issue of a higher level) a "Parent link"
+// link to its parent in the hierarchy of Advanced Roadmaps (e.g. an
+// initiative). They are outward links of the child issue only, since
+// the children are not listed with the parent issue.
+const (
+	LinkTypeParent     = "Parent"
+	LinkTypeEpic       = "Epic"
+	LinkTypeParentLink = "Parent link"
+)
+
+// LinkTypePreviousKey is the type of the links from an issue moved
+// to another project to each key it had before (e.g. from "NEW-4" to
+// "PJ-8"). The links are queried as aliases of the keys with the
+// `jira_issue_key_aliases` view.
+const LinkTypePreviousKey = "Previous key"
+
+// IssueLink represents a link between two issues (e.g. "Blocks",
+// "Relates") to be stored in the DB.
+type IssueLink struct {
+	SourceKey string
+	TargetKey string
+	LinkType  string
+	Direction string
+}
+
+// linksTables are the tables created with `CreateTables` to store
+// the links between issues.
+var linksTables = []string{
+	`CREATE TABLE "jira_issue_links" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"source_key" TEXT NOT NULL,
+		"target_key" TEXT NOT NULL,
+		"link_type" TEXT NOT NULL,
+		"direction" TEXT NOT NULL
+	);`,
+}
+
+// linksViews are the views created along with the tables to query
+// the links.
+//
+// ### jira_issue_ancestors
+//
+// Returns one row per ancestor of each issue in the hierarchy of
+// the issues (see `LinkTypeParent`), with its depth (1 for the
+// parent or epic of the issue) and the type of the link to it (e.g.
+// "Epic" for the epic of the parent of a sub-task). E.g. to roll the
+// bugs up to their epics, including the bug sub-tasks of the
+// epic's stories:
+//
+//	SELECT a.ancestor_key AS epic_key, COUNT(*)
+//	FROM jira_issue_ancestors a
+//	JOIN jira_issues_states s ON s.issue_key = a.issue_key
+//	WHERE a.link_type = 'Epic' AND s.issue_type = 'Bug'
+//	GROUP BY a.ancestor_key;
+var linksViews = []string{
+	`CREATE OR REPLACE VIEW jira_issue_ancestors AS
+	WITH RECURSIVE ancestors (issue_key, ancestor_key, link_type, depth, path) AS (
+		SELECT l.source_key, l.target_key, l.link_type, 1, ARRAY[l.source_key]
+		FROM jira_issue_links l
+		WHERE l.link_type IN ('Parent', 'Epic', 'Parent link') AND l.direction = 'outward'
+		UNION ALL
+		SELECT a.issue_key, l.target_key, l.link_type, a.depth + 1, a.path || l.source_key
+		FROM ancestors a
+		JOIN jira_issue_links l ON l.source_key = a.ancestor_key
+		WHERE l.link_type IN ('Parent', 'Epic', 'Parent link') AND l.direction = 'outward'
+		AND NOT l.target_key = ANY(a.path)
+	)
+	SELECT issue_key, ancestor_key, link_type, depth FROM ancestors;`,
+	keyAliasesView,
+}
+
+// keyAliasesView creates the `jira_issue_key_aliases` view, which
+// returns one row per previous key (`alias_key`) of the issues moved
+// to other projects, with the current key of the issue (`issue_key`).
+// E.g. to find the records of a purged issue which was only moved:
+//
+//	SELECT a.issue_key
+//	FROM jira_issue_key_aliases a
+//	WHERE a.alias_key = 'PJ-8';
+const keyAliasesView = `CREATE OR REPLACE VIEW jira_issue_key_aliases AS
+	SELECT target_key AS alias_key, source_key AS issue_key
+	FROM jira_issue_links
+	WHERE link_type = 'Previous key' AND direction = 'outward';`
+
+// GetLinks returns the links of the specified types (e.g. "Blocks")
+// from `jira_issue_links`. All links are returned if `linkTypes` is
+// nil.
+func (s *PGStore) GetLinks(linkTypes []string) ([]IssueLink, error) {
+	q := `
+	SELECT source_key, target_key, link_type, direction
+	FROM jira_issue_links
+	WHERE $1::TEXT[] IS NULL OR link_type = ANY($1)
+	ORDER BY source_key, target_key
+	`
+	rows, err := s.Query(q, pq.Array(linkTypes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]IssueLink, 0)
+	for rows.Next() {
+		var l IssueLink
+		if err = rows.Scan(&l.SourceKey, &l.TargetKey, &l.LinkType, &l.Direction); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// insertIssueLinks inserts the passed links in the store within the
+// specified transaction.
+func insertIssueLinks(tx *sql.Tx, links []IssueLink) (err error) {
+	query := `
+	INSERT INTO jira_issue_links (
+		source_key,
+		target_key,
+		link_type,
+		direction
+	)
+	VALUES ($1, $2, $3, $4);
+	`
+	for _, l := range links {
+		if _, err = tx.Exec(query, l.SourceKey, l.TargetKey, l.LinkType, l.Direction); err != nil {
+			return
+		}
+	}
+	return
+}
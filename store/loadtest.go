@@ -0,0 +1,264 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Strategies of `LoadTest` to insert the events
+const (
+	// LoadTestSingle inserts each event with its own `INSERT`,
+	// outside of any transaction.
+	LoadTestSingle = "single"
+
+	// LoadTestBatch inserts the events of a batch with a multi-row
+	// `INSERT`, in a transaction.
+	LoadTestBatch = "batch"
+
+	// LoadTestCopy inserts the events of a batch with `COPY`, in a
+	// transaction, as `Writer` does.
+	LoadTestCopy = "copy"
+)
+
+// LoadTestStrategies are the strategies of `LoadTest`, from the
+// slowest to the fastest one in most setups.
+var LoadTestStrategies = []string{LoadTestSingle, LoadTestBatch, LoadTestCopy}
+
+// Defaults of `LoadTestOptions`
+const (
+	DefaultLoadTestEvents    = 10000
+	DefaultLoadTestBatchSize = 500
+)
+
+// loadTestKeyPrefix is the prefix of the keys of the issues of the
+// events written by `LoadTest`, which are deleted afterwards.
+const loadTestKeyPrefix = "LOADTEST-"
+
+// maxQueryParams is the maximum number of parameters of a Postgres
+// statement.
+const maxQueryParams = 65535
+
+// LoadTestOptions are the options of `LoadTest`.
+type LoadTestOptions struct {
+	// Strategy is the way the events are inserted (e.g.
+	// `LoadTestCopy`).
+	Strategy string
+
+	// Events is the number of written events. Defaults to
+	// `DefaultLoadTestEvents`.
+	Events int
+
+	// BatchSize is the number of events written at once by the
+	// `LoadTestBatch` and `LoadTestCopy` strategies. Defaults to
+	// `DefaultLoadTestBatchSize`.
+	BatchSize int
+
+	// EventsPerSecond is the target rate of written events. Events
+	// are written as fast as possible if zero.
+	EventsPerSecond float64
+}
+
+// LoadTestResult is the result of a `LoadTest`.
+type LoadTestResult struct {
+	Strategy string
+	Events   int
+
+	// Writes is the number of statements (`LoadTestSingle`) or
+	// transactions (`LoadTestBatch`, `LoadTestCopy`) performed.
+	Writes int
+
+	// Duration is the time taken to write all the events, including
+	// the waits to respect the target rate.
+	Duration time.Duration
+
+	// Latencies of the writes, by percentile (nearest-rank method)
+	P50, P95, P99, Max time.Duration
+}
+
+// EventsPerSecond returns the throughput of the load test.
+func (r LoadTestResult) EventsPerSecond() float64 {
+	if r.Duration == 0 {
+		return 0
+	}
+	return float64(r.Events) / r.Duration.Seconds()
+}
+
+// LoadTest writes synthetic events to `jira_issues_events` with the
+// strategy of the options and measures the throughput and latency
+// of the writes, so operators can choose an ingestion strategy
+// fitting their DB (e.g. `max_rows_per_second` and the batch size
+// of `Writer`). The store's throttle is not applied.
+//
+// The events belong to issues with keys starting with `LOADTEST-`,
+// which are deleted before and after the test.
+func (s *PGStore) LoadTest(o LoadTestOptions) (r LoadTestResult, err error) {
+	if s.strictSchema {
+		return r, errors.New("the store benchmark writes events without states, it can't be run with a strict schema")
+	}
+	if o.Events <= 0 {
+		o.Events = DefaultLoadTestEvents
+	}
+	switch {
+	case o.Strategy == LoadTestSingle:
+		o.BatchSize = 1
+	case o.BatchSize <= 0:
+		o.BatchSize = DefaultLoadTestBatchSize
+	}
+	columns := append(issueEventColumns, customColumnNames(s.customColumns)...)
+
+	var write func(rows [][]interface{}) error
+	switch o.Strategy {
+	case LoadTestSingle:
+		write = func(rows [][]interface{}) error {
+			_, err := s.Exec(insertQuery("jira_issues_events", columns), rows[0]...)
+			return err
+		}
+	case LoadTestBatch:
+		if o.BatchSize*len(columns) > maxQueryParams {
+			return r, fmt.Errorf("batch size too large for a multi-row INSERT (max %d)", maxQueryParams/len(columns))
+		}
+		write = func(rows [][]interface{}) error {
+			var values []interface{}
+			for _, row := range rows {
+				values = append(values, row...)
+			}
+			tx, err := s.Begin()
+			if err != nil {
+				return err
+			}
+			if _, err = tx.Exec(insertRowsQuery("jira_issues_events", columns, len(rows)), values...); err != nil {
+				tx.Rollback()
+				return err
+			}
+			return tx.Commit()
+		}
+	case LoadTestCopy:
+		write = func(rows [][]interface{}) error {
+			tx, err := s.Begin()
+			if err != nil {
+				return err
+			}
+			if err = copyRows(tx, "jira_issues_events", columns, rows); err != nil {
+				tx.Rollback()
+				return err
+			}
+			return tx.Commit()
+		}
+	default:
+		return r, fmt.Errorf("unknown load test strategy `%s`", o.Strategy)
+	}
+
+	if err = s.deleteLoadTestEvents(); err != nil {
+		return
+	}
+	defer func() {
+		if cleanupErr := s.deleteLoadTestEvents(); err == nil {
+			err = cleanupErr
+		}
+	}()
+
+	r = LoadTestResult{Strategy: o.Strategy, Events: o.Events}
+	var latencies []time.Duration
+	start := time.Now()
+	for written := 0; written < o.Events; {
+		if o.EventsPerSecond > 0 {
+			due := start.Add(time.Duration(float64(written) / o.EventsPerSecond * float64(time.Second)))
+			time.Sleep(time.Until(due))
+		}
+		n := o.BatchSize
+		if written+n > o.Events {
+			n = o.Events - written
+		}
+		rows := make([][]interface{}, n)
+		for i := range rows {
+			ie, is := loadTestEvent(written + i)
+			rows[i] = append(issueEventValues(ie, is), customColumnValues(s.customColumns, is)...)
+		}
+		writeStart := time.Now()
+		if err = write(rows); err != nil {
+			return r, fmt.Errorf("error writing events %d to %d: %s", written, written+n, err)
+		}
+		latencies = append(latencies, time.Since(writeStart))
+		written += n
+	}
+	r.Duration = time.Since(start)
+	r.Writes = len(latencies)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.P50 = nearestRank(latencies, 50)
+	r.P95 = nearestRank(latencies, 95)
+	r.P99 = nearestRank(latencies, 99)
+	r.Max = latencies[len(latencies)-1]
+	return
+}
+
+// loadTestEvent returns the `n`th synthetic event of a load test and
+// the state of its issue. Each issue has 10 events: its creation,
+// status changes and comments.
+func loadTestEvent(n int) (IssueEvent, IssueState) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(n/10) * time.Hour)
+	key := fmt.Sprintf("%s%d", loadTestKeyPrefix, n/10+1)
+	ie := IssueEvent{
+		EventTime:   created.Add(time.Duration(n%10) * time.Minute),
+		EventAuthor: "load-test",
+		IssueKey:    key,
+	}
+	switch n % 10 {
+	case 0:
+		ie.EventKind = EventCreated
+	case 3, 6:
+		ie.EventKind = EventCommentAdded
+		body := "Synthetic comment written by the load test"
+		ie.CommentBody = &body
+	default:
+		ie.EventKind = EventStatusChanged
+		from, to := "Open", "In Progress"
+		ie.StatusChangeFrom, ie.StatusChangeTo = &from, &to
+	}
+	project, status, priority, summary, issueType := "LOADTEST", "In Progress", "Medium", "Load test issue", "Task"
+	is := IssueState{
+		Key:       key,
+		CreatedAt: created,
+		UpdatedAt: created.Add(10 * time.Minute),
+		Project:   &project,
+		Status:    &status,
+		Priority:  &priority,
+		Summary:   &summary,
+		Type:      &issueType,
+	}
+	return ie, is
+}
+
+// deleteLoadTestEvents deletes the events written by `LoadTest`.
+func (s *PGStore) deleteLoadTestEvents() error {
+	_, err := s.Exec(`DELETE FROM jira_issues_events WHERE issue_key LIKE $1;`, loadTestKeyPrefix+"%")
+	return err
+}
+
+// insertRowsQuery returns the statement inserting `n` rows in the
+// table, e.g. `INSERT INTO t (a, b) VALUES ($1, $2), ($3, $4);`.
+func insertRowsQuery(table string, columns []string, n int) string {
+	rows := make([]string, n)
+	for r := range rows {
+		placeholders := make([]string, len(columns))
+		for i := range columns {
+			placeholders[i] = fmt.Sprintf("$%d", r*len(columns)+i+1)
+		}
+		rows[r] = "(" + strings.Join(placeholders, ", ") + ")"
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s;", table, strings.Join(columns, ", "), strings.Join(rows, ", "))
+}
+
+// nearestRank returns the `p`th percentile of the sorted durations
+// using the nearest-rank method.
+func nearestRank(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,451 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// IssueHistory groups the events of an issue with the issue's
+// fields needed to compute metrics.
+type IssueHistory struct {
+	IssueKey  string
+	Project   string
+	Type      string
+	CreatedAt time.Time
+	Events    []IssueEvent
+}
+
+// IssueMetrics represents the metrics computed for an issue, to be
+// stored in the DB.
+type IssueMetrics struct {
+	IssueKey  string
+	Project   string
+	Type      string
+	CreatedAt time.Time
+	StartedAt *time.Time
+	DoneAt    *time.Time
+	LeadTime  *time.Duration
+	CycleTime *time.Duration
+
+	// FirstResponseAt and FirstResponseTime are only computed
+	// for bugs.
+	FirstResponseAt   *time.Time
+	FirstResponseTime *time.Duration
+
+	// Reopenings is the number of times the issue was reopened
+	// after being done.
+	Reopenings int
+
+	// FirstAssignedAt is the time the issue was first assigned, and
+	// TimeToFirstAssignment the duration from its creation until
+	// then. Nil if the issue was never assigned.
+	FirstAssignedAt       *time.Time
+	TimeToFirstAssignment *time.Duration
+
+	// LeadTimeBusiness, CycleTimeBusiness, FirstResponseTimeBusiness
+	// and TimeToFirstAssignmentBusiness are the durations in business
+	// time (see `metrics.Calendar`). Nil if no calendar is
+	// configured.
+	LeadTimeBusiness              *time.Duration
+	CycleTimeBusiness             *time.Duration
+	FirstResponseTimeBusiness     *time.Duration
+	TimeToFirstAssignmentBusiness *time.Duration
+
+	// BlockedTime is the time the issue was flagged or blocked by an
+	// unresolved issue (see `metrics.Compute`), and
+	// BlockedTimeBusiness the same in business time (nil if no
+	// calendar is configured).
+	BlockedTime         time.Duration
+	BlockedTimeBusiness *time.Duration
+
+	// FirstTimeRight is true if the issue passed its review statuses
+	// without going back before them (see `metrics.Compute`). Nil if
+	// the issue is not done or never entered a review status.
+	FirstTimeRight *bool
+
+	// StatusTimes are the times spent in each status the issue
+	// left, in the order the statuses were first entered. They are
+	// stored in `jira_issue_status_times`.
+	StatusTimes []StatusTime
+}
+
+// StatusTime is the total time an issue spent in a status, over all
+// the periods it was in it.
+type StatusTime struct {
+	Status   string
+	Duration time.Duration
+
+	// BusinessDuration is the time in business time, nil if no
+	// calendar is configured.
+	BusinessDuration *time.Duration
+}
+
+// WeeklyStats represents the stats of a week for the issues of a
+// project and type, to be stored in the DB.
+type WeeklyStats struct {
+	// Week is the first day (monday) of the week.
+	Week    time.Time
+	Project string
+	Type    string
+
+	// Throughput is the number of issues done during the week.
+	Throughput int
+
+	// CycleTimeP85 and CycleTimeP95 are the 85th and 95th
+	// percentiles of the cycle times of the issues done during
+	// the trailing window ending with the week.
+	CycleTimeP85 *time.Duration
+	CycleTimeP95 *time.Duration
+}
+
+// metricsTables are the tables created with `CreateTables` to
+// store the metrics computed by the `analyze` action, per issue
+// and per week.
+//
+// Throughput can be computed from `done_at`, e.g.:
+//
+//	SELECT date_trunc('week', done_at) AS week, COUNT(*)
+//	FROM jira_issue_metrics
+//	WHERE done_at IS NOT NULL
+//	GROUP BY week;
+var metricsTables = []string{
+	`CREATE TABLE "jira_issue_metrics" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"issue_key" TEXT NOT NULL,
+		"issue_project" TEXT NOT NULL,
+		"issue_type" TEXT NOT NULL,
+		"issue_created_at" TIMESTAMP NOT NULL,
+		"started_at" TIMESTAMP,
+		"done_at" TIMESTAMP,
+		"lead_time_seconds" BIGINT,
+		"cycle_time_seconds" BIGINT,
+		"first_response_at" TIMESTAMP,
+		"first_response_time_seconds" BIGINT,
+		"reopenings_count" INTEGER NOT NULL DEFAULT 0,
+		"first_assigned_at" TIMESTAMP,
+		"time_to_first_assignment_seconds" BIGINT,
+		"lead_time_business_seconds" BIGINT,
+		"cycle_time_business_seconds" BIGINT,
+		"first_response_time_business_seconds" BIGINT,
+		"time_to_first_assignment_business_seconds" BIGINT,
+		"blocked_time_seconds" BIGINT NOT NULL DEFAULT 0,
+		"blocked_time_business_seconds" BIGINT,
+		"first_time_right" BOOLEAN
+	);`,
+	`CREATE TABLE "jira_issue_status_times" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"issue_key" TEXT NOT NULL,
+		"issue_project" TEXT NOT NULL,
+		"status" TEXT NOT NULL,
+		"duration_seconds" BIGINT NOT NULL,
+		"business_duration_seconds" BIGINT
+	);`,
+	`CREATE TABLE "jira_weekly_stats" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"week" DATE NOT NULL,
+		"issue_project" TEXT NOT NULL,
+		"issue_type" TEXT NOT NULL,
+		"throughput" INTEGER NOT NULL,
+		"cycle_time_p85_seconds" BIGINT,
+		"cycle_time_p95_seconds" BIGINT
+	);`,
+}
+
+// EachIssueHistory calls `fn` with the history of each issue in
+// the store. Events are sorted by time.
+//
+// Stops and returns the error if `fn` returns one.
+func (s *PGStore) EachIssueHistory(fn func(h IssueHistory) error) error {
+	return s.eachIssueHistory("", fn)
+}
+
+// GetIssueHistory returns the history of the issue specified by its
+// key, or nil if the issue has no event in the store.
+func (s *PGStore) GetIssueHistory(issueKey string) (*IssueHistory, error) {
+	var history *IssueHistory
+	err := s.eachIssueHistory("WHERE issue_key = $1", func(h IssueHistory) error {
+		history = &h
+		return nil
+	}, issueKey)
+	return history, err
+}
+
+// eachIssueHistory implements `EachIssueHistory` for the events
+// matching the `where` clause and its arguments.
+func (s *PGStore) eachIssueHistory(where string, fn func(h IssueHistory) error, args ...interface{}) error {
+	q := `
+	SELECT
+		issue_key,
+		issue_project,
+		issue_type,
+		issue_created_at,
+		event_time,
+		event_kind,
+		event_author,
+		comment_body,
+		status_change_from,
+		status_change_to,
+		assignee_change_from,
+		assignee_change_to,
+		author_excluded,
+		field_name,
+		field_change_from,
+		field_change_to
+	FROM jira_issues_events
+	` + where + `
+	ORDER BY issue_key, event_time, id
+	`
+	rows, err := s.Query(q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var h *IssueHistory
+	for rows.Next() {
+		var k, project, issueType string
+		var createdAt time.Time
+		var e IssueEvent
+		err = rows.Scan(
+			&k,
+			&project,
+			&issueType,
+			&createdAt,
+			&e.EventTime,
+			&e.EventKind,
+			&e.EventAuthor,
+			&e.CommentBody,
+			&e.StatusChangeFrom,
+			&e.StatusChangeTo,
+			&e.AssigneeChangeFrom,
+			&e.AssigneeChangeTo,
+			&e.AuthorExcluded,
+			&e.FieldName,
+			&e.FieldChangeFrom,
+			&e.FieldChangeTo,
+		)
+		if err != nil {
+			return err
+		}
+		e.IssueKey = k
+		if h != nil && h.IssueKey != k {
+			if err = fn(*h); err != nil {
+				return err
+			}
+			h = nil
+		}
+		if h == nil {
+			h = &IssueHistory{
+				IssueKey:  k,
+				Project:   project,
+				Type:      issueType,
+				CreatedAt: createdAt,
+			}
+		}
+		h.Events = append(h.Events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if h != nil {
+		return fn(*h)
+	}
+	return nil
+}
+
+// ReplaceIssueMetrics replaces all records in `jira_issue_metrics`
+// and `jira_issue_status_times` by the passed ones.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) ReplaceIssueMetrics(ims []IssueMetrics) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM jira_issue_metrics;"); err != nil {
+		return
+	}
+	if _, err = tx.Exec("DELETE FROM jira_issue_status_times;"); err != nil {
+		return
+	}
+	for _, im := range ims {
+		if err = insertIssueMetrics(tx, im); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ReplaceWeeklyStats replaces all records in `jira_weekly_stats`
+// by the passed ones.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) ReplaceWeeklyStats(wss []WeeklyStats) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM jira_weekly_stats;"); err != nil {
+		return
+	}
+	return insertWeeklyStats(tx, wss)
+}
+
+// insertWeeklyStats inserts the weekly stats in `jira_weekly_stats`
+// within the transaction.
+func insertWeeklyStats(tx *sql.Tx, wss []WeeklyStats) (err error) {
+	query := `
+	INSERT INTO jira_weekly_stats (
+		week,
+		issue_project,
+		issue_type,
+		throughput,
+		cycle_time_p85_seconds,
+		cycle_time_p95_seconds
+	)
+	VALUES ($1, $2, $3, $4, $5, $6);
+	`
+	for _, ws := range wss {
+		_, err = tx.Exec(
+			query,
+			ws.Week,
+			ws.Project,
+			ws.Type,
+			ws.Throughput,
+			seconds(ws.CycleTimeP85),
+			seconds(ws.CycleTimeP95),
+		)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// GetStatusCategories returns the key of the Jira status category
+// (e.g. "indeterminate") of each status, as recorded in the
+// latest issue states.
+func (s *PGStore) GetStatusCategories() (map[string]string, error) {
+	q := `
+	SELECT DISTINCT ON (issue_status) issue_status, issue_status_category
+	FROM jira_issues_states
+	WHERE issue_status_category IS NOT NULL
+	ORDER BY issue_status, issue_updated_at DESC
+	`
+	rows, err := s.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := make(map[string]string)
+	for rows.Next() {
+		var status, category string
+		if err = rows.Scan(&status, &category); err != nil {
+			return nil, err
+		}
+		categories[status] = category
+	}
+	return categories, rows.Err()
+}
+
+func insertIssueMetrics(tx *sql.Tx, im IssueMetrics) (err error) {
+	query := `
+	INSERT INTO jira_issue_metrics (
+		issue_key,
+		issue_project,
+		issue_type,
+		issue_created_at,
+		started_at,
+		done_at,
+		lead_time_seconds,
+		cycle_time_seconds,
+		first_response_at,
+		first_response_time_seconds,
+		reopenings_count,
+		first_assigned_at,
+		time_to_first_assignment_seconds,
+		lead_time_business_seconds,
+		cycle_time_business_seconds,
+		first_response_time_business_seconds,
+		time_to_first_assignment_business_seconds,
+		blocked_time_seconds,
+		blocked_time_business_seconds,
+		first_time_right
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20);
+	`
+	_, err = tx.Exec(
+		query,
+		im.IssueKey,
+		im.Project,
+		im.Type,
+		im.CreatedAt,
+		im.StartedAt,
+		im.DoneAt,
+		seconds(im.LeadTime),
+		seconds(im.CycleTime),
+		im.FirstResponseAt,
+		seconds(im.FirstResponseTime),
+		im.Reopenings,
+		im.FirstAssignedAt,
+		seconds(im.TimeToFirstAssignment),
+		seconds(im.LeadTimeBusiness),
+		seconds(im.CycleTimeBusiness),
+		seconds(im.FirstResponseTimeBusiness),
+		seconds(im.TimeToFirstAssignmentBusiness),
+		int64(im.BlockedTime.Seconds()),
+		seconds(im.BlockedTimeBusiness),
+		im.FirstTimeRight,
+	)
+	if err != nil {
+		return
+	}
+	for _, st := range im.StatusTimes {
+		_, err = tx.Exec(
+			`INSERT INTO jira_issue_status_times (issue_key, issue_project, status, duration_seconds, business_duration_seconds) VALUES ($1, $2, $3, $4, $5);`,
+			im.IssueKey,
+			im.Project,
+			st.Status,
+			seconds(&st.Duration),
+			seconds(st.BusinessDuration),
+		)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// seconds returns the number of seconds of the duration, or nil if
+// the duration is nil.
+func seconds(d *time.Duration) *int64 {
+	if d == nil {
+		return nil
+	}
+	s := int64(d.Seconds())
+	return &s
+}
@@ -0,0 +1,248 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The kinds of computations of the metrics recorded with
+// `StartMetricsRefresh`
+const (
+	MetricsRefreshFull        = "full"
+	MetricsRefreshIncremental = "incremental"
+)
+
+// MetricsGroup is a project and issue type, the metrics of whose
+// issues are aggregated in `jira_weekly_stats`.
+type MetricsGroup struct {
+	Project string
+	Type    string
+}
+
+// metricsRefreshesTables are the tables created with `CreateTables`
+// to record the computations of the metrics, so the next one only
+// recomputes the metrics of the issues whose events were written
+// since the last one with the same settings (the fingerprint, see
+// `metrics.Fingerprint`), and the index of the events by insertion
+// time used to find them.
+var metricsRefreshesTables = []string{
+	`CREATE TABLE IF NOT EXISTS "jira_metrics_refreshes" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"started_at" TIMESTAMP(6) NOT NULL,
+		"finished_at" TIMESTAMP(6),
+		"kind" TEXT NOT NULL,
+		"fingerprint" TEXT NOT NULL,
+		"issues_count" INTEGER
+	);`,
+	`CREATE INDEX IF NOT EXISTS "jira_issues_events_inserted_at_idx" ON "jira_issues_events" ("inserted_at");`,
+}
+
+// StartMetricsRefresh records the start of a computation of the
+// metrics of the kind (e.g. "incremental") in
+// `jira_metrics_refreshes`, and returns its ID. The start time is
+// the DB's clock, which is also used for the insertion time of the
+// events.
+func (s *PGStore) StartMetricsRefresh(kind string, fingerprint string) (id int64, err error) {
+	err = s.QueryRow(`
+	INSERT INTO jira_metrics_refreshes (started_at, kind, fingerprint)
+	VALUES (statement_timestamp(), $1, $2)
+	RETURNING id;
+	`, kind, fingerprint).Scan(&id)
+	return
+}
+
+// FinishMetricsRefresh records the successful end of the
+// computation of the metrics, with the number of issues whose
+// metrics were computed.
+func (s *PGStore) FinishMetricsRefresh(id int64, issuesCount int) error {
+	_, err := s.Exec(`
+	UPDATE jira_metrics_refreshes
+	SET finished_at = statement_timestamp(), issues_count = $2
+	WHERE id = $1;
+	`, id, issuesCount)
+	return err
+}
+
+// GetLastMetricsRefresh returns the start time of the last
+// successful computation of the metrics with the fingerprint, or nil
+// if there is none.
+func (s *PGStore) GetLastMetricsRefresh(fingerprint string) (*time.Time, error) {
+	var t sql.NullTime
+	err := s.QueryRow(`
+	SELECT MAX(started_at)
+	FROM jira_metrics_refreshes
+	WHERE finished_at IS NOT NULL AND fingerprint = $1;
+	`, fingerprint).Scan(&t)
+	if err != nil || !t.Valid {
+		return nil, err
+	}
+	return &t.Time, nil
+}
+
+// GetChangedIssueKeys returns the keys of the issues whose events
+// were written since `since` (the events of an issue are all written
+// again each time it's synced), of the issues they blocked (see
+// `FieldBlockedBy`), whose blocked time may end with their
+// resolution, and of the issues which have metrics but no events
+// anymore (e.g. after a purge), sorted.
+func (s *PGStore) GetChangedIssueKeys(since time.Time) ([]string, error) {
+	rows, err := s.Query(`
+	SELECT DISTINCT issue_key FROM jira_issues_events WHERE inserted_at >= $1
+	UNION
+	SELECT m.issue_key FROM jira_issue_metrics m
+	WHERE NOT EXISTS (SELECT 1 FROM jira_issues_events e WHERE e.issue_key = m.issue_key)
+	UNION
+	SELECT b.issue_key FROM jira_issues_events b
+	WHERE b.field_name = $2
+	AND b.field_change_to IN (SELECT issue_key FROM jira_issues_events WHERE inserted_at >= $1)
+	ORDER BY 1;
+	`, since, FieldBlockedBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err = rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// EachIssueHistoryOf calls `fn` with the history of each issue of
+// the keys having events, like `EachIssueHistory`.
+func (s *PGStore) EachIssueHistoryOf(issueKeys []string, fn func(h IssueHistory) error) error {
+	return s.eachIssueHistory("WHERE issue_key = ANY($1)", fn, pq.Array(issueKeys))
+}
+
+// GetIssueMetricsGroups returns the projects and types of the
+// metrics stored for the issues.
+func (s *PGStore) GetIssueMetricsGroups(issueKeys []string) ([]MetricsGroup, error) {
+	rows, err := s.Query(`
+	SELECT DISTINCT issue_project, issue_type
+	FROM jira_issue_metrics
+	WHERE issue_key = ANY($1)
+	ORDER BY 1, 2;
+	`, pq.Array(issueKeys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var groups []MetricsGroup
+	for rows.Next() {
+		var g MetricsGroup
+		if err = rows.Scan(&g.Project, &g.Type); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// ReplaceIssuesMetrics replaces the records of the issues in
+// `jira_issue_metrics` and `jira_issue_status_times` by the passed
+// ones, within a transaction. The records of the issues without
+// metrics in `ims` are deleted.
+func (s *PGStore) ReplaceIssuesMetrics(issueKeys []string, ims []IssueMetrics) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(`DELETE FROM jira_issue_metrics WHERE issue_key = ANY($1);`, pq.Array(issueKeys)); err != nil {
+		return
+	}
+	if _, err = tx.Exec(`DELETE FROM jira_issue_status_times WHERE issue_key = ANY($1);`, pq.Array(issueKeys)); err != nil {
+		return
+	}
+	for _, im := range ims {
+		if err = insertIssueMetrics(tx, im); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// GetDoneIssueMetrics returns the metrics of the issues of the
+// groups which are done, with the fields used by the weekly stats
+// only (project, type, done time and cycle time).
+func (s *PGStore) GetDoneIssueMetrics(groups []MetricsGroup) ([]IssueMetrics, error) {
+	projects, types := groupArrays(groups)
+	rows, err := s.Query(`
+	SELECT m.issue_key, m.issue_project, m.issue_type, m.done_at, m.cycle_time_seconds
+	FROM jira_issue_metrics m
+	JOIN unnest($1::TEXT[], $2::TEXT[]) AS g (project, type) ON g.project = m.issue_project AND g.type = m.issue_type
+	WHERE m.done_at IS NOT NULL;
+	`, projects, types)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ims []IssueMetrics
+	for rows.Next() {
+		var im IssueMetrics
+		var cycleTime *int64
+		if err = rows.Scan(&im.IssueKey, &im.Project, &im.Type, &im.DoneAt, &cycleTime); err != nil {
+			return nil, err
+		}
+		if cycleTime != nil {
+			d := time.Duration(*cycleTime) * time.Second
+			im.CycleTime = &d
+		}
+		ims = append(ims, im)
+	}
+	return ims, rows.Err()
+}
+
+// ReplaceGroupsWeeklyStats replaces the records of the groups in
+// `jira_weekly_stats` by the passed ones, within a transaction.
+func (s *PGStore) ReplaceGroupsWeeklyStats(groups []MetricsGroup, wss []WeeklyStats) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	projects, types := groupArrays(groups)
+	if _, err = tx.Exec(`
+	DELETE FROM jira_weekly_stats w
+	USING unnest($1::TEXT[], $2::TEXT[]) AS g (project, type)
+	WHERE g.project = w.issue_project AND g.type = w.issue_type;
+	`, projects, types); err != nil {
+		return
+	}
+	return insertWeeklyStats(tx, wss)
+}
+
+// groupArrays returns the projects and types of the groups, as
+// arrays for the queries.
+func groupArrays(groups []MetricsGroup) (interface{}, interface{}) {
+	projects := make([]string, len(groups))
+	types := make([]string, len(groups))
+	for i, g := range groups {
+		projects[i], types[i] = g.Project, g.Type
+	}
+	return pq.Array(projects), pq.Array(types)
+}
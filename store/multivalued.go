@@ -0,0 +1,120 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// multiValuedField is a multi-valued field of the issues whose
+// values are stored one per row in its table, along with the
+// concatenated column of `jira_issues_states`, in normalized-fields
+// mode (see `SetNormalizedFields`).
+type multiValuedField struct {
+	table  string
+	column string
+	values func(is IssueState) []string
+}
+
+// multiValuedFields are the fields written to their own table in
+// normalized-fields mode: the labels, components and fix versions of
+// the issues.
+var multiValuedFields = []multiValuedField{
+	{"jira_issue_labels", "label", func(is IssueState) []string { return is.LabelList }},
+	{"jira_issue_components", "component", func(is IssueState) []string { return is.ComponentList }},
+	{"jira_issue_fix_versions", "fix_version", func(is IssueState) []string { return is.FixVersionList }},
+}
+
+// multiValuedTables are the tables created with `CreateTables` to
+// store the values of the multi-valued fields (see
+// `multiValuedFields`), filled in normalized-fields mode only. E.g.
+// to count the open bugs per component:
+//
+//	SELECT c.component, COUNT(*)
+//	FROM jira_issue_components c
+//	JOIN jira_issues_states s ON s.issue_key = c.issue_key
+//	WHERE s.issue_type = 'Bug' AND s.issue_resolved_at IS NULL
+//	GROUP BY c.component;
+var multiValuedTables = func() []string {
+	var queries []string
+	for _, f := range multiValuedFields {
+		queries = append(queries,
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (
+		"issue_key" TEXT NOT NULL,
+		"%s" TEXT NOT NULL,
+		PRIMARY KEY ("issue_key", "%s")
+	);`, f.table, f.column, f.column),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s_%s_idx" ON "%s" ("%s");`, f.table, f.column, f.table, f.column),
+		)
+	}
+	return queries
+}()
+
+// SetNormalizedFields enables the normalized-fields mode: the
+// labels, components and fix versions of the issues are written one
+// per row to `jira_issue_labels`, `jira_issue_components` and
+// `jira_issue_fix_versions` along with their state, so they can be
+// filtered in SQL without parsing the concatenated columns. The
+// tables are left empty otherwise.
+//
+// Only `PGStore` supports the mode.
+func (s *PGStore) SetNormalizedFields(normalized bool) {
+	s.normalizedFields = normalized
+}
+
+// rows returns the rows of the field's table for the
+// issue. Duplicate values are stored once.
+func (f multiValuedField) rows(is IssueState) [][]interface{} {
+	var rows [][]interface{}
+	seen := make(map[string]bool)
+	for _, v := range f.values(is) {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		rows = append(rows, []interface{}{is.Key, v})
+	}
+	return rows
+}
+
+// replaceMultiValues replaces the values of the multi-valued fields
+// of the issues within the transaction, if the normalized-fields mode
+// is enabled.
+func (s *PGStore) replaceMultiValues(tx *sql.Tx, states ...IssueState) error {
+	if !s.normalizedFields {
+		return nil
+	}
+	keys := make([]string, len(states))
+	for i, is := range states {
+		keys[i] = is.Key
+	}
+	for _, f := range multiValuedFields {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE issue_key = ANY($1);`, f.table), pq.Array(keys)); err != nil {
+			return err
+		}
+		var rows [][]interface{}
+		for _, is := range states {
+			rows = append(rows, f.rows(is)...)
+		}
+		if err := s.insertRows(tx, f.table, []string{"issue_key", f.column}, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteMultiValues deletes the values of the multi-valued fields of
+// the issue within the transaction, if the normalized-fields mode is
+// enabled.
+func (s *PGStore) deleteMultiValues(tx *sql.Tx, issueKey string) error {
+	if !s.normalizedFields {
+		return nil
+	}
+	for _, f := range multiValuedFields {
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM "%s" WHERE issue_key = $1;`, f.table), issueKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,565 @@
+package store
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// personColumns are the columns of a table referencing a user: the
+// account ID and the name of the user, either one being empty if the
+// table doesn't record it.
+type personColumns struct {
+	account string
+	name    string
+}
+
+// personDataTable describes the columns of a table referencing
+// persons, exported by `GetPersonData` and anonymized by
+// `ErasePersonData`.
+//
+// A row references the person if one of its account columns holds
+// the person's account ID, or if the account column is NULL (or the
+// table has none) and the name column holds one of the unambiguous
+// names of the person (see `personNames`): the rows of the other
+// people with the same name are not matched.
+type personDataTable struct {
+	table string
+
+	// users are the columns referencing users, and textColumns the
+	// texts mentioning users (e.g.
+	// "[~accountid:5b10a2844c20165700ede21g]" or "[~alice]").
+	users       []personColumns
+	textColumns []string
+
+	// tied is the condition matching the rows tied to the account
+	// ID (`%[1]s`) by another table, e.g. the vaulted comments of
+	// the person's events. The rows are anonymized before those of
+	// the other table.
+	tied string
+
+	// records are the tables whose rows are stored as JSON objects
+	// in the `record` column (`jira_quarantine`), matched on the
+	// keys of their columns.
+	records []personDataTable
+
+	// sealed is true if the comments of the rows are encrypted with
+	// the comment vault (see `SetCommentVault`): they are decrypted
+	// to be exported and sealed again once anonymized if the store
+	// has the key.
+	sealed bool
+
+	// timeColumn is the time of the rows, to slice the export, empty
+	// if the rows are exported whatever the slice.
+	timeColumn string
+	order      string
+
+	// deleted is true if the rows are deleted by `ErasePersonData`
+	// instead of being anonymized.
+	deleted bool
+}
+
+// personDataTables are the tables referencing persons, the custom
+// columns of "user" fields being added by `personDataTables`.
+var personDataTables = []personDataTable{
+	{table: "jira_users", users: []personColumns{{account: "account_id"}}, order: "account_id", deleted: true},
+	{table: "team_memberships", users: []personColumns{{name: "person"}}, order: "id"},
+	{
+		table:       "jira_issues_states",
+		users:       []personColumns{{"issue_assignee_account_id", "issue_assignee"}},
+		textColumns: []string{"issue_description"},
+		timeColumn:  "issue_updated_at",
+		order:       "issue_key",
+	},
+	{
+		table:      "jira_comment_vault",
+		users:      []personColumns{{name: "event_author"}},
+		tied:       "dedup_key IN (SELECT dedup_key FROM jira_issues_events WHERE event_author_account_id = %[1]s)",
+		sealed:     true,
+		timeColumn: "event_time",
+		order:      "event_time, dedup_key",
+	},
+	{
+		table: "jira_issues_events",
+		users: []personColumns{
+			{"event_author_account_id", "event_author"},
+			{"assignee_change_from_account_id", "assignee_change_from"},
+			{"assignee_change_to_account_id", "assignee_change_to"},
+			{"issue_assignee_account_id", "issue_assignee"},
+		},
+		textColumns: []string{"comment_body", "issue_description"},
+		timeColumn:  "event_time",
+		order:       "event_time, id",
+	},
+	{
+		table:       "jira_issue_comments",
+		users:       []personColumns{{"author_account_id", "author"}},
+		textColumns: []string{"body"},
+		timeColumn:  "created_at",
+		order:       "created_at, comment_id",
+	},
+	{
+		table:      "jira_assignee_intervals",
+		users:      []personColumns{{"assignee_account_id", "assignee"}},
+		timeColumn: "from_time",
+		order:      "from_time, issue_key",
+	},
+	{
+		table:       "jira_issue_description_revisions",
+		users:       []personColumns{{name: "author"}},
+		textColumns: []string{"description_from", "description_to"},
+		timeColumn:  "revised_at",
+		order:       "revised_at, id",
+	},
+	{table: "jira_issues_states_history", users: []personColumns{{name: "issue_assignee"}}, timeColumn: "valid_from", order: "valid_from, id"},
+	{table: "jira_quarantine", timeColumn: "quarantined_at", order: "quarantined_at, id", deleted: true},
+}
+
+// personDataTables returns `personDataTables` with the custom
+// columns of "user" fields (see `CustomColumn.User`), the quarantined
+// records being matched on the columns of their tables.
+func (s *PGStore) personDataTables() []personDataTable {
+	var custom []personColumns
+	for _, c := range s.customColumns {
+		if c.User {
+			custom = append(custom, personColumns{name: c.Name})
+		}
+	}
+	tables := make([]personDataTable, len(personDataTables))
+	byName := make(map[string]personDataTable)
+	for i, t := range personDataTables {
+		for _, ct := range customColumnsTables {
+			if t.table == ct {
+				t.users = append(append([]personColumns(nil), t.users...), custom...)
+			}
+		}
+		tables[i] = t
+		byName[t.table] = t
+	}
+	for i, t := range tables {
+		if t.table == "jira_quarantine" {
+			tables[i].records = []personDataTable{byName["jira_issues_states"], byName["jira_issues_events"]}
+		}
+	}
+	return tables
+}
+
+// PersonData is the data stored about a person, returned by
+// `GetPersonData` to answer a data subject access request.
+type PersonData struct {
+	AccountID string `json:"account_id"`
+
+	// Names are the names the person had in Jira (see
+	// `jira_user_names`) which no other account had, matched along
+	// with the account ID. AmbiguousNames are those other accounts
+	// had too: they are only matched on the rows recording the
+	// account ID.
+	Names          []string `json:"names"`
+	AmbiguousNames []string `json:"ambiguous_names,omitempty"`
+
+	// Since and Until are the slice of time of the export, nil if
+	// not bounded.
+	Since *time.Time `json:"since,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
+
+	// Tables are the rows referencing the person, by table, each one
+	// as a JSON object of its columns.
+	Tables map[string][]json.RawMessage `json:"tables"`
+}
+
+// GetPersonData returns the rows of the tables referencing the
+// person with the account ID: authored events and comments,
+// assignments, "user" custom fields, mentions in the texts (e.g.
+// comments, descriptions), quarantined records, and the person's
+// user and team memberships. If `since` or `until` is set, only the
+// rows whose time is within the slice (`until` excluded) are
+// returned, the users and team memberships being returned whatever
+// the slice.
+//
+// If the store has the key of the comment vault (see
+// `SetCommentVault`), the vaulted comments are returned decrypted
+// (`comment_body`), including those mentioning the person.
+func (s *PGStore) GetPersonData(accountID string, since, until *time.Time) (PersonData, error) {
+	pd := PersonData{AccountID: accountID, Since: since, Until: until, Tables: make(map[string][]json.RawMessage)}
+	names, unique, err := personNames(s.DB, accountID)
+	if err != nil {
+		return pd, err
+	}
+	pd.Names, pd.AmbiguousNames = unique, ambiguousNames(names, unique)
+	for _, t := range s.personDataTables() {
+		var args sqlArgs
+		where := t.where(&args, accountID, unique, nil)
+		if t.sealed && s.commentVault != nil {
+			mentioned, err := s.vaultedMentions(s.DB, accountID, unique)
+			if err != nil {
+				return pd, fmt.Errorf("error reading `%s`: %s", t.table, err)
+			}
+			where = fmt.Sprintf("(%s OR dedup_key = ANY(%s))", where, args.add(pq.Array(keys(mentioned))))
+		}
+		if t.timeColumn != "" && since != nil {
+			where += fmt.Sprintf(" AND %s >= %s", t.timeColumn, args.add(*since))
+		}
+		if t.timeColumn != "" && until != nil {
+			where += fmt.Sprintf(" AND %s < %s", t.timeColumn, args.add(*until))
+		}
+		rows, err := s.Query(fmt.Sprintf(`SELECT row_to_json(t)::TEXT FROM %s t WHERE %s ORDER BY %s;`, t.table, where, t.order), args...)
+		if err != nil {
+			return pd, fmt.Errorf("error reading `%s`: %s", t.table, err)
+		}
+		records := []json.RawMessage{}
+		for rows.Next() {
+			var r string
+			if err = rows.Scan(&r); err != nil {
+				rows.Close()
+				return pd, err
+			}
+			records = append(records, json.RawMessage(r))
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return pd, err
+		}
+		if t.sealed && s.commentVault != nil {
+			for i, r := range records {
+				if records[i], err = s.openVaultRecord(r); err != nil {
+					return pd, err
+				}
+			}
+		}
+		pd.Tables[t.table] = records
+	}
+	return pd, nil
+}
+
+// ErasePersonData anonymizes the rows of the tables referencing the
+// person with the account ID, as returned by `GetPersonData`: the
+// account ID and names are replaced with a random pseudonym, which
+// is returned, in the columns and the mentions of the texts, and
+// the user and the quarantined records are deleted. The rows of the
+// person stay consistent with each other, e.g. to compute the
+// metrics of the team. The operations are performed atomically using
+// a DB transaction.
+//
+// The records of the issues are rewritten by their next sync: the
+// person must be erased from Jira too (e.g. by closing the Atlassian
+// account, which anonymizes it). The mentions in the comments
+// encrypted in `jira_comment_vault` are only rewritten if the store
+// has the key (see `SetCommentVault`).
+func (s *PGStore) ErasePersonData(accountID string) (pseudonym string, err error) {
+	b := make([]byte, 8)
+	if _, err = rand.Read(b); err != nil {
+		return "", err
+	}
+	pseudonym = "erased-" + hex.EncodeToString(b)
+
+	tx, err := s.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	_, unique, err := personNames(tx, accountID)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range s.personDataTables() {
+		if err = t.erase(tx, accountID, unique, pseudonym); err != nil {
+			return "", fmt.Errorf("error erasing from `%s`: %s", t.table, err)
+		}
+		if t.sealed && s.commentVault != nil {
+			if err = s.eraseVaultedMentions(tx, accountID, unique, pseudonym); err != nil {
+				return "", fmt.Errorf("error erasing from `%s`: %s", t.table, err)
+			}
+		}
+	}
+	return pseudonym, nil
+}
+
+// erase anonymizes the rows of the table referencing the person
+// within the transaction, or deletes them (see `deleted`).
+func (t personDataTable) erase(tx *sql.Tx, accountID string, names []string, pseudonym string) error {
+	if t.deleted {
+		var args sqlArgs
+		where := t.where(&args, accountID, names, nil)
+		_, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s;`, t.table, where), args...)
+		return err
+	}
+	var tiedNames []string
+	for _, u := range t.users {
+		var err error
+		switch {
+		case u.account != "" && u.name != "":
+			_, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = $1, %s = $1 WHERE %s = $2;`, t.table, u.account, u.name, u.account), pseudonym, accountID)
+		case u.account != "":
+			_, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s = $2;`, t.table, u.account, u.account), pseudonym, accountID)
+		default:
+			tiedNames = append(tiedNames, u.name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if t.tied != "" {
+		for _, c := range tiedNames {
+			if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s;`, t.table, c, fmt.Sprintf(t.tied, "$2")), pseudonym, accountID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, u := range t.users {
+		if u.name == "" || len(names) == 0 {
+			continue
+		}
+		where := fmt.Sprintf("%s = ANY($2)", u.name)
+		if u.account != "" {
+			where += fmt.Sprintf(" AND %s IS NULL", u.account)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s;`, t.table, u.name, where), pseudonym, pq.Array(names)); err != nil {
+			return err
+		}
+	}
+	for _, c := range t.textColumns {
+		for _, m := range mentionReplacements(accountID, names, pseudonym) {
+			if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = REPLACE(%s, $1, $2) WHERE %s LIKE $3;`, t.table, c, c, c), m[0], m[1], "%"+escapeLike(m[0])+"%"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// where returns the condition matching the rows of the table
+// referencing the person, adding its arguments to `args`. `column`
+// returns the expression of a column, the column itself if nil.
+func (t personDataTable) where(args *sqlArgs, accountID string, names []string, column func(c string) string) string {
+	if column == nil {
+		column = func(c string) string { return c }
+	}
+	var id, named, mentioned string
+	param := func(p *string, v interface{}) string {
+		if *p == "" {
+			*p = args.add(v)
+		}
+		return *p
+	}
+	var conditions []string
+	for _, u := range t.users {
+		if u.account != "" {
+			conditions = append(conditions, fmt.Sprintf("%s = %s", column(u.account), param(&id, accountID)))
+		}
+		if u.name == "" || len(names) == 0 {
+			continue
+		}
+		c := fmt.Sprintf("%s = ANY(%s)", column(u.name), param(&named, pq.Array(names)))
+		if u.account != "" {
+			c = fmt.Sprintf("(%s IS NULL AND %s)", column(u.account), c)
+		}
+		conditions = append(conditions, c)
+	}
+	if t.tied != "" {
+		conditions = append(conditions, fmt.Sprintf(t.tied, param(&id, accountID)))
+	}
+	for _, c := range t.textColumns {
+		var patterns []string
+		for _, m := range mentionReplacements(accountID, names, "") {
+			patterns = append(patterns, "%"+escapeLike(m[0])+"%")
+		}
+		conditions = append(conditions, fmt.Sprintf("%s LIKE ANY(%s)", column(c), param(&mentioned, pq.Array(patterns))))
+	}
+	for _, r := range t.records {
+		rc := r.where(args, accountID, names, func(c string) string { return "record->>'" + c + "'" })
+		conditions = append(conditions, fmt.Sprintf("(table_name = '%s' AND %s)", r.table, rc))
+	}
+	if len(conditions) == 0 {
+		return "FALSE"
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+// sqlArgs are the arguments of a query, numbered as they're added.
+type sqlArgs []interface{}
+
+// add adds the argument and returns its placeholder (e.g. "$2").
+func (a *sqlArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// querier is implemented by `*sql.DB` and `*sql.Tx`.
+type querier interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+}
+
+// personNames returns the names of the person with the account ID,
+// from `jira_users` and the events (see `jira_user_names`), and
+// those which no other account had.
+func personNames(q querier, accountID string) (names, unique []string, err error) {
+	rows, err := q.Query(`
+	WITH user_names AS (
+		SELECT name, account_id FROM jira_users
+		UNION
+		SELECT name, account_id FROM jira_user_names
+	)
+	SELECT name, COUNT(DISTINCT account_id) = 1
+	FROM user_names
+	WHERE name IN (SELECT name FROM user_names WHERE account_id = $1)
+	GROUP BY name
+	ORDER BY name;
+	`, accountID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var n string
+		var u bool
+		if err = rows.Scan(&n, &u); err != nil {
+			return nil, nil, err
+		}
+		names = append(names, n)
+		if u {
+			unique = append(unique, n)
+		}
+	}
+	return names, unique, rows.Err()
+}
+
+// ambiguousNames returns the names which are not unique.
+func ambiguousNames(names, unique []string) []string {
+	var ambiguous []string
+	for _, n := range names {
+		if !containsString(unique, n) {
+			ambiguous = append(ambiguous, n)
+		}
+	}
+	return ambiguous
+}
+
+// containsString returns true if `v` is one of `values`.
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// mentionReplacements returns the mentions of the person in the
+// texts, by account ID and by name, each with the mention of the
+// pseudonym replacing it.
+func mentionReplacements(accountID string, names []string, pseudonym string) [][2]string {
+	r := [][2]string{{mention("accountid:" + accountID), mention("accountid:" + pseudonym)}}
+	for _, n := range names {
+		r = append(r, [2]string{mention(n), mention(pseudonym)})
+	}
+	return r
+}
+
+// vaultedMentions returns the bodies of the comments of the vault
+// mentioning the person, decrypted, by dedup key. The store must have
+// the key of the vault.
+func (s *PGStore) vaultedMentions(q querier, accountID string, names []string) (map[string]string, error) {
+	rows, err := q.Query(`SELECT dedup_key, comment_body_encrypted FROM jira_comment_vault;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	mentioned := make(map[string]string)
+	for rows.Next() {
+		var k string
+		var sealed []byte
+		if err = rows.Scan(&k, &sealed); err != nil {
+			return nil, err
+		}
+		body, err := s.commentVault.Open(k, sealed)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range mentionReplacements(accountID, names, "") {
+			if strings.Contains(body, m[0]) {
+				mentioned[k] = body
+				break
+			}
+		}
+	}
+	return mentioned, rows.Err()
+}
+
+// eraseVaultedMentions replaces the mentions of the person in the
+// comments of the vault with the pseudonym, sealing them again.
+func (s *PGStore) eraseVaultedMentions(tx *sql.Tx, accountID string, names []string, pseudonym string) error {
+	mentioned, err := s.vaultedMentions(tx, accountID, names)
+	if err != nil {
+		return err
+	}
+	for k, body := range mentioned {
+		for _, m := range mentionReplacements(accountID, names, pseudonym) {
+			body = strings.Replace(body, m[0], m[1], -1)
+		}
+		sealed, err := s.commentVault.Seal(k, body)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(`UPDATE jira_comment_vault SET comment_body_encrypted = $1 WHERE dedup_key = $2;`, sealed, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openVaultRecord returns the record of `jira_comment_vault` with
+// its comment decrypted (`comment_body`) instead of
+// `comment_body_encrypted`.
+func (s *PGStore) openVaultRecord(r json.RawMessage) (json.RawMessage, error) {
+	var record map[string]interface{}
+	if err := json.Unmarshal(r, &record); err != nil {
+		return nil, err
+	}
+	k, _ := record["dedup_key"].(string)
+	encoded, _ := record["comment_body_encrypted"].(string)
+	sealed, err := hex.DecodeString(strings.TrimPrefix(encoded, `\x`))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected encrypted comment of `%s`: %s", k, err)
+	}
+	body, err := s.commentVault.Open(k, sealed)
+	if err != nil {
+		return nil, err
+	}
+	delete(record, "comment_body_encrypted")
+	record["comment_body"] = body
+	return json.Marshal(record)
+}
+
+// keys returns the keys of the map.
+func keys(m map[string]string) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+// mention returns the Jira wiki markup mentioning the user, e.g.
+// "[~alice]" or "[~accountid:5b10a2844c20165700ede21g]".
+func mention(user string) string {
+	return "[~" + user + "]"
+}
+
+// escapeLike escapes the wildcards of `LIKE` patterns in `s`.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -1,10 +1,14 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"sort"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	_ "github.com/lib/pq" // PG engine for database/sql
 )
@@ -13,22 +17,79 @@ import (
 // Postgres DB backend.
 type PGStore struct {
 	*sql.DB
+	throttle         *Throttle
+	columnComments   []ColumnComment
+	customColumns    []CustomColumn
+	batchSize        int
+	eventHandler     EventHandler
+	commentVault     *CommentVault
+	strictSchema     bool
+	searchConfig     string
+	normalizedFields bool
+	stateHistory     bool
+	quarantine       bool
+	runInfo          RunInfo
+
+	insertMutex      sync.Mutex
+	insertStrategy   string
+	poolerCompatible bool
+
+	issueLocks issueLocks
 }
 
 // NewPGStore returns a `PGStore` storing the specified DB.
 // The passed DB should already be open and ready to
 // receive queries.
 func NewPGStore(db *sql.DB) *PGStore {
-	return &PGStore{db}
+	return &PGStore{DB: db}
+}
+
+// SetThrottle sets the `Throttle` used to slow down the writes
+// performed by `ReplaceIssueStateAndEvents`.
+func (s *PGStore) SetThrottle(t *Throttle) {
+	s.throttle = t
 }
 
 // ReplaceIssueStateAndEvents replace the existing state and
 // events records for the specified issue key, then inserts
-// the new state and events records.
+// the new state and events records. Duplicate events (see
+// `IssueEvent.DedupKey`) are stored once.
+//
+// The events are inserted in event-time order, the events at the
+// same time in the passed order, so their IDs break the ties. The
+// concurrent writes of the same issue, including by a `Writer`, are
+// performed one after the other.
+//
+// Returns an error without writing anything if the state is not
+// valid (see `IssueState.Validate`).
 //
 // The operations are performed atomically using a DB transaction.
-func (s *PGStore) ReplaceIssueStateAndEvents(k string, is IssueState, ies []IssueEvent) (err error) {
+// If a statement exceeds the connection's `statement_timeout` or
+// `lock_timeout`, a `TimeoutError` is returned. If it failed because
+// the DB doesn't support `COPY` (see `InsertCopy`), it's performed
+// again with the fallback strategy.
+func (s *PGStore) ReplaceIssueStateAndEvents(k string, is IssueState, ies []IssueEvent) error {
+	if err := is.Validate(); err != nil {
+		return err
+	}
+	defer s.issueLocks.lock(k)()
+	err := s.replaceIssueStateAndEvents(k, is, ies)
+	if err != nil && s.fallBackFromCopy(err) {
+		err = s.replaceIssueStateAndEvents(k, is, ies)
+	}
+	return err
+}
+
+func (s *PGStore) replaceIssueStateAndEvents(k string, is IssueState, ies []IssueEvent) (err error) {
+	ies = sortedEvents(uniqueEvents(ies))
+	if s.throttle != nil {
+		s.throttle.Wait(1 + len(is.Links) + len(ies))
+	}
+
 	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
 
 	defer func() {
 		switch err {
@@ -37,15 +98,52 @@ func (s *PGStore) ReplaceIssueStateAndEvents(k string, is IssueState, ies []Issu
 		default:
 			tx.Rollback()
 		}
+		if isPGTimeout(err) {
+			err = &TimeoutError{IssueKey: k, Err: err}
+		}
+		if err == nil {
+			s.handleEvents(ies)
+		}
 	}()
 
 	if err = dropAllForIssueKey(tx, k); err != nil {
 		return
 	}
-	if err = insertIssueState(tx, is); err != nil {
+	if err = s.deleteVaultedComments(tx, k); err != nil {
+		return
+	}
+	written, err := s.quarantineIssueState(tx, is)
+	if err != nil {
+		return
+	}
+	if written {
+		if err = insertIssueState(tx, is, s.customColumns); err != nil {
+			return
+		}
+	}
+	if err = insertIssueLinks(tx, is.Links); err != nil {
+		return
+	}
+	if written {
+		if err = s.replaceMultiValues(tx, is); err != nil {
+			return
+		}
+		if err = s.recordStateHistory(tx, is); err != nil {
+			return
+		}
+	}
+	if err = insertDescriptionRevisions(tx, is.DescriptionRevisions); err != nil {
+		return
+	}
+	deleted, err := s.replaceIssueComments(tx, is, time.Now())
+	if err != nil {
 		return
 	}
-	if err = insertIssueEvents(tx, ies, is); err != nil {
+	ies = append(ies, deleted...)
+	if ies, err = s.quarantineIssueEvents(tx, ies, is); err != nil {
+		return
+	}
+	if err = s.insertIssueEvents(tx, ies, is); err != nil {
 		return
 	}
 
@@ -61,7 +159,7 @@ func (s *PGStore) ReplaceIssueStateAndEvents(k string, is IssueState, ies []Issu
 // several goroutines, a crash or error may have processed newer issues
 // that the one it crashed for. `n` should thus be taken at least to
 // the number of goroutines fetching issues, even better a multiple.
-func (s *PGStore) GetRestartFromUpdatedAt(n int) *time.Time {
+func (s *PGStore) GetRestartFromUpdatedAt(n int) (*time.Time, error) {
 	var maxUpdatedAt time.Time
 	q := `
 	SELECT MIN(issue_updated_at)
@@ -71,22 +169,51 @@ func (s *PGStore) GetRestartFromUpdatedAt(n int) *time.Time {
 		ORDER BY issue_updated_at DESC LIMIT $1
 	) subq
 	`
-	err := s.QueryRow(q, n).Scan(&maxUpdatedAt)
-	switch {
-	case err != nil:
-		log.Fatal(err)
-	default:
-		return &maxUpdatedAt
+	if err := s.QueryRow(q, n).Scan(&maxUpdatedAt); err != nil {
+		return nil, err
 	}
-	return nil
+	return &maxUpdatedAt, nil
+}
+
+// GetIssueKeysWhere returns the keys of the issues whose state in
+// `jira_issues_states` matches the SQL predicate, e.g.
+// `issue_status IS NULL`. The predicate is run in a read-only
+// transaction, so it can't modify the DB.
+func (s *PGStore) GetIssueKeysWhere(predicate string) (keys []string, err error) {
+	tx, err := s.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(fmt.Sprintf(`SELECT issue_key FROM jira_issues_states WHERE (%s) ORDER BY issue_key;`, predicate))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k string
+		if err = rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
 }
 
 // CreateTables creates the `jira_issues_events` and
 // `jira_issues_states` tables used by this
-// application.
-func (s *PGStore) CreateTables() {
+// application, as well as the SQL functions and views
+// built on top of them (see `timeTravelFunctions` and
+// `epicViews`). The custom columns are added to the issue tables
+// (see `SetCustomColumns`). Comments
+// are added to the columns (see `SetColumnComments`) and the
+// changes of the schema are recorded as applied in
+// `schema_migrations` (see `SchemaVersion`).
+func (s *PGStore) CreateTables() error {
+	custom := customColumnsDefinition(s.customColumns)
 	queries := []string{
-		`CREATE TABLE "jira_issues_states" (
+		fmt.Sprintf(`CREATE TABLE "jira_issues_states" (
 			"id" SERIAL PRIMARY KEY NOT NULL,
 			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
 			"issue_created_at" TIMESTAMP NOT NULL,
@@ -94,6 +221,7 @@ func (s *PGStore) CreateTables() {
 			"issue_key" TEXT NOT NULL,
 			"issue_project" TEXT NOT NULL,
 			"issue_status" TEXT NOT NULL,
+			"issue_status_category" TEXT,
 			"issue_resolved_at" TIMESTAMP,
 			"issue_priority" TEXT NOT NULL,
 			"issue_summary" TEXT NOT NULL,
@@ -101,17 +229,30 @@ func (s *PGStore) CreateTables() {
 			"issue_type" TEXT NOT NULL,
 			"issue_labels" TEXT,
 			"issue_assignee" TEXT,
-			"issue_developer_backend" TEXT,
-			"issue_developer_frontend" TEXT,
-			"issue_reviewer" TEXT,
-			"issue_product_owner" TEXT,
-			"issue_bug_cause" TEXT,
 			"issue_epic" TEXT,
-			"issue_tribe" TEXT,
 			"issue_components" TEXT,
-			"issue_fix_versions" TEXT
-		);`,
-		`CREATE TABLE "jira_issues_events" (
+			"issue_fix_versions" TEXT,
+			"issue_sprints" TEXT,
+			"issue_epic_name" TEXT,
+			"issue_epic_color" TEXT,
+			"cloned_from_key" TEXT,
+			"moved_from_project" TEXT,
+			"issue_original_estimate_seconds" INTEGER,
+			"issue_remaining_estimate_seconds" INTEGER,
+			"issue_time_spent_seconds" INTEGER,
+			"issue_sprint_ids" TEXT,
+			"issue_source" TEXT,
+			"issue_severity_bucket" TEXT,
+			"issue_deleted_at" TIMESTAMP,
+			"issue_assignee_account_id" TEXT,
+			"issue_estimate_seconds" INTEGER,
+			"issue_estimate_points" NUMERIC,
+			"issue_is_generated" BOOLEAN NOT NULL DEFAULT FALSE,
+			"issue_properties" JSONB,
+			"issue_description_html" TEXT,
+			"issue_changelog_truncated" BOOLEAN NOT NULL DEFAULT FALSE%s
+		);`, custom),
+		fmt.Sprintf(`CREATE TABLE "jira_issues_events" (
 			"id" serial primary key not null,
 			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
 			"event_time" TIMESTAMP NOT NULL,
@@ -129,47 +270,149 @@ func (s *PGStore) CreateTables() {
 			"issue_type" TEXT NOT NULL,
 			"issue_labels" TEXT,
 			"issue_assignee" TEXT,
-			"issue_developer_backend" TEXT,
-			"issue_developer_frontend" TEXT,
-			"issue_reviewer" TEXT,
-			"issue_product_owner" TEXT,
-			"issue_bug_cause" TEXT,
 			"issue_epic" TEXT,
-			"issue_tribe" TEXT,
 			"issue_components" TEXT,
 			"issue_fix_versions" TEXT,
 			"comment_body" TEXT,
 			"status_change_from" TEXT,
 			"status_change_to" TEXT,
+			"status_change_reason" TEXT,
 			"assignee_change_from" TEXT,
-			"assignee_change_to" TEXT
-		);`,
+			"assignee_change_to" TEXT,
+			"worklog_started_at" TIMESTAMP,
+			"worklog_time_spent_seconds" INTEGER,
+			"author_excluded" BOOLEAN NOT NULL DEFAULT FALSE,
+			"sprint_id" INTEGER,
+			"sprint_name" TEXT,
+			"field_name" TEXT,
+			"field_change_from" TEXT,
+			"field_change_to" TEXT,
+			"dedup_key" TEXT,
+			"comment_length" INTEGER,
+			"issue_source" TEXT,
+			"issue_severity_bucket" TEXT,
+			"event_author_account_id" TEXT,
+			"assignee_change_from_account_id" TEXT,
+			"assignee_change_to_account_id" TEXT,
+			"issue_assignee_account_id" TEXT,
+			"comment_id" TEXT%s
+		);`, custom),
+		`CREATE UNIQUE INDEX "jira_issues_states_issue_key_idx" ON "jira_issues_states" ("issue_key");`,
+		`CREATE UNIQUE INDEX "jira_issues_events_dedup_key_idx" ON "jira_issues_events" ("dedup_key");`,
 	}
-	err := s.exec(queries)
-	if err != nil {
-		log.Fatalln(fmt.Errorf("error in `Reset`: %s", err))
+	queries = append(queries, eventCountsIndexes...)
+	queries = append(queries, linksTables...)
+	queries = append(queries, metricsTables...)
+	queries = append(queries, teamsTables...)
+	queries = append(queries, watchersTables...)
+	queries = append(queries, syncRunsTables...)
+	queries = append(queries, descriptionRevisionsTables...)
+	queries = append(queries, sprintsTables...)
+	queries = append(queries, workflowsTables...)
+	queries = append(queries, commentVaultTables...)
+	queries = append(queries, fieldLineageTables...)
+	queries = append(queries, usersTables...)
+	queries = append(queries, issueCommentsTables...)
+	queries = append(queries, multiValuedTables...)
+	queries = append(queries, assigneeIntervalsTables...)
+	queries = append(queries, wipAgingTables...)
+	queries = append(queries, statusAliasesTables...)
+	queries = append(queries, metricsRefreshesTables...)
+	queries = append(queries, sprintReportsTables...)
+	queries = append(queries, webhookDeliveriesTables...)
+	queries = append(queries, stateHistoryTables...)
+	queries = append(queries, sprintGoalsTables...)
+	queries = append(queries, quarantineTables...)
+	queries = append(queries, syncLocksTables...)
+	queries = append(queries, timeTravelFunctions...)
+	queries = append(queries, epicViews...)
+	queries = append(queries, linksViews...)
+	queries = append(queries, hierarchyViews...)
+	queries = append(queries, usersViews...)
+	queries = append(queries, sprintReportsViews...)
+	queries = append(queries, commentQueries(s.columnComments)...)
+	queries = append(queries, s.strictSchemaQueries()...)
+	queries = append(queries, s.fullTextSearchQueries()...)
+	queries = append(queries, recordMigrationsQueries(SchemaVersion)...)
+	if err := s.exec(queries); err != nil {
+		return fmt.Errorf("error creating tables: %s", err)
 	}
+	return nil
 }
 
 // DropTables drops the tables used by this source
-// (`jira_issues_events` and `jira_issues_states`)
-func (s *PGStore) DropTables() {
+// (`jira_issues_events`, `jira_issues_states`,
+// `jira_issue_links`, `jira_issue_metrics`,
+// `jira_issue_status_times`, `jira_weekly_stats`, `team_memberships`,
+// `jira_issue_watchers_daily`, `sync_runs`, `sync_progress`,
+// `jira_issue_description_revisions`, `jira_boards`,
+// `jira_sprints`, `field_lineage`, `jira_users`,
+// `jira_issue_comments`, `jira_issue_labels`,
+// `jira_issue_components`, `jira_issue_fix_versions`,
+// `jira_assignee_intervals`, `jira_wip_aging`,
+// `jira_status_aliases`, `jira_metrics_refreshes`,
+// `jira_sprint_reports`, `jira_webhook_deliveries`,
+// `jira_issues_states_history`, `sprint_goal_results`,
+// `jira_quarantine`, `schema_migrations`...) and the
+// functions and views depending on them. The sync locks
+// (`sync_locks`) are kept, the run dropping the tables usually
+// holding one (see `LockSync`).
+func (s *PGStore) DropTables() error {
 	queries := []string{
-		`DROP TABLE IF EXISTS "jira_issues_states";`,
-		`DROP TABLE IF EXISTS "jira_issues_events";`,
+		`DROP VIEW IF EXISTS jira_epic_rollup;`,
+		`DROP VIEW IF EXISTS jira_issue_hierarchy;`,
+		`DROP VIEW IF EXISTS jira_issue_ancestors;`,
+		`DROP VIEW IF EXISTS jira_issue_key_aliases;`,
+		`DROP VIEW IF EXISTS jira_user_names;`,
+		`DROP VIEW IF EXISTS jira_sprint_reconciliation;`,
+		`DROP FUNCTION IF EXISTS jira_issues_as_of(TIMESTAMP);`,
+		`DROP TABLE IF EXISTS "jira_issues_states" CASCADE;`,
+		`DROP TABLE IF EXISTS "jira_issues_events" CASCADE;`,
+		`DROP TABLE IF EXISTS "jira_issue_links";`,
+		`DROP TABLE IF EXISTS "jira_issue_metrics";`,
+		`DROP TABLE IF EXISTS "jira_issue_status_times";`,
+		`DROP TABLE IF EXISTS "jira_weekly_stats";`,
+		`DROP TABLE IF EXISTS "team_memberships";`,
+		`DROP TABLE IF EXISTS "jira_issue_watchers_daily";`,
+		`DROP TABLE IF EXISTS "sync_runs";`,
+		`DROP TABLE IF EXISTS "sync_progress";`,
+		`DROP TABLE IF EXISTS "jira_issue_description_revisions";`,
+		`DROP TABLE IF EXISTS "jira_sprints";`,
+		`DROP TABLE IF EXISTS "jira_boards";`,
+		`DROP TABLE IF EXISTS "jira_project_statuses";`,
+		`DROP TABLE IF EXISTS "jira_project_workflows";`,
+		`DROP TABLE IF EXISTS "jira_workflow_transitions";`,
+		`DROP TABLE IF EXISTS "jira_comment_vault";`,
+		`DROP TABLE IF EXISTS "field_lineage";`,
+		`DROP TABLE IF EXISTS "jira_users";`,
+		`DROP TABLE IF EXISTS "jira_issue_comments";`,
+		`DROP TABLE IF EXISTS "jira_issue_labels";`,
+		`DROP TABLE IF EXISTS "jira_issue_components";`,
+		`DROP TABLE IF EXISTS "jira_issue_fix_versions";`,
+		`DROP TABLE IF EXISTS "jira_assignee_intervals";`,
+		`DROP TABLE IF EXISTS "jira_wip_aging";`,
+		`DROP TABLE IF EXISTS "jira_status_aliases";`,
+		`DROP TABLE IF EXISTS "jira_metrics_refreshes";`,
+		`DROP TABLE IF EXISTS "jira_sprint_reports";`,
+		`DROP TABLE IF EXISTS "jira_webhook_deliveries";`,
+		`DROP TABLE IF EXISTS "jira_issues_states_history";`,
+		`DROP TABLE IF EXISTS "sprint_goal_results";`,
+		`DROP TABLE IF EXISTS "jira_quarantine";`,
+		`DROP TABLE IF EXISTS "jira_schema_version";`,
+		`DROP TABLE IF EXISTS "schema_migrations";`,
 	}
-	err := s.exec(queries)
-	if err != nil {
-		log.Fatalln(fmt.Errorf("error in `Drop()`: %s", err))
+	if err := s.exec(queries); err != nil {
+		return fmt.Errorf("error dropping tables: %s", err)
 	}
+	return nil
 }
 
 // insertIssueEvents inserts the specified events in the store in
 // the passed transaction. The passed `IssueState` is used to enrich
-// the event records.
-func insertIssueEvents(tx *sql.Tx, ies []IssueEvent, is IssueState) (err error) {
+// the event records, including the custom columns.
+func (s *PGStore) insertIssueEvents(tx *sql.Tx, ies []IssueEvent, is IssueState) (err error) {
 	for _, ie := range ies {
-		if err = insertIssueEvent(tx, ie, is); err != nil {
+		if err = s.insertIssueEvent(tx, ie, is); err != nil {
 			return err
 		}
 	}
@@ -177,45 +420,117 @@ func insertIssueEvents(tx *sql.Tx, ies []IssueEvent, is IssueState) (err error)
 }
 
 // insertIssueEvent inserts an issue event in the store through
-// the specified transaction
-func insertIssueEvent(tx *sql.Tx, ie IssueEvent, is IssueState) (err error) {
-	query := `
-	INSERT INTO jira_issues_events (
-		event_time,
-		event_kind,
-		event_author,
-		comment_body,
-		status_change_from,
-		status_change_to,
-		assignee_change_from,
-		assignee_change_to,
-		issue_key,
-		issue_created_at,
-		issue_updated_at,
-		issue_project,
-		issue_status,
-		issue_resolved_at,
-		issue_priority,
-		issue_summary,
-		issue_description,
-		issue_type,
-		issue_labels,
-		issue_assignee,
-		issue_developer_backend,
-		issue_developer_frontend,
-		issue_reviewer,
-		issue_product_owner,
-		issue_bug_cause,
-		issue_epic,
-		issue_tribe,
-		issue_components,
-		issue_fix_versions
-	)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29);
-	`
+// the specified transaction, and its comment body in the vault if
+// the store has one (see `SetCommentVault`).
+//
+// Returns an error if the event's kind is not valid (see
+// `EventKinds()`).
+func (s *PGStore) insertIssueEvent(tx *sql.Tx, ie IssueEvent, is IssueState) (err error) {
+	if !ie.EventKind.IsValid() {
+		return fmt.Errorf("invalid kind `%s` for event of issue `%s`", ie.EventKind, ie.IssueKey)
+	}
+	columns := append(issueEventColumns, customColumnNames(s.customColumns)...)
+	values, vaultValues, err := s.issueEventRow(ie, is)
+	if err != nil {
+		return
+	}
+	query := strings.TrimSuffix(insertQuery("jira_issues_events", columns), ";") + " ON CONFLICT (dedup_key) DO NOTHING;"
+	if _, err = tx.Exec(query, values...); err != nil || vaultValues == nil {
+		return
+	}
+	query = strings.TrimSuffix(insertQuery("jira_comment_vault", commentVaultColumns), ";") + " ON CONFLICT (dedup_key) DO NOTHING;"
+	_, err = tx.Exec(query, vaultValues...)
+	return
+}
+
+// insertIssueState inserts a new `IssueState` record in the store within
+// the specified transaction, including the custom columns `cs`. The
+// existing record of the issue, if any, is updated instead (e.g. if
+// it was written by a concurrent transaction).
+func insertIssueState(tx *sql.Tx, is IssueState, cs []CustomColumn) (err error) {
+	columns := append(issueStateColumns, customColumnNames(cs)...)
+	values := append(issueStateValues(is), customColumnValues(cs, is)...)
+	_, err = tx.Exec(upsertQuery("jira_issues_states", "issue_key", columns), values...)
+	return
+}
 
-	_, err = tx.Exec(
-		query,
+// uniqueEvents returns the events without the duplicates (events
+// with the same `DedupKey`), keeping the first one.
+func uniqueEvents(ies []IssueEvent) []IssueEvent {
+	seen := make(map[string]bool, len(ies))
+	unique := make([]IssueEvent, 0, len(ies))
+	for _, ie := range ies {
+		k := ie.DedupKey()
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		unique = append(unique, ie)
+	}
+	return unique
+}
+
+// sortedEvents sorts the events by time, keeping the order of the
+// events at the same time, and returns them.
+func sortedEvents(ies []IssueEvent) []IssueEvent {
+	sort.Stable(IssueEventsByTime(ies))
+	return ies
+}
+
+// issueEventColumns are the columns of `jira_issues_events` filled
+// with `issueEventValues`.
+var issueEventColumns = []string{
+	"event_time",
+	"event_kind",
+	"event_author",
+	"comment_body",
+	"status_change_from",
+	"status_change_to",
+	"assignee_change_from",
+	"assignee_change_to",
+	"issue_key",
+	"issue_created_at",
+	"issue_updated_at",
+	"issue_project",
+	"issue_status",
+	"issue_resolved_at",
+	"issue_priority",
+	"issue_summary",
+	"issue_description",
+	"issue_type",
+	"issue_labels",
+	"issue_assignee",
+	"issue_epic",
+	"issue_components",
+	"issue_fix_versions",
+	"status_change_reason",
+	"worklog_started_at",
+	"worklog_time_spent_seconds",
+	"author_excluded",
+	"sprint_id",
+	"sprint_name",
+	"field_name",
+	"field_change_from",
+	"field_change_to",
+	"dedup_key",
+	"comment_length",
+	"issue_source",
+	"issue_severity_bucket",
+	"event_author_account_id",
+	"assignee_change_from_account_id",
+	"assignee_change_to_account_id",
+	"issue_assignee_account_id",
+	"comment_id",
+}
+
+// commentBodyColumn is the index of `comment_body` in
+// `issueEventColumns`.
+var commentBodyColumn = 3
+
+// issueEventValues returns the values of `issueEventColumns` for the
+// event, enriched with the issue's state.
+func issueEventValues(ie IssueEvent, is IssueState) []interface{} {
+	return []interface{}{
 		ie.EventTime,
 		ie.EventKind,
 		ie.EventAuthor,
@@ -236,50 +551,93 @@ func insertIssueEvent(tx *sql.Tx, ie IssueEvent, is IssueState) (err error) {
 		is.Type,
 		is.Labels,
 		is.Assignee,
-		is.DeveloperBackend,
-		is.DeveloperFrontend,
-		is.Reviewer,
-		is.ProductOwner,
-		is.BugCause,
 		is.Epic,
-		is.Tribe,
 		is.Components,
 		is.FixVersions,
-	)
-	return
+		ie.StatusChangeReason,
+		ie.WorklogStartedAt,
+		ie.WorklogTimeSpent,
+		ie.AuthorExcluded,
+		ie.SprintID,
+		ie.SprintName,
+		ie.FieldName,
+		ie.FieldChangeFrom,
+		ie.FieldChangeTo,
+		ie.DedupKey(),
+		eventCommentLength(ie),
+		is.Source,
+		is.SeverityBucket,
+		ie.EventAuthorAccountID,
+		ie.AssigneeChangeFromAccountID,
+		ie.AssigneeChangeToAccountID,
+		is.AssigneeAccountID,
+		ie.CommentID,
+	}
 }
 
-// insertIssueState inserts a new `IssueState` record in the store within
-// the specified transaction
-func insertIssueState(tx *sql.Tx, is IssueState) (err error) {
-	query := `
-	INSERT INTO jira_issues_states (
-		issue_created_at,
-		issue_updated_at,
-		issue_key,
-		issue_project,
-		issue_status,
-		issue_resolved_at,
-		issue_priority,
-		issue_summary,
-		issue_description,
-		issue_type,
-		issue_labels,
-		issue_assignee,
-		issue_developer_backend,
-		issue_developer_frontend,
-		issue_reviewer,
-		issue_product_owner,
-		issue_bug_cause,
-		issue_epic,
-		issue_tribe,
-		issue_components,
-		issue_fix_versions
-	)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21);
-	`
-	_, err = tx.Exec(
-		query,
+// eventCommentLength returns the length of the comment of the
+// event: its length before redaction if set, the length of its body
+// otherwise.
+func eventCommentLength(ie IssueEvent) *int {
+	if ie.CommentLength != nil {
+		return ie.CommentLength
+	}
+	return commentLength(ie.CommentBody)
+}
+
+// commentLength returns the number of characters of the comment
+// body, or nil if there is none.
+func commentLength(body *string) *int {
+	if body == nil {
+		return nil
+	}
+	n := utf8.RuneCountInString(*body)
+	return &n
+}
+
+// issueStateColumns are the columns of `jira_issues_states` filled
+// with `issueStateValues`.
+var issueStateColumns = []string{
+	"issue_created_at",
+	"issue_updated_at",
+	"issue_key",
+	"issue_project",
+	"issue_status",
+	"issue_resolved_at",
+	"issue_priority",
+	"issue_summary",
+	"issue_description",
+	"issue_type",
+	"issue_labels",
+	"issue_assignee",
+	"issue_epic",
+	"issue_components",
+	"issue_fix_versions",
+	"issue_status_category",
+	"issue_sprints",
+	"issue_epic_name",
+	"issue_epic_color",
+	"cloned_from_key",
+	"moved_from_project",
+	"issue_original_estimate_seconds",
+	"issue_remaining_estimate_seconds",
+	"issue_time_spent_seconds",
+	"issue_sprint_ids",
+	"issue_source",
+	"issue_severity_bucket",
+	"issue_assignee_account_id",
+	"issue_estimate_seconds",
+	"issue_estimate_points",
+	"issue_is_generated",
+	"issue_properties",
+	"issue_description_html",
+	"issue_changelog_truncated",
+}
+
+// issueStateValues returns the values of `issueStateColumns` for the
+// issue state.
+func issueStateValues(is IssueState) []interface{} {
+	return []interface{}{
 		is.CreatedAt,
 		is.UpdatedAt,
 		is.Key,
@@ -292,27 +650,117 @@ func insertIssueState(tx *sql.Tx, is IssueState) (err error) {
 		is.Type,
 		is.Labels,
 		is.Assignee,
-		is.DeveloperBackend,
-		is.DeveloperFrontend,
-		is.Reviewer,
-		is.ProductOwner,
-		is.BugCause,
 		is.Epic,
-		is.Tribe,
 		is.Components,
 		is.FixVersions,
-	)
+		is.StatusCategory,
+		is.Sprints,
+		is.EpicName,
+		is.EpicColor,
+		is.ClonedFromKey,
+		is.MovedFromProject,
+		is.OriginalEstimate,
+		is.RemainingEstimate,
+		is.TimeSpent,
+		is.SprintIDs,
+		is.Source,
+		is.SeverityBucket,
+		is.AssigneeAccountID,
+		is.EstimateSeconds,
+		is.EstimatePoints,
+		is.Generated,
+		is.Properties,
+		is.DescriptionHTML,
+		is.ChangelogTruncated,
+	}
+}
+
+// insertQuery returns the statement inserting a row in the table,
+// e.g. `INSERT INTO t (a, b) VALUES ($1, $2);`.
+func insertQuery(table string, columns []string) string {
+	placeholders := make([]string, len(columns))
+	for i := range columns {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+}
+
+// upsertQuery returns the statement inserting a row in the table,
+// or updating the row having the same value in the `key` column if
+// there's one (the column must have a unique index).
+func upsertQuery(table string, key string, columns []string) string {
+	var updates []string
+	for _, c := range columns {
+		if c != key {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", c, c))
+		}
+	}
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s;", strings.TrimSuffix(insertQuery(table, columns), ";"), key, strings.Join(updates, ", "))
+}
+
+// DeleteIssue deletes all the records of the issue (e.g. when it's
+// deleted in Jira): its state, events, links, description
+// revisions, comments, metrics (including the times in status),
+// watchers, vaulted comments, values of the multi-valued fields and
+// versions of its state.
+func (s *PGStore) DeleteIssue(issueKey string) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if err = dropAllForIssueKey(tx, issueKey); err != nil {
+		return
+	}
+	if err = s.deleteVaultedComments(tx, issueKey); err != nil {
+		return
+	}
+	if _, err = tx.Exec("DELETE FROM jira_issue_comments WHERE issue_key = $1;", issueKey); err != nil {
+		return
+	}
+	if err = s.deleteMultiValues(tx, issueKey); err != nil {
+		return
+	}
+	if err = s.deleteStateHistory(tx, issueKey); err != nil {
+		return
+	}
+	if _, err = tx.Exec("DELETE FROM jira_issue_metrics WHERE issue_key = $1;", issueKey); err != nil {
+		return
+	}
+	if _, err = tx.Exec("DELETE FROM jira_issue_status_times WHERE issue_key = $1;", issueKey); err != nil {
+		return
+	}
+	_, err = tx.Exec("DELETE FROM jira_issue_watchers_daily WHERE issue_key = $1;", issueKey)
 	return
 }
 
-// dropAllForIssueKey drops all records from `jira_issues_states` and
-// `jira_issues_events` that match the specified issue key.
+// dropAllForIssueKey drops all records from `jira_issues_states`,
+// `jira_issues_events`, `jira_issue_links` and
+// `jira_issue_description_revisions` that match the specified issue
+// key.
 func dropAllForIssueKey(tx *sql.Tx, issueKey string) (err error) {
-	_, err = tx.Exec("DELETE FROM jira_issues_events WHERE issue_key = '" + issueKey + "';")
+	_, err = tx.Exec("DELETE FROM jira_issues_events WHERE issue_key = $1;", issueKey)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec("DELETE FROM jira_issues_states WHERE issue_key = $1;", issueKey)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec("DELETE FROM jira_issue_links WHERE source_key = $1;", issueKey)
 	if err != nil {
 		return
 	}
-	_, err = tx.Exec("DELETE FROM jira_issues_states WHERE issue_key = '" + issueKey + "';")
+	_, err = tx.Exec("DELETE FROM jira_issue_description_revisions WHERE issue_key = $1;", issueKey)
 	return
 }
 
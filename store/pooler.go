@@ -0,0 +1,26 @@
+package store
+
+// WithPoolerCompatibility returns the connection string with the
+// `binary_parameters` option of the driver set, so the statements
+// with parameters are parsed, bound and executed in a single round
+// trip. Otherwise the driver prepares them in a round trip of their
+// own, and a pooler in transaction mode (e.g. pgbouncer with
+// `pool_mode = transaction`) may execute them on another server
+// connection than the one they were prepared on, failing
+// intermittently.
+func WithPoolerCompatibility(connStr string) string {
+	return withParam(connStr, "binary_parameters", "yes")
+}
+
+// SetPoolerCompatible makes the store avoid the features relying on
+// a session, which break behind a pooler in transaction mode (see
+// `WithPoolerCompatibility`): `InsertStatements` executes the
+// statements without preparing them, and the default insert
+// strategy is `InsertValues` since the pooler may not support
+// `COPY`. The store uses no other session state: the lock of
+// `LockSync` is a lease recorded in a table.
+func (s *PGStore) SetPoolerCompatible(c bool) {
+	s.insertMutex.Lock()
+	defer s.insertMutex.Unlock()
+	s.poolerCompatible = c
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// quarantineTables are the tables created with `CreateTables` to
+// keep the rows a `Writer` (or `ReplaceIssueStateAndEvents`) could
+// not insert because they violate a unique constraint, in quarantine mode only (see `SetQuarantine`).
+// Each row has the table and value of the violated constraint, the
+// sync run which wrote the batch (NULL outside of a sync) and the
+// rejected row as a JSON object of its columns, e.g. to list the
+// issues whose records were rejected by the last sync:
+//
+//	SELECT table_name, issue_key, reason, COUNT(*)
+//	FROM jira_quarantine
+//	WHERE sync_run_id = (SELECT MAX(id) FROM sync_runs)
+//	GROUP BY table_name, issue_key, reason;
+var quarantineTables = []string{
+	`CREATE TABLE IF NOT EXISTS "jira_quarantine" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"quarantined_at" TIMESTAMP NOT NULL DEFAULT NOW(),
+		"table_name" TEXT NOT NULL,
+		"conflict_key" TEXT NOT NULL,
+		"issue_key" TEXT NOT NULL,
+		"sync_run_id" INTEGER,
+		"reason" TEXT NOT NULL,
+		"record" JSONB NOT NULL
+	);`,
+	`CREATE INDEX IF NOT EXISTS "jira_quarantine_issue_key_idx" ON "jira_quarantine" ("issue_key");`,
+}
+
+// quarantineColumns are the columns of `jira_quarantine` filled by
+// `quarantineRows`.
+var quarantineColumns = []string{"table_name", "conflict_key", "issue_key", "sync_run_id", "reason", "record"}
+
+// The reasons of the rows quarantined by `quarantineRows`.
+const (
+	QuarantineDuplicate = "duplicate in batch"
+	QuarantineStored    = "already stored"
+)
+
+// SetQuarantine enables the quarantine mode: instead of failing the
+// whole batch (and retrying it forever), a `Writer` moves the issue
+// states and events violating the unique constraints of
+// `jira_issues_states` (`issue_key`) and `jira_issues_events`
+// (`dedup_key`) to `jira_quarantine`, and writes the others. A row
+// violates a constraint if a previous row of the batch has the same
+// value (`QuarantineDuplicate`), e.g. two requested keys returning
+// the same moved issue, or if a row not replaced by the batch does
+// (`QuarantineStored`).
+//
+// Single writes (`ReplaceIssueStateAndEvents`) are quarantined the
+// same way: the state of the issue if a row of the same key was
+// written meanwhile (e.g. by a concurrent transaction), instead of
+// being updated, and the events already stored, instead of being
+// ignored.
+//
+// The mode costs a query per table and batch or single write. Only
+// the events written are passed to the event handler (see
+// `SetEventHandler`).
+func (s *PGStore) SetQuarantine(quarantine bool) {
+	s.quarantine = quarantine
+}
+
+// quarantineRows moves the rows of the table violating the unique
+// constraint on `column` to `jira_quarantine` within the
+// transaction, recording the sync run if not 0, and returns the
+// others. It must be called once the rows replaced by the batch are
+// deleted.
+func (s *PGStore) quarantineRows(tx *sql.Tx, syncRunID int64, table string, columns []string, column string, rows [][]interface{}) ([][]interface{}, error) {
+	if !s.quarantine || len(rows) == 0 {
+		return rows, nil
+	}
+	ci, ki := columnIndex(columns, column), columnIndex(columns, "issue_key")
+	values := make([]string, len(rows))
+	for i, r := range rows {
+		values[i] = r[ci].(string)
+	}
+	stored := make(map[string]bool)
+	res, err := tx.Query(fmt.Sprintf(`SELECT %s FROM %s WHERE %s = ANY($1);`, column, table, column), pq.Array(values))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	for res.Next() {
+		var v string
+		if err = res.Scan(&v); err != nil {
+			return nil, err
+		}
+		stored[v] = true
+	}
+	if err = res.Err(); err != nil {
+		return nil, err
+	}
+
+	var runID *int64
+	if syncRunID != 0 {
+		runID = &syncRunID
+	}
+	var kept, quarantined [][]interface{}
+	seen := make(map[string]bool)
+	for i, r := range rows {
+		var reason string
+		switch v := values[i]; {
+		case seen[v]:
+			reason = QuarantineDuplicate
+		case stored[v]:
+			reason = QuarantineStored
+		}
+		seen[values[i]] = true
+		if reason == "" {
+			kept = append(kept, r)
+			continue
+		}
+		record := make(map[string]interface{}, len(columns))
+		for j, c := range columns {
+			record[c] = r[j]
+		}
+		b, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		quarantined = append(quarantined, []interface{}{table, values[i], r[ki], runID, reason, string(b)})
+	}
+	if err = s.insertRows(tx, "jira_quarantine", quarantineColumns, quarantined); err != nil {
+		return nil, err
+	}
+	return kept, nil
+}
+
+// quarantineIssueState moves the state of the issue to
+// `jira_quarantine` if it violates the unique constraint of
+// `jira_issues_states`, for `ReplaceIssueStateAndEvents`. Returns
+// false if it was quarantined.
+func (s *PGStore) quarantineIssueState(tx *sql.Tx, is IssueState) (bool, error) {
+	if !s.quarantine {
+		return true, nil
+	}
+	columns := append(issueStateColumns, customColumnNames(s.customColumns)...)
+	row := append(issueStateValues(is), customColumnValues(s.customColumns, is)...)
+	kept, err := s.quarantineRows(tx, 0, "jira_issues_states", columns, "issue_key", [][]interface{}{row})
+	return len(kept) == 1, err
+}
+
+// quarantineIssueEvents moves the events of the issue violating the
+// unique constraint of `jira_issues_events` to `jira_quarantine`, for
+// `ReplaceIssueStateAndEvents`, and returns the others. The events
+// must be unique (see `uniqueEvents`).
+func (s *PGStore) quarantineIssueEvents(tx *sql.Tx, ies []IssueEvent, is IssueState) ([]IssueEvent, error) {
+	if !s.quarantine || len(ies) == 0 {
+		return ies, nil
+	}
+	columns := append(issueEventColumns, customColumnNames(s.customColumns)...)
+	rows := make([][]interface{}, len(ies))
+	for i, ie := range ies {
+		row, _, err := s.issueEventRow(ie, is)
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = row
+	}
+	rows, err := s.quarantineRows(tx, 0, "jira_issues_events", columns, "dedup_key", rows)
+	if err != nil {
+		return nil, err
+	}
+	return writtenEvents(ies, rows, columns), nil
+}
+
+// writtenEvents returns the events whose row is in `rows`, the rows
+// of `jira_issues_events` written (e.g. once the quarantined ones are
+// removed).
+func writtenEvents(ies []IssueEvent, rows [][]interface{}, columns []string) []IssueEvent {
+	written := make(map[string]bool, len(rows))
+	ki := columnIndex(columns, "dedup_key")
+	for _, r := range rows {
+		written[r[ki].(string)] = true
+	}
+	var kept []IssueEvent
+	for _, ie := range ies {
+		if written[ie.DedupKey()] {
+			kept = append(kept, ie)
+		}
+	}
+	return kept
+}
+
+// columnIndex returns the index of the column in `columns`, -1 if
+// it's not one of them.
+func columnIndex(columns []string, column string) int {
+	for i, c := range columns {
+		if c == column {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,66 @@
+package store
+
+import "time"
+
+// ResolvedIssue is an issue resolved at a time, as returned by
+// `GetResolvedIssues`.
+type ResolvedIssue struct {
+	Key        string
+	ResolvedAt time.Time
+}
+
+// GetResolvedIssues returns the issues resolved in [from, to), not
+// deleted nor generated (see `IssueState.Generated`), sorted by
+// resolution time.
+func (s *PGStore) GetResolvedIssues(from, to time.Time) ([]ResolvedIssue, error) {
+	rows, err := s.Query(`
+	SELECT issue_key, issue_resolved_at
+	FROM jira_issues_states
+	WHERE issue_resolved_at >= $1 AND issue_resolved_at < $2
+	AND issue_deleted_at IS NULL AND NOT issue_is_generated
+	ORDER BY issue_resolved_at, issue_key;
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var issues []ResolvedIssue
+	for rows.Next() {
+		var ri ResolvedIssue
+		if err = rows.Scan(&ri.Key, &ri.ResolvedAt); err != nil {
+			return nil, err
+		}
+		issues = append(issues, ri)
+	}
+	return issues, rows.Err()
+}
+
+// GetBlockerResolutions returns the resolution time of each resolved
+// issue which blocked another issue (see `FieldBlockedBy`), by key.
+func (s *PGStore) GetBlockerResolutions() (map[string]time.Time, error) {
+	rows, err := s.Query(`
+	SELECT issue_key, issue_resolved_at
+	FROM jira_issues_states
+	WHERE issue_resolved_at IS NOT NULL
+	AND issue_key IN (
+		SELECT field_change_to FROM jira_issues_events
+		WHERE field_name = $1 AND field_change_to IS NOT NULL
+	);
+	`, FieldBlockedBy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resolutions := make(map[string]time.Time)
+	for rows.Next() {
+		var k string
+		var t time.Time
+		if err = rows.Scan(&k, &t); err != nil {
+			return nil, err
+		}
+		resolutions[k] = t
+	}
+	return resolutions, rows.Err()
+}
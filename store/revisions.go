@@ -0,0 +1,65 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DescriptionRevision is an edit of an issue's description, read
+// from the issue's changelog, to be stored in the DB. Rewrites of
+// the description after the work started are a signal of churn in
+// the requirements.
+type DescriptionRevision struct {
+	IssueKey  string
+	RevisedAt time.Time
+	Author    string
+
+	// From and To are the description before and after the edit.
+	// From is nil when the description was added, To when it was
+	// removed.
+	From *string
+	To   *string
+}
+
+// descriptionRevisionsTables are the tables created with
+// `CreateTables` to store the edits of the issues' descriptions.
+var descriptionRevisionsTables = []string{
+	`CREATE TABLE "jira_issue_description_revisions" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"issue_key" TEXT NOT NULL,
+		"revised_at" TIMESTAMP NOT NULL,
+		"author" TEXT NOT NULL,
+		"description_from" TEXT,
+		"description_to" TEXT
+	);`,
+}
+
+// descriptionRevisionColumns are the columns of
+// `jira_issue_description_revisions` filled with
+// `descriptionRevisionValues`.
+var descriptionRevisionColumns = []string{
+	"issue_key",
+	"revised_at",
+	"author",
+	"description_from",
+	"description_to",
+}
+
+// descriptionRevisionValues returns the values of
+// `descriptionRevisionColumns` for the revision.
+func descriptionRevisionValues(r DescriptionRevision) []interface{} {
+	return []interface{}{r.IssueKey, r.RevisedAt, r.Author, r.From, r.To}
+}
+
+// insertDescriptionRevisions inserts the passed revisions in the
+// store within the specified transaction.
+func insertDescriptionRevisions(tx *sql.Tx, revisions []DescriptionRevision) (err error) {
+	query := insertQuery("jira_issue_description_revisions", descriptionRevisionColumns)
+	for _, r := range revisions {
+		if _, err = tx.Exec(query, descriptionRevisionValues(r)...); err != nil {
+			return
+		}
+	}
+	return
+}
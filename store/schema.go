@@ -0,0 +1,662 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SchemaChange is a change of the schema of the store between two
+// versions of the application, with the statements migrating a
+// schema from the previous version.
+//
+// Statements must be idempotent (e.g. `ADD COLUMN IF NOT EXISTS`)
+// since schemas created before versioning are assumed to be at
+// version 1 while they may already include later changes.
+type SchemaChange struct {
+	Version     int
+	Description string
+	Statements  []string
+}
+
+// schemaChanges is the changelog of the schema. To change the
+// schema, update `CreateTables` and append a change with the
+// statements migrating existing schemas.
+var schemaChanges = []SchemaChange{
+	{
+		Version:     1,
+		Description: "Schema before versioning",
+	},
+	{
+		Version:     2,
+		Description: "Add the epic name and color to `jira_issues_states` and the `jira_epic_rollup` view",
+		Statements: append([]string{
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "issue_epic_name" TEXT, ADD COLUMN IF NOT EXISTS "issue_epic_color" TEXT;`,
+		}, epicViews...),
+	},
+	{
+		Version:     3,
+		Description: "Add `status_change_reason` to `jira_issues_events`",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_events" ADD COLUMN IF NOT EXISTS "status_change_reason" TEXT;`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "Add the `jira_issue_watchers_daily` table",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS "jira_issue_watchers_daily" (
+		"issue_key" TEXT NOT NULL,
+		"day" DATE NOT NULL,
+		"watchers" INTEGER NOT NULL,
+		"added" INTEGER NOT NULL DEFAULT 0,
+		"removed" INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY ("issue_key", "day")
+	);`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "Add `cloned_from_key` and `moved_from_project` to `jira_issues_states`",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "cloned_from_key" TEXT, ADD COLUMN IF NOT EXISTS "moved_from_project" TEXT;`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "Add the `sync_runs` table",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS "sync_runs" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"kind" TEXT NOT NULL,
+		"status" TEXT NOT NULL,
+		"started_at" TIMESTAMP NOT NULL,
+		"finished_at" TIMESTAMP,
+		"issues_count" INTEGER
+	);`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "Add the `jira_issue_description_revisions` table",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS "jira_issue_description_revisions" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"issue_key" TEXT NOT NULL,
+		"revised_at" TIMESTAMP NOT NULL,
+		"author" TEXT NOT NULL,
+		"description_from" TEXT,
+		"description_to" TEXT
+	);`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "Add the time-tracking columns to `jira_issues_states` and the worklog columns to `jira_issues_events`",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "issue_original_estimate_seconds" INTEGER, ADD COLUMN IF NOT EXISTS "issue_remaining_estimate_seconds" INTEGER, ADD COLUMN IF NOT EXISTS "issue_time_spent_seconds" INTEGER;`,
+			`ALTER TABLE "jira_issues_events" ADD COLUMN IF NOT EXISTS "worklog_started_at" TIMESTAMP, ADD COLUMN IF NOT EXISTS "worklog_time_spent_seconds" INTEGER;`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "Add `author_excluded` to `jira_issues_events`",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_events" ADD COLUMN IF NOT EXISTS "author_excluded" BOOLEAN NOT NULL DEFAULT FALSE;`,
+		},
+	},
+	{
+		Version:     10,
+		Description: "Add the `jira_boards` and `jira_sprints` tables, `issue_sprint_ids` to `jira_issues_states` and the sprint columns to `jira_issues_events`",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS "jira_boards" (
+		"id" INTEGER PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"name" TEXT NOT NULL,
+		"type" TEXT NOT NULL
+	);`,
+			`CREATE TABLE IF NOT EXISTS "jira_sprints" (
+		"id" INTEGER PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"board_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"state" TEXT NOT NULL,
+		"start_date" TIMESTAMP,
+		"end_date" TIMESTAMP,
+		"complete_date" TIMESTAMP
+	);`,
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "issue_sprint_ids" TEXT;`,
+			`ALTER TABLE "jira_issues_events" ADD COLUMN IF NOT EXISTS "sprint_id" INTEGER, ADD COLUMN IF NOT EXISTS "sprint_name" TEXT;`,
+		},
+	},
+	{
+		Version:     11,
+		Description: "Add the `sync_progress` table",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS "sync_progress" (
+		"sync_run_id" INTEGER NOT NULL,
+		"issue_key" TEXT NOT NULL,
+		PRIMARY KEY ("sync_run_id", "issue_key")
+	);`,
+		},
+	},
+	{
+		Version:     12,
+		Description: "Add the generic field change columns to `jira_issues_events`",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_events" ADD COLUMN IF NOT EXISTS "field_name" TEXT, ADD COLUMN IF NOT EXISTS "field_change_from" TEXT, ADD COLUMN IF NOT EXISTS "field_change_to" TEXT;`,
+		},
+	},
+	{
+		Version:     13,
+		Description: "Add the `jira_project_statuses`, `jira_project_workflows` and `jira_workflow_transitions` tables",
+		Statements: []string{
+			`CREATE TABLE IF NOT EXISTS "jira_project_statuses" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"project_key" TEXT NOT NULL,
+		"issue_type" TEXT NOT NULL,
+		"status_id" TEXT NOT NULL,
+		"status_name" TEXT NOT NULL,
+		"status_category" TEXT NOT NULL,
+		"valid_from" TIMESTAMP NOT NULL,
+		"valid_to" TIMESTAMP
+	);`,
+			`CREATE TABLE IF NOT EXISTS "jira_project_workflows" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"project_key" TEXT NOT NULL,
+		"issue_type" TEXT NOT NULL,
+		"workflow_scheme" TEXT NOT NULL,
+		"workflow" TEXT NOT NULL,
+		"valid_from" TIMESTAMP NOT NULL,
+		"valid_to" TIMESTAMP
+	);`,
+			`CREATE TABLE IF NOT EXISTS "jira_workflow_transitions" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"workflow" TEXT NOT NULL,
+		"transition_id" TEXT NOT NULL,
+		"name" TEXT NOT NULL,
+		"from_status" TEXT,
+		"to_status" TEXT NOT NULL,
+		"valid_from" TIMESTAMP NOT NULL,
+		"valid_to" TIMESTAMP
+	);`,
+		},
+	},
+	{
+		Version:     14,
+		Description: "Add unique indexes on the `issue_key` of `jira_issues_states` and the new `dedup_key` of `jira_issues_events`, deleting the duplicate states",
+		Statements: []string{
+			`DELETE FROM jira_issues_states a USING jira_issues_states b WHERE a.issue_key = b.issue_key AND a.id < b.id;`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "jira_issues_states_issue_key_idx" ON "jira_issues_states" ("issue_key");`,
+			`ALTER TABLE "jira_issues_events" ADD COLUMN IF NOT EXISTS "dedup_key" TEXT;`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "jira_issues_events_dedup_key_idx" ON "jira_issues_events" ("dedup_key");`,
+		},
+	},
+	{
+		Version:     15,
+		Description: "Add `comment_length` to `jira_issues_events` and the `jira_comment_vault` table",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_events" ADD COLUMN IF NOT EXISTS "comment_length" INTEGER;`,
+			`UPDATE jira_issues_events SET comment_length = char_length(comment_body) WHERE comment_body IS NOT NULL;`,
+			`CREATE TABLE IF NOT EXISTS "jira_comment_vault" (
+		"dedup_key" TEXT PRIMARY KEY NOT NULL,
+		"issue_key" TEXT NOT NULL,
+		"event_time" TIMESTAMP NOT NULL,
+		"event_author" TEXT NOT NULL,
+		"comment_body_encrypted" BYTEA NOT NULL
+	);`,
+			`REVOKE ALL ON "jira_comment_vault" FROM PUBLIC;`,
+		},
+	},
+	{
+		Version:     16,
+		Description: "Track the applied migrations in `schema_migrations` instead of `jira_schema_version`",
+		Statements: []string{
+			`DROP TABLE IF EXISTS "jira_schema_version";`,
+		},
+	},
+	{
+		Version:     17,
+		Description: "Add `issue_source` to the issue tables",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "issue_source" TEXT;`,
+			`ALTER TABLE "jira_issues_events" ADD COLUMN IF NOT EXISTS "issue_source" TEXT;`,
+		},
+	},
+	{
+		Version:     18,
+		Description: "Add the `jira_issue_ancestors` view (the hierarchy links are stored by the next syncs of the issues)",
+		Statements:  linksViews,
+	},
+	{
+		Version:     19,
+		Description: "Add indexes on the project and epic, time and kind of `jira_issues_events` to count the events",
+		Statements:  eventCountsIndexes,
+	},
+	{
+		Version:     20,
+		Description: "Add `reopenings_count` to `jira_issue_metrics` and the `jira_issue_status_times` table",
+		Statements: []string{
+			`ALTER TABLE "jira_issue_metrics" ADD COLUMN IF NOT EXISTS "reopenings_count" INTEGER NOT NULL DEFAULT 0;`,
+			`CREATE TABLE IF NOT EXISTS "jira_issue_status_times" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"issue_key" TEXT NOT NULL,
+		"issue_project" TEXT NOT NULL,
+		"status" TEXT NOT NULL,
+		"duration_seconds" BIGINT NOT NULL
+	);`,
+		},
+	},
+	{
+		Version:     21,
+		Description: "Add `issue_severity_bucket` to the issue tables",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "issue_severity_bucket" TEXT;`,
+			`ALTER TABLE "jira_issues_events" ADD COLUMN IF NOT EXISTS "issue_severity_bucket" TEXT;`,
+		},
+	},
+	{
+		Version:     22,
+		Description: "Add the `jira_issue_key_aliases` view (the previous keys are stored by the next syncs of the moved issues)",
+		Statements:  []string{keyAliasesView},
+	},
+	{
+		Version:     23,
+		Description: "Add the `field_lineage` table (filled by the next sync)",
+		Statements:  fieldLineageTables,
+	},
+	{
+		Version:     24,
+		Description: "Add `issue_deleted_at` to `jira_issues_states`",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "issue_deleted_at" TIMESTAMP;`,
+		},
+	},
+	{
+		Version:     25,
+		Description: "Add the account IDs of the users to the issue tables, the `jira_users` table (filled by the next sync) and the `jira_user_names` view",
+		Statements: append(append([]string{
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "issue_assignee_account_id" TEXT;`,
+			`ALTER TABLE "jira_issues_events" ADD COLUMN IF NOT EXISTS "event_author_account_id" TEXT, ADD COLUMN IF NOT EXISTS "assignee_change_from_account_id" TEXT, ADD COLUMN IF NOT EXISTS "assignee_change_to_account_id" TEXT, ADD COLUMN IF NOT EXISTS "issue_assignee_account_id" TEXT;`,
+		}, usersTables...), usersViews...),
+	},
+	{
+		Version:     26,
+		Description: "Add `comment_id` to `jira_issues_events` and the `jira_issue_comments` table (filled by the next syncs of the issues)",
+		Statements: append([]string{
+			`ALTER TABLE "jira_issues_events" ADD COLUMN IF NOT EXISTS "comment_id" TEXT;`,
+		}, issueCommentsTables...),
+	},
+	{
+		Version:     27,
+		Description: "Add `first_assigned_at` and `time_to_first_assignment_seconds` to `jira_issue_metrics`",
+		Statements: []string{
+			`ALTER TABLE "jira_issue_metrics" ADD COLUMN IF NOT EXISTS "first_assigned_at" TIMESTAMP, ADD COLUMN IF NOT EXISTS "time_to_first_assignment_seconds" BIGINT;`,
+		},
+	},
+	{
+		Version:     28,
+		Description: "Add the `jira_issue_labels`, `jira_issue_components` and `jira_issue_fix_versions` tables (filled in normalized-fields mode by the next syncs of the issues)",
+		Statements:  multiValuedTables,
+	},
+	{
+		Version:     29,
+		Description: "Walk the \"Parent link\" links of Advanced Roadmaps in `jira_issue_ancestors` and add the `jira_issue_hierarchy` view (the links are stored by the next syncs of the issues)",
+		Statements:  append([]string{linksViews[0]}, hierarchyViews...),
+	},
+	{
+		Version:     30,
+		Description: "Add `issue_estimate_seconds` and `issue_estimate_points` to `jira_issues_states` (filled by the next syncs of the issues)",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "issue_estimate_seconds" INTEGER, ADD COLUMN IF NOT EXISTS "issue_estimate_points" NUMERIC;`,
+		},
+	},
+	{
+		Version:     31,
+		Description: "Add the build of the application (`app_version`, `app_commit`, `mapper_version` and `schema_version`) to `sync_runs`",
+		Statements: []string{
+			`ALTER TABLE "sync_runs" ADD COLUMN IF NOT EXISTS "app_version" TEXT, ADD COLUMN IF NOT EXISTS "app_commit" TEXT, ADD COLUMN IF NOT EXISTS "mapper_version" TEXT, ADD COLUMN IF NOT EXISTS "schema_version" INTEGER;`,
+		},
+	},
+	{
+		Version:     32,
+		Description: "Add `jira_assignee_intervals` (filled at the end of the next sync)",
+		Statements:  assigneeIntervalsTables,
+	},
+	{
+		Version:     33,
+		Description: "Add the business-time durations to `jira_issue_metrics` and `jira_issue_status_times` (filled by the next `analyze`)",
+		Statements: []string{
+			`ALTER TABLE "jira_issue_metrics" ADD COLUMN IF NOT EXISTS "lead_time_business_seconds" BIGINT, ADD COLUMN IF NOT EXISTS "cycle_time_business_seconds" BIGINT, ADD COLUMN IF NOT EXISTS "first_response_time_business_seconds" BIGINT, ADD COLUMN IF NOT EXISTS "time_to_first_assignment_business_seconds" BIGINT;`,
+			`ALTER TABLE "jira_issue_status_times" ADD COLUMN IF NOT EXISTS "business_duration_seconds" BIGINT;`,
+		},
+	},
+	{
+		Version:     34,
+		Description: "Add `issue_is_generated` to `jira_issues_states` (filled by the next syncs of the issues)",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "issue_is_generated" BOOLEAN NOT NULL DEFAULT FALSE;`,
+		},
+	},
+	{
+		Version:     35,
+		Description: "Add `issue_properties` to `jira_issues_states` (filled by the next syncs of the issues)",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "issue_properties" JSONB;`,
+		},
+	},
+	{
+		Version:     36,
+		Description: "Add `jira_wip_aging` (filled by `projections rebuild wip-aging`)",
+		Statements:  wipAgingTables,
+	},
+	{
+		Version:     37,
+		Description: "Add `jira_status_aliases` (filled by the next `analyze`)",
+		Statements:  statusAliasesTables,
+	},
+	{
+		Version:     38,
+		Description: "Add the consumption of Jira API (`api_calls`, `api_calls_by_endpoint`, `api_throttled`, `api_quota_used` and `api_calls_per_issue`) to `sync_runs`",
+		Statements: []string{
+			`ALTER TABLE "sync_runs" ADD COLUMN IF NOT EXISTS "api_calls" INTEGER, ADD COLUMN IF NOT EXISTS "api_calls_by_endpoint" JSONB, ADD COLUMN IF NOT EXISTS "api_throttled" INTEGER, ADD COLUMN IF NOT EXISTS "api_quota_used" REAL, ADD COLUMN IF NOT EXISTS "api_calls_per_issue" REAL;`,
+		},
+	},
+	{
+		Version:     39,
+		Description: "Add the descriptions and comments rendered in HTML (`issue_description_html` of `jira_issues_states` and `body_html` of `jira_issue_comments`, filled by the next syncs of the issues if `mapping.render_html` is set)",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "issue_description_html" TEXT;`,
+			`ALTER TABLE "jira_issue_comments" ADD COLUMN IF NOT EXISTS "body_html" TEXT;`,
+		},
+	},
+	{
+		Version:     40,
+		Description: "Add `jira_metrics_refreshes` and the index of the events by insertion time, to compute the metrics incrementally",
+		Statements:  metricsRefreshesTables,
+	},
+	{
+		Version:     41,
+		Description: "Add `issue_changelog_truncated` to `jira_issues_states` (filled by the next syncs of the issues if `mapping.changelog_truncation_threshold` is set)",
+		Statements: []string{
+			`ALTER TABLE "jira_issues_states" ADD COLUMN IF NOT EXISTS "issue_changelog_truncated" BOOLEAN NOT NULL DEFAULT FALSE;`,
+		},
+	},
+	{
+		Version:     42,
+		Description: "Add the blocked time to `jira_issue_metrics` (filled by the next full sync and `analyze`)",
+		Statements: []string{
+			`ALTER TABLE "jira_issue_metrics" ADD COLUMN IF NOT EXISTS "blocked_time_seconds" BIGINT NOT NULL DEFAULT 0, ADD COLUMN IF NOT EXISTS "blocked_time_business_seconds" BIGINT;`,
+		},
+	},
+	{
+		Version:     43,
+		Description: "Add `jira_sprint_reports` and `jira_sprint_reconciliation` (filled at the end of the next sync)",
+		Statements:  append([]string{sprintReportsTables[0]}, sprintReportsViews...),
+	},
+	{
+		Version:     44,
+		Description: "Add `jira_webhook_deliveries`, to record the events posted to the outbound webhooks",
+		Statements:  webhookDeliveriesTables,
+	},
+	{
+		Version:     45,
+		Description: "Add `jira_issues_states_history`, to keep the versions of the issue states (filled if `db.state_history` is set)",
+		Statements:  stateHistoryTables,
+	},
+	{
+		Version:     46,
+		Description: "Add the goal of the sprints to `jira_sprints` and `sprint_goal_results` (filled at the end of the next sync if `metrics.sprint_goal_query` is set)",
+		Statements: append([]string{
+			`ALTER TABLE "jira_sprints" ADD COLUMN IF NOT EXISTS "goal" TEXT;`,
+		}, sprintGoalsTables...),
+	},
+	{
+		Version:     47,
+		Description: "Add `first_time_right` to `jira_issue_metrics` (computed by the next `analyze` if `metrics.projects` lists review statuses)",
+		Statements: []string{
+			`ALTER TABLE "jira_issue_metrics" ADD COLUMN IF NOT EXISTS "first_time_right" BOOLEAN;`,
+		},
+	},
+	{
+		Version:     48,
+		Description: "Add `jira_quarantine`, to keep the rows violating the unique constraints of a batch (filled if `db.quarantine` is set)",
+		Statements:  quarantineTables,
+	},
+	{
+		Version:     49,
+		Description: "Add the filter of the sync runs (`filter_jql`) to `sync_runs`, the filtered runs not being restart points of the incremental syncs",
+		Statements: []string{
+			`ALTER TABLE "sync_runs" ADD COLUMN IF NOT EXISTS "filter_jql" TEXT;`,
+		},
+	},
+	{
+		Version:     50,
+		Description: "Add `sync_locks`, holding the sync locks as leases instead of advisory locks held by a transaction",
+		Statements:  syncLocksTables,
+	},
+	{
+		Version:     51,
+		Description: "Recreate `jira_issues_as_of`, falling back to the first change after the passed time or the current state for the status and assignee, and excluding the deleted issues",
+		Statements:  timeTravelFunctions,
+	},
+}
+
+// SchemaVersion is the version of the schema created by this
+// version of the application.
+var SchemaVersion = schemaChanges[len(schemaChanges)-1].Version
+
+// SchemaChanges returns the changes to apply to a schema at version
+// `from` to get to `SchemaVersion`.
+func SchemaChanges(from int) []SchemaChange {
+	var changes []SchemaChange
+	for _, c := range schemaChanges {
+		if c.Version > from {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// schemaMigrationsTable is the statement creating the table tracking
+// the changes applied to the schema.
+const schemaMigrationsTable = `CREATE TABLE IF NOT EXISTS "schema_migrations" (
+	"version" INTEGER PRIMARY KEY NOT NULL,
+	"description" TEXT NOT NULL,
+	"applied_at" TIMESTAMP NOT NULL DEFAULT statement_timestamp()
+);`
+
+// recordMigrationsQueries returns the statements recording the
+// changes up to version `to` as applied in `schema_migrations`. The
+// changes already recorded are left unchanged.
+func recordMigrationsQueries(to int) []string {
+	var values []string
+	for _, c := range schemaChanges {
+		if c.Version <= to {
+			values = append(values, fmt.Sprintf("(%d, '%s')", c.Version, strings.Replace(c.Description, "'", "''", -1)))
+		}
+	}
+	return []string{
+		schemaMigrationsTable,
+		fmt.Sprintf(`INSERT INTO schema_migrations (version, description) VALUES %s ON CONFLICT (version) DO NOTHING;`, strings.Join(values, ", ")),
+	}
+}
+
+// MigrationPlan returns the statements migrating a schema at version
+// `from` to `SchemaVersion`, including the recording of the applied
+// changes. Returns no statement if the schema is up to date.
+func MigrationPlan(from int) []string {
+	changes := SchemaChanges(from)
+	if len(changes) == 0 {
+		return nil
+	}
+	var queries []string
+	for _, c := range changes {
+		queries = append(queries, c.Statements...)
+	}
+	return append(queries, recordMigrationsQueries(SchemaVersion)...)
+}
+
+// RecordedSchemaVersion returns the version of the schema recorded
+// in the DB: the last change recorded in `schema_migrations`, or in
+// `jira_schema_version` before the migrations were tracked. Schemas
+// created before versioning are at version 1. Returns 0 if the
+// schema has not been created.
+func (s *PGStore) RecordedSchemaVersion() (int, error) {
+	var hasMigrations, hasVersion, hasStates bool
+	err := s.QueryRow(`
+	SELECT
+		to_regclass('schema_migrations') IS NOT NULL,
+		to_regclass('jira_schema_version') IS NOT NULL,
+		to_regclass('jira_issues_states') IS NOT NULL;
+	`).Scan(&hasMigrations, &hasVersion, &hasStates)
+	switch {
+	case err != nil:
+		return 0, err
+	case hasMigrations:
+		var version int
+		err = s.QueryRow(`SELECT COALESCE(MAX(version), 1) FROM schema_migrations;`).Scan(&version)
+		return version, err
+	case hasVersion:
+		var version int
+		err = s.QueryRow(`SELECT COALESCE(MAX(version), 1) FROM jira_schema_version;`).Scan(&version)
+		return version, err
+	case hasStates:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// MigrationStatus is the status of a change of the schema in the DB.
+type MigrationStatus struct {
+	SchemaChange
+	Applied bool
+
+	// AppliedAt is the time the change was applied, nil if it's
+	// pending or was applied before the migrations were tracked in
+	// `schema_migrations`.
+	AppliedAt *time.Time
+}
+
+// MigrationStatuses returns the status of each change of the schema.
+// All the changes are pending if the schema has not been created.
+func (s *PGStore) MigrationStatuses() ([]MigrationStatus, error) {
+	from, err := s.RecordedSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	var tracked bool
+	if err = s.QueryRow(`SELECT to_regclass('schema_migrations') IS NOT NULL;`).Scan(&tracked); err != nil {
+		return nil, err
+	}
+	appliedAt := make(map[int]time.Time)
+	if tracked {
+		rows, err := s.Query(`SELECT version, applied_at FROM schema_migrations;`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var version int
+			var at time.Time
+			if err = rows.Scan(&version, &at); err != nil {
+				return nil, err
+			}
+			appliedAt[version] = at
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	statuses := make([]MigrationStatus, len(schemaChanges))
+	for i, c := range schemaChanges {
+		statuses[i] = MigrationStatus{SchemaChange: c, Applied: c.Version <= from}
+		if at, ok := appliedAt[c.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies the changes of the schema which are pending, in
+// order, each one in its own transaction with its recording in
+// `schema_migrations`, so a failed migration can be fixed and
+// resumed. Creates the schema if it has not been created (see
+// `CreateTables`). The columns of the custom columns missing from
+// the tables are then added (see `CustomColumnsPlan`), and the
+// foreign keys of the strict schema and the columns of the full-text
+// search if enabled (see `StrictSchemaPlan` and `FullTextSearchPlan`).
+//
+// Returns the applied changes.
+func (s *PGStore) MigrateUp() ([]SchemaChange, error) {
+	from, err := s.RecordedSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if from == 0 {
+		return schemaChanges, s.CreateTables()
+	}
+
+	// The changes applied before the migrations were tracked are
+	// recorded first
+	if err = s.transaction(recordMigrationsQueries(from)); err != nil {
+		return nil, err
+	}
+	var applied []SchemaChange
+	for _, c := range SchemaChanges(from) {
+		queries := append(append([]string{}, c.Statements...), recordMigrationsQueries(c.Version)[1])
+		if err = s.transaction(queries); err != nil {
+			return applied, fmt.Errorf("error applying version %d (%s): %s", c.Version, c.Description, err)
+		}
+		applied = append(applied, c)
+	}
+	custom, err := s.CustomColumnsPlan()
+	if err != nil {
+		return applied, err
+	}
+	if err = s.transaction(custom); err != nil {
+		return applied, fmt.Errorf("error adding the custom columns: %s", err)
+	}
+	strict, err := s.StrictSchemaPlan()
+	if err != nil {
+		return applied, err
+	}
+	if err = s.transaction(strict); err != nil {
+		return applied, fmt.Errorf("error adding the foreign keys of the strict schema: %s", err)
+	}
+	search, err := s.FullTextSearchPlan()
+	if err != nil {
+		return applied, err
+	}
+	if err = s.transaction(search); err != nil {
+		return applied, fmt.Errorf("error adding the columns of the full-text search: %s", err)
+	}
+	return applied, nil
+}
+
+// transaction executes the statements in a transaction.
+func (s *PGStore) transaction(queries []string) (err error) {
+	if len(queries) == 0 {
+		return nil
+	}
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+	for _, q := range queries {
+		if _, err = tx.Exec(q); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// searchColumn is a `tsvector` column of the full-text search (see
+// `SetFullTextSearch`), generated from text columns of its table and
+// indexed with a GIN index.
+type searchColumn struct {
+	index  string
+	table  string
+	column string
+
+	// weights are the text columns indexed in the column, with
+	// their weight in the ranking of the results (`A` to `D`).
+	weights [][2]string
+}
+
+// searchColumns are the columns created in full-text search mode:
+// the summaries and descriptions of the issues, and the bodies of
+// their comments.
+var searchColumns = []searchColumn{
+	{"jira_issues_states_search_idx", "jira_issues_states", "issue_search", [][2]string{{"issue_summary", "A"}, {"issue_description", "B"}}},
+	{"jira_issue_comments_search_idx", "jira_issue_comments", "body_search", [][2]string{{"body", "B"}}},
+}
+
+// statements returns the statements adding the column, generated
+// with the text search configuration, and its index.
+func (c searchColumn) statements(config string) []string {
+	parts := make([]string, len(c.weights))
+	for i, w := range c.weights {
+		parts[i] = fmt.Sprintf(`setweight(to_tsvector('%s', COALESCE("%s", '')), '%s')`, strings.Replace(config, "'", "''", -1), w[0], w[1])
+	}
+	return []string{
+		fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN IF NOT EXISTS "%s" tsvector GENERATED ALWAYS AS (%s) STORED;`, c.table, c.column, strings.Join(parts, " || ")),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%s" ON "%s" USING GIN ("%s");`, c.index, c.table, c.column),
+	}
+}
+
+// SetFullTextSearch enables the full-text search mode with the
+// Postgres text search configuration (e.g. "english", or "simple"
+// to index the words as they are): `tsvector` columns indexed with
+// GIN indexes (see `searchColumns`) are created by `CreateTables`, or
+// added to an existing schema by `MigrateUp`, so the texts can be
+// searched with `Search`. Disabled if the configuration is empty.
+//
+// The columns are generated by Postgres (12 or later) and require
+// no change to the writes. The configuration of existing columns
+// is not changed: drop them to recreate them with another one.
+func (s *PGStore) SetFullTextSearch(config string) {
+	s.searchConfig = config
+}
+
+// fullTextSearchQueries returns the statements creating the columns
+// of the full-text search, or nil if the mode is disabled.
+func (s *PGStore) fullTextSearchQueries() []string {
+	if s.searchConfig == "" {
+		return nil
+	}
+	var queries []string
+	for _, c := range searchColumns {
+		queries = append(queries, c.statements(s.searchConfig)...)
+	}
+	return queries
+}
+
+// FullTextSearchPlan returns the statements adding the columns of
+// the full-text search missing from the DB, or nil if the mode is
+// disabled (see `SetFullTextSearch`). The columns are computed for
+// the existing records when added, which can take a while on large
+// tables.
+func (s *PGStore) FullTextSearchPlan() ([]string, error) {
+	if s.searchConfig == "" {
+		return nil, nil
+	}
+	existing := make(map[string]bool)
+	rows, err := s.Query(`SELECT indexname FROM pg_indexes WHERE indexname LIKE '%_search_idx';`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		existing[name] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	var queries []string
+	for _, c := range searchColumns {
+		if !existing[c.index] {
+			queries = append(queries, c.statements(s.searchConfig)...)
+		}
+	}
+	return queries, nil
+}
+
+// SearchResult is an issue or comment matching the query of
+// `Search`.
+type SearchResult struct {
+	IssueKey string
+	Summary  string
+
+	// CommentID is the ID of the matching comment, nil if the
+	// summary or description of the issue matches.
+	CommentID *string
+
+	// Rank is the relevance of the result, higher first.
+	Rank float64
+
+	// Headline is an excerpt of the matching text, with the
+	// matching words between `**`.
+	Headline string
+}
+
+// Search returns the issues whose summary or description match the
+// query, and the comments matching it (deleted comments excluded),
+// by decreasing relevance, `limit` at most.
+//
+// The query is parsed with `websearch_to_tsquery`, e.g.
+// `"payment failed" -refund` or `timeout or crash`. Fails if the
+// full-text search mode is disabled (see `SetFullTextSearch`).
+func (s *PGStore) Search(query string, limit int) ([]SearchResult, error) {
+	if s.searchConfig == "" {
+		return nil, errors.New("the full-text search is not enabled (see `db.full_text_search`)")
+	}
+	rows, err := s.Query(`
+	WITH q AS (SELECT websearch_to_tsquery($1::regconfig, $2) AS query)
+	SELECT issue_key, issue_summary, NULL::text, ts_rank(issue_search, q.query) AS rank,
+		ts_headline($1::regconfig, issue_summary || ' ' || COALESCE(issue_description, ''), q.query, 'StartSel=**, StopSel=**')
+	FROM jira_issues_states, q
+	WHERE issue_search @@ q.query
+	UNION ALL
+	SELECT c.issue_key, s.issue_summary, c.comment_id, ts_rank(c.body_search, q.query) AS rank,
+		ts_headline($1::regconfig, c.body, q.query, 'StartSel=**, StopSel=**')
+	FROM jira_issue_comments c
+	JOIN jira_issues_states s ON s.issue_key = c.issue_key, q
+	WHERE c.body_search @@ q.query AND c.deleted_at IS NULL
+	ORDER BY rank DESC
+	LIMIT $3;
+	`, s.searchConfig, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err = rows.Scan(&r.IssueKey, &r.Summary, &r.CommentID, &r.Rank, &r.Headline); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
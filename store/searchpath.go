@@ -0,0 +1,10 @@
+package store
+
+// WithSearchPath returns the connection string with the
+// `search_path` parameter set to the schema, so the tables, functions
+// and views are created and queried in it rather than in `public`,
+// e.g. to isolate the stores of tests sharing a DB (see package
+// `storetest`).
+func WithSearchPath(connStr, schema string) string {
+	return withParam(connStr, "search_path", schema)
+}
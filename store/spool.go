@@ -0,0 +1,262 @@
+package store
+
+import (
+	"bufio"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// Kinds of the writes recorded in a spool
+const (
+	spoolIssue      = "issue"
+	spoolWatchCount = "watch_count"
+	spoolDeletion   = "deletion"
+)
+
+// spoolRecord is a write recorded in a spool, one JSON object per
+// line.
+type spoolRecord struct {
+	Kind     string       `json:"kind"`
+	IssueKey string       `json:"issue_key"`
+	State    *IssueState  `json:"state,omitempty"`
+	Events   []IssueEvent `json:"events,omitempty"`
+	Time     time.Time    `json:"time,omitempty"`
+	Count    int          `json:"count,omitempty"`
+}
+
+// SpoolingStore is a `PGStore` buffering its writes to a local file
+// (the spool) while the DB is unreachable, so that a transient
+// outage of the DB doesn't lose the data received in real time
+// (e.g. by webhooks). The spooled writes are replayed, in order,
+// once the DB is reachable again (see `Flush`).
+//
+// Only the writes of issues (`ReplaceIssueStateAndEvents`), of
+// watch counts (`RecordWatchCount`) and the deletions of issues
+// (`DeleteIssue`) are spooled.
+type SpoolingStore struct {
+	*PGStore
+	path  string
+	mutex sync.Mutex
+}
+
+// NewSpoolingStore returns a `SpoolingStore` writing to `s` and
+// spooling to the file at `path`. Writes left in the spool by a
+// previous run are replayed by the first `Flush`.
+func NewSpoolingStore(s *PGStore, path string) *SpoolingStore {
+	return &SpoolingStore{PGStore: s, path: path}
+}
+
+// ReplaceIssueStateAndEvents replaces the records of the issue like
+// `PGStore.ReplaceIssueStateAndEvents`, or spools them if the DB is
+// unreachable. Writes are spooled while the spool can't be flushed,
+// so they are replayed in order.
+func (s *SpoolingStore) ReplaceIssueStateAndEvents(k string, is IssueState, ies []IssueEvent) error {
+	return s.write(spoolRecord{Kind: spoolIssue, IssueKey: k, State: &is, Events: ies})
+}
+
+// RecordWatchCount records the watch count like
+// `PGStore.RecordWatchCount`, or spools it if the DB is unreachable.
+func (s *SpoolingStore) RecordWatchCount(issueKey string, t time.Time, count int) error {
+	return s.write(spoolRecord{Kind: spoolWatchCount, IssueKey: issueKey, Time: t, Count: count})
+}
+
+// DeleteIssue deletes the records of the issue like
+// `PGStore.DeleteIssue`, or spools the deletion if the DB is
+// unreachable.
+func (s *SpoolingStore) DeleteIssue(issueKey string) error {
+	return s.write(spoolRecord{Kind: spoolDeletion, IssueKey: issueKey})
+}
+
+// Flush replays the spooled writes until the spool is empty or the
+// DB is unreachable, in which case the remaining writes are kept.
+// A spooled write failing for another reason is logged and dropped,
+// so it doesn't block the following ones.
+func (s *SpoolingStore) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.flush()
+}
+
+// FlushEvery calls `Flush` every `interval` until `stop` is closed.
+func (s *SpoolingStore) FlushEvery(interval time.Duration, stop chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if err := s.Flush(); err != nil && !isConnectionError(err) {
+				logging.Errorf("Error flushing spool `%s`: %s", s.path, err)
+			}
+		}
+	}
+}
+
+// write performs the write, after flushing the spool, or spools it
+// if the DB is unreachable.
+func (s *SpoolingStore) write(r spoolRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.flush()
+	if err == nil {
+		err = s.apply(r)
+	}
+	if !isConnectionError(err) {
+		return err
+	}
+	if err = s.append(r); err != nil {
+		return fmt.Errorf("DB unreachable and could not spool %s of issue `%s`: %s", r.Kind, r.IssueKey, err)
+	}
+	logging.Warnf("DB unreachable, %s of issue `%s` spooled to `%s`", r.Kind, r.IssueKey, s.path)
+	return nil
+}
+
+// apply performs the write recorded in `r` on the DB.
+func (s *SpoolingStore) apply(r spoolRecord) error {
+	switch r.Kind {
+	case spoolIssue:
+		return s.PGStore.ReplaceIssueStateAndEvents(r.IssueKey, *r.State, r.Events)
+	case spoolWatchCount:
+		return s.PGStore.RecordWatchCount(r.IssueKey, r.Time, r.Count)
+	case spoolDeletion:
+		return s.PGStore.DeleteIssue(r.IssueKey)
+	}
+	return fmt.Errorf("unknown kind `%s` of spooled write", r.Kind)
+}
+
+// flush replays the spooled writes, rewriting the spool with the
+// writes which couldn't be replayed. Must be called with the mutex
+// held.
+func (s *SpoolingStore) flush() error {
+	records, err := s.read()
+	if err != nil || len(records) == 0 {
+		return err
+	}
+	for i, r := range records {
+		err = s.apply(r)
+		if isConnectionError(err) && i == 0 {
+			return err
+		}
+		if isConnectionError(err) {
+			return s.rewrite(records[i:], err)
+		}
+		if err != nil {
+			logging.Errorf("Error replaying spooled %s of issue `%s`, dropped: %s", r.Kind, r.IssueKey, err)
+		}
+	}
+	logging.Infof("Replayed %d spooled write(s) from `%s`", len(records), s.path)
+	return os.Remove(s.path)
+}
+
+// read returns the records in the spool, or none if there is no
+// spool.
+func (s *SpoolingStore) read() ([]spoolRecord, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []spoolRecord
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var rec spoolRecord
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return nil, fmt.Errorf("invalid record in spool `%s`: %s", s.path, err)
+			}
+			records = append(records, rec)
+		}
+		// An incomplete last line is a write interrupted by a
+		// crash, it's ignored.
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// append writes the record at the end of the spool.
+func (s *SpoolingStore) append(r spoolRecord) error {
+	return appendRecord(s.path, r)
+}
+
+// appendRecord writes the record at the end of the file at `path`
+// and syncs it to the disk.
+func appendRecord(path string, r spoolRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rewrite replaces the spool with the records, atomically, and
+// returns `cause` (the error which stopped the flush).
+func (s *SpoolingStore) rewrite(records []spoolRecord, cause error) error {
+	tmp := s.path + ".tmp"
+	os.Remove(tmp)
+	for _, r := range records {
+		if err := appendRecord(tmp, r); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	return cause
+}
+
+// isConnectionError returns true if the error means the DB is
+// unreachable (e.g. connection refused, connection lost, server
+// shutting down), as opposed to an error of the statement.
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 is "Connection Exception", class 57P0x are the
+		// server being shut down or unavailable.
+		return pqErr.Code.Class() == "08" || pqErr.Code == "57P01" || pqErr.Code == "57P02" || pqErr.Code == "57P03"
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"github.com/lib/pq"
+)
+
+// sprintGoalsTables are the tables created with `CreateTables` to
+// store the evaluations of the sprint goals.
+//
+// A row is written for each closed sprint once evaluated: the issues
+// of its goal (the issues of the sprint selected by the sprint goal
+// query) and those resolved when the sprint was completed. `achieved`
+// is NULL if no issue was tagged to the goal. E.g. the goal
+// attainment of each board per quarter:
+//
+//	SELECT r.board_id, date_trunc('quarter', s.complete_date) AS quarter,
+//	  AVG(r.achieved::INTEGER) AS attainment
+//	FROM sprint_goal_results r
+//	JOIN jira_sprints s ON s.id = r.sprint_id
+//	WHERE r.achieved IS NOT NULL
+//	GROUP BY 1, 2;
+var sprintGoalsTables = []string{
+	`CREATE TABLE IF NOT EXISTS "sprint_goal_results" (
+		"sprint_id" INTEGER PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"board_id" INTEGER NOT NULL,
+		"goal" TEXT,
+		"goal_issues" INTEGER NOT NULL,
+		"completed_goal_issues" INTEGER NOT NULL,
+		"achieved" BOOLEAN
+	);`,
+}
+
+// GetSprintsToEvaluate returns the closed sprints whose goal was not
+// evaluated yet, or all the closed sprints if `all`, sorted by ID.
+func (s *PGStore) GetSprintsToEvaluate(all bool) ([]Sprint, error) {
+	rows, err := s.Query(`
+	SELECT s.id, s.board_id, s.name, s.state, s.start_date, s.end_date, s.complete_date, COALESCE(s.goal, '')
+	FROM jira_sprints s
+	WHERE s.state = 'closed'
+	AND ($1 OR NOT EXISTS (SELECT 1 FROM sprint_goal_results r WHERE r.sprint_id = s.id))
+	ORDER BY s.id;
+	`, all)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sprints []Sprint
+	for rows.Next() {
+		var sp Sprint
+		if err = rows.Scan(&sp.ID, &sp.BoardID, &sp.Name, &sp.State, &sp.StartDate, &sp.EndDate, &sp.CompleteDate, &sp.Goal); err != nil {
+			return nil, err
+		}
+		sprints = append(sprints, sp)
+	}
+	return sprints, rows.Err()
+}
+
+// ReplaceSprintGoalResult evaluates the goal of the closed sprint
+// from the stored states of the issues of its goal, and writes the
+// result to `sprint_goal_results`, replacing the previous one of the
+// sprint. The goal is achieved if all of its issues which were not
+// deleted were resolved when the sprint was completed.
+func (s *PGStore) ReplaceSprintGoalResult(sp Sprint, goalIssueKeys []string) error {
+	_, err := s.Exec(`
+	INSERT INTO sprint_goal_results (
+		sprint_id,
+		board_id,
+		goal,
+		goal_issues,
+		completed_goal_issues,
+		achieved
+	)
+	SELECT $1, $2, NULLIF($3, ''), r.goal_issues, r.completed_goal_issues,
+		CASE WHEN r.goal_issues = 0 THEN NULL ELSE r.completed_goal_issues = r.goal_issues END
+	FROM (
+		SELECT
+			COUNT(*) AS goal_issues,
+			COUNT(*) FILTER (WHERE issue_resolved_at <= $4) AS completed_goal_issues
+		FROM jira_issues_states
+		WHERE issue_key = ANY($5) AND issue_deleted_at IS NULL
+	) r
+	ON CONFLICT (sprint_id) DO UPDATE SET
+		inserted_at = statement_timestamp(),
+		board_id = EXCLUDED.board_id,
+		goal = EXCLUDED.goal,
+		goal_issues = EXCLUDED.goal_issues,
+		completed_goal_issues = EXCLUDED.completed_goal_issues,
+		achieved = EXCLUDED.achieved;
+	`, sp.ID, sp.BoardID, sp.Goal, sp.CompleteDate, pq.Array(goalIssueKeys))
+	return err
+}
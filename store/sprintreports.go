@@ -0,0 +1,234 @@
+package store
+
+import (
+	"time"
+)
+
+// SprintReport represents the figures of the sprint report of Jira
+// Agile for a closed sprint, to be stored in the DB and compared
+// with the figures computed from the records of the issues (see
+// `sprintReportsViews`).
+//
+// The points are the estimates of the board's estimation statistic,
+// expected to be the story points mapped to `issue_estimate_points`.
+type SprintReport struct {
+	SprintID int
+	BoardID  int
+
+	CompletedIssues    int
+	NotCompletedIssues int
+	PuntedIssues       int
+	AddedIssues        int
+
+	CompletedPoints    float64
+	NotCompletedPoints float64
+}
+
+// SprintReconciliation compares the figures of the sprint report of
+// Jira with those computed from the records of the issues, as read
+// from `jira_sprint_reconciliation`.
+type SprintReconciliation struct {
+	SprintID     int
+	SprintName   string
+	BoardID      int
+	CompleteDate *time.Time
+
+	JiraCompletedIssues         int
+	WarehouseCompletedIssues    int
+	JiraNotCompletedIssues      int
+	WarehouseNotCompletedIssues int
+	JiraAddedIssues             int
+	WarehouseAddedIssues        int
+	JiraCompletedPoints         float64
+	WarehouseCompletedPoints    float64
+}
+
+// sprintReportsTables are the tables created with `CreateTables` to
+// store the sprint reports of Jira Agile.
+var sprintReportsTables = []string{
+	`CREATE TABLE IF NOT EXISTS "jira_sprint_reports" (
+		"sprint_id" INTEGER PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"board_id" INTEGER NOT NULL,
+		"completed_issues" INTEGER NOT NULL,
+		"not_completed_issues" INTEGER NOT NULL,
+		"punted_issues" INTEGER NOT NULL,
+		"added_issues" INTEGER NOT NULL,
+		"completed_points" NUMERIC NOT NULL,
+		"not_completed_points" NUMERIC NOT NULL
+	);`,
+}
+
+// sprintReportsViews are the views created along with the tables to
+// compare the sprint reports with the records.
+//
+// ### jira_sprint_reconciliation
+//
+// Returns one row per sprint report, with the figures of Jira
+// (`jira_*`), those computed from the records of the issues
+// (`warehouse_*`) and their difference (`*_delta`, the warehouse
+// figure minus Jira's one). An issue of the sprint (see
+// `issue_sprint_ids`) is completed if it was resolved when the sprint
+// was completed, and added if it was added to the sprint after its
+// start (see the `sprint_added` events). Deleted and generated
+// issues are ignored. E.g. to list the sprints whose figures differ:
+//
+//	SELECT sprint_name, completed_issues_delta, completed_points_delta
+//	FROM jira_sprint_reconciliation
+//	WHERE completed_issues_delta <> 0 OR completed_points_delta <> 0;
+var sprintReportsViews = []string{
+	`CREATE OR REPLACE VIEW jira_sprint_reconciliation AS
+	WITH sprint_issues AS (
+		SELECT
+			s.id AS sprint_id,
+			i.issue_estimate_points AS points,
+			i.issue_resolved_at IS NOT NULL AND i.issue_resolved_at <= s.complete_date AS completed
+		FROM jira_sprints s
+		JOIN jira_issues_states i ON s.id::TEXT = ANY(string_to_array(i.issue_sprint_ids, ','))
+		WHERE i.issue_deleted_at IS NULL AND NOT i.issue_is_generated
+	), warehouse AS (
+		SELECT
+			sprint_id,
+			COUNT(*) FILTER (WHERE completed) AS completed_issues,
+			COUNT(*) FILTER (WHERE NOT completed) AS not_completed_issues,
+			COALESCE(SUM(points) FILTER (WHERE completed), 0) AS completed_points,
+			COALESCE(SUM(points) FILTER (WHERE NOT completed), 0) AS not_completed_points
+		FROM sprint_issues
+		GROUP BY sprint_id
+	), added AS (
+		SELECT e.sprint_id, COUNT(DISTINCT e.issue_key) AS added_issues
+		FROM jira_issues_events e
+		JOIN jira_sprints s ON s.id = e.sprint_id
+		WHERE e.event_kind = 'sprint_added' AND e.event_time > s.start_date AND e.event_time <= s.complete_date
+		GROUP BY e.sprint_id
+	)
+	SELECT
+		r.sprint_id,
+		s.name AS sprint_name,
+		r.board_id,
+		s.complete_date,
+		r.completed_issues AS jira_completed_issues,
+		COALESCE(w.completed_issues, 0) AS warehouse_completed_issues,
+		COALESCE(w.completed_issues, 0) - r.completed_issues AS completed_issues_delta,
+		r.not_completed_issues AS jira_not_completed_issues,
+		COALESCE(w.not_completed_issues, 0) AS warehouse_not_completed_issues,
+		COALESCE(w.not_completed_issues, 0) - r.not_completed_issues AS not_completed_issues_delta,
+		r.added_issues AS jira_added_issues,
+		COALESCE(a.added_issues, 0) AS warehouse_added_issues,
+		COALESCE(a.added_issues, 0) - r.added_issues AS added_issues_delta,
+		r.completed_points AS jira_completed_points,
+		COALESCE(w.completed_points, 0) AS warehouse_completed_points,
+		COALESCE(w.completed_points, 0) - r.completed_points AS completed_points_delta,
+		r.not_completed_points AS jira_not_completed_points,
+		COALESCE(w.not_completed_points, 0) AS warehouse_not_completed_points,
+		COALESCE(w.not_completed_points, 0) - r.not_completed_points AS not_completed_points_delta
+	FROM jira_sprint_reports r
+	LEFT JOIN jira_sprints s ON s.id = r.sprint_id
+	LEFT JOIN warehouse w ON w.sprint_id = r.sprint_id
+	LEFT JOIN added a ON a.sprint_id = r.sprint_id;`,
+}
+
+// GetClosedSprints returns the closed sprints, only those without
+// a sprint report if `unreportedOnly`, sorted by ID.
+func (s *PGStore) GetClosedSprints(unreportedOnly bool) ([]Sprint, error) {
+	rows, err := s.Query(`
+	SELECT s.id, s.board_id, s.name, s.state, s.start_date, s.end_date, s.complete_date
+	FROM jira_sprints s
+	WHERE s.state = 'closed'
+	AND (NOT $1 OR NOT EXISTS (SELECT 1 FROM jira_sprint_reports r WHERE r.sprint_id = s.id))
+	ORDER BY s.id;
+	`, unreportedOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sprints []Sprint
+	for rows.Next() {
+		var sp Sprint
+		if err = rows.Scan(&sp.ID, &sp.BoardID, &sp.Name, &sp.State, &sp.StartDate, &sp.EndDate, &sp.CompleteDate); err != nil {
+			return nil, err
+		}
+		sprints = append(sprints, sp)
+	}
+	return sprints, rows.Err()
+}
+
+// ReplaceSprintReport inserts the sprint report in
+// `jira_sprint_reports`, replacing the previous one of the sprint.
+func (s *PGStore) ReplaceSprintReport(r SprintReport) error {
+	_, err := s.Exec(`
+	INSERT INTO jira_sprint_reports (
+		sprint_id,
+		board_id,
+		completed_issues,
+		not_completed_issues,
+		punted_issues,
+		added_issues,
+		completed_points,
+		not_completed_points
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (sprint_id) DO UPDATE SET
+		inserted_at = statement_timestamp(),
+		board_id = EXCLUDED.board_id,
+		completed_issues = EXCLUDED.completed_issues,
+		not_completed_issues = EXCLUDED.not_completed_issues,
+		punted_issues = EXCLUDED.punted_issues,
+		added_issues = EXCLUDED.added_issues,
+		completed_points = EXCLUDED.completed_points,
+		not_completed_points = EXCLUDED.not_completed_points;
+	`, r.SprintID, r.BoardID, r.CompletedIssues, r.NotCompletedIssues, r.PuntedIssues, r.AddedIssues, r.CompletedPoints, r.NotCompletedPoints)
+	return err
+}
+
+// GetSprintReconciliations returns the comparisons of the sprint
+// reports with the records (see `jira_sprint_reconciliation`),
+// sorted by completion date.
+func (s *PGStore) GetSprintReconciliations() ([]SprintReconciliation, error) {
+	rows, err := s.Query(`
+	SELECT
+		sprint_id,
+		COALESCE(sprint_name, ''),
+		board_id,
+		complete_date,
+		jira_completed_issues,
+		warehouse_completed_issues,
+		jira_not_completed_issues,
+		warehouse_not_completed_issues,
+		jira_added_issues,
+		warehouse_added_issues,
+		jira_completed_points,
+		warehouse_completed_points
+	FROM jira_sprint_reconciliation
+	ORDER BY complete_date, sprint_id;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rs []SprintReconciliation
+	for rows.Next() {
+		var r SprintReconciliation
+		err = rows.Scan(
+			&r.SprintID,
+			&r.SprintName,
+			&r.BoardID,
+			&r.CompleteDate,
+			&r.JiraCompletedIssues,
+			&r.WarehouseCompletedIssues,
+			&r.JiraNotCompletedIssues,
+			&r.WarehouseNotCompletedIssues,
+			&r.JiraAddedIssues,
+			&r.WarehouseAddedIssues,
+			&r.JiraCompletedPoints,
+			&r.WarehouseCompletedPoints,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	return rs, rows.Err()
+}
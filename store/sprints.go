@@ -0,0 +1,139 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Board represents a Jira Agile board to be stored in the DB.
+type Board struct {
+	ID   int
+	Name string
+
+	// Type is the type of the board, "scrum" or "kanban".
+	Type string
+}
+
+// Sprint represents a sprint of a Jira Agile board to be stored in
+// the DB.
+type Sprint struct {
+	ID int
+
+	// BoardID is the ID of the board the sprint was created on.
+	// The sprint may be displayed on other boards too.
+	BoardID int
+
+	Name string
+
+	// State is the state of the sprint: "future", "active" or
+	// "closed".
+	State string
+
+	StartDate    *time.Time
+	EndDate      *time.Time
+	CompleteDate *time.Time
+
+	// Goal is the goal of the sprint, empty if it has none.
+	Goal string
+}
+
+// sprintsTables are the tables created with `CreateTables` to store
+// the boards and sprints of Jira Agile.
+//
+// The sprints of an issue are listed in the `issue_sprint_ids`
+// column of `jira_issues_states`, e.g. to compute the completed
+// issues of each sprint:
+//
+//	SELECT s.name, COUNT(*)
+//	FROM jira_sprints s
+//	JOIN jira_issues_states i ON s.id::TEXT = ANY(string_to_array(i.issue_sprint_ids, ','))
+//	WHERE i.issue_resolved_at <= s.complete_date
+//	GROUP BY s.name;
+var sprintsTables = []string{
+	`CREATE TABLE "jira_boards" (
+		"id" INTEGER PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"name" TEXT NOT NULL,
+		"type" TEXT NOT NULL
+	);`,
+	`CREATE TABLE "jira_sprints" (
+		"id" INTEGER PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"board_id" INTEGER NOT NULL,
+		"name" TEXT NOT NULL,
+		"state" TEXT NOT NULL,
+		"start_date" TIMESTAMP,
+		"end_date" TIMESTAMP,
+		"complete_date" TIMESTAMP,
+		"goal" TEXT
+	);`,
+}
+
+// ReplaceBoardsAndSprints replaces all records in `jira_boards` and
+// `jira_sprints` by the passed ones.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) ReplaceBoardsAndSprints(boards []Board, sprints []Sprint) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM jira_sprints;"); err != nil {
+		return
+	}
+	if _, err = tx.Exec("DELETE FROM jira_boards;"); err != nil {
+		return
+	}
+	for _, b := range boards {
+		if err = insertBoard(tx, b); err != nil {
+			return
+		}
+	}
+	for _, sp := range sprints {
+		if err = insertSprint(tx, sp); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func insertBoard(tx *sql.Tx, b Board) (err error) {
+	query := `
+	INSERT INTO jira_boards (
+		id,
+		name,
+		type
+	)
+	VALUES ($1, $2, $3);
+	`
+	_, err = tx.Exec(query, b.ID, b.Name, b.Type)
+	return
+}
+
+func insertSprint(tx *sql.Tx, sp Sprint) (err error) {
+	query := `
+	INSERT INTO jira_sprints (
+		id,
+		board_id,
+		name,
+		state,
+		start_date,
+		end_date,
+		complete_date,
+		goal
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''));
+	`
+	_, err = tx.Exec(query, sp.ID, sp.BoardID, sp.Name, sp.State, sp.StartDate, sp.EndDate, sp.CompleteDate, sp.Goal)
+	return
+}
@@ -0,0 +1,272 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLiteDriver is the name of the `database/sql` driver the DB of a
+// `SQLiteStore` must be opened with (e.g. registered by
+// `github.com/mattn/go-sqlite3`).
+const SQLiteDriver = "sqlite3"
+
+// SQLiteStore implements the application's `Store` with a SQLite DB
+// backend, to use the extractor locally without a Postgres instance.
+//
+// Only the issue tables (`jira_issues_states`, `jira_issues_events`
+// and `jira_issue_links`) are stored, with the same columns as in
+// Postgres: the derived tables (metrics, teams, sprints...) and the
+// features depending on them are only available with `PGStore`.
+type SQLiteStore struct {
+	*sql.DB
+	customColumns []CustomColumn
+}
+
+// NewSQLiteStore returns a `SQLiteStore` storing the specified DB,
+// opened with `SQLiteDriver`.
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{DB: db}
+}
+
+// SetCustomColumns sets the custom columns created by
+// `CreateTables` and filled by `ReplaceIssueStateAndEvents`.
+func (s *SQLiteStore) SetCustomColumns(cs []CustomColumn) {
+	s.customColumns = cs
+}
+
+// sqliteColumnTypes are the types of the columns of the issue tables
+// which are not `TEXT`. The `TIMESTAMP` and `BOOLEAN` declared types
+// are read back as `time.Time` and `bool` by the driver.
+var sqliteColumnTypes = map[string]string{
+	"event_time":                       "TIMESTAMP",
+	"issue_created_at":                 "TIMESTAMP",
+	"issue_updated_at":                 "TIMESTAMP",
+	"issue_resolved_at":                "TIMESTAMP",
+	"worklog_started_at":               "TIMESTAMP",
+	"worklog_time_spent_seconds":       "INTEGER",
+	"issue_original_estimate_seconds":  "INTEGER",
+	"issue_remaining_estimate_seconds": "INTEGER",
+	"issue_time_spent_seconds":         "INTEGER",
+	"issue_estimate_seconds":           "INTEGER",
+	"issue_estimate_points":            "NUMERIC",
+	"issue_is_generated":               "BOOLEAN",
+	"issue_changelog_truncated":        "BOOLEAN",
+	"author_excluded":                  "BOOLEAN",
+	"sprint_id":                        "INTEGER",
+	"comment_length":                   "INTEGER",
+}
+
+// sqliteTable is a table of the SQLite schema.
+type sqliteTable struct {
+	name    string
+	columns [][2]string // name and type
+	indexes []string
+}
+
+// sqliteTables returns the tables of the SQLite schema, with the
+// custom columns.
+func (s *SQLiteStore) sqliteTables() []sqliteTable {
+	columns := func(names []string) [][2]string {
+		cs := make([][2]string, 0, len(names)+len(s.customColumns))
+		for _, n := range names {
+			t, ok := sqliteColumnTypes[n]
+			if !ok {
+				t = "TEXT"
+			}
+			cs = append(cs, [2]string{n, t})
+		}
+		for _, c := range s.customColumns {
+			cs = append(cs, [2]string{c.Name, c.Type})
+		}
+		return cs
+	}
+	return []sqliteTable{
+		{
+			name:    "jira_issues_states",
+			columns: columns(issueStateColumns),
+			indexes: []string{`CREATE UNIQUE INDEX IF NOT EXISTS "jira_issues_states_issue_key_idx" ON "jira_issues_states" ("issue_key");`},
+		},
+		{
+			name:    "jira_issues_events",
+			columns: columns(issueEventColumns),
+			indexes: []string{`CREATE UNIQUE INDEX IF NOT EXISTS "jira_issues_events_dedup_key_idx" ON "jira_issues_events" ("dedup_key");`},
+		},
+		{
+			name: "jira_issue_links",
+			columns: [][2]string{
+				{"source_key", "TEXT"},
+				{"target_key", "TEXT"},
+				{"link_type", "TEXT"},
+				{"direction", "TEXT"},
+			},
+		},
+	}
+}
+
+// CreateTables creates the issue tables, if they don't exist.
+//
+// The columns are nullable, so that the columns added to the
+// existing tables by `MigrateUp` don't need a default value.
+func (s *SQLiteStore) CreateTables() error {
+	var queries []string
+	for _, t := range s.sqliteTables() {
+		var defs strings.Builder
+		for _, c := range t.columns {
+			fmt.Fprintf(&defs, ",\n\t\t\"%s\" %s", c[0], c[1])
+		}
+		queries = append(queries, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (
+		"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"inserted_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP%s
+	);`, t.name, defs.String()))
+		queries = append(queries, t.indexes...)
+	}
+	return s.exec(queries)
+}
+
+// DropTables drops the issue tables.
+func (s *SQLiteStore) DropTables() error {
+	var queries []string
+	for _, t := range s.sqliteTables() {
+		queries = append(queries, fmt.Sprintf(`DROP TABLE IF EXISTS "%s";`, t.name))
+	}
+	return s.exec(queries)
+}
+
+// MigrateUp creates the issue tables if they don't exist, and adds
+// the columns missing from the existing ones (e.g. after upgrading
+// the application or adding a custom field to the mapping). Unlike
+// the Postgres schema, the SQLite schema is not versioned.
+func (s *SQLiteStore) MigrateUp() error {
+	if err := s.CreateTables(); err != nil {
+		return err
+	}
+	for _, t := range s.sqliteTables() {
+		existing, err := s.columnNames(t.name)
+		if err != nil {
+			return err
+		}
+		for _, c := range t.columns {
+			if existing[c[0]] {
+				continue
+			}
+			if _, err = s.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s;`, t.name, c[0], c[1])); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// columnNames returns the names of the columns of the table.
+func (s *SQLiteStore) columnNames(table string) (map[string]bool, error) {
+	rows, err := s.Query(fmt.Sprintf(`SELECT name FROM pragma_table_info('%s');`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+	return names, rows.Err()
+}
+
+// ReplaceIssueStateAndEvents replaces the existing state, events
+// and links of the issue by the passed ones. Duplicate events (see
+// `IssueEvent.DedupKey`) are stored once.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *SQLiteStore) ReplaceIssueStateAndEvents(k string, is IssueState, ies []IssueEvent) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	for _, q := range []string{
+		`DELETE FROM jira_issues_events WHERE issue_key = ?;`,
+		`DELETE FROM jira_issues_states WHERE issue_key = ?;`,
+		`DELETE FROM jira_issue_links WHERE source_key = ?;`,
+	} {
+		if _, err = tx.Exec(q, k); err != nil {
+			return
+		}
+	}
+
+	custom := customColumnNames(s.customColumns)
+	_, err = tx.Exec(
+		sqliteInsertQuery("jira_issues_states", append(issueStateColumns, custom...)),
+		append(issueStateValues(is), customColumnValues(s.customColumns, is)...)...,
+	)
+	if err != nil {
+		return
+	}
+	for _, l := range is.Links {
+		_, err = tx.Exec(
+			`INSERT INTO jira_issue_links (source_key, target_key, link_type, direction) VALUES (?, ?, ?, ?);`,
+			l.SourceKey, l.TargetKey, l.LinkType, l.Direction,
+		)
+		if err != nil {
+			return
+		}
+	}
+	query := sqliteInsertQuery("jira_issues_events", append(issueEventColumns, custom...))
+	for _, ie := range uniqueEvents(ies) {
+		values := append(issueEventValues(ie, is), customColumnValues(s.customColumns, is)...)
+		if _, err = tx.Exec(query, values...); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// GetRestartFromUpdatedAt returns the `n`th value of
+// `issue_updated_at` from `jira_issues_states` in descending order
+// (see `PGStore.GetRestartFromUpdatedAt`).
+func (s *SQLiteStore) GetRestartFromUpdatedAt(n int) (*time.Time, error) {
+	var updatedAt time.Time
+	q := `
+	SELECT issue_updated_at
+	FROM (
+		SELECT issue_updated_at
+		FROM jira_issues_states
+		ORDER BY issue_updated_at DESC LIMIT ?
+	) subq
+	ORDER BY issue_updated_at LIMIT 1;
+	`
+	if err := s.QueryRow(q, n).Scan(&updatedAt); err != nil {
+		return nil, err
+	}
+	return &updatedAt, nil
+}
+
+// sqliteInsertQuery returns the statement inserting a row in the
+// table, with `?` placeholders.
+func sqliteInsertQuery(table string, columns []string) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", table, strings.Join(columns, ", "), placeholders)
+}
+
+// exec executes the passed SQL commands on the DB using `Exec`.
+func (s *SQLiteStore) exec(cmds []string) (err error) {
+	for _, c := range cmds {
+		if _, err = s.Exec(c); err != nil {
+			return
+		}
+	}
+	return
+}
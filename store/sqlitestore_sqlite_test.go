@@ -0,0 +1,93 @@
+//go:build sqlite
+// +build sqlite
+
+package store_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Runs against the SQLite engine, only built in with the `sqlite`
+// build tag (`make test-sqlite`).
+
+func openSQLiteStore(t *testing.T) (*store.SQLiteStore, *sql.DB) {
+	db, err := sql.Open(store.SQLiteDriver, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each connection has its own in-memory DB
+	db.SetMaxOpenConns(1)
+	s := store.NewSQLiteStore(db)
+	s.SetCustomColumns([]store.CustomColumn{{Name: "issue_team", Type: store.CustomColumnText}})
+	if err = s.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	return s, db
+}
+
+func TestSQLiteStore_ReplaceIssueStateAndEvents_engine(t *testing.T) {
+	s, db := openSQLiteStore(t)
+	defer db.Close()
+
+	updatedAt := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	is := withRequired(store.IssueState{
+		Key:          "PJ-1",
+		CreatedAt:    updatedAt.Add(-time.Hour),
+		UpdatedAt:    updatedAt,
+		CustomFields: map[string]interface{}{"issue_team": stringAddr("Payments")},
+		Links:        []store.IssueLink{{SourceKey: "PJ-1", TargetKey: "PJ-2", LinkType: "Blocks", Direction: store.LinkOutward}},
+	})
+	ie := store.IssueEvent{EventTime: updatedAt, EventKind: store.EventCreated, EventAuthor: "al", IssueKey: "PJ-1"}
+	// Replaced, and the duplicate event stored once
+	for i := 0; i < 2; i++ {
+		if err := s.ReplaceIssueStateAndEvents("PJ-1", is, []store.IssueEvent{ie, ie}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for table, expected := range map[string]int{"jira_issues_states": 1, "jira_issues_events": 1, "jira_issue_links": 1} {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != expected {
+			t.Errorf("expected %d rows in %s, got %d", expected, table, n)
+		}
+	}
+	var team string
+	if err := db.QueryRow(`SELECT issue_team FROM jira_issues_events`).Scan(&team); err != nil || team != "Payments" {
+		t.Errorf("expected the custom column of the event to be `Payments`, got `%s` (%v)", team, err)
+	}
+
+	restart, err := s.GetRestartFromUpdatedAt(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restart.Equal(updatedAt) {
+		t.Errorf("expected to restart from %s, got %s", updatedAt, restart)
+	}
+}
+
+func TestSQLiteStore_MigrateUp_engine(t *testing.T) {
+	s, db := openSQLiteStore(t)
+	defer db.Close()
+
+	s.SetCustomColumns([]store.CustomColumn{
+		{Name: "issue_team", Type: store.CustomColumnText},
+		{Name: "issue_story_points", Type: store.CustomColumnNumeric},
+	})
+	if err := s.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"jira_issues_states", "jira_issues_events"} {
+		if _, err := db.Exec(`SELECT issue_story_points FROM ` + table); err != nil {
+			t.Errorf("expected `issue_story_points` to be added to %s, got %s", table, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// stateHistoryColumns are the columns of `jira_issues_states` kept in
+// `jira_issues_states_history`. A new version of an issue is recorded
+// when one of them changes.
+var stateHistoryColumns = []string{
+	"issue_project",
+	"issue_status",
+	"issue_status_category",
+	"issue_resolved_at",
+	"issue_priority",
+	"issue_summary",
+	"issue_type",
+	"issue_labels",
+	"issue_assignee",
+	"issue_epic",
+	"issue_sprints",
+	"issue_components",
+	"issue_fix_versions",
+}
+
+// stateHistoryTables are the tables created with `CreateTables` to
+// keep the versions of the issue states, filled in state-history mode
+// only (see `SetStateHistory`). Each row is a version of the columns
+// of `stateHistoryColumns`, valid from `valid_from` (included) to
+// `valid_to` (excluded, NULL for the current version), so the state
+// of the issues at a time is a simple join, e.g. to count the issues
+// per status at the beginning of July 2018:
+//
+//	SELECT issue_status, COUNT(*)
+//	FROM jira_issues_states_history
+//	WHERE valid_from <= '2018-07-01'
+//	AND (valid_to IS NULL OR valid_to > '2018-07-01')
+//	GROUP BY issue_status;
+var stateHistoryTables = []string{
+	`CREATE TABLE IF NOT EXISTS "jira_issues_states_history" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"issue_key" TEXT NOT NULL,
+		"valid_from" TIMESTAMP NOT NULL,
+		"valid_to" TIMESTAMP,
+		"version_hash" TEXT NOT NULL,
+		"issue_project" TEXT,
+		"issue_status" TEXT,
+		"issue_status_category" TEXT,
+		"issue_resolved_at" TIMESTAMP,
+		"issue_priority" TEXT,
+		"issue_summary" TEXT,
+		"issue_type" TEXT,
+		"issue_labels" TEXT,
+		"issue_assignee" TEXT,
+		"issue_epic" TEXT,
+		"issue_sprints" TEXT,
+		"issue_components" TEXT,
+		"issue_fix_versions" TEXT
+	);`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS "jira_issues_states_history_current_idx" ON "jira_issues_states_history" ("issue_key") WHERE valid_to IS NULL;`,
+	`CREATE INDEX IF NOT EXISTS "jira_issues_states_history_issue_key_valid_from_idx" ON "jira_issues_states_history" ("issue_key", "valid_from");`,
+}
+
+// SetStateHistory enables the state-history mode: each time an issue
+// state is written, a new version is added to
+// `jira_issues_states_history` if one of the columns of
+// `stateHistoryColumns` changed since the current version, which is
+// closed. The versions are valid from the update time of the issue,
+// so writing an unchanged issue again (e.g. by each full sync) adds
+// no row. The table is left empty otherwise.
+//
+// Only `PGStore` supports the mode. The versions start with the
+// first write of each issue in this mode: the earlier states are not
+// reconstructed.
+func (s *PGStore) SetStateHistory(history bool) {
+	s.stateHistory = history
+}
+
+// stateHistoryValues returns the values of the columns of
+// `stateHistoryColumns` of the issue state, and the hash identifying
+// them.
+func stateHistoryValues(is IssueState) ([]interface{}, string) {
+	var resolvedAt *string
+	if is.ResolvedAt != nil {
+		t := is.ResolvedAt.UTC().Format(time.RFC3339Nano)
+		resolvedAt = &t
+	}
+	values := []interface{}{
+		is.Project,
+		is.Status,
+		is.StatusCategory,
+		is.ResolvedAt,
+		is.Priority,
+		is.Summary,
+		is.Type,
+		is.Labels,
+		is.Assignee,
+		is.Epic,
+		is.Sprints,
+		is.Components,
+		is.FixVersions,
+	}
+	hashed := append([]interface{}{}, values...)
+	hashed[3] = resolvedAt
+	b, _ := json.Marshal(hashed)
+	sum := sha256.Sum256(b)
+	return values, hex.EncodeToString(sum[:])
+}
+
+// recordStateHistory adds a version of the issues to
+// `jira_issues_states_history` within the transaction, for those
+// whose columns changed since their current version (closed at the
+// update time of the issue), if the state-history mode is enabled.
+func (s *PGStore) recordStateHistory(tx *sql.Tx, states ...IssueState) error {
+	if !s.stateHistory {
+		return nil
+	}
+	// The rows of the data-modifying CTE are not visible to the
+	// `INSERT`, which sees the current version before it's closed:
+	// it's only skipped if the current version is unchanged. The
+	// new version starts when the previous one ends, so versions
+	// never overlap even if the update time went backwards.
+	params := make([]string, len(stateHistoryColumns))
+	for i := range stateHistoryColumns {
+		params[i] = fmt.Sprintf("$%d", i+4)
+	}
+	q := fmt.Sprintf(`
+	WITH closed AS (
+		UPDATE jira_issues_states_history
+		SET valid_to = GREATEST(valid_from, $2)
+		WHERE issue_key = $1 AND valid_to IS NULL AND version_hash <> $3
+		RETURNING valid_to
+	)
+	INSERT INTO jira_issues_states_history (issue_key, valid_from, version_hash, %s)
+	SELECT $1, COALESCE((SELECT valid_to FROM closed), $2), $3, %s
+	WHERE NOT EXISTS (
+		SELECT 1 FROM jira_issues_states_history
+		WHERE issue_key = $1 AND valid_to IS NULL AND version_hash = $3
+	);`, strings.Join(stateHistoryColumns, ", "), strings.Join(params, ", "))
+	for _, is := range states {
+		values, hash := stateHistoryValues(is)
+		if _, err := tx.Exec(q, append([]interface{}{is.Key, is.UpdatedAt, hash}, values...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteStateHistory deletes the versions of the state of the issue
+// within the transaction, if the state-history mode is enabled.
+func (s *PGStore) deleteStateHistory(tx *sql.Tx, issueKey string) error {
+	if !s.stateHistory {
+		return nil
+	}
+	_, err := tx.Exec("DELETE FROM jira_issues_states_history WHERE issue_key = $1;", issueKey)
+	return err
+}
@@ -0,0 +1,119 @@
+package store
+
+import "time"
+
+// StatusTransition is the number of `status_changed` events of a
+// project from a status to another, and when the first and last
+// ones happened, as returned by `GetStatusTransitions`.
+type StatusTransition struct {
+	Project string
+
+	// From is empty for the initial status of the issues.
+	From  string
+	To    string
+	Count int
+	First time.Time
+	Last  time.Time
+}
+
+// StatusAlias is a former name of a status of a project, as stored in
+// `jira_status_aliases`.
+type StatusAlias struct {
+	Project string
+	Alias   string
+	Status  string
+
+	// Score is the similarity of the transitions of the alias and the
+	// status, between 0 and 1, for the aliases which were detected
+	// (see `metrics.DetectStatusRenames`).
+	Score float64
+}
+
+// statusAliasesTables are the tables created with `CreateTables` to
+// store the former names of the renamed statuses, replaced when the
+// metrics are computed. The metrics count the time spent in an alias
+// as spent in the status.
+var statusAliasesTables = []string{
+	`CREATE TABLE IF NOT EXISTS "jira_status_aliases" (
+		"project" TEXT NOT NULL,
+		"alias" TEXT NOT NULL,
+		"status" TEXT NOT NULL,
+		"score" REAL NOT NULL,
+		PRIMARY KEY ("project", "alias")
+	);`,
+}
+
+// GetStatusTransitions returns the transitions between the statuses
+// of each project, from the `status_changed` events of the issues not
+// deleted, sorted by project, origin and destination.
+func (s *PGStore) GetStatusTransitions() ([]StatusTransition, error) {
+	rows, err := s.Query(`
+	SELECT s.issue_project, COALESCE(e.status_change_from, ''), e.status_change_to, COUNT(*), MIN(e.event_time), MAX(e.event_time)
+	FROM jira_issues_events e
+	JOIN jira_issues_states s ON s.issue_key = e.issue_key AND s.issue_deleted_at IS NULL
+	WHERE e.event_kind = 'status_changed' AND e.status_change_to IS NOT NULL
+	GROUP BY 1, 2, 3
+	ORDER BY 1, 2, 3;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ts []StatusTransition
+	for rows.Next() {
+		var t StatusTransition
+		if err = rows.Scan(&t.Project, &t.From, &t.To, &t.Count, &t.First, &t.Last); err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, rows.Err()
+}
+
+// ReplaceStatusAliases replaces the records of `jira_status_aliases`
+// with the aliases, within a transaction.
+func (s *PGStore) ReplaceStatusAliases(aliases []StatusAlias) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(`DELETE FROM jira_status_aliases;`); err != nil {
+		return
+	}
+	rows := make([][]interface{}, len(aliases))
+	for i, a := range aliases {
+		rows[i] = []interface{}{a.Project, a.Alias, a.Status, a.Score}
+	}
+	return valuesRows(tx, "jira_status_aliases", []string{"project", "alias", "status", "score"}, rows)
+}
+
+// GetStatusAliases returns the aliases of `jira_status_aliases`,
+// sorted by project and alias.
+func (s *PGStore) GetStatusAliases() ([]StatusAlias, error) {
+	rows, err := s.Query(`SELECT project, alias, status, score FROM jira_status_aliases ORDER BY project, alias;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aliases []StatusAlias
+	for rows.Next() {
+		var a StatusAlias
+		if err = rows.Scan(&a.Project, &a.Alias, &a.Status, &a.Score); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, rows.Err()
+}
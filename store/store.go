@@ -1,70 +1,221 @@
 package store
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 )
 
 // Store is an interface for the application's store
 type Store interface {
 	ReplaceIssueStateAndEvents(k string, is IssueState, ies []IssueEvent) (err error)
-	GetRestartFromUpdatedAt(n int) *time.Time
-	CreateTables()
-	DropTables()
+	GetRestartFromUpdatedAt(n int) (*time.Time, error)
+	CreateTables() error
+	DropTables() error
 }
 
 // IssueState represents the state of an issue to be stored
 // in the DB.
 type IssueState struct {
-	CreatedAt         time.Time
-	UpdatedAt         time.Time
-	Key               string
-	Project           *string
-	Status            *string
-	ResolvedAt        *time.Time
-	Priority          *string
-	Summary           *string
-	Description       *string
-	Type              *string
-	Labels            *string
-	Reporter          *string
-	Assignee          *string
-	DeveloperBackend  *string
-	DeveloperFrontend *string
-	Reviewer          *string
-	ProductOwner      *string
-	BugCause          *string
-	Epic              *string
-	Tribe             *string
-	Components        *string
-	FixVersions       *string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Key            string
+	Project        *string
+	Status         *string
+	StatusCategory *string
+	ResolvedAt     *time.Time
+	Priority       *string
+	Summary        *string
+	Description    *string
+	Type           *string
+	Labels         *string
+	Reporter       *string
+	Assignee       *string
+	Epic           *string
+	Sprints        *string
+	SprintIDs      *string
+	EpicName       *string
+	EpicColor      *string
+	Components     *string
+	FixVersions    *string
+
+	// LabelList, ComponentList and FixVersionList are the values of
+	// `Labels`, `Components` and `FixVersions`, stored one per row in
+	// normalized-fields mode (see `PGStore.SetNormalizedFields`).
+	LabelList      []string
+	ComponentList  []string
+	FixVersionList []string
+
+	// ClonedFromKey is the key of the issue this issue was cloned
+	// from, and MovedFromProject the project the issue was created
+	// in if it was moved to another one.
+	ClonedFromKey    *string
+	MovedFromProject *string
+
+	// OriginalEstimate, RemainingEstimate and TimeSpent are the
+	// time-tracking fields of the issue, in seconds. Nil if time
+	// tracking is disabled or the field is not set.
+	OriginalEstimate  *int
+	RemainingEstimate *int
+	TimeSpent         *int
+
+	// Source is the name of the source the issue was synced from,
+	// nil if the sources are not configured (see `jira.Sources`).
+	Source *string
+
+	// SeverityBucket is the bucket the issue's priority belongs to,
+	// nil if none (see `config.SeverityBucket`).
+	SeverityBucket *string
+
+	// AssigneeAccountID is the account ID of the assignee (see
+	// `User`), nil if the issue is unassigned.
+	AssigneeAccountID *string
+
+	// EstimateSeconds and EstimatePoints are the estimate of the
+	// issue normalized across the projects (see
+	// `config.Estimates`), nil if the issue has no estimate or it
+	// can't be converted.
+	EstimateSeconds *int
+	EstimatePoints  *float64
+
+	// Generated is true if the issue was created by automation or
+	// from a template (see `config.GeneratedIssues`).
+	Generated bool
+
+	// Properties are the configured entity properties of the issue,
+	// as a JSON object keyed by property (see
+	// `config.Mapping.IssueProperties`).
+	Properties *string
+
+	// DescriptionHTML is the description rendered in HTML by Jira,
+	// nil if the rendered fields were not fetched (see
+	// `config.Mapping.RenderHTML`).
+	DescriptionHTML *string
+
+	// ChangelogTruncated is true if the changelog of the issue seems
+	// truncated: its earliest history is much later than the creation
+	// of the issue (see `mapping.Mapper.ChangelogTruncationThreshold`).
+	ChangelogTruncated bool
+
+	// CustomFields are the values of the custom columns (see
+	// `CustomColumn`) by column name. Missing values are NULL.
+	CustomFields map[string]interface{}
+
+	// Links are the links from this issue to other issues. They
+	// are stored in `jira_issue_links`.
+	Links []IssueLink
+
+	// DescriptionRevisions are the edits of the issue's
+	// description. They are stored in
+	// `jira_issue_description_revisions`.
+	DescriptionRevisions []DescriptionRevision
+
+	// Comments are the comments of the issue, nil if they were not
+	// fetched. They are stored in `jira_issue_comments`.
+	Comments []Comment
+}
+
+// Validate returns an error naming the required columns of the
+// issue's records (`NOT NULL` in `jira_issues_states` and
+// `jira_issues_events`) which have no value, so an invalid issue is
+// skipped with an explicit error instead of failing the insert of
+// the other issues of its batch.
+func (is IssueState) Validate() error {
+	var missing []string
+	if is.Key == "" {
+		missing = append(missing, "issue_key")
+	}
+	for _, c := range []struct {
+		column string
+		value  *string
+	}{
+		{"issue_project", is.Project},
+		{"issue_status", is.Status},
+		{"issue_priority", is.Priority},
+		{"issue_summary", is.Summary},
+		{"issue_type", is.Type},
+	} {
+		if c.value == nil {
+			missing = append(missing, c.column)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("invalid issue `%s`: no value for the required columns %s", is.Key, strings.Join(missing, ", "))
+	}
+	return nil
 }
 
 // IssueEvent represents a change event on an issue to be stored
 // in the DB.
 type IssueEvent struct {
 	EventTime          time.Time
-	EventKind          string
+	EventKind          EventKind
 	EventAuthor        string
 	IssueKey           string
 	CommentBody        *string
 	StatusChangeFrom   *string
 	StatusChangeTo     *string
+	StatusChangeReason *string
 	AssigneeChangeFrom *string
 	AssigneeChangeTo   *string
+
+	// WorklogStartedAt and WorklogTimeSpent are the start of the
+	// work and the time spent (in seconds) logged by a
+	// `worklog_added` event.
+	WorklogStartedAt *time.Time
+	WorklogTimeSpent *int
+
+	// AuthorExcluded is true if the event's author is excluded from
+	// the metrics (e.g. a migration bot). The event is stored
+	// anyway.
+	AuthorExcluded bool
+
+	// SprintID and SprintName are the sprint the issue was added to
+	// or removed from by a `sprint_added` or `sprint_removed` event.
+	SprintID   *int
+	SprintName *string
+
+	// FieldName, FieldChangeFrom and FieldChangeTo are the field
+	// changed by an event generated from a changelog (e.g.
+	// `priority` for a `field_changed` event, `status` for a
+	// `status_changed` event) and its values before and after the
+	// change, as displayed by Jira.
+	FieldName       *string
+	FieldChangeFrom *string
+	FieldChangeTo   *string
+
+	// EventAuthorAccountID, AssigneeChangeFromAccountID and
+	// AssigneeChangeToAccountID are the account IDs (see `User`) of
+	// the event's author and of the assignees before and after an
+	// `assignee_changed` event, nil if unknown.
+	EventAuthorAccountID        *string
+	AssigneeChangeFromAccountID *string
+	AssigneeChangeToAccountID   *string
+
+	// CommentID is the ID of the comment (see `Comment`) of a
+	// `comment_added`, `comment_updated` or `comment_deleted` event.
+	CommentID *string
+
+	// CommentLength is the length of the comment body before it was
+	// redacted (see `config.Redaction`). The length of `CommentBody`
+	// is stored if nil.
+	CommentLength *int
 }
 
 func (ie IssueEvent) String() string {
 	var from, to string
 	switch ie.EventKind {
-	case "status_changed":
+	case EventStatusChanged:
 		if ie.StatusChangeFrom != nil {
 			from = *ie.StatusChangeFrom
 		}
 		if ie.StatusChangeTo != nil {
 			to = *ie.StatusChangeTo
 		}
-	case "assignee_changed":
+	case EventAssigneeChanged:
 		if ie.AssigneeChangeFrom != nil {
 			from = *ie.AssigneeChangeFrom
 		}
@@ -77,6 +228,21 @@ func (ie IssueEvent) String() string {
 	return fmt.Sprintf("<IssueEvent:%s `%s` -> `%s`: time=%s author=%s issueKey=%s>", ie.EventKind, from, to, ie.EventTime, ie.EventAuthor, ie.IssueKey)
 }
 
+// DedupKey returns the key identifying the event: a hash of all its
+// fields, including the issue's key. It's stored in the unique
+// `dedup_key` column, so the same event is never stored twice, even
+// by concurrent writes of the issue.
+func (ie IssueEvent) DedupKey() string {
+	ie.EventTime = ie.EventTime.UTC()
+	if ie.WorklogStartedAt != nil {
+		t := ie.WorklogStartedAt.UTC()
+		ie.WorklogStartedAt = &t
+	}
+	b, _ := json.Marshal(ie)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
 // IssueEventsByTime implements sort.Interface for []IssueEvent based on
 // the EventTime field.
 type IssueEventsByTime []IssueEvent
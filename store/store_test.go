@@ -1,11 +1,22 @@
 package store_test
 
 import (
+	"bytes"
+	"context"
 	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/rchampourlier/kaizenizer-source-jira/store"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
@@ -25,6 +36,34 @@ func TestStore_IssueEventsByTime_sort(t *testing.T) {
 	}
 }
 
+func TestIssueEvent_DedupKey(t *testing.T) {
+	at := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	ie := store.IssueEvent{EventTime: at, EventKind: store.EventCommentAdded, IssueKey: "PJ-1", CommentBody: stringAddr("Hello")}
+
+	same := ie
+	same.EventTime = at.In(time.FixedZone("CET", 3600))
+	if ie.DedupKey() != same.DedupKey() {
+		t.Errorf("expected the same key for the same event in another time zone")
+	}
+	other := ie
+	other.CommentBody = stringAddr("Hello again")
+	if ie.DedupKey() == other.DedupKey() {
+		t.Errorf("expected different keys for different comments at the same time")
+	}
+}
+
+func TestIssueState_Validate(t *testing.T) {
+	if err := withRequired(store.IssueState{Key: "PJ-1"}).Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	is := withRequired(store.IssueState{Key: "PJ-1"})
+	is.Priority, is.Summary = nil, nil
+	err := is.Validate()
+	if err == nil || err.Error() != "invalid issue `PJ-1`: no value for the required columns issue_priority, issue_summary" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
 func TestPGStore_ReplaceIssueStateAndEvents(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -37,10 +76,20 @@ func TestPGStore_ReplaceIssueStateAndEvents(t *testing.T) {
 	mock.ExpectBegin()
 
 	// expect drop state and events
-	mock.ExpectExec("DELETE FROM jira_issues_events WHERE issue_key = 'key'").
+	mock.ExpectExec("DELETE FROM jira_issues_events WHERE issue_key = \\$1").
+		WithArgs("key").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectExec("DELETE FROM jira_issues_states WHERE issue_key = \\$1").
+		WithArgs("key").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectExec("DELETE FROM jira_issue_links WHERE source_key = \\$1").
+		WithArgs("key").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	mock.ExpectExec("DELETE FROM jira_issues_states WHERE issue_key = 'key'").
+	mock.ExpectExec("DELETE FROM jira_issue_description_revisions WHERE issue_key = \\$1").
+		WithArgs("key").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// expect insert state
@@ -57,20 +106,43 @@ func TestPGStore_ReplaceIssueStateAndEvents(t *testing.T) {
 		"type",
 		"labels",
 		"assignee",
-		"developer_backend",
-		"developer_frontend",
-		"reviewer",
-		"product_owner",
-		"bug_cause",
 		"epic",
-		"tribe",
 		"components",
 		"fix_versions",
+		"status_category",
+		"sprints",
+		"epic_name",
+		"epic_color",
+		"cloned_from_key",
+		"moved_from_project",
+		nil,
+		nil,
+		nil,
+		nil,
+		"source",
+		"severity_bucket",
+		"assignee_account_id",
+		nil,
+		nil,
+		false,
+		nil,
+		nil,
+		false,
 	).WillReturnResult(sqlmock.NewResult(1, 1))
 
+	// expect insert links
+	mock.ExpectExec("INSERT INTO jira_issue_links").
+		WithArgs("key", "other_key", "Blocks", "outward").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// expect insert description revisions
+	mock.ExpectExec("INSERT INTO jira_issue_description_revisions").
+		WithArgs("key", anyTime{}, "author", nil, "description").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
 	mock.ExpectExec("INSERT INTO jira_issues_events").WithArgs(
 		anyTime{},
-		"kind",
+		"status_changed",
 		"author",
 		"comment",
 		"status_from",
@@ -89,20 +161,36 @@ func TestPGStore_ReplaceIssueStateAndEvents(t *testing.T) {
 		"type",
 		"labels",
 		"assignee",
-		"developer_backend",
-		"developer_frontend",
-		"reviewer",
-		"product_owner",
-		"bug_cause",
 		"epic",
-		"tribe",
 		"components",
 		"fix_versions",
+		"reason",
+		nil,
+		nil,
+		false,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		sqlmock.AnyArg(),
+		7,
+		"source",
+		"severity_bucket",
+		"author_account_id",
+		"assignee_from_account_id",
+		"assignee_to_account_id",
+		"assignee_account_id",
+		nil,
 	).WillReturnResult(sqlmock.NewResult(1, 1))
 
 	mock.ExpectCommit()
 
-	err = s.ReplaceIssueStateAndEvents("key", mockIssueState(), []store.IssueEvent{mockIssueEvent()})
+	is := mockIssueState()
+	is.DescriptionRevisions = []store.DescriptionRevision{
+		{IssueKey: "key", RevisedAt: time.Now(), Author: "author", To: stringAddr("description")},
+	}
+	err = s.ReplaceIssueStateAndEvents("key", is, []store.IssueEvent{mockIssueEvent()})
 	if err != nil {
 		t.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s\n", err)
 	}
@@ -113,113 +201,2464 @@ func TestPGStore_ReplaceIssueStateAndEvents(t *testing.T) {
 	}
 }
 
-func TestPGStore_GetRestartFromUpdatedAt(t *testing.T) {
+func TestPGStore_ReplaceIssueStateAndEvents_customColumns(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
+	s := store.NewPGStore(db)
+	s.SetCustomColumns([]store.CustomColumn{
+		{Name: "issue_team", Type: store.CustomColumnText},
+		{Name: "issue_story_points", Type: store.CustomColumnNumeric},
+	})
+
+	is := withRequired(store.IssueState{
+		Key:          "key",
+		CustomFields: map[string]interface{}{"issue_team": stringAddr("Payments")},
+	})
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_issues_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issues_states").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issue_links").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issue_description_revisions").WillReturnResult(sqlmock.NewResult(0, 0))
+	args := make([]driver.Value, 36)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	args[34], args[35] = "Payments", nil
+	mock.ExpectExec("INSERT INTO jira_issues_states \\(.*issue_changelog_truncated, issue_team, issue_story_points\\).*\\$35, \\$36\\)").
+		WithArgs(args...).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err = s.ReplaceIssueStateAndEvents("key", is, nil); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
 
+func TestPGStore_ReplaceIssueStateAndEvents_quarantine(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
 	s := store.NewPGStore(db)
+	s.SetQuarantine(true)
+	var handled []store.IssueEvent
+	s.SetEventHandler(func(ie store.IssueEvent) error {
+		handled = append(handled, ie)
+		return nil
+	})
 
-	timeV := time.Now()
-	rows := sqlmock.NewRows([]string{"max"}).
-		AddRow(timeV)
+	// An event is already stored (e.g. by an issue moved since): it is
+	// quarantined instead of being ignored, and not handled.
+	stored := store.IssueEvent{EventKind: "comment_added", IssueKey: "key", CommentBody: stringAddr("Hi")}
+	created := store.IssueEvent{EventKind: "created", IssueKey: "key"}
+	mock.ExpectBegin()
+	for _, table := range []string{"jira_issues_events", "jira_issues_states", "jira_issue_links", "jira_issue_description_revisions"} {
+		mock.ExpectExec("DELETE FROM " + table).WithArgs("key").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectQuery("SELECT issue_key FROM jira_issues_states WHERE issue_key = ANY\\(\\$1\\)").
+		WithArgs(`{"key"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"issue_key"}))
+	mock.ExpectExec("INSERT INTO jira_issues_states").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT dedup_key FROM jira_issues_events WHERE dedup_key = ANY\\(\\$1\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"dedup_key"}).AddRow(stored.DedupKey()))
+	quarantine := mock.ExpectPrepare("COPY \"jira_quarantine\"")
+	quarantine.ExpectExec().
+		WithArgs("jira_issues_events", stored.DedupKey(), "key", nil, store.QuarantineStored, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	quarantine.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO jira_issues_events").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
-	mock.ExpectQuery("SELECT MIN\\(issue_updated_at\\) FROM \\( SELECT issue_updated_at FROM jira_issues_states ORDER BY issue_updated_at DESC LIMIT \\$1 \\)").
-		WillReturnRows(rows)
+	if err = s.ReplaceIssueStateAndEvents("key", withRequired(store.IssueState{Key: "key"}), []store.IssueEvent{created, stored}); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s\n", err)
+	}
+	if len(handled) != 1 || handled[0].EventKind != "created" {
+		t.Errorf("expected only the written event to be handled, got %v", handled)
+	}
 
-	r := s.GetRestartFromUpdatedAt(10)
-	if *r != timeV {
-		t.Errorf("unexpected result `%v`, expected `%v`\n", r, timeV)
+	// The state is quarantined if the issue was written meanwhile
+	mock.ExpectBegin()
+	for _, table := range []string{"jira_issues_events", "jira_issues_states", "jira_issue_links", "jira_issue_description_revisions"} {
+		mock.ExpectExec("DELETE FROM " + table).WithArgs("key").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectQuery("SELECT issue_key FROM jira_issues_states").
+		WillReturnRows(sqlmock.NewRows([]string{"issue_key"}).AddRow("key"))
+	quarantine = mock.ExpectPrepare("COPY \"jira_quarantine\"")
+	quarantine.ExpectExec().
+		WithArgs("jira_issues_states", "key", "key", nil, store.QuarantineStored, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	quarantine.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if err = s.ReplaceIssueStateAndEvents("key", withRequired(store.IssueState{Key: "key"}), nil); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
 
-func TestPGStore_CreateTables(t *testing.T) {
+func TestPGStore_ReplaceIssueStateAndEvents_order(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
+	s := store.NewPGStore(db)
 
-	mock.ExpectExec("CREATE TABLE \"jira_issues_states\"").
+	at := time.Date(2020, 3, 2, 9, 0, 0, 0, time.UTC)
+	ies := []store.IssueEvent{
+		{EventTime: at.Add(time.Hour), EventKind: store.EventCommentAdded, IssueKey: "key"},
+		{EventTime: at, EventKind: store.EventCreated, IssueKey: "key"},
+		{EventTime: at, EventKind: store.EventStatusChanged, IssueKey: "key"},
+	}
+	// The events are inserted in event-time order, the events at
+	// the same time in the passed order.
+	mock.ExpectBegin()
+	for _, table := range []string{"jira_issues_events", "jira_issues_states", "jira_issue_links", "jira_issue_description_revisions"} {
+		mock.ExpectExec("DELETE FROM " + table).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("INSERT INTO jira_issues_states").WillReturnResult(sqlmock.NewResult(1, 1))
+	for _, ie := range []store.IssueEvent{ies[1], ies[2], ies[0]} {
+		args := make([]driver.Value, 41)
+		for i := range args {
+			args[i] = sqlmock.AnyArg()
+		}
+		args[0], args[1] = ie.EventTime, string(ie.EventKind)
+		mock.ExpectExec("INSERT INTO jira_issues_events").WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectCommit()
+
+	if err = s.ReplaceIssueStateAndEvents("key", withRequired(store.IssueState{Key: "key"}), ies); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s\n", err)
+	}
+	if ies[0].EventKind != store.EventCommentAdded {
+		t.Errorf("expected the passed events not to be sorted in place")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ReplaceIssueStateAndEvents_comments(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	created := time.Date(2020, 3, 2, 9, 0, 0, 0, time.UTC)
+	deletedAt := time.Date(2020, 3, 3, 9, 0, 0, 0, time.UTC)
+	is := withRequired(store.IssueState{
+		Key: "key",
+		Comments: []store.Comment{
+			{ID: "10101", IssueKey: "key", Author: "bob", CreatedAt: created, UpdatedAt: created, Body: "Done", BodyHTML: stringAddr("<p>Done</p>")},
+		},
+	})
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_issues_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issues_states").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issue_links").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issue_description_revisions").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO jira_issues_states").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO jira_issue_comments .* ON CONFLICT \\(comment_id\\) DO UPDATE SET").
+		WithArgs("10101", "key", "bob", nil, created, created, "Done", "<p>Done</p>", nil, nil, nil).
 		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec("CREATE TABLE \"jira_issues_events\"").
+	// The comments not found anymore are marked as deleted
+	mock.ExpectExec("UPDATE jira_issue_comments SET deleted_at = \\$2").
+		WithArgs("key", anyTime{}, pq.Array([]string{"10101"})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT comment_id, author, author_account_id, deleted_at FROM jira_issue_comments").
+		WithArgs("key").
+		WillReturnRows(sqlmock.NewRows([]string{"comment_id", "author", "author_account_id", "deleted_at"}).
+			AddRow("10100", "alice", nil, deletedAt))
+	args := make([]driver.Value, 41)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	args[0], args[1], args[2], args[40] = deletedAt, "comment_deleted", "alice", "10100"
+	mock.ExpectExec("INSERT INTO jira_issues_events").
+		WithArgs(args...).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err = s.ReplaceIssueStateAndEvents("key", is, nil); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
 
+func TestPGStore_ReplaceIssueStateAndEvents_normalizedFields(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
 	s := store.NewPGStore(db)
-	s.CreateTables()
+	s.SetNormalizedFields(true)
+
+	is := withRequired(store.IssueState{
+		Key:            "key",
+		Labels:         stringAddr("a,bc"),
+		LabelList:      []string{"a,b", "c", "c"},
+		ComponentList:  []string{"Backend"},
+		FixVersionList: nil,
+	})
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_issues_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issues_states").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issue_links").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issue_description_revisions").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO jira_issues_states").WillReturnResult(sqlmock.NewResult(1, 1))
+	// One row per value, including those containing commas, and
+	// duplicates once
+	mock.ExpectExec("DELETE FROM \"jira_issue_labels\" WHERE issue_key = ANY\\(\\$1\\)").
+		WithArgs(`{"key"}`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	labels := mock.ExpectPrepare("COPY \"jira_issue_labels\" \\(\"issue_key\", \"label\"\\)")
+	labels.ExpectExec().WithArgs("key", "a,b").WillReturnResult(sqlmock.NewResult(0, 0))
+	labels.ExpectExec().WithArgs("key", "c").WillReturnResult(sqlmock.NewResult(0, 0))
+	labels.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM \"jira_issue_components\"").
+		WithArgs(`{"key"}`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	components := mock.ExpectPrepare("COPY \"jira_issue_components\"")
+	components.ExpectExec().WithArgs("key", "Backend").WillReturnResult(sqlmock.NewResult(0, 0))
+	components.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	// The values removed from the issue are deleted
+	mock.ExpectExec("DELETE FROM \"jira_issue_fix_versions\"").
+		WithArgs(`{"key"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err = s.ReplaceIssueStateAndEvents("key", is, nil); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
 
-func TestPGStore_Drop(t *testing.T) {
+func TestPGStore_ReplaceIssueStateAndEvents_stateHistory(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
+	s := store.NewPGStore(db)
+	s.SetStateHistory(true)
 
-	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_issues_states\"").
+	is := withRequired(store.IssueState{Key: "key", UpdatedAt: time.Now()})
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_issues_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issues_states").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issue_links").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issue_description_revisions").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO jira_issues_states").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("WITH closed AS \\(\\s*UPDATE jira_issues_states_history (.+) INSERT INTO jira_issues_states_history").
+		WithArgs("key", is.UpdatedAt, sqlmock.AnyArg(), "project", "status", nil, nil, "priority", "summary", "type", nil, nil, nil, nil, nil, nil).
 		WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_issues_events\"").
+	mock.ExpectCommit()
+
+	if err = s.ReplaceIssueStateAndEvents("key", is, nil); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSQLiteStore_ReplaceIssueStateAndEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewSQLiteStore(db)
+	s.SetCustomColumns([]store.CustomColumn{{Name: "issue_team", Type: store.CustomColumnText}})
+
+	mock.ExpectBegin()
+	for _, q := range []string{
+		"DELETE FROM jira_issues_events WHERE issue_key = \\?",
+		"DELETE FROM jira_issues_states WHERE issue_key = \\?",
+		"DELETE FROM jira_issue_links WHERE source_key = \\?",
+	} {
+		mock.ExpectExec(q).WithArgs("key").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("INSERT INTO jira_issues_states \\(.*issue_changelog_truncated, issue_team\\) VALUES \\((\\?, ){34}\\?\\)").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO jira_issue_links").
+		WithArgs("key", "other_key", "Blocks", store.LinkOutward).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO jira_issues_events \\(.*comment_id, issue_team\\) VALUES \\((\\?, ){41}\\?\\)").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err = s.ReplaceIssueStateAndEvents("key", mockIssueState(), []store.IssueEvent{mockIssueEvent()}); err != nil {
+		t.Errorf("error was not expected while replacing the issue: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	for _, format := range []string{store.FileFormatJSONL, store.FileFormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "filestore")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			s, err := store.NewFileStore(dir, format, []store.CustomColumn{{Name: "issue_story_points", Type: store.CustomColumnNumeric}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			is := mockIssueState()
+			is.CustomFields = map[string]interface{}{"issue_story_points": floatAddr(3)}
+			ie := mockIssueEvent()
+			if err = s.ReplaceIssueStateAndEvents("key", is, []store.IssueEvent{ie, ie}); err != nil {
+				t.Fatal(err)
+			}
+			if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
 
+			states, _ := ioutil.ReadFile(filepath.Join(dir, "jira_issues_states."+format))
+			events, _ := ioutil.ReadFile(filepath.Join(dir, "jira_issues_events."+format))
+			links, _ := ioutil.ReadFile(filepath.Join(dir, "jira_issue_links."+format))
+			expected := map[string][]string{
+				store.FileFormatJSONL: {
+					`"issue_key":"key"`, `"issue_story_points":3}`, `"issue_sprint_ids":null`,
+					`"event_kind":"status_changed"`, `"comment_length":7`,
+					`{"source_key":"key","target_key":"other_key","link_type":"Blocks","direction":"outward"}`,
+				},
+				store.FileFormatCSV: {
+					"issue_created_at,issue_updated_at,issue_key,", ",severity_bucket,assignee_account_id,,,false,,,false,3\n",
+					"event_time,event_kind,", ",status_changed,author,comment,",
+					"source_key,target_key,link_type,direction\nkey,other_key,Blocks,outward\n",
+				},
+			}[format]
+			all := string(states) + string(events) + string(links)
+			for _, e := range expected {
+				if !strings.Contains(all, e) {
+					t.Errorf("expected the files to contain `%s`, got:\n%s", e, all)
+				}
+			}
+			// The duplicate event is written once (after the header
+			// in CSV)
+			lines := map[string]int{store.FileFormatJSONL: 1, store.FileFormatCSV: 2}[format]
+			if n := strings.Count(string(events), "\n"); n != lines {
+				t.Errorf("expected a single event, got:\n%s", events)
+			}
+		})
+	}
+}
+
+func TestPGStore_ReplaceIssueStateAndEvents_timeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
 	s := store.NewPGStore(db)
-	s.DropTables()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_issues_events WHERE issue_key = \\$1").
+		WithArgs("key").
+		WillReturnError(&pq.Error{Code: "55P03", Message: "canceling statement due to lock timeout"})
+	mock.ExpectRollback()
+
+	err = s.ReplaceIssueStateAndEvents("key", mockIssueState(), []store.IssueEvent{mockIssueEvent()})
+	if !store.IsTimeout(err) {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
 
-func mockIssueState() store.IssueState {
-	return store.IssueState{
-		CreatedAt:         time.Now(),
-		UpdatedAt:         time.Now(),
-		Key:               "key",
-		Project:           stringAddr("project"),
-		Status:            stringAddr("status"),
-		ResolvedAt:        timeAddr(time.Now()),
-		Priority:          stringAddr("priority"),
-		Summary:           stringAddr("summary"),
-		Description:       stringAddr("description"),
-		Type:              stringAddr("type"),
-		Labels:            stringAddr("labels"),
-		Reporter:          stringAddr("reporter"),
-		Assignee:          stringAddr("assignee"),
-		DeveloperBackend:  stringAddr("developer_backend"),
-		DeveloperFrontend: stringAddr("developer_frontend"),
-		Reviewer:          stringAddr("reviewer"),
-		ProductOwner:      stringAddr("product_owner"),
-		BugCause:          stringAddr("bug_cause"),
-		Epic:              stringAddr("epic"),
-		Tribe:             stringAddr("tribe"),
-		Components:        stringAddr("components"),
-		FixVersions:       stringAddr("fix_versions"),
+func TestPGStore_SetEventHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	var handled []store.IssueEvent
+	s.SetEventHandler(func(ie store.IssueEvent) error {
+		handled = append(handled, ie)
+		return errors.New("ignored")
+	})
+
+	// Not called if the write fails
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_issues_events").WillReturnError(errors.New("failed"))
+	mock.ExpectRollback()
+	if err = s.ReplaceIssueStateAndEvents("key", withRequired(store.IssueState{Key: "key"}), []store.IssueEvent{mockIssueEvent()}); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if len(handled) != 0 {
+		t.Errorf("expected no handled event, got %d", len(handled))
+	}
+
+	// Called once committed, its errors being ignored
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_issues_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issues_states").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issue_links").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issue_description_revisions").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO jira_issues_states").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO jira_issues_events").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	if err = s.ReplaceIssueStateAndEvents("key", withRequired(store.IssueState{Key: "key"}), []store.IssueEvent{mockIssueEvent()}); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s\n", err)
+	}
+	if len(handled) != 1 || handled[0].IssueKey != mockIssueEvent().IssueKey {
+		t.Errorf("expected the event to be handled, got %v", handled)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
 
-func mockIssueEvent() store.IssueEvent {
-	return store.IssueEvent{
-		EventTime:          time.Now(),
-		EventKind:          "kind",
-		EventAuthor:        "author",
-		IssueKey:           "key",
-		CommentBody:        stringAddr("comment"),
-		StatusChangeFrom:   stringAddr("status_from"),
-		StatusChangeTo:     stringAddr("status_to"),
-		AssigneeChangeFrom: stringAddr("assignee_from"),
-		AssigneeChangeTo:   stringAddr("assignee_to"),
+func TestPGStore_CommentVault(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	if _, err = store.NewCommentVault([]byte("short")); err == nil {
+		t.Errorf("expected an error for a key of the wrong size")
+	}
+	v, err := store.NewCommentVault([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetCommentVault(v)
+
+	ie := mockIssueEvent()
+	args := make([]driver.Value, 41)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	// The body is removed from the event, only its length is kept
+	args[3], args[33] = nil, 7
+	sealed := &capturedArg{}
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_issues_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issues_states").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issue_links").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_issue_description_revisions").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM jira_comment_vault WHERE issue_key = ANY").WithArgs("{\"key\"}").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO jira_issues_states").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO jira_issues_events").WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO jira_comment_vault").
+		WithArgs(ie.DedupKey(), "key", anyTime{}, "author", sealed).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	if err = s.ReplaceIssueStateAndEvents("key", withRequired(store.IssueState{Key: "key"}), []store.IssueEvent{ie}); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s\n", err)
+	}
+	if bytes.Contains(sealed.value.([]byte), []byte("comment")) {
+		t.Errorf("expected the comment body to be encrypted")
+	}
+
+	// The comments are decrypted with the key
+	mock.ExpectQuery("SELECT (.+) FROM jira_comment_vault WHERE issue_key = \\$1").
+		WithArgs("key").
+		WillReturnRows(sqlmock.NewRows([]string{"dedup_key", "event_time", "event_author", "comment_body_encrypted"}).
+			AddRow(ie.DedupKey(), ie.EventTime, "author", sealed.value))
+	cs, err := s.GetVaultedComments("key")
+	if err != nil {
+		t.Fatalf("unexpected error in `GetVaultedComments`: %s\n", err)
+	}
+	if len(cs) != 1 || cs[0].Body != "comment" {
+		t.Errorf("expected the decrypted comment, got %v", cs)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
 
-type anyTime struct{}
+func TestPGStore_EachIssueMetrics(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
 
-// Match satisfies sqlmock.Argument interface
-func (a anyTime) Match(v driver.Value) bool {
-	_, ok := v.(time.Time)
-	return ok
+	created := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT issue_key, status, duration_seconds, business_duration_seconds FROM jira_issue_status_times").
+		WillReturnRows(sqlmock.NewRows([]string{"issue_key", "status", "duration_seconds", "business_duration_seconds"}).
+			AddRow("PJ-1", "To Do", 60, nil).
+			AddRow("PJ-1", "In Progress", 3600, 1800))
+	columns := []string{"issue_key", "issue_project", "issue_type", "issue_created_at", "started_at", "done_at",
+		"lead_time_seconds", "cycle_time_seconds", "first_response_at", "first_response_time_seconds", "reopenings_count",
+		"first_assigned_at", "time_to_first_assignment_seconds", "lead_time_business_seconds", "cycle_time_business_seconds",
+		"first_response_time_business_seconds", "time_to_first_assignment_business_seconds", "blocked_time_seconds",
+		"blocked_time_business_seconds", "first_time_right"}
+	mock.ExpectQuery("SELECT (.+) FROM jira_issue_metrics").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("PJ-1", "Project", "Story", created, nil, nil, 7200, nil, nil, nil, 1, nil, nil, nil, nil, nil, nil, 120, nil, false))
+
+	var ims []store.IssueMetrics
+	err = s.EachIssueMetrics(func(im store.IssueMetrics) error {
+		ims = append(ims, im)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in `EachIssueMetrics`: %s\n", err)
+	}
+	if len(ims) != 1 {
+		t.Fatalf("expected 1 issue metrics, got %d", len(ims))
+	}
+	im := ims[0]
+	if im.LeadTime == nil || *im.LeadTime != 2*time.Hour || im.CycleTime != nil || im.BlockedTime != 2*time.Minute || im.Reopenings != 1 ||
+		im.FirstTimeRight == nil || *im.FirstTimeRight {
+		t.Errorf("unexpected metrics %+v", im)
+	}
+	if len(im.StatusTimes) != 2 || im.StatusTimes[1].Status != "In Progress" || *im.StatusTimes[1].BusinessDuration != 30*time.Minute {
+		t.Errorf("unexpected status times %+v", im.StatusTimes)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
 
-func stringAddr(s string) *string {
-	return &s
+func TestPGStore_LockSync(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	store.SyncLockPollInterval = time.Millisecond
+
+	lease := store.SyncLockLease.Seconds()
+	lockQuery := "INSERT INTO sync_locks \\(name, holder, acquired_at, expires_at\\) .* ON CONFLICT \\(name\\) DO UPDATE .* WHERE sync_locks.expires_at < NOW\\(\\) RETURNING holder"
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"sync_locks\"").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(lockQuery).WithArgs("sync", sqlmock.AnyArg(), lease).
+		WillReturnRows(sqlmock.NewRows([]string{"holder"}))
+	if _, err = s.LockSync(context.Background(), "sync", false); err != store.ErrSyncLocked {
+		t.Errorf("expected `ErrSyncLocked` when the lock is held, got %v", err)
+	}
+
+	// The lock is retried until it's released, then held until
+	// `Unlock`
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"sync_locks\"").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(lockQuery).WithArgs("sync", sqlmock.AnyArg(), lease).
+		WillReturnRows(sqlmock.NewRows([]string{"holder"}))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"sync_locks\"").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(lockQuery).WithArgs("sync", sqlmock.AnyArg(), lease).
+		WillReturnRows(sqlmock.NewRows([]string{"holder"}).AddRow("host:42:0123456789abcdef"))
+	mock.ExpectExec("DELETE FROM sync_locks WHERE name = \\$1 AND holder = \\$2").
+		WithArgs("sync", "host:42:0123456789abcdef").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	l, err := s.LockSync(context.Background(), "sync", true)
+	if err != nil {
+		t.Fatalf("unexpected error in `LockSync`: %s\n", err)
+	}
+	if err = l.Unlock(); err != nil {
+		t.Errorf("unexpected error in `Unlock`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
 
-func timeAddr(t time.Time) *time.Time {
-	return &t
+func TestSyncLock_Lost(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	defer func(lease time.Duration) { store.SyncLockLease = lease }(store.SyncLockLease)
+	store.SyncLockLease = 30 * time.Millisecond
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"sync_locks\"").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO sync_locks").
+		WillReturnRows(sqlmock.NewRows([]string{"holder"}).AddRow("host:42:0123456789abcdef"))
+	// Another run took over the lock
+	mock.ExpectExec("UPDATE sync_locks").
+		WithArgs("sync", "host:42:0123456789abcdef", store.SyncLockLease.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM sync_locks").WillReturnResult(sqlmock.NewResult(0, 0))
+	l, err := s.LockSync(context.Background(), "sync", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second):
+		t.Errorf("expected the lock to be lost")
+	}
+	if err = l.Unlock(); err != nil {
+		t.Errorf("unexpected error in `Unlock`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWithTimeouts(t *testing.T) {
+	tests := []struct {
+		connStr  string
+		expected string
+	}{
+		{"dbname=db", "dbname=db statement_timeout=30000 lock_timeout=10000"},
+		{"postgres://u@host/db", "postgres://u@host/db?statement_timeout=30000&lock_timeout=10000"},
+		{"postgres://u@host/db?sslmode=disable", "postgres://u@host/db?sslmode=disable&statement_timeout=30000&lock_timeout=10000"},
+	}
+	for _, tt := range tests {
+		if got := store.WithTimeouts(tt.connStr, 30*time.Second, 10*time.Second); got != tt.expected {
+			t.Errorf("expected `%s`, got `%s`", tt.expected, got)
+		}
+	}
+	if got := store.WithTimeouts("dbname=db", 0, 0); got != "dbname=db" {
+		t.Errorf("expected the connection string to be unchanged without timeouts, got `%s`", got)
+	}
+}
+
+func TestWithSearchPath(t *testing.T) {
+	if got := store.WithSearchPath("dbname=db", "test_1"); got != "dbname=db search_path=test_1" {
+		t.Errorf("unexpected connection string `%s`", got)
+	}
+	if got := store.WithSearchPath("postgres://u@host/db?sslmode=disable", "test_1"); got != "postgres://u@host/db?sslmode=disable&search_path=test_1" {
+		t.Errorf("unexpected connection string `%s`", got)
+	}
+}
+
+func TestPGStore_GetRestartFromUpdatedAt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	s := store.NewPGStore(db)
+
+	timeV := time.Now()
+	rows := sqlmock.NewRows([]string{"max"}).
+		AddRow(timeV)
+
+	mock.ExpectQuery("SELECT MIN\\(issue_updated_at\\) FROM \\( SELECT issue_updated_at FROM jira_issues_states ORDER BY issue_updated_at DESC LIMIT \\$1 \\)").
+		WillReturnRows(rows)
+
+	r, err := s.GetRestartFromUpdatedAt(10)
+	if err != nil {
+		t.Fatalf("unexpected error in `GetRestartFromUpdatedAt`: %s", err)
+	}
+	if *r != timeV {
+		t.Errorf("unexpected result `%v`, expected `%v`\n", r, timeV)
+	}
+}
+
+func TestPGStore_GetIssueKeysWhere(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT issue_key FROM jira_issues_states WHERE \\(issue_status IS NULL\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"issue_key"}).AddRow("PJ-1").AddRow("PJ-2"))
+	mock.ExpectRollback()
+
+	s := store.NewPGStore(db)
+	keys, err := s.GetIssueKeysWhere("issue_status IS NULL")
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssueKeysWhere`: %s", err)
+	}
+	if len(keys) != 2 || keys[0] != "PJ-1" || keys[1] != "PJ-2" {
+		t.Errorf("expected PJ-1 and PJ-2, got %v", keys)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_GetVerifiedStates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	updated := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT issue_key, issue_updated_at, issue_status, issue_assignee FROM jira_issues_states WHERE issue_key = ANY").
+		WithArgs(pq.Array([]string{"PJ-1", "PJ-2"})).
+		WillReturnRows(sqlmock.NewRows([]string{"issue_key", "issue_updated_at", "issue_status", "issue_assignee"}).AddRow("PJ-1", updated, "Open", nil))
+
+	s := store.NewPGStore(db)
+	states, err := s.GetVerifiedStates([]string{"PJ-1", "PJ-2"})
+	if err != nil {
+		t.Fatalf("unexpected error in `GetVerifiedStates`: %s", err)
+	}
+	vs, ok := states["PJ-1"]
+	if len(states) != 1 || !ok || !vs.UpdatedAt.Equal(updated) || vs.Status == nil || *vs.Status != "Open" || vs.Assignee != nil {
+		t.Errorf("expected the state of PJ-1 only, got %v", states)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_RefreshAssigneeIntervals(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_assignee_intervals").
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec("INSERT INTO jira_assignee_intervals .* LEAD\\(event_time\\) OVER \\(PARTITION BY issue_key ORDER BY event_time, id\\) .* WHERE event_kind = 'assignee_changed'").
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectCommit()
+
+	s := store.NewPGStore(db)
+	n, err := s.RefreshAssigneeIntervals()
+	if err != nil || n != 5 {
+		t.Errorf("expected 5 intervals, got %d (error: %v)", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_GetAssigneeIntervals(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	from := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+	mock.ExpectQuery("SELECT issue_key, assignee, from_time, to_time FROM jira_assignee_intervals WHERE assignee = ANY\\(\\$1\\)").
+		WithArgs(`{"alice","bob"}`, from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"issue_key", "assignee", "from_time", "to_time"}).
+			AddRow("PJ-1", "alice", from.AddDate(0, 0, -1), to.AddDate(0, 0, -1)).
+			AddRow("PJ-2", "bob", from.AddDate(0, 0, 2), nil))
+
+	s := store.NewPGStore(db)
+	intervals, err := s.GetAssigneeIntervals([]string{"alice", "bob"}, from, to)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(intervals) != 2 || intervals[0].To == nil || intervals[1].To != nil || intervals[1].Assignee != "bob" {
+		t.Errorf("unexpected intervals %+v", intervals)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_UpdateWIPAging(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	at := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectExec("WITH deleted AS \\( DELETE FROM jira_wip_aging .* INSERT INTO jira_wip_aging").
+		WithArgs("PJ-1", at, "In Review").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM jira_wip_aging WHERE issue_key = \\$1").
+		WithArgs("PJ-1", at.AddDate(0, 0, 1)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	s := store.NewPGStore(db)
+	if err = s.UpdateWIPAging("PJ-1", at, "In Review", true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err = s.UpdateWIPAging("PJ-1", at.AddDate(0, 0, 1), "Done", false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ReplaceStatusAliases(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_status_aliases").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO jira_status_aliases \\(project, alias, status, score\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\);").
+		WithArgs("PJ", "In Dev", "Development", 0.75).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	s := store.NewPGStore(db)
+	if err = s.ReplaceStatusAliases([]store.StatusAlias{{Project: "PJ", Alias: "In Dev", Status: "Development", Score: 0.75}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_Violations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	a, ok := store.BuiltinAssertion("no_event_before_creation")
+	if !ok {
+		t.Fatalf("expected the built-in assertion to exist")
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM jira_issues_events WHERE event_time < issue_created_at").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectRollback()
+
+	n, err := store.NewPGStore(db).Violations(a)
+	if err != nil || n != 3 {
+		t.Errorf("expected 3 violations, got %d (%v)", n, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_GetDailyEventCounts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	from := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 30)
+	mock.ExpectQuery("SELECT event_time::DATE, event_kind, COUNT\\(\\*\\) FROM jira_issues_events WHERE issue_epic = \\$1").
+		WithArgs("PJ-10", from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "event_kind", "count"}).AddRow(from, "created", 3))
+
+	s := store.NewPGStore(db)
+	counts, err := s.GetDailyEventCounts(store.EventCountsScope{Epic: "PJ-10"}, from, to)
+	if err != nil {
+		t.Fatalf("unexpected error in `GetDailyEventCounts`: %s", err)
+	}
+	if len(counts) != 1 || counts[0].Kind != store.EventCreated || counts[0].Count != 3 {
+		t.Errorf("expected 3 created events, got %v", counts)
+	}
+	if _, err = s.GetDailyEventCounts(store.EventCountsScope{}, from, to); err == nil {
+		t.Errorf("expected an error for an empty scope")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_CreateTables(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("CREATE TABLE \"jira_issues_states\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_issues_events\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE UNIQUE INDEX \"jira_issues_states_issue_key_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE UNIQUE INDEX \"jira_issues_events_dedup_key_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS \"jira_issues_events_project_time_kind_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS \"jira_issues_events_epic_time_kind_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE \"jira_issue_links\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_issue_metrics\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_issue_status_times\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_weekly_stats\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"team_memberships\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_issue_watchers_daily\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"sync_runs\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"sync_progress\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_issue_description_revisions\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_boards\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_sprints\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_project_statuses\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_project_workflows\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_workflow_transitions\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_comment_vault\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("REVOKE ALL ON \"jira_comment_vault\" FROM PUBLIC").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"field_lineage\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"jira_users\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"jira_issue_comments\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS \"jira_issue_comments_issue_key_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, f := range [][2]string{{"jira_issue_labels", "label"}, {"jira_issue_components", "component"}, {"jira_issue_fix_versions", "fix_version"}} {
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"" + f[0] + "\" .*\"" + f[1] + "\" TEXT NOT NULL").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE INDEX IF NOT EXISTS \"" + f[0] + "_" + f[1] + "_idx\"").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"jira_assignee_intervals\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS \"jira_assignee_intervals_issue_key_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS \"jira_assignee_intervals_assignee_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"jira_wip_aging\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE UNIQUE INDEX IF NOT EXISTS \"jira_wip_aging_issue_key_day_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS \"jira_wip_aging_day_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"jira_status_aliases\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"jira_metrics_refreshes\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS \"jira_issues_events_inserted_at_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"jira_sprint_reports\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"jira_webhook_deliveries\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"jira_issues_states_history\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE UNIQUE INDEX IF NOT EXISTS \"jira_issues_states_history_current_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS \"jira_issues_states_history_issue_key_valid_from_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"sprint_goal_results\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"jira_quarantine\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE INDEX IF NOT EXISTS \"jira_quarantine_issue_key_idx\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"sync_locks\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE OR REPLACE FUNCTION jira_issues_as_of\\(TIMESTAMP\\)").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE OR REPLACE VIEW jira_epic_rollup").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE OR REPLACE VIEW jira_issue_ancestors").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE OR REPLACE VIEW jira_issue_key_aliases").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE OR REPLACE VIEW jira_issue_hierarchy").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE OR REPLACE VIEW jira_user_names").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE OR REPLACE VIEW jira_sprint_reconciliation").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("COMMENT ON COLUMN \"jira_issues_states\".\"issue_tribe\" IS 'Tribe''s name.'").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"schema_migrations\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(fmt.Sprintf("INSERT INTO schema_migrations \\(version, description\\) VALUES \\(1, .*\\(%d, .*ON CONFLICT", store.SchemaVersion)).
+		WillReturnResult(sqlmock.NewResult(0, int64(store.SchemaVersion)))
+
+	s := store.NewPGStore(db)
+	s.SetColumnComments([]store.ColumnComment{
+		{Table: "jira_issues_states", Column: "issue_tribe", Comment: "Tribe's name."},
+	})
+	if err = s.CreateTables(); err != nil {
+		t.Fatalf("unexpected error in `CreateTables`: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_Drop(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("DROP VIEW IF EXISTS jira_epic_rollup").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP VIEW IF EXISTS jira_issue_hierarchy").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP VIEW IF EXISTS jira_issue_ancestors").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP VIEW IF EXISTS jira_issue_key_aliases").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP VIEW IF EXISTS jira_user_names").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP VIEW IF EXISTS jira_sprint_reconciliation").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP FUNCTION IF EXISTS jira_issues_as_of\\(TIMESTAMP\\)").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_issues_states\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_issues_events\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_issue_links\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_issue_metrics\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_issue_status_times\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_weekly_stats\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"team_memberships\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_issue_watchers_daily\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"sync_runs\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"sync_progress\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_issue_description_revisions\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_sprints\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_boards\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_project_statuses\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_project_workflows\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_workflow_transitions\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_comment_vault\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"field_lineage\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_users\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_issue_comments\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, table := range []string{"jira_issue_labels", "jira_issue_components", "jira_issue_fix_versions", "jira_assignee_intervals", "jira_wip_aging", "jira_status_aliases", "jira_metrics_refreshes", "jira_sprint_reports", "jira_webhook_deliveries", "jira_issues_states_history", "sprint_goal_results", "jira_quarantine"} {
+		mock.ExpectExec("DROP TABLE IF EXISTS \"" + table + "\"").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_schema_version\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"schema_migrations\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	s := store.NewPGStore(db)
+	if err = s.DropTables(); err != nil {
+		t.Fatalf("unexpected error in `DropTables`: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_CollectOrphanedEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	// Relinked: the duplicates are deleted before the other events
+	// are moved, then the remaining orphans are deleted
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_issues_events e USING jira_issue_key_aliases a").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE jira_issues_events e SET issue_key = a.issue_key FROM jira_issue_key_aliases a").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM jira_issues_events e WHERE NOT EXISTS").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	r, err := s.CollectOrphanedEvents(true, false)
+	if err != nil {
+		t.Fatalf("unexpected error in `CollectOrphanedEvents`: %s", err)
+	}
+	if expected := (store.OrphanedEvents{Relinked: 3, Duplicates: 1, Deleted: 2}); r != expected {
+		t.Errorf("expected %+v, got %+v", expected, r)
+	}
+
+	// Dry run: the orphans are only counted
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_issues_events e WHERE NOT EXISTS").
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectRollback()
+
+	if r, err = s.CollectOrphanedEvents(false, true); err != nil {
+		t.Fatalf("unexpected error in `CollectOrphanedEvents`: %s", err)
+	}
+	if r.Deleted != 5 {
+		t.Errorf("expected 5 orphaned events, got %d", r.Deleted)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_MarkDeletedIssues(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	at := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	// PJ-2 was not found by the sync
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT issue_key FROM jira_issues_states WHERE issue_deleted_at IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"issue_key"}).AddRow("PJ-1").AddRow("PJ-2"))
+	mock.ExpectExec("UPDATE jira_issues_states SET issue_deleted_at = \\$1 WHERE issue_key = ANY").
+		WithArgs(at, pq.Array([]string{"PJ-2"})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dedupKey := store.IssueEvent{EventTime: at, EventKind: store.EventIssueDeleted, IssueKey: "PJ-2"}.DedupKey()
+	mock.ExpectExec("INSERT INTO jira_issues_events \\(event_time, event_kind, event_author, dedup_key, issue_key, issue_created_at, .*\\) SELECT .* FROM jira_issues_states s").
+		WithArgs(at, store.EventIssueDeleted, pq.Array([]string{"PJ-2"}), pq.Array([]string{dedupKey})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	count, err := s.MarkDeletedIssues([]string{"PJ-1", "PJ-3"}, at)
+	if err != nil {
+		t.Fatalf("unexpected error in `MarkDeletedIssues`: %s", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 issue to be marked as deleted, got %d", count)
+	}
+
+	// Nothing to mark
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT issue_key FROM jira_issues_states WHERE issue_deleted_at IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"issue_key"}).AddRow("PJ-1"))
+	mock.ExpectCommit()
+
+	if count, err = s.MarkDeletedIssues([]string{"PJ-1"}, at); err != nil || count != 0 {
+		t.Errorf("expected no issue to be marked as deleted, got %d (error: %v)", count, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_StrictSchemaPlan(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	// Disabled: no foreign key
+	plan, err := s.StrictSchemaPlan()
+	if err != nil || plan != nil {
+		t.Fatalf("expected no statement without strict schema, got %v (%v)", plan, err)
+	}
+
+	// Only the missing foreign keys are added, deferred
+	s.SetStrictSchema(true)
+	mock.ExpectQuery("SELECT conname FROM pg_constraint").
+		WillReturnRows(sqlmock.NewRows([]string{"conname"}).
+			AddRow("jira_issues_events_issue_key_fkey").
+			AddRow("jira_issue_links_source_key_fkey").
+			AddRow("jira_issue_description_revisions_issue_key_fkey").
+			AddRow("jira_issue_metrics_issue_key_fkey"))
+	if plan, err = s.StrictSchemaPlan(); err != nil {
+		t.Fatalf("unexpected error in `StrictSchemaPlan`: %s", err)
+	}
+	expected := []string{
+		`ALTER TABLE "jira_issue_status_times" ADD CONSTRAINT "jira_issue_status_times_issue_key_fkey" FOREIGN KEY ("issue_key") REFERENCES "jira_issues_states" ("issue_key") DEFERRABLE INITIALLY DEFERRED;`,
+		`ALTER TABLE "jira_comment_vault" ADD CONSTRAINT "jira_comment_vault_dedup_key_fkey" FOREIGN KEY ("dedup_key") REFERENCES "jira_issues_events" ("dedup_key") DEFERRABLE INITIALLY DEFERRED;`,
+		`ALTER TABLE "jira_issue_comments" ADD CONSTRAINT "jira_issue_comments_issue_key_fkey" FOREIGN KEY ("issue_key") REFERENCES "jira_issues_states" ("issue_key") DEFERRABLE INITIALLY DEFERRED;`,
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("expected %v, got %v", expected, plan)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_FullTextSearchPlan(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	// Disabled: no column
+	plan, err := s.FullTextSearchPlan()
+	if err != nil || plan != nil {
+		t.Fatalf("expected no statement without full-text search, got %v (%v)", plan, err)
+	}
+	if _, err = s.Search("payment", 10); err == nil {
+		t.Errorf("expected an error searching without full-text search")
+	}
+
+	// Only the missing columns are added, with their index
+	s.SetFullTextSearch("english")
+	mock.ExpectQuery("SELECT indexname FROM pg_indexes").
+		WillReturnRows(sqlmock.NewRows([]string{"indexname"}).AddRow("jira_issues_states_search_idx"))
+	if plan, err = s.FullTextSearchPlan(); err != nil {
+		t.Fatalf("unexpected error in `FullTextSearchPlan`: %s", err)
+	}
+	expected := []string{
+		`ALTER TABLE "jira_issue_comments" ADD COLUMN IF NOT EXISTS "body_search" tsvector GENERATED ALWAYS AS (setweight(to_tsvector('english', COALESCE("body", '')), 'B')) STORED;`,
+		`CREATE INDEX IF NOT EXISTS "jira_issue_comments_search_idx" ON "jira_issue_comments" USING GIN ("body_search");`,
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("expected %v, got %v", expected, plan)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_Search(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	s.SetFullTextSearch("english")
+
+	mock.ExpectQuery("SELECT issue_key, issue_summary, NULL::text, ts_rank\\(issue_search, q.query\\) .* UNION ALL .* FROM jira_issue_comments c").
+		WithArgs("english", "payment failed", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"issue_key", "issue_summary", "comment_id", "rank", "ts_headline"}).
+			AddRow("PJ-1", "Payment failed", nil, 0.6, "**Payment** **failed**").
+			AddRow("PJ-2", "Checkout", "10100", 0.3, "the **payment** **failed** again"))
+	rs, err := s.Search("payment failed", 10)
+	if err != nil {
+		t.Fatalf("unexpected error in `Search`: %s", err)
+	}
+	if len(rs) != 2 || rs[0].CommentID != nil || rs[1].CommentID == nil || *rs[1].CommentID != "10100" || rs[1].Rank != 0.3 {
+		t.Errorf("expected the issue PJ-1 and the comment 10100 of PJ-2, got %v", rs)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ReplaceTeamMemberships(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	tribe := "Commerce"
+	from := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM team_memberships").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO team_memberships").
+		WithArgs("alice", "Payments", "Commerce", from, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = s.ReplaceTeamMemberships([]store.TeamMembership{
+		{Person: "alice", Team: "Payments", Tribe: &tribe, From: &from},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in `ReplaceTeamMemberships`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ReplaceFieldLineage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM field_lineage").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO field_lineage").
+		WithArgs("jira_issues_states", "issue_tribe", "Tribe", "customfield_10800", "11", "Value of the select list.").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO field_lineage").
+		WithArgs("jira_issues_states", "issue_severity_bucket", nil, nil, "11", "Bucket of the priority.").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = s.ReplaceFieldLineage([]store.FieldLineage{
+		{Table: "jira_issues_states", Column: "issue_tribe", JiraField: stringAddr("Tribe"), JiraFieldID: stringAddr("customfield_10800"), MapperVersion: "11", Transformation: "Value of the select list."},
+		{Table: "jira_issues_states", Column: "issue_severity_bucket", MapperVersion: "11", Transformation: "Bucket of the priority."},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in `ReplaceFieldLineage`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ReplaceBoardsAndSprints(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	start := time.Date(2020, 3, 2, 9, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_sprints").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM jira_boards").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO jira_boards").
+		WithArgs(1, "Team A", "scrum").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO jira_sprints").
+		WithArgs(10, 1, "A 1", "active", &start, nil, nil, "Ship the checkout").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = s.ReplaceBoardsAndSprints(
+		[]store.Board{{ID: 1, Name: "Team A", Type: "scrum"}},
+		[]store.Sprint{{ID: 10, BoardID: 1, Name: "A 1", State: "active", StartDate: &start, Goal: "Ship the checkout"}},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error in `ReplaceBoardsAndSprints`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_GetSprintMembers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	resolved := time.Date(2020, 3, 10, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("WITH members AS (.+) UNION (.+) FROM jira_issues_events (.+) ORDER BY m.sprint_id, m.issue_key").
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"sprint_id", "issue_key", "issue_estimate_points", "issue_resolved_at"}).
+			AddRow(1, "PJ-1", 3.0, resolved).
+			AddRow(1, "PJ-2", nil, nil))
+
+	members, err := s.GetSprintMembers(42)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(members) != 2 || members[0].Points == nil || *members[0].Points != 3 || members[0].ResolvedAt == nil ||
+		members[1].IssueKey != "PJ-2" || members[1].Points != nil || members[1].ResolvedAt != nil {
+		t.Errorf("unexpected members %+v", members)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ReplaceUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_users").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO jira_users").
+		WithArgs("557058:alice", "alice", "Alice Liddell", "alice@example.com", true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO jira_users").
+		WithArgs("557058:bob", "bob", "Bob", nil, false).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = s.ReplaceUsers([]store.User{
+		{AccountID: "557058:alice", Name: "alice", DisplayName: "Alice Liddell", Email: stringAddr("alice@example.com"), Active: true},
+		{AccountID: "557058:bob", Name: "bob", DisplayName: "Bob"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in `ReplaceUsers`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ReplaceWorkflows(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	at := time.Date(2020, 3, 2, 9, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	// `Open` is unchanged, `Closed` was removed and `Done` added
+	mock.ExpectQuery("SELECT id, project_key, issue_type, status_id, status_name, status_category FROM jira_project_statuses WHERE valid_to IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "project_key", "issue_type", "status_id", "status_name", "status_category"}).
+			AddRow(1, "PJ", "Bug", "1", "Open", "new").
+			AddRow(2, "PJ", "Bug", "2", "Closed", "done"))
+	mock.ExpectExec("UPDATE jira_project_statuses SET valid_to = \\$1 WHERE id = ANY\\(\\$2\\)").
+		WithArgs(at, "{2}").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO jira_project_statuses").
+		WithArgs("PJ", "Bug", "3", "Done", "done", at).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT (.+) FROM jira_project_workflows WHERE valid_to IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "project_key", "issue_type", "workflow_scheme", "workflow"}))
+	mock.ExpectExec("INSERT INTO jira_project_workflows").
+		WithArgs("PJ", "Bug", "PJ Scheme", "Bug Workflow", at).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT (.+) FROM jira_workflow_transitions WHERE valid_to IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "workflow", "transition_id", "name", "from_status", "to_status"}).
+			AddRow(1, "Bug Workflow", "11", "Close", nil, "Done"))
+	mock.ExpectCommit()
+
+	err = s.ReplaceWorkflows(store.Workflows{
+		Statuses: []store.ProjectStatus{
+			{ProjectKey: "PJ", IssueType: "Bug", StatusID: "1", StatusName: "Open", StatusCategory: "new"},
+			{ProjectKey: "PJ", IssueType: "Bug", StatusID: "3", StatusName: "Done", StatusCategory: "done"},
+		},
+		Workflows: []store.ProjectWorkflow{
+			{ProjectKey: "PJ", IssueType: "Bug", WorkflowScheme: "PJ Scheme", Workflow: "Bug Workflow"},
+		},
+		Transitions: []store.WorkflowTransition{
+			{Workflow: "Bug Workflow", TransitionID: "11", Name: "Close", ToStatus: "Done"},
+		},
+	}, at)
+	if err != nil {
+		t.Fatalf("unexpected error in `ReplaceWorkflows`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_RecordWatchCount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	// 3 watchers were recorded before, 5 now: 2 were added
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT watchers FROM jira_issue_watchers_daily").
+		WithArgs("PJ-1", "2020-03-02").
+		WillReturnRows(sqlmock.NewRows([]string{"watchers"}).AddRow(3))
+	mock.ExpectExec("INSERT INTO jira_issue_watchers_daily").
+		WithArgs("PJ-1", "2020-03-02", 5, 2, 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = s.RecordWatchCount("PJ-1", time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC), 5)
+	if err != nil {
+		t.Fatalf("unexpected error in `RecordWatchCount`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSpoolingStore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spool.jsonl")
+	s := store.NewSpoolingStore(store.NewPGStore(db), path)
+	day := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	unreachable := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	// The DB is unreachable: both counts and the deletion of the
+	// issue are spooled
+	for i := 0; i < 3; i++ {
+		mock.ExpectBegin().WillReturnError(unreachable)
+	}
+	for _, count := range []int{4, 5} {
+		if err = s.RecordWatchCount("PJ-1", day, count); err != nil {
+			t.Fatalf("expected the count to be spooled, got error: %s", err)
+		}
+	}
+	if err = s.DeleteIssue("PJ-1"); err != nil {
+		t.Fatalf("expected the deletion to be spooled, got error: %s", err)
+	}
+	if _, err = os.Stat(path); err != nil {
+		t.Fatalf("expected the spool to be written: %s", err)
+	}
+
+	// The DB is back: the writes are replayed in order
+	for _, count := range []int{4, 5} {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT watchers FROM jira_issue_watchers_daily").
+			WillReturnRows(sqlmock.NewRows([]string{"watchers"}).AddRow(count - 1))
+		mock.ExpectExec("INSERT INTO jira_issue_watchers_daily").
+			WithArgs("PJ-1", "2020-03-02", count, 1, 0).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectBegin()
+	for _, table := range []string{"jira_issues_events", "jira_issues_states", "jira_issue_links", "jira_issue_description_revisions", "jira_issue_comments", "jira_issue_metrics", "jira_issue_status_times", "jira_issue_watchers_daily"} {
+		mock.ExpectExec("DELETE FROM " + table).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+	if err = s.Flush(); err != nil {
+		t.Fatalf("unexpected error in `Flush`: %s", err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the spool to be removed once flushed")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_SyncRuns(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	s.SetRunInfo(store.RunInfo{AppVersion: "v1.2.0", AppCommit: "abc123", MapperVersion: "16"})
+
+	// The build is recorded with the run
+	start := time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("INSERT INTO sync_runs").
+		WithArgs("incremental", store.SyncRunRunning, start, "v1.2.0", "abc123", "16", store.SchemaVersion, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec("UPDATE sync_runs").
+		WithArgs(7, store.SyncRunDone, anyTime{}, 12).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM sync_progress WHERE sync_run_id = \\$1").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectQuery("SELECT MAX\\(started_at\\) FROM sync_runs WHERE status = \\$1 AND kind = ANY\\(\\$2\\) AND filter_jql IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(start))
+
+	id, err := s.StartSyncRun("incremental", start, "")
+	if err != nil || id != 7 {
+		t.Fatalf("expected run 7 to be started, got %d (error: %v)", id, err)
+	}
+	if err = s.FinishSyncRun(id, time.Now(), 12); err != nil {
+		t.Fatalf("unexpected error in `FinishSyncRun`: %s", err)
+	}
+	last, err := s.GetLastSyncStart([]string{"full", "incremental"})
+	if err != nil || last == nil || !last.Equal(start) {
+		t.Errorf("expected last sync start %s, got %v (error: %v)", start, last, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_RecordSyncRunAPIUsage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	perIssue := 2.5
+	mock.ExpectExec("UPDATE sync_runs SET api_calls = \\$2, api_calls_by_endpoint = \\$3, api_throttled = \\$4, api_quota_used = \\$5, api_calls_per_issue = \\$6 WHERE id = \\$1").
+		WithArgs(7, 5, `{"GET /rest/api/2/issue/{key}":4,"GET /rest/api/2/search":1}`, 1, nil, &perIssue).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = s.RecordSyncRunAPIUsage(7, store.APIUsage{
+		Calls:           5,
+		CallsByEndpoint: map[string]int{"GET /rest/api/2/search": 1, "GET /rest/api/2/issue/{key}": 4},
+		Throttled:       1,
+		CallsPerIssue:   &perIssue,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in `RecordSyncRunAPIUsage`: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_MetricsRefreshes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	mock.ExpectQuery("SELECT MAX\\(started_at\\) FROM jira_metrics_refreshes WHERE finished_at IS NOT NULL AND fingerprint = \\$1").
+		WithArgs("abc").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(nil))
+	last, err := s.GetLastMetricsRefresh("abc")
+	if err != nil {
+		t.Fatalf("unexpected error in `GetLastMetricsRefresh`: %s", err)
+	}
+	if last != nil {
+		t.Errorf("expected no previous refresh, got %s", last)
+	}
+
+	since := time.Date(2020, 3, 2, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT DISTINCT issue_key FROM jira_issues_events WHERE inserted_at >= \\$1 UNION SELECT m.issue_key FROM jira_issue_metrics m").
+		WithArgs(since, store.FieldBlockedBy).
+		WillReturnRows(sqlmock.NewRows([]string{"issue_key"}).AddRow("PJ-1").AddRow("PJ-2"))
+	keys, err := s.GetChangedIssueKeys(since)
+	if err != nil {
+		t.Fatalf("unexpected error in `GetChangedIssueKeys`: %s", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"PJ-1", "PJ-2"}) {
+		t.Errorf("expected PJ-1 and PJ-2 to have changed, got %v", keys)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_weekly_stats w USING unnest\\(\\$1::TEXT\\[\\], \\$2::TEXT\\[\\]\\)").
+		WithArgs(`{"PJ"}`, `{"Bug"}`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+	if err = s.ReplaceGroupsWeeklyStats([]store.MetricsGroup{{Project: "PJ", Type: "Bug"}}, nil); err != nil {
+		t.Fatalf("unexpected error in `ReplaceGroupsWeeklyStats`: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ResumableSyncRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	mock.ExpectQuery("SELECT id, status FROM sync_runs WHERE kind = \\$1 ORDER BY started_at DESC LIMIT 1").
+		WithArgs("full").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(3, store.SyncRunDone))
+	mock.ExpectQuery("SELECT id, status FROM sync_runs").
+		WithArgs("full").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(4, store.SyncRunRunning))
+	mock.ExpectQuery("SELECT issue_key FROM sync_progress WHERE sync_run_id = \\$1").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"issue_key"}).AddRow("PJ-1").AddRow("PJ-2"))
+
+	if id, err := s.GetResumableSyncRun("full"); err != nil || id != 0 {
+		t.Errorf("expected no run to resume after a finished one, got %d (error: %v)", id, err)
+	}
+	id, err := s.GetResumableSyncRun("full")
+	if err != nil || id != 4 {
+		t.Fatalf("expected run 4 to be resumable, got %d (error: %v)", id, err)
+	}
+	keys, err := s.GetSyncedIssueKeys(id)
+	if err != nil || len(keys) != 2 {
+		t.Errorf("expected 2 synced issues, got %v (error: %v)", keys, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	s.SetBatchSize(2)
+	w := s.NewWriter()
+	w.SetSyncRun(5)
+
+	// The first batch fails and is kept, the second write includes
+	// the issue added since.
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_issues_events WHERE issue_key = ANY\\(\\$1\\)").
+		WithArgs(`{"A-1","A-2"}`).
+		WillReturnError(errors.New("deadlock detected"))
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	for _, table := range []string{"jira_issues_events", "jira_issues_states", "jira_issue_links", "jira_issue_description_revisions"} {
+		mock.ExpectExec("DELETE FROM " + table).
+			WithArgs(`{"A-1","A-2","A-3"}`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	states := mock.ExpectPrepare("COPY \"jira_issues_states\"")
+	for i := 0; i < 4; i++ {
+		states.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	links := mock.ExpectPrepare("COPY \"jira_issue_links\"")
+	links.ExpectExec().WithArgs("A-1", "A-2", "Blocks", "outward").WillReturnResult(sqlmock.NewResult(0, 0))
+	links.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	revisions := mock.ExpectPrepare("COPY \"jira_issue_description_revisions\"")
+	revisions.ExpectExec().WithArgs("A-3", anyTime{}, "alice", nil, "Steps").WillReturnResult(sqlmock.NewResult(0, 0))
+	revisions.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	events := mock.ExpectPrepare("COPY \"jira_issues_events\"")
+	for i := 0; i < 2; i++ {
+		events.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("INSERT INTO sync_progress").
+		WithArgs(5, `{"A-1","A-2","A-3"}`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	link := store.IssueLink{SourceKey: "A-1", TargetKey: "A-2", LinkType: "Blocks", Direction: "outward"}
+	if err = w.Add("A-1", withRequired(store.IssueState{Key: "A-1"}), nil); err != nil {
+		t.Fatalf("unexpected error adding A-1: %s", err)
+	}
+	// Adding an issue again replaces its records without growing the
+	// batch.
+	if err = w.Add("A-1", withRequired(store.IssueState{Key: "A-1", Links: []store.IssueLink{link}}), nil); err != nil {
+		t.Fatalf("unexpected error adding A-1 again: %s", err)
+	}
+	err = w.Add("A-2", withRequired(store.IssueState{Key: "A-2"}), []store.IssueEvent{{EventKind: "created", IssueKey: "A-2"}})
+	if err == nil {
+		t.Fatalf("expected an error writing the first batch")
+	}
+	if w.Pending() != 2 {
+		t.Fatalf("expected the failed batch to be kept, got %d pending issues", w.Pending())
+	}
+	revision := store.DescriptionRevision{IssueKey: "A-3", RevisedAt: time.Now(), Author: "alice", To: stringAddr("Steps")}
+	if err = w.Add("A-3", withRequired(store.IssueState{Key: "A-3", DescriptionRevisions: []store.DescriptionRevision{revision}}), nil); err != nil {
+		t.Fatalf("unexpected error writing the second batch: %s", err)
+	}
+	if w.Pending() != 0 {
+		t.Errorf("expected no pending issues, got %d", w.Pending())
+	}
+	if err = w.Flush(); err != nil {
+		t.Errorf("unexpected error flushing an empty batch: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWriter_quarantine(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	s.SetQuarantine(true)
+	s.SetBatchSize(3)
+	w := s.NewWriter()
+	w.SetSyncRun(7)
+	var handled []store.IssueEvent
+	s.SetEventHandler(func(ie store.IssueEvent) error {
+		handled = append(handled, ie)
+		return nil
+	})
+
+	// B-1 was moved to A-1, so its state is a duplicate of the first
+	// one, and an event of A-2 is already stored (e.g. by an issue
+	// moved since): they are quarantined and the others written.
+	stored := store.IssueEvent{EventKind: "comment_added", IssueKey: "A-2", CommentBody: stringAddr("Hi")}
+	created := store.IssueEvent{EventKind: "created", IssueKey: "A-2"}
+	mock.ExpectBegin()
+	for _, table := range []string{"jira_issues_events", "jira_issues_states", "jira_issue_links", "jira_issue_description_revisions"} {
+		mock.ExpectExec("DELETE FROM " + table).
+			WithArgs(`{"A-1","B-1","A-2"}`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectQuery("SELECT issue_key FROM jira_issues_states WHERE issue_key = ANY\\(\\$1\\)").
+		WithArgs(`{"A-1","A-1","A-2"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"issue_key"}))
+	quarantine := mock.ExpectPrepare("COPY \"jira_quarantine\"")
+	quarantine.ExpectExec().
+		WithArgs("jira_issues_states", "A-1", "A-1", 7, store.QuarantineDuplicate, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	quarantine.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT dedup_key FROM jira_issues_events WHERE dedup_key = ANY\\(\\$1\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"dedup_key"}).AddRow(stored.DedupKey()))
+	quarantine = mock.ExpectPrepare("COPY \"jira_quarantine\"")
+	quarantine.ExpectExec().
+		WithArgs("jira_issues_events", stored.DedupKey(), "A-2", 7, store.QuarantineStored, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	quarantine.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	states := mock.ExpectPrepare("COPY \"jira_issues_states\"")
+	for i := 0; i < 3; i++ {
+		states.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	events := mock.ExpectPrepare("COPY \"jira_issues_events\"")
+	for i := 0; i < 2; i++ {
+		events.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("INSERT INTO sync_progress").
+		WithArgs(7, `{"A-1","B-1","A-2"}`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	w.Add("A-1", withRequired(store.IssueState{Key: "A-1"}), nil)
+	w.Add("B-1", withRequired(store.IssueState{Key: "A-1"}), nil)
+	if err = w.Add("A-2", withRequired(store.IssueState{Key: "A-2"}), []store.IssueEvent{created, stored}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if w.Pending() != 0 {
+		t.Errorf("expected no pending issues, got %d", w.Pending())
+	}
+	if len(handled) != 1 || handled[0].EventKind != "created" {
+		t.Errorf("expected only the written event to be handled, got %v", handled)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWriter_InsertStrategy(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	if err = s.SetInsertStrategy("bulk"); err == nil {
+		t.Errorf("expected an error for an unknown strategy")
+	}
+	w := s.NewWriter()
+
+	expectDeletes := func() {
+		mock.ExpectBegin()
+		for _, table := range []string{"jira_issues_events", "jira_issues_states", "jira_issue_links", "jira_issue_description_revisions"} {
+			mock.ExpectExec("DELETE FROM " + table).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+	}
+	// COPY is rejected, so the batch is written again with multi-row
+	// INSERTs
+	expectDeletes()
+	mock.ExpectPrepare("COPY \"jira_issues_states\"").
+		WillReturnError(&pq.Error{Code: "0A000", Message: "COPY is not supported"})
+	mock.ExpectRollback()
+	expectDeletes()
+	mock.ExpectExec("INSERT INTO jira_issues_states \\(.*\\) VALUES \\(.*\\), \\(.*\\);").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO jira_issues_events \\(.*\\) VALUES \\(.*\\);").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w.Add("A-1", withRequired(store.IssueState{Key: "A-1"}), nil)
+	w.Add("A-2", withRequired(store.IssueState{Key: "A-2"}), []store.IssueEvent{{EventKind: "created", IssueKey: "A-2"}})
+	if err = w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if st := s.InsertStrategy(); st != store.InsertValues {
+		t.Errorf("expected the store to fall back to `%s`, got `%s`", store.InsertValues, st)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_SetPoolerCompatible(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	s.SetPoolerCompatible(true)
+	if st := s.InsertStrategy(); st != store.InsertValues {
+		t.Errorf("expected the default strategy to be `%s`, got `%s`", store.InsertValues, st)
+	}
+
+	// The statements are executed without being prepared
+	s.SetInsertStrategy(store.InsertStatements)
+	w := s.NewWriter()
+	mock.ExpectBegin()
+	for _, table := range []string{"jira_issues_events", "jira_issues_states", "jira_issue_links", "jira_issue_description_revisions"} {
+		mock.ExpectExec("DELETE FROM " + table).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	for i := 0; i < 2; i++ {
+		mock.ExpectExec("INSERT INTO jira_issues_states \\(.*\\) VALUES \\([^)]*\\);").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+	w.Add("A-1", withRequired(store.IssueState{Key: "A-1"}), nil)
+	w.Add("A-2", withRequired(store.IssueState{Key: "A-2"}), nil)
+	if err = w.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	for connStr, expected := range map[string]string{
+		"dbname=db":               "dbname=db binary_parameters=yes",
+		"postgres://h/db":         "postgres://h/db?binary_parameters=yes",
+		"postgres://h/db?ssl=off": "postgres://h/db?ssl=off&binary_parameters=yes",
+	} {
+		if got := store.WithPoolerCompatibility(connStr); got != expected {
+			t.Errorf("expected `%s`, got `%s`", expected, got)
+		}
+	}
+}
+
+func TestPGStore_LoadTest(t *testing.T) {
+	for _, tc := range []struct {
+		strategy string
+		expect   func(mock sqlmock.Sqlmock)
+	}{
+		{store.LoadTestSingle, func(mock sqlmock.Sqlmock) {
+			for i := 0; i < 3; i++ {
+				mock.ExpectExec("INSERT INTO jira_issues_events").WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+		}},
+		{store.LoadTestBatch, func(mock sqlmock.Sqlmock) {
+			for _, n := range []int{2, 1} {
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO jira_issues_events").WillReturnResult(sqlmock.NewResult(0, int64(n)))
+				mock.ExpectCommit()
+			}
+		}},
+		{store.LoadTestCopy, func(mock sqlmock.Sqlmock) {
+			for _, n := range []int{2, 1} {
+				mock.ExpectBegin()
+				events := mock.ExpectPrepare("COPY \"jira_issues_events\"")
+				for i := 0; i <= n; i++ {
+					events.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+				}
+				mock.ExpectCommit()
+			}
+		}},
+	} {
+		t.Run(tc.strategy, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+			s := store.NewPGStore(db)
+
+			// The events of previous load tests are deleted before and
+			// after
+			mock.ExpectExec("DELETE FROM jira_issues_events WHERE issue_key LIKE").
+				WithArgs("LOADTEST-%").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			tc.expect(mock)
+			mock.ExpectExec("DELETE FROM jira_issues_events WHERE issue_key LIKE").
+				WithArgs("LOADTEST-%").
+				WillReturnResult(sqlmock.NewResult(0, 3))
+
+			r, err := s.LoadTest(store.LoadTestOptions{Strategy: tc.strategy, Events: 3, BatchSize: 2})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			writes := 2
+			if tc.strategy == store.LoadTestSingle {
+				writes = 3
+			}
+			if r.Events != 3 || r.Writes != writes || r.Max < r.P50 || r.EventsPerSecond() <= 0 {
+				t.Errorf("unexpected result %+v", r)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+
+	s := store.NewPGStore(nil)
+	if _, err := s.LoadTest(store.LoadTestOptions{Strategy: "bulk"}); err == nil {
+		t.Errorf("expected an error for an unknown strategy")
+	}
+	if _, err := s.LoadTest(store.LoadTestOptions{Strategy: store.LoadTestBatch, BatchSize: 10000}); err == nil {
+		t.Errorf("expected an error for a batch too large for an INSERT")
+	}
+}
+
+func TestThrottle_Wait(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	th := store.NewThrottle(db, store.ThrottleOptions{
+		MaxRowsPerSecond:  1000,
+		MaxReplicationLag: time.Second,
+		PauseDuration:     time.Millisecond,
+	})
+
+	// The replication lag is over the limit on the first check, so
+	// writes are paused until the second one.
+	mock.ExpectQuery("FROM pg_stat_replication").
+		WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(5.0))
+	mock.ExpectQuery("FROM pg_stat_replication").
+		WillReturnRows(sqlmock.NewRows([]string{"lag"}).AddRow(0.5))
+
+	start := time.Now()
+	th.Wait(50) // no wait for the rate, the next call will
+	th.Wait(50) // wait 50ms (50 rows at 1000 rows/s)
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("expected writes to be throttled to 1000 rows/s, 100 rows took %s", d)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// withRequired sets the required columns of the state (see
+// `store.IssueState.Validate`).
+func withRequired(is store.IssueState) store.IssueState {
+	is.Project, is.Status, is.Priority = stringAddr("project"), stringAddr("status"), stringAddr("priority")
+	is.Summary, is.Type = stringAddr("summary"), stringAddr("type")
+	return is
+}
+
+func mockIssueState() store.IssueState {
+	return store.IssueState{
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+		Key:              "key",
+		Project:          stringAddr("project"),
+		Status:           stringAddr("status"),
+		StatusCategory:   stringAddr("status_category"),
+		ResolvedAt:       timeAddr(time.Now()),
+		Priority:         stringAddr("priority"),
+		Summary:          stringAddr("summary"),
+		Description:      stringAddr("description"),
+		Type:             stringAddr("type"),
+		Labels:           stringAddr("labels"),
+		Reporter:         stringAddr("reporter"),
+		Assignee:         stringAddr("assignee"),
+		Epic:             stringAddr("epic"),
+		Sprints:          stringAddr("sprints"),
+		EpicName:         stringAddr("epic_name"),
+		EpicColor:        stringAddr("epic_color"),
+		ClonedFromKey:    stringAddr("cloned_from_key"),
+		MovedFromProject: stringAddr("moved_from_project"),
+		Source:           stringAddr("source"),
+		SeverityBucket:   stringAddr("severity_bucket"),
+		Components:       stringAddr("components"),
+		FixVersions:      stringAddr("fix_versions"),
+
+		AssigneeAccountID: stringAddr("assignee_account_id"),
+		Links: []store.IssueLink{
+			{SourceKey: "key", TargetKey: "other_key", LinkType: "Blocks", Direction: store.LinkOutward},
+		},
+	}
+}
+
+func mockIssueEvent() store.IssueEvent {
+	return store.IssueEvent{
+		EventTime:          time.Now(),
+		EventKind:          store.EventStatusChanged,
+		EventAuthor:        "author",
+		IssueKey:           "key",
+		CommentBody:        stringAddr("comment"),
+		StatusChangeFrom:   stringAddr("status_from"),
+		StatusChangeTo:     stringAddr("status_to"),
+		StatusChangeReason: stringAddr("reason"),
+		AssigneeChangeFrom: stringAddr("assignee_from"),
+		AssigneeChangeTo:   stringAddr("assignee_to"),
+
+		EventAuthorAccountID:        stringAddr("author_account_id"),
+		AssigneeChangeFromAccountID: stringAddr("assignee_from_account_id"),
+		AssigneeChangeToAccountID:   stringAddr("assignee_to_account_id"),
+	}
+}
+
+type anyTime struct{}
+
+// capturedArg matches any argument and records it.
+type capturedArg struct {
+	value driver.Value
+}
+
+// Match satisfies sqlmock.Argument interface
+func (a *capturedArg) Match(v driver.Value) bool {
+	a.value = v
+	return true
+}
+
+// Match satisfies sqlmock.Argument interface
+func (a anyTime) Match(v driver.Value) bool {
+	_, ok := v.(time.Time)
+	return ok
+}
+
+func stringAddr(s string) *string {
+	return &s
+}
+
+func timeAddr(t time.Time) *time.Time {
+	return &t
+}
+
+func floatAddr(f float64) *float64 {
+	return &f
+}
+
+type pingerMock struct {
+	failures int
+	pings    int
+}
+
+func (p *pingerMock) Ping() error {
+	p.pings++
+	if p.pings <= p.failures {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestWaitForDB(t *testing.T) {
+	p := &pingerMock{failures: 2}
+	err := store.WaitForDB(p, store.WaitOptions{Timeout: time.Second, InitialBackoff: time.Millisecond})
+	if err != nil {
+		t.Errorf("expected the DB to be reached, got error: %s", err)
+	}
+	if p.pings != 3 {
+		t.Errorf("expected 3 pings, got %d", p.pings)
+	}
+
+	p = &pingerMock{failures: 1000}
+	err = store.WaitForDB(p, store.WaitOptions{Timeout: 20 * time.Millisecond, InitialBackoff: time.Millisecond})
+	if err == nil {
+		t.Errorf("expected an error when the DB is unreachable after the timeout")
+	}
+}
+
+func TestEventKind_IsValid(t *testing.T) {
+	for _, info := range store.EventKinds() {
+		if !info.Kind.IsValid() {
+			t.Errorf("expected `%s` to be valid", info.Kind)
+		}
+	}
+	if store.EventKind("status_change").IsValid() {
+		t.Errorf("expected `status_change` to be invalid")
+	}
+}
+
+func TestMigrationPlan(t *testing.T) {
+	if q := store.MigrationPlan(store.SchemaVersion); len(q) != 0 {
+		t.Errorf("expected no statement for an up-to-date schema, got %v", q)
+	}
+
+	changes := store.SchemaChanges(store.SchemaVersion - 1)
+	if len(changes) != 1 || changes[0].Version != store.SchemaVersion {
+		t.Fatalf("expected only the last change, got %v", changes)
+	}
+	q := store.MigrationPlan(store.SchemaVersion - 1)
+	last := q[len(q)-1]
+	if len(q) != len(changes[0].Statements)+2 || !strings.HasPrefix(last, "INSERT INTO schema_migrations") || !strings.Contains(last, fmt.Sprintf("(%d, ", store.SchemaVersion)) {
+		t.Errorf("expected the change's statements followed by its recording, got %v", q)
+	}
+}
+
+func TestPGStore_MigrateUp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// Schema at the previous version, recorded in `jira_schema_version`
+	mock.ExpectQuery("SELECT to_regclass").
+		WillReturnRows(sqlmock.NewRows([]string{"m", "v", "s"}).AddRow(false, true, true))
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(version\\), 1\\) FROM jira_schema_version").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(store.SchemaVersion - 1))
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS \"schema_migrations\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(fmt.Sprintf("INSERT INTO schema_migrations .*\\(%d, ", store.SchemaVersion-1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	for range store.SchemaChanges(store.SchemaVersion - 1)[0].Statements {
+		mock.ExpectExec(".*").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec(fmt.Sprintf("INSERT INTO schema_migrations .*\\(%d, ", store.SchemaVersion)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	for range []string{"jira_issues_states", "jira_issues_events"} {
+		mock.ExpectQuery("SELECT column_name FROM information_schema.columns").
+			WillReturnRows(sqlmock.NewRows([]string{"column_name"}))
+	}
+
+	s := store.NewPGStore(db)
+	applied, err := s.MigrateUp()
+	if err != nil {
+		t.Fatalf("unexpected error in `MigrateUp`: %s", err)
+	}
+	if len(applied) != 1 || applied[0].Version != store.SchemaVersion {
+		t.Errorf("expected only the last change to be applied, got %v", applied)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSchemaChanges_issuesAsOf(t *testing.T) {
+	changes := store.SchemaChanges(50)
+	if len(changes) == 0 || changes[0].Version != 51 || len(changes[0].Statements) != 1 {
+		t.Fatalf("expected change 51 to recreate `jira_issues_as_of`, got %v", changes)
+	}
+	q := changes[0].Statements[0]
+	if !strings.HasPrefix(q, "CREATE OR REPLACE FUNCTION jira_issues_as_of(TIMESTAMP)") {
+		t.Fatalf("unexpected statement: %s", q)
+	}
+
+	// Before its first change, an issue has the value changed by it,
+	// or its current value if it never changed
+	for _, field := range []string{"status", "assignee"} {
+		if !strings.Contains(q, fmt.Sprintf("CASE WHEN e.event_time <= $1 THEN e.%s_change_to ELSE e.%s_change_from END", field, field)) {
+			t.Errorf("expected the %s to fall back to the first change after the passed time", field)
+		}
+		if !strings.Contains(q, fmt.Sprintf("ELSE s.issue_%s END", field)) {
+			t.Errorf("expected the %s to fall back to the current state", field)
+		}
+	}
+
+	// The issues deleted at the passed time are excluded
+	if !strings.Contains(q, "AND (s.issue_deleted_at IS NULL OR s.issue_deleted_at > $1)") {
+		t.Errorf("expected the deleted issues to be excluded")
+	}
+}
+
+func TestKeyRenames_Normalize(t *testing.T) {
+	r := store.KeyRenames{"OLD": "MID", "MID": "NEW", "A_B": "AB"}
+	cases := map[string]string{
+		"OLD-12": "NEW-12",
+		"MID-3":  "NEW-3",
+		"A_B-1":  "AB-1",
+		"PJ-1":   "PJ-1",
+		"OLD":    "OLD",
+		"OLDER-": "OLDER-",
+	}
+	for key, expected := range cases {
+		if k := r.Normalize(key); k != expected {
+			t.Errorf("expected `%s` to be normalized to `%s`, got `%s`", key, expected, k)
+		}
+	}
+	if k := (store.KeyRenames{"A": "B", "B": "A"}).Normalize("A-1"); k != "A-1" && k != "B-1" {
+		t.Errorf("expected circular renames to terminate, got `%s`", k)
+	}
+}
+
+func TestPGStore_GetWeeklyThroughput(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	from, to := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	week := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM jira_issue_metrics WHERE done_at >= \\$1 AND done_at < \\$2 AND issue_type = \\$3 GROUP BY 1").
+		WithArgs(from, to, "Bug").
+		WillReturnRows(sqlmock.NewRows([]string{"week", "count"}).AddRow(week, 4))
+
+	points, err := s.GetWeeklyThroughput(store.TimeSeriesScope{Type: "Bug"}, from, to)
+	if err != nil {
+		t.Fatalf("unexpected error in `GetWeeklyThroughput`: %s", err)
+	}
+	if len(points) != 1 || !points[0].Time.Equal(week) || points[0].Value != 4 {
+		t.Errorf("expected 4 issues done the week of %s, got %v", week, points)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_GetPersonData(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	s.SetCustomColumns([]store.CustomColumn{{Name: "reviewer", Type: store.CustomColumnText, User: true}})
+
+	// "al" is also the name of another account: it's only matched on
+	// the rows recording the account ID
+	since := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("WITH user_names AS \\((.+)\\) SELECT name, COUNT\\(DISTINCT account_id\\) = 1").
+		WithArgs("acc-1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "unique"}).AddRow("al", false).AddRow("alice", true))
+	mentions := `{"%[~accountid:acc-1]%","%[~alice]%"}`
+	for _, table := range []string{
+		"jira_users", "team_memberships", "jira_issues_states", "jira_comment_vault", "jira_issues_events", "jira_issue_comments",
+		"jira_assignee_intervals", "jira_issue_description_revisions", "jira_issues_states_history", "jira_quarantine",
+	} {
+		query := "SELECT row_to_json\\(t\\)::TEXT FROM " + table + " t WHERE"
+		switch table {
+		case "jira_issues_events":
+			query += " \\(event_author_account_id = \\$1 OR \\(event_author_account_id IS NULL AND event_author = ANY\\(\\$2\\)\\) (.+) OR reviewer = ANY\\(\\$2\\) OR comment_body LIKE ANY\\(\\$3\\) (.+)\\) AND event_time >= \\$4 ORDER BY event_time, id"
+		case "jira_comment_vault":
+			query += " \\(event_author = ANY\\(\\$1\\) OR dedup_key IN \\(SELECT dedup_key FROM jira_issues_events WHERE event_author_account_id = \\$2\\)\\)"
+		case "jira_quarantine":
+			query += " \\(\\(table_name = 'jira_issues_states' AND \\(record->>'issue_assignee_account_id' = \\$1 (.+) OR record->>'reviewer' = ANY\\(\\$2\\) (.+)\\)\\) OR \\(table_name = 'jira_issues_events' AND (.+)\\)\\) AND quarantined_at >= \\$7"
+		}
+		q := mock.ExpectQuery(query)
+		rows := sqlmock.NewRows([]string{"row_to_json"})
+		switch table {
+		case "jira_users":
+			q.WithArgs("acc-1")
+			rows.AddRow(`{"account_id":"acc-1","name":"alice"}`)
+		case "team_memberships":
+			q.WithArgs(`{"alice"}`)
+		case "jira_issues_events":
+			q.WithArgs("acc-1", `{"alice"}`, mentions, since)
+			rows.AddRow(`{"event_kind":"comment_added","event_author":"alice"}`)
+		case "jira_quarantine":
+			q.WithArgs("acc-1", `{"alice"}`, mentions, "acc-1", `{"alice"}`, mentions, since)
+			rows.AddRow(`{"table_name":"jira_issues_states","record":{"reviewer":"alice"}}`)
+		}
+		q.WillReturnRows(rows)
+	}
+
+	pd, err := s.GetPersonData("acc-1", &since, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(pd.Names, []string{"alice"}) || !reflect.DeepEqual(pd.AmbiguousNames, []string{"al"}) {
+		t.Errorf("expected the names of the account, got %v and ambiguous %v", pd.Names, pd.AmbiguousNames)
+	}
+	if len(pd.Tables) != 10 || len(pd.Tables["jira_users"]) != 1 || len(pd.Tables["jira_issues_events"]) != 1 || len(pd.Tables["jira_quarantine"]) != 1 || pd.Tables["jira_issues_states"] == nil {
+		t.Errorf("unexpected tables %v", pd.Tables)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ErasePersonData(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+	s.SetCustomColumns([]store.CustomColumn{{Name: "reviewer", Type: store.CustomColumnText, User: true}})
+
+	// The names shared with other accounts ("al") are only anonymized
+	// on the rows recording the account ID
+	mock.ExpectBegin()
+	mock.ExpectQuery("WITH user_names AS \\((.+)\\) SELECT name, COUNT\\(DISTINCT account_id\\) = 1").
+		WithArgs("acc-1").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "unique"}).AddRow("al", false).AddRow("alice", true))
+	exec := func(query string, args ...driver.Value) {
+		e := mock.ExpectExec(query)
+		if len(args) > 0 {
+			e.WithArgs(args...)
+		}
+		e.WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	account := func(table string, pairs ...[2]string) {
+		for _, p := range pairs {
+			exec(fmt.Sprintf("UPDATE %s SET %s = \\$1, %s = \\$1 WHERE %s = \\$2", table, p[0], p[1], p[0]), sqlmock.AnyArg(), "acc-1")
+		}
+	}
+	name := func(table string, pairs ...[2]string) {
+		for _, p := range pairs {
+			q := fmt.Sprintf("UPDATE %s SET %s = \\$1 WHERE %s = ANY\\(\\$2\\)", table, p[1], p[1])
+			if p[0] != "" {
+				q += fmt.Sprintf(" AND %s IS NULL", p[0])
+			}
+			exec(q+";", sqlmock.AnyArg(), `{"alice"}`)
+		}
+	}
+	text := func(table string, columns ...string) {
+		for _, c := range columns {
+			exec(fmt.Sprintf("UPDATE %s SET %s = REPLACE\\(%s, \\$1, \\$2\\)", table, c, c), "[~accountid:acc-1]", sqlmock.AnyArg(), "%[~accountid:acc-1]%")
+			exec(fmt.Sprintf("UPDATE %s SET %s = REPLACE\\(%s, \\$1, \\$2\\)", table, c, c), "[~alice]", sqlmock.AnyArg(), "%[~alice]%")
+		}
+	}
+	assignee := [2]string{"issue_assignee_account_id", "issue_assignee"}
+	reviewer := [2]string{"", "reviewer"}
+	events := [][2]string{
+		{"event_author_account_id", "event_author"},
+		{"assignee_change_from_account_id", "assignee_change_from"},
+		{"assignee_change_to_account_id", "assignee_change_to"},
+		assignee,
+	}
+	exec("DELETE FROM jira_users WHERE \\(account_id = \\$1\\)", "acc-1")
+	name("team_memberships", [2]string{"", "person"})
+	account("jira_issues_states", assignee)
+	name("jira_issues_states", assignee, reviewer)
+	text("jira_issues_states", "issue_description")
+	// The vaulted comments are tied to the account by their events,
+	// so they're anonymized first
+	exec("UPDATE jira_comment_vault SET event_author = \\$1 WHERE dedup_key IN \\(SELECT dedup_key FROM jira_issues_events WHERE event_author_account_id = \\$2\\)", sqlmock.AnyArg(), "acc-1")
+	name("jira_comment_vault", [2]string{"", "event_author"})
+	account("jira_issues_events", events...)
+	name("jira_issues_events", append(events, reviewer)...)
+	text("jira_issues_events", "comment_body", "issue_description")
+	account("jira_issue_comments", [2]string{"author_account_id", "author"})
+	name("jira_issue_comments", [2]string{"author_account_id", "author"})
+	text("jira_issue_comments", "body")
+	account("jira_assignee_intervals", [2]string{"assignee_account_id", "assignee"})
+	name("jira_assignee_intervals", [2]string{"assignee_account_id", "assignee"})
+	name("jira_issue_description_revisions", [2]string{"", "author"})
+	text("jira_issue_description_revisions", "description_from", "description_to")
+	name("jira_issues_states_history", [2]string{"", "issue_assignee"})
+	exec("DELETE FROM jira_quarantine WHERE \\(\\(table_name = 'jira_issues_states' AND (.+) record->>'reviewer' = ANY\\(\\$2\\) (.+)\\)\\)",
+		"acc-1", `{"alice"}`, `{"%[~accountid:acc-1]%","%[~alice]%"}`, "acc-1", `{"alice"}`, `{"%[~accountid:acc-1]%","%[~alice]%"}`)
+	mock.ExpectCommit()
+
+	pseudonym, err := s.ErasePersonData("acc-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.HasPrefix(pseudonym, "erased-") {
+		t.Errorf("unexpected pseudonym %q", pseudonym)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
@@ -0,0 +1,84 @@
+// Package storetest provides the helpers of the tests running
+// against a Postgres DB. Each test gets its own temporary schema, so
+// the tests can run in parallel against a single DB without
+// interfering.
+//
+// The tests are skipped unless `TEST_DB_URL` is set to the
+// connection string of the DB, e.g.:
+//
+//	TEST_DB_URL="postgres://postgres@localhost/kaizenizer_test?sslmode=disable" go test ./...
+package storetest
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// DBURLEnv is the environment variable of the connection string of
+// the DB the tests run against.
+const DBURLEnv = "TEST_DB_URL"
+
+// NewDB returns a connection to the DB of `TEST_DB_URL` using a new
+// temporary schema (see `store.WithSearchPath`), with the migrations
+// applied (see `store.PGStore.MigrateUp`). The schema is dropped
+// with all its records when the test and its subtests complete.
+// Skips the test if `TEST_DB_URL` is not set.
+func NewDB(t testing.TB) *sql.DB {
+	t.Helper()
+	connStr := os.Getenv(DBURLEnv)
+	if connStr == "" {
+		t.Skipf("%s is not set", DBURLEnv)
+	}
+	admin, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatalf("error opening the test DB: %s", err)
+	}
+	schema, err := schemaName()
+	if err != nil {
+		admin.Close()
+		t.Fatalf("error naming the test schema: %s", err)
+	}
+	if _, err = admin.Exec(`CREATE SCHEMA "` + schema + `";`); err != nil {
+		admin.Close()
+		t.Fatalf("error creating the test schema: %s", err)
+	}
+
+	db, err := sql.Open("postgres", store.WithSearchPath(connStr, schema))
+	t.Cleanup(func() {
+		if db != nil {
+			db.Close()
+		}
+		if _, err := admin.Exec(`DROP SCHEMA "` + schema + `" CASCADE;`); err != nil {
+			t.Errorf("error dropping the test schema `%s`: %s", schema, err)
+		}
+		admin.Close()
+	})
+	if err != nil {
+		t.Fatalf("error opening the test DB: %s", err)
+	}
+	if _, err = store.NewPGStore(db).MigrateUp(); err != nil {
+		t.Fatalf("error migrating the test schema: %s", err)
+	}
+	return db
+}
+
+// NewStore returns a store on a new temporary schema of the DB of
+// `TEST_DB_URL` (see `NewDB`).
+func NewStore(t testing.TB) *store.PGStore {
+	t.Helper()
+	return store.NewPGStore(NewDB(t))
+}
+
+// schemaName returns a random name for a test schema.
+func schemaName() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "test_" + hex.EncodeToString(b), nil
+}
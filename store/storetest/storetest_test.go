@@ -0,0 +1,34 @@
+package storetest_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+// TestNewStore checks that the stores of parallel tests are isolated:
+// each one only sees the records it wrote.
+func TestNewStore(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		person := fmt.Sprintf("person-%d", i)
+		t.Run(person, func(t *testing.T) {
+			t.Parallel()
+			s := storetest.NewStore(t)
+			if v, err := s.RecordedSchemaVersion(); err != nil || v != store.SchemaVersion {
+				t.Fatalf("expected the schema at version %d, got %d (%v)", store.SchemaVersion, v, err)
+			}
+			if err := s.ReplaceTeamMemberships([]store.TeamMembership{{Team: "core", Person: person}}); err != nil {
+				t.Fatal(err)
+			}
+			tms, err := s.GetTeamMemberships("core")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tms) != 1 || tms[0].Person != person {
+				t.Errorf("expected only the membership of `%s`, got %+v", person, tms)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"fmt"
+)
+
+// foreignKey is a foreign key of the strict schema (see
+// `SetStrictSchema`).
+type foreignKey struct {
+	name             string
+	table, column    string
+	refTable, refCol string
+}
+
+// strictSchemaForeignKeys are the foreign keys created in
+// strict-schema mode: the records of an issue reference its state,
+// and the comments of the vault their event.
+var strictSchemaForeignKeys = []foreignKey{
+	{"jira_issues_events_issue_key_fkey", "jira_issues_events", "issue_key", "jira_issues_states", "issue_key"},
+	{"jira_issue_links_source_key_fkey", "jira_issue_links", "source_key", "jira_issues_states", "issue_key"},
+	{"jira_issue_description_revisions_issue_key_fkey", "jira_issue_description_revisions", "issue_key", "jira_issues_states", "issue_key"},
+	{"jira_issue_metrics_issue_key_fkey", "jira_issue_metrics", "issue_key", "jira_issues_states", "issue_key"},
+	{"jira_issue_status_times_issue_key_fkey", "jira_issue_status_times", "issue_key", "jira_issues_states", "issue_key"},
+	{"jira_comment_vault_dedup_key_fkey", "jira_comment_vault", "dedup_key", "jira_issues_events", "dedup_key"},
+	{"jira_issue_comments_issue_key_fkey", "jira_issue_comments", "issue_key", "jira_issues_states", "issue_key"},
+}
+
+// statement returns the statement adding the foreign key. The
+// constraint is deferred to the end of the transactions, so the
+// records of an issue can be replaced in any order (e.g. its state
+// deleted before its events, or the batches of a full sync copied
+// before the states).
+func (fk foreignKey) statement() string {
+	return fmt.Sprintf(`ALTER TABLE "%s" ADD CONSTRAINT "%s" FOREIGN KEY ("%s") REFERENCES "%s" ("%s") DEFERRABLE INITIALLY DEFERRED;`, fk.table, fk.name, fk.column, fk.refTable, fk.refCol)
+}
+
+// SetStrictSchema enables the strict-schema mode: foreign keys are
+// created between the issue tables (see `strictSchemaForeignKeys`)
+// by `CreateTables`, or added to an existing schema by `MigrateUp`,
+// so a write leaving inconsistent records (e.g. events or metrics of
+// an issue without state) fails instead of being committed.
+//
+// The store benchmark (see `LoadTest`), which writes events without
+// states, can't be run in this mode.
+func (s *PGStore) SetStrictSchema(strict bool) {
+	s.strictSchema = strict
+}
+
+// strictSchemaQueries returns the statements creating the foreign
+// keys of the strict schema, or nil if the mode is disabled.
+func (s *PGStore) strictSchemaQueries() []string {
+	if !s.strictSchema {
+		return nil
+	}
+	queries := make([]string, len(strictSchemaForeignKeys))
+	for i, fk := range strictSchemaForeignKeys {
+		queries[i] = fk.statement()
+	}
+	return queries
+}
+
+// StrictSchemaPlan returns the statements adding the foreign keys of
+// the strict schema missing from the DB, or nil if the mode is
+// disabled (see `SetStrictSchema`). The statements fail if the
+// existing records are inconsistent, e.g. orphaned events which can
+// be deleted first with `CollectOrphanedEvents`.
+func (s *PGStore) StrictSchemaPlan() ([]string, error) {
+	if !s.strictSchema {
+		return nil, nil
+	}
+	existing := make(map[string]bool)
+	rows, err := s.Query(`SELECT conname FROM pg_constraint WHERE contype = 'f';`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		existing[name] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	var queries []string
+	for _, fk := range strictSchemaForeignKeys {
+		if !existing[fk.name] {
+			queries = append(queries, fk.statement())
+		}
+	}
+	return queries, nil
+}
@@ -0,0 +1,36 @@
+package store
+
+// IssueSummary is the summary of an issue, as returned by
+// `GetOpenIssueSummaries`.
+type IssueSummary struct {
+	Key     string
+	Project string
+	Summary string
+}
+
+// GetOpenIssueSummaries returns the summaries of the issues not
+// resolved, not deleted nor generated (see `IssueState.Generated`),
+// sorted by key.
+func (s *PGStore) GetOpenIssueSummaries() ([]IssueSummary, error) {
+	rows, err := s.Query(`
+	SELECT issue_key, issue_project, issue_summary
+	FROM jira_issues_states
+	WHERE issue_resolved_at IS NULL
+	AND issue_deleted_at IS NULL AND NOT issue_is_generated
+	ORDER BY issue_key;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []IssueSummary
+	for rows.Next() {
+		var is IssueSummary
+		if err = rows.Scan(&is.Key, &is.Project, &is.Summary); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, is)
+	}
+	return summaries, rows.Err()
+}
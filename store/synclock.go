@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// ErrSyncLocked is returned by `LockSync` when another run holds the
+// sync lock.
+var ErrSyncLocked = errors.New("another run holds the sync lock")
+
+// SyncLockPollInterval is the time between two attempts of
+// `LockSync` to acquire a lock held by another run.
+var SyncLockPollInterval = 5 * time.Second
+
+// SyncLockLease is the time a sync lock stays held without being
+// extended, so the lock of a run which died is released once it's
+// over. The lease is extended every third of it while the lock is
+// held.
+var SyncLockLease = 2 * time.Minute
+
+// syncLocksTables are the tables created with `CreateTables` to hold
+// the sync locks (see `LockSync`), one row per lock held, until its
+// lease expires (`expires_at`, in the time of the DB). `holder`
+// identifies the run holding the lock (host, PID and a random
+// token).
+var syncLocksTables = []string{
+	`CREATE TABLE IF NOT EXISTS "sync_locks" (
+		"name" TEXT PRIMARY KEY NOT NULL,
+		"holder" TEXT NOT NULL,
+		"acquired_at" TIMESTAMP NOT NULL,
+		"expires_at" TIMESTAMP NOT NULL
+	);`,
+}
+
+// SyncLock is the lock of a run acquired with `LockSync`.
+type SyncLock struct {
+	s      *PGStore
+	name   string
+	holder string
+	stop   chan struct{}
+	done   chan struct{}
+	lost   chan struct{}
+}
+
+// LockSync acquires the sync lock of the name, so runs writing the
+// same records (e.g. a cron sync overlapping a slow one) don't
+// interleave their writes. The lock is a lease recorded in
+// `sync_locks`, so stores in different schemas of the same DB have
+// their own locks. The table is created if it doesn't exist yet, so
+// a run can hold the lock while it creates the schema (e.g. `reset`).
+//
+// If another run holds the lock, returns `ErrSyncLocked`, or retries
+// every `SyncLockPollInterval` until the lock is released or the
+// context is done if `wait` is set.
+//
+// The lease is extended in the background until `Unlock` (see
+// `SyncLockLease`), each statement running on its own, so the lock
+// holds no connection nor transaction: it works behind a pooler in
+// transaction mode (see `SetPoolerCompatible`), and is released once
+// the lease expires if the process dies.
+func (s *PGStore) LockSync(ctx context.Context, name string, wait bool) (*SyncLock, error) {
+	for {
+		l, err := s.tryLockSync(name)
+		if err != ErrSyncLocked || !wait {
+			return l, err
+		}
+		logging.Infof("Another run holds the sync lock `%s`, retrying in %s", name, SyncLockPollInterval)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(SyncLockPollInterval):
+		}
+	}
+}
+
+// tryLockSync acquires the sync lock of the name if it's free or its
+// lease expired.
+func (s *PGStore) tryLockSync(name string) (*SyncLock, error) {
+	if _, err := s.Exec(syncLocksTables[0]); err != nil {
+		return nil, err
+	}
+	holder, err := syncLockHolder()
+	if err != nil {
+		return nil, err
+	}
+	err = s.QueryRow(`
+	INSERT INTO sync_locks (name, holder, acquired_at, expires_at)
+	VALUES ($1, $2, NOW(), NOW() + $3 * INTERVAL '1 second')
+	ON CONFLICT (name) DO UPDATE
+	SET holder = EXCLUDED.holder, acquired_at = EXCLUDED.acquired_at, expires_at = EXCLUDED.expires_at
+	WHERE sync_locks.expires_at < NOW()
+	RETURNING holder;
+	`, name, holder, SyncLockLease.Seconds()).Scan(&holder)
+	if err == sql.ErrNoRows {
+		return nil, ErrSyncLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &SyncLock{s: s, name: name, holder: holder, stop: make(chan struct{}), done: make(chan struct{}), lost: make(chan struct{})}
+	go l.extend()
+	return l, nil
+}
+
+// syncLockHolder returns the identifier of a run holding a lock:
+// the host, the PID and a random token, so two locks are never held
+// by the same holder.
+func syncLockHolder() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(b)), nil
+}
+
+// extend extends the lease of the lock every third of
+// `SyncLockLease` until `Unlock`. A failed extension is retried on
+// the next tick, the lease still covering two of them. Closes `lost`
+// if the lease expired.
+func (l *SyncLock) extend() {
+	defer close(l.done)
+	ticker := time.NewTicker(SyncLockLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		res, err := l.s.Exec(`
+		UPDATE sync_locks
+		SET expires_at = NOW() + $3 * INTERVAL '1 second'
+		WHERE name = $1 AND holder = $2;
+		`, l.name, l.holder, SyncLockLease.Seconds())
+		if err != nil {
+			logging.Warnf("Failed to extend the lease of the sync lock `%s`: %s", l.name, err)
+			continue
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			logging.Errorf("The lease of the sync lock `%s` expired, another run may hold it", l.name)
+			close(l.lost)
+			return
+		}
+	}
+}
+
+// Lost returns a channel closed if the lease of the lock expired
+// while it was held (e.g. the DB was unreachable for longer than
+// `SyncLockLease`), another run being then able to acquire it: the
+// run must stop writing.
+func (l *SyncLock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Unlock releases the lock.
+func (l *SyncLock) Unlock() error {
+	close(l.stop)
+	<-l.done
+	_, err := l.s.Exec(`DELETE FROM sync_locks WHERE name = $1 AND holder = $2;`, l.name, l.holder)
+	return err
+}
@@ -0,0 +1,259 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The statuses of a sync run
+const (
+	SyncRunRunning = "running"
+	SyncRunDone    = "done"
+)
+
+// syncRunsTables are the tables created with `CreateTables` to
+// record the synchronizations, so an incremental sync can restart
+// from the last successful one, and the issues stored by the
+// running ones (`sync_progress`), so a full sync which didn't
+// finish can be resumed.
+var syncRunsTables = []string{
+	`CREATE TABLE "sync_runs" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"kind" TEXT NOT NULL,
+		"status" TEXT NOT NULL,
+		"started_at" TIMESTAMP NOT NULL,
+		"finished_at" TIMESTAMP,
+		"issues_count" INTEGER,
+		"app_version" TEXT,
+		"app_commit" TEXT,
+		"mapper_version" TEXT,
+		"schema_version" INTEGER,
+		"api_calls" INTEGER,
+		"api_calls_by_endpoint" JSONB,
+		"api_throttled" INTEGER,
+		"api_quota_used" REAL,
+		"api_calls_per_issue" REAL,
+		"filter_jql" TEXT
+	);`,
+	`CREATE TABLE "sync_progress" (
+		"sync_run_id" INTEGER NOT NULL,
+		"issue_key" TEXT NOT NULL,
+		PRIMARY KEY ("sync_run_id", "issue_key")
+	);`,
+}
+
+// RunInfo identifies the build of the application recording the
+// sync runs, so the records can be traced to the code which
+// produced them (see `SetRunInfo`).
+type RunInfo struct {
+	AppVersion    string
+	AppCommit     string
+	MapperVersion string
+}
+
+// SetRunInfo sets the build of the application recorded with the
+// sync runs, along with `SchemaVersion`. NULL is recorded for the
+// fields not set.
+func (s *PGStore) SetRunInfo(ri RunInfo) {
+	s.runInfo = ri
+}
+
+// StartSyncRun records the start of a sync of the specified kind
+// (e.g. "incremental") in `sync_runs`, with the build of the
+// application (see `SetRunInfo`) and the JQL of the filter
+// restricting the synced issues (NULL if empty, see
+// `GetLastSyncStart`), and returns the run's ID.
+func (s *PGStore) StartSyncRun(kind string, startedAt time.Time, filter string) (id int64, err error) {
+	ri := s.runInfo
+	err = s.QueryRow(`
+	INSERT INTO sync_runs (kind, status, started_at, app_version, app_commit, mapper_version, schema_version, filter_jql)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id;
+	`, kind, SyncRunRunning, startedAt, nullString(ri.AppVersion), nullString(ri.AppCommit), nullString(ri.MapperVersion), SchemaVersion, nullString(filter)).Scan(&id)
+	return
+}
+
+// SetSyncRunFilter replaces the JQL of the filter of the sync run
+// (see `StartSyncRun`), e.g. when a run is resumed with another
+// filter.
+func (s *PGStore) SetSyncRunFilter(id int64, filter string) error {
+	_, err := s.Exec(`UPDATE sync_runs SET filter_jql = $2 WHERE id = $1;`, id, nullString(filter))
+	return err
+}
+
+// nullString returns the string as a `sql.NullString`, NULL if it's
+// empty.
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
+
+// FinishSyncRun records the successful end of the sync run, and
+// deletes its progress (see `Writer.SetSyncRun`).
+func (s *PGStore) FinishSyncRun(id int64, finishedAt time.Time, issuesCount int) error {
+	_, err := s.Exec(`
+	UPDATE sync_runs
+	SET status = $2, finished_at = $3, issues_count = $4
+	WHERE id = $1;
+	`, id, SyncRunDone, finishedAt, issuesCount)
+	if err != nil {
+		return err
+	}
+	_, err = s.Exec(`DELETE FROM sync_progress WHERE sync_run_id = $1;`, id)
+	return err
+}
+
+// APIUsage is the consumption of Jira API by a sync run, recorded
+// with `RecordSyncRunAPIUsage`.
+type APIUsage struct {
+	// Calls is the number of requests sent to Jira API, and
+	// CallsByEndpoint their number by endpoint (e.g. `GET
+	// /rest/api/2/issue/{key}`).
+	Calls           int            `json:"calls"`
+	CallsByEndpoint map[string]int `json:"calls_by_endpoint"`
+
+	// Throttled is the number of requests rejected because of the
+	// rate limit of Jira.
+	Throttled int `json:"throttled"`
+
+	// QuotaUsed is the share of the quota announced by Jira
+	// consumed by the run, nil if Jira didn't announce it.
+	QuotaUsed *float64 `json:"quota_used"`
+
+	// CallsPerIssue is the number of requests per synced issue, nil
+	// if no issue was synced.
+	CallsPerIssue *float64 `json:"calls_per_issue"`
+}
+
+// RecordSyncRunAPIUsage records the consumption of Jira API by the
+// sync run.
+func (s *PGStore) RecordSyncRunAPIUsage(id int64, u APIUsage) error {
+	byEndpoint, err := json.Marshal(u.CallsByEndpoint)
+	if err != nil {
+		return err
+	}
+	_, err = s.Exec(`
+	UPDATE sync_runs
+	SET api_calls = $2, api_calls_by_endpoint = $3, api_throttled = $4, api_quota_used = $5, api_calls_per_issue = $6
+	WHERE id = $1;
+	`, id, u.Calls, string(byEndpoint), u.Throttled, u.QuotaUsed, u.CallsPerIssue)
+	return err
+}
+
+// GetResumableSyncRun returns the ID of the last sync run of the
+// kind which didn't finish (e.g. because the process crashed), or 0
+// if the last one finished.
+func (s *PGStore) GetResumableSyncRun(kind string) (int64, error) {
+	var id int64
+	var status string
+	err := s.QueryRow(`
+	SELECT id, status
+	FROM sync_runs
+	WHERE kind = $1
+	ORDER BY started_at DESC
+	LIMIT 1;
+	`, kind).Scan(&id, &status)
+	if err == sql.ErrNoRows || err == nil && status != SyncRunRunning {
+		return 0, nil
+	}
+	return id, err
+}
+
+// GetSyncedIssueKeys returns the keys of the issues stored by the
+// sync run (see `Writer.SetSyncRun`).
+func (s *PGStore) GetSyncedIssueKeys(id int64) ([]string, error) {
+	rows, err := s.Query(`SELECT issue_key FROM sync_progress WHERE sync_run_id = $1;`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err = rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// GetLastSyncStart returns the start time of the last successful
+// sync of one of the specified kinds, or nil if there is none. The
+// filtered syncs (see `StartSyncRun`) are ignored, since the issues
+// they didn't match were not synced.
+func (s *PGStore) GetLastSyncStart(kinds []string) (*time.Time, error) {
+	var t sql.NullTime
+	err := s.QueryRow(`
+	SELECT MAX(started_at)
+	FROM sync_runs
+	WHERE status = $1 AND kind = ANY($2) AND filter_jql IS NULL;
+	`, SyncRunDone, pq.Array(kinds)).Scan(&t)
+	if err != nil || !t.Valid {
+		return nil, err
+	}
+	return &t.Time, nil
+}
+
+// SyncRun is a sync recorded in `sync_runs`, as returned by
+// `GetSyncRuns`.
+type SyncRun struct {
+	ID          int64      `json:"id"`
+	Kind        string     `json:"kind"`
+	Status      string     `json:"status"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	IssuesCount *int       `json:"issues_count"`
+
+	// AppVersion, AppCommit, MapperVersion and SchemaVersion are the
+	// build of the application which ran the sync (see
+	// `SetRunInfo`), nil if not recorded.
+	AppVersion    *string `json:"app_version"`
+	AppCommit     *string `json:"app_commit"`
+	MapperVersion *string `json:"mapper_version"`
+	SchemaVersion *int    `json:"schema_version"`
+
+	// APIUsage is the consumption of Jira API by the run, nil if not
+	// recorded.
+	APIUsage *APIUsage `json:"api_usage"`
+}
+
+// GetSyncRuns returns the last `n` sync runs, the most recent first.
+func (s *PGStore) GetSyncRuns(n int) ([]SyncRun, error) {
+	rows, err := s.Query(`
+	SELECT id, kind, status, started_at, finished_at, issues_count, app_version, app_commit, mapper_version, schema_version,
+		api_calls, api_calls_by_endpoint, api_throttled, api_quota_used, api_calls_per_issue
+	FROM sync_runs
+	ORDER BY started_at DESC, id DESC
+	LIMIT $1;
+	`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []SyncRun
+	for rows.Next() {
+		var r SyncRun
+		var calls, throttled sql.NullInt64
+		var byEndpoint []byte
+		var u APIUsage
+		if err = rows.Scan(&r.ID, &r.Kind, &r.Status, &r.StartedAt, &r.FinishedAt, &r.IssuesCount, &r.AppVersion, &r.AppCommit, &r.MapperVersion, &r.SchemaVersion,
+			&calls, &byEndpoint, &throttled, &u.QuotaUsed, &u.CallsPerIssue); err != nil {
+			return nil, err
+		}
+		if calls.Valid {
+			u.Calls, u.Throttled = int(calls.Int64), int(throttled.Int64)
+			if byEndpoint != nil {
+				if err = json.Unmarshal(byEndpoint, &u.CallsByEndpoint); err != nil {
+					return nil, err
+				}
+			}
+			r.APIUsage = &u
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
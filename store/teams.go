@@ -0,0 +1,110 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// TeamMembership represents the membership of a person to a team,
+// to be stored in the DB. `From` and `To` are nil when the
+// membership is not bounded on that side.
+type TeamMembership struct {
+	Person string
+	Team   string
+	Tribe  *string
+	From   *time.Time
+	To     *time.Time
+}
+
+// teamsTables are the tables created with `CreateTables` to store
+// the teams loaded by the `load-teams` action.
+//
+// The team of the author of an event at the time of the event can
+// be found with:
+//
+//	SELECT e.*, m.team
+//	FROM jira_issues_events e
+//	LEFT JOIN team_memberships m ON m.person = e.event_author
+//		AND (m.valid_from IS NULL OR m.valid_from <= e.event_time::DATE)
+//		AND (m.valid_to IS NULL OR m.valid_to >= e.event_time::DATE);
+var teamsTables = []string{
+	`CREATE TABLE "team_memberships" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"person" TEXT NOT NULL,
+		"team" TEXT NOT NULL,
+		"tribe" TEXT,
+		"valid_from" DATE,
+		"valid_to" DATE
+	);`,
+}
+
+// ReplaceTeamMemberships replaces all records in `team_memberships`
+// by the passed ones.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) ReplaceTeamMemberships(tms []TeamMembership) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM team_memberships;"); err != nil {
+		return
+	}
+	for _, tm := range tms {
+		if err = insertTeamMembership(tx, tm); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// GetTeamMemberships returns the memberships of the team from
+// `team_memberships`, sorted by person and start.
+func (s *PGStore) GetTeamMemberships(team string) ([]TeamMembership, error) {
+	rows, err := s.Query(`
+	SELECT person, team, tribe, valid_from, valid_to
+	FROM team_memberships
+	WHERE team = $1
+	ORDER BY person, valid_from NULLS FIRST;
+	`, team)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tms []TeamMembership
+	for rows.Next() {
+		var tm TeamMembership
+		if err = rows.Scan(&tm.Person, &tm.Team, &tm.Tribe, &tm.From, &tm.To); err != nil {
+			return nil, err
+		}
+		tms = append(tms, tm)
+	}
+	return tms, rows.Err()
+}
+
+func insertTeamMembership(tx *sql.Tx, tm TeamMembership) (err error) {
+	query := `
+	INSERT INTO team_memberships (
+		person,
+		team,
+		tribe,
+		valid_from,
+		valid_to
+	)
+	VALUES ($1, $2, $3, $4, $5);
+	`
+	_, err = tx.Exec(query, tm.Person, tm.Team, tm.Tribe, tm.From, tm.To)
+	return
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// ThrottleOptions configures a `Throttle`. Zero values disable the
+// corresponding limit.
+type ThrottleOptions struct {
+	// MaxRowsPerSecond limits the rate of inserted rows.
+	MaxRowsPerSecond int
+
+	// MaxReplicationLag pauses the writes while the replication
+	// lag of one of the DB's replicas is greater.
+	MaxReplicationLag time.Duration
+
+	// MaxConnectionsUsage pauses the writes while the ratio of
+	// used connections over `max_connections` is greater (e.g.
+	// `0.8`).
+	MaxConnectionsUsage float64
+
+	// CheckInterval is the minimum interval between two checks of
+	// the DB's capacity (replication lag and connections).
+	// Defaults to 10 seconds.
+	CheckInterval time.Duration
+
+	// PauseDuration is the time to wait before checking the DB's
+	// capacity again when writes are paused. Defaults to 5 seconds.
+	PauseDuration time.Duration
+}
+
+// Throttle slows down writes to the DB so that a sync doesn't
+// degrade a DB shared with other applications (e.g. a production
+// BI database).
+//
+// It limits the rate of inserted rows and pauses writes when the
+// DB is detected as overloaded (replication lag or connections
+// saturation).
+type Throttle struct {
+	db        *sql.DB
+	opts      ThrottleOptions
+	mutex     sync.Mutex
+	next      time.Time
+	lastCheck time.Time
+}
+
+// NewThrottle returns a `Throttle` for the specified DB.
+func NewThrottle(db *sql.DB, opts ThrottleOptions) *Throttle {
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = 10 * time.Second
+	}
+	if opts.PauseDuration == 0 {
+		opts.PauseDuration = 5 * time.Second
+	}
+	return &Throttle{db: db, opts: opts}
+}
+
+// Wait blocks until `rows` rows may be written.
+//
+// Concurrent callers are serialized, so the rate limit applies to
+// all writes performed through the throttle.
+func (t *Throttle) Wait(rows int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.waitForCapacity()
+
+	if t.opts.MaxRowsPerSecond <= 0 {
+		return
+	}
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	time.Sleep(t.next.Sub(now))
+	t.next = t.next.Add(time.Duration(rows) * time.Second / time.Duration(t.opts.MaxRowsPerSecond))
+}
+
+// waitForCapacity checks the DB's capacity every `CheckInterval`
+// and pauses until it's back under the configured limits.
+func (t *Throttle) waitForCapacity() {
+	if t.opts.MaxReplicationLag == 0 && t.opts.MaxConnectionsUsage == 0 {
+		return
+	}
+	if time.Since(t.lastCheck) < t.opts.CheckInterval {
+		return
+	}
+	for {
+		t.lastCheck = time.Now()
+		overloaded, reason := t.overloaded()
+		if !overloaded {
+			return
+		}
+		logging.Warnf("Throttle: pausing writes for %s (%s)", t.opts.PauseDuration, reason)
+		time.Sleep(t.opts.PauseDuration)
+	}
+}
+
+// overloaded returns true and the reason if the DB is over one of
+// the configured limits. Errors while checking are logged and
+// ignored, since the monitoring views may not be accessible to the
+// DB user.
+func (t *Throttle) overloaded() (bool, string) {
+	if t.opts.MaxReplicationLag > 0 {
+		var lag float64
+		q := `SELECT COALESCE(MAX(EXTRACT(EPOCH FROM replay_lag)), 0) FROM pg_stat_replication`
+		if err := t.db.QueryRow(q).Scan(&lag); err != nil {
+			logging.Warnf("Throttle: failed to check replication lag: %s", err)
+		} else if d := time.Duration(lag * float64(time.Second)); d > t.opts.MaxReplicationLag {
+			return true, "replication lag is " + d.String()
+		}
+	}
+	if t.opts.MaxConnectionsUsage > 0 {
+		var usage float64
+		q := `SELECT COUNT(*)::float / current_setting('max_connections')::float FROM pg_stat_activity`
+		if err := t.db.QueryRow(q).Scan(&usage); err != nil {
+			logging.Warnf("Throttle: failed to check connections usage: %s", err)
+		} else if usage > t.opts.MaxConnectionsUsage {
+			return true, "connections are saturated"
+		}
+	}
+	return false, ""
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Postgres error codes for statements canceled because of
+// `statement_timeout` and `lock_timeout`.
+const (
+	pgQueryCanceled    = "57014"
+	pgLockNotAvailable = "55P03"
+)
+
+// TimeoutError is returned by `ReplaceIssueStateAndEvents` when
+// a statement was canceled because it exceeded the configured
+// statement or lock timeout (see `WithTimeouts`).
+type TimeoutError struct {
+	IssueKey string
+	Err      error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timeout while storing issue %s: %s", e.IssueKey, e.Err)
+}
+
+// Timeout returns true, for consistency with `net.Error`.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// IsTimeout returns true if the error is a `TimeoutError`.
+func IsTimeout(err error) bool {
+	_, ok := err.(*TimeoutError)
+	return ok
+}
+
+// isPGTimeout returns true if the error was returned by Postgres
+// for a statement canceled by `statement_timeout` or
+// `lock_timeout`.
+func isPGTimeout(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return false
+	}
+	return pqErr.Code == pgQueryCanceled || pqErr.Code == pgLockNotAvailable
+}
+
+// WithTimeouts returns the connection string with the
+// `statement_timeout` and `lock_timeout` parameters set, so they
+// apply to every connection opened with it. Zero durations are
+// ignored. Both URL (`postgres://...`) and key/value connection
+// strings are supported.
+func WithTimeouts(connStr string, statement, lock time.Duration) string {
+	params := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"statement_timeout", statement},
+		{"lock_timeout", lock},
+	}
+	for _, p := range params {
+		if p.value <= 0 {
+			continue
+		}
+		connStr = withParam(connStr, p.name, fmt.Sprintf("%d", p.value.Nanoseconds()/int64(time.Millisecond)))
+	}
+	return connStr
+}
+
+// withParam returns the connection string, URL or key/value, with
+// the parameter appended.
+func withParam(connStr, name, value string) string {
+	isURL := strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://")
+	switch {
+	case isURL && strings.Contains(connStr, "?"):
+		return connStr + "&" + name + "=" + url.QueryEscape(value)
+	case isURL:
+		return connStr + "?" + name + "=" + url.QueryEscape(value)
+	default:
+		return connStr + " " + name + "=" + value
+	}
+}
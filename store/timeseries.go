@@ -0,0 +1,103 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeSeriesPoint is a value of a time series, as returned by
+// `GetWeeklyThroughput`, `GetWeeklyCycleTime` and `GetDailyWIP`.
+type TimeSeriesPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// TimeSeriesScope restricts the issues of a time series to those of
+// a project and of a type. Both are optional.
+type TimeSeriesScope struct {
+	Project string
+	Type    string
+}
+
+// where returns the conditions of the scope on the columns of the
+// project and type, with the placeholders numbered from `n`, and
+// their arguments.
+func (sc TimeSeriesScope) where(projectColumn, typeColumn string, n int) (string, []interface{}) {
+	var cond string
+	var args []interface{}
+	if sc.Project != "" {
+		args = append(args, sc.Project)
+		cond += fmt.Sprintf(" AND %s = $%d", projectColumn, n+len(args)-1)
+	}
+	if sc.Type != "" {
+		args = append(args, sc.Type)
+		cond += fmt.Sprintf(" AND %s = $%d", typeColumn, n+len(args)-1)
+	}
+	return cond, args
+}
+
+// GetWeeklyThroughput returns the number of issues of the scope done
+// each week (starting on monday) from `jira_issue_metrics`, for the
+// issues done in [from, to). The weeks without issues done are
+// omitted. Sorted by week.
+func (s *PGStore) GetWeeklyThroughput(scope TimeSeriesScope, from, to time.Time) ([]TimeSeriesPoint, error) {
+	cond, args := scope.where("issue_project", "issue_type", 3)
+	return s.timeSeries(`
+	SELECT date_trunc('week', done_at), COUNT(*)
+	FROM jira_issue_metrics
+	WHERE done_at >= $1 AND done_at < $2`+cond+`
+	GROUP BY 1
+	ORDER BY 1;
+	`, append([]interface{}{from, to}, args...)...)
+}
+
+// GetWeeklyCycleTime returns the percentile (e.g. 0.85) of the cycle
+// times, in days, of the issues of the scope done each week
+// (starting on monday) from `jira_issue_metrics`, for the issues
+// done in [from, to). The weeks without issues done are omitted.
+// Sorted by week.
+func (s *PGStore) GetWeeklyCycleTime(scope TimeSeriesScope, percentile float64, from, to time.Time) ([]TimeSeriesPoint, error) {
+	cond, args := scope.where("issue_project", "issue_type", 4)
+	return s.timeSeries(`
+	SELECT date_trunc('week', done_at), percentile_cont($3) WITHIN GROUP (ORDER BY cycle_time_seconds) / 86400
+	FROM jira_issue_metrics
+	WHERE done_at >= $1 AND done_at < $2 AND cycle_time_seconds IS NOT NULL`+cond+`
+	GROUP BY 1
+	ORDER BY 1;
+	`, append([]interface{}{from, to, percentile}, args...)...)
+}
+
+// GetDailyWIP returns the number of issues of the scope in progress
+// at the end of each day in [from, to), from `jira_wip_aging`. The
+// days without issues in progress are omitted. Sorted by day.
+func (s *PGStore) GetDailyWIP(scope TimeSeriesScope, from, to time.Time) ([]TimeSeriesPoint, error) {
+	cond, args := scope.where("s.issue_project", "s.issue_type", 3)
+	return s.timeSeries(`
+	SELECT w.day::TIMESTAMP, COUNT(*)
+	FROM jira_wip_aging w
+	JOIN jira_issues_states s ON s.issue_key = w.issue_key
+	WHERE w.day >= $1::DATE AND w.day < $2::DATE`+cond+`
+	GROUP BY 1
+	ORDER BY 1;
+	`, append([]interface{}{from, to}, args...)...)
+}
+
+// timeSeries returns the points of the time series returned by the
+// query, a time and a value per row.
+func (s *PGStore) timeSeries(query string, args ...interface{}) ([]TimeSeriesPoint, error) {
+	rows, err := s.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []TimeSeriesPoint
+	for rows.Next() {
+		var p TimeSeriesPoint
+		if err = rows.Scan(&p.Time, &p.Value); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
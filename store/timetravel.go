@@ -0,0 +1,80 @@
+package store
+
+// timeTravelFunctions are the SQL functions created along with
+// the tables to query the store "as of" a point in time.
+//
+// ### jira_issues_as_of(TIMESTAMP)
+//
+// Returns one row per issue created before the passed time and not
+// deleted at this time, with the status and assignee the issue had
+// at this time. They are reconstructed from the last
+// `status_changed` and `assignee_changed` events before the passed
+// time or, if the issue had not changed yet, from the value changed
+// by the first event after it (`*_change_from`). Without any change,
+// the current state is used, as for the fields which have no history
+// in the events (e.g. project, type).
+//
+// Example:
+//
+//	SELECT issue_status, COUNT(*)
+//	FROM jira_issues_as_of('2018-07-01')
+//	GROUP BY issue_status;
+//
+// The change events of each field are ordered with the ones before
+// the passed time first (the last one first), then the ones after it
+// (the first one first).
+//
+// NB: columns in the function's body are qualified with their table
+// alias to avoid conflicts with the returned columns.
+var timeTravelFunctions = []string{
+	`CREATE OR REPLACE FUNCTION jira_issues_as_of(TIMESTAMP)
+	RETURNS TABLE (
+		"issue_key" TEXT,
+		"issue_created_at" TIMESTAMP,
+		"issue_project" TEXT,
+		"issue_type" TEXT,
+		"issue_priority" TEXT,
+		"issue_summary" TEXT,
+		"issue_status" TEXT,
+		"issue_assignee" TEXT,
+		"issue_resolved_at" TIMESTAMP
+	) AS $$
+		SELECT
+			s.issue_key,
+			s.issue_created_at,
+			s.issue_project,
+			s.issue_type,
+			s.issue_priority,
+			s.issue_summary,
+			CASE WHEN sc.found THEN sc.value ELSE s.issue_status END,
+			CASE WHEN ac.found THEN ac.value ELSE s.issue_assignee END,
+			CASE WHEN s.issue_resolved_at <= $1 THEN s.issue_resolved_at END
+		FROM jira_issues_states s
+		LEFT JOIN LATERAL (
+			SELECT TRUE AS found, CASE WHEN e.event_time <= $1 THEN e.status_change_to ELSE e.status_change_from END AS value
+			FROM jira_issues_events e
+			WHERE e.issue_key = s.issue_key
+			AND e.event_kind = 'status_changed'
+			ORDER BY
+				e.event_time <= $1 DESC,
+				CASE WHEN e.event_time <= $1 THEN e.event_time END DESC,
+				CASE WHEN e.event_time <= $1 THEN e.id END DESC,
+				e.event_time, e.id
+			LIMIT 1
+		) sc ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT TRUE AS found, CASE WHEN e.event_time <= $1 THEN e.assignee_change_to ELSE e.assignee_change_from END AS value
+			FROM jira_issues_events e
+			WHERE e.issue_key = s.issue_key
+			AND e.event_kind = 'assignee_changed'
+			ORDER BY
+				e.event_time <= $1 DESC,
+				CASE WHEN e.event_time <= $1 THEN e.event_time END DESC,
+				CASE WHEN e.event_time <= $1 THEN e.id END DESC,
+				e.event_time, e.id
+			LIMIT 1
+		) ac ON TRUE
+		WHERE s.issue_created_at <= $1
+		AND (s.issue_deleted_at IS NULL OR s.issue_deleted_at > $1);
+	$$ LANGUAGE SQL STABLE;`,
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"database/sql"
+)
+
+// User represents a Jira user to be stored in the DB.
+type User struct {
+	// AccountID identifies the user, even if their name changes.
+	AccountID string
+
+	// Name is the user's name, as stored in `event_author` and the
+	// assignee columns of the issue tables.
+	Name string
+
+	DisplayName string
+
+	// Email is nil if the user's privacy settings hide it.
+	Email *string
+
+	Active bool
+}
+
+// usersTables are the tables created with `CreateTables` to store
+// the users of Jira, replaced on each sync (see `ReplaceUsers`).
+//
+// The events and states record the account ID of the users next to
+// their name (e.g. `event_author_account_id`, `issue_assignee_account_id`),
+// so that the users can be grouped even if they were renamed, e.g. to
+// count the comments per user with their current name:
+//
+//	SELECT COALESCE(u.display_name, e.event_author), COUNT(*)
+//	FROM jira_issues_events e
+//	LEFT JOIN jira_users u ON u.account_id = e.event_author_account_id
+//	WHERE e.event_kind = 'comment_added'
+//	GROUP BY 1;
+var usersTables = []string{
+	`CREATE TABLE IF NOT EXISTS "jira_users" (
+		"account_id" TEXT PRIMARY KEY NOT NULL,
+		"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		"name" TEXT NOT NULL,
+		"display_name" TEXT NOT NULL,
+		"email" TEXT,
+		"active" BOOLEAN NOT NULL
+	);`,
+}
+
+// usersViews are the views created with `CreateTables` on top of
+// `jira_users`.
+//
+// ### jira_user_names
+//
+// Returns one row per name the users had in the events (as author or
+// assignee), with their account ID and current display name, to map
+// the names of the renamed users in existing reports. E.g. to count
+// the assignments per user:
+//
+//	SELECT n.display_name, COUNT(*)
+//	FROM jira_issues_events e
+//	JOIN jira_user_names n ON n.name = e.assignee_change_to
+//	WHERE e.event_kind = 'assignee_changed'
+//	GROUP BY n.display_name;
+var usersViews = []string{
+	`CREATE OR REPLACE VIEW jira_user_names AS
+	SELECT n.name, n.account_id, COALESCE(u.display_name, n.name) AS display_name
+	FROM (
+		SELECT event_author AS name, event_author_account_id AS account_id
+		FROM jira_issues_events
+		WHERE event_author_account_id IS NOT NULL
+		UNION
+		SELECT assignee_change_to, assignee_change_to_account_id
+		FROM jira_issues_events
+		WHERE assignee_change_to_account_id IS NOT NULL
+	) n
+	LEFT JOIN jira_users u ON u.account_id = n.account_id;`,
+}
+
+// ReplaceUsers replaces all records in `jira_users` by the passed
+// ones.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) ReplaceUsers(users []User) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM jira_users;"); err != nil {
+		return
+	}
+	for _, u := range users {
+		if err = insertUser(tx, u); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func insertUser(tx *sql.Tx, u User) (err error) {
+	query := `
+	INSERT INTO jira_users (
+		account_id,
+		name,
+		display_name,
+		email,
+		active
+	)
+	VALUES ($1, $2, $3, $4, $5);
+	`
+	_, err = tx.Exec(query, u.AccountID, u.Name, u.DisplayName, u.Email, u.Active)
+	return
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CommentVaultKeySize is the size of the keys of a `CommentVault`
+// (AES-256).
+const CommentVaultKeySize = 32
+
+// CommentVault encrypts the bodies of the comments with AES-256-GCM,
+// so they can be stored in `jira_comment_vault` instead of
+// `jira_issues_events` (see `SetCommentVault`) and only read with the
+// key.
+type CommentVault struct {
+	aead cipher.AEAD
+}
+
+// NewCommentVault returns a `CommentVault` using the key, which must
+// be `CommentVaultKeySize` bytes long.
+func NewCommentVault(key []byte) (*CommentVault, error) {
+	if len(key) != CommentVaultKeySize {
+		return nil, fmt.Errorf("comment vault key must be %d bytes long, got %d", CommentVaultKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &CommentVault{aead: aead}, nil
+}
+
+// Seal encrypts the body, returning the nonce followed by the
+// ciphertext. The event's dedup key (see `IssueEvent.DedupKey`) is
+// authenticated, so a sealed body can't be moved to another event.
+func (v *CommentVault) Seal(dedupKey string, body string) ([]byte, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return v.aead.Seal(nonce, nonce, []byte(body), []byte(dedupKey)), nil
+}
+
+// Open decrypts a body sealed with `Seal` for the event with the
+// dedup key.
+func (v *CommentVault) Open(dedupKey string, sealed []byte) (string, error) {
+	n := v.aead.NonceSize()
+	if len(sealed) < n {
+		return "", errors.New("sealed comment body too short")
+	}
+	body, err := v.aead.Open(nil, sealed[:n], sealed[n:], []byte(dedupKey))
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt comment body (wrong key?): %s", err)
+	}
+	return string(body), nil
+}
+
+// VaultedComment is a comment stored in `jira_comment_vault`, as
+// returned by `GetVaultedComments`.
+type VaultedComment struct {
+	IssueKey    string
+	EventTime   time.Time
+	EventAuthor string
+	Body        string
+}
+
+// commentVaultTables are the tables created with `CreateTables` to
+// store the bodies of the comments encrypted (see
+// `SetCommentVault`). The table is not readable by other roles than
+// its owner unless granted explicitly, e.g. to an auditors role:
+//
+//	GRANT SELECT ON jira_comment_vault TO auditors;
+//
+// The bodies can't be read without the key anyway.
+var commentVaultTables = []string{
+	`CREATE TABLE "jira_comment_vault" (
+		"dedup_key" TEXT PRIMARY KEY NOT NULL,
+		"issue_key" TEXT NOT NULL,
+		"event_time" TIMESTAMP NOT NULL,
+		"event_author" TEXT NOT NULL,
+		"comment_body_encrypted" BYTEA NOT NULL
+	);`,
+	`REVOKE ALL ON "jira_comment_vault" FROM PUBLIC;`,
+}
+
+// commentVaultColumns are the columns of `jira_comment_vault`.
+var commentVaultColumns = []string{"dedup_key", "issue_key", "event_time", "event_author", "comment_body_encrypted"}
+
+// SetCommentVault enables the redact-then-store mode: the bodies of
+// the comments are encrypted with the vault and stored in
+// `jira_comment_vault` instead of `jira_issues_events`, where only
+// their metadata (`comment_length`, author, time) are kept. The
+// comments of an issue can then be read with `GetVaultedComments`.
+func (s *PGStore) SetCommentVault(v *CommentVault) {
+	s.commentVault = v
+}
+
+// issueEventRow returns the values of the event's columns (see
+// `issueEventColumns`) including the custom columns, and the values
+// of `commentVaultColumns` for its comment body if it must be
+// stored in the vault (nil otherwise). The comment body is then
+// removed from the event's values.
+func (s *PGStore) issueEventRow(ie IssueEvent, is IssueState) (row []interface{}, vaultRow []interface{}, err error) {
+	row = append(issueEventValues(ie, is), customColumnValues(s.customColumns, is)...)
+	if s.commentVault == nil || ie.CommentBody == nil {
+		return row, nil, nil
+	}
+	dedupKey := ie.DedupKey()
+	sealed, err := s.commentVault.Seal(dedupKey, *ie.CommentBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	row[commentBodyColumn] = nil
+	return row, []interface{}{dedupKey, ie.IssueKey, ie.EventTime, ie.EventAuthor, sealed}, nil
+}
+
+// deleteVaultedComments deletes the comments of the issues from the
+// vault, if the store has one.
+func (s *PGStore) deleteVaultedComments(tx *sql.Tx, issueKeys ...string) error {
+	if s.commentVault == nil {
+		return nil
+	}
+	_, err := tx.Exec(`DELETE FROM jira_comment_vault WHERE issue_key = ANY($1);`, pq.Array(issueKeys))
+	return err
+}
+
+// GetVaultedComments returns the comments of the issue stored in the
+// vault, decrypted, sorted by time. Returns an error if the store
+// has no vault (see `SetCommentVault`) or the key is not the one the
+// comments were encrypted with.
+func (s *PGStore) GetVaultedComments(issueKey string) ([]VaultedComment, error) {
+	if s.commentVault == nil {
+		return nil, errors.New("no comment vault key set")
+	}
+	rows, err := s.Query(`
+	SELECT dedup_key, event_time, event_author, comment_body_encrypted
+	FROM jira_comment_vault
+	WHERE issue_key = $1
+	ORDER BY event_time;
+	`, issueKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cs []VaultedComment
+	for rows.Next() {
+		var dedupKey string
+		var sealed []byte
+		c := VaultedComment{IssueKey: issueKey}
+		if err = rows.Scan(&dedupKey, &c.EventTime, &c.EventAuthor, &sealed); err != nil {
+			return nil, err
+		}
+		if c.Body, err = s.commentVault.Open(dedupKey, sealed); err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, rows.Err()
+}
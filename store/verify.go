@@ -0,0 +1,42 @@
+package store
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// VerifiedState is the part of the state of an issue compared with
+// Jira to measure the drift of the store (see `jira.Verify`).
+type VerifiedState struct {
+	Key       string
+	UpdatedAt time.Time
+	Status    *string
+	Assignee  *string
+}
+
+// GetVerifiedStates returns the states of the issues with the keys,
+// or of all the issues not marked as deleted if `keys` is nil, by
+// issue key. The issues not in the store are missing from the map.
+func (s *PGStore) GetVerifiedStates(keys []string) (map[string]VerifiedState, error) {
+	q := `SELECT issue_key, issue_updated_at, issue_status, issue_assignee FROM jira_issues_states WHERE issue_deleted_at IS NULL;`
+	var args []interface{}
+	if keys != nil {
+		q = `SELECT issue_key, issue_updated_at, issue_status, issue_assignee FROM jira_issues_states WHERE issue_key = ANY($1);`
+		args = append(args, pq.Array(keys))
+	}
+	rows, err := s.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	states := make(map[string]VerifiedState)
+	for rows.Next() {
+		var vs VerifiedState
+		if err = rows.Scan(&vs.Key, &vs.UpdatedAt, &vs.Status, &vs.Assignee); err != nil {
+			return nil, err
+		}
+		states[vs.Key] = vs
+	}
+	return states, rows.Err()
+}
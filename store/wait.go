@@ -0,0 +1,62 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// Pinger is implemented by DB handles able to check the connection
+// to the DB (e.g. `*sql.DB`).
+type Pinger interface {
+	Ping() error
+}
+
+// WaitOptions configures `WaitForDB`.
+type WaitOptions struct {
+	// Timeout is the period during which the connection is
+	// retried. The DB is only pinged once if 0.
+	Timeout time.Duration
+
+	// InitialBackoff is the time to wait before the first retry.
+	// It's doubled after each attempt. Defaults to 500ms.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the time between two attempts. Defaults to
+	// 5 seconds.
+	MaxBackoff time.Duration
+}
+
+// WaitForDB pings the DB until it's reachable, retrying with an
+// exponential backoff for `o.Timeout`. This is useful when the DB
+// is started at the same time as the application (e.g. with
+// docker-compose) and is not ready yet.
+//
+// Returns the last error if the DB is still unreachable after the
+// timeout.
+func WaitForDB(db Pinger, o WaitOptions) error {
+	if o.InitialBackoff == 0 {
+		o.InitialBackoff = 500 * time.Millisecond
+	}
+	if o.MaxBackoff == 0 {
+		o.MaxBackoff = 5 * time.Second
+	}
+	deadline := time.Now().Add(o.Timeout)
+	backoff := o.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := db.Ping()
+		if err == nil {
+			return nil
+		}
+		if !time.Now().Add(backoff).Before(deadline) {
+			return fmt.Errorf("DB unreachable after %d attempt(s): %s", attempt, err)
+		}
+		logging.Warnf("DB not ready (%s), retrying in %s", err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > o.MaxBackoff {
+			backoff = o.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// watchersTables are the tables created with `CreateTables` to store
+// the number of watchers of issues over time, as reported by the
+// webhooks (see the `webhook` package).
+//
+// `jira_issue_watchers_daily` holds one row per issue and day with
+// an update, with the number of watchers at the end of the day and
+// the number of watchers added and removed during the day.
+var watchersTables = []string{
+	`CREATE TABLE "jira_issue_watchers_daily" (
+		"issue_key" TEXT NOT NULL,
+		"day" DATE NOT NULL,
+		"watchers" INTEGER NOT NULL,
+		"added" INTEGER NOT NULL DEFAULT 0,
+		"removed" INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY ("issue_key", "day")
+	);`,
+}
+
+// RecordWatchCount records the number of watchers of the issue at
+// time `t` in `jira_issue_watchers_daily`.
+//
+// The difference with the last recorded count of the issue is added
+// to the day's `added` or `removed` watchers. The first count
+// recorded for an issue is the baseline and is not counted as added
+// watchers. Since only counts are known, watchers added and removed
+// between two counts cancel each other.
+func (s *PGStore) RecordWatchCount(issueKey string, t time.Time, count int) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	day := t.UTC().Format("2006-01-02")
+	var previous int
+	err = tx.QueryRow(`
+	SELECT watchers
+	FROM jira_issue_watchers_daily
+	WHERE issue_key = $1 AND day <= $2
+	ORDER BY day DESC
+	LIMIT 1;
+	`, issueKey, day).Scan(&previous)
+	switch {
+	case err == sql.ErrNoRows:
+		previous, err = count, nil
+	case err != nil:
+		return
+	}
+
+	added, removed := 0, 0
+	if delta := count - previous; delta > 0 {
+		added = delta
+	} else {
+		removed = -delta
+	}
+	_, err = tx.Exec(`
+	INSERT INTO jira_issue_watchers_daily (issue_key, day, watchers, added, removed)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (issue_key, day) DO UPDATE SET
+		watchers = EXCLUDED.watchers,
+		added = jira_issue_watchers_daily.added + EXCLUDED.added,
+		removed = jira_issue_watchers_daily.removed + EXCLUDED.removed;
+	`, issueKey, day, count, added, removed)
+	return
+}
@@ -0,0 +1,34 @@
+package store
+
+// webhookDeliveriesTables are the tables created with `CreateTables`
+// to record the events posted to the outbound webhooks (see package
+// `outbound`), so an event written again by the next sync of its
+// issue is not posted twice.
+var webhookDeliveriesTables = []string{
+	`CREATE TABLE IF NOT EXISTS "jira_webhook_deliveries" (
+		"webhook" TEXT NOT NULL,
+		"dedup_key" TEXT NOT NULL,
+		"delivered_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+		PRIMARY KEY ("webhook", "dedup_key")
+	);`,
+}
+
+// IsWebhookDelivered returns true if the event of the dedup key (see
+// `IssueEvent.DedupKey`) was posted to the webhook.
+func (s *PGStore) IsWebhookDelivered(webhook string, dedupKey string) (delivered bool, err error) {
+	err = s.QueryRow(`
+	SELECT EXISTS (SELECT 1 FROM jira_webhook_deliveries WHERE webhook = $1 AND dedup_key = $2);
+	`, webhook, dedupKey).Scan(&delivered)
+	return
+}
+
+// RecordWebhookDelivery records that the event of the dedup key was
+// posted to the webhook.
+func (s *PGStore) RecordWebhookDelivery(webhook string, dedupKey string) error {
+	_, err := s.Exec(`
+	INSERT INTO jira_webhook_deliveries (webhook, dedup_key)
+	VALUES ($1, $2)
+	ON CONFLICT (webhook, dedup_key) DO NOTHING;
+	`, webhook, dedupKey)
+	return err
+}
@@ -0,0 +1,116 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// wipAgingTables are the tables created with `CreateTables` to store
+// the daily snapshots of the issues in progress, the data source of
+// aging-WIP charts. They're maintained from the `status_changed`
+// events by the `wip-aging` projection (see package `projection`)
+// and extended to the current day at the end of each sync (see
+// `ExtendWIPAging`).
+//
+// A row is the state of an issue in progress at the end of `day`:
+// its `status`, since when (`status_since`), when it entered the
+// in-progress statuses (`started_at`), and the ages in days derived
+// from them. E.g. the aging WIP of today per status:
+//
+//	SELECT status, issue_key, age_days
+//	FROM jira_wip_aging
+//	WHERE day = CURRENT_DATE
+//	ORDER BY status, age_days DESC;
+var wipAgingTables = []string{
+	`CREATE TABLE IF NOT EXISTS "jira_wip_aging" (
+		"day" DATE NOT NULL,
+		"issue_key" TEXT NOT NULL,
+		"status" TEXT NOT NULL,
+		"status_since" TIMESTAMP NOT NULL,
+		"started_at" TIMESTAMP NOT NULL,
+		"age_days" INTEGER NOT NULL,
+		"status_age_days" INTEGER NOT NULL
+	);`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS "jira_wip_aging_issue_key_day_idx" ON "jira_wip_aging" ("issue_key", "day");`,
+	`CREATE INDEX IF NOT EXISTS "jira_wip_aging_day_idx" ON "jira_wip_aging" ("day", "status");`,
+}
+
+// updateWIPAgingQuery replaces the snapshots of the issue from the
+// day of the change with those of the new status up to the current
+// day. The issue entered the in-progress statuses at the start of
+// the snapshot replaced for the same day if it was already in
+// progress, at the start of the snapshot of the day before
+// otherwise, or at the time of the change.
+const updateWIPAgingQuery = `WITH deleted AS (
+		DELETE FROM jira_wip_aging
+		WHERE issue_key = $1 AND day >= $2::TIMESTAMP::DATE
+		RETURNING started_at
+	)
+	INSERT INTO jira_wip_aging (day, issue_key, status, status_since, started_at, age_days, status_age_days)
+	SELECT d::DATE, $1, $3, $2::TIMESTAMP, w.started_at, d::DATE - w.started_at::DATE, d::DATE - $2::TIMESTAMP::DATE
+	FROM (
+		SELECT COALESCE(
+			(SELECT MAX(started_at) FROM deleted WHERE started_at <= $2::TIMESTAMP),
+			(SELECT started_at FROM jira_wip_aging WHERE issue_key = $1 AND day = $2::TIMESTAMP::DATE - 1),
+			$2::TIMESTAMP
+		) AS started_at
+	) w
+	CROSS JOIN generate_series($2::TIMESTAMP::DATE::TIMESTAMP, CURRENT_DATE::TIMESTAMP, '1 day') d;`
+
+// UpdateWIPAging updates the snapshots of the issue with a change
+// of its status at `t`. If the new status is in progress, the
+// snapshots from the day of the change to the current day are
+// replaced with the new status, otherwise they're deleted. The
+// changes of an issue must be passed in their order (a change
+// removes the snapshots of the later ones).
+func (s *PGStore) UpdateWIPAging(issueKey string, t time.Time, status string, inProgress bool) error {
+	if !inProgress {
+		_, err := s.Exec(`DELETE FROM jira_wip_aging WHERE issue_key = $1 AND day >= $2::TIMESTAMP::DATE;`, issueKey, t)
+		return err
+	}
+	_, err := s.Exec(updateWIPAgingQuery, issueKey, t, status)
+	return err
+}
+
+// extendWIPAgingQuery extends the latest snapshot of each issue to
+// the current day if the issue is still in the same status.
+const extendWIPAgingQuery = `INSERT INTO jira_wip_aging (day, issue_key, status, status_since, started_at, age_days, status_age_days)
+	SELECT d::DATE, w.issue_key, w.status, w.status_since, w.started_at, d::DATE - w.started_at::DATE, d::DATE - w.status_since::DATE
+	FROM (
+		SELECT DISTINCT ON (issue_key) *
+		FROM jira_wip_aging
+		ORDER BY issue_key, day DESC
+	) w
+	JOIN jira_issues_states s ON s.issue_key = w.issue_key AND s.issue_status = w.status AND s.issue_deleted_at IS NULL
+	CROSS JOIN LATERAL generate_series((w.day + 1)::TIMESTAMP, CURRENT_DATE::TIMESTAMP, '1 day') d
+	WHERE w.day < CURRENT_DATE;`
+
+// ExtendWIPAging adds the snapshots of the days without any change
+// of the issues still in progress, up to the current day, and
+// returns their number. Snapshots are only added on status changes
+// otherwise, so it's called at the end of each sync.
+func (s *PGStore) ExtendWIPAging() (int64, error) {
+	res, err := s.Exec(extendWIPAgingQuery)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ClearWIPAging deletes all the snapshots of `jira_wip_aging`, e.g.
+// before rebuilding them.
+func (s *PGStore) ClearWIPAging() error {
+	_, err := s.Exec(`DELETE FROM jira_wip_aging;`)
+	return err
+}
+
+// GetIssueProject returns the project of the issue, or an empty
+// string if the issue is not stored.
+func (s *PGStore) GetIssueProject(issueKey string) (string, error) {
+	var project string
+	err := s.QueryRow(`SELECT issue_project FROM jira_issues_states WHERE issue_key = $1;`, issueKey).Scan(&project)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return project, err
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ProjectStatus is a status valid for an issue type of a project, to
+// be stored in the DB.
+type ProjectStatus struct {
+	ProjectKey string
+	IssueType  string
+	StatusID   string
+	StatusName string
+
+	// StatusCategory is the key of the status category ("new",
+	// "indeterminate" or "done").
+	StatusCategory string
+}
+
+// ProjectWorkflow is the workflow used by an issue type of a
+// project, as mapped by the project's workflow scheme, to be stored
+// in the DB.
+type ProjectWorkflow struct {
+	ProjectKey     string
+	IssueType      string
+	WorkflowScheme string
+	Workflow       string
+}
+
+// WorkflowTransition is a transition of a workflow, to be stored in
+// the DB.
+type WorkflowTransition struct {
+	Workflow     string
+	TransitionID string
+	Name         string
+
+	// FromStatus is nil for the transitions available from any
+	// status (global transitions) and for the initial transition.
+	FromStatus *string
+	ToStatus   string
+}
+
+// Workflows are the issue types, statuses and workflows of the
+// projects. See `ReplaceWorkflows`.
+type Workflows struct {
+	Statuses    []ProjectStatus
+	Workflows   []ProjectWorkflow
+	Transitions []WorkflowTransition
+}
+
+// workflowsTables are the tables created with `CreateTables` to store
+// the `Workflows`.
+//
+// Records are versioned to detect the workflow changes: a record is
+// valid from `valid_from` until `valid_to`, which is NULL for the
+// current records. E.g. to list the statuses added to or removed
+// from the projects over the last 30 days:
+//
+//	SELECT project_key, issue_type, status_name, valid_from, valid_to
+//	FROM jira_project_statuses
+//	WHERE valid_from > NOW() - INTERVAL '30 days'
+//	OR valid_to > NOW() - INTERVAL '30 days';
+var workflowsTables = []string{
+	`CREATE TABLE "jira_project_statuses" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"project_key" TEXT NOT NULL,
+		"issue_type" TEXT NOT NULL,
+		"status_id" TEXT NOT NULL,
+		"status_name" TEXT NOT NULL,
+		"status_category" TEXT NOT NULL,
+		"valid_from" TIMESTAMP NOT NULL,
+		"valid_to" TIMESTAMP
+	);`,
+	`CREATE TABLE "jira_project_workflows" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"project_key" TEXT NOT NULL,
+		"issue_type" TEXT NOT NULL,
+		"workflow_scheme" TEXT NOT NULL,
+		"workflow" TEXT NOT NULL,
+		"valid_from" TIMESTAMP NOT NULL,
+		"valid_to" TIMESTAMP
+	);`,
+	`CREATE TABLE "jira_workflow_transitions" (
+		"id" SERIAL PRIMARY KEY NOT NULL,
+		"workflow" TEXT NOT NULL,
+		"transition_id" TEXT NOT NULL,
+		"name" TEXT NOT NULL,
+		"from_status" TEXT,
+		"to_status" TEXT NOT NULL,
+		"valid_from" TIMESTAMP NOT NULL,
+		"valid_to" TIMESTAMP
+	);`,
+}
+
+// ReplaceWorkflows replaces the current records of
+// `jira_project_statuses`, `jira_project_workflows` and
+// `jira_workflow_transitions` by the passed ones, at time `at`: the
+// current records which are not passed anymore are closed
+// (`valid_to` is set to `at`) and the new ones are inserted (valid
+// from `at`). Unchanged records are kept as they are.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) ReplaceWorkflows(w Workflows, at time.Time) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	var statuses, workflows, transitions [][]sql.NullString
+	for _, st := range w.Statuses {
+		statuses = append(statuses, nullStrings(&st.ProjectKey, &st.IssueType, &st.StatusID, &st.StatusName, &st.StatusCategory))
+	}
+	for _, wf := range w.Workflows {
+		workflows = append(workflows, nullStrings(&wf.ProjectKey, &wf.IssueType, &wf.WorkflowScheme, &wf.Workflow))
+	}
+	for _, t := range w.Transitions {
+		transitions = append(transitions, nullStrings(&t.Workflow, &t.TransitionID, &t.Name, t.FromStatus, &t.ToStatus))
+	}
+	if err = replaceVersioned(tx, "jira_project_statuses", []string{"project_key", "issue_type", "status_id", "status_name", "status_category"}, statuses, at); err != nil {
+		return
+	}
+	if err = replaceVersioned(tx, "jira_project_workflows", []string{"project_key", "issue_type", "workflow_scheme", "workflow"}, workflows, at); err != nil {
+		return
+	}
+	err = replaceVersioned(tx, "jira_workflow_transitions", []string{"workflow", "transition_id", "name", "from_status", "to_status"}, transitions, at)
+	return
+}
+
+// replaceVersioned replaces the current records of a versioned table
+// (see `workflowsTables`) by the rows, at time `at`. Rows are
+// compared on all the columns.
+func replaceVersioned(tx *sql.Tx, table string, columns []string, rows [][]sql.NullString, at time.Time) error {
+	current, err := tx.Query(fmt.Sprintf(`SELECT id, %s FROM %s WHERE valid_to IS NULL;`, strings.Join(columns, ", "), table))
+	if err != nil {
+		return err
+	}
+	defer current.Close()
+
+	wanted := make(map[string][]sql.NullString)
+	for _, row := range rows {
+		wanted[versionedKey(row)] = row
+	}
+	var closed []int64
+	for current.Next() {
+		var id int64
+		row := make([]sql.NullString, len(columns))
+		dest := []interface{}{&id}
+		for i := range row {
+			dest = append(dest, &row[i])
+		}
+		if err = current.Scan(dest...); err != nil {
+			return err
+		}
+		k := versionedKey(row)
+		if _, ok := wanted[k]; ok {
+			delete(wanted, k)
+			continue
+		}
+		closed = append(closed, id)
+	}
+	if err = current.Err(); err != nil {
+		return err
+	}
+	current.Close()
+
+	if len(closed) > 0 {
+		if _, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET valid_to = $1 WHERE id = ANY($2);`, table), at, pq.Array(closed)); err != nil {
+			return err
+		}
+	}
+	query := insertQuery(table, append(columns, "valid_from"))
+	for _, row := range rows {
+		if _, ok := wanted[versionedKey(row)]; !ok {
+			continue
+		}
+		// Duplicate rows are inserted once
+		delete(wanted, versionedKey(row))
+		values := make([]interface{}, 0, len(row)+1)
+		for _, v := range row {
+			values = append(values, v)
+		}
+		if _, err = tx.Exec(query, append(values, at)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionedKey returns the key identifying a row of a versioned table
+// by the values of all its columns.
+func versionedKey(row []sql.NullString) string {
+	parts := make([]string, len(row))
+	for i, v := range row {
+		if v.Valid {
+			parts[i] = "+" + v.String
+		}
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// nullStrings returns the values as `sql.NullString`, NULL for nil.
+func nullStrings(values ...*string) []sql.NullString {
+	ns := make([]sql.NullString, len(values))
+	for i, v := range values {
+		if v != nil {
+			ns[i] = sql.NullString{String: *v, Valid: true}
+		}
+	}
+	return ns
+}
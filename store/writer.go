@@ -0,0 +1,338 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultBatchSize is the number of issues written at once by a
+// `Writer` when no batch size is set (see `SetBatchSize`).
+const DefaultBatchSize = 100
+
+// Writer replaces the records of issues in batches, which is much
+// faster than `ReplaceIssueStateAndEvents` when writing many issues
+// to a remote DB: each batch is written in a single transaction,
+// the records being deleted with one statement per table and
+// inserted with the store's insert strategy (`COPY` by default, see
+// `SetInsertStrategy`).
+//
+// Like with `ReplaceIssueStateAndEvents`, the events of each issue
+// are inserted in event-time order, and the writes of an issue by
+// concurrent writers are performed one after the other.
+//
+// Issues are added with `Add` and written when the batch is full,
+// or when `Flush` is called. If writing a batch fails, the error is
+// returned and the batch is kept, so it's retried by the next
+// `Add` or `Flush`.
+//
+// A `Writer` is safe for concurrent use.
+type Writer struct {
+	s         *PGStore
+	batchSize int
+	syncRunID int64
+
+	mutex  sync.Mutex
+	keys   []string
+	states map[string]IssueState
+	events map[string][]IssueEvent
+
+	// written are the rows of `jira_issues_events` written by the
+	// last `write`, once the quarantined ones are removed.
+	written [][]interface{}
+}
+
+// SetBatchSize sets the number of issues written at once by the
+// writers returned by `NewWriter`.
+func (s *PGStore) SetBatchSize(n int) {
+	s.batchSize = n
+}
+
+// NewWriter returns a `Writer` writing to the store in batches of
+// the size set with `SetBatchSize` (`DefaultBatchSize` if not set).
+func (s *PGStore) NewWriter() *Writer {
+	n := s.batchSize
+	if n <= 0 {
+		n = DefaultBatchSize
+	}
+	return &Writer{
+		s:         s,
+		batchSize: n,
+		states:    make(map[string]IssueState),
+		events:    make(map[string][]IssueEvent),
+	}
+}
+
+// SetSyncRun records the keys of the issues written by the writer
+// in `sync_progress` for the sync run, in the transaction of their
+// batch, so the run can be resumed (see `GetSyncedIssueKeys`).
+func (w *Writer) SetSyncRun(id int64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.syncRunID = id
+}
+
+// Add adds the records of the issue to the batch, replacing those
+// added before for the same issue, and writes the batch if it's
+// full.
+//
+// Returns an error without adding the records if the state is not
+// valid (see `IssueState.Validate`) or an event's kind is not (see
+// `EventKinds()`), or the error of writing the batch (see
+// `BatchError`).
+func (w *Writer) Add(k string, is IssueState, ies []IssueEvent) error {
+	if err := is.Validate(); err != nil {
+		return err
+	}
+	for _, ie := range ies {
+		if !ie.EventKind.IsValid() {
+			return fmt.Errorf("invalid kind `%s` for event of issue `%s`", ie.EventKind, ie.IssueKey)
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if _, ok := w.states[k]; !ok {
+		w.keys = append(w.keys, k)
+	}
+	w.states[k] = is
+	w.events[k] = sortedEvents(uniqueEvents(ies))
+	if len(w.keys) < w.batchSize {
+		return nil
+	}
+	return w.flush()
+}
+
+// Flush writes the issues added since the last successful write.
+func (w *Writer) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.flush()
+}
+
+// Pending returns the number of issues added and not written yet.
+func (w *Writer) Pending() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.keys)
+}
+
+// flush writes the batch and empties it if the write succeeded. The
+// batch is written again if it failed because the DB doesn't support
+// `COPY` (see `InsertCopy`). Must be called with the mutex held.
+func (w *Writer) flush() error {
+	if len(w.keys) == 0 {
+		return nil
+	}
+	err := w.write()
+	if err != nil && w.s.fallBackFromCopy(err) {
+		err = w.write()
+	}
+	if err != nil {
+		return &BatchError{Count: len(w.keys), Err: err}
+	}
+	for _, k := range w.keys {
+		w.s.handleEvents(writtenEvents(w.events[k], w.written, w.eventColumns()))
+	}
+	w.keys = nil
+	w.states = make(map[string]IssueState)
+	w.events = make(map[string][]IssueEvent)
+	return nil
+}
+
+// BatchError is the error returned by a `Writer` which failed to
+// write its batch. The issues of the batch are kept, to be written
+// again by the next `Add` or `Flush`.
+type BatchError struct {
+	Count int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("error writing batch of %d issues: %s", e.Count, e.Err)
+}
+
+// IsBatchError returns true if the error is a `BatchError`.
+func IsBatchError(err error) bool {
+	_, ok := err.(*BatchError)
+	return ok
+}
+
+// write writes the batch in a transaction, holding the locks of its
+// issues (see `issueLocks`). Must be called with the mutex held.
+func (w *Writer) write() (err error) {
+	defer w.s.issueLocks.lock(w.keys...)()
+	var states, links, revisions, events, vaulted [][]interface{}
+	for _, k := range w.keys {
+		is := w.states[k]
+		states = append(states, append(issueStateValues(is), customColumnValues(w.s.customColumns, is)...))
+		for _, l := range is.Links {
+			links = append(links, []interface{}{l.SourceKey, l.TargetKey, l.LinkType, l.Direction})
+		}
+		for _, r := range is.DescriptionRevisions {
+			revisions = append(revisions, descriptionRevisionValues(r))
+		}
+		for _, ie := range w.events[k] {
+			row, vaultRow, err := w.s.issueEventRow(ie, is)
+			if err != nil {
+				return err
+			}
+			events = append(events, row)
+			if vaultRow != nil {
+				vaulted = append(vaulted, vaultRow)
+			}
+		}
+	}
+	if w.s.throttle != nil {
+		w.s.throttle.Wait(len(states) + len(links) + len(revisions) + len(events))
+	}
+
+	tx, err := w.s.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	keys := pq.Array(w.keys)
+	for _, q := range []string{
+		`DELETE FROM jira_issues_events WHERE issue_key = ANY($1);`,
+		`DELETE FROM jira_issues_states WHERE issue_key = ANY($1);`,
+		`DELETE FROM jira_issue_links WHERE source_key = ANY($1);`,
+		`DELETE FROM jira_issue_description_revisions WHERE issue_key = ANY($1);`,
+	} {
+		if _, err = tx.Exec(q, keys); err != nil {
+			return
+		}
+	}
+	if err = w.s.deleteVaultedComments(tx, w.keys...); err != nil {
+		return
+	}
+	stateColumns := append(issueStateColumns, customColumnNames(w.s.customColumns)...)
+	if states, err = w.s.quarantineRows(tx, w.syncRunID, "jira_issues_states", stateColumns, "issue_key", states); err != nil {
+		return
+	}
+	eventColumns := w.eventColumns()
+	if events, err = w.s.quarantineRows(tx, w.syncRunID, "jira_issues_events", eventColumns, "dedup_key", events); err != nil {
+		return
+	}
+	w.written = events
+	if err = w.s.insertRows(tx, "jira_issues_states", stateColumns, states); err != nil {
+		return
+	}
+	if err = w.s.insertRows(tx, "jira_issue_links", []string{"source_key", "target_key", "link_type", "direction"}, links); err != nil {
+		return
+	}
+	if err = w.s.insertRows(tx, "jira_issue_description_revisions", descriptionRevisionColumns, revisions); err != nil {
+		return
+	}
+	issues := w.issues(states)
+	if err = w.s.replaceMultiValues(tx, issues...); err != nil {
+		return
+	}
+	if err = w.s.recordStateHistory(tx, issues...); err != nil {
+		return
+	}
+	if err = w.s.insertRows(tx, "jira_issues_events", eventColumns, events); err != nil {
+		return
+	}
+	if err = w.s.insertRows(tx, "jira_comment_vault", commentVaultColumns, vaultedRows(vaulted, events, eventColumns)); err != nil {
+		return
+	}
+	now := time.Now()
+	for _, k := range w.keys {
+		var deleted []IssueEvent
+		if deleted, err = w.s.replaceIssueComments(tx, w.states[k], now); err != nil {
+			return
+		}
+		if err = w.s.insertIssueEvents(tx, deleted, w.states[k]); err != nil {
+			return
+		}
+	}
+	if w.syncRunID != 0 {
+		_, err = tx.Exec(`
+		INSERT INTO sync_progress (sync_run_id, issue_key)
+		SELECT $1, UNNEST($2::TEXT[])
+		ON CONFLICT DO NOTHING;
+		`, w.syncRunID, keys)
+	}
+	return
+}
+
+// eventColumns returns the columns of the rows of
+// `jira_issues_events` written.
+func (w *Writer) eventColumns() []string {
+	return append(issueEventColumns, customColumnNames(w.s.customColumns)...)
+}
+
+// issues returns the issue states of the batch whose row is in
+// `states`, once each: all of them unless some were quarantined (see
+// `PGStore.SetQuarantine`).
+func (w *Writer) issues(states [][]interface{}) []IssueState {
+	written := make(map[string]bool, len(states))
+	ki := columnIndex(issueStateColumns, "issue_key")
+	for _, r := range states {
+		written[r[ki].(string)] = true
+	}
+	var issues []IssueState
+	for _, k := range w.keys {
+		if is := w.states[k]; written[is.Key] {
+			issues = append(issues, is)
+			delete(written, is.Key)
+		}
+	}
+	return issues
+}
+
+// vaultedRows returns the rows of `jira_comment_vault` of the events
+// in `events`, once each, dropping those of the quarantined events.
+func vaultedRows(vaulted, events [][]interface{}, columns []string) [][]interface{} {
+	if len(vaulted) == 0 {
+		return nil
+	}
+	written := make(map[string]bool, len(events))
+	ki := columnIndex(columns, "dedup_key")
+	for _, r := range events {
+		written[r[ki].(string)] = true
+	}
+	var rows [][]interface{}
+	for _, r := range vaulted {
+		if k := r[0].(string); written[k] {
+			rows = append(rows, r)
+			delete(written, k)
+		}
+	}
+	return rows
+}
+
+// copyRows inserts the rows in the table with `COPY`, within the
+// transaction (see `InsertCopy`).
+func copyRows(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(pq.CopyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if _, err = stmt.Exec(r...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	if _, err = stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
@@ -0,0 +1,249 @@
+// Package support gathers the information needed to troubleshoot an
+// installation into a support bundle (see `WriteBundle`), sanitized
+// so it can be attached to a support request.
+package support
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/buildinfo"
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Store is the interface of the store used by `WriteBundle`. It's
+// implemented by `store.PGStore`.
+type Store interface {
+	RecordedSchemaVersion() (int, error)
+	GetSyncRuns(n int) ([]store.SyncRun, error)
+}
+
+// SyncRunsCount is the number of sync runs of the bundle.
+const SyncRunsCount = 50
+
+// MaxLogSize is the size of the end of each log file kept in the
+// bundle.
+const MaxLogSize = 1 << 20
+
+// EnvVars are the environment variables of the application listed in
+// the bundle, without their values: only whether they're set.
+var EnvVars = []string{
+	"ADMIN_ADDR", "API_ADDR", "COMMENT_VAULT_KEY", "CONFIG_PATH", "DB_URL",
+	"ERROR_WEBHOOK_URL", "JIRA_CLOCK_SKEW_THRESHOLD", "JIRA_MAX_REQUESTS_PER_SECOND",
+	"JIRA_OAUTH_CLIENT_ID", "JIRA_OAUTH_CLIENT_SECRET", "JIRA_OAUTH_REFRESH_TOKEN", "JIRA_OAUTH_TOKEN_FILE",
+	"JIRA_PASSWORD", "JIRA_REQUESTS_BURST", "JIRA_TOKEN", "JIRA_USERNAME",
+	"READ_DB_URL", "REDACTION_SALT", "SENTRY_DSN", "SPOOL_PATH", "STALL_RESTART",
+	"STALL_TIMEOUT", "TEAMS_PATH", "WEBHOOK_ADDR", "WEBHOOK_SECRET",
+}
+
+// Options configures `WriteBundle`.
+type Options struct {
+	// Config is the configuration of the application, sanitized
+	// before being added (see `SanitizeConfig`).
+	Config *config.Config
+
+	// Store is the store the schema version and the sync runs are
+	// read from, nil if the DB is not reachable.
+	Store Store
+
+	// LogPaths are the log files whose end is added. The missing
+	// files are skipped.
+	LogPaths []string
+
+	// Errors are the errors which occurred while gathering the
+	// information before `WriteBundle` (e.g. connecting to the DB),
+	// added to `errors.txt`.
+	Errors []string
+
+	// Now is the time of the bundle. Defaults to the current time.
+	Now time.Time
+}
+
+// Environment describes the environment the application runs in.
+type Environment struct {
+	OS        string          `json:"os"`
+	Arch      string          `json:"arch"`
+	CPUs      int             `json:"cpus"`
+	Hostname  string          `json:"hostname"`
+	GoVersion string          `json:"go_version"`
+	Variables map[string]bool `json:"variables"`
+}
+
+// Schema describes the schema of the DB.
+type Schema struct {
+	Recorded int `json:"recorded"`
+	Expected int `json:"expected"`
+}
+
+// WriteBundle writes the support bundle, a gzipped tarball, to `w`:
+//
+//   - `build.json`: the build of the application (see `buildinfo`);
+//   - `environment.json`: the OS, the architecture and whether the
+//     environment variables of the application are set;
+//   - `config.json`: the configuration without its credentials;
+//   - `schema.json`: the version of the schema recorded in the DB and
+//     the one expected by the application;
+//   - `sync_runs.json`: the last `SyncRunsCount` sync runs;
+//   - `logs/<name>`: the end of each log file;
+//   - `errors.txt`: the information which couldn't be gathered.
+//
+// The files are redacted with `logging.Scrub`, even if the redaction
+// of the logs is disabled. The information
+// which can't be gathered (e.g. if the DB is not reachable) is
+// listed in `errors.txt` rather than failing the bundle.
+func WriteBundle(w io.Writer, o Options) error {
+	if o.Now.IsZero() {
+		o.Now = time.Now()
+	}
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	b := bundle{tw: tw, now: o.Now, errors: o.Errors}
+
+	b.addJSON("build.json", buildinfo.Get())
+	b.addJSON("environment.json", environment())
+	if o.Config != nil {
+		b.addJSON("config.json", SanitizeConfig(*o.Config))
+	}
+	if o.Store != nil {
+		if v, err := o.Store.RecordedSchemaVersion(); err != nil {
+			b.fail("schema.json", err)
+		} else {
+			b.addJSON("schema.json", Schema{Recorded: v, Expected: store.SchemaVersion})
+		}
+		if runs, err := o.Store.GetSyncRuns(SyncRunsCount); err != nil {
+			b.fail("sync_runs.json", err)
+		} else {
+			b.addJSON("sync_runs.json", runs)
+		}
+	}
+	for _, p := range o.LogPaths {
+		b.addLog(p)
+	}
+	if len(b.errors) > 0 {
+		b.add("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n"))
+	}
+	if b.err != nil {
+		return b.err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// SanitizeConfig returns the configuration without its credentials:
+// the credentials of Jira API, the salt of the redaction and the
+// secrets of the outbound webhooks.
+func SanitizeConfig(cfg config.Config) config.Config {
+	auth := &cfg.Jira.Auth
+	for _, v := range []*string{&auth.Password, &auth.Token, &auth.ClientSecret, &auth.RefreshToken} {
+		if *v != "" {
+			*v = logging.Redacted
+		}
+	}
+	if cfg.Mapping.Redaction.Salt != "" {
+		cfg.Mapping.Redaction.Salt = logging.Redacted
+	}
+	if len(cfg.OutboundWebhooks) > 0 {
+		// Copied so the secrets of the passed configuration are kept
+		webhooks := make([]config.OutboundWebhook, len(cfg.OutboundWebhooks))
+		for i, w := range cfg.OutboundWebhooks {
+			if w.Secret != "" {
+				w.Secret = logging.Redacted
+			}
+			webhooks[i] = w
+		}
+		cfg.OutboundWebhooks = webhooks
+	}
+	return cfg
+}
+
+// environment returns the environment the application runs in.
+func environment() Environment {
+	hostname, _ := os.Hostname()
+	vars := make(map[string]bool, len(EnvVars))
+	for _, v := range EnvVars {
+		_, vars[v] = os.LookupEnv(v)
+	}
+	return Environment{
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		Hostname:  hostname,
+		GoVersion: runtime.Version(),
+		Variables: vars,
+	}
+}
+
+// bundle writes the files of a support bundle, keeping the first
+// error writing the tarball.
+type bundle struct {
+	tw     *tar.Writer
+	now    time.Time
+	errors []string
+	err    error
+}
+
+// add adds the file, redacted.
+func (b *bundle) add(name string, content []byte) {
+	if b.err != nil {
+		return
+	}
+	content = []byte(logging.Scrub(string(content)))
+	hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), ModTime: b.now}
+	if b.err = b.tw.WriteHeader(hdr); b.err != nil {
+		return
+	}
+	_, b.err = b.tw.Write(content)
+}
+
+// addJSON adds the value as an indented JSON file.
+func (b *bundle) addJSON(name string, v interface{}) {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b.fail(name, err)
+		return
+	}
+	b.add(name, append(content, '\n'))
+}
+
+// addLog adds the end of the log file (see `MaxLogSize`) in `logs/`.
+func (b *bundle) addLog(path string) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		b.fail(path, err)
+		return
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Size() > MaxLogSize {
+		if _, err = f.Seek(-MaxLogSize, io.SeekEnd); err != nil {
+			b.fail(path, err)
+			return
+		}
+	}
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		b.fail(path, err)
+		return
+	}
+	b.add("logs/"+filepath.Base(path), content)
+}
+
+// fail records that the file couldn't be gathered.
+func (b *bundle) fail(name string, err error) {
+	b.errors = append(b.errors, fmt.Sprintf("%s: %s", name, err))
+}
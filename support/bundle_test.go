@@ -0,0 +1,93 @@
+package support_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/support"
+)
+
+type storeMock struct{}
+
+func (s storeMock) RecordedSchemaVersion() (int, error) {
+	return store.SchemaVersion - 1, nil
+}
+
+func (s storeMock) GetSyncRuns(n int) ([]store.SyncRun, error) {
+	return nil, errors.New("relation \"sync_runs\" does not exist")
+}
+
+func TestWriteBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "support")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "sync.log")
+	if err = ioutil.WriteFile(logPath, []byte("ERROR Could not connect to postgres://kaizen:s3cr3t@db/jira\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{}
+	cfg.Jira.Auth.Password = "hunter2"
+	cfg.Mapping.Redaction.Salt = "pepper"
+	cfg.OutboundWebhooks = []config.OutboundWebhook{{Name: "deploys", Secret: "whsecret"}}
+	var buf bytes.Buffer
+	err = support.WriteBundle(&buf, support.Options{
+		Config:   &cfg,
+		Store:    storeMock{},
+		LogPaths: []string{logPath, filepath.Join(dir, "missing.log")},
+		Now:      time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string)
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(tr)
+		files[hdr.Name] = string(b)
+	}
+
+	for _, name := range []string{"build.json", "environment.json", "config.json", "schema.json", "logs/sync.log", "errors.txt"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("expected `%s` in the bundle, got %d files", name, len(files))
+		}
+	}
+	if _, ok := files["sync_runs.json"]; ok {
+		t.Errorf("expected no `sync_runs.json` since the sync runs couldn't be read")
+	}
+	if !strings.Contains(files["errors.txt"], "sync_runs.json: relation") {
+		t.Errorf("expected the error reading the sync runs, got %q", files["errors.txt"])
+	}
+	for name, content := range files {
+		for _, secret := range []string{"hunter2", "pepper", "s3cr3t", "whsecret"} {
+			if strings.Contains(content, secret) {
+				t.Errorf("expected `%s` to be redacted from `%s`", secret, name)
+			}
+		}
+	}
+}
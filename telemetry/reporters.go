@@ -0,0 +1,128 @@
+package telemetry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// reportTimeout is the timeout of the requests sending events,
+// so a crashing run doesn't hang.
+const reportTimeout = 10 * time.Second
+
+// WebhookReporter is a `Reporter` sending each event as JSON in a
+// POST request to an URL. It's a generic hook to integrate with any
+// error-reporting or alerting service.
+type WebhookReporter struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookReporter returns a `WebhookReporter` posting to the URL.
+func NewWebhookReporter(url string) *WebhookReporter {
+	return &WebhookReporter{URL: url, Client: &http.Client{Timeout: reportTimeout}}
+}
+
+// Report implements `Reporter`.
+func (r *WebhookReporter) Report(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return post(r.Client, r.URL, b, nil)
+}
+
+// SentryReporter is a `Reporter` sending events to Sentry using its
+// HTTP store API.
+type SentryReporter struct {
+	storeURL  string
+	publicKey string
+	Client    *http.Client
+}
+
+// NewSentryReporter returns a `SentryReporter` for the Sentry DSN
+// (e.g. `https://<key>@sentry.io/<project>`).
+func NewSentryReporter(dsn string) (*SentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: missing public key")
+	}
+	i := strings.LastIndex(u.Path, "/")
+	project := u.Path[i+1:]
+	if project == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: missing project")
+	}
+	storeURL := fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:i], project)
+	return &SentryReporter{
+		storeURL:  storeURL,
+		publicKey: u.User.Username(),
+		Client:    &http.Client{Timeout: reportTimeout},
+	}, nil
+}
+
+// Report implements `Reporter`.
+func (r *SentryReporter) Report(e Event) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	breadcrumbs := make([]map[string]string, len(e.Logs))
+	for i, l := range e.Logs {
+		breadcrumbs[i] = map[string]string{"category": "log", "message": l}
+	}
+	payload := map[string]interface{}{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   e.Time.UTC().Format("2006-01-02T15:04:05"),
+		"level":       "fatal",
+		"platform":    "go",
+		"logger":      "telemetry",
+		"message":     e.Message,
+		"server_name": e.Run.Hostname,
+		"tags": map[string]string{
+			"action": e.Run.Action,
+			"kind":   string(e.Level),
+		},
+		"extra": map[string]interface{}{
+			"args":       e.Run.Args,
+			"go_version": e.Run.GoVersion,
+			"started_at": e.Run.StartedAt,
+			"stack":      e.Stack,
+		},
+		"breadcrumbs": map[string]interface{}{"values": breadcrumbs},
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=kaizenizer-source-jira/1.0, sentry_key=%s", r.publicKey)
+	return post(r.Client, r.storeURL, b, map[string]string{"X-Sentry-Auth": auth})
+}
+
+func post(c *http.Client, url string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", res.Status)
+	}
+	return nil
+}
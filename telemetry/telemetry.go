@@ -0,0 +1,212 @@
+// Package telemetry reports panics and fatal errors to an external
+// service (e.g. Sentry), with the context of the run attached, so
+// crashes of unattended runs are noticed.
+//
+// The application calls `Init` on start, `defer Recover()` in `main`
+// and `Fatalln`/`Fatalf` instead of `log.Fatalln`/`log.Fatalf`. When
+// no `Reporter` is set, these behave like their `log` counterparts.
+package telemetry
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// Level is the level of a reported `Event`.
+type Level string
+
+// The levels of the reported events.
+const (
+	LevelFatal Level = "fatal"
+	LevelPanic Level = "panic"
+)
+
+// RunContext describes the run during which an event occurred.
+type RunContext struct {
+	Action    string    `json:"action"`
+	Args      []string  `json:"args"`
+	Hostname  string    `json:"hostname"`
+	GoVersion string    `json:"go_version"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Event is a panic or fatal error to be reported.
+type Event struct {
+	Level   Level      `json:"level"`
+	Message string     `json:"message"`
+	Time    time.Time  `json:"time"`
+	Stack   string     `json:"stack"`
+	Run     RunContext `json:"run"`
+
+	// Logs are the last lines logged before the event.
+	Logs []string `json:"logs"`
+}
+
+// Reporter sends events to an external service.
+type Reporter interface {
+	Report(e Event) error
+}
+
+// MaxLogs is the number of log lines kept to be attached to the
+// reported events.
+const MaxLogs = 20
+
+var (
+	mutex    sync.Mutex
+	reporter Reporter
+	run      RunContext
+	logs     = &logBuffer{max: MaxLogs}
+	atExit   []func()
+
+	// exit is replaced in tests
+	exit = os.Exit
+)
+
+// Init sets the reporter used to report events and captures the
+// context of the run (the action is the first argument, so the flags
+// which may precede it must be removed from `args`). The output
+// of the standard logger is tee'd to keep the last lines logged.
+//
+// If `r` is nil, nothing is reported.
+func Init(r Reporter, args []string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	reporter = r
+	hostname, _ := os.Hostname()
+	run = RunContext{
+		Args:      args,
+		Hostname:  hostname,
+		GoVersion: runtime.Version(),
+		StartedAt: time.Now(),
+	}
+	if len(args) > 1 {
+		run.Action = args[1]
+	}
+	log.SetOutput(&teeWriter{logs})
+}
+
+// Recover reports the panic in progress, if any, then panics
+// again. It must be deferred, e.g. `defer telemetry.Recover()`.
+func Recover() {
+	if r := recover(); r != nil {
+		report(LevelPanic, fmt.Sprint(r))
+		panic(r)
+	}
+}
+
+// AtExit registers `f` to be called by `Fatalln` and `Fatalf` before
+// exiting, e.g. to release a lock held by the run in the DB, the
+// deferred functions not being called.
+func AtExit(f func()) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	atExit = append(atExit, f)
+}
+
+// Fatalln is equivalent to `log.Fatalln`, the error being logged
+// with `logging.Errorf`, and reports the error before exiting.
+func Fatalln(v ...interface{}) {
+	msg := strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+	logging.Errorf("%s", msg)
+	report(LevelFatal, msg)
+	runAtExit()
+	exit(1)
+}
+
+// Fatalf is equivalent to `log.Fatalf`, the error being logged with
+// `logging.Errorf`, and reports the error before exiting.
+func Fatalf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	logging.Errorf("%s", msg)
+	report(LevelFatal, msg)
+	runAtExit()
+	exit(1)
+}
+
+// runAtExit calls the functions registered with `AtExit`, the last
+// registered first.
+func runAtExit() {
+	mutex.Lock()
+	fs := atExit
+	atExit = nil
+	mutex.Unlock()
+	for i := len(fs) - 1; i >= 0; i-- {
+		fs[i]()
+	}
+}
+
+// report sends an event to the reporter, if one is set. Errors are
+// written to stderr since the application is about to crash. The
+// message, arguments and logs of the event are redacted (see
+// `logging.Redact`).
+func report(level Level, msg string) {
+	mutex.Lock()
+	r, ctx := reporter, run
+	mutex.Unlock()
+	if r == nil {
+		return
+	}
+	ctx.Args = redactAll(ctx.Args)
+	e := Event{
+		Level:   level,
+		Message: logging.Redact(msg),
+		Time:    time.Now(),
+		Stack:   string(debug.Stack()),
+		Run:     ctx,
+		Logs:    redactAll(logs.lines()),
+	}
+	if err := r.Report(e); err != nil {
+		fmt.Fprintf(os.Stderr, "error reporting %s: %s\n", level, err)
+	}
+}
+
+// redactAll returns the strings redacted with `logging.Redact`.
+func redactAll(ss []string) []string {
+	redacted := make([]string, len(ss))
+	for i, s := range ss {
+		redacted[i] = logging.Redact(s)
+	}
+	return redacted
+}
+
+// logBuffer keeps the last `max` lines written to it.
+type logBuffer struct {
+	mutex sync.Mutex
+	max   int
+	buf   []string
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.buf = append(b.buf, strings.TrimSuffix(string(p), "\n"))
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+	return len(p), nil
+}
+
+func (b *logBuffer) lines() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]string(nil), b.buf...)
+}
+
+// teeWriter writes to stderr, the default output of the standard
+// logger, and to the log buffer.
+type teeWriter struct {
+	logs *logBuffer
+}
+
+func (w *teeWriter) Write(p []byte) (int, error) {
+	w.logs.Write(p)
+	return os.Stderr.Write(p)
+}
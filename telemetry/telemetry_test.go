@@ -0,0 +1,88 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFatalln(t *testing.T) {
+	var received Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	exitCode := -1
+	exit = func(code int) { exitCode = code }
+	defer func() {
+		exit = os.Exit
+		Init(nil, nil)
+		log.SetOutput(os.Stderr)
+	}()
+
+	Init(NewWebhookReporter(srv.URL), []string{"main", "sync"})
+	log.SetOutput(&logBuffer{max: 0}) // keep test output clean
+	logs.Write([]byte("Fetched issue PJ-1\n"))
+	Fatalln("error in `sync`:", "boom")
+
+	if exitCode != 1 {
+		t.Errorf("expected exit code 1, got %d", exitCode)
+	}
+	if received.Level != LevelFatal || received.Message != "error in `sync`: boom" {
+		t.Errorf("unexpected event %+v", received)
+	}
+	if received.Run.Action != "sync" {
+		t.Errorf("expected action `sync`, got `%s`", received.Run.Action)
+	}
+	if len(received.Logs) == 0 || received.Logs[0] != "Fetched issue PJ-1" {
+		t.Errorf("expected logs to be attached, got %v", received.Logs)
+	}
+}
+
+func TestAtExit(t *testing.T) {
+	var calls []string
+	exit = func(code int) { calls = append(calls, "exit") }
+	defer func() { exit = os.Exit }()
+
+	AtExit(func() { calls = append(calls, "first") })
+	AtExit(func() { calls = append(calls, "second") })
+	Fatalf("error in `%s`", "sync")
+	if len(calls) != 3 || calls[0] != "second" || calls[1] != "first" || calls[2] != "exit" {
+		t.Errorf("expected the functions to be called in reverse order before exiting, got %v", calls)
+	}
+}
+
+func TestSentryReporter(t *testing.T) {
+	var path, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("X-Sentry-Auth")
+		ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	r, err := NewSentryReporter("http://public@" + srv.Listener.Addr().String() + "/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Report(Event{Level: LevelPanic, Message: "boom"}); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/api/42/store/" {
+		t.Errorf("expected event to be sent to the store endpoint, got `%s`", path)
+	}
+	if auth == "" {
+		t.Errorf("expected the X-Sentry-Auth header to be set")
+	}
+
+	if _, err = NewSentryReporter("https://sentry.io/42"); err == nil {
+		t.Errorf("expected an error for a DSN without public key")
+	}
+}
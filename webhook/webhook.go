@@ -0,0 +1,296 @@
+// Package webhook receives the events posted by Jira webhooks to
+// keep the store up to date in near real time.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/telemetry"
+)
+
+// Webhook events handled by the receiver
+const (
+	EventIssueCreated   = "jira:issue_created"
+	EventIssueUpdated   = "jira:issue_updated"
+	EventIssueDeleted   = "jira:issue_deleted"
+	EventCommentCreated = "comment_created"
+	EventCommentUpdated = "comment_updated"
+	EventCommentDeleted = "comment_deleted"
+)
+
+// HandledEvents are the webhook events handled by the receiver, to
+// register the webhook with (see `client.APIClient.RegisterWebhook`).
+var HandledEvents = []string{
+	EventIssueCreated,
+	EventIssueUpdated,
+	EventIssueDeleted,
+	EventCommentCreated,
+	EventCommentUpdated,
+	EventCommentDeleted,
+}
+
+// MaxEventSize is the maximum size of the body of an event, larger
+// events being rejected.
+const MaxEventSize = 10 << 20
+
+// ErrInvalidIssueKey is returned by `Receive` for an event whose
+// issue key is not a valid Jira key (e.g. `PJ-12`).
+var ErrInvalidIssueKey = errors.New("invalid issue key")
+
+// issueKey matches the valid Jira issue keys.
+var issueKey = regexp.MustCompile(`^[A-Z][A-Z0-9_]*-[0-9]+$`)
+
+// SignatureHeader is the header of the signature of the events
+// posted by a webhook registered with a secret.
+const SignatureHeader = "X-Hub-Signature"
+
+// Event is the subset of a Jira webhook delivery used by the
+// receiver.
+type Event struct {
+	WebhookEvent string `json:"webhookEvent"`
+
+	// Timestamp is the time of the event, in milliseconds since
+	// the epoch.
+	Timestamp int64  `json:"timestamp"`
+	Issue     *Issue `json:"issue"`
+}
+
+// Issue is the subset of the issue sent with a webhook event.
+type Issue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Watches *struct {
+			WatchCount int `json:"watchCount"`
+		} `json:"watches"`
+	} `json:"fields"`
+}
+
+// Time returns the time of the event, or the current time if the
+// event has no timestamp.
+func (e Event) Time() time.Time {
+	if e.Timestamp == 0 {
+		return time.Now()
+	}
+	return time.Unix(0, e.Timestamp*int64(time.Millisecond))
+}
+
+// WatchersStore is implemented by stores recording the number of
+// watchers of issues over time (e.g. `*store.PGStore`).
+type WatchersStore interface {
+	RecordWatchCount(issueKey string, t time.Time, count int) error
+}
+
+// Store is implemented by the stores the receiver writes to (e.g.
+// `*store.PGStore`, or `*store.SpoolingStore` to survive outages of
+// the DB).
+type Store interface {
+	WatchersStore
+	DeleteIssue(issueKey string) error
+}
+
+// Receiver handles the events posted by Jira webhooks. For each
+// created or updated issue, it records the issue's number of
+// watchers and synchronizes the issue. The records of deleted issues
+// are deleted once confirmed (see `SetIssueCheck`), and the issues
+// whose comments are created, updated or deleted are synchronized to
+// update their comment events.
+type Receiver struct {
+	store       Store
+	syncIssue   func(issueKey string)
+	secret      string
+	issueExists func(issueKey string) (bool, error)
+
+	// queue holds the keys of the issues to synchronize, and
+	// pending those in it (see `enqueue`).
+	queue   chan string
+	mutex   sync.Mutex
+	pending map[string]bool
+}
+
+// SyncWorkers is the number of issues a `Receiver` synchronizes
+// concurrently.
+var SyncWorkers = 4
+
+// MaxPendingSyncs is the number of issues waiting to be synchronized
+// by a `Receiver`, above which the events of other issues are not
+// synchronized (the next reconciliation catching up with them).
+var MaxPendingSyncs = 1000
+
+// NewReceiver returns a `Receiver` writing to `s` and calling
+// `syncIssue` to synchronize the issues of the events. `syncIssue`
+// is called asynchronously so Jira gets a response without waiting
+// for the sync, by `SyncWorkers` goroutines, so a burst of events
+// doesn't start as many syncs. An issue waiting to be synchronized
+// is synchronized once, whatever its number of events.
+func NewReceiver(s Store, syncIssue func(issueKey string)) *Receiver {
+	r := &Receiver{store: s, syncIssue: syncIssue}
+	if syncIssue != nil {
+		r.queue = make(chan string, MaxPendingSyncs)
+		r.pending = make(map[string]bool)
+		for i := 0; i < SyncWorkers; i++ {
+			go r.syncQueued()
+		}
+	}
+	return r
+}
+
+// enqueue queues the issue to be synchronized, unless it's already
+// waiting to be. Drops it if `MaxPendingSyncs` issues are waiting.
+func (r *Receiver) enqueue(issueKey string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.pending[issueKey] {
+		return
+	}
+	select {
+	case r.queue <- issueKey:
+		r.pending[issueKey] = true
+	default:
+		logging.Warnf("Not synchronizing `%s`: %d issues are waiting to be synchronized", issueKey, len(r.queue))
+	}
+}
+
+// syncQueued synchronizes the queued issues. An issue is not pending
+// anymore once its sync starts, so the events received during the
+// sync queue it again.
+func (r *Receiver) syncQueued() {
+	defer telemetry.Recover()
+	for issueKey := range r.queue {
+		r.mutex.Lock()
+		delete(r.pending, issueKey)
+		r.mutex.Unlock()
+		r.syncIssue(issueKey)
+	}
+}
+
+// SetSecret makes the receiver reject the events not signed with the
+// secret the webhook was registered with: their `X-Hub-Signature`
+// header must be the HMAC-SHA256 of the body with the secret, as
+// `sha256=<hex>`. Events are not checked if the secret is empty.
+func (r *Receiver) SetSecret(secret string) {
+	r.secret = secret
+}
+
+// SetIssueCheck makes the receiver check that the issue of an
+// `issue_deleted` event doesn't exist in Jira anymore before deleting
+// its records, with `issueExists` (e.g.
+// `client.APIClient.IssueExists`), so a forged event can't delete
+// the records of an existing issue. Without a check, the deletions
+// are only accepted from events signed with the secret (see
+// `SetSecret`), and refused if the receiver has none.
+func (r *Receiver) SetIssueCheck(issueExists func(issueKey string) (bool, error)) {
+	r.issueExists = issueExists
+}
+
+// verify returns true if the body is signed with the receiver's
+// secret, or if it has none.
+func (r *Receiver) verify(body []byte, signature string) bool {
+	if r.secret == "" {
+		return true
+	}
+	mac := hmac.New(sha256.New, []byte(r.secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// Handler returns an `http.Handler` receiving the webhook events
+// on `POST /webhook`.
+func (r *Receiver) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, MaxEventSize))
+		if err != nil {
+			http.Error(w, "invalid event: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !r.verify(body, req.Header.Get(SignatureHeader)) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		var e Event
+		if err := json.Unmarshal(body, &e); err != nil {
+			http.Error(w, "invalid event: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = r.Receive(e)
+		if err == ErrInvalidIssueKey {
+			http.Error(w, "invalid event: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logging.Errorf("Error in webhook receiver: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// Receive processes a webhook event. Events other than those of
+// issues and comments are ignored. Returns `ErrInvalidIssueKey`
+// without processing the event if its issue key is invalid.
+func (r *Receiver) Receive(e Event) error {
+	if e.Issue == nil {
+		return nil
+	}
+	if !issueKey.MatchString(e.Issue.Key) {
+		return ErrInvalidIssueKey
+	}
+	switch e.WebhookEvent {
+	case EventIssueCreated, EventIssueUpdated:
+		if w := e.Issue.Fields.Watches; w != nil {
+			if err := r.store.RecordWatchCount(e.Issue.Key, e.Time(), w.WatchCount); err != nil {
+				return err
+			}
+		}
+	case EventIssueDeleted:
+		return r.deleteIssue(e.Issue.Key)
+	case EventCommentCreated, EventCommentUpdated, EventCommentDeleted:
+	default:
+		return nil
+	}
+	if r.syncIssue != nil {
+		r.enqueue(e.Issue.Key)
+	}
+	return nil
+}
+
+// deleteIssue deletes the records of the issue of an `issue_deleted`
+// event, once confirmed the issue doesn't exist anymore (see
+// `SetIssueCheck`).
+func (r *Receiver) deleteIssue(issueKey string) error {
+	if r.issueExists == nil {
+		if r.secret == "" {
+			logging.Warnf("Ignored the deletion of `%s`: deletions are refused from unsigned events without an issue check", issueKey)
+			return nil
+		}
+		return r.store.DeleteIssue(issueKey)
+	}
+	exists, err := r.issueExists(issueKey)
+	if err != nil {
+		return fmt.Errorf("error checking the deletion of `%s`: %s", issueKey, err)
+	}
+	if exists {
+		logging.Warnf("Ignored the deletion of `%s`: the issue still exists in Jira", issueKey)
+		return nil
+	}
+	return r.store.DeleteIssue(issueKey)
+}
@@ -0,0 +1,272 @@
+package webhook_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/webhook"
+)
+
+type watchCount struct {
+	issueKey string
+	t        time.Time
+	count    int
+}
+
+type storeMock struct {
+	counts  []watchCount
+	deleted []string
+}
+
+func (s *storeMock) RecordWatchCount(issueKey string, t time.Time, count int) error {
+	s.counts = append(s.counts, watchCount{issueKey, t, count})
+	return nil
+}
+
+func (s *storeMock) DeleteIssue(issueKey string) error {
+	s.deleted = append(s.deleted, issueKey)
+	return nil
+}
+
+func TestReceiver_Handler(t *testing.T) {
+	s := &storeMock{}
+	synced := make(chan string, 1)
+	r := webhook.NewReceiver(s, func(issueKey string) { synced <- issueKey })
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+
+	body := `{
+		"webhookEvent": "jira:issue_updated",
+		"timestamp": 1583143200000,
+		"issue": {"key": "PJ-1", "fields": {"watches": {"watchCount": 4}}}
+	}`
+	res, err := http.Post(srv.URL+"/webhook", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+
+	if len(s.counts) != 1 {
+		t.Fatalf("expected 1 recorded watch count, got %d", len(s.counts))
+	}
+	expected := watchCount{"PJ-1", time.Date(2020, 3, 2, 10, 0, 0, 0, time.UTC), 4}
+	if c := s.counts[0]; c.issueKey != expected.issueKey || !c.t.Equal(expected.t) || c.count != expected.count {
+		t.Errorf("expected %v, got %v", expected, c)
+	}
+
+	select {
+	case k := <-synced:
+		if k != "PJ-1" {
+			t.Errorf("expected `PJ-1` to be synced, got `%s`", k)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the issue to be synced")
+	}
+
+	// Other events are ignored
+	body = `{"webhookEvent": "worklog_updated", "issue": {"key": "PJ-1"}}`
+	res, err = http.Post(srv.URL+"/webhook", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(s.counts) != 1 || len(s.deleted) != 0 {
+		t.Errorf("expected worklog event to be ignored")
+	}
+	select {
+	case k := <-synced:
+		t.Errorf("expected no issue to be synced, got `%s`", k)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReceiver_Receive(t *testing.T) {
+	for _, tc := range []struct {
+		event   string
+		synced  bool
+		deleted bool
+	}{
+		{webhook.EventIssueDeleted, false, true},
+		{webhook.EventCommentCreated, true, false},
+		{webhook.EventCommentUpdated, true, false},
+		{webhook.EventCommentDeleted, true, false},
+	} {
+		t.Run(tc.event, func(t *testing.T) {
+			s := &storeMock{}
+			synced := make(chan string, 1)
+			r := webhook.NewReceiver(s, func(issueKey string) { synced <- issueKey })
+			r.SetIssueCheck(func(issueKey string) (bool, error) { return false, nil })
+
+			if err := r.Receive(webhook.Event{WebhookEvent: tc.event, Issue: &webhook.Issue{Key: "PJ-1"}}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if deleted := len(s.deleted) == 1 && s.deleted[0] == "PJ-1"; deleted != tc.deleted {
+				t.Errorf("expected deleted to be %v, got %v", tc.deleted, s.deleted)
+			}
+			select {
+			case k := <-synced:
+				if !tc.synced || k != "PJ-1" {
+					t.Errorf("unexpected sync of `%s`", k)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tc.synced {
+					t.Errorf("expected the issue to be synced")
+				}
+			}
+		})
+	}
+}
+
+func TestReceiver_SetSecret(t *testing.T) {
+	s := &storeMock{}
+	r := webhook.NewReceiver(s, nil)
+	r.SetSecret("secret")
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+
+	body := `{"webhookEvent": "jira:issue_deleted", "issue": {"key": "PJ-1"}}`
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+	for signature, expected := range map[string]int{
+		"":         http.StatusUnauthorized,
+		"sha256=0": http.StatusUnauthorized,
+		"sha256=" + hex.EncodeToString(mac.Sum(nil)): http.StatusNoContent,
+	} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/webhook", strings.NewReader(body))
+		req.Header.Set(webhook.SignatureHeader, signature)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != expected {
+			t.Errorf("expected status %d with signature `%s`, got %d", expected, signature, res.StatusCode)
+		}
+	}
+	if len(s.deleted) != 1 {
+		t.Errorf("expected only the signed event to be processed, got %v", s.deleted)
+	}
+}
+
+func TestReceiver_SetIssueCheck(t *testing.T) {
+	e := webhook.Event{WebhookEvent: webhook.EventIssueDeleted, Issue: &webhook.Issue{Key: "PJ-1"}}
+	for _, tc := range []struct {
+		name        string
+		issueExists func(issueKey string) (bool, error)
+		deleted     bool
+		err         bool
+	}{
+		{"no check", nil, false, false},
+		{"deleted", func(string) (bool, error) { return false, nil }, true, false},
+		{"existing", func(string) (bool, error) { return true, nil }, false, false},
+		{"error", func(string) (bool, error) { return false, errors.New("unavailable") }, false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &storeMock{}
+			r := webhook.NewReceiver(s, nil)
+			if tc.issueExists != nil {
+				r.SetIssueCheck(tc.issueExists)
+			}
+			if err := r.Receive(e); (err != nil) != tc.err {
+				t.Errorf("expected error to be %v, got %v", tc.err, err)
+			}
+			if deleted := len(s.deleted) == 1; deleted != tc.deleted {
+				t.Errorf("expected deleted to be %v, got %v", tc.deleted, s.deleted)
+			}
+		})
+	}
+}
+
+func TestReceiver_Handler_maxEventSize(t *testing.T) {
+	s := &storeMock{}
+	r := webhook.NewReceiver(s, nil)
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+
+	body := `{"webhookEvent": "jira:issue_updated", "issue": {"key": "PJ-1", "fields": {"watches": {"watchCount": 4}}}, "padding": "` + strings.Repeat("x", webhook.MaxEventSize) + `"}`
+	res, err := http.Post(srv.URL+"/webhook", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+	if len(s.counts) != 0 {
+		t.Errorf("expected the event to be rejected, got %v", s.counts)
+	}
+}
+
+func TestReceiver_Receive_invalidIssueKey(t *testing.T) {
+	s := &storeMock{}
+	checked := false
+	r := webhook.NewReceiver(s, nil)
+	r.SetIssueCheck(func(string) (bool, error) {
+		checked = true
+		return false, nil
+	})
+	srv := httptest.NewServer(r.Handler())
+	defer srv.Close()
+
+	body := `{"webhookEvent": "jira:issue_deleted", "issue": {"key": "x' OR '1'='1"}}`
+	res, err := http.Post(srv.URL+"/webhook", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, res.StatusCode)
+	}
+	if checked || len(s.deleted) != 0 {
+		t.Errorf("expected the event to be rejected before checking and deleting the issue, got %v", s.deleted)
+	}
+}
+
+func TestReceiver_Receive_syncQueue(t *testing.T) {
+	started, release := make(chan string, 10), make(chan struct{})
+	defer func(workers, max int) { webhook.SyncWorkers, webhook.MaxPendingSyncs = workers, max }(webhook.SyncWorkers, webhook.MaxPendingSyncs)
+	webhook.SyncWorkers, webhook.MaxPendingSyncs = 1, 2
+	r := webhook.NewReceiver(&storeMock{}, func(issueKey string) {
+		started <- issueKey
+		<-release
+	})
+	receive := func(key string) {
+		if err := r.Receive(webhook.Event{WebhookEvent: webhook.EventIssueUpdated, Issue: &webhook.Issue{Key: key}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The worker is busy with PJ-1: PJ-2 is queued once, PJ-3 too,
+	// and PJ-4 is dropped, the queue being full
+	receive("PJ-1")
+	if k := <-started; k != "PJ-1" {
+		t.Fatalf("expected `PJ-1` to be synced, got `%s`", k)
+	}
+	for _, k := range []string{"PJ-2", "PJ-2", "PJ-3", "PJ-2", "PJ-4"} {
+		receive(k)
+	}
+	close(release)
+	var synced []string
+	for {
+		select {
+		case k := <-started:
+			synced = append(synced, k)
+			continue
+		case <-time.After(100 * time.Millisecond):
+		}
+		break
+	}
+	if strings.Join(synced, ",") != "PJ-2,PJ-3" {
+		t.Errorf("expected `PJ-2` and `PJ-3` to be synced once, got %v", synced)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/config"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/onboarding"
+	"github.com/rchampourlier/kaizenizer-source-jira/telemetry"
+)
+
+// trialSyncSize is the number of issues synced by the trial sync of
+// `init`, unless set with `--limit`.
+const trialSyncSize = 100
+
+// suggestedCoverage is the share of the sampled issues a custom
+// field must have a value in to be mapped by default by `init`.
+const suggestedCoverage = 0.2
+
+// credentialVars are the environment variables holding the
+// credentials of each type of authentication (see
+// `config.JiraAuth`), with the question asking them.
+var credentialVars = map[string][][2]string{
+	"basic": {
+		{"JIRA_USERNAME", "Username (email for Jira Cloud)"},
+		{"JIRA_PASSWORD", "Password (API token for Jira Cloud)"},
+	},
+	"pat": {
+		{"JIRA_TOKEN", "Personal access token"},
+	},
+	"oauth2": {
+		{"JIRA_OAUTH_CLIENT_ID", "Client ID of the OAuth 2.0 app"},
+		{"JIRA_OAUTH_CLIENT_SECRET", "Client secret of the OAuth 2.0 app"},
+		{"JIRA_OAUTH_REFRESH_TOKEN", "Refresh token"},
+	},
+}
+
+// runInitWizard runs the `init` wizard: it asks the URL of Jira and
+// the credentials and checks them, lets the user choose the projects
+// to sync, suggests the custom fields to map and the estimates from
+// a sample of their issues, writes the configuration file, then
+// creates the schema and runs a trial sync if the user agrees.
+//
+// The credentials are not written to the configuration file: those
+// which are not set in the environment are asked, and must be set
+// in the environment of the next commands.
+func runInitWizard() {
+	p := onboarding.NewPrompter(os.Stdin, os.Stdout)
+	path := config.Path()
+	if _, err := os.Stat(path); err == nil && !p.Confirm(fmt.Sprintf("The configuration file `%s` exists, replace it?", path), false) {
+		return
+	}
+
+	var s onboarding.Settings
+	for s.BaseURL == "" {
+		s.BaseURL = strings.TrimSuffix(p.Ask("URL of Jira (e.g. https://example.atlassian.net)", ""), "/")
+	}
+	for credentialVars[s.AuthType] == nil {
+		s.AuthType = p.Ask("Authentication (basic, pat or oauth2)", "basic")
+	}
+	var asked []string
+	for _, v := range credentialVars[s.AuthType] {
+		if os.Getenv(v[0]) != "" {
+			p.Printf("Using %s from the environment\n", v[0])
+			continue
+		}
+		os.Setenv(v[0], p.Ask(v[1], ""))
+		asked = append(asked, v[0])
+	}
+
+	c := newAPIClientFor(&config.Config{Jira: config.Jira{BaseURL: s.BaseURL, Auth: config.JiraAuth{Type: s.AuthType}}})
+	user, err := c.CurrentUser()
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `init`: %s", err))
+	}
+	p.Printf("Authenticated as %s\n\n", user)
+
+	projects, err := c.GetProjects()
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `init`: %s", err))
+	}
+	p.Printf("Projects:\n")
+	for _, pj := range projects {
+		p.Printf("  %-10s %s\n", pj.Key, pj.Name)
+	}
+	s.Projects = splitList(p.Ask("Projects to sync (comma-separated keys, empty for all)", ""))
+
+	query := jira.Filter{Projects: s.Projects}.Apply("ORDER BY updated DESC")
+	issues, err := onboarding.SampleIssues(c, query, onboarding.DefaultSampleSize)
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `init`: %s", err))
+	}
+	fields, err := c.GetFields()
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `init`: %s", err))
+	}
+	suggestions := onboarding.SuggestCustomFields(fields, issues)
+	p.Printf("\n%d custom fields are set in the last %d updated issues\n", len(suggestions), len(issues))
+	var mapped []onboarding.Suggestion
+	for _, sg := range suggestions {
+		f := sg.Field
+		question := fmt.Sprintf("Map %s (%s, set in %.0f%% of the issues, e.g. %q) to column `%s`?", f.Name, f.Type, 100*sg.Coverage, sg.Example, f.Column)
+		if p.Confirm(question, sg.Coverage >= suggestedCoverage) {
+			mapped = append(mapped, sg)
+			s.CustomFields = append(s.CustomFields, f)
+		}
+	}
+	if es := onboarding.SuggestEstimates(mapped, issues); len(es) > 0 {
+		names := make([]string, len(es))
+		for i, e := range es {
+			names[i] = e.Project
+		}
+		if p.Confirm(fmt.Sprintf("Use the story points of `%s` as the estimates of %s?", es[0].Field, strings.Join(names, ", ")), true) {
+			s.Estimates = es
+		}
+	}
+
+	b, err := s.Config()
+	if err == nil {
+		err = ioutil.WriteFile(path, b, 0644)
+	}
+	if err != nil {
+		telemetry.Fatalln(fmt.Errorf("error in `init`: %s", err))
+	}
+	p.Printf("\nWrote the configuration to `%s`\n", path)
+
+	if p.Confirm("Create the schema in the DB?", true) {
+		db := openDB()
+		defer db.Close()
+		st := newStore(db)
+		migrateUp(st)
+
+		n := trialSyncSize
+		if limit > 0 {
+			n = limit
+		}
+		if p.Confirm(fmt.Sprintf("Run a trial sync of %d issues?", n), true) {
+			recordFieldLineage(st)
+			shutdown = handleShutdown()
+			c, m := withSources(newSyncClient())
+			jira.PerformSync(shutdown, jira.NewLimitedClient(c, n), st, poolSize, m)
+		}
+	}
+
+	p.Printf("\nDone. ")
+	if len(asked) > 0 {
+		p.Printf("Set %s in the environment of the next commands, then ", strings.Join(asked, ", "))
+	}
+	p.Printf("run `go run *.go sync --full` to sync all the issues.\n")
+}